@@ -0,0 +1,95 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package check
+
+import (
+	"fmt"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// budget limits the size of a patchset. Zero values are unlimited.
+type budget struct {
+	maxPatches int
+	maxLines   int
+}
+
+// loadBudget reads the budget of the patchset from git config. Patchset
+// budgets are set with kilt-patchset.<name>.maxPatches and maxLines, which
+// default to branch.<branch>.kiltMaxPatches and kiltMaxLines, which in turn
+// default to kilt.maxPatches and kilt.maxLines.
+func loadBudget(r *repo.Repo, ps *patchset.Patchset) (budget, error) {
+	var b budget
+	var err error
+	for _, keys := range []struct {
+		v                  *int
+		kilt, branch, name string
+	}{
+		{&b.maxPatches, "kilt.maxPatches", "kiltMaxPatches", "maxPatches"},
+		{&b.maxLines, "kilt.maxLines", "kiltMaxLines", "maxLines"},
+	} {
+		if *keys.v, err = r.ConfigInt(keys.kilt, 0); err != nil {
+			return b, err
+		}
+		if *keys.v, err = r.ConfigInt(fmt.Sprintf("branch.%s.%s", r.KiltBranch(), keys.branch), *keys.v); err != nil {
+			return b, err
+		}
+		if *keys.v, err = r.ConfigInt(fmt.Sprintf("kilt-patchset.%s.%s", ps.Name(), keys.name), *keys.v); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// checkBudget checks that the patchset doesn't exceed its size budget. The
+// quarantine patchset has no budget, as its patches are yet to be assigned.
+func checkBudget(r *repo.Repo, ps *patchset.Patchset) ([]string, error) {
+	if ps.Name() == repo.QuarantinePatchset {
+		return nil, nil
+	}
+	b, err := loadBudget(r, ps)
+	if err != nil {
+		return nil, err
+	}
+	if b.maxPatches == 0 && b.maxLines == 0 {
+		return nil, nil
+	}
+	patches := append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...)
+	lines := 0
+	for _, patch := range patches {
+		stat, err := r.CommitStat(patch)
+		if err != nil {
+			return nil, err
+		}
+		lines += stat.Insertions + stat.Deletions
+	}
+	return b.problems(len(patches), lines), nil
+}
+
+// problems describes how a patchset of the given number of patches and changed
+// lines exceeds the budget.
+func (b budget) problems(patches, lines int) []string {
+	var problems []string
+	if b.maxPatches > 0 && patches > b.maxPatches {
+		problems = append(problems, fmt.Sprintf("%d patches exceeds budget of %d patches", patches, b.maxPatches))
+	}
+	if b.maxLines > 0 && lines > b.maxLines {
+		problems = append(problems, fmt.Sprintf("%d changed lines exceeds budget of %d lines", lines, b.maxLines))
+	}
+	return problems
+}
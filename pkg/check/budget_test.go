@@ -0,0 +1,104 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package check
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBudgetProblems(t *testing.T) {
+	tests := []struct {
+		desc           string
+		budget         budget
+		patches, lines int
+		want           []string
+	}{
+		{
+			desc:    "Unlimited",
+			budget:  budget{},
+			patches: 100,
+			lines:   10000,
+		},
+		{
+			desc:    "At the limits",
+			budget:  budget{maxPatches: 3, maxLines: 50},
+			patches: 3,
+			lines:   50,
+		},
+		{
+			desc:    "Too many patches",
+			budget:  budget{maxPatches: 3, maxLines: 50},
+			patches: 4,
+			lines:   50,
+			want:    []string{"4 patches exceeds budget of 3 patches"},
+		},
+		{
+			desc:    "Too many lines",
+			budget:  budget{maxPatches: 3, maxLines: 50},
+			patches: 1,
+			lines:   51,
+			want:    []string{"51 changed lines exceeds budget of 50 lines"},
+		},
+		{
+			desc:    "Only lines limited",
+			budget:  budget{maxLines: 50},
+			patches: 20,
+			lines:   60,
+			want:    []string{"60 changed lines exceeds budget of 50 lines"},
+		},
+		{
+			desc:    "Both exceeded",
+			budget:  budget{maxPatches: 1, maxLines: 10},
+			patches: 2,
+			lines:   11,
+			want:    []string{"2 patches exceeds budget of 1 patches", "11 changed lines exceeds budget of 10 lines"},
+		},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.budget.problems(tt.patches, tt.lines), tt.want); diff != "" {
+			t.Errorf("%s: problems() returned diff (-got +want):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestOverdue(t *testing.T) {
+	day := 24 * time.Hour
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc     string
+		since    time.Time
+		maxAge   time.Duration
+		want     bool
+		wantDays int
+	}{
+		{"Just quarantined", now, 7 * day, false, 0},
+		{"Exactly at the limit", now.Add(-7 * day), 7 * day, false, 7},
+		{"Past the limit", now.Add(-7*day - time.Minute), 7 * day, true, 7},
+		{"Partial days round down", now.Add(-10*day - 23*time.Hour), 7 * day, true, 10},
+	}
+	for _, tt := range tests {
+		if got := overdue(tt.since, now, tt.maxAge); got != tt.want {
+			t.Errorf("%s: overdue() = %t, want %t", tt.desc, got, tt.want)
+		}
+		if got := days(now.Sub(tt.since)); got != tt.wantDays {
+			t.Errorf("%s: days() = %d, want %d", tt.desc, got, tt.wantDays)
+		}
+	}
+}
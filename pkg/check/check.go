@@ -0,0 +1,69 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package check implements checks of the patchsets in a kilt branch.
+package check
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// ErrFailed indicates that one or more checks failed.
+var ErrFailed = errors.New("checks failed")
+
+// checker checks a patchset, returning a description of each problem found.
+type checker func(r *repo.Repo, ps *patchset.Patchset) ([]string, error)
+
+var checkers = []checker{
+	checkBudget,
+	checkQuarantine,
+	checkSubjectPrefix,
+}
+
+// Run will run all checks on the patchsets of the kilt branch, printing the
+// problems found and returning ErrFailed if there are any.
+func Run() error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, ps := range patchsets {
+		for _, check := range checkers {
+			problems, err := check(r, ps)
+			if err != nil {
+				return err
+			}
+			for _, p := range problems {
+				fmt.Printf("Patchset %q: %s\n", ps.Name(), p)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return ErrFailed
+	}
+	fmt.Println("All checks passed.")
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package check
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// checkQuarantine checks that no patch has been quarantined for longer than
+// the configured maximum age.
+func checkQuarantine(r *repo.Repo, ps *patchset.Patchset) ([]string, error) {
+	if ps.Name() != repo.QuarantinePatchset {
+		return nil, nil
+	}
+	maxAge, err := r.QuarantineMaxAge()
+	if err != nil || maxAge == 0 {
+		return nil, err
+	}
+	patches := append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...)
+	since, err := r.QuarantinedSince(patches)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var problems []string
+	for _, patch := range patches {
+		t, ok := since[patch]
+		if !ok || !overdue(t, now, maxAge) {
+			continue
+		}
+		desc, err := r.DescribeCommit(patch)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, fmt.Sprintf("patch %s quarantined for %d days, longer than the limit of %d days", desc, days(now.Sub(t)), days(maxAge)))
+	}
+	return problems, nil
+}
+
+// overdue reports whether a patch quarantined since t has been quarantined for
+// longer than maxAge by now.
+func overdue(t, now time.Time, maxAge time.Duration) bool {
+	return now.Sub(t) > maxAge
+}
+
+// days returns the number of whole days in d.
+func days(d time.Duration) int {
+	return int(d / (24 * time.Hour))
+}
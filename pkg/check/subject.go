@@ -0,0 +1,54 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package check
+
+import (
+	"fmt"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// checkSubjectPrefix checks that the subjects of the patches of the patchset
+// start with its configured subject prefix, if it has one. The quarantine
+// patchset is skipped, as its patches are yet to be assigned.
+func checkSubjectPrefix(r *repo.Repo, ps *patchset.Patchset) ([]string, error) {
+	if ps.Name() == repo.QuarantinePatchset {
+		return nil, nil
+	}
+	prefix, err := r.SubjectPrefix(ps)
+	if err != nil || prefix == "" {
+		return nil, err
+	}
+	var problems []string
+	patches := append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...)
+	for _, patch := range patches {
+		info, err := r.CommitInfo(patch)
+		if err != nil {
+			return nil, err
+		}
+		if repo.HasSubjectPrefix(info.Summary, prefix) {
+			continue
+		}
+		desc, err := r.DescribeCommit(patch)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, fmt.Sprintf("patch %s subject lacks prefix %q; run kilt fix-subjects", desc, prefix))
+	}
+	return problems, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/verify"
+)
+
+var annotateBuildCmd = &cobra.Command{
+	Use:   "annotate-build <branch>",
+	Short: "Report the patchsets contained in a built branch",
+	Long: `Report the patchsets and versions contained in a branch produced by kilt build,
+and how rebuilding it from the current kilt branch would change it.
+
+The built patchsets are reconstructed from the kilt metadata carried in the
+branch, and matched to the patchsets of the kilt branch by UUID, so renamed
+patchsets are followed. Patchsets that have since been updated, removed, or
+changed without a version update are reported, as are dependencies of the
+built patchsets which a rebuild would add.
+
+The built branch is read from the patches between --base and branch, where
+--base defaults to the merge base of branch and the kilt base.`,
+	Args: argsAnnotateBuild,
+	Run:  runAnnotateBuild,
+}
+
+var annotateBuildFlags = struct {
+	base string
+}{}
+
+func init() {
+	rootCmd.AddCommand(annotateBuildCmd)
+	annotateBuildCmd.Flags().StringVar(&annotateBuildFlags.base, "base", "", "base the branch was built on")
+}
+
+func argsAnnotateBuild(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one branch is required")
+	}
+	return nil
+}
+
+func runAnnotateBuild(cmd *cobra.Command, args []string) {
+	lines, changes, err := verify.AnnotateBuild(args[0], annotateBuildFlags.base)
+	if err != nil {
+		log.Exitf("Annotate failed: %v", err)
+	}
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	if changes > 0 {
+		log.Exitf("Rebuilding %s would change %d patchsets", args[0], changes)
+	}
+	fmt.Printf("Build %s is up to date\n", args[0])
+}
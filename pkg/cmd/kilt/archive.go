@@ -0,0 +1,103 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive <patchset>",
+	Short: "Archive a patchset",
+	Long: `Archive a patchset that is kept on the kilt branch but no longer worked on,
+by setting the Archived field of its metadata to true, through a rework which
+creates a metadata commit with a new version. With --undo, the field is removed
+instead.
+
+Archived patchsets are left out of kilt rework --all, and of kilt list unless
+--archived is given. They can't be given new patches with kilt float or kilt
+move, and kilt status warns about any floating patches found for them. The
+archived selector term selects them explicitly, as in kilt rework -p archived.
+
+A patchset that other patchsets depend on is only archived with --cascade, which
+either removes the dependencies on it with --cascade=edges, or archives the
+dependent patchsets as well with --cascade=dependents. The affected patchsets
+are listed for confirmation first, unless --yes is given.`,
+	Args: argsArchive,
+	Run:  runArchive,
+}
+
+var archiveFlags = struct {
+	undo    bool
+	cascade string
+	yes     bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(archiveCmd)
+	archiveCmd.Flags().BoolVar(&archiveFlags.undo, "undo", false, "unarchive the patchset")
+	archiveCmd.Flags().StringVar(&archiveFlags.cascade, "cascade", "", "handle patchsets depending on the archived patchset: edges or dependents")
+	archiveCmd.Flags().Lookup("cascade").NoOptDefVal = rework.CascadeEdges
+	archiveCmd.Flags().BoolVarP(&archiveFlags.yes, "yes", "y", false, "don't ask for confirmation with --cascade")
+}
+
+func argsArchive(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one patchset name is required")
+	}
+	switch archiveFlags.cascade {
+	case "", rework.CascadeEdges, rework.CascadeDependents:
+	default:
+		return fmt.Errorf("unknown --cascade mode %q", archiveFlags.cascade)
+	}
+	if archiveFlags.undo && archiveFlags.cascade != "" {
+		return errors.New("--cascade can't be used with --undo")
+	}
+	return nil
+}
+
+func runArchive(cmd *cobra.Command, args []string) {
+	if archiveFlags.cascade != "" && !archiveFlags.yes {
+		dependents, err := rework.Dependents(args[0])
+		if err != nil {
+			log.Exitf("Archive failed: %v", err)
+		}
+		if len(dependents) > 0 && !confirmCascade("archive", args[0], archiveFlags.cascade, dependents) {
+			log.Exit("Archive cancelled")
+		}
+	}
+	c, err := rework.NewArchiveCommand(args[0], !archiveFlags.undo, archiveFlags.cascade)
+	if errors.Is(err, rework.ErrNoChanges) {
+		fmt.Println("Patchset unchanged.")
+		return
+	}
+	if err != nil {
+		log.Exitf("Archive failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Archive failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/verify"
+)
+
+var baseCmd = &cobra.Command{
+	Use:   "base",
+	Short: "Inspect the kilt base of the branch",
+	Long: `Inspect the kilt base of the branch, the commit that the patch stack is
+applied on top of.
+
+Walks between the base and the branch stop with an error after kilt.maxCommits
+commits (10000 by default), and patches whose changed files add up to more
+than kilt.maxPatchSize bytes (64MiB by default) aren't rendered, as both
+usually mean the base is wrong. Setting either option to 0 removes the limit.`,
+}
+
+var baseVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Sanity-check the kilt base of the branch",
+	Long: `Check that the kilt base is an ancestor of the branch, that the branch is
+within kilt.maxCommits commits of it, and that the patch stack starts right
+after it with a metadata commit. The exit status is non-zero if the base looks
+misconfigured.`,
+	Args: argsBase,
+	Run:  runBaseVerify,
+}
+
+func init() {
+	rootCmd.AddCommand(baseCmd)
+	baseCmd.AddCommand(baseVerifyCmd)
+}
+
+func argsBase(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func runBaseVerify(cmd *cobra.Command, args []string) {
+	if err := verify.Base(); err != nil {
+		log.Exitf("Base verify failed: %v", err)
+	}
+}
@@ -28,23 +28,67 @@ import (
 var buildCmd = &cobra.Command{
 	Use:   "build",
 	Short: "build a new tree using the specified patchsets.",
-	Long:  `build a new tree using the specified patchsets.`,
-	Args:  argsbuild,
-	Run:   runbuild,
+	Long: `build a new tree using the specified patchsets.
+
+Patchsets are selected with --patchset, or by tag with --tag, which selects the
+patchsets whose Tags metadata field lists the tag, ignoring case.
+
+--patchset takes a patchset name or a selector expression, combining terms with
+&&, ||, ! and parentheses. The terms are all, floating, a patchset name,
+name=<name>, name~<regexp>, tag:<tag> and depends(<patchset>), which selects
+the patchsets depending on the given one. For example:
+
+  -p 'name~^net-'
+  -p 'depends(base)'
+  -p 'floating && !tag:frozen'
+
+Commas separate several selectors, which select the patchsets matching any of
+them, so expressions can't contain commas.
+
+With --up-to, the last patchset of the build is only applied up to and
+including the given patch, for debugging or bisecting within a patchset.
+
+Patchsets are applied in dependency order, so each comes after the patchsets it
+depends on. If the branch order disagrees, because a patchset precedes one it
+depends on, the build stops with an error rather than applying a stale order;
+reorder the branch, or use --order=branch to apply the patchsets in branch order
+regardless.
+
+The selected patchsets are built along with the patchsets they depend on. A
+patchset that is only needed through a dependency on one of its patches, added
+with kilt add-dep --on, is only applied up to that patch.
+
+The build queue is saved with the kilt branch, so a build stopped by conflicts
+can be resumed: resolve them and use --continue, use --skip to skip the failed
+operation, discarding its changes, or use --abort to give up on the build.
+
+Patchsets with a test command, set as described by kilt help rework, are tested
+once applied, and the build stops if the test fails.
+
+With --worktree, the build is done in a new linked git work tree at the given
+path instead of detaching HEAD in the current work tree, which is left
+untouched. If the build stops, run kilt build --continue or --abort from the new
+work tree. While the build is in progress, the kilt branch reports a rework in
+progress; read commands can use --branch-view to see the branch itself.`,
+	Args: argsbuild,
+	Run:  runbuild,
 }
 
 var buildFlags = struct {
 	begin     bool
-	finish    bool
-	validate  bool
 	rContinue bool
 	abort     bool
 	skip      bool
-	force     bool
-	auto      bool
 	patchsets []string
+	tags      []string
 	all       bool
 	base      string
+	squash    bool
+	upTo      string
+	worktree  string
+	order     string
+	notifyCmd string
+	notifyURL string
 }{}
 
 func init() {
@@ -53,15 +97,23 @@ func init() {
 	buildCmd.Flags().MarkHidden("begin")
 	buildCmd.Flags().BoolVar(&buildFlags.abort, "abort", false, "abort rework")
 	buildCmd.Flags().BoolVar(&buildFlags.rContinue, "continue", false, "continue rework")
-	buildCmd.Flags().StringSliceVarP(&buildFlags.patchsets, "patchset", "p", nil, "specify individual patchset for rework")
+	buildCmd.Flags().BoolVar(&buildFlags.skip, "skip", false, "skip the failed operation and continue the build")
+	buildCmd.Flags().StringSliceVarP(&buildFlags.patchsets, "patchset", "p", nil, "specify a patchset or selector expression for rework")
+	buildCmd.Flags().StringSliceVar(&buildFlags.tags, "tag", nil, "specify the patchsets with a tag to build")
 	buildCmd.Flags().StringVarP(&buildFlags.base, "base", "b", "", "specify base")
+	buildCmd.Flags().StringVar(&buildFlags.notifyCmd, "notify-command", "", "shell command to run when the build completes or stops")
+	buildCmd.Flags().StringVar(&buildFlags.notifyURL, "notify-url", "", "webhook URL to post to when the build completes or stops")
+	buildCmd.Flags().BoolVar(&buildFlags.squash, "squash", false, "apply each patchset as a single squashed commit")
+	buildCmd.Flags().StringVar(&buildFlags.upTo, "up-to", "", "only apply the last patchset up to and including this patch")
+	buildCmd.Flags().StringVar(&buildFlags.worktree, "worktree", "", "build in a new linked work tree at this path")
+	buildCmd.Flags().StringVar(&buildFlags.order, "order", rework.OrderTopological, "order to apply the patchsets in: topo or branch")
 }
 
 func argsbuild(cmd *cobra.Command, args []string) error {
-	if buildFlags.abort || buildFlags.rContinue {
+	if buildFlags.abort || buildFlags.rContinue || buildFlags.skip {
 		return nil
 	}
-	if len(buildFlags.patchsets) == 0 {
+	if len(buildFlags.patchsets) == 0 && len(buildFlags.tags) == 0 {
 		return errors.New("Must specify at least one patchset")
 	}
 	if buildFlags.base == "" {
@@ -74,9 +126,6 @@ func runbuild(cmd *cobra.Command, args []string) {
 	var c *rework.Command
 	var err error
 	switch {
-	case buildFlags.finish:
-		buildFlags.auto = true
-		c, err = rework.NewFinishCommand(buildFlags.force)
 	case buildFlags.abort:
 		c, err = rework.NewAbortCommand()
 	case buildFlags.skip:
@@ -86,9 +135,22 @@ func runbuild(cmd *cobra.Command, args []string) {
 	case buildFlags.begin:
 		var targets []rework.TargetSelector
 		for _, p := range buildFlags.patchsets {
-			targets = append(targets, rework.PatchsetTarget{Name: p})
+			t, pErr := rework.ParseTarget(p)
+			if pErr != nil {
+				log.Exitf("Rework failed: %v", pErr)
+			}
+			targets = append(targets, t)
+		}
+		for _, t := range buildFlags.tags {
+			targets = append(targets, rework.TagTarget{Tag: t})
 		}
-		c, err = rework.NewBeginBuildCommand(buildFlags.base, targets...)
+		c, err = rework.NewBeginBuildCommandWithOptions(rework.BuildOptions{
+			Base:     buildFlags.base,
+			Squash:   buildFlags.squash,
+			UpTo:     buildFlags.upTo,
+			Worktree: buildFlags.worktree,
+			Order:    buildFlags.order,
+		}, targets...)
 	default:
 		log.Exitf("No operation specified")
 	}
@@ -96,6 +158,9 @@ func runbuild(cmd *cobra.Command, args []string) {
 		log.Exitf("Rework failed: %v", err)
 	}
 	err = c.ExecuteAll()
+	if nErr := c.Notify("build", buildFlags.notifyCmd, buildFlags.notifyURL, err); nErr != nil {
+		log.Warningf("Failed to send notification: %v", nErr)
+	}
 	if err != nil {
 		log.Exitf("Rework failed: %v", err)
 	}
@@ -0,0 +1,68 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var bumpCmd = &cobra.Command{
+	Use:   "bump <patchset>...",
+	Short: "Bump the versions of patchsets",
+	Long: `Bump the versions of the patchsets. The branch is rewritten through a rework
+of the patchsets, which updates their metadata commits.
+
+The kilt.versionPolicy config option controls when reworks bump versions by
+themselves: always, the default, bumps the version of every reworked patchset;
+on-change only bumps the versions of patchsets whose patches change, by gaining
+floating patches or losing patches, rather than only being rebased; and manual
+leaves bumping versions to kilt bump.
+
+If the rework stops due to conflicts, resolve them and use kilt rework
+--continue to complete it.`,
+	Args: argsBump,
+	Run:  runBump,
+}
+
+func init() {
+	rootCmd.AddCommand(bumpCmd)
+}
+
+func argsBump(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return errors.New("at least one patchset name is required")
+	}
+	return nil
+}
+
+func runBump(cmd *cobra.Command, args []string) {
+	c, err := rework.NewBumpCommand(args)
+	if err != nil {
+		log.Exitf("Bump failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Bump failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/check"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the patchsets of the kilt branch",
+	Long: `Check the patchsets of the kilt branch, failing if any problems are found.
+
+Patchsets are checked against their size budgets, which limit the number of
+patches and the number of changed lines in a patchset. Budgets are configured
+with git config, and are unlimited by default:
+
+  kilt-patchset.<name>.maxPatches, kilt-patchset.<name>.maxLines
+    budget of a single patchset
+  branch.<branch>.kiltMaxPatches, branch.<branch>.kiltMaxLines
+    default budget of the patchsets on a kilt branch
+  kilt.maxPatches, kilt.maxLines
+    default budget of all patchsets
+
+The quarantine patchset has no budget. Instead, the check fails if a patch has
+been quarantined for longer than kilt.quarantineMaxAge days, 14 by default. Set
+it to 0 to let patches stay quarantined indefinitely.
+
+The subjects of patches are checked against the subject prefix of their
+patchset, such as CHROMIUM: or BACKPORT:, which kilt fix-subjects adds where it
+is missing. Prefixes are unset by default, and are configured with:
+
+  the Subject-Prefix field of a patchset, or
+  kilt-patchset.<name>.subjectPrefix
+    prefix of a single patchset
+  kilt-tag.<tag>.subjectPrefix
+    prefix of the patchsets with the tag, the first of their tags that has one
+  kilt.subjectPrefix
+    default prefix of all patchsets`,
+	Args: argsCheck,
+	Run:  runCheck,
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
+
+func argsCheck(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func runCheck(cmd *cobra.Command, args []string) {
+	if err := check.Run(); err != nil {
+		log.Exitf("Check failed: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/verify"
+)
+
+var compareCmd = &cobra.Command{
+	Use:   "compare <other-branch>",
+	Short: "Report patchset version skew against another kilt branch",
+	Long: `Compare the patchset versions of the current kilt branch with those of another
+kilt branch, such as a diverging copy of the patch stack maintained by another
+team.
+
+Patchsets are matched by the UUID in their metadata commits, so renamed
+patchsets are followed. Patchsets added or removed relative to the other
+branch, patchsets ahead or behind it in version, and patchsets renamed without
+a version update are reported. Each is printed, or with --json a report is
+printed for tools. The exit status is non-zero if the branches differ.
+
+Unlike kilt verify --compare, patch content isn't compared; only the versions
+recorded in the metadata commits are.`,
+	Args: argsCompare,
+	Run:  runCompare,
+}
+
+var compareFlags = struct {
+	json bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+	compareCmd.Flags().BoolVar(&compareFlags.json, "json", false, "print the version skew report as JSON")
+}
+
+func argsCompare(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one branch is required")
+	}
+	return nil
+}
+
+func runCompare(cmd *cobra.Command, args []string) {
+	if err := verify.CompareVersions(args[0], compareFlags.json); err != nil {
+		log.Exitf("Compare failed: %v", err)
+	}
+}
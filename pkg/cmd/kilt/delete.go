@@ -0,0 +1,114 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete <patchset>",
+	Short: "Delete a patchset",
+	Long: `Delete a patchset from the kilt branch. The branch is rewritten through a
+rework which drops the metadata commit of the patchset. The patches of the
+patchset are dropped as well, unless --rehome is used to reassign them to a
+preceding patchset, where they follow its own patches.
+
+A patchset that other patchsets depend on is only deleted with --cascade, which
+either removes the dependencies on it with --cascade=edges, or deletes the
+dependent patchsets as well with --cascade=dependents. The affected patchsets
+are listed for confirmation first, unless --yes is given.
+
+If the rework stops due to conflicts, resolve them and use kilt rework
+--continue to complete the deletion.`,
+	Args: argsDelete,
+	Run:  runDelete,
+}
+
+var deleteFlags = struct {
+	rehome  string
+	cascade string
+	yes     bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+	deleteCmd.Flags().StringVar(&deleteFlags.rehome, "rehome", "", "reassign the patches of the deleted patchset to this patchset")
+	deleteCmd.Flags().StringVar(&deleteFlags.cascade, "cascade", "", "handle patchsets depending on the deleted patchset: edges or dependents")
+	deleteCmd.Flags().Lookup("cascade").NoOptDefVal = rework.CascadeEdges
+	deleteCmd.Flags().BoolVarP(&deleteFlags.yes, "yes", "y", false, "don't ask for confirmation with --cascade")
+}
+
+func argsDelete(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one patchset name is required")
+	}
+	switch deleteFlags.cascade {
+	case "", rework.CascadeEdges, rework.CascadeDependents:
+	default:
+		return fmt.Errorf("unknown --cascade mode %q", deleteFlags.cascade)
+	}
+	return nil
+}
+
+func runDelete(cmd *cobra.Command, args []string) {
+	if deleteFlags.cascade != "" && !deleteFlags.yes {
+		dependents, err := rework.Dependents(args[0])
+		if err != nil {
+			log.Exitf("Delete failed: %v", err)
+		}
+		if len(dependents) > 0 && !confirmCascade("delete", args[0], deleteFlags.cascade, dependents) {
+			log.Exit("Delete cancelled")
+		}
+	}
+	c, err := rework.NewDeleteCommand(args[0], deleteFlags.rehome, deleteFlags.cascade)
+	if err != nil {
+		log.Exitf("Delete failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Delete failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
+
+// confirmCascade asks for confirmation to cascade the command, such as
+// "delete", on the patchset to its dependents, as set by the cascade mode.
+func confirmCascade(command, name, cascade string, dependents []string) bool {
+	action := "remove their dependencies on it"
+	if cascade == rework.CascadeDependents {
+		action = command + " them as well"
+	}
+	fmt.Printf("Patchsets depending on %s:\n", name)
+	for _, d := range dependents {
+		fmt.Printf("\t%s\n", d)
+	}
+	fmt.Printf("%s%s %s and %s? [y/N] ", strings.ToUpper(command[:1]), command[1:], name, action)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
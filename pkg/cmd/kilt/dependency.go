@@ -17,9 +17,8 @@ limitations under the License.
 package kilt
 
 import (
-	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
 
 	log "github.com/golang/glog"
 
@@ -34,7 +33,18 @@ var addDepCmd = &cobra.Command{
 	Use:   "add-dep <patchset> <p1> [p2...]",
 	Short: "Add a dependency to a patchset",
 	Long: `Add one or more dependencies to a patchset. Pass in multiple patchset names to
-include multiple dependencies.`,
+include multiple dependencies.
+
+Dependencies are stored in the repo under refs/kilt/<branch>/deps, with each
+change recorded as a commit. A dependencies.json file left in the work tree by
+earlier versions of kilt is read if no dependencies are stored yet.
+
+With --on, a single dependency is added on a patch of the dependency rather
+than the whole patchset, and with --patch, for a single patch of the patchset
+rather than all of them. Builds then only include the dependency up to the
+patch depended on, when nothing else needs the rest of it. Patches are given as
+revisions, and recorded by their subjects, so that the dependency is kept when
+the patchsets are reworked.`,
 	Args: argsDep,
 	Run:  runAdd,
 }
@@ -43,33 +53,113 @@ var rmDepCmd = &cobra.Command{
 	Use:   "rm-dep <patchset> <p1> [p2...]",
 	Short: "Remove a dependency from a patchset",
 	Long: `Remove one or more dependencies to a patchset. Pass in multiple patchset names to
-include multiple dependencies.`,
+include multiple dependencies. With --on and --patch, a dependency on a patch
+added with the same options is removed.`,
 	Args: argsDep,
 	Run:  runRm,
 }
 
-var dependencyFile = "dependencies.json"
+var depFlags = struct {
+	on    string
+	patch string
+}{}
 
 func init() {
 	rootCmd.AddCommand(addDepCmd)
 	rootCmd.AddCommand(rmDepCmd)
+	for _, c := range []*cobra.Command{addDepCmd, rmDepCmd} {
+		c.Flags().StringVar(&depFlags.on, "on", "", "depend on this patch of the dependency rather than all of it")
+		c.Flags().StringVar(&depFlags.patch, "patch", "", "with --on, make only this patch of the patchset depend on it")
+	}
 }
 
 func argsDep(cmd *cobra.Command, args []string) error {
 	if len(args) < 2 {
 		return errors.New("Patchset name and at least one dependency required")
 	}
+	if depFlags.on != "" && len(args) != 2 {
+		return errors.New("exactly one dependency required with --on")
+	}
+	if depFlags.patch != "" && depFlags.on == "" {
+		return errors.New("--patch requires --on")
+	}
 	return nil
 }
 
 func runAdd(cmd *cobra.Command, args []string) {
+	if depFlags.on != "" {
+		runPatchDep((*dependency.StructGraph).AddPatch, args)
+		return
+	}
 	runDep(dependency.Graph.Add, cmd, args)
 }
 
 func runRm(cmd *cobra.Command, args []string) {
+	if depFlags.on != "" {
+		runPatchDep((*dependency.StructGraph).RemovePatch, args)
+		return
+	}
 	runDep(dependency.Graph.Remove, cmd, args)
 }
 
+func runPatchDep(op func(d *dependency.StructGraph, ps *patchset.Patchset, patch string, dep *patchset.Patchset, on string) error, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Init failed: %s", err)
+	}
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		log.Exitf("Error loading patchsets: %v", err)
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		log.Exitf("Error loading dependencies: %v", err)
+	}
+	ps, ok := patchsets.Map[args[0]]
+	if !ok || ps == nil {
+		log.Exitf("Patchset %q not found", args[0])
+	}
+	dep, ok := patchsets.Map[args[1]]
+	if !ok || dep == nil {
+		log.Exitf("Patchset %q not found", args[1])
+	}
+	on, err := patchSubject(r, dep, depFlags.on)
+	if err != nil {
+		log.Exitf("Error finding patch: %v", err)
+	}
+	var patch string
+	if depFlags.patch != "" {
+		if patch, err = patchSubject(r, ps, depFlags.patch); err != nil {
+			log.Exitf("Error finding patch: %v", err)
+		}
+	}
+	if err = op(deps, ps, patch, dep, on); err != nil {
+		log.Exitf("Operation failed: %v", err)
+	}
+	if err = dependency.Save(r, deps); err != nil {
+		log.Exitf("Failed to save dependencies: %v", err)
+	}
+}
+
+// patchSubject returns the subject of the patch rev, which must belong to the
+// patchset.
+func patchSubject(r *repo.Repo, ps *patchset.Patchset, rev string) (string, error) {
+	id, err := r.ResolveCommit(rev)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range ps.Patches() {
+		if p == id {
+			info, err := r.CommitInfo(id)
+			if err != nil {
+				return "", err
+			}
+			return info.Summary, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not a patch of patchset %s", rev, ps.Name())
+}
+
 func runDep(op func(d dependency.Graph, ps, dep *patchset.Patchset) error, cmd *cobra.Command, args []string) {
 	repo, err := repo.Open()
 	if err != nil {
@@ -79,13 +169,9 @@ func runDep(op func(d dependency.Graph, ps, dep *patchset.Patchset) error, cmd *
 	if err != nil {
 		log.Exitf("Error loading patchsets: %v", err)
 	}
-	deps := dependency.NewStruct(patchsets)
-	b, err := ioutil.ReadFile(dependencyFile)
-	if err == nil {
-		err = json.Unmarshal(b, deps)
-		if err != nil {
-			log.Exitf("Failed to load %q: %v", dependencyFile, err)
-		}
+	deps, err := dependency.Load(repo)
+	if err != nil {
+		log.Exitf("Error loading dependencies: %v", err)
 	}
 	ps, ok := patchsets.Map[args[0]]
 	if !ok {
@@ -109,13 +195,7 @@ func runDep(op func(d dependency.Graph, ps, dep *patchset.Patchset) error, cmd *
 	if err = deps.Validate(); err != nil {
 		log.Exitf("Invalid graph: %v", err)
 	}
-	b, err = json.MarshalIndent(deps, "", "  ")
-	if err != nil {
-		log.Exitf("Failed to marshal dependencies: %v", err)
-	}
-	b = append(b, "\n"...)
-	err = ioutil.WriteFile(dependencyFile, b, 0666)
-	if err != nil {
-		log.Exitf("Failed to write file %q: %v", dependencyFile, err)
+	if err = dependency.Save(repo, deps); err != nil {
+		log.Exitf("Failed to save dependencies: %v", err)
 	}
 }
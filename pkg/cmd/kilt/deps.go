@@ -0,0 +1,227 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+var depsCmd = &cobra.Command{
+	Use:   "deps --graph [--format dot|mermaid] | --check | --prune",
+	Short: "Inspect patchset dependencies",
+	Long: `Inspect the dependencies between patchsets.
+
+With --graph, the full dependency graph is rendered in Graphviz dot or Mermaid
+format, with an edge from each patchset to each of its dependencies. Edges
+already implied by transitive dependencies are drawn dashed, and patchsets and
+edges that form cycles are highlighted in red.
+
+With --check, the declared dependencies are verified: each patchset is applied
+in memory on top of the kilt base and its transitive dependencies alone,
+leaving the work tree untouched, and patchsets that fail to apply are
+reported, as they likely depend on a patchset they don't declare.
+
+With --prune, dependencies recorded for patchsets that are no longer on the
+branch, or on such patchsets, are removed, as reported by kilt verify.
+
+Use kilt deps show to query the dependencies of a single patchset.`,
+	Args: argsDeps,
+	Run:  runDeps,
+}
+
+var depsShowCmd = &cobra.Command{
+	Use:   "show <patchset> [--why <patchset>]",
+	Short: "Show the dependencies of a patchset",
+	Long: `Show the direct and transitive dependencies of a patchset, the patchsets that
+depend on it, directly or not, and its patch dependencies, which tells what
+would break if it were dropped.
+
+With --why, the chain of dependencies between the patchset and the other given
+patchset is printed instead, in whichever direction it exists.`,
+	Args: argsDepsShow,
+	Run:  runDepsShow,
+}
+
+var depsFlags = struct {
+	graph  bool
+	format string
+	check  bool
+	prune  bool
+	why    string
+}{}
+
+func init() {
+	rootCmd.AddCommand(depsCmd)
+	depsCmd.Flags().BoolVar(&depsFlags.graph, "graph", false, "render the dependency graph")
+	depsCmd.Flags().BoolVar(&depsFlags.check, "check", false, "check that each patchset applies on top of its declared dependencies alone")
+	depsCmd.Flags().BoolVar(&depsFlags.prune, "prune", false, "remove dependencies naming patchsets missing from the branch")
+	depsCmd.Flags().StringVar(&depsFlags.format, "format", dependency.FormatDot, "graph format: dot or mermaid")
+	depsCmd.AddCommand(depsShowCmd)
+	depsShowCmd.Flags().StringVar(&depsFlags.why, "why", "", "print the dependency path between the patchset and this one")
+}
+
+func argsDeps(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	n := 0
+	for _, set := range []bool{depsFlags.graph, depsFlags.check, depsFlags.prune} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("exactly one of --graph, --check or --prune required")
+	}
+	return nil
+}
+
+func runDeps(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Failed to open repo: %v", err)
+	}
+	if depsFlags.check {
+		checkDeps(r)
+		return
+	}
+	if depsFlags.prune {
+		pruned, err := dependency.Prune(r)
+		if err != nil {
+			log.Exitf("Error pruning dependencies: %v", err)
+		}
+		fmt.Printf("Pruned %d dependency entries.\n", pruned)
+		return
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		log.Exitf("Error loading dependencies: %v", err)
+	}
+	graph, err := deps.Render(depsFlags.format)
+	if err != nil {
+		log.Exitf("Error rendering dependencies: %v", err)
+	}
+	fmt.Print(graph)
+}
+
+func checkDeps(r *repo.Repo) {
+	insufficient, err := dependency.Check(r)
+	if err != nil {
+		log.Exitf("Error checking dependencies: %v", err)
+	}
+	for _, i := range insufficient {
+		deps := "no dependencies"
+		if len(i.Dependencies) > 0 {
+			deps = "dependencies " + strings.Join(i.Dependencies, ", ")
+		}
+		in := ""
+		if i.In != i.Patchset {
+			in = " of " + i.In
+		}
+		fmt.Printf("Patchset %s doesn't apply on top of its %s: patch %.7s%s conflicts\n", i.Patchset, deps, i.Patch, in)
+	}
+	if len(insufficient) > 0 {
+		log.Exitf("%d patchsets have insufficient dependencies", len(insufficient))
+	}
+	fmt.Println("All patchsets apply on top of their dependencies.")
+}
+
+func argsDepsShow(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one patchset name is required")
+	}
+	return nil
+}
+
+func runDepsShow(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Failed to open repo: %v", err)
+	}
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		log.Exitf("Error loading patchsets: %v", err)
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		log.Exitf("Error loading dependencies: %v", err)
+	}
+	ps, ok := patchsets.Lookup(args[0])
+	if !ok {
+		log.Exitf("Patchset %q not found", args[0])
+	}
+	if depsFlags.why != "" {
+		other, ok := patchsets.Lookup(depsFlags.why)
+		if !ok {
+			log.Exitf("Patchset %q not found", depsFlags.why)
+		}
+		path := deps.Path(ps, other)
+		if path == nil {
+			path = deps.Path(other, ps)
+		}
+		if path == nil {
+			fmt.Printf("%s and %s don't depend on each other\n", ps.Name(), other.Name())
+			return
+		}
+		fmt.Println(strings.Join(patchsetNames(patchsets, path, false), " -> "))
+		return
+	}
+	list := func(label string, ps []*patchset.Patchset) {
+		names := patchsetNames(patchsets, ps, true)
+		if len(names) == 0 {
+			names = []string{"none"}
+		}
+		fmt.Printf("%s: %s\n", label, strings.Join(names, ", "))
+	}
+	fmt.Printf("Patchset %s\n", ps.Name())
+	list("Dependencies", deps.Dependencies(ps))
+	list("Transitive dependencies", deps.TransitiveDependencies(ps))
+	list("Dependents", deps.Dependents(ps))
+	list("Transitive dependents", deps.TransitiveReverseDependencies(ps))
+	for _, p := range deps.PatchDependencies(ps) {
+		fmt.Printf("Patch dependency: %s\n", p)
+	}
+	for _, p := range deps.PatchDependents(ps) {
+		fmt.Printf("Patch dependent: %s\n", p)
+	}
+}
+
+// patchsetNames returns the names of the patchsets, in branch order if sorted
+// is set.
+func patchsetNames(patchsets repo.PatchsetCache, ps []*patchset.Patchset, sorted bool) []string {
+	ps = append([]*patchset.Patchset{}, ps...)
+	if sorted {
+		sort.Slice(ps, func(i, j int) bool {
+			return patchsets.Index[ps[i].Name()] < patchsets.Index[ps[j].Name()]
+		})
+	}
+	var names []string
+	for _, p := range ps {
+		names = append(names, p.Name())
+	}
+	return names
+}
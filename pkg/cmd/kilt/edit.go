@@ -0,0 +1,73 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/cmd/kilt/internal/editor"
+	"github.com/google/kilt/pkg/rework"
+)
+
+var editCmd = &cobra.Command{
+	Use:   "edit <patchset>",
+	Short: "Edit the metadata of a patchset",
+	Long: `Open the name, description and metadata fields of a patchset in the editor
+given by $GIT_EDITOR, $VISUAL or $EDITOR.
+
+Once the editor exits, the patchset is reworked with a new metadata commit
+holding the edited metadata and a bumped version. If the name was changed, the
+patches of the patchset are reassigned to the new name.`,
+	Args: argsEdit,
+	Run:  runEdit,
+}
+
+func init() {
+	rootCmd.AddCommand(editCmd)
+}
+
+func argsEdit(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one patchset name is required")
+	}
+	return nil
+}
+
+func editMetadata(text []byte) ([]byte, error) {
+	return editor.Edit("metadata", text)
+}
+
+func runEdit(cmd *cobra.Command, args []string) {
+	c, err := rework.NewEditCommand(args[0], editMetadata)
+	if errors.Is(err, rework.ErrNoChanges) {
+		fmt.Println("Metadata unchanged.")
+		return
+	}
+	if err != nil {
+		log.Exitf("Edit failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Edit failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
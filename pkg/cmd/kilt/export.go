@@ -0,0 +1,76 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/export"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <patchset> | --worktree <dir> [patchset...]",
+	Short: "Export a patchset as a series of patch files",
+	Long: `Export the patches of a patchset as a numbered series of format-patch style
+mbox files. The kilt metadata of the patchset is included in the mail headers of
+each patch, so the series can be shared with people who don't use kilt.
+
+With --worktree, fully materialized source trees are written instead, for
+consumers that compare whole trees rather than apply patches. The kilt base is
+written to <dir>/00-base, and the tree with each further patchset applied, in
+branch order, to <dir>/01-<patchset>, <dir>/02-<patchset> and so on. The given
+patchsets are included, or all of them if none are given. Patches are applied
+in memory, so the work tree is left untouched.`,
+	Args: argsExport,
+	Run:  runExport,
+}
+
+var exportFlags = struct {
+	output   string
+	worktree string
+}{}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVarP(&exportFlags.output, "output", "o", ".", "directory to write the patch files to")
+	exportCmd.Flags().StringVar(&exportFlags.worktree, "worktree", "", "write materialized source trees to subdirectories of this directory")
+}
+
+func argsExport(cmd *cobra.Command, args []string) error {
+	if exportFlags.worktree != "" {
+		return nil
+	}
+	if len(args) != 1 {
+		return errors.New("exactly one patchset name is required")
+	}
+	return nil
+}
+
+func runExport(cmd *cobra.Command, args []string) {
+	if exportFlags.worktree != "" {
+		if err := export.Worktrees(args, exportFlags.worktree); err != nil {
+			log.Exitf("Export failed: %v", err)
+		}
+		return
+	}
+	if err := export.Patchset(args[0], exportFlags.output); err != nil {
+		log.Exitf("Export failed: %v", err)
+	}
+}
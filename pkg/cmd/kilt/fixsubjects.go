@@ -0,0 +1,55 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var fixSubjectsCmd = &cobra.Command{
+	Use:   "fix-subjects [<patchset>...]",
+	Short: "Add subject prefixes to the patches lacking them",
+	Long: `Add the subject prefix of each patchset, such as CHROMIUM:, to the subjects of
+its patches that lack it, or normalize a prefix differing only in case or
+spacing. Prefixes are configured as described by kilt check. Only the named
+patchsets are fixed, or all patchsets if none are named.
+
+The branch is rewritten through a rework which only changes the commit
+messages of the patches; the patchset versions are left unchanged. If the
+rework stops, use kilt rework --continue to complete it.`,
+	Run: runFixSubjects,
+}
+
+func init() {
+	rootCmd.AddCommand(fixSubjectsCmd)
+}
+
+func runFixSubjects(cmd *cobra.Command, args []string) {
+	c, err := rework.NewFixSubjectsCommand(args)
+	if err != nil {
+		log.Exitf("Fix subjects failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Fix subjects failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
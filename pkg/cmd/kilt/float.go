@@ -0,0 +1,80 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/repo"
+)
+
+var floatCmd = &cobra.Command{
+	Use:   "float <commit> <patchset>",
+	Short: "Mark a patch to be reworked into a patchset",
+	Long: `Mark a patch on the top of the kilt branch as a floating patch of a
+patchset, by setting the Patchset-Name footer of its commit message. The next
+rework of the patchset moves the patch into it.
+
+Only the given commit and the patches above it are rewritten, and their trees
+are left unchanged, so the work tree isn't touched. The commit must come after
+the metadata commit of the last patchset.`,
+	Args: argsFloat,
+	Run:  runFloat,
+}
+
+func init() {
+	rootCmd.AddCommand(floatCmd)
+}
+
+func argsFloat(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("a commit and a patchset name are required")
+	}
+	return nil
+}
+
+func runFloat(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Float failed: %v", err)
+	}
+	if exists, err := r.ReworkInProgress(); err != nil {
+		log.Exitf("Float failed: %v", err)
+	} else if exists {
+		log.Exit("Float failed: rework in progress")
+	}
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		log.Exitf("Failed to load patchsets: %v", err)
+	}
+	ps, ok := patchsets.Lookup(args[1])
+	if !ok {
+		log.Exitf("Float failed: patchset %q not found", args[1])
+	}
+	if ps.Archived() {
+		log.Exitf("Float failed: patchset %q is archived", ps.Name())
+	}
+	id, err := r.FloatPatch(args[0], ps.Name())
+	if err != nil {
+		log.Exitf("Float failed: %v", err)
+	}
+	fmt.Printf("Floated %s into patchset %s\n", id, ps.Name())
+}
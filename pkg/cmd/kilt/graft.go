@@ -0,0 +1,102 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/graft"
+	"github.com/google/kilt/pkg/rework"
+)
+
+var graftCmd = &cobra.Command{
+	Use:   "graft <ref>",
+	Short: "Adopt patchset state from another patch stack",
+	Long: `Adopt the patchset state of another patch stack carrying overlapping patches,
+such as a diverged fork of the kilt branch fetched from another repository.
+
+Patches are matched by patch id. Each patchset whose matched patches all belong
+to a single patchset of the other stack adopts that patchset's name and UUID
+through a rework. Patchsets that can't be matched unambiguously are reported as
+conflicts and left unchanged.
+
+The other stack is read from the patches between --base and ref, where --base
+defaults to the merge base of ref and the kilt base. Use --dry-run to only
+report what would be adopted.`,
+	Args: argsGraft,
+	Run:  runGraft,
+}
+
+var graftFlags = struct {
+	base   string
+	fetch  bool
+	dryRun bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(graftCmd)
+	graftCmd.Flags().StringVar(&graftFlags.base, "base", "", "base of the other patch stack")
+	graftCmd.Flags().BoolVar(&graftFlags.fetch, "fetch", false, "fetch the remote of ref first")
+	graftCmd.Flags().BoolVarP(&graftFlags.dryRun, "dry-run", "n", false, "only report what would be adopted")
+}
+
+func argsGraft(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one ref is required")
+	}
+	return nil
+}
+
+func runGraft(cmd *cobra.Command, args []string) {
+	plan, err := graft.NewPlan(args[0], graftFlags.base, graftFlags.fetch)
+	if err != nil {
+		log.Exitf("Graft failed: %v", err)
+	}
+	printGraftPlan(plan)
+	if graftFlags.dryRun || len(plan.Adoptions) == 0 {
+		return
+	}
+	c, err := rework.NewAdoptCommand(plan.Adoptions)
+	if err != nil {
+		log.Exitf("Graft failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Graft failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
+
+func printGraftPlan(p *graft.Plan) {
+	for _, name := range p.Matching {
+		fmt.Printf("Patchset %q already matches\n", name)
+	}
+	for _, a := range p.Adoptions {
+		fmt.Printf("Patchset %q will adopt patchset %q (%s)\n", a.Patchset, a.Name, a.UUID)
+	}
+	for _, c := range p.Conflicts {
+		fmt.Printf("Conflict: %s\n", c)
+	}
+	if len(p.Matching) == 0 && len(p.Adoptions) == 0 && len(p.Conflicts) == 0 {
+		fmt.Println("No matching patches found")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/repo"
+)
+
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "Manage the git hooks kilt provides",
+}
+
+var hooksInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install the kilt commit-msg hook",
+	Long: `Install a commit-msg hook that runs kilt lint-msg --fix on every new commit
+message. Messages without a Patchset-Name footer get one naming the default
+patchset, set with the kilt.defaultPatchset config option, or else the patchset
+of the commit the new one is made on. The commit is rejected if the footer is
+malformed or names a patchset that doesn't exist.
+
+The hook is installed in the directory set by core.hooksPath, or in the hooks
+directory of the repo. An existing commit-msg hook is only replaced with
+--force.`,
+	Args: argsHooksInstall,
+	Run:  runHooksInstall,
+}
+
+var hooksFlags = struct {
+	force bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(hooksCmd)
+	hooksCmd.AddCommand(hooksInstallCmd)
+	hooksInstallCmd.Flags().BoolVar(&hooksFlags.force, "force", false, "replace an existing commit-msg hook")
+}
+
+func argsHooksInstall(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func runHooksInstall(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Failed to open repo: %v", err)
+	}
+	path, err := r.InstallCommitMsgHook(hooksFlags.force)
+	if errors.Is(err, repo.ErrHookExists) {
+		log.Exitf("Install failed: %v, use --force to replace it", err)
+	} else if err != nil {
+		log.Exitf("Install failed: %v", err)
+	}
+	fmt.Printf("Installed %s\n", path)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/importer"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import [--patchset <name>] <dir-or-mbox>",
+	Short: "Import a series of patch files into a patchset",
+	Long: `Import a series of patch files, either a directory of .patch files or a single
+mbox, applying them on top of the kilt branch. Each resulting commit is
+assigned to the patchset with a "Patchset-Name:" footer, and the patchset
+metadata is created if the patchset doesn't exist yet.
+
+If no patchset is specified, the patchset is taken from the kilt headers
+written by kilt export.`,
+	Args: argsImport,
+	Run:  runImport,
+}
+
+var importFlags = struct {
+	patchset string
+}{}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVarP(&importFlags.patchset, "patchset", "p", "", "patchset to import the patches into")
+}
+
+func argsImport(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one patch directory or mbox is required")
+	}
+	return nil
+}
+
+func runImport(cmd *cobra.Command, args []string) {
+	if err := importer.Import(importFlags.patchset, args[0]); err != nil {
+		log.Exitf("Import failed: %v", err)
+	}
+}
@@ -17,8 +17,14 @@ limitations under the License.
 package kilt
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 
+	"github.com/google/kilt/pkg/dependency"
 	"github.com/google/kilt/pkg/repo"
 
 	log "github.com/golang/glog"
@@ -29,16 +35,41 @@ var initCmd = &cobra.Command{
 	Use:   "init <base>",
 	Short: "Initialize branch to work with Kilt",
 	Long: `Initialize the current branch to work with Kilt. Pass in a <base> specified in
-the form of a git revision. Every commit on top of <base> can be managed by Kilt. `,
+the form of a git revision. Every commit on top of <base> can be managed by Kilt.
+The base must be an ancestor of the current branch.
+
+A branch that is already initialized is left alone, unless --force is given to
+move its base. Use --show to print the current base instead.
+
+If the branch ships a dependencies.json file, such as one copied from another
+branch, its dependencies are validated against the patchsets on the branch and
+imported. For each patchset it names that isn't on the branch, kilt asks for a
+patchset to use instead, or prunes its entries if none is given. With
+--prune-dependencies, such entries are pruned without asking.`,
 	Args: argsInit,
 	Run:  runInit,
 }
 
+var initFlags = struct {
+	force             bool
+	show              bool
+	pruneDependencies bool
+}{}
+
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().BoolVarP(&initFlags.force, "force", "f", false, "move the base of an already initialized branch")
+	initCmd.Flags().BoolVar(&initFlags.show, "show", false, "print the current base of the branch")
+	initCmd.Flags().BoolVar(&initFlags.pruneDependencies, "prune-dependencies", false, "prune dependencies on missing patchsets without asking")
 }
 
 func argsInit(cmd *cobra.Command, args []string) error {
+	if initFlags.show {
+		if len(args) != 0 || initFlags.force {
+			return errors.New("--show takes no <base> and can't be used with --force")
+		}
+		return nil
+	}
 	if len(args) < 1 {
 		return errors.New("<base> required")
 	}
@@ -46,8 +77,62 @@ func argsInit(cmd *cobra.Command, args []string) error {
 }
 
 func runInit(cmd *cobra.Command, args []string) {
-	_, err := repo.Init(args[0])
-	if err != nil {
+	if initFlags.show {
+		r, err := repo.Open()
+		if err != nil {
+			log.Exitf("Failed to read Kilt base: %v", err)
+		}
+		fmt.Println(r.KiltBase())
+		return
+	}
+	r, err := repo.Init(args[0], initFlags.force)
+	var initialized *repo.ErrInitialized
+	if errors.As(err, &initialized) {
+		log.Exitf("Failed to initialize Kilt: %v; use --force to move the base", err)
+	} else if err != nil {
 		log.Errorf("Failed to initialize Kilt: %v", err)
+		return
+	}
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		log.Exitf("Failed to import dependencies: %v", err)
+	}
+	imported, err := dependency.Import(r, remapDependency(patchsets))
+	if err != nil {
+		log.Exitf("Failed to import dependencies: %v", err)
+	} else if imported {
+		fmt.Println("Imported patchset dependencies")
+	}
+}
+
+// remapDependency returns the function asking which patchset to use in place
+// of a missing patchset named in the dependencies.
+func remapDependency(patchsets repo.PatchsetCache) func(name string) (string, error) {
+	in := bufio.NewReader(os.Stdin)
+	return func(name string) (string, error) {
+		if initFlags.pruneDependencies {
+			fmt.Printf("Pruning missing patchset %q from dependencies\n", name)
+			return "", nil
+		}
+		for {
+			fmt.Printf("Patchset %q in dependencies is not on the branch; patchset to use instead (empty to prune): ", name)
+			answer, err := in.ReadString('\n')
+			answer = strings.TrimSpace(answer)
+			if err == io.EOF && answer == "" {
+				return "", errors.New("no answer given; use --prune-dependencies to prune missing patchsets")
+			} else if err != nil && err != io.EOF {
+				return "", err
+			}
+			if answer == "" {
+				return "", nil
+			}
+			if _, ok := patchsets.Lookup(answer); ok {
+				return answer, nil
+			}
+			fmt.Printf("Patchset %q not found\n", answer)
+			if err == io.EOF {
+				return "", fmt.Errorf("patchset %q not found", answer)
+			}
+		}
 	}
 }
@@ -0,0 +1,177 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/google/kilt/pkg/journal"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+)
+
+var journalCmd = &cobra.Command{
+	Use:   "journal",
+	Short: "Inspect the local journal of kilt commands",
+	Long: `Inspect the journal of kilt commands run in the repo. Each command is
+recorded with the names of the flags it was given, but not their values, the
+name of the rework in progress, its duration and its result, in
+.git/kilt/journal. The journal is never sent
+anywhere. Setting kilt.journal to false stops recording.`,
+}
+
+var journalReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize usage and failures of kilt commands",
+	Long: `Summarize the commands recorded in the journal: how often each was run, how
+often it failed and how long it took, with the commands failing most often
+first, along with their most frequent error. Commands that exited with an
+error before finishing are counted as failed.
+
+With --since, only commands started within the given duration are included.
+With --session, only commands run during the named rework are included.`,
+	Args: argsJournal,
+	Run:  runJournalReport,
+}
+
+var journalFlags = struct {
+	since   time.Duration
+	session string
+	asJSON  bool
+}{}
+
+// invocation is the journal record of the running command, if any.
+var invocation *journal.Invocation
+
+func init() {
+	rootCmd.AddCommand(journalCmd)
+	journalCmd.AddCommand(journalReportCmd)
+	journalReportCmd.Flags().DurationVar(&journalFlags.since, "since", 0, "only include commands started within this duration, such as 168h")
+	journalReportCmd.Flags().StringVar(&journalFlags.session, "session", "", "only include commands run during the rework with this name")
+	journalReportCmd.Flags().BoolVar(&journalFlags.asJSON, "json", false, "print the report as JSON")
+}
+
+func argsJournal(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func journalPath(r *repo.Repo) string {
+	return filepath.Join(r.KiltDirectory(), "journal")
+}
+
+// startJournal records the start of the command, unless it runs outside a
+// repo, in read-only mode or the journal is disabled. Failing to record is
+// never fatal.
+func startJournal(cmd *cobra.Command) {
+	if repo.ReadOnly() || cmd.Parent() == journalCmd {
+		return
+	}
+	r, err := repo.Open()
+	if err != nil {
+		return
+	}
+	if enabled, err := r.ConfigBool("kilt.journal", true); err != nil || !enabled {
+		return
+	}
+	if err := os.MkdirAll(r.KiltDirectory(), 0777); err != nil {
+		log.V(1).Infof("Failed to create journal: %v", err)
+		return
+	}
+	var flags []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		flags = append(flags, f.Name)
+	})
+	command := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	session, err := rework.SessionName(r)
+	if err != nil {
+		log.V(1).Infof("Failed to read rework name: %v", err)
+	}
+	if invocation, err = journal.Start(journalPath(r), command, flags, session); err != nil {
+		log.V(1).Infof("Failed to record command in journal: %v", err)
+	}
+}
+
+// finishJournal records the result of the command started by startJournal.
+func finishJournal(cmdErr error) {
+	if invocation == nil {
+		return
+	}
+	if err := invocation.Finish(cmdErr); err != nil {
+		log.V(1).Infof("Failed to record command in journal: %v", err)
+	}
+	invocation = nil
+}
+
+func runJournalReport(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Journal report failed: %v", err)
+	}
+	records, err := journal.Read(journalPath(r))
+	if err != nil {
+		log.Exitf("Journal report failed: %v", err)
+	}
+	cutoff := time.Now().Add(-journalFlags.since)
+	var selected []journal.Record
+	for _, rec := range records {
+		if journalFlags.since > 0 && !rec.Start.After(cutoff) {
+			continue
+		}
+		if journalFlags.session != "" && rec.Session != journalFlags.session {
+			continue
+		}
+		selected = append(selected, rec)
+	}
+	records = selected
+	usage := journal.Report(records)
+	if journalFlags.asJSON {
+		b, err := json.MarshalIndent(usage, "", "  ")
+		if err != nil {
+			log.Exitf("Journal report failed: %v", err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	if len(usage) == 0 {
+		fmt.Println("No commands recorded.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMAND\tRUNS\tFAILED\tMEAN\tMAX\tTOP ERROR")
+	for _, u := range usage {
+		top, n := u.TopError()
+		if n > 0 {
+			top = fmt.Sprintf("%s (%d)", top, n)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", u.Command, u.Runs, u.Failures, u.Mean.Round(time.Millisecond), u.Max.Round(time.Millisecond), top)
+	}
+	w.Flush()
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/repo"
+)
+
+var lintMsgCmd = &cobra.Command{
+	Use:   "lint-msg [<file>...]",
+	Short: "Check the Patchset-Name footers of commit messages",
+	Long: `Check that commit messages have a single Patchset-Name footer, in their
+trailing paragraph, naming a patchset of the kilt branch. The messages are read
+from the given files, as the commit-msg hook installed by kilt hooks install
+does, or if none are given, taken from the patches of the branch, so that CI can
+reject a branch with unassigned patches. Metadata commits and fixup! and
+squash! commits are not checked, nor are the patches already in archived
+patchsets, which can't be given new patches.
+
+With --fix, a message read from a file that has no Patchset-Name footer gets
+one naming the default patchset, set with the kilt.defaultPatchset config
+option, or else the patchset of the head commit.`,
+	Run: runLintMsg,
+}
+
+var lintMsgFlags = struct {
+	fix bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(lintMsgCmd)
+	lintMsgCmd.Flags().BoolVar(&lintMsgFlags.fix, "fix", false, "add a missing Patchset-Name footer naming the default patchset")
+}
+
+func runLintMsg(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Failed to open repo: %v", err)
+	}
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		log.Exitf("Failed to load patchsets: %v", err)
+	}
+	failed := 0
+	report := func(source, message string) {
+		for _, p := range repo.LintMessage(message, patchsets) {
+			fmt.Printf("%s: %s\n", source, p)
+			failed++
+		}
+	}
+	if len(args) == 0 {
+		for _, ps := range patchsets.Slice {
+			patches := ps.FloatingPatches()
+			if !ps.Archived() {
+				patches = append(append([]string{}, ps.Patches()...), patches...)
+			}
+			for _, patch := range patches {
+				info, err := r.CommitInfo(patch)
+				if err != nil {
+					log.Exitf("Failed to read commit %s: %v", patch, err)
+				}
+				report(info.ID, info.Message)
+			}
+		}
+	}
+	for _, file := range args {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Exitf("Failed to read message: %v", err)
+		}
+		message := string(b)
+		if lintMsgFlags.fix && !repo.HasPatchsetName(message) {
+			if message, err = fixMessage(r, message); err != nil {
+				log.Exitf("Failed to fix %s: %v", file, err)
+			}
+			if err := ioutil.WriteFile(file, []byte(message), 0666); err != nil {
+				log.Exitf("Failed to write message: %v", err)
+			}
+		}
+		report(file, message)
+	}
+	if failed > 0 {
+		log.Exitf("Lint failed: %d problems found", failed)
+	}
+}
+
+// fixMessage returns the message with a Patchset-Name footer naming the
+// default patchset, or unchanged if there is none.
+func fixMessage(r *repo.Repo, message string) (string, error) {
+	name, err := r.DefaultPatchset()
+	if err != nil || name == "" {
+		return message, err
+	}
+	return repo.WithPatchsetName(message, name), nil
+}
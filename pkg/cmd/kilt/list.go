@@ -0,0 +1,85 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/show"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the patchsets of the branch",
+	Long: `List the patchsets of the kilt branch in order, with their versions and the
+number of patches in each.
+
+With --at, the patchsets are listed as they were at the given commit, such as a
+release tag or a previous state of the branch. The base of the patch stack at
+that commit is found from the branch's backups or its metadata commits, or can
+be given with --base.
+
+Archived patchsets are left out, unless --archived is given.
+
+With --branch-view, the kilt branch is read even while a rework is in
+progress, rather than the rework head, so scripts and other read-only consumers
+see the branch state.`,
+	Args: argsList,
+	Run:  runList,
+}
+
+var listFlags = struct {
+	at   string
+	base string
+
+	branchView bool
+	archived   bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVar(&listFlags.at, "at", "", "list the patchsets as they were at the given commit")
+	listCmd.Flags().StringVar(&listFlags.base, "base", "", "base of the patch stack at the --at commit")
+	listCmd.Flags().BoolVar(&listFlags.archived, "archived", false, "also list archived patchsets")
+	listCmd.Flags().BoolVar(&listFlags.branchView, "branch-view", false, "read the kilt branch rather than the head of a rework in progress")
+}
+
+func argsList(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments are accepted")
+	}
+	if listFlags.base != "" && listFlags.at == "" {
+		return errors.New("--base can only be used with --at")
+	}
+	if listFlags.branchView && listFlags.at != "" {
+		return errors.New("--branch-view can't be used with --at")
+	}
+	return nil
+}
+
+func runList(cmd *cobra.Command, args []string) {
+	r, err := show.Open(listFlags.at, listFlags.base, listFlags.branchView)
+	if err != nil {
+		log.Exitf("Error: %v", err)
+	}
+	if err := show.List(r, listFlags.archived); err != nil {
+		log.Exitf("Error: %v", err)
+	}
+}
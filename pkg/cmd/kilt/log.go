@@ -0,0 +1,86 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/show"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the history of the kilt branch by patchset",
+	Long: `Show the commits of the kilt branch from the base to the head, grouped under a
+header for each run of commits belonging to the same patchset. Metadata commits
+and floating patches are marked.
+
+With --patchset, only the commits of the given patchset are shown. Output is
+colored when writing to a terminal, which can be changed with --color.
+
+With --branch-view, the kilt branch is read even while a rework is in
+progress, rather than the rework head, so scripts and other read-only consumers
+see the branch state.`,
+	Args: argsLog,
+	Run:  runLog,
+}
+
+var logFlags = struct {
+	patchset string
+	color    string
+
+	branchView bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+	logCmd.Flags().StringVarP(&logFlags.patchset, "patchset", "p", "", "only show the commits of the patchset")
+	logCmd.Flags().StringVar(&logFlags.color, "color", "auto", "when to color the output: auto, always or never")
+	logCmd.Flags().BoolVar(&logFlags.branchView, "branch-view", false, "read the kilt branch rather than the head of a rework in progress")
+}
+
+func argsLog(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	switch logFlags.color {
+	case "auto", "always", "never":
+		return nil
+	}
+	return fmt.Errorf("invalid --color %q", logFlags.color)
+}
+
+func runLog(cmd *cobra.Command, args []string) {
+	color := logFlags.color == "always"
+	if logFlags.color == "auto" {
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			color = true
+		}
+	}
+	r, err := show.Open("", "", logFlags.branchView)
+	if err != nil {
+		log.Exitf("Log failed: %v", err)
+	}
+	if err := show.LogFromRepo(r, logFlags.patchset, color); err != nil {
+		log.Exitf("Log failed: %v", err)
+	}
+}
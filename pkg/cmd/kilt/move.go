@@ -0,0 +1,71 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var moveCmd = &cobra.Command{
+	Use:   "move <commit>... --to <patchset>",
+	Short: "Move patches to another patchset",
+	Long: `Move patches to another patchset. The branch is rewritten through a rework
+which reassigns the given commits to the target patchset by rewriting their
+Patchset-Name footer, and places them after the patches of the target patchset.
+
+If the rework stops due to conflicts, resolve them and use kilt rework
+--continue to complete the move.`,
+	Args: argsMove,
+	Run:  runMove,
+}
+
+var moveFlags = struct {
+	to string
+}{}
+
+func init() {
+	rootCmd.AddCommand(moveCmd)
+	moveCmd.Flags().StringVar(&moveFlags.to, "to", "", "patchset to move the patches to")
+}
+
+func argsMove(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return errors.New("at least one commit is required")
+	}
+	if moveFlags.to == "" {
+		return errors.New("a target patchset must be specified with --to")
+	}
+	return nil
+}
+
+func runMove(cmd *cobra.Command, args []string) {
+	c, err := rework.NewMoveCommand(args, moveFlags.to)
+	if err != nil {
+		log.Exitf("Move failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Move failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
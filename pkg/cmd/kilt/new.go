@@ -18,6 +18,8 @@ package kilt
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	log "github.com/golang/glog"
 	"github.com/spf13/cobra"
@@ -30,20 +32,64 @@ var newCmd = &cobra.Command{
 	Use:   "new <patchset>",
 	Short: "Create a new patchset",
 	Long: `Create a new patchset in the current repo. Pass in the patchset name as the
-first positional argument.`,
+first positional argument.
+
+The metadata of the patchset can carry a free-form description, given with
+--description, and any number of Key: value fields, such as an owner, a bug link
+or the upstream status, given with --field key=value. They are shown by kilt
+show.
+
+The Apply-Strategy field sets how rework and build apply the patches of the
+patchset. It lists an optional method, cherry-pick (the default) or 3way for
+git apply --3way, followed by ours=<patterns> and theirs=<patterns> rules that
+resolve conflicts in matching paths to the head or to the patch. For example,
+--field "Apply-Strategy=3way ours=*.pb.go theirs=vendor/**" keeps the head's
+generated protos and takes vendored files from the patch.
+
+The Tags field labels the patchset with tags separated by commas or spaces, such
+as --field "Tags=performance, vendor", so that kilt rework and kilt build can
+select related patchsets with --tag.
+
+Patchset names are compared ignoring case and the Unicode composition of
+accented letters, so a new patchset can't be named like an existing one that
+only differs in those.`,
 	Args: argsNew,
 	Run:  runNew,
 }
 
+var newFlags = struct {
+	description string
+	fields      []string
+}{}
+
 func init() {
 	rootCmd.AddCommand(newCmd)
+	newCmd.Flags().StringVarP(&newFlags.description, "description", "d", "", "description of the patchset")
+	newCmd.Flags().StringArrayVarP(&newFlags.fields, "field", "F", nil, "metadata field of the patchset, as key=value")
 }
 
 func argsNew(cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
 		return errors.New("Patchset name required")
 	}
-	return nil
+	_, err := parseFieldFlags(newFlags.fields)
+	return err
+}
+
+// parseFieldFlags parses key=value field flags into patchset fields.
+func parseFieldFlags(flags []string) ([]patchset.Field, error) {
+	var fields []patchset.Field
+	for _, f := range flags {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("field %q must be given as key=value", f)
+		}
+		if err := repo.CheckField(kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+		fields = append(fields, patchset.Field{Key: kv[0], Value: kv[1]})
+	}
+	return fields, nil
 }
 
 func runNew(cmd *cobra.Command, args []string) {
@@ -52,7 +98,19 @@ func runNew(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Exitf("Init failed: %s", err)
 	}
+	patchsets, err := repo.PatchsetCache()
+	if err != nil {
+		log.Exitf("Failed to load patchsets: %s", err)
+	}
+	if other, ok := patchsets.Lookup(args[0]); ok {
+		log.Exitf("Patchset %q already exists", other.Name())
+	}
 	ps := patchset.New(args[0])
+	ps.SetDescription(newFlags.description)
+	fields, _ := parseFieldFlags(newFlags.fields)
+	for _, f := range fields {
+		ps.SetField(f.Key, f.Value)
+	}
 	err = repo.AddPatchset(ps)
 	if err != nil {
 		log.Exitf("Failed to add patchset: %s", err)
@@ -0,0 +1,89 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/repo"
+)
+
+var noteCmd = &cobra.Command{
+	Use:   "note <patch>",
+	Short: "Attach a review note to a patch",
+	Long: `Attach a review note to a patch, or print the note it has. Notes are kept as
+git notes under refs/notes/kilt, so they can also be read with
+git log --notes=kilt, and are shown by kilt show --notes.
+
+When patches are rewritten by a rework, their notes are carried forward to the
+reworked patches that make the same changes, matched by patch ID.
+
+With --message, the note of the patch is replaced. With --remove, the note is
+removed.`,
+	Args: argsNote,
+	Run:  runNote,
+}
+
+var noteFlags = struct {
+	message string
+	remove  bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(noteCmd)
+	noteCmd.Flags().StringVarP(&noteFlags.message, "message", "m", "", "note to attach to the patch")
+	noteCmd.Flags().BoolVar(&noteFlags.remove, "remove", false, "remove the note of the patch")
+}
+
+func argsNote(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("a patch is required")
+	}
+	if noteFlags.remove && noteFlags.message != "" {
+		return errors.New("--message can't be used with --remove")
+	}
+	return nil
+}
+
+func runNote(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Note failed: %v", err)
+	}
+	id, err := r.ResolveCommit(args[0])
+	if err != nil {
+		log.Exitf("Note failed: %v", err)
+	}
+	switch {
+	case noteFlags.remove:
+		err = r.RemovePatchNote(id)
+	case noteFlags.message != "":
+		err = r.SetPatchNote(id, noteFlags.message+"\n")
+	default:
+		var note string
+		if note, err = r.PatchNote(id); err == nil {
+			fmt.Print(note)
+		}
+	}
+	if err != nil {
+		log.Exitf("Note failed: %v", err)
+	}
+}
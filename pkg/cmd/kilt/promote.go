@@ -0,0 +1,134 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote <patchset> --from <branch>",
+	Short: "Promote a patchset from another kilt branch",
+	Long: `Promote a patchset from another kilt branch, such as a development branch, onto
+the kilt branch, such as a release branch, through a rework. The metadata and
+patches of the patchset are cherry-picked from the other branch, keeping its
+version. A patchset with the same UUID on the kilt branch is replaced in
+place, otherwise the patchset is added after the last patchset.
+
+The current version of the patchset is promoted, unless --version names an
+earlier one recorded by a version ref. The dependencies the patchset has on the
+other branch are carried over; promoting fails if any of them is missing from
+the kilt branch, or comes after the patchset.
+
+Each promotion is recorded in the promotion journal of both branches, which
+--list prints for the kilt branch.`,
+	Args: argsPromote,
+	Run:  runPromote,
+}
+
+var promoteFlags = struct {
+	from    string
+	version string
+	dryRun  bool
+	list    bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(promoteCmd)
+	promoteCmd.Flags().StringVar(&promoteFlags.from, "from", "", "kilt branch to promote the patchset from")
+	promoteCmd.Flags().StringVar(&promoteFlags.version, "version", "", "version of the patchset to promote")
+	promoteCmd.Flags().BoolVarP(&promoteFlags.dryRun, "dry-run", "n", false, "only report what would be promoted")
+	promoteCmd.Flags().BoolVar(&promoteFlags.list, "list", false, "print the promotions recorded for the kilt branch")
+}
+
+func argsPromote(cmd *cobra.Command, args []string) error {
+	if promoteFlags.list {
+		if len(args) != 0 {
+			return errors.New("no arguments expected with --list")
+		}
+		return nil
+	}
+	if len(args) != 1 {
+		return errors.New("exactly one patchset is required")
+	}
+	if promoteFlags.from == "" {
+		return errors.New("--from is required")
+	}
+	return nil
+}
+
+func runPromote(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Promote failed: %v", err)
+	}
+	if promoteFlags.list {
+		if err := listPromotions(r); err != nil {
+			log.Exitf("Promote failed: %v", err)
+		}
+		return
+	}
+	p, err := rework.LoadPromotion(r, promoteFlags.from, args[0], promoteFlags.version)
+	if err != nil {
+		log.Exitf("Promote failed: %v", err)
+	}
+	fmt.Printf("Promoting patchset %q version %s from %s with %d patches\n", p.Patchset, p.Version, p.From, len(p.Patches))
+	if len(p.Dependencies) > 0 {
+		fmt.Printf("Dependencies: %s\n", strings.Join(p.Dependencies, ", "))
+	}
+	if promoteFlags.dryRun {
+		return
+	}
+	c, err := rework.NewPromoteCommand(p)
+	if err != nil {
+		log.Exitf("Promote failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Promote failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
+
+func listPromotions(r *repo.Repo) error {
+	promotions, err := r.Promotions()
+	if err != nil {
+		return err
+	}
+	if len(promotions) == 0 {
+		fmt.Println("No promotions recorded.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tPATCHSET\tVERSION\tFROM\tTO")
+	for _, p := range promotions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Time.Format(time.RFC3339), p.Patchset, p.Version, p.From, p.To)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/push"
+)
+
+var pushCmd = &cobra.Command{
+	Use:   "push <remote> [patchset...]",
+	Short: "Push patchsets to a remote",
+	Long: `Push the tip of each given patchset, or of every patchset, to a remote. The
+--to ref template names the ref each patchset is pushed to, where {branch} and
+{patchset} are replaced by the kilt branch and patchset names. For example,
+--to 'refs/for/main%topic={patchset}' uploads each patchset as a Gerrit topic.
+
+Patchsets are pushed one at a time, and progress is saved, so if the network
+fails part way, running the same push again only pushes the patchsets that are
+left. Failed pushes are retried with backoff, as configured by the
+kilt.networkAttempts, kilt.networkBackoff and kilt.networkInterval options.
+Credentials are taken from the ssh agent or the configured git credential
+helpers.
+
+The owners of each patchset are added as its reviewers: those named in its
+Owner fields, and those the path ownership file at the tip of the patchset
+assigns to the paths its patches change. The ownership file uses the
+CODEOWNERS format, and is read from CODEOWNERS, .github/CODEOWNERS or
+docs/CODEOWNERS, or from the path set by the kilt.ownersFile config option.
+Reviewers are added to Gerrit refs/for/ targets as r= push options, and are
+printed for other targets, such as GitHub branches, for review tooling to pick
+up. Use --reviewers=false to leave them out.`,
+	Args: argsPush,
+	Run:  runPush,
+}
+
+var pushFlags = struct {
+	to        string
+	force     bool
+	reviewers bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+	pushCmd.Flags().StringVar(&pushFlags.to, "to", push.DefaultTarget, "template of the ref to push each patchset to")
+	pushCmd.Flags().BoolVarP(&pushFlags.force, "force", "f", false, "force update the remote refs")
+	pushCmd.Flags().BoolVar(&pushFlags.reviewers, "reviewers", true, "add the owners of each patchset as reviewers")
+}
+
+func argsPush(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("remote name required")
+	}
+	return nil
+}
+
+func runPush(cmd *cobra.Command, args []string) {
+	if err := push.Patchsets(args[1:], args[0], pushFlags.to, pushFlags.force, pushFlags.reviewers); err != nil {
+		log.Exitf("Push failed: %v", err)
+	}
+}
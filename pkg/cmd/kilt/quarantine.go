@@ -0,0 +1,67 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var quarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "Collect unassigned patches in the quarantine patchset",
+	Long: `Collect the patches without a Patchset-Name footer in the quarantine patchset.
+The branch is rewritten through a rework which reassigns the patches to the
+quarantine patchset, creating it where the first unassigned patch is if it
+doesn't exist yet.
+
+Quarantined patches aren't reported as unassigned by kilt status, and aren't
+subject to patchset budgets in kilt check. Instead, the time each patch entered
+quarantine is tracked, and kilt check fails for patches quarantined longer than
+kilt.quarantineMaxAge days. Use kilt move to assign quarantined patches to a
+patchset.`,
+	Args: argsQuarantine,
+	Run:  runQuarantine,
+}
+
+func init() {
+	rootCmd.AddCommand(quarantineCmd)
+}
+
+func argsQuarantine(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func runQuarantine(cmd *cobra.Command, args []string) {
+	c, err := rework.NewQuarantineCommand()
+	if err != nil {
+		log.Exitf("Quarantine failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Quarantine failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
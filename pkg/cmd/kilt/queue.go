@@ -0,0 +1,160 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+)
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Inspect the saved queues of reworks and builds",
+	Long: `Inspect the queues of operations that reworks and builds save in the kilt
+directory, to debug a rework that is stuck.
+
+The queue named "queue" holds the operations of a rework, and "buildQueue"
+those of a build. An operation working on a patchset, such as Rework or Apply,
+runs the steps of that patchset from "reworkQueue", whose initial steps are
+kept in "reworkQueue-plan". Operations skipped with kilt rework --skip are
+kept in "skipped".
+
+Clearing the queue of a rework in progress leaves the rework inconsistent, so
+it requires --force; use kilt rework --abort to give up on a rework instead.`,
+}
+
+var queueListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the queues and the number of operations saved in each",
+	Args:  argsQueueList,
+	Run:   runQueueList,
+}
+
+var queueShowCmd = &cobra.Command{
+	Use:   "show <queue>",
+	Short: "Print the saved operations of a queue",
+	Long: `Print the saved operations of a queue, as they are on disk: the operation
+that failed or was interrupted, the operation that completed before the queue
+was saved again, and the queued operations.`,
+	Args: argsQueueName,
+	Run:  runQueueShow,
+}
+
+var queueClearCmd = &cobra.Command{
+	Use:   "clear <queue>",
+	Short: "Remove the saved operations of a queue",
+	Args:  argsQueueName,
+	Run:   runQueueClear,
+}
+
+var queueFlags = struct {
+	force bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(queueCmd)
+	queueCmd.AddCommand(queueListCmd)
+	queueCmd.AddCommand(queueShowCmd)
+	queueCmd.AddCommand(queueClearCmd)
+	queueClearCmd.Flags().BoolVarP(&queueFlags.force, "force", "f", false, "clear the queue even if a rework is in progress")
+}
+
+func argsQueueList(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func argsQueueName(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("queue name required")
+	}
+	return nil
+}
+
+func openQueueRepo() *repo.Repo {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Failed to open repo: %v", err)
+	}
+	return r
+}
+
+func runQueueList(cmd *cobra.Command, args []string) {
+	r := openQueueRepo()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, info := range rework.Queues() {
+		state, err := rework.ReadQueue(r, info.Name)
+		if err != nil {
+			log.Exitf("Reading queue %s failed: %v", info.Name, err)
+		}
+		summary := fmt.Sprintf("%d queued", len(state.Queue.Items))
+		if len(state.Current.Items) > 0 {
+			summary += ", 1 current"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", info.Name, summary, info.Description)
+	}
+	w.Flush()
+}
+
+func printItems(title string, items []queue.Item) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", title)
+	for _, item := range items {
+		fmt.Printf("\t%s\n", strings.TrimSpace(item.Operation+" "+strings.Join(item.Args, " ")))
+	}
+}
+
+func runQueueShow(cmd *cobra.Command, args []string) {
+	state, err := rework.ReadQueue(openQueueRepo(), args[0])
+	if err != nil {
+		log.Exitf("Reading queue failed: %v", err)
+	}
+	fmt.Printf("Queue %s: %s\n", state.Name, state.Description)
+	if h := state.Header; h.KiltVersion != "" {
+		fmt.Printf("Saved by kilt %s, operation schema %d\n", h.KiltVersion, h.Schema)
+	}
+	if state.Progress.Total > 0 {
+		fmt.Println(state.Progress)
+	}
+	if state.Empty() {
+		fmt.Println("No saved operations.")
+		return
+	}
+	printItems("Current", state.Current.Items)
+	printItems("Done", state.Done.Items)
+	printItems("Queued", state.Queue.Items)
+}
+
+func runQueueClear(cmd *cobra.Command, args []string) {
+	if err := rework.ClearQueue(openQueueRepo(), args[0], queueFlags.force); err != nil {
+		log.Exitf("Clearing queue failed: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var rebaseCmd = &cobra.Command{
+	Use:   "rebase --onto <rev>",
+	Short: "Rebase the kilt branch onto a new base",
+	Long: `Rebuild the kilt branch on top of a new base, such as a new upstream snapshot.
+The rebase is a rework which checks out the new base and replays every
+patchset in order. Conflicts are resolved the same way as in any other rework,
+using kilt rework --continue, --skip or --abort.
+
+The kilt base is moved to the new base once all patchsets have been replayed.
+Aborting the rebase leaves both the branch and its base unchanged.`,
+	Args: argsRebase,
+	Run:  runRebase,
+}
+
+var rebaseFlags = struct {
+	onto string
+}{}
+
+func init() {
+	rootCmd.AddCommand(rebaseCmd)
+	rebaseCmd.Flags().StringVar(&rebaseFlags.onto, "onto", "", "revision to rebase the branch onto")
+}
+
+func argsRebase(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	if rebaseFlags.onto == "" {
+		return errors.New("--onto is required")
+	}
+	return nil
+}
+
+func runRebase(cmd *cobra.Command, args []string) {
+	c, err := rework.NewRebaseCommand(rebaseFlags.onto)
+	if err != nil {
+		log.Exitf("Rebase failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Rebase failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var renameCmd = &cobra.Command{
+	Use:   "rename <patchset> <new name>",
+	Short: "Rename a patchset",
+	Long: `Rename a patchset in the kilt branch. The branch is rewritten through a
+rework which renames the metadata commit of the patchset, rewrites the
+Patchset-Name footer of each of its patches, and updates the patchset
+dependencies to refer to the new name.
+
+If the rework stops due to conflicts, resolve them and use kilt rework
+--continue to complete the rename.`,
+	Args: argsRename,
+	Run:  runRename,
+}
+
+func init() {
+	rootCmd.AddCommand(renameCmd)
+}
+
+func argsRename(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("a patchset name and a new name are required")
+	}
+	return nil
+}
+
+func runRename(cmd *cobra.Command, args []string) {
+	c, err := rework.NewRenameCommand(args[0], args[1])
+	if err != nil {
+		log.Exitf("Rename failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Rename failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/cmd/kilt/internal/editor"
+	"github.com/google/kilt/pkg/rework"
+)
+
+var reorderCmd = &cobra.Command{
+	Use:   "reorder (<patchset>... | --edit)",
+	Short: "Reorder the patchsets of the branch",
+	Long: `Reorder the patchsets of the kilt branch. The new order lists every patchset
+once, either as arguments or, with --edit, by editing the current order in the
+editor given by $GIT_EDITOR, $VISUAL or $EDITOR.
+
+The branch is rewritten through a rework which checks out the last patchset
+whose position is unchanged, or the base, and applies the following patchsets
+in their new order. Orders placing a patchset before one it depends on are
+refused.
+
+If the rework stops due to conflicts, resolve them and use kilt rework
+--continue to complete the reorder.`,
+	Args: argsReorder,
+	Run:  runReorder,
+}
+
+var reorderFlags = struct {
+	edit bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(reorderCmd)
+	reorderCmd.Flags().BoolVar(&reorderFlags.edit, "edit", false, "edit the order of the patchsets in an editor")
+}
+
+func argsReorder(cmd *cobra.Command, args []string) error {
+	if reorderFlags.edit == (len(args) > 0) {
+		return errors.New("exactly one of a list of patchsets or --edit must be specified")
+	}
+	return nil
+}
+
+func editOrder(text []byte) ([]byte, error) {
+	return editor.Edit("order", text)
+}
+
+func runReorder(cmd *cobra.Command, args []string) {
+	var c *rework.Command
+	var err error
+	if reorderFlags.edit {
+		c, err = rework.NewEditReorderCommand(editOrder)
+	} else {
+		c, err = rework.NewReorderCommand(args)
+	}
+	if errors.Is(err, rework.ErrNoChanges) {
+		fmt.Println("Order unchanged.")
+		return
+	}
+	if err != nil {
+		log.Exitf("Reorder failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Reorder failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
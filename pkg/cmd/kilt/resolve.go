@@ -0,0 +1,168 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+)
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve",
+	Short: "Resolve the conflicts of a stopped rework",
+	Long: `Help resolve the conflicts that stopped a rework or build. The patch that
+conflicted, its patchset and the conflicting files are listed, along with any
+conflict hints annotated on the patch, and git mergetool is launched on the
+conflicting files, using the tool configured with merge.tool unless --tool is
+given.
+
+Once no conflicts remain, the resolution is committed with the author and
+message of the patch, and the rest of the rework is performed as with kilt
+rework --continue. If rerere is enabled, the resolution is recorded for
+replaying when the same conflicts recur. Conflicts can also be resolved by
+hand and staged with git add before running kilt resolve.
+
+With --list, only the conflict is described.`,
+	Args: argsResolve,
+	Run:  runResolve,
+}
+
+var resolveFlags = struct {
+	list bool
+	tool string
+}{}
+
+func init() {
+	rootCmd.AddCommand(resolveCmd)
+	resolveCmd.Flags().BoolVarP(&resolveFlags.list, "list", "l", false, "only describe the conflict")
+	resolveCmd.Flags().StringVarP(&resolveFlags.tool, "tool", "t", "", "merge tool to use instead of the one configured with merge.tool")
+}
+
+func argsResolve(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func runResolve(cmd *cobra.Command, args []string) {
+	r, err := repo.Open()
+	if err != nil {
+		log.Exitf("Resolve failed: %v", err)
+	}
+	if ok, err := r.ReworkInProgress(); err != nil {
+		log.Exitf("Resolve failed: %v", err)
+	} else if !ok {
+		log.Exitf("Resolve failed: no rework in progress")
+	}
+	item, failed, err := rework.FailedOperation(r)
+	if err != nil {
+		log.Exitf("Resolve failed: %v", err)
+	} else if !failed {
+		log.Exitf("Resolve failed: no operation of the rework failed")
+	}
+	patch, ok := rework.AppliedPatch(item)
+	if !ok {
+		log.Exitf("Resolve failed: the failed operation %s doesn't apply a patch", item.Operation)
+	}
+	paths, err := r.ConflictedPaths()
+	if err != nil {
+		log.Exitf("Resolve failed: %v", err)
+	}
+	if err := describeConflict(r, patch, paths); err != nil {
+		log.Exitf("Resolve failed: %v", err)
+	}
+	if resolveFlags.list {
+		return
+	}
+	if len(paths) > 0 {
+		if err := runMergetool(r, paths); err != nil {
+			log.Exitf("Merge tool failed: %v\nResolve the remaining conflicts and run kilt resolve again.", err)
+		}
+		if paths, err = r.ConflictedPaths(); err != nil {
+			log.Exitf("Resolve failed: %v", err)
+		} else if len(paths) > 0 {
+			log.Exitf("Resolve failed: %d files still have conflicts", len(paths))
+		}
+	}
+	c, err := rework.NewResolveCommand()
+	if err != nil {
+		log.Exitf("Resolve failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Rework failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
+
+func describeConflict(r *repo.Repo, patch string, paths []string) error {
+	desc, err := r.DescribeCommit(patch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Conflicts applying %s\n", desc)
+	if name, err := r.CommitPatchset(patch); err != nil {
+		return err
+	} else if name != "" {
+		fmt.Printf("Patchset: %s\n", name)
+	}
+	if len(paths) > 0 {
+		fmt.Println("Conflicting files:")
+		for _, p := range paths {
+			fmt.Printf("\t%s\n", p)
+		}
+	}
+	hints, err := r.ConflictHints(patch)
+	if err != nil {
+		return err
+	}
+	if len(hints) > 0 {
+		fmt.Println("Conflict hints for this patch:")
+		for _, h := range hints {
+			fmt.Printf("\t%s\n", h)
+		}
+	}
+	return nil
+}
+
+// runMergetool runs git mergetool on the paths, attached to the terminal.
+func runMergetool(r *repo.Repo, paths []string) error {
+	if err := repo.Writable("run merge tool"); err != nil {
+		return err
+	}
+	args := []string{"mergetool"}
+	if resolveFlags.tool != "" {
+		args = append(args, "--tool="+resolveFlags.tool)
+	}
+	args = append(args, "--")
+	cmd := exec.Command("git", append(args, paths...)...)
+	cmd.Dir = r.Workdir()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package kilt
 
 import (
+	"fmt"
+
+	"github.com/google/kilt/pkg/cmd/kilt/internal/editor"
 	"github.com/google/kilt/pkg/rework"
 
 	log "github.com/golang/glog"
@@ -39,7 +42,124 @@ operations, such as re-ordering or merging patches.
 Once the user is finished, kilt will verify that the rework is valid, and
 modify the previous kilt branch to point to the result of the rework. A rework
 is considered valid if the end state is identical to the initial state -- the
-diff between them is empty.`,
+diff between them is empty.
+
+Rework state is kept per kilt branch, so a rework of one branch doesn't block
+checking out and working on another. While HEAD is detached and reworks of
+several branches are in progress, the rework started last is used.
+
+Patchsets are selected with --patchset, or by tag with --tag, which selects the
+patchsets whose Tags metadata field lists the tag, ignoring case.
+
+--patchset takes a patchset name or a selector expression, combining terms with
+&&, ||, ! and parentheses. The terms are all, floating, archived, a patchset
+name, name=<name>, name~<regexp>, tag:<tag> and depends(<patchset>), which
+selects the patchsets depending on the given one. For example:
+
+  -p 'name~^net-'
+  -p 'depends(base)'
+  -p 'floating && !tag:frozen'
+
+Commas separate several selectors, which select the patchsets matching any of
+them, so expressions can't contain commas.
+
+With --name, the rework is given a name or description, such as "5.15.3 base
+bump", which kilt status and the kilt journal show while the rework is in
+progress, so anyone landing in the repo can tell what it is for.
+
+With --interactive, the queued operations are opened in an editor before the
+rework begins, allowing them to be reordered, dropped, or added to, similar to
+git rebase -i.
+
+Floating patches whose subject starts with "fixup! " or "squash! ", or that
+carry a "Fixes-Patch: <commit or subject>" footer, are melded into the patch
+they refer to while reworking their patchset, like git rebase --autosquash,
+rather than being appended to the patchset. Fixups whose target isn't found in
+the patchset are appended as usual. Use --autosquash=false, or set the
+kilt.autosquash config option to false, to append them all instead.
+
+If an operation fails, for example with conflicts, resolve them and use
+--continue, or use --skip to skip the operation, discarding its changes, and
+carry on with the rest of the rework. Skipped operations are reported by
+kilt status until the rework is finished or aborted. kilt resolve describes the
+conflict, launches the merge tool and continues once it is resolved.
+
+Operations on a patchset, such as Rework or Apply, run a queue of steps of
+their own, one per patch. If a step fails, kilt status shows where, for example
+"Rework foo: step 3/7 (Apply abc1234)"; --continue resumes the patchset from
+that step, and --skip skips only that step rather than the whole patchset.
+
+Each operation is printed with its position among all of the rework's
+operations, including the steps of each patchset, for example "[34/120]
+Applying ...". kilt status shows the same count while a rework is paused. Use
+--quiet to only print notes and errors, or --verbose to include the name of each
+operation and the percentage complete.
+
+The rework queue is saved before and after every operation, so a rework
+interrupted by a crash can be resumed with --continue. An operation that was
+running when the process died is run again. Use --verify-state to check that
+the saved state is consistent.
+
+The saved queue records the kilt version that saved it and the schema of its
+operations, so a rework started before upgrading kilt can be continued after
+it; queues of an older schema are migrated. If the queue was saved by a newer
+kilt, or holds operations this kilt doesn't know, --continue stops and asks to
+finish the rework with the version that started it, or to abort it.
+
+Use --undo-last N to roll back the last N operations of a paused rework,
+moving the rework head back to where it was before them and queueing them to
+run again, for example after continuing past a mistaken conflict resolution.
+A failed operation is rolled back first without being counted. Only operations
+that move the rework head can be rolled back. Use --continue afterwards to
+run them again.
+
+With --auto, a notification can be sent when the rework completes or stops at
+the first conflict or error, so unattended reworks don't need to be watched. The
+shell command given with --notify-command, or the kilt.notifyCommand git config
+option, is run with the outcome as JSON on its standard input and in the
+KILT_COMMAND, KILT_BRANCH, KILT_OUTCOME and KILT_SUBJECT environment variables.
+The same JSON is posted to the webhook given with --notify-url or kilt.notifyURL.
+
+If the rerere.enabled git config option is set, conflict resolutions are
+recorded with git rerere, and replayed when the same conflicts recur in later
+reworks and builds, so that they don't need to be resolved again.
+
+Finishing a rework saves the previous state of the branch and its base under
+refs/kilt/<branch>/backup/<n>, keeping the last 10. Use --undo to restore the
+branch to its state before the most recently finished rework, including a
+forced finish. If the branch has changed since, --undo refuses unless --force
+is also given.
+
+If the kilt.versionRefs git config option is set, finishing a rework will record
+the version of each patchset as a ref named
+refs/kilt/<branch>/patchsets/<name>/v<version>, pointing at its last patch.
+
+Finishing a rework also points refs/kilt/<branch>/boundaries/<name> at the last
+patch of each patchset, so external tools such as CI can build the base and the
+patchsets up to a given one without understanding kilt. Refs of patchsets that
+no longer exist are removed. Set the kilt.boundaryRefs git config option to
+false to disable this.
+
+If a test command is configured for a patchset, it is run in the work tree with
+sh -c once the patchset is reworked or applied, and the rework stops if it
+fails, so breakage is caught at the patchset that caused it. The command is set
+by the kilt-patchset.<name>.testCommand git config option, else by
+kilt-tag.<tag>.testCommand for one of the patchset's tags, else by
+kilt.testCommand, and gets the patchset in KILT_PATCHSET. Fix the breakage and
+commit, then use --continue to test again, or use --skip to move on.
+
+If a patch can't be cherry-picked, for example due to line ending conversions
+or filters, kilt falls back to applying it with git apply --3way. Set the
+kilt.applyFallback git config option to false to disable this.
+
+Runs of patches applied as they are, as when applying a patchset or building,
+are cherry-picked in memory, only checking out the result once, which is much
+faster for large patchsets. A patch that conflicts, and the patches following
+it, are applied one at a time as usual. Set the kilt.inMemoryApply git config
+option to false to apply every patch in the work tree.
+
+In work trees using sparse-checkout, checkouts and cherry-picks are done with
+git so that only files within the sparse patterns are materialized.`,
 	Args: argsRework,
 	Run:  runRework,
 }
@@ -48,13 +168,23 @@ var reworkFlags = struct {
 	begin     bool
 	finish    bool
 	validate  bool
+	verify    bool
 	rContinue bool
 	abort     bool
 	skip      bool
 	force     bool
 	auto      bool
+	editQueue bool
+	interact  bool
+	squash    bool
+	undo      bool
+	undoLast  int
 	patchsets []string
+	tags      []string
+	name      string
 	all       bool
+	notifyCmd string
+	notifyURL string
 }{}
 
 func init() {
@@ -63,26 +193,73 @@ func init() {
 	reworkCmd.Flags().MarkHidden("begin")
 	reworkCmd.Flags().BoolVar(&reworkFlags.finish, "finish", false, "validate and finish rework")
 	reworkCmd.Flags().BoolVar(&reworkFlags.abort, "abort", false, "abort rework")
-	reworkCmd.Flags().BoolVarP(&reworkFlags.force, "force", "f", false, "when finishing, force finish rework, regardless of validation")
+	reworkCmd.Flags().BoolVarP(&reworkFlags.force, "force", "f", false, "when finishing, force finish rework, regardless of validation; when undoing, undo even if the branch changed since")
+	reworkCmd.Flags().BoolVar(&reworkFlags.undo, "undo", false, "restore the branch to its state before the most recently finished rework")
+	reworkCmd.Flags().IntVar(&reworkFlags.undoLast, "undo-last", 0, "roll back the last `N` operations of the rework in progress")
 	reworkCmd.Flags().BoolVar(&reworkFlags.validate, "validate", false, "validate rework")
+	reworkCmd.Flags().BoolVar(&reworkFlags.verify, "verify-state", false, "check that the saved rework state is consistent")
 	reworkCmd.Flags().BoolVar(&reworkFlags.rContinue, "continue", false, "continue rework")
-	reworkCmd.Flags().BoolVar(&reworkFlags.skip, "skip", false, "skip rework step")
+	reworkCmd.Flags().BoolVar(&reworkFlags.skip, "skip", false, "skip the failed or next rework step and continue")
+	reworkCmd.Flags().BoolVar(&reworkFlags.editQueue, "edit-queue", false, "edit the remaining operations of a paused rework")
+	reworkCmd.Flags().BoolVarP(&reworkFlags.interact, "interactive", "i", false, "edit the queued operations before beginning rework")
+	reworkCmd.Flags().BoolVar(&reworkFlags.squash, "autosquash", true, "meld floating fixup!, squash! and Fixes-Patch: patches into the patches they refer to (default from kilt.autosquash)")
 	reworkCmd.Flags().BoolVar(&reworkFlags.auto, "auto", false, "attempt to automatically complete rework")
-	reworkCmd.Flags().BoolVarP(&reworkFlags.all, "all", "a", false, "specify all patchsets for rework")
-	reworkCmd.Flags().StringSliceVarP(&reworkFlags.patchsets, "patchset", "p", nil, "specify individual patchset for rework")
+	reworkCmd.Flags().StringVar(&reworkFlags.notifyCmd, "notify-command", "", "with --auto, shell command to run when the rework completes or stops")
+	reworkCmd.Flags().StringVar(&reworkFlags.notifyURL, "notify-url", "", "with --auto, webhook URL to post to when the rework completes or stops")
+	reworkCmd.Flags().BoolVarP(&reworkFlags.all, "all", "a", false, "specify all patchsets for rework, except archived ones")
+	reworkCmd.Flags().StringSliceVarP(&reworkFlags.patchsets, "patchset", "p", nil, "specify a patchset or selector expression for rework")
+	reworkCmd.Flags().StringSliceVar(&reworkFlags.tags, "tag", nil, "specify the patchsets with a tag for rework")
+	reworkCmd.Flags().StringVar(&reworkFlags.name, "name", "", "describe what a new rework is for, as shown by kilt status")
 }
 
 func argsRework(*cobra.Command, []string) error {
 	return nil
 }
 
+func editQueue(text []byte) ([]byte, error) {
+	return editor.Edit("rework-queue", text)
+}
+
 func runRework(cmd *cobra.Command, args []string) {
+	if reworkFlags.verify {
+		problems, err := rework.VerifyState()
+		if err != nil {
+			log.Exitf("Verifying rework state failed: %v", err)
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			log.Exitf("Rework state is inconsistent")
+		}
+		fmt.Println("Rework state is consistent.")
+		return
+	}
+	if reworkFlags.editQueue {
+		if err := rework.EditQueue(editQueue); err != nil {
+			log.Exitf("Editing queue failed: %v", err)
+		}
+		return
+	}
+	if reworkFlags.undoLast > 0 {
+		c, err := rework.NewUndoLastCommand(reworkFlags.undoLast)
+		if err != nil {
+			log.Exitf("Rolling back rework failed: %v", err)
+		}
+		if err = c.Save(); err != nil {
+			log.Exitf("Failed to save rework state: %v", err)
+		}
+		return
+	}
 	var c *rework.Command
 	var err error
 	switch {
 	case reworkFlags.finish:
 		reworkFlags.auto = true
 		c, err = rework.NewFinishCommand(reworkFlags.force)
+	case reworkFlags.undo:
+		reworkFlags.auto = true
+		c, err = rework.NewUndoCommand(reworkFlags.force)
 	case reworkFlags.abort:
 		c, err = rework.NewAbortCommand()
 	case reworkFlags.skip:
@@ -95,12 +272,32 @@ func runRework(cmd *cobra.Command, args []string) {
 		targets := []rework.TargetSelector{rework.FloatingTargets{}}
 		if reworkFlags.all {
 			targets = append(targets, rework.AllTargets{})
-		} else if len(reworkFlags.patchsets) > 0 {
+		} else {
 			for _, p := range reworkFlags.patchsets {
-				targets = append(targets, rework.PatchsetTarget{Name: p})
+				t, pErr := rework.ParseTarget(p)
+				if pErr != nil {
+					log.Exitf("Rework failed: %v", pErr)
+				}
+				targets = append(targets, t)
+			}
+			for _, t := range reworkFlags.tags {
+				targets = append(targets, rework.TagTarget{Tag: t})
 			}
 		}
 		c, err = rework.NewBeginCommand(targets...)
+		squash := reworkFlags.squash
+		if err == nil && !cmd.Flags().Changed("autosquash") {
+			squash, err = c.AutosquashByDefault()
+		}
+		if err == nil && squash {
+			err = c.Autosquash()
+		}
+		if err == nil && reworkFlags.name != "" {
+			err = c.SetName(reworkFlags.name)
+		}
+		if err == nil && reworkFlags.interact {
+			err = c.Edit(editQueue)
+		}
 	default:
 		log.Exitf("No operation specified")
 	}
@@ -109,6 +306,9 @@ func runRework(cmd *cobra.Command, args []string) {
 	}
 	if reworkFlags.auto {
 		err = c.ExecuteAll()
+		if nErr := c.Notify("rework", reworkFlags.notifyCmd, reworkFlags.notifyURL, err); nErr != nil {
+			log.Warningf("Failed to send notification: %v", nErr)
+		}
 	} else {
 		err = c.Execute()
 	}
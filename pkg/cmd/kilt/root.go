@@ -18,22 +18,139 @@ limitations under the License.
 package kilt
 
 import (
+	"errors"
+	"os"
+
 	log "github.com/golang/glog"
 	"github.com/spf13/cobra"
 
 	"github.com/google/kilt/pkg/cmd/kilt/internal/flag"
+	"github.com/google/kilt/pkg/internal/failpoint"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/reporter"
+	"github.com/google/kilt/pkg/rework"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "kilt",
 	Short: "kilt is a patchset management tool",
-	Long:  "kilt is a tool for managing patches and patchsets.",
+	Long: `kilt is a tool for managing patches and patchsets.
+
+Commands that modify the branch lock the repo, so that concurrent kilt
+processes don't corrupt each other's state. A lock left by a process that is
+no longer running is replaced automatically; --break-lock removes a lock
+regardless, for example one left on a shared file system by another host.
+
+With --read-only, or the KILT_READ_ONLY environment variable set to 1, any
+attempt to create or update refs, commits, the work tree or kilt state files
+fails, so that audits and reports can safely run kilt against any repo.
+
+Malformed kilt metadata on a branch, such as a metadata commit missing a field
+or a patch of a patchset with no metadata commit, is skipped or worked around
+with a warning, so that commands keep working on messy branches. With --strict,
+or the KILT_STRICT environment variable set to 1, the first malformed metadata
+found fails the command instead, so that CI rejects it. kilt verify always
+reports malformed metadata as a problem.
+
+Each command run in a repo is recorded in a local journal, which kilt journal
+report summarizes. Nothing in the journal leaves the machine.
+
+Patches that kilt rewrites keep their original committer and commit date by
+default. With --reset-committer, the current user becomes the committer with
+the current date, as in git rebase; --committer-date-is-author-date also uses
+the current user, but keeps the author date as the commit date. The default can
+be set with the kilt.committer config option to preserve, reset or author-date.
+New commits, such as metadata commits, honor the GIT_AUTHOR_* and
+GIT_COMMITTER_* environment variables for their identity and dates.
+
+When an applied patch changes the commit a submodule records, or changes
+.gitmodules, kilt runs git submodule sync and update for initialized
+submodules. Setting kilt.submoduleUpdate to false leaves the submodules alone
+and warns about those left out of date instead.`,
+	PersistentPreRunE:  setup,
+	PersistentPostRunE: teardown,
+}
+
+var rootFlags = struct {
+	report              string
+	breakLock           bool
+	readOnly            bool
+	strict              bool
+	resetCommitter      bool
+	committerAuthorDate bool
+	quiet               bool
+	verbose             bool
+	simulateCrash       []string
+}{}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&rootFlags.report, "report", "text", "format of operation messages: text, json or quiet")
+	rootCmd.PersistentFlags().BoolVar(&rootFlags.readOnly, "read-only", false, "fail any attempt to modify refs, commits, the work tree or kilt state")
+	rootCmd.PersistentFlags().BoolVar(&rootFlags.strict, "strict", false, "fail on malformed kilt metadata rather than skipping it with a warning")
+	rootCmd.PersistentFlags().BoolVar(&rootFlags.resetCommitter, "reset-committer", false, "make the current user the committer of rewritten commits, with the current date")
+	rootCmd.PersistentFlags().BoolVar(&rootFlags.committerAuthorDate, "committer-date-is-author-date", false, "make the current user the committer of rewritten commits, with the author date")
+	rootCmd.PersistentFlags().BoolVar(&rootFlags.breakLock, "break-lock", false, "remove the repo lock held by another kilt process before running")
+	rootCmd.PersistentFlags().BoolVarP(&rootFlags.quiet, "quiet", "q", false, "only print notes and errors, not each operation")
+	rootCmd.PersistentFlags().BoolVar(&rootFlags.verbose, "verbose", false, "print the name and percentage complete with each operation")
+	rootCmd.PersistentFlags().StringSliceVar(&rootFlags.simulateCrash, "simulate-crash", nil, "exit abruptly at the given failpoints (name or name@n), for testing recovery")
+	rootCmd.PersistentFlags().MarkHidden("simulate-crash")
+}
+
+func setup(cmd *cobra.Command, args []string) error {
+	failpoint.Enable(rootFlags.simulateCrash...)
+	if rootFlags.readOnly {
+		repo.SetReadOnly(true)
+	}
+	if rootFlags.strict {
+		repo.SetParseMode(repo.Strict)
+	}
+	startJournal(cmd)
+	switch {
+	case rootFlags.resetCommitter && rootFlags.committerAuthorDate:
+		return errors.New("--reset-committer and --committer-date-is-author-date can't be used together")
+	case rootFlags.resetCommitter:
+		repo.SetCommitterMode(repo.ResetCommitter)
+	case rootFlags.committerAuthorDate:
+		repo.SetCommitterMode(repo.CommitterDateIsAuthorDate)
+	}
+	if rootFlags.breakLock {
+		if err := rework.BreakLock(); err != nil {
+			return err
+		}
+	}
+	return setupReporter(cmd, args)
+}
+
+func teardown(cmd *cobra.Command, args []string) error {
+	finishJournal(nil)
+	return rework.ReleaseLocks()
+}
+
+func setupReporter(cmd *cobra.Command, args []string) error {
+	if rootFlags.quiet && rootFlags.verbose {
+		return errors.New("--quiet and --verbose can't be used together")
+	}
+	rep, err := reporter.New(rootFlags.report, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if c, ok := rep.(*reporter.Console); ok {
+		switch {
+		case rootFlags.quiet:
+			c.SetVerbosity(reporter.Terse)
+		case rootFlags.verbose:
+			c.SetVerbosity(reporter.Verbose)
+		}
+	}
+	rework.SetDefaultReporter(rep)
+	return nil
 }
 
 // Execute is the entry point into subcommand processing.
 func Execute() {
 	flag.AddFlags()
 	if err := rootCmd.Execute(); err != nil {
+		finishJournal(err)
 		log.Exitf("Error: %s", err)
 	}
 }
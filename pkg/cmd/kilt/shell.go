@@ -0,0 +1,60 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+	"os"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/shell"
+)
+
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Start an interactive kilt session",
+	Long: `Start an interactive session that keeps the repo and its patchsets loaded
+across commands, avoiding walking the branch for every command. Patchsets can be
+listed and shown, and reworks can be stepped through one operation at a time.
+Commands and patchset names are completed with tab. Use help to list the
+commands, and quit or end of input to leave the session.`,
+	Args: argsShell,
+	Run:  runShell,
+}
+
+func init() {
+	rootCmd.AddCommand(shellCmd)
+}
+
+func argsShell(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func runShell(cmd *cobra.Command, args []string) {
+	s, err := shell.New()
+	if err != nil {
+		log.Exitf("Shell failed: %v", err)
+	}
+	if err := s.Run(os.Stdin); err != nil {
+		log.Exitf("Shell failed: %v", err)
+	}
+}
@@ -29,25 +29,97 @@ var showCmd = &cobra.Command{
 	Short: "Print information about a specific patchset",
 	Long: `Display information about a patchset, showing the user patchset metadata
 (version, UUID, name), the id and a short description of each component patch
-of the patchset, as well as any floating patches that belong to the patchset.`,
+of the patchset, as well as any floating patches that belong to the patchset.
+
+With --json, the patchsets are printed as a JSON array, including the author,
+date, diffstat, trailers and note of every patch.
+
+With --notes, the review notes attached to patches with kilt note are printed
+under each patch.
+
+With --diff, the combined diff of the patches of the patchset is printed
+instead, from the metadata commit to the last patch. With --stat, a diffstat of
+the combined changes is printed.
+
+With --at, the patchsets are shown as they were at the given commit, such as a
+release tag or a previous state of the branch. The base of the patch stack at
+that commit is found from the branch's backups or its metadata commits, or can
+be given with --base.
+
+With --branch-view, the kilt branch is read even while a rework is in
+progress, rather than the rework head, so scripts and other read-only consumers
+see the branch state.`,
 	Args: argsShow,
 	Run:  runShow,
 }
 
+var showFlags = struct {
+	json  bool
+	diff  bool
+	notes bool
+	stat  bool
+	at    string
+	base  string
+
+	branchView bool
+}{}
+
 func init() {
 	rootCmd.AddCommand(showCmd)
+	showCmd.Flags().BoolVar(&showFlags.json, "json", false, "print patchset information as JSON")
+	showCmd.Flags().BoolVar(&showFlags.diff, "diff", false, "print the combined diff of the patchset")
+	showCmd.Flags().BoolVar(&showFlags.stat, "stat", false, "print a diffstat of the combined changes of the patchset")
+	showCmd.Flags().BoolVar(&showFlags.notes, "notes", false, "print the review notes of each patch")
+	showCmd.Flags().StringVar(&showFlags.at, "at", "", "show the patchset as it was at the given commit")
+	showCmd.Flags().StringVar(&showFlags.base, "base", "", "base of the patch stack at the --at commit")
+	showCmd.Flags().BoolVar(&showFlags.branchView, "branch-view", false, "read the kilt branch rather than the head of a rework in progress")
 }
 
 func argsShow(cmd *cobra.Command, args []string) error {
 	if len(args) == 0 {
 		return errors.New("at least one patchset name is required")
 	}
+	if showFlags.json && (showFlags.diff || showFlags.stat) {
+		return errors.New("--json can't be used with --diff or --stat")
+	}
+	if showFlags.notes && (showFlags.json || showFlags.diff || showFlags.stat) {
+		return errors.New("--notes can't be used with --json, --diff or --stat")
+	}
+	if showFlags.base != "" && showFlags.at == "" {
+		return errors.New("--base can only be used with --at")
+	}
+	if showFlags.branchView && showFlags.at != "" {
+		return errors.New("--branch-view can't be used with --at")
+	}
 	return nil
 }
 
 func runShow(cmd *cobra.Command, args []string) {
+	r, err := show.Open(showFlags.at, showFlags.base, showFlags.branchView)
+	if err != nil {
+		log.Exitf("Error: %v", err)
+	}
+	if showFlags.json {
+		if err := show.PatchsetsJSON(r, args); err != nil {
+			log.Exitf("Error: %v", err)
+		}
+		return
+	}
+	if showFlags.diff || showFlags.stat {
+		for _, arg := range args {
+			if err := show.Diff(r, arg, showFlags.stat, showFlags.diff); err != nil {
+				log.Exitf("Error: %v", err)
+			}
+		}
+		return
+	}
 	for _, arg := range args {
-		if err := show.Patchset(arg); err != nil {
+		if showFlags.notes {
+			err = show.PatchsetWithNotesFromRepo(r, arg)
+		} else {
+			err = show.PatchsetFromRepo(r, arg)
+		}
+		if err != nil {
 			log.Exitf("Error: %v", err)
 		}
 	}
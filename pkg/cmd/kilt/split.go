@@ -0,0 +1,88 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var splitCmd = &cobra.Command{
+	Use:   "split <patchset> --at <commit> --new-name <name>",
+	Short: "Split a patchset into two",
+	Long: `Split a patchset into two. The branch is rewritten through a rework which
+keeps the patches before the --at commit in the patchset, with a bumped
+version, and reassigns the --at commit and the patches after it to a new
+patchset, created right after the original, by rewriting their Patchset-Name
+footer.
+
+The new patchset depends on the original, and on the patchsets the original
+depends on. The patchsets depending on the original also depend on the new
+patchset with --dependents copy, the default, or depend on the new patchset
+instead of the original with --dependents move.
+
+If the rework stops due to conflicts, resolve them and use kilt rework
+--continue to complete the split.`,
+	Args: argsSplit,
+	Run:  runSplit,
+}
+
+var splitFlags = struct {
+	at         string
+	newName    string
+	dependents string
+}{}
+
+func init() {
+	rootCmd.AddCommand(splitCmd)
+	splitCmd.Flags().StringVar(&splitFlags.at, "at", "", "first patch of the new patchset")
+	splitCmd.Flags().StringVar(&splitFlags.newName, "new-name", "", "name of the new patchset")
+	splitCmd.Flags().StringVar(&splitFlags.dependents, "dependents", rework.CopyDependents, "how to update the patchsets depending on the original: copy or move")
+}
+
+func argsSplit(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one patchset name is required")
+	}
+	if splitFlags.at == "" {
+		return errors.New("the first patch of the new patchset must be specified with --at")
+	}
+	if splitFlags.newName == "" {
+		return errors.New("a name for the new patchset must be specified with --new-name")
+	}
+	if splitFlags.dependents != rework.CopyDependents && splitFlags.dependents != rework.MoveDependents {
+		return errors.New("--dependents must be copy or move")
+	}
+	return nil
+}
+
+func runSplit(cmd *cobra.Command, args []string) {
+	c, err := rework.NewSplitCommand(args[0], splitFlags.at, splitFlags.newName, splitFlags.dependents)
+	if err != nil {
+		log.Exitf("Split failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Split failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
@@ -17,8 +17,13 @@ limitations under the License.
 package kilt
 
 import (
+	"errors"
+	"fmt"
+	"os"
+
 	log "github.com/golang/glog"
 	"github.com/spf13/cobra"
+	"github.com/google/kilt/pkg/repo"
 	"github.com/google/kilt/pkg/status"
 )
 
@@ -34,21 +39,116 @@ that the user could take.
 
 If a rework is not in progress, status will display any suggested fixes
 that the user should make to the kilt branch, including reworking floating
-patches or assigning unknown patches to a patchset.`,
+patches or assigning unknown patches to a patchset.
+
+With --check-base <ref>, status will instead compare the kilt base against the
+given upstream ref, reporting how far behind the base is and which patchsets
+touch files that have changed upstream.
+
+With --format json or --format porcelain, status will print the state of the
+kilt branch in a stable, machine-readable form for use by scripts, including
+the remaining rework queue and the floating patches of each patchset.
+
+With --branch-view, the patchsets of the kilt branch are reported even while a
+rework is in progress, rather than those of the rework head. The rework queue
+is still included in the machine-readable formats.
+
+With --suggest-commands, status prints the commands fixing each problem found,
+such as reworking patchsets with floating patches, assigning unknown patches to
+a patchset or pruning dependencies on missing patchsets, as a block that can be
+pasted into a shell; placeholders in angle brackets need to be filled in first.
+With --apply-suggestions, the safe ones, pruning dependencies and reworking
+patchsets, are run directly, while the rest are still printed.`,
 	Args: argsStatus,
 	Run:  runStatus,
 }
 
+var statusFlags = struct {
+	checkBase  string
+	fetch      bool
+	format     string
+	branchView bool
+	suggest    bool
+	apply      bool
+}{}
+
 func init() {
 	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVar(&statusFlags.checkBase, "check-base", "", "report drift of the kilt base against the given upstream ref")
+	statusCmd.Flags().StringVar(&statusFlags.format, "format", "", "print status in a machine-readable format: json or porcelain")
+	statusCmd.Flags().BoolVar(&statusFlags.fetch, "fetch", false, "when checking the base, fetch the upstream ref first")
+	statusCmd.Flags().BoolVar(&statusFlags.suggest, "suggest-commands", false, "print the commands fixing the problems found")
+	statusCmd.Flags().BoolVar(&statusFlags.apply, "apply-suggestions", false, "run the safe suggested commands, and print the rest")
+	statusCmd.Flags().BoolVar(&statusFlags.branchView, "branch-view", false, "report the kilt branch rather than the head of a rework in progress")
 }
 
 func argsStatus(cmd *cobra.Command, args []string) error {
+	if statusFlags.fetch && statusFlags.checkBase == "" {
+		return errors.New("--fetch requires --check-base")
+	}
+	switch statusFlags.format {
+	case "", "json", "porcelain":
+	default:
+		return fmt.Errorf("unknown format %q", statusFlags.format)
+	}
+	if statusFlags.format != "" && statusFlags.checkBase != "" {
+		return errors.New("--format can't be used with --check-base")
+	}
+	if statusFlags.branchView && statusFlags.checkBase != "" {
+		return errors.New("--branch-view can't be used with --check-base")
+	}
+	if (statusFlags.suggest || statusFlags.apply) && (statusFlags.checkBase != "" || statusFlags.format != "" || statusFlags.branchView) {
+		return errors.New("--suggest-commands and --apply-suggestions can't be used with --check-base, --format or --branch-view")
+	}
 	return nil
 }
 
 func runStatus(cmd *cobra.Command, args []string) {
-	if err := status.Print(); err != nil {
+	if statusFlags.checkBase != "" {
+		if err := status.CheckBase(statusFlags.checkBase, statusFlags.fetch); err != nil {
+			log.Exitf("Error: %v", err)
+		}
+		return
+	}
+	r, err := status.Open(statusFlags.branchView)
+	if err != nil {
+		log.Exitf("Error: %v", err)
+	}
+	if statusFlags.suggest || statusFlags.apply {
+		suggestCommands(r)
+		return
+	}
+	switch statusFlags.format {
+	case "json":
+		err = status.PrintJSON(r)
+	case "porcelain":
+		err = status.PrintPorcelain(r)
+	default:
+		err = status.PrintFromRepo(r)
+	}
+	if err != nil {
 		log.Exitf("Error: %v", err)
 	}
 }
+
+func suggestCommands(r *repo.Repo) {
+	suggestions, err := status.Suggestions(r)
+	if err != nil {
+		log.Exitf("Error: %v", err)
+	}
+	if statusFlags.apply {
+		c, err := status.ApplySuggestions(r, suggestions)
+		if err != nil {
+			log.Errorf("Applying suggestions failed: %v", err)
+		}
+		if c != nil {
+			if err := c.Save(); err != nil {
+				log.Exitf("Failed to save rework state: %v", err)
+			}
+		}
+		if suggestions, err = status.Suggestions(r); err != nil {
+			log.Exitf("Error: %v", err)
+		}
+	}
+	status.WriteSuggestions(os.Stdout, suggestions)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/rework"
+)
+
+var trailerCmd = &cobra.Command{
+	Use:   "trailer <key> <value>",
+	Short: "Set a trailer on the patches of patchsets",
+	Long: `Set a trailer on every patch of the selected patchsets, such as adding
+Reviewed-on links after an import. The branch is rewritten through a rework
+which only changes the commit messages of the patches; the patchset versions
+are left unchanged.
+
+Existing trailers with the same key are replaced, unless --add is used.
+
+If the rework stops, use kilt rework --continue to complete it.`,
+	Args: argsTrailer,
+	Run:  runTrailer,
+}
+
+var trailerFlags = struct {
+	add       bool
+	all       bool
+	patchsets []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(trailerCmd)
+	trailerCmd.Flags().BoolVar(&trailerFlags.add, "add", false, "add the trailer even if the patch already has one with the same key")
+	trailerCmd.Flags().BoolVarP(&trailerFlags.all, "all", "a", false, "set the trailer on all patchsets")
+	trailerCmd.Flags().StringSliceVarP(&trailerFlags.patchsets, "patchset", "p", nil, "specify individual patchset to set the trailer on")
+}
+
+func argsTrailer(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("a trailer key and value are required")
+	}
+	if trailerFlags.all == (len(trailerFlags.patchsets) > 0) {
+		return errors.New("exactly one of --all or --patchset must be specified")
+	}
+	return nil
+}
+
+func runTrailer(cmd *cobra.Command, args []string) {
+	c, err := rework.NewTrailerCommand(trailerFlags.patchsets, args[0], args[1], trailerFlags.add)
+	if err != nil {
+		log.Exitf("Trailer failed: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		log.Errorf("Trailer failed: %v", err)
+	}
+	if err = c.Save(); err != nil {
+		log.Exitf("Failed to save rework state: %v", err)
+	}
+}
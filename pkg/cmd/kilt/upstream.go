@@ -0,0 +1,56 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/verify"
+)
+
+var compareUpstreamCmd = &cobra.Command{
+	Use:   "compare-upstream <patchset> <upstream-ref>",
+	Short: "Report which patches of a patchset have landed upstream",
+	Long: `Match the patches of a patchset against the commits on an upstream ref since
+the kilt base, and report each patch as merged, modified upstream, or pending.
+
+Patches are matched by patch id first, so a patch merged unchanged is reported
+as merged even if applied to a different base. Patches without a patch id match
+are matched by their summary line, and reported as modified upstream.`,
+	Args: argsCompareUpstream,
+	Run:  runCompareUpstream,
+}
+
+func init() {
+	rootCmd.AddCommand(compareUpstreamCmd)
+}
+
+func argsCompareUpstream(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("a patchset and an upstream ref are required")
+	}
+	return nil
+}
+
+func runCompareUpstream(cmd *cobra.Command, args []string) {
+	if err := verify.CompareUpstream(args[0], args[1]); err != nil {
+		log.Exitf("Compare failed: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/verify"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [--compare <branch>]",
+	Short: "Verify kilt branches",
+	Long: `Verify kilt branches.
+
+Without --compare, the current kilt branch is checked for structural problems:
+duplicate metadata commits, patches whose Patchset-Name has no metadata commit,
+malformed metadata commits, patchset versions lower than a version recorded
+earlier, dependencies on patchsets that are not on the branch, and refs under
+refs/kilt that belong to no kilt branch or rework. Each problem is printed, or with --json a report is
+printed for tools such as CI checks. The exit status is non-zero if any problem
+was found.
+
+With --compare, the patchsets of the current kilt branch are compared with the
+patchsets of another kilt branch, such as a parallel release branch carrying
+the same patchsets. Patchsets present on only one of the branches, version
+mismatches, and patches whose content has drifted are reported. Patch content
+is compared by patch id, so the same change applied to different bases is
+considered equal.`,
+	Args: argsVerify,
+	Run:  runVerify,
+}
+
+var verifyFlags = struct {
+	compare string
+	json    bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVar(&verifyFlags.compare, "compare", "", "kilt branch to compare the current branch with")
+	verifyCmd.Flags().BoolVar(&verifyFlags.json, "json", false, "print the consistency report as JSON")
+}
+
+func argsVerify(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	if verifyFlags.compare != "" && verifyFlags.json {
+		return errors.New("--json can't be used with --compare")
+	}
+	return nil
+}
+
+func runVerify(cmd *cobra.Command, args []string) {
+	if verifyFlags.compare == "" {
+		if err := verify.Check(verifyFlags.json); err != nil {
+			log.Exitf("Verify failed: %v", err)
+		}
+		return
+	}
+	if err := verify.Compare(verifyFlags.compare); err != nil {
+		log.Exitf("Verify failed: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	log "github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/google/kilt/pkg/workspace"
+)
+
+var workspaceCmd = &cobra.Command{
+	Use:     "workspace",
+	Aliases: []string{"ws"},
+	Short:   "Run kilt operations across the repos of a workspace",
+	Long: `Run kilt operations across several kilt managed repos at once.
+
+The repos of a workspace are listed in a kilt-workspace.json file, which is
+looked up in the current directory and its parents:
+
+	{
+	  "repos": [
+	    {"name": "kernel", "path": "kernel", "base": "v5.4", "patchsets": ["a", "b"]},
+	    {"path": "firmware"}
+	  ]
+	}
+
+Paths are relative to the config file, and the name defaults to the last path
+element. The base and patchsets are only needed by kilt ws build.
+
+Each operation runs in every repo in turn, carrying on after failures, and
+fails at the end if it failed in any repo.`,
+}
+
+var wsStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print a summary of the kilt branch of every repo",
+	Args:  argsWorkspace,
+	Run:   runWorkspaceStatus,
+}
+
+var wsBuildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Build the configured patchsets in every repo",
+	Long: `Build the patchsets configured for each repo on its configured base, as kilt
+build would. Repos without a base or patchsets are skipped. Use --manifest to
+write the combined manifest after building.`,
+	Args: argsWorkspace,
+	Run:  runWorkspaceBuild,
+}
+
+var wsManifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Print the combined manifest of all repos",
+	Long: `Print a JSON manifest recording the head, kilt branch, base and patchset
+versions of every repo of the workspace, along with the commit each submodule
+records and the commit checked out in it.`,
+	Args: argsWorkspace,
+	Run:  runWorkspaceManifest,
+}
+
+var workspaceFlags = struct {
+	manifest string
+}{}
+
+func init() {
+	rootCmd.AddCommand(workspaceCmd)
+	workspaceCmd.AddCommand(wsStatusCmd)
+	workspaceCmd.AddCommand(wsBuildCmd)
+	workspaceCmd.AddCommand(wsManifestCmd)
+	wsBuildCmd.Flags().StringVar(&workspaceFlags.manifest, "manifest", "", "file to write the combined manifest to after building")
+	wsManifestCmd.Flags().StringVarP(&workspaceFlags.manifest, "output", "o", "", "file to write the manifest to instead of stdout")
+}
+
+func argsWorkspace(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+func findWorkspace() *workspace.Workspace {
+	w, err := workspace.Find()
+	if err != nil {
+		log.Exitf("Failed to load workspace: %v", err)
+	}
+	return w
+}
+
+func runWorkspaceStatus(cmd *cobra.Command, args []string) {
+	results, err := findWorkspace().Status()
+	if err == nil {
+		err = workspace.Failed(results)
+	}
+	if err != nil {
+		log.Exitf("Workspace status failed: %v", err)
+	}
+}
+
+func runWorkspaceBuild(cmd *cobra.Command, args []string) {
+	w := findWorkspace()
+	results, err := w.Build()
+	if err == nil {
+		err = workspace.Failed(results)
+	}
+	if err != nil {
+		log.Exitf("Workspace build failed: %v", err)
+	}
+	if workspaceFlags.manifest != "" {
+		writeManifest(w, workspaceFlags.manifest)
+	}
+}
+
+func runWorkspaceManifest(cmd *cobra.Command, args []string) {
+	writeManifest(findWorkspace(), workspaceFlags.manifest)
+}
+
+func writeManifest(w *workspace.Workspace, file string) {
+	manifest, results, err := w.Manifest()
+	if err == nil {
+		err = workspace.Failed(results)
+	}
+	if err != nil {
+		log.Exitf("Workspace manifest failed: %v", err)
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Exitf("Workspace manifest failed: %v", err)
+	}
+	if file == "" {
+		fmt.Println(string(b))
+		return
+	}
+	if err = ioutil.WriteFile(file, append(b, '\n'), 0666); err != nil {
+		log.Exitf("Failed to write manifest: %v", err)
+	}
+}
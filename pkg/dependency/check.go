@@ -0,0 +1,88 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dependency
+
+import (
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// Insufficient describes a patchset that doesn't apply on top of only its
+// declared dependencies.
+type Insufficient struct {
+	Patchset string
+	// Dependencies are the names of the transitive dependencies of Patchset,
+	// in the order they were applied.
+	Dependencies []string
+	// Patch is the first patch that didn't apply, and In the patchset it
+	// belongs to, which is either Patchset or one of its dependencies.
+	Patch, In string
+}
+
+// Check verifies the declared dependencies by applying each patchset in
+// memory on top of the kilt base and its transitive dependencies alone,
+// returning the patchsets that fail to apply, in branch order.
+func Check(r *repo.Repo) ([]Insufficient, error) {
+	d, err := Load(r)
+	if err != nil {
+		return nil, err
+	}
+	var result []Insufficient
+	for _, ps := range d.patchsets.Slice {
+		if len(ps.Patches()) == 0 {
+			continue
+		}
+		order := d.applyOrder(ps)
+		var patches []string
+		owner := map[string]string{}
+		for _, p := range order {
+			for _, patch := range p.Patches() {
+				patches = append(patches, patch)
+				owner[patch] = p.Name()
+			}
+		}
+		patch, err := r.ApplyPatches(r.KiltBase(), patches)
+		if err != nil {
+			return nil, err
+		}
+		if patch == "" {
+			continue
+		}
+		var names []string
+		for _, p := range order[:len(order)-1] {
+			names = append(names, p.Name())
+		}
+		result = append(result, Insufficient{Patchset: ps.Name(), Dependencies: names, Patch: patch, In: owner[patch]})
+	}
+	return result, nil
+}
+
+// applyOrder returns the transitive dependencies of the patchset in branch
+// order, followed by the patchset itself.
+func (d *StructGraph) applyOrder(ps *patchset.Patchset) []*patchset.Patchset {
+	deps := map[string]bool{}
+	for _, dep := range d.TransitiveDependencies(ps) {
+		deps[dep.UUID().String()] = true
+	}
+	var order []*patchset.Patchset
+	for _, p := range d.patchsets.Slice {
+		if deps[p.UUID().String()] {
+			order = append(order, p)
+		}
+	}
+	return append(order, ps)
+}
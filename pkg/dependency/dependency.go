@@ -19,9 +19,15 @@ package dependency
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
+	log "github.com/golang/glog"
+
 	"github.com/google/kilt/pkg/patchset"
 	"github.com/google/kilt/pkg/repo"
 )
@@ -33,6 +39,365 @@ type Graph interface {
 	Validate() error
 }
 
+const (
+	// File is the name of the file that patchset dependencies are stored in.
+	File = "dependencies.json"
+	// dataName is the name patchset dependencies are stored under in the repo.
+	dataName = "deps"
+)
+
+// Load reads the dependency graph for the patchsets in the repo. Dependencies
+// are stored in the repo under the kilt branch, see repo.DataRef. Repos which
+// still have dependencies in a dependency file in the work tree are migrated
+// to the repo the next time the graph is saved.
+func Load(r *repo.Repo) (*StructGraph, error) {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	deps := NewStruct(patchsets)
+	b, err := read(r)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		if err = json.Unmarshal(b, deps); err != nil {
+			return nil, fmt.Errorf("failed to load dependencies: %w", err)
+		}
+	}
+	if err = deps.loadPatchDependencies(r); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// read returns the stored dependency graph, or nil if there is none.
+func read(r *repo.Repo) ([]byte, error) {
+	b, err := r.ReadData(dataName, File)
+	if errors.Is(err, repo.ErrNoData) {
+		return readLegacyFile(r)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read dependencies: %w", err)
+	}
+	return b, nil
+}
+
+// DanglingEntry is an entry of the stored dependency graph naming a patchset
+// that isn't on the branch, which keeps the graph from loading.
+type DanglingEntry struct {
+	Patchset string
+	// Dependency is the missing dependency of Patchset, or empty if Patchset
+	// itself is missing.
+	Dependency string
+}
+
+// Dangling returns the entries of the stored dependency graph that name
+// patchsets missing from the branch.
+func Dangling(r *repo.Repo) ([]DanglingEntry, error) {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	b, err := read(r)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	f := map[string][]string{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse dependencies: %w", err)
+	}
+	return dangling(f, patchsets), nil
+}
+
+func dangling(f map[string][]string, patchsets repo.PatchsetCache) []DanglingEntry {
+	var names []string
+	for name := range f {
+		names = append(names, name)
+	}
+	patchset.SortNames(names)
+	var entries []DanglingEntry
+	for _, name := range names {
+		if _, ok := patchsets.Lookup(name); !ok {
+			entries = append(entries, DanglingEntry{Patchset: name})
+			continue
+		}
+		for _, dep := range f[name] {
+			if _, ok := patchsets.Lookup(dep); !ok {
+				entries = append(entries, DanglingEntry{Patchset: name, Dependency: dep})
+			}
+		}
+	}
+	return entries
+}
+
+// Import validates the stored dependency graph, or the dependency file in the
+// work tree, against the patchsets on the branch and saves it to the repo. Each
+// missing patchset the graph names is passed to remap, which returns the name
+// of the patchset to use instead, or an empty name to prune its entries. It
+// reports whether there was a graph to import.
+func Import(r *repo.Repo, remap func(name string) (string, error)) (bool, error) {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return false, err
+	}
+	b, err := read(r)
+	if err != nil || b == nil {
+		return false, err
+	}
+	f := map[string][]string{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return false, fmt.Errorf("failed to parse dependencies: %w", err)
+	}
+	if f, err = remapEntries(f, patchsets, remap); err != nil {
+		return false, err
+	}
+	deps := NewStruct(patchsets)
+	if err := deps.load(f); err != nil {
+		return false, err
+	}
+	for _, dep := range deps.dependencies {
+		for _, p := range dep.predicates {
+			if !deps.checkOrder(dep.patchset, p.Patchset) {
+				return false, fmt.Errorf("patchset %q depends on %q, which comes after it", dep.patchset.Name(), p.Patchset.Name())
+			}
+		}
+	}
+	if err := deps.Validate(); err != nil {
+		return false, err
+	}
+	if err := deps.loadPatchDependencies(r); err != nil {
+		return false, err
+	}
+	return true, Save(r, deps)
+}
+
+// Prune removes the entries of the stored dependency graph naming patchsets
+// missing from the branch, returning the number of entries removed. The rest
+// of the stored graph is kept as it is.
+func Prune(r *repo.Repo) (int, error) {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return 0, err
+	}
+	b, err := read(r)
+	if err != nil || b == nil {
+		return 0, err
+	}
+	f := map[string][]string{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return 0, fmt.Errorf("failed to parse dependencies: %w", err)
+	}
+	entries := dangling(f, patchsets)
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	if b, err = json.MarshalIndent(prune(f, entries), "", "  "); err != nil {
+		return 0, fmt.Errorf("failed to marshal dependencies: %w", err)
+	}
+	b = append(b, "\n"...)
+	if err = r.WriteData(dataName, File, b, "kilt: prune patchset dependencies"); err != nil {
+		return 0, fmt.Errorf("failed to save dependencies: %w", err)
+	}
+	return len(entries), nil
+}
+
+// prune returns the entries of f without the dangling entries.
+func prune(f map[string][]string, entries []DanglingEntry) map[string][]string {
+	out := map[string][]string{}
+	for name, deps := range f {
+		out[name] = deps
+	}
+	for _, e := range entries {
+		if e.Dependency == "" {
+			delete(out, e.Patchset)
+			continue
+		}
+		kept := []string{}
+		for _, dep := range out[e.Patchset] {
+			if dep != e.Dependency {
+				kept = append(kept, dep)
+			}
+		}
+		out[e.Patchset] = kept
+	}
+	return out
+}
+
+// Rename renames patchsets in the stored dependency graph once they have been
+// renamed on the branch, replacing each old name in renames with the new one.
+// Old names the graph no longer uses are left alone, so renaming again has no
+// effect.
+func Rename(r *repo.Repo, renames map[string]string) error {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return err
+	}
+	b, err := read(r)
+	if err != nil || b == nil {
+		return err
+	}
+	f := map[string][]string{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("failed to parse dependencies: %w", err)
+	}
+	f, err = remapEntries(f, patchsets, func(name string) (string, error) {
+		if to, ok := renames[name]; ok {
+			return to, nil
+		}
+		return "", fmt.Errorf("patchset %q not found", name)
+	})
+	if err != nil {
+		return err
+	}
+	deps := NewStruct(patchsets)
+	if err := deps.load(f); err != nil {
+		return err
+	}
+	entries, err := readPatchEntries(r)
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if to, ok := renames[e.Patchset]; ok {
+			entries[i].Patchset = to
+		}
+		if to, ok := renames[e.Dependency]; ok {
+			entries[i].Dependency = to
+		}
+	}
+	deps.loadPatchEntries(entries)
+	return Save(r, deps)
+}
+
+// Delete removes patchsets from the stored dependency graph once they have been
+// deleted from the branch, along with the dependencies on them. Names the graph
+// no longer uses are left alone, so deleting again has no effect.
+func Delete(r *repo.Repo, names []string) error {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return err
+	}
+	b, err := read(r)
+	if err != nil || b == nil {
+		return err
+	}
+	f := map[string][]string{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("failed to parse dependencies: %w", err)
+	}
+	f, err = remapEntries(f, patchsets, func(name string) (string, error) {
+		if contains(names, name) {
+			return "", nil
+		}
+		return "", fmt.Errorf("patchset %q not found", name)
+	})
+	if err != nil {
+		return err
+	}
+	deps := NewStruct(patchsets)
+	if err := deps.load(f); err != nil {
+		return err
+	}
+	entries, err := readPatchEntries(r)
+	if err != nil {
+		return err
+	}
+	var kept []patchEntry
+	for _, e := range entries {
+		if !contains(names, e.Patchset) && !contains(names, e.Dependency) {
+			kept = append(kept, e)
+		}
+	}
+	deps.loadPatchEntries(kept)
+	return Save(r, deps)
+}
+
+// remapEntries returns the entries of f with the names of patchsets missing
+// from patchsets replaced by the names remap returns for them. Entries remapped
+// to an empty name are pruned, and remap is asked about each name only once.
+func remapEntries(f map[string][]string, patchsets repo.PatchsetCache, remap func(name string) (string, error)) (map[string][]string, error) {
+	remapped := map[string]string{}
+	resolve := func(name string) (string, error) {
+		if _, ok := patchsets.Lookup(name); ok {
+			return name, nil
+		}
+		if to, ok := remapped[name]; ok {
+			return to, nil
+		}
+		to, err := remap(name)
+		if err != nil {
+			return "", err
+		}
+		remapped[name] = to
+		return to, nil
+	}
+	var names []string
+	for name := range f {
+		names = append(names, name)
+	}
+	patchset.SortNames(names)
+	out := map[string][]string{}
+	for _, name := range names {
+		to, err := resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		if to == "" {
+			continue
+		}
+		for _, dep := range f[name] {
+			depTo, err := resolve(dep)
+			if err != nil {
+				return nil, err
+			}
+			if depTo == "" || contains(out[to], depTo) {
+				continue
+			}
+			out[to] = append(out[to], depTo)
+		}
+		if _, ok := out[to]; !ok {
+			out[to] = []string{}
+		}
+	}
+	return out, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readLegacyFile reads the dependency file that earlier versions of kilt kept
+// in the work tree. A missing file is returned as nil.
+func readLegacyFile(r *repo.Repo) ([]byte, error) {
+	path := filepath.Join(r.Workdir(), File)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", path, err)
+	}
+	log.Warningf("Reading dependencies from %q; they will be stored in %s when next saved, after which the file can be removed", path, r.DataRef(dataName))
+	return b, nil
+}
+
+// Save writes the dependency graph to the repo.
+func Save(r *repo.Repo, d *StructGraph) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal dependencies: %w", err)
+	}
+	b = append(b, "\n"...)
+	if err = r.WriteData(dataName, File, b, "kilt: update patchset dependencies"); err != nil {
+		return fmt.Errorf("failed to save dependencies: %w", err)
+	}
+	return d.savePatchDependencies(r)
+}
+
 type patchsetPredicate struct {
 	Patchset *patchset.Patchset
 }
@@ -70,6 +435,7 @@ type StructGraph struct {
 	patchsets           repo.PatchsetCache
 	reverseDependencies map[string][]*patchset.Patchset
 	dependencies        map[string]*dependency
+	patchDependencies   []*PatchDependency
 }
 
 // NewStruct creates a new StructGraph
@@ -121,6 +487,161 @@ func (d *StructGraph) Remove(ps, dep *patchset.Patchset) error {
 	return fmt.Errorf("patchset %q does not depend on patchset %q", ps.Name(), dep.Name())
 }
 
+// RemovePatchset removes the patchset and every dependency on it from the graph.
+func (d *StructGraph) RemovePatchset(ps *patchset.Patchset) {
+	delete(d.dependencies, ps.UUID().String())
+	for _, dep := range d.dependencies {
+		predicates := []*patchsetPredicate{}
+		for _, p := range dep.predicates {
+			if !p.Patchset.SameAs(ps) {
+				predicates = append(predicates, p)
+			}
+		}
+		dep.predicates = predicates
+	}
+	var patchDeps []*PatchDependency
+	for _, p := range d.patchDependencies {
+		if !p.Patchset.SameAs(ps) && !p.Dependency.SameAs(ps) {
+			patchDeps = append(patchDeps, p)
+		}
+	}
+	d.patchDependencies = patchDeps
+	d.reverseDependencies = nil
+}
+
+// RenamePatchset renames the patchset everywhere it appears in the graph.
+func (d *StructGraph) RenamePatchset(ps *patchset.Patchset, name string) {
+	renamed := patchset.Load(name, ps.UUID().String(), ps.Version())
+	for _, dep := range d.dependencies {
+		if dep.patchset.SameAs(ps) {
+			dep.patchset = renamed
+		}
+		for _, p := range dep.predicates {
+			if p.Patchset.SameAs(ps) {
+				p.Patchset = renamed
+			}
+		}
+	}
+	for _, p := range d.patchDependencies {
+		if p.Patchset.SameAs(ps) {
+			p.Patchset = renamed
+		}
+		if p.Dependency.SameAs(ps) {
+			p.Dependency = renamed
+		}
+	}
+	d.reverseDependencies = nil
+}
+
+// Dependents returns the patchsets that directly depend on the patchset, in
+// branch order.
+func (d *StructGraph) Dependents(ps *patchset.Patchset) []*patchset.Patchset {
+	var dependents []*patchset.Patchset
+	for _, p := range d.patchsets.Slice {
+		dep, ok := d.dependencies[p.UUID().String()]
+		if !ok {
+			continue
+		}
+		for _, pred := range dep.predicates {
+			if pred.Patchset.SameAs(ps) {
+				dependents = append(dependents, p)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// Dependencies returns the patchsets the patchset directly depends on.
+func (d *StructGraph) Dependencies(ps *patchset.Patchset) []*patchset.Patchset {
+	dep, ok := d.dependencies[ps.UUID().String()]
+	if !ok {
+		return nil
+	}
+	var deps []*patchset.Patchset
+	for _, pred := range dep.predicates {
+		deps = append(deps, pred.Patchset)
+	}
+	return deps
+}
+
+// Order returns the patchsets ordered so that each comes after those of them
+// it depends on, directly or not, keeping their given order where the
+// dependencies allow. An error is returned if their dependencies have a cycle.
+func (d *StructGraph) Order(patchsets []*patchset.Patchset) ([]*patchset.Patchset, error) {
+	pending := map[string]bool{}
+	for _, ps := range patchsets {
+		pending[ps.UUID().String()] = true
+	}
+	// Dependencies outside of the patchsets are followed, so that ordering
+	// through them is kept.
+	var blocked func(ps *patchset.Patchset, seen map[string]bool) bool
+	blocked = func(ps *patchset.Patchset, seen map[string]bool) bool {
+		for _, dep := range d.Dependencies(ps) {
+			id := dep.UUID().String()
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if pending[id] || blocked(dep, seen) {
+				return true
+			}
+		}
+		return false
+	}
+	var ordered []*patchset.Patchset
+	for len(ordered) < len(patchsets) {
+		found := false
+		for _, ps := range patchsets {
+			id := ps.UUID().String()
+			if !pending[id] || blocked(ps, map[string]bool{id: true}) {
+				continue
+			}
+			delete(pending, id)
+			ordered = append(ordered, ps)
+			found = true
+			break
+		}
+		if !found {
+			var cycle []string
+			for _, ps := range patchsets {
+				if pending[ps.UUID().String()] {
+					cycle = append(cycle, ps.Name())
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between patchsets %s", strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// Path returns the shortest chain of dependencies leading from ps to dep,
+// starting with ps and ending with dep, or nil if ps doesn't depend on dep.
+func (d *StructGraph) Path(ps, dep *patchset.Patchset) []*patchset.Patchset {
+	prev := map[string]*patchset.Patchset{ps.UUID().String(): nil}
+	queue := []*patchset.Patchset{ps}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, next := range d.Dependencies(p) {
+			id := next.UUID().String()
+			if _, ok := prev[id]; ok {
+				continue
+			}
+			prev[id] = p
+			if next.SameAs(dep) {
+				path := []*patchset.Patchset{next}
+				for q := p; q != nil; q = prev[q.UUID().String()] {
+					path = append([]*patchset.Patchset{q}, path...)
+				}
+				return path
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
 // flatten a structgraph to a map of patchset names to dependency names, for easy marshalling.
 func (d *StructGraph) flatten() map[string][]string {
 	f := map[string][]string{}
@@ -136,19 +657,20 @@ func (d *StructGraph) flatten() map[string][]string {
 
 // load a structgraph from a map of patchset names to dependendency names.
 func (d *StructGraph) load(f map[string][]string) error {
-	ps := make(map[string]*patchset.Patchset)
-	for _, p := range d.patchsets.Slice {
-		ps[p.Name()] = p
+	var names []string
+	for name := range f {
+		names = append(names, name)
 	}
-	for name, deps := range f {
-		p, ok := ps[name]
+	patchset.SortNames(names)
+	for _, name := range names {
+		p, ok := d.patchsets.Lookup(name)
 		if !ok {
 			return fmt.Errorf("patchset %q not found", name)
 		}
 		dep := dependency{patchset: p}
 		predicates := []*patchsetPredicate{}
-		for _, depName := range deps {
-			depPatchset, ok := ps[depName]
+		for _, depName := range f[name] {
+			depPatchset, ok := d.patchsets.Lookup(depName)
 			if !ok {
 				return fmt.Errorf("patchset dependency %q not found", depName)
 			}
@@ -245,11 +767,7 @@ func (d StructGraph) TransitiveDependencies(ps *patchset.Patchset) []*patchset.P
 			patchsets = append(patchsets, patchset)
 			queue = append(queue, patchset)
 		}
-		if len(queue) > 1 {
-			queue = queue[1 : len(queue)-1]
-		} else {
-			break
-		}
+		queue = queue[1:]
 	}
 	return patchsets
 }
@@ -290,11 +808,7 @@ func (d *StructGraph) TransitiveReverseDependencies(ps *patchset.Patchset) []*pa
 			patchsets = append(patchsets, patchset)
 			queue = append(queue, patchset)
 		}
-		if len(queue) > 1 {
-			queue = queue[1 : len(queue)-1]
-		} else {
-			break
-		}
+		queue = queue[1:]
 	}
 	return patchsets
 }
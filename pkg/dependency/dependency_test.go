@@ -17,6 +17,7 @@ limitations under the License.
 package dependency
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/google/kilt/pkg/patchset"
@@ -197,6 +198,91 @@ func TestRemove(t *testing.T) {
 	}
 }
 
+func TestRemovePatchset(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	c := patchset.New("c")
+	s := NewStruct(repo.PatchsetCache{})
+	s.dependencies = map[string]*dependency{
+		a.UUID().String(): {
+			patchset:   a,
+			predicates: []*patchsetPredicate{{b}, {c}},
+		},
+		b.UUID().String(): {
+			patchset:   b,
+			predicates: []*patchsetPredicate{{c}},
+		},
+	}
+	s.RemovePatchset(b)
+	want := map[string]*dependency{
+		a.UUID().String(): {
+			patchset:   a,
+			predicates: []*patchsetPredicate{{c}},
+		},
+	}
+	if diff := cmp.Diff(s.dependencies, want); diff != "" {
+		t.Errorf("RemovePatchset(%v) returned diff (-got +want)\n%s", b.Name(), diff)
+	}
+}
+
+func TestRenamePatchset(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	c := patchset.New("c")
+	s := NewStruct(repo.PatchsetCache{})
+	s.dependencies = map[string]*dependency{
+		a.UUID().String(): {
+			patchset:   a,
+			predicates: []*patchsetPredicate{{b}, {c}},
+		},
+		b.UUID().String(): {
+			patchset:   b,
+			predicates: []*patchsetPredicate{{c}},
+		},
+	}
+	s.RenamePatchset(b, "d")
+	want := map[string][]string{
+		"a": {"d", "c"},
+		"d": {"c"},
+	}
+	if diff := cmp.Diff(s.flatten(), want); diff != "" {
+		t.Errorf("RenamePatchset(%v) returned diff (-got +want)\n%s", b.Name(), diff)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	c := patchset.New("c")
+	s := NewStruct(repo.PatchsetCache{Slice: []*patchset.Patchset{c, b, a}})
+	s.dependencies = map[string]*dependency{
+		a.UUID().String(): {
+			patchset:   a,
+			predicates: []*patchsetPredicate{{c}},
+		},
+		b.UUID().String(): {
+			patchset:   b,
+			predicates: []*patchsetPredicate{{c}},
+		},
+	}
+	tests := []struct {
+		patchset *patchset.Patchset
+		want     []string
+	}{
+		{c, []string{"b", "a"}},
+		{b, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range s.Dependents(tt.patchset) {
+			got = append(got, p.Name())
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("Dependents(%v) returned diff (-got +want)\n%s", tt.patchset.Name(), diff)
+		}
+	}
+}
+
 func TestValidate(t *testing.T) {
 	a := patchset.New("a")
 	b := patchset.New("b")
@@ -239,3 +325,291 @@ func TestValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestDangling(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	patchsets := repo.PatchsetCache{
+		Slice: []*patchset.Patchset{a, b},
+		Map:   map[string]*patchset.Patchset{"a": a, "b": b},
+		Index: map[string]int{"a": 0, "b": 1},
+	}
+	f := map[string][]string{
+		"b": {"a", "gone"},
+		"c": {"a"},
+		"a": {},
+	}
+	want := []DanglingEntry{{Patchset: "b", Dependency: "gone"}, {Patchset: "c"}}
+	if diff := cmp.Diff(dangling(f, patchsets), want); diff != "" {
+		t.Errorf("dangling() returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	patchsets := repo.PatchsetCache{
+		Slice: []*patchset.Patchset{a, b},
+		Map:   map[string]*patchset.Patchset{"a": a, "b": b},
+		Index: map[string]int{"a": 0, "b": 1},
+	}
+	f := map[string][]string{
+		"b": {"a", "gone"},
+		"c": {"a"},
+		"a": {"gone"},
+	}
+	got := prune(f, dangling(f, patchsets))
+	want := map[string][]string{"a": {}, "b": {"a"}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("prune() returned diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(dangling(got, patchsets), []DanglingEntry(nil)); diff != "" {
+		t.Errorf("dangling() after prune() returned diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(f["b"], []string{"a", "gone"}); diff != "" {
+		t.Errorf("prune() changed its input (-got +want):\n%s", diff)
+	}
+}
+
+func TestRemapEntries(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	patchsets := repo.PatchsetCache{
+		Slice: []*patchset.Patchset{a, b},
+		Map:   map[string]*patchset.Patchset{"a": a, "b": b},
+		Index: map[string]int{"a": 0, "b": 1},
+	}
+	f := map[string][]string{
+		"b":   {"old", "gone"},
+		"c":   {"a"},
+		"new": {"old"},
+	}
+	var asked []string
+	remap := func(name string) (string, error) {
+		asked = append(asked, name)
+		if name == "old" {
+			return "a", nil
+		}
+		return "", nil
+	}
+	got, err := remapEntries(f, patchsets, remap)
+	if err != nil {
+		t.Fatalf("remapEntries(): %v", err)
+	}
+	want := map[string][]string{"b": {"a"}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("remapEntries() returned diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(asked, []string{"old", "gone", "c", "new"}); diff != "" {
+		t.Errorf("remapEntries() asked diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	c := patchset.New("c")
+	d := patchset.New("d")
+	s := NewStruct(repo.PatchsetCache{Slice: []*patchset.Patchset{a, b, c, d}})
+	s.dependencies = map[string]*dependency{
+		a.UUID().String(): {
+			patchset:   a,
+			predicates: []*patchsetPredicate{{c}},
+		},
+		c.UUID().String(): {
+			patchset:   c,
+			predicates: []*patchsetPredicate{{d}},
+		},
+	}
+	tests := []struct {
+		desc      string
+		patchsets []*patchset.Patchset
+		want      []string
+	}{
+		{
+			desc:      "Dependencies moved ahead",
+			patchsets: []*patchset.Patchset{a, b, c, d},
+			want:      []string{"b", "d", "c", "a"},
+		},
+		{
+			desc:      "Order kept",
+			patchsets: []*patchset.Patchset{d, c, b, a},
+			want:      []string{"d", "c", "b", "a"},
+		},
+		{
+			desc:      "Transitive through unselected patchset",
+			patchsets: []*patchset.Patchset{a, d},
+			want:      []string{"d", "a"},
+		},
+	}
+	for _, tt := range tests {
+		ordered, err := s.Order(tt.patchsets)
+		if err != nil {
+			t.Errorf("%s: Order() returned error: %v", tt.desc, err)
+			continue
+		}
+		var got []string
+		for _, p := range ordered {
+			got = append(got, p.Name())
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("%s: Order() returned diff (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+	s.dependencies[d.UUID().String()] = &dependency{
+		patchset:   d,
+		predicates: []*patchsetPredicate{{a}},
+	}
+	if _, err := s.Order([]*patchset.Patchset{a, c, d}); err == nil {
+		t.Error("Order() with a dependency cycle returned nil error")
+	}
+}
+
+func TestApplyOrder(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	c := patchset.New("c")
+	d := patchset.New("d")
+	e := patchset.New("e")
+	s := NewStruct(repo.PatchsetCache{Slice: []*patchset.Patchset{d, c, e, a, b}})
+	s.dependencies = map[string]*dependency{
+		b.UUID().String(): {
+			patchset:   b,
+			predicates: []*patchsetPredicate{{a}},
+		},
+		a.UUID().String(): {
+			patchset:   a,
+			predicates: []*patchsetPredicate{{c}},
+		},
+		c.UUID().String(): {
+			patchset:   c,
+			predicates: []*patchsetPredicate{{d}},
+		},
+	}
+	tests := []struct {
+		patchset *patchset.Patchset
+		want     []string
+	}{
+		{b, []string{"d", "c", "a", "b"}},
+		{e, []string{"e"}},
+		{d, []string{"d"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range s.applyOrder(tt.patchset) {
+			got = append(got, p.Name())
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("applyOrder(%s) returned diff (-got +want)\n%s", tt.patchset.Name(), diff)
+		}
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	c := patchset.New("c")
+	d := patchset.New("d")
+	e := patchset.New("e")
+	s := NewStruct(repo.PatchsetCache{Slice: []*patchset.Patchset{a, b, c, d, e}, Index: map[string]int{"a": 0, "b": 1, "c": 2, "d": 3, "e": 4}})
+	if err := s.Add(c, b); err != nil {
+		t.Fatalf("Add(): %v", err)
+	}
+	for _, p := range []PatchDependency{
+		{Patchset: b, Dependency: a, On: "a2"},
+		{Patchset: d, Patch: "d2", Dependency: a, On: "a3"},
+		{Patchset: e, Patch: "e1", Dependency: d, On: "d1"},
+	} {
+		if err := s.AddPatch(p.Patchset, p.Patch, p.Dependency, p.On); err != nil {
+			t.Fatalf("AddPatch(%s): %v", &p, err)
+		}
+	}
+	if err := s.AddPatch(a, "", b, "b1"); err == nil {
+		t.Error("AddPatch() on a later patchset returned nil error")
+	}
+	subjects := map[string][]string{
+		"a": {"a1", "a2", "a3"},
+		"b": {"b1"},
+		"c": {"c1"},
+		"d": {"d1", "d2"},
+		"e": {"e1"},
+	}
+	index := func(ps *patchset.Patchset, subject string) (int, error) {
+		for i, s := range subjects[ps.Name()] {
+			if s == subject {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("patch %q not found in %s", subject, ps.Name())
+	}
+	tests := []struct {
+		desc      string
+		patchsets []*patchset.Patchset
+		want      map[string]int
+	}{
+		{
+			desc:      "Prefix through patchset dependency",
+			patchsets: []*patchset.Patchset{c},
+			want:      map[string]int{"c": All, "b": All, "a": 2},
+		},
+		{
+			desc:      "Dependencies of patches past the prefix left out",
+			patchsets: []*patchset.Patchset{e},
+			want:      map[string]int{"e": All, "d": 1},
+		},
+		{
+			desc:      "Longest prefix kept",
+			patchsets: []*patchset.Patchset{b, d},
+			want:      map[string]int{"b": All, "d": All, "a": 3},
+		},
+		{
+			desc:      "Whole patchset wins",
+			patchsets: []*patchset.Patchset{a, c},
+			want:      map[string]int{"a": All, "b": All, "c": All},
+		},
+	}
+	for _, tt := range tests {
+		got, err := s.Prefixes(tt.patchsets, index)
+		if err != nil {
+			t.Errorf("%s: Prefixes() returned error: %v", tt.desc, err)
+			continue
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("%s: Prefixes() returned diff (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+	s.RemovePatchset(a)
+	if got := s.PatchDependencies(b); len(got) != 0 {
+		t.Errorf("PatchDependencies() after RemovePatchset() = %v, want none", got)
+	}
+}
+
+func TestPath(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	c := patchset.New("c")
+	d := patchset.New("d")
+	s := NewStruct(repo.PatchsetCache{Slice: []*patchset.Patchset{a, b, c, d}, Index: map[string]int{"a": 0, "b": 1, "c": 2, "d": 3}})
+	for _, dep := range [][2]*patchset.Patchset{{d, c}, {c, b}, {b, a}, {d, a}} {
+		if err := s.Add(dep[0], dep[1]); err != nil {
+			t.Fatalf("Add(): %v", err)
+		}
+	}
+	tests := []struct {
+		ps, dep *patchset.Patchset
+		want    []string
+	}{
+		{d, a, []string{"d", "a"}},
+		{c, a, []string{"c", "b", "a"}},
+		{a, d, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range s.Path(tt.ps, tt.dep) {
+			got = append(got, p.Name())
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("Path(%s, %s) returned diff (-got +want)\n%s", tt.ps.Name(), tt.dep.Name(), diff)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dependency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// PatchFile is the name of the file that patch dependencies are stored in,
+// next to File.
+const PatchFile = "patch-dependencies.json"
+
+// PatchDependency is a dependency of a patch on a patch of another patchset.
+// Patches are identified by their subjects, which are kept when patchsets are
+// reworked. Unlike patchset dependencies, only the patches of Dependency up to
+// and including On are needed, so builds can leave out the rest.
+type PatchDependency struct {
+	Patchset *patchset.Patchset
+	// Patch is the subject of the dependent patch, or empty if every patch of
+	// Patchset depends on On.
+	Patch      string
+	Dependency *patchset.Patchset
+	// On is the subject of the patch of Dependency that is depended on.
+	On string
+}
+
+func (p *PatchDependency) String() string {
+	s := p.Patchset.Name()
+	if p.Patch != "" {
+		s += fmt.Sprintf(" patch %q", p.Patch)
+	}
+	return s + fmt.Sprintf(" on %s patch %q", p.Dependency.Name(), p.On)
+}
+
+// patchEntry is the stored form of a PatchDependency.
+type patchEntry struct {
+	Patchset   string `json:"patchset"`
+	Patch      string `json:"patch,omitempty"`
+	Dependency string `json:"dependency"`
+	On         string `json:"on"`
+}
+
+// AddPatch adds a dependency of the patch of ps with the subject patch, or of
+// all of ps if patch is empty, on the patch of dep with the subject on.
+func (d *StructGraph) AddPatch(ps *patchset.Patchset, patch string, dep *patchset.Patchset, on string) error {
+	if ps.SameAs(dep) {
+		return fmt.Errorf("can't add a patch of %q as a dependency of itself", ps.Name())
+	}
+	if !d.checkOrder(ps, dep) {
+		return fmt.Errorf("can't add a patch of %q as a dependency of preceding patchset %q", dep.Name(), ps.Name())
+	}
+	if on == "" {
+		return errors.New("no patch depended on given")
+	}
+	for _, p := range d.patchDependencies {
+		if p.Patchset.SameAs(ps) && p.Patch == patch && p.Dependency.SameAs(dep) && p.On == on {
+			return fmt.Errorf("%s already exists", p)
+		}
+	}
+	d.patchDependencies = append(d.patchDependencies, &PatchDependency{Patchset: ps, Patch: patch, Dependency: dep, On: on})
+	return nil
+}
+
+// RemovePatch removes a dependency added by AddPatch.
+func (d *StructGraph) RemovePatch(ps *patchset.Patchset, patch string, dep *patchset.Patchset, on string) error {
+	for i, p := range d.patchDependencies {
+		if p.Patchset.SameAs(ps) && p.Patch == patch && p.Dependency.SameAs(dep) && p.On == on {
+			d.patchDependencies = append(d.patchDependencies[:i], d.patchDependencies[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("patchset %q has no such dependency on patchset %q", ps.Name(), dep.Name())
+}
+
+// PatchDependencies returns the patch dependencies of the patchset.
+func (d *StructGraph) PatchDependencies(ps *patchset.Patchset) []*PatchDependency {
+	var deps []*PatchDependency
+	for _, p := range d.patchDependencies {
+		if p.Patchset.SameAs(ps) {
+			deps = append(deps, p)
+		}
+	}
+	return deps
+}
+
+// PatchDependents returns the patch dependencies on patches of the patchset.
+func (d *StructGraph) PatchDependents(ps *patchset.Patchset) []*PatchDependency {
+	var deps []*PatchDependency
+	for _, p := range d.patchDependencies {
+		if p.Dependency.SameAs(ps) {
+			deps = append(deps, p)
+		}
+	}
+	return deps
+}
+
+// All is the prefix length returned by Prefixes for patchsets needed whole.
+const All = -1
+
+// Prefixes returns the patchsets needed to build the given patchsets, mapped
+// by name to the number of their leading patches that are needed. The given
+// patchsets and their transitive patchset dependencies are needed whole, as
+// All. Patchsets only reached through patch dependencies are needed up to the
+// last patch depended on, and in turn need their own dependencies, except
+// those of patches past that prefix. index returns the position of the patch
+// with the given subject in the patchset.
+func (d *StructGraph) Prefixes(patchsets []*patchset.Patchset, index func(ps *patchset.Patchset, subject string) (int, error)) (map[string]int, error) {
+	need := map[string]int{}
+	var queue []*patchset.Patchset
+	add := func(ps *patchset.Patchset, n int) {
+		if cur, ok := need[ps.Name()]; ok && (cur == All || n != All && n <= cur) {
+			return
+		}
+		need[ps.Name()] = n
+		queue = append(queue, ps)
+	}
+	for _, ps := range patchsets {
+		add(ps, All)
+	}
+	for len(queue) > 0 {
+		ps := queue[0]
+		queue = queue[1:]
+		n := need[ps.Name()]
+		for _, dep := range d.Dependencies(ps) {
+			add(dep, All)
+		}
+		for _, p := range d.PatchDependencies(ps) {
+			if p.Patch != "" && n != All {
+				i, err := index(ps, p.Patch)
+				if err != nil {
+					return nil, err
+				}
+				if i >= n {
+					continue
+				}
+			}
+			i, err := index(p.Dependency, p.On)
+			if err != nil {
+				return nil, err
+			}
+			add(p.Dependency, i+1)
+		}
+	}
+	return need, nil
+}
+
+// loadPatchDependencies reads the stored patch dependencies. Those naming
+// patchsets missing from the branch are dropped with a warning, as they only
+// refine the patchset dependencies.
+func (d *StructGraph) loadPatchDependencies(r *repo.Repo) error {
+	entries, err := readPatchEntries(r)
+	if err != nil {
+		return err
+	}
+	d.loadPatchEntries(entries)
+	return nil
+}
+
+// readPatchEntries returns the stored patch dependencies, or nil if there are
+// none.
+func readPatchEntries(r *repo.Repo) ([]patchEntry, error) {
+	b, err := r.ReadData(dataName, PatchFile)
+	if errors.Is(err, repo.ErrNoData) || err == nil && b == nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read patch dependencies: %w", err)
+	}
+	var entries []patchEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("failed to load patch dependencies: %w", err)
+	}
+	return entries, nil
+}
+
+func (d *StructGraph) loadPatchEntries(entries []patchEntry) {
+	d.patchDependencies = nil
+	for _, e := range entries {
+		ps, ok := d.patchsets.Lookup(e.Patchset)
+		dep, depOK := d.patchsets.Lookup(e.Dependency)
+		if !ok || !depOK {
+			log.Warningf("Dropping dependency of patchset %q on patchset %q, which is no longer on the branch", e.Patchset, e.Dependency)
+			continue
+		}
+		d.patchDependencies = append(d.patchDependencies, &PatchDependency{Patchset: ps, Patch: e.Patch, Dependency: dep, On: e.On})
+	}
+}
+
+// savePatchDependencies writes the patch dependencies, unless there are none
+// and none were stored before.
+func (d *StructGraph) savePatchDependencies(r *repo.Repo) error {
+	if len(d.patchDependencies) == 0 {
+		if _, err := r.ReadData(dataName, PatchFile); errors.Is(err, repo.ErrNoData) {
+			return nil
+		}
+	}
+	entries := []patchEntry{}
+	for _, p := range d.patchDependencies {
+		entries = append(entries, patchEntry{Patchset: p.Patchset.Name(), Patch: p.Patch, Dependency: p.Dependency.Name(), On: p.On})
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal patch dependencies: %w", err)
+	}
+	b = append(b, "\n"...)
+	if err = r.WriteData(dataName, PatchFile, b, "kilt: update patch dependencies"); err != nil {
+		return fmt.Errorf("failed to save patch dependencies: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dependency
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+// Formats supported by Render.
+const (
+	FormatDot     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+// edge is a dependency of one patchset on another in a rendered graph.
+type edge struct {
+	from, to   string
+	transitive bool
+	cyclic     bool
+}
+
+// Render renders the dependency graph in the given format, with an edge from
+// each patchset to each of its dependencies. Edges implied by other, transitive
+// dependencies are drawn dashed, and patchsets and edges in cycles are
+// highlighted.
+func (d *StructGraph) Render(format string) (string, error) {
+	nodes, adj := d.adjacency()
+	edges, cyclic := analyze(nodes, adj)
+	switch format {
+	case FormatDot:
+		return renderDot(nodes, edges, cyclic), nil
+	case FormatMermaid:
+		return renderMermaid(nodes, edges, cyclic), nil
+	}
+	return "", fmt.Errorf("unknown graph format %q", format)
+}
+
+// adjacency returns the names of the patchsets in the graph in branch order,
+// along with the names of the direct dependencies of each patchset.
+func (d *StructGraph) adjacency() ([]string, map[string][]string) {
+	var nodes []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			nodes = append(nodes, name)
+		}
+	}
+	for _, ps := range d.patchsets.Slice {
+		if ps.MetadataCommit() != "" {
+			add(ps.Name())
+		}
+	}
+	adj := map[string][]string{}
+	var extra []string
+	for _, dep := range d.dependencies {
+		name := dep.patchset.Name()
+		if !seen[name] {
+			extra = append(extra, name)
+		}
+		for _, p := range dep.predicates {
+			adj[name] = append(adj[name], p.Patchset.Name())
+			if !seen[p.Patchset.Name()] {
+				extra = append(extra, p.Patchset.Name())
+			}
+		}
+	}
+	patchset.SortNames(extra)
+	for _, name := range extra {
+		add(name)
+	}
+	return nodes, adj
+}
+
+// analyze returns the edges of the graph, marking those implied by other
+// dependencies as transitive and those in cycles as cyclic, along with the
+// set of nodes in cycles.
+func analyze(nodes []string, adj map[string][]string) ([]edge, map[string]bool) {
+	components := stronglyConnected(nodes, adj)
+	size := map[int]int{}
+	for _, c := range components {
+		size[c]++
+	}
+	cyclic := map[string]bool{}
+	var edges []edge
+	for _, from := range nodes {
+		for _, to := range adj[from] {
+			e := edge{from: from, to: to}
+			if from == to || components[from] == components[to] && size[components[from]] > 1 {
+				e.cyclic = true
+				cyclic[from], cyclic[to] = true, true
+			} else {
+				for _, via := range adj[from] {
+					if via != to && via != from && reachable(adj, via, to) {
+						e.transitive = true
+						break
+					}
+				}
+			}
+			edges = append(edges, e)
+		}
+	}
+	return edges, cyclic
+}
+
+// reachable reports whether to can be reached from from by following edges.
+func reachable(adj map[string][]string, from, to string) bool {
+	seen := map[string]bool{}
+	stack := []string{from}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n == to {
+			return true
+		}
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		stack = append(stack, adj[n]...)
+	}
+	return false
+}
+
+// stronglyConnected numbers the strongly connected components of the graph
+// using Tarjan's algorithm, returning the component of each node.
+func stronglyConnected(nodes []string, adj map[string][]string) map[string]int {
+	index := map[string]int{}
+	low := map[string]int{}
+	onStack := map[string]bool{}
+	components := map[string]int{}
+	var stack []string
+	next, component := 0, 0
+	var visit func(n string)
+	visit = func(n string) {
+		index[n], low[n] = next, next
+		next++
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, m := range adj[n] {
+			if _, ok := index[m]; !ok {
+				visit(m)
+				if low[m] < low[n] {
+					low[n] = low[m]
+				}
+			} else if onStack[m] && index[m] < low[n] {
+				low[n] = index[m]
+			}
+		}
+		if low[n] == index[n] {
+			for {
+				m := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[m] = false
+				components[m] = component
+				if m == n {
+					break
+				}
+			}
+			component++
+		}
+	}
+	for _, n := range nodes {
+		if _, ok := index[n]; !ok {
+			visit(n)
+		}
+	}
+	return components
+}
+
+func renderDot(nodes []string, edges []edge, cyclic map[string]bool) string {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n")
+	for _, n := range nodes {
+		if cyclic[n] {
+			fmt.Fprintf(&b, "\t%q [color=red];\n", n)
+		} else {
+			fmt.Fprintf(&b, "\t%q;\n", n)
+		}
+	}
+	for _, e := range edges {
+		switch {
+		case e.cyclic:
+			fmt.Fprintf(&b, "\t%q -> %q [color=red];\n", e.from, e.to)
+		case e.transitive:
+			fmt.Fprintf(&b, "\t%q -> %q [style=dashed];\n", e.from, e.to)
+		default:
+			fmt.Fprintf(&b, "\t%q -> %q;\n", e.from, e.to)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func renderMermaid(nodes []string, edges []edge, cyclic map[string]bool) string {
+	var b strings.Builder
+	b.WriteString("graph TD\n")
+	ids := map[string]string{}
+	var cycleIDs []string
+	for i, n := range nodes {
+		ids[n] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(&b, "\t%s[\"%s\"]\n", ids[n], strings.ReplaceAll(n, `"`, "#quot;"))
+		if cyclic[n] {
+			cycleIDs = append(cycleIDs, ids[n])
+		}
+	}
+	for i, e := range edges {
+		arrow := "-->"
+		if e.transitive {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&b, "\t%s %s %s\n", ids[e.from], arrow, ids[e.to])
+		if e.cyclic {
+			fmt.Fprintf(&b, "\tlinkStyle %d stroke:red\n", i)
+		}
+	}
+	if len(cycleIDs) > 0 {
+		b.WriteString("\tclassDef cycle stroke:red\n")
+		fmt.Fprintf(&b, "\tclass %s cycle\n", strings.Join(cycleIDs, ","))
+	}
+	return b.String()
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dependency
+
+import (
+	"testing"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRender(t *testing.T) {
+	a := patchset.New("a")
+	b := patchset.New("b")
+	c := patchset.New("c")
+	patchsets := repo.PatchsetCache{
+		Slice: []*patchset.Patchset{c, b, a},
+		Map: map[string]*patchset.Patchset{
+			"a": a,
+			"b": b,
+			"c": c,
+		},
+		Index: map[string]int{
+			"a": 2,
+			"b": 1,
+			"c": 0,
+		},
+	}
+	s := NewStruct(patchsets)
+	if err := s.UnmarshalJSON([]byte(`{"a":["b","c"],"b":["c"]}`)); err != nil {
+		t.Fatalf("UnmarshalJSON(): %v", err)
+	}
+	tests := []struct {
+		format, want string
+	}{
+		{
+			format: FormatDot,
+			want: `digraph dependencies {
+	"a";
+	"b";
+	"c";
+	"a" -> "b";
+	"a" -> "c" [style=dashed];
+	"b" -> "c";
+}
+`,
+		},
+		{
+			format: FormatMermaid,
+			want: `graph TD
+	n0["a"]
+	n1["b"]
+	n2["c"]
+	n0 --> n1
+	n0 -.-> n2
+	n1 --> n2
+`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := s.Render(tt.format)
+		if err != nil {
+			t.Errorf("Render(%q): %v", tt.format, err)
+			continue
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("Render(%q) returned diff (-want +got):\n%s", tt.format, diff)
+		}
+	}
+	if _, err := s.Render("svg"); err == nil {
+		t.Errorf("Render(%q): expected error for unknown format", "svg")
+	}
+}
+
+func TestAnalyzeCycles(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	adj := map[string][]string{
+		"a": {"b"},
+		"b": {"a"},
+		"c": {"a"},
+	}
+	edges, cyclic := analyze(nodes, adj)
+	wantEdges := []edge{
+		{from: "a", to: "b", cyclic: true},
+		{from: "b", to: "a", cyclic: true},
+		{from: "c", to: "a"},
+	}
+	if diff := cmp.Diff(wantEdges, edges, cmp.AllowUnexported(edge{})); diff != "" {
+		t.Errorf("analyze() returned diff in edges (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]bool{"a": true, "b": true}, cyclic); diff != "" {
+		t.Errorf("analyze() returned diff in cyclic nodes (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package export implements exporting patchsets for use outside of kilt.
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+const (
+	mboxDate   = "Mon Sep 17 00:00:00 2001"
+	dateFormat = "Mon, 2 Jan 2006 15:04:05 -0700"
+	maxSlugLen = 52
+)
+
+var slugRegexp = regexp.MustCompile("[^[:alnum:]]+")
+
+// Patchset will write the patches of the named patchset as a numbered series
+// of format-patch style mbox files in dir.
+func Patchset(name, dir string) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	ps, ok := patchsets[name]
+	if !ok {
+		return fmt.Errorf("patchset %s not found", name)
+	}
+	patches := ps.Patches()
+	if len(patches) == 0 {
+		return fmt.Errorf("patchset %s has no patches", name)
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	for i, patch := range patches {
+		info, err := r.CommitInfo(patch)
+		if err != nil {
+			return err
+		}
+		mail, err := formatPatch(r, ps, info, i+1, len(patches))
+		if err != nil {
+			return err
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%04d-%s.patch", i+1, slug(info.Summary)))
+		if err := ioutil.WriteFile(file, []byte(mail), 0666); err != nil {
+			return err
+		}
+		fmt.Println(file)
+	}
+	return nil
+}
+
+func formatPatch(r *repo.Repo, ps *patchset.Patchset, info repo.CommitInfo, n, total int) (string, error) {
+	stat, err := r.CommitDiffStat(info.ID)
+	if err != nil {
+		return "", err
+	}
+	diff, err := r.CommitPatch(info.ID)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %s %s\n", info.ID, mboxDate)
+	fmt.Fprintf(&b, "From: %s <%s>\n", info.AuthorName, info.AuthorEmail)
+	fmt.Fprintf(&b, "Date: %s\n", info.AuthorDate.Format(dateFormat))
+	fmt.Fprintf(&b, "Subject: [PATCH %d/%d] %s\n", n, total, info.Summary)
+	fmt.Fprintf(&b, "X-Kilt-Patchset-Name: %s\n", ps.Name())
+	fmt.Fprintf(&b, "X-Kilt-Patchset-UUID: %s\n", ps.UUID())
+	fmt.Fprintf(&b, "X-Kilt-Patchset-Version: %s\n", ps.Version())
+	b.WriteString("\n")
+	body := strings.TrimSpace(strings.TrimPrefix(info.Message, info.Summary))
+	if body != "" {
+		b.WriteString(body)
+		b.WriteString("\n")
+	}
+	b.WriteString("---\n")
+	b.WriteString(stat)
+	b.WriteString("\n")
+	b.WriteString(diff)
+	b.WriteString("-- \nkilt\n\n")
+	return b.String(), nil
+}
+
+// slug converts a commit summary into a file name component the way git
+// format-patch does.
+func slug(summary string) string {
+	s := strings.Trim(slugRegexp.ReplaceAllString(summary, "-"), "-")
+	if len(s) > maxSlugLen {
+		s = strings.TrimRight(s[:maxSlugLen], "-")
+	}
+	return s
+}
+
+// Worktrees writes fully materialized source trees into numbered sibling
+// directories of dir: 00-base holds the kilt base, and each following
+// directory the tree with one more patchset applied, in branch order, such as
+// 01-<first patchset>. The patchsets are those named, or all of them if no
+// names are given. Patches are applied in memory, leaving the work tree of the
+// repo untouched.
+func Worktrees(names []string, dir string) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	patchsets, err := selectPatchsets(r, names)
+	if err != nil {
+		return err
+	}
+	dirs := []string{filepath.Join(dir, "00-base")}
+	for i, ps := range patchsets {
+		dirs = append(dirs, filepath.Join(dir, fmt.Sprintf("%02d-%s", i+1, ps.Name())))
+	}
+	for _, d := range dirs {
+		if _, err := os.Stat(d); err == nil {
+			return fmt.Errorf("%s already exists", d)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	tree, _, err := r.TreeWithPatches(r.KiltBase(), nil)
+	if err != nil {
+		return err
+	}
+	for i, d := range dirs {
+		if i > 0 {
+			ps := patchsets[i-1]
+			var conflict string
+			if tree, conflict, err = r.TreeWithPatches(tree, ps.Patches()); err != nil {
+				return err
+			} else if conflict != "" {
+				return fmt.Errorf("patch %.7s of patchset %s doesn't apply", conflict, ps.Name())
+			}
+		}
+		if err := r.CheckoutTreeTo(tree, d); err != nil {
+			return fmt.Errorf("failed to write %s: %w", d, err)
+		}
+		fmt.Println(d)
+	}
+	return nil
+}
+
+// selectPatchsets returns the named patchsets, or all patchsets if no names
+// are given, in branch order.
+func selectPatchsets(r *repo.Repo, names []string) ([]*patchset.Patchset, error) {
+	cache, err := r.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return cache.Slice, nil
+	}
+	selected := map[string]bool{}
+	for _, name := range names {
+		ps, ok := cache.Map[name]
+		if !ok {
+			return nil, fmt.Errorf("patchset %s not found", name)
+		}
+		selected[ps.Name()] = true
+	}
+	var patchsets []*patchset.Patchset
+	for _, ps := range cache.Slice {
+		if selected[ps.Name()] {
+			patchsets = append(patchsets, ps)
+		}
+	}
+	return patchsets, nil
+}
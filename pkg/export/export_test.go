@@ -0,0 +1,35 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import "testing"
+
+func TestSlug(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"Add a feature", "Add-a-feature"},
+		{"ext4: fix inode.c (again)", "ext4-fix-inode-c-again"},
+		{"  leading and trailing!  ", "leading-and-trailing"},
+		{"a very long subject line that keeps going well past the limit", "a-very-long-subject-line-that-keeps-going-well-past"},
+	}
+	for _, tt := range tests {
+		if got := slug(tt.in); got != tt.out {
+			t.Errorf("slug(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package graft implements adopting the patchset state of another patch stack.
+package graft
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+)
+
+// Plan describes how the patchsets of the kilt branch are grafted onto the
+// patchsets of another patch stack.
+type Plan struct {
+	// Adoptions are the names and UUIDs the patchsets adopt.
+	Adoptions []rework.Adoption
+	// Matching are the names of the patchsets that already match.
+	Matching []string
+	// Conflicts describe the patchsets that can't be matched unambiguously.
+	Conflicts []string
+}
+
+// NewPlan matches the patches of the kilt branch to the patches between base
+// and rev by patch id, and plans for each patchset whose matched patches all
+// belong to a single patchset of rev to adopt its name and UUID. If base is
+// empty, the merge base of rev and the kilt base is used. If fetch is set, the
+// remote of rev is fetched first.
+func NewPlan(rev, base string, fetch bool) (*Plan, error) {
+	r, err := repo.Open()
+	if err != nil {
+		return nil, err
+	}
+	if fetch {
+		if err := r.FetchUpstream(rev); err != nil {
+			return nil, err
+		}
+	}
+	if base == "" {
+		if base, err = r.MergeBase(rev, r.KiltBase()); err != nil {
+			return nil, err
+		}
+	}
+	o, err := r.OpenRevision(rev, base)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := patchIDs(o)
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, ps := range patchsets {
+		names[ps.Name()] = true
+	}
+	plan := &Plan{}
+	candidates := map[*patchset.Patchset]*patchset.Patchset{}
+	claims := map[string][]string{}
+	for _, ps := range patchsets {
+		if ps.MetadataCommit() == "" {
+			continue
+		}
+		matches := map[string]*patchset.Patchset{}
+		patches := append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...)
+		for _, patch := range patches {
+			id, err := r.PatchID(patch)
+			if err != nil {
+				return nil, err
+			}
+			if t, ok := theirs[id]; ok {
+				matches[t.UUID().String()] = t
+			}
+		}
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			for _, t := range matches {
+				candidates[ps] = t
+				claims[t.UUID().String()] = append(claims[t.UUID().String()], ps.Name())
+			}
+		default:
+			var matched []string
+			for _, t := range matches {
+				matched = append(matched, t.Name())
+			}
+			patchset.SortNames(matched)
+			plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("patchset %q matches patches of several patchsets: %s", ps.Name(), strings.Join(matched, ", ")))
+		}
+	}
+	reported := map[string]bool{}
+	for _, ps := range patchsets {
+		t, ok := candidates[ps]
+		if !ok {
+			continue
+		}
+		uuid := t.UUID().String()
+		switch {
+		case len(claims[uuid]) > 1:
+			if !reported[uuid] {
+				plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("patchsets %s all match patchset %q", strings.Join(claims[uuid], ", "), t.Name()))
+				reported[uuid] = true
+			}
+		case t.Name() == ps.Name() && t.SameAs(ps):
+			plan.Matching = append(plan.Matching, ps.Name())
+		case t.Name() != ps.Name() && names[t.Name()]:
+			plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("patchset %q matches patchset %q, whose name is already in use", ps.Name(), t.Name()))
+		default:
+			plan.Adoptions = append(plan.Adoptions, rework.Adoption{
+				Patchset: ps.Name(),
+				Name:     t.Name(),
+				UUID:     t.UUID().String(),
+			})
+		}
+	}
+	return plan, nil
+}
+
+// patchIDs maps the patch ids of the patches in the repo to their patchsets.
+func patchIDs(r *repo.Repo) (map[string]*patchset.Patchset, error) {
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]*patchset.Patchset{}
+	for _, ps := range patchsets {
+		if ps.MetadataCommit() == "" {
+			continue
+		}
+		patches := append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...)
+		for _, patch := range patches {
+			id, err := r.PatchID(patch)
+			if err != nil {
+				return nil, err
+			}
+			ids[id] = ps
+		}
+	}
+	return ids, nil
+}
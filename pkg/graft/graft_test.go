@@ -0,0 +1,222 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graft
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/internal/testfiles"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+const (
+	uuid1 = "8d3c9b3c-6a7e-4b0e-9d5b-1f8e2a3c4d5e"
+	uuid2 = "0f6e4c1a-2b3d-4e5f-8a9b-7c6d5e4f3a2b"
+	uuid3 = "5a4b3c2d-1e0f-4a9b-8c7d-6e5f4a3b2c1d"
+)
+
+// stackPatchset is a patchset of a test stack, adding a file for each patch.
+type stackPatchset struct {
+	name  string
+	uuid  string
+	files []string
+}
+
+// setupRepo creates a repo with an initial commit, returning it and the id of
+// the commit.
+func setupRepo(t *testing.T, name string) (*git.Repository, *git.Oid) {
+	path, err := testfiles.TempDir(name)
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	os.Chdir(path)
+	g, err := git.InitRepository(path, false)
+	if err != nil {
+		t.Fatalf("InitRepository(): %v", err)
+	}
+	config, err := g.Config()
+	if err != nil {
+		t.Fatalf("Config(): %v", err)
+	}
+	config.SetString("user.name", "Test Data")
+	config.SetString("user.email", "nobody@google.com")
+	commitFile(t, g, "base", "")
+	head, err := g.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	return g, head.Target()
+}
+
+// addStack creates a kilt branch of the patchsets on top of base, and checks
+// it out.
+func addStack(t *testing.T, g *git.Repository, branch string, base *git.Oid, patchsets []stackPatchset) {
+	commit, err := g.LookupCommit(base)
+	if err != nil {
+		t.Fatalf("LookupCommit(): %v", err)
+	}
+	b, err := g.CreateBranch(branch, commit, false)
+	if err != nil {
+		t.Fatalf("CreateBranch(): %v", err)
+	}
+	if err = g.SetHead(b.Reference.Name()); err != nil {
+		t.Fatalf("SetHead(): %v", err)
+	}
+	if err = g.CheckoutHead(&git.CheckoutOpts{Strategy: git.CheckoutForce | git.CheckoutRemoveUntracked}); err != nil {
+		t.Fatalf("CheckoutHead(): %v", err)
+	}
+	r, err := repo.Init("HEAD", false)
+	if err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	for _, ps := range patchsets {
+		p := patchset.Load(ps.name, ps.uuid, patchset.InitialVersion())
+		if ps.uuid == "" {
+			p = patchset.New(ps.name)
+		}
+		if err = r.AddPatchset(p); err != nil {
+			t.Fatalf("AddPatchset(%q): %v", ps.name, err)
+		}
+		for _, file := range ps.files {
+			commitFile(t, g, file, ps.name)
+		}
+	}
+}
+
+// commitFile commits a new file, as a patch of the patchset if set.
+func commitFile(t *testing.T, g *git.Repository, file, patchset string) {
+	if err := ioutil.WriteFile(filepath.Join(g.Workdir(), file), []byte(file+"\n"), 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	index, err := g.Index()
+	if err != nil {
+		t.Fatalf("Index(): %v", err)
+	}
+	if err = index.AddByPath(file); err != nil {
+		t.Fatalf("AddByPath(): %v", err)
+	}
+	if err = index.Write(); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	oid, err := index.WriteTree()
+	if err != nil {
+		t.Fatalf("WriteTree(): %v", err)
+	}
+	tree, err := g.LookupTree(oid)
+	if err != nil {
+		t.Fatalf("LookupTree(): %v", err)
+	}
+	sig, err := g.DefaultSignature()
+	if err != nil {
+		t.Fatalf("DefaultSignature(): %v", err)
+	}
+	var parents []*git.Commit
+	if head, err := g.Head(); err == nil {
+		parent, err := g.LookupCommit(head.Target())
+		if err != nil {
+			t.Fatalf("LookupCommit(): %v", err)
+		}
+		parents = append(parents, parent)
+	}
+	message := fmt.Sprintf("Add %s\n", file)
+	if patchset != "" {
+		message += fmt.Sprintf("\nPatchset-Name: %s\n", patchset)
+	}
+	if _, err = g.CreateCommit("HEAD", sig, sig, message, tree, parents...); err != nil {
+		t.Fatalf("CreateCommit(): %v", err)
+	}
+}
+
+// plan creates the other stack and then the kilt branch on the initial commit,
+// and returns the plan of grafting the kilt branch onto the other stack.
+func plan(t *testing.T, name string, theirs, ours []stackPatchset) *Plan {
+	g, base := setupRepo(t, name)
+	defer os.RemoveAll(g.Workdir())
+	addStack(t, g, "other", base, theirs)
+	addStack(t, g, "test", base, ours)
+	p, err := NewPlan("other", "", false)
+	if err != nil {
+		t.Fatalf("NewPlan(): %v", err)
+	}
+	return p
+}
+
+func TestNewPlanUUID(t *testing.T) {
+	theirs := []stackPatchset{
+		{name: "same", uuid: uuid1, files: []string{"s"}},
+		{name: "renamed", uuid: uuid2, files: []string{"r"}},
+	}
+	ours := []stackPatchset{
+		{name: "same", uuid: uuid1, files: []string{"s"}},
+		{name: "old", files: []string{"r"}},
+		{name: "unmatched", files: []string{"u"}},
+	}
+	want := &Plan{
+		Matching:  []string{"same"},
+		Adoptions: []rework.Adoption{{Patchset: "old", Name: "renamed", UUID: uuid2}},
+	}
+	if diff := cmp.Diff(plan(t, "NewPlanUUID", theirs, ours), want); diff != "" {
+		t.Errorf("NewPlan() returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestNewPlanName(t *testing.T) {
+	theirs := []stackPatchset{{name: "named", uuid: uuid2, files: []string{"n1", "n2"}}}
+	// Matching only some of the patches of the other patchset is enough.
+	ours := []stackPatchset{{name: "named", uuid: uuid1, files: []string{"n1", "extra"}}}
+	want := &Plan{
+		Adoptions: []rework.Adoption{{Patchset: "named", Name: "named", UUID: uuid2}},
+	}
+	if diff := cmp.Diff(plan(t, "NewPlanName", theirs, ours), want); diff != "" {
+		t.Errorf("NewPlan() returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestNewPlanConflicts(t *testing.T) {
+	theirs := []stackPatchset{
+		{name: "p", uuid: uuid1, files: []string{"p1"}},
+		{name: "q", uuid: uuid2, files: []string{"p2"}},
+		{name: "d", uuid: uuid3, files: []string{"d1", "d2"}},
+		{name: "y", files: []string{"x"}},
+	}
+	ours := []stackPatchset{
+		{name: "split", files: []string{"p1", "p2"}},
+		{name: "dup1", files: []string{"d1"}},
+		{name: "dup2", files: []string{"d2"}},
+		{name: "x", files: []string{"x"}},
+		{name: "y", files: []string{"y"}},
+	}
+	want := &Plan{
+		Conflicts: []string{
+			`patchset "split" matches patches of several patchsets: p, q`,
+			`patchsets dup1, dup2 all match patchset "d"`,
+			`patchset "x" matches patchset "y", whose name is already in use`,
+		},
+	}
+	if diff := cmp.Diff(plan(t, "NewPlanConflicts", theirs, ours), want); diff != "" {
+		t.Errorf("NewPlan() returned diff (-got +want):\n%s", diff)
+	}
+}
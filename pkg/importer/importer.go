@@ -0,0 +1,86 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package importer implements importing external patch series into patchsets.
+package importer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/mbox"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// Import will apply the series of patches found at path on top of the kilt
+// branch, assigning each of them to the named patchset. If name is empty, the
+// patchset name is taken from the kilt headers of the patches. The patchset
+// metadata commit is created if the patchset doesn't exist yet.
+func Import(name, path string) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	if ok, err := r.ReworkInProgress(); err != nil {
+		return err
+	} else if ok {
+		return errors.New("can't import during a rework")
+	}
+	patches, err := mbox.ReadPath(path)
+	if err != nil {
+		return err
+	}
+	if len(patches) == 0 {
+		return fmt.Errorf("no patches found in %q", path)
+	}
+	uuid := patches[0].Header.Get("X-Kilt-Patchset-UUID")
+	if name == "" {
+		name = patches[0].Header.Get("X-Kilt-Patchset-Name")
+		if name == "" {
+			return errors.New("no patchset specified, and patches have no kilt patchset headers")
+		}
+	}
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	if _, ok := patchsets[name]; !ok {
+		var ps *patchset.Patchset
+		if uuid != "" && patches[0].Header.Get("X-Kilt-Patchset-Name") == name {
+			ps = patchset.Load(name, uuid, patchset.InitialVersion())
+		} else {
+			ps = patchset.New(name)
+		}
+		fmt.Printf("Creating metadata for %s\n", name)
+		if err := r.AddPatchset(ps); err != nil {
+			return err
+		}
+	}
+	for _, p := range patches {
+		fmt.Printf("Applying %s\n", p.Subject)
+		info := repo.CommitInfo{
+			Message:     repo.WithPatchsetName(p.Message(), name),
+			AuthorName:  p.Name,
+			AuthorEmail: p.Email,
+			AuthorDate:  p.Date,
+		}
+		if err := r.ApplyPatchToHead(p.Diff, info); err != nil {
+			return fmt.Errorf("failed to import %q: %w", p.Subject, err)
+		}
+	}
+	return nil
+}
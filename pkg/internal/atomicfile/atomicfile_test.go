@@ -0,0 +1,52 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicfile")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "state")
+	for _, contents := range []string{"first\n", "second\n"} {
+		if err := WriteFile(name, []byte(contents), 0666); err != nil {
+			t.Fatalf("WriteFile(): %v", err)
+		}
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile(): %v", err)
+		}
+		if got := string(b); got != contents {
+			t.Errorf("ReadFile() = %q, want %q", got, contents)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir(): %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("ReadDir() returned %d files, want only the written file", len(files))
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package failpoint injects simulated crashes at named points, so that
+// recovery from an interrupted kilt process can be tested.
+package failpoint
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvVar is the environment variable listing the enabled failpoints, separated
+// by commas. A failpoint may be given as name@n to crash the nth time it is
+// reached instead of the first.
+const EnvVar = "KILT_FAILPOINTS"
+
+// ExitCode is the exit code of a simulated crash.
+const ExitCode = 86
+
+// Crash is called when an enabled failpoint is reached. By default the process
+// exits immediately, without running deferred functions.
+var Crash = func(name string) {
+	fmt.Fprintf(os.Stderr, "Simulated crash at failpoint %s\n", name)
+	os.Exit(ExitCode)
+}
+
+var (
+	enabled = parse(os.Getenv(EnvVar))
+	hits    = map[string]int{}
+)
+
+func parse(list string) map[string]int {
+	points := map[string]int{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			enable(points, name)
+		}
+	}
+	return points
+}
+
+func enable(points map[string]int, name string) {
+	n := 1
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		if hit, err := strconv.Atoi(name[i+1:]); err == nil && hit > 0 {
+			name, n = name[:i], hit
+		}
+	}
+	points[name] = n
+}
+
+// Enable enables the named failpoints in addition to those in EnvVar.
+func Enable(names ...string) {
+	for _, name := range names {
+		enable(enabled, name)
+	}
+}
+
+// Disable disables all failpoints and resets their hit counts.
+func Disable() {
+	enabled = map[string]int{}
+	hits = map[string]int{}
+}
+
+// Inject simulates a crash if the named failpoint is enabled and has been
+// reached the configured number of times.
+func Inject(name string) {
+	n, ok := enabled[name]
+	if !ok {
+		return
+	}
+	hits[name]++
+	if hits[name] == n {
+		Crash(name)
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failpoint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInject(t *testing.T) {
+	defer Disable()
+	var crashed []string
+	Crash = func(name string) {
+		crashed = append(crashed, name)
+	}
+	enabled = parse(" a, b ,,e@2")
+	Enable("c", "f@x")
+	for _, name := range []string{"a", "b", "c", "d", "e", "f@x", "a", "e", "e"} {
+		Inject(name)
+	}
+	if got := strings.Join(crashed, ","); got != "a,b,c,f@x,e" {
+		t.Errorf("crashed at %q, want %q", got, "a,b,c,f@x,e")
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lock implements the lock file that keeps concurrent kilt processes
+// from modifying the same repo at once.
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Owner describes the process holding a lock.
+type Owner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Command string    `json:"command"`
+	Time    time.Time `json:"time"`
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("%q (pid %d on %s, since %s)", o.Command, o.PID, o.Host, o.Time.Format(time.RFC3339))
+}
+
+// ErrLocked is returned when the lock is held by another live process.
+type ErrLocked struct {
+	Path  string
+	Owner Owner
+}
+
+func (e *ErrLocked) Error() string {
+	return fmt.Sprintf("locked by %s; if that process is gone, remove the lock with --break-lock", e.Owner)
+}
+
+// Lock is a held lock file.
+type Lock struct {
+	path string
+}
+
+// Acquire creates the lock file at path, recording the current process and
+// command as its owner. A lock left behind by a process that is no longer
+// running on this host is stale, and is replaced.
+func Acquire(path, command string) (*Lock, error) {
+	host, _ := os.Hostname()
+	owner := Owner{PID: os.Getpid(), Host: host, Command: command, Time: time.Now().UTC()}
+	b, err := json.Marshal(owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			_, err = f.Write(b)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock %s: %w", path, err)
+			}
+			return &Lock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock %s: %w", path, err)
+		}
+		current, err := Read(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if current.PID == 0 && recent(path) {
+			// The owner may still be writing the lock.
+			return nil, &ErrLocked{Path: path, Owner: current}
+		}
+		if !stale(current, host) {
+			return nil, &ErrLocked{Path: path, Owner: current}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale lock %s: %w", path, err)
+		}
+	}
+	return nil, fmt.Errorf("failed to acquire lock %s", path)
+}
+
+// Read returns the owner recorded in the lock file at path.
+func Read(path string) (Owner, error) {
+	var o Owner
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return o, err
+	}
+	if err := json.Unmarshal(b, &o); err != nil {
+		// A lock that was being written by a crashed process has no owner.
+		return Owner{}, nil
+	}
+	return o, nil
+}
+
+// stale returns whether the owner of a lock no longer holds it: it is a
+// process on this host that has exited. Locks owned by other hosts, as with
+// repos on shared file systems, are never considered stale.
+func stale(o Owner, host string) bool {
+	if o.PID == 0 {
+		return true
+	}
+	return o.Host == host && !alive(o.PID)
+}
+
+// recent returns whether the file at path was modified in the last few seconds.
+func recent(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) < 5*time.Second
+}
+
+// alive returns whether a process with the pid is running.
+var alive = func(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Release removes the lock file.
+func (l *Lock) Release() error {
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Break removes the lock file at path regardless of its owner.
+func Break(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/kilt/pkg/internal/testfiles"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := testfiles.TempDir("lock")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kilt", "lock")
+	l, err := Acquire(path, "kilt rework")
+	if err != nil {
+		t.Fatalf("Acquire(): %v", err)
+	}
+	_, err = Acquire(path, "kilt build")
+	var locked *ErrLocked
+	if !errors.As(err, &locked) {
+		t.Fatalf("Acquire(): got %v, want ErrLocked", err)
+	}
+	if locked.Owner.PID != os.Getpid() || locked.Owner.Command != "kilt rework" {
+		t.Errorf("Acquire(): got owner %v, want this process", locked.Owner)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release(): %v", err)
+	}
+	if l, err = Acquire(path, "kilt build"); err != nil {
+		t.Fatalf("Acquire() after Release(): %v", err)
+	}
+	if err := Break(path); err != nil {
+		t.Fatalf("Break(): %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Break(): lock still exists")
+	}
+}
+
+func TestAcquireStale(t *testing.T) {
+	dir, err := testfiles.TempDir("lock")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+	host, _ := os.Hostname()
+	defer func(f func(int) bool) { alive = f }(alive)
+	alive = func(pid int) bool { return pid != 12345 }
+	tests := []struct {
+		name, owner string
+		wantLocked  bool
+	}{
+		{"dead process", `{"pid":12345,"host":"` + host + `"}`, false},
+		{"live process", `{"pid":1,"host":"` + host + `"}`, true},
+		{"other host", `{"pid":12345,"host":"elsewhere.invalid"}`, true},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(path, []byte(tt.owner), 0666); err != nil {
+			t.Fatalf("WriteFile(): %v", err)
+		}
+		l, err := Acquire(path, "kilt rework")
+		var locked *ErrLocked
+		if got := errors.As(err, &locked); got != tt.wantLocked {
+			t.Errorf("%s: Acquire() = %v, want locked %t", tt.name, err, tt.wantLocked)
+		}
+		if l != nil {
+			l.Release()
+		}
+	}
+}
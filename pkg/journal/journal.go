@@ -0,0 +1,253 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package journal records kilt command invocations in a file in the repo, and
+// summarizes them. The journal is purely local: nothing is ever sent anywhere.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// maxSize is the size after which the journal is rotated, keeping a single
+// previous file.
+const maxSize = 1 << 20
+
+// ExitedError is the error recorded for invocations that were started but
+// never finished, which happens when kilt exits with an error.
+const ExitedError = "exited before finishing"
+
+// Record is a line of the journal. An invocation is recorded when it starts,
+// and again with its result when it finishes.
+type Record struct {
+	ID      string    `json:"id"`
+	Command string    `json:"command"`
+	Flags   []string  `json:"flags,omitempty"`
+	Start   time.Time `json:"start"`
+	// Session is the name of the rework in progress, if it was named.
+	Session string `json:"session,omitempty"`
+	// Finished is set on the record written when the invocation finishes.
+	Finished bool          `json:"finished,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Invocation is a command invocation in progress.
+type Invocation struct {
+	path   string
+	record Record
+}
+
+// Start records the start of the command with the names of the flags that
+// were set, and the name of the rework session it runs in. Flag values aren't
+// recorded, as they may hold URLs or secrets.
+func Start(path, command string, flags []string, session string) (*Invocation, error) {
+	now := time.Now()
+	inv := &Invocation{path: path, record: Record{
+		ID:      fmt.Sprintf("%d-%d", now.UnixNano(), os.Getpid()),
+		Command: command,
+		Flags:   flags,
+		Start:   now,
+		Session: session,
+	}}
+	if err := rotate(path); err != nil {
+		return nil, err
+	}
+	if err := appendRecord(path, inv.record); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Finish records the result of the invocation.
+func (i *Invocation) Finish(err error) error {
+	r := i.record
+	r.Finished = true
+	r.Duration = time.Since(r.Start)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return appendRecord(i.path, r)
+}
+
+func rotate(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Size() < maxSize {
+		return nil
+	}
+	return os.Rename(path, path+".1")
+}
+
+func appendRecord(path string, r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	// A single write keeps records of concurrent processes from interleaving.
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return f.Close()
+}
+
+// Read returns the invocations recorded in the journal at path and its
+// rotated predecessor, in the order they started. Invocations that never
+// finished are returned as failed with ExitedError.
+func Read(path string) ([]Record, error) {
+	var records []Record
+	for _, p := range []string{path + ".1", path} {
+		f, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		rs, err := parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal %s: %w", p, err)
+		}
+		records = append(records, rs...)
+	}
+	return merge(records), nil
+}
+
+func parse(r io.Reader) ([]Record, error) {
+	var records []Record
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, s.Err()
+}
+
+// merge combines the start and finish records of each invocation.
+func merge(records []Record) []Record {
+	index := map[string]int{}
+	var merged []Record
+	for _, r := range records {
+		i, ok := index[r.ID]
+		if !ok {
+			index[r.ID] = len(merged)
+			merged = append(merged, r)
+		} else if r.Finished {
+			merged[i] = r
+		}
+	}
+	for i := range merged {
+		if !merged[i].Finished {
+			merged[i].Error = ExitedError
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Start.Before(merged[j].Start) })
+	return merged
+}
+
+// Usage summarizes the invocations of a command.
+type Usage struct {
+	Command  string `json:"command"`
+	Runs     int    `json:"runs"`
+	Failures int    `json:"failures"`
+	// Mean and Max are durations of the finished runs.
+	Mean time.Duration `json:"mean"`
+	Max  time.Duration `json:"max"`
+	// Errors counts the failures by error message.
+	Errors map[string]int `json:"errors,omitempty"`
+}
+
+// Report summarizes invocations by command, ordered by the number of
+// failures and then of runs, so that failure hotspots come first.
+func Report(records []Record) []Usage {
+	byCommand := map[string]*Usage{}
+	totals := map[string]time.Duration{}
+	finished := map[string]int{}
+	for _, r := range records {
+		u, ok := byCommand[r.Command]
+		if !ok {
+			u = &Usage{Command: r.Command}
+			byCommand[r.Command] = u
+		}
+		u.Runs++
+		if r.Finished {
+			finished[r.Command]++
+			totals[r.Command] += r.Duration
+		}
+		if r.Duration > u.Max {
+			u.Max = r.Duration
+		}
+		if r.Error != "" {
+			u.Failures++
+			if u.Errors == nil {
+				u.Errors = map[string]int{}
+			}
+			u.Errors[r.Error]++
+		}
+	}
+	usage := []Usage{}
+	for c, u := range byCommand {
+		if finished[c] > 0 {
+			u.Mean = totals[c] / time.Duration(finished[c])
+		}
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		a, b := usage[i], usage[j]
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		if a.Runs != b.Runs {
+			return a.Runs > b.Runs
+		}
+		return a.Command < b.Command
+	})
+	return usage
+}
+
+// TopError returns the most frequent error of the usage, and how often it
+// occurred.
+func (u Usage) TopError() (string, int) {
+	var top string
+	var n int
+	for e, c := range u.Errors {
+		if c > n || c == n && e < top {
+			top, n = e, c
+		}
+	}
+	return top, n
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/kilt/pkg/internal/testfiles"
+)
+
+func TestStartFinish(t *testing.T) {
+	dir, err := testfiles.TempDir("journal")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	path := filepath.Join(dir, "journal")
+	ok, err := Start(path, "rework", []string{"all"}, "")
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	failed, err := Start(path, "build", nil, "base bump")
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	if _, err := Start(path, "status", nil, ""); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	if err := ok.Finish(nil); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+	if err := failed.Finish(errors.New("conflict")); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+	got, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	want := []Record{
+		{Command: "rework", Flags: []string{"all"}, Finished: true},
+		{Command: "build", Session: "base bump", Finished: true, Error: "conflict"},
+		{Command: "status", Error: ExitedError},
+	}
+	opts := cmpopts.IgnoreFields(Record{}, "ID", "Start", "Duration")
+	if diff := cmp.Diff(got, want, opts); diff != "" {
+		t.Errorf("Read() returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestReport(t *testing.T) {
+	records := []Record{
+		{Command: "status", Finished: true, Duration: time.Second},
+		{Command: "status", Finished: true, Duration: 3 * time.Second},
+		{Command: "status", Finished: true, Duration: 2 * time.Second},
+		{Command: "rework", Finished: true, Duration: 4 * time.Second, Error: "conflict"},
+		{Command: "rework", Error: ExitedError},
+		{Command: "rework", Finished: true, Duration: 2 * time.Second, Error: "conflict"},
+		{Command: "build", Error: ExitedError},
+	}
+	want := []Usage{
+		{Command: "rework", Runs: 3, Failures: 3, Mean: 3 * time.Second, Max: 4 * time.Second, Errors: map[string]int{"conflict": 2, ExitedError: 1}},
+		{Command: "build", Runs: 1, Failures: 1, Errors: map[string]int{ExitedError: 1}},
+		{Command: "status", Runs: 3, Mean: 2 * time.Second, Max: 3 * time.Second},
+	}
+	got := Report(records)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Report() returned diff (-got +want):\n%s", diff)
+	}
+	if e, n := got[0].TopError(); e != "conflict" || n != 2 {
+		t.Errorf("TopError() = %q, %d, want %q, 2", e, n, "conflict")
+	}
+}
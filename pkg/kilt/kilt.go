@@ -0,0 +1,162 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kilt is the library API of kilt, for tools that embed it rather
+// than running the kilt command.
+//
+// Operations take a context, and return structured results instead of
+// printing. Each Repo works on the repo containing its directory, regardless
+// of the working directory, and the operations of a Repo are serialized.
+package kilt
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+	"github.com/google/kilt/pkg/status"
+)
+
+// Repo is a kilt managed git repo.
+type Repo struct {
+	dir string
+	// mu serializes the operations of the repo.
+	mu sync.Mutex
+}
+
+// Patchset describes a patchset of a kilt branch.
+type Patchset struct {
+	Name           string
+	Version        string
+	UUID           string
+	Description    string
+	Fields         []patchset.Field
+	MetadataCommit string
+	// Patches are the ids of the patches of the patchset, in order.
+	Patches []string
+	// FloatingPatches are the ids of patches of the patchset that are
+	// separated from it by patches of other patchsets.
+	FloatingPatches []string
+}
+
+// Open opens the kilt branch checked out in the git repo containing dir.
+func Open(ctx context.Context, dir string) (*Repo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	k := &Repo{dir: dir}
+	if err := k.run(ctx, func(r *repo.Repo) error { return nil }); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// run opens the repo in the directory of k and calls fn with it, unless ctx
+// is already done. Locks taken by rework commands are released afterwards.
+func (k *Repo) run(ctx context.Context, fn func(r *repo.Repo) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	r, err := repo.OpenDir(k.dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if lockErr := rework.ReleaseLock(r); err == nil {
+			err = lockErr
+		}
+	}()
+	return fn(r)
+}
+
+// Branch returns the name of the kilt branch.
+func (k *Repo) Branch(ctx context.Context) (string, error) {
+	var branch string
+	err := k.run(ctx, func(r *repo.Repo) error {
+		branch = r.KiltBranch()
+		return nil
+	})
+	return branch, err
+}
+
+// Patchsets returns the patchsets of the kilt branch, in order.
+func (k *Repo) Patchsets(ctx context.Context) ([]Patchset, error) {
+	var result []Patchset
+	err := k.run(ctx, func(r *repo.Repo) error {
+		patchsets, err := r.Patchsets()
+		if err != nil {
+			return err
+		}
+		for _, p := range patchsets {
+			result = append(result, newPatchset(p))
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Patchset returns the named patchset of the kilt branch.
+func (k *Repo) Patchset(ctx context.Context, name string) (Patchset, error) {
+	var result Patchset
+	err := k.run(ctx, func(r *repo.Repo) error {
+		cache, err := r.PatchsetCache()
+		if err != nil {
+			return err
+		}
+		p, ok := cache.Lookup(name)
+		if !ok {
+			return fmt.Errorf("patchset %s not found", name)
+		}
+		result = newPatchset(p)
+		return nil
+	})
+	return result, err
+}
+
+// Status returns the status of the kilt branch, as printed by kilt status
+// --format json.
+func (k *Repo) Status(ctx context.Context) (*status.State, error) {
+	var s *status.State
+	err := k.run(ctx, func(r *repo.Repo) error {
+		var err error
+		s, err = status.LoadStateFromRepo(r)
+		return err
+	})
+	return s, err
+}
+
+func newPatchset(p *patchset.Patchset) Patchset {
+	ps := Patchset{
+		Name:            p.Name(),
+		Description:     p.Description(),
+		Fields:          append([]patchset.Field{}, p.Fields()...),
+		MetadataCommit:  p.MetadataCommit(),
+		Patches:         append([]string{}, p.Patches()...),
+		FloatingPatches: append([]string{}, p.FloatingPatches()...),
+	}
+	if ps.MetadataCommit != "" {
+		ps.Version = p.Version().String()
+		ps.UUID = p.UUID().String()
+	}
+	return ps
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/patchset"
+)
+
+func TestNewPatchset(t *testing.T) {
+	p := patchset.Load("foo", "8d3c9b3c-6a7e-4b0e-9d5b-1f8e2a3c4d5e", patchset.InitialVersion())
+	p.SetDescription("Foo things")
+	p.SetField("Owner", "someone")
+	p.AddMetadataCommit("m1")
+	p.AddPatch("p1")
+	p.AddPatch("p2")
+	p.AddFloatingPatch("p3")
+	want := Patchset{
+		Name:            "foo",
+		Version:         "1",
+		UUID:            "8d3c9b3c-6a7e-4b0e-9d5b-1f8e2a3c4d5e",
+		Description:     "Foo things",
+		Fields:          []patchset.Field{{Key: "Owner", Value: "someone"}},
+		MetadataCommit:  "m1",
+		Patches:         []string{"p1", "p2"},
+		FloatingPatches: []string{"p3"},
+	}
+	if diff := cmp.Diff(newPatchset(p), want); diff != "" {
+		t.Errorf("newPatchset returned diff (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kilt
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/reporter"
+	"github.com/google/kilt/pkg/rework"
+)
+
+// ReworkOptions selects the patchsets of a rework or build.
+type ReworkOptions struct {
+	// Patchsets are the names of the patchsets to rework or build. Floating
+	// patches are always reworked.
+	Patchsets []string
+	// Tags select the patchsets tagged with any of them.
+	Tags []string
+	// All selects every patchset.
+	All bool
+	// Autosquash melds fixup! and squash! patches into their targets.
+	Autosquash bool
+	// Name describes what a new rework is for.
+	Name string
+	// Reporter, if set, receives the messages of the operations as they are
+	// performed, in addition to them being returned in the Result.
+	Reporter reporter.Reporter
+}
+
+// Result is the outcome of a rework or build step.
+type Result struct {
+	// Events are the messages of the operations that were performed.
+	Events []reporter.Event
+	// Conflict is set if the rework stopped at a conflict. Once it is
+	// resolved in the work tree, the rework is resumed with Continue.
+	Conflict bool
+}
+
+// Rework begins a rework of the selected patchsets and performs it, stopping
+// at conflicts. If ctx is done, the rework stops before the next operation and
+// can be resumed with Continue.
+func (k *Repo) Rework(ctx context.Context, opts ReworkOptions) (*Result, error) {
+	return k.execute(ctx, opts.Reporter, func(o rework.Options) (*rework.Command, error) {
+		targets := []rework.TargetSelector{rework.FloatingTargets{}}
+		if opts.All {
+			targets = append(targets, rework.AllTargets{})
+		}
+		for _, p := range opts.Patchsets {
+			targets = append(targets, rework.PatchsetTarget{Name: p})
+		}
+		for _, t := range opts.Tags {
+			targets = append(targets, rework.TagTarget{Tag: t})
+		}
+		c, err := rework.NewBeginCommandWithOptions(o, targets...)
+		if err == nil && opts.Autosquash {
+			err = c.Autosquash()
+		}
+		if err == nil && opts.Name != "" {
+			err = c.SetName(opts.Name)
+		}
+		return c, err
+	})
+}
+
+// Build begins building the selected patchsets and their dependencies on
+// base, as kilt build does, and performs the build, stopping at conflicts.
+func (k *Repo) Build(ctx context.Context, base string, opts ReworkOptions) (*Result, error) {
+	return k.execute(ctx, opts.Reporter, func(o rework.Options) (*rework.Command, error) {
+		var targets []rework.TargetSelector
+		for _, p := range opts.Patchsets {
+			targets = append(targets, rework.PatchsetTarget{Name: p})
+		}
+		for _, t := range opts.Tags {
+			targets = append(targets, rework.TagTarget{Tag: t})
+		}
+		return rework.NewBeginBuildCommandWithOptions(rework.BuildOptions{Options: o, Base: base}, targets...)
+	})
+}
+
+// Continue resumes a rework or build stopped at a conflict or by its context.
+func (k *Repo) Continue(ctx context.Context, rep reporter.Reporter) (*Result, error) {
+	return k.execute(ctx, rep, rework.NewContinueCommandWithOptions)
+}
+
+// Skip skips the operation a rework or build stopped at, and resumes it.
+func (k *Repo) Skip(ctx context.Context, rep reporter.Reporter) (*Result, error) {
+	return k.execute(ctx, rep, rework.NewSkipCommandWithOptions)
+}
+
+// Finish finishes a complete rework or build, updating the branch. Unless
+// force is set, the rework must leave the tree of the branch unchanged.
+func (k *Repo) Finish(ctx context.Context, force bool) (*Result, error) {
+	return k.execute(ctx, nil, func(o rework.Options) (*rework.Command, error) {
+		return rework.NewFinishCommandWithOptions(o, force)
+	})
+}
+
+// Abort abandons a rework or build, restoring the branch.
+func (k *Repo) Abort(ctx context.Context) (*Result, error) {
+	return k.execute(ctx, nil, rework.NewAbortCommandWithOptions)
+}
+
+// execute creates a rework command of the repo and executes all of its
+// operations, recording their messages, and saves the remaining queue.
+func (k *Repo) execute(ctx context.Context, rep reporter.Reporter, command func(rework.Options) (*rework.Command, error)) (*Result, error) {
+	rec := &reporter.Recorder{}
+	result := &Result{}
+	err := k.run(ctx, func(*repo.Repo) error {
+		opts := rework.Options{Dir: k.dir, Reporter: rec}
+		if rep != nil {
+			opts.Reporter = tee{rec, rep}
+		}
+		c, err := command(opts)
+		if err != nil {
+			return err
+		}
+		err = c.ExecuteAllContext(ctx)
+		if saveErr := c.Save(); err == nil {
+			err = saveErr
+		}
+		if errors.Is(err, repo.ErrUserActionRequired) {
+			result.Conflict = true
+			return nil
+		}
+		return err
+	})
+	result.Events = rec.Events()
+	return result, err
+}
+
+// tee sends messages to two reporters.
+type tee [2]reporter.Reporter
+
+func (t tee) Operation(name, message string) {
+	for _, r := range t {
+		r.Operation(name, message)
+	}
+}
+
+func (t tee) Note(message string) {
+	for _, r := range t {
+		r.Note(message)
+	}
+}
+
+func (t tee) Progress(p reporter.Progress) {
+	for _, r := range t {
+		if pr, ok := r.(reporter.ProgressReporter); ok {
+			pr.Progress(p)
+		}
+	}
+}
+
+func (t tee) Finished(name string, err error) {
+	for _, r := range t {
+		reporter.Finished(r, name, err)
+	}
+}
+
+func (t tee) Conflict(commit string, paths []string) {
+	for _, r := range t {
+		reporter.Conflict(r, commit, paths)
+	}
+}
+
+func (t tee) Checkout(target string) {
+	for _, r := range t {
+		reporter.Checkout(r, target)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mbox parses format-patch style patch files and mailboxes.
+package mbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Patch is a single patch parsed from a patch mail.
+type Patch struct {
+	Name    string
+	Email   string
+	Date    time.Time
+	Subject string
+	Body    string
+	Diff    string
+	Header  mail.Header
+}
+
+var (
+	fromLineRegexp = regexp.MustCompile(`^From \S+ `)
+	prefixRegexp   = regexp.MustCompile(`^(\[[^]]*\][[:space:]]*)+`)
+)
+
+// Message returns the commit message for the patch.
+func (p *Patch) Message() string {
+	if p.Body == "" {
+		return p.Subject + "\n"
+	}
+	return p.Subject + "\n\n" + p.Body + "\n"
+}
+
+// ReadPath reads patches from path, which may either be a single mailbox file
+// or a directory of patch files, which are read in lexical order.
+func ReadPath(path string) ([]*Patch, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return readFile(path)
+	}
+	files, err := filepath.Glob(filepath.Join(path, "*.patch"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var patches []*Patch
+	for _, f := range files {
+		ps, err := readFile(f)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, ps...)
+	}
+	return patches, nil
+}
+
+func readFile(path string) ([]*Patch, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	patches, err := Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	return patches, nil
+}
+
+// Parse splits a mailbox into its messages and parses each of them as a patch.
+func Parse(mbox string) ([]*Patch, error) {
+	var patches []*Patch
+	for _, m := range split(mbox) {
+		p, err := parseMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, p)
+	}
+	return patches, nil
+}
+
+// split separates a mailbox into messages on "From " lines, dropping the "From " lines themselves.
+func split(mbox string) []string {
+	var messages []string
+	var current []string
+	lines := strings.Split(mbox, "\n")
+	for i, l := range lines {
+		if fromLineRegexp.MatchString(l) && (i == 0 || lines[i-1] == "") {
+			if len(current) > 0 {
+				messages = append(messages, strings.Join(current, "\n"))
+			}
+			current = nil
+			continue
+		}
+		current = append(current, l)
+	}
+	if strings.TrimSpace(strings.Join(current, "\n")) != "" {
+		messages = append(messages, strings.Join(current, "\n"))
+	}
+	return messages
+}
+
+func parseMessage(m string) (*Patch, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(m))
+	if err != nil {
+		return nil, err
+	}
+	p := &Patch{Header: msg.Header}
+	dec := new(mime.WordDecoder)
+	if from := msg.Header.Get("From"); from != "" {
+		addr, err := mail.ParseAddress(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid From header %q: %w", from, err)
+		}
+		p.Name, p.Email = addr.Name, addr.Address
+	}
+	if date := msg.Header.Get("Date"); date != "" {
+		if p.Date, err = mail.ParseDate(date); err != nil {
+			return nil, fmt.Errorf("invalid Date header %q: %w", date, err)
+		}
+	}
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Subject header: %w", err)
+	}
+	p.Subject = prefixRegexp.ReplaceAllString(subject, "")
+	b, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	var body, diff []string
+	inDiff, inBody := false, true
+	for _, l := range strings.Split(string(b), "\n") {
+		switch {
+		case inDiff && l == "-- ":
+			inDiff = false
+		case inDiff:
+			diff = append(diff, l)
+		case strings.HasPrefix(l, "diff --git "):
+			inDiff, inBody = true, false
+			diff = append(diff, l)
+		case inBody && l == "---":
+			inBody = false
+		case inBody:
+			body = append(body, l)
+		}
+	}
+	if len(diff) == 0 {
+		return nil, fmt.Errorf("no diff found in patch %q", p.Subject)
+	}
+	p.Body = strings.TrimSpace(strings.Join(body, "\n"))
+	p.Diff = strings.Join(diff, "\n")
+	if !strings.HasSuffix(p.Diff, "\n") {
+		p.Diff += "\n"
+	}
+	return p, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mbox
+
+import (
+	"testing"
+)
+
+const series = `From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: Test Data <nobody@google.com>
+Date: Tue, 2 Jun 2020 10:00:00 -0700
+Subject: [PATCH 1/2] Add a file
+X-Kilt-Patchset-Name: test
+
+Some description.
+---
+ a | 1 +
+ 1 file changed, 1 insertion(+)
+
+diff --git a/a b/a
+new file mode 100644
+--- /dev/null
++++ b/a
+@@ -0,0 +1 @@
++a
+` + "-- " + `
+kilt
+
+From 123456789abcdef0123456789abcdef012345678 Mon Sep 17 00:00:00 2001
+From: Test Data <nobody@google.com>
+Date: Tue, 2 Jun 2020 11:00:00 -0700
+Subject: [PATCH 2/2] Modify
+ the file
+
+diff --git a/a b/a
+--- a/a
++++ b/a
+@@ -1 +1 @@
+-a
++b
+`
+
+func TestParse(t *testing.T) {
+	patches, err := Parse(series)
+	if err != nil {
+		t.Fatalf("Parse(): %v", err)
+	}
+	if len(patches) != 2 {
+		t.Fatalf("Parse(): got %d patches, want 2", len(patches))
+	}
+	tests := []struct {
+		subject, body, message, firstDiffLine string
+	}{
+		{"Add a file", "Some description.", "Add a file\n\nSome description.\n", "diff --git a/a b/a"},
+		{"Modify the file", "", "Modify the file\n", "diff --git a/a b/a"},
+	}
+	for i, tt := range tests {
+		p := patches[i]
+		if p.Subject != tt.subject {
+			t.Errorf("patch %d: Subject = %q, want %q", i, p.Subject, tt.subject)
+		}
+		if p.Body != tt.body {
+			t.Errorf("patch %d: Body = %q, want %q", i, p.Body, tt.body)
+		}
+		if p.Message() != tt.message {
+			t.Errorf("patch %d: Message() = %q, want %q", i, p.Message(), tt.message)
+		}
+		if p.Name != "Test Data" || p.Email != "nobody@google.com" {
+			t.Errorf("patch %d: got author %q <%q>", i, p.Name, p.Email)
+		}
+		if p.Date.IsZero() {
+			t.Errorf("patch %d: got zero date", i)
+		}
+	}
+	if got := patches[0].Header.Get("X-Kilt-Patchset-Name"); got != "test" {
+		t.Errorf("X-Kilt-Patchset-Name = %q, want %q", got, "test")
+	}
+	if want := "diff --git a/a b/a\nnew file mode 100644\n--- /dev/null\n+++ b/a\n@@ -0,0 +1 @@\n+a\n"; patches[0].Diff != want {
+		t.Errorf("patch 0: Diff = %q, want %q", patches[0].Diff, want)
+	}
+}
+
+func TestParseNoDiff(t *testing.T) {
+	if _, err := Parse("From: a <a@b.c>\nSubject: nothing\n\nbody\n"); err == nil {
+		t.Errorf("Parse(): expected error for patch without diff")
+	}
+}
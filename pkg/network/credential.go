@@ -0,0 +1,95 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Credential is a username and password for a remote URL, as provided by git
+// credential helpers.
+type Credential struct {
+	URL      string
+	Username string
+	Password string
+}
+
+// runCredential runs git credential with the given action and input.
+var runCredential = func(action string, input []byte) ([]byte, error) {
+	cmd := exec.Command("git", "credential", action)
+	cmd.Stdin = bytes.NewReader(input)
+	return cmd.Output()
+}
+
+// Fill asks the configured git credential helpers for the credential of the
+// URL, in the same way git itself does.
+func Fill(url, username string) (Credential, error) {
+	c := Credential{URL: url, Username: username}
+	out, err := runCredential("fill", c.format())
+	if err != nil {
+		return Credential{}, fmt.Errorf("git credential fill failed: %w", err)
+	}
+	return parseCredential(c, out), nil
+}
+
+// Approve tells the git credential helpers that the credential worked, so it
+// can be stored.
+func (c Credential) Approve() error {
+	_, err := runCredential("approve", c.format())
+	return err
+}
+
+// Reject tells the git credential helpers that the credential didn't work, so
+// it can be erased.
+func (c Credential) Reject() error {
+	_, err := runCredential("reject", c.format())
+	return err
+}
+
+func (c Credential) format() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "url=%s\n", c.URL)
+	if c.Username != "" {
+		fmt.Fprintf(&b, "username=%s\n", c.Username)
+	}
+	if c.Password != "" {
+		fmt.Fprintf(&b, "password=%s\n", c.Password)
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+func parseCredential(c Credential, out []byte) Credential {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			c.Username = kv[1]
+		case "password":
+			c.Password = kv[1]
+		}
+	}
+	return c
+}
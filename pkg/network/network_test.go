@@ -0,0 +1,111 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDo(t *testing.T) {
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	fail := errors.New("fail")
+	tests := []struct {
+		name      string
+		failures  int
+		permanent bool
+		wantCalls int
+		wantSleep []time.Duration
+		wantErr   bool
+	}{
+		{"success", 0, false, 1, nil, false},
+		{"retried", 2, false, 3, []time.Duration{time.Second, 2 * time.Second}, false},
+		{"exhausted", 5, false, 4, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, true},
+		{"permanent", 5, true, 1, nil, true},
+	}
+	for _, tt := range tests {
+		slept = nil
+		p := &Policy{Attempts: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second}
+		calls := 0
+		err := p.Do("test", func() error {
+			calls++
+			if calls <= tt.failures {
+				if tt.permanent {
+					return Permanent(fail)
+				}
+				return fail
+			}
+			return nil
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Do() = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, fail) {
+			t.Errorf("%s: Do() = %v, want wrapped %v", tt.name, err, fail)
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("%s: Do() made %d calls, want %d", tt.name, calls, tt.wantCalls)
+		}
+		if diff := cmp.Diff(slept, tt.wantSleep); diff != "" {
+			t.Errorf("%s: Do() sleeps returned diff (-got +want):\n%s", tt.name, diff)
+		}
+	}
+}
+
+func TestInterval(t *testing.T) {
+	defer func(s func(time.Duration), n func() time.Time) { sleep, now = s, n }(sleep, now)
+	clock := time.Unix(0, 0)
+	now = func() time.Time { return clock }
+	var slept []time.Duration
+	sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		clock = clock.Add(d)
+	}
+	p := &Policy{Attempts: 1, Interval: 10 * time.Second}
+	for _, elapsed := range []time.Duration{0, 0, 4 * time.Second, 20 * time.Second} {
+		clock = clock.Add(elapsed)
+		p.Do("test", func() error { return nil })
+	}
+	if diff := cmp.Diff(slept, []time.Duration{10 * time.Second, 6 * time.Second}); diff != "" {
+		t.Errorf("Do() sleeps returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestFill(t *testing.T) {
+	defer func(r func(string, []byte) ([]byte, error)) { runCredential = r }(runCredential)
+	var input string
+	runCredential = func(action string, in []byte) ([]byte, error) {
+		input = action + "\n" + string(in)
+		return []byte("protocol=https\nhost=example.com\nusername=me\npassword=secret\n"), nil
+	}
+	c, err := Fill("https://example.com/repo", "")
+	if err != nil {
+		t.Fatalf("Fill(): %v", err)
+	}
+	want := Credential{URL: "https://example.com/repo", Username: "me", Password: "secret"}
+	if diff := cmp.Diff(c, want); diff != "" {
+		t.Errorf("Fill() returned diff (-got +want):\n%s", diff)
+	}
+	if want := "fill\nurl=https://example.com/repo\n\n"; input != want {
+		t.Errorf("Fill() sent %q, want %q", input, want)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package network provides the shared retry, rate limiting and credential
+// handling of kilt operations that talk to remotes.
+package network
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Policy describes how network operations are retried and rate limited.
+type Policy struct {
+	// Attempts is the maximum number of times an operation is tried.
+	Attempts int
+	// Backoff is the delay before the first retry, which doubles after each
+	// failed attempt up to MaxBackoff.
+	Backoff, MaxBackoff time.Duration
+	// Interval is the minimum time between the start of two operations.
+	Interval time.Duration
+
+	last time.Time
+}
+
+// DefaultPolicy returns the policy used when none is configured.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Attempts:   3,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+var (
+	now   = time.Now
+	sleep = time.Sleep
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// IsPermanent returns whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Do runs op until it succeeds, fails permanently or runs out of attempts,
+// waiting between attempts as described by the policy. The description is
+// used in the returned error.
+func (p *Policy) Do(description string, op func() error) error {
+	attempts := p.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := p.Backoff
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			sleep(backoff)
+			if backoff *= 2; p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		}
+		p.wait()
+		if err = op(); err == nil || IsPermanent(err) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", description, err)
+	}
+	return nil
+}
+
+// wait enforces the minimum interval between operations.
+func (p *Policy) wait() {
+	if p.Interval > 0 && !p.last.IsZero() {
+		if d := p.Interval - now().Sub(p.last); d > 0 {
+			sleep(d)
+		}
+	}
+	p.last = now()
+}
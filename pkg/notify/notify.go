@@ -0,0 +1,142 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify sends notifications when unattended kilt operations, such as
+// automatic reworks and builds, complete or stop.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/kilt/pkg/network"
+)
+
+// Outcomes of an operation.
+const (
+	Complete = "complete"
+	Conflict = "conflict"
+	Failed   = "failed"
+)
+
+// Event describes how an operation ended.
+type Event struct {
+	// Command is the kilt command that ran, such as "rework" or "build".
+	Command string `json:"command"`
+	Branch  string `json:"branch"`
+	// Outcome is one of Complete, Conflict or Failed.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// Summary lists what was done and what remains, one line per entry.
+	Summary []string `json:"summary"`
+}
+
+// Subject returns a one line description of the event.
+func (e Event) Subject() string {
+	s := fmt.Sprintf("kilt %s of %s: %s", e.Command, e.Branch, e.Outcome)
+	if e.Error != "" {
+		s += ": " + e.Error
+	}
+	return s
+}
+
+// Notifier delivers an event.
+type Notifier interface {
+	Notify(e Event) error
+}
+
+// Command runs a shell command, passing the event as JSON on its standard
+// input, and its command, branch, outcome and subject in the KILT_COMMAND,
+// KILT_BRANCH, KILT_OUTCOME and KILT_SUBJECT environment variables.
+type Command struct {
+	Shell string
+}
+
+// Notify runs the command.
+func (c Command) Notify(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", c.Shell)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"KILT_COMMAND="+e.Command,
+		"KILT_BRANCH="+e.Branch,
+		"KILT_OUTCOME="+e.Outcome,
+		"KILT_SUBJECT="+e.Subject())
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("notify command %q failed: %w", c.Shell, err)
+	}
+	return nil
+}
+
+// Webhook posts the event as JSON to a URL. The "text" field of the posted
+// object holds the subject and summary, for chat services that display it.
+type Webhook struct {
+	URL    string
+	Policy *network.Policy
+}
+
+type webhookPayload struct {
+	Event
+	Text string `json:"text"`
+}
+
+// Notify posts the event, retrying according to the policy of the webhook.
+func (w Webhook) Notify(e Event) error {
+	b, err := json.Marshal(webhookPayload{Event: e, Text: strings.Join(append([]string{e.Subject()}, e.Summary...), "\n")})
+	if err != nil {
+		return err
+	}
+	p := w.Policy
+	if p == nil {
+		p = network.DefaultPolicy()
+	}
+	return p.Do("notify "+w.URL, func() error {
+		resp, err := http.Post(w.URL, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 500:
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		case resp.StatusCode >= 300:
+			return network.Permanent(fmt.Errorf("webhook returned %s", resp.Status))
+		}
+		return nil
+	})
+}
+
+// All sends the event to each of the notifiers, returning the errors of those
+// that failed.
+func All(notifiers []Notifier, e Event) []error {
+	var errs []error
+	for _, n := range notifiers {
+		if err := n.Notify(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/kilt/pkg/internal/testfiles"
+	"github.com/google/kilt/pkg/network"
+)
+
+var event = Event{
+	Command: "rework",
+	Branch:  "main",
+	Outcome: Conflict,
+	Error:   "user action required",
+	Summary: []string{"3/7 operations complete"},
+}
+
+func TestSubject(t *testing.T) {
+	if got, want := event.Subject(), "kilt rework of main: conflict: user action required"; got != want {
+		t.Errorf("Subject() = %q, want %q", got, want)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := testfiles.TempDir("notify")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	out := filepath.Join(dir, "out")
+	c := Command{Shell: `cat > "` + out + `"; echo "$KILT_OUTCOME" >> "` + out + `"`}
+	if err := c.Notify(event); err != nil {
+		t.Fatalf("Notify(): %v", err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	want, _ := json.Marshal(event)
+	if diff := cmp.Diff(string(b), string(want)+"conflict\n"); diff != "" {
+		t.Errorf("Notify() returned diff (-got +want):\n%s", diff)
+	}
+	if err := (Command{Shell: "exit 1"}).Notify(event); err == nil {
+		t.Errorf("Notify(): expected error for failing command")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got []webhookPayload
+	status := []int{http.StatusBadGateway, http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var p webhookPayload
+		if err := json.NewDecoder(req.Body).Decode(&p); err != nil {
+			t.Errorf("Decode(): %v", err)
+		}
+		got = append(got, p)
+		w.WriteHeader(status[0])
+		status = status[1:]
+	}))
+	defer srv.Close()
+	w := Webhook{URL: srv.URL, Policy: &network.Policy{Attempts: 2}}
+	if err := w.Notify(event); err != nil {
+		t.Fatalf("Notify(): %v", err)
+	}
+	p := webhookPayload{Event: event, Text: "kilt rework of main: conflict: user action required\n3/7 operations complete"}
+	if diff := cmp.Diff(got, []webhookPayload{p, p}); diff != "" {
+		t.Errorf("Notify() returned diff (-got +want):\n%s", diff)
+	}
+
+	status = []int{http.StatusNotFound, http.StatusOK}
+	got = nil
+	if err := w.Notify(event); err == nil {
+		t.Errorf("Notify(): expected error for client error status")
+	}
+	if len(got) != 1 {
+		t.Errorf("Notify(): got %d requests, want 1 for permanent failure", len(got))
+	}
+}
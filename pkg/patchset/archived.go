@@ -0,0 +1,47 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patchset
+
+import "strconv"
+
+// ArchivedField is the metadata field marking a patchset as archived, such as
+// a historical patchset that is kept on the branch but no longer worked on.
+// Archived patchsets are left out of reworks of all patchsets, hidden from
+// patchset lists, and can't be given new patches.
+const ArchivedField = "Archived"
+
+// ParseArchived parses the value of an Archived field, which is a boolean such
+// as true or false.
+func ParseArchived(value string) (bool, error) {
+	return strconv.ParseBool(value)
+}
+
+// Archived reports whether the patchset is archived. An invalid Archived field
+// leaves the patchset unarchived.
+func (p Patchset) Archived() bool {
+	archived, err := ParseArchived(p.Field(ArchivedField))
+	return err == nil && archived
+}
+
+// SetArchived archives or unarchives the patchset.
+func (p *Patchset) SetArchived(archived bool) {
+	if archived {
+		p.SetField(ArchivedField, "true")
+	} else {
+		p.SetField(ArchivedField, "")
+	}
+}
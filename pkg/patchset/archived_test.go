@@ -0,0 +1,40 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patchset
+
+import "testing"
+
+func TestArchived(t *testing.T) {
+	p := New("foo")
+	if p.Archived() {
+		t.Error("Archived() = true for new patchset")
+	}
+	for value, want := range map[string]bool{"true": true, "1": true, "false": false, "yes": false} {
+		p.SetField(ArchivedField, value)
+		if got := p.Archived(); got != want {
+			t.Errorf("Archived() = %t with %s: %s, want %t", got, ArchivedField, value, want)
+		}
+	}
+	p.SetArchived(true)
+	if got := p.Field(ArchivedField); got != "true" {
+		t.Errorf("SetArchived(true) set %s to %q, want %q", ArchivedField, got, "true")
+	}
+	p.SetArchived(false)
+	if got := p.Field(ArchivedField); got != "" {
+		t.Errorf("SetArchived(false) set %s to %q, want it removed", ArchivedField, got)
+	}
+}
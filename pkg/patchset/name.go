@@ -0,0 +1,109 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patchset
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Patchset names are collated case-insensitively and independently of Unicode
+// normalization: a name compares equal to the same name with different case,
+// or with its accented Latin letters precomposed (NFC, as on most systems) or
+// decomposed into a base letter and combining mark (NFD, as in file names on
+// macOS). Names that collate equal are ordered by their bytes, so the order is
+// total and the same on every platform.
+//
+// Case is folded with Unicode simple case folding. Precomposed letters of the
+// Latin-1 Supplement and Latin Extended-A blocks are decomposed.
+
+// NameKey returns the collation key of a patchset name. Names with equal keys
+// refer to the same patchset.
+func NameKey(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if d, ok := decompositions[r]; ok {
+			b.WriteRune(foldRune(d[0]))
+			b.WriteRune(d[1])
+			continue
+		}
+		b.WriteRune(foldRune(r))
+	}
+	return b.String()
+}
+
+// SameName returns whether the names collate equal.
+func SameName(a, b string) bool {
+	return a == b || NameKey(a) == NameKey(b)
+}
+
+// CompareNames compares the names in collation order, returning -1, 0 or 1.
+func CompareNames(a, b string) int {
+	if c := strings.Compare(NameKey(a), NameKey(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// SortNames sorts the names in collation order.
+func SortNames(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		return CompareNames(names[i], names[j]) < 0
+	})
+}
+
+// foldRune returns the canonical rune of the simple case folding orbit of r.
+func foldRune(r rune) rune {
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// compositions lists, for each combining mark, pairs of a base letter and the
+// precomposed letter combining it with the mark.
+var compositions = map[rune]string{
+	'\u0300': "AÀEÈIÌOÒUÙaàeèiìoòuù",                             // combining grave accent
+	'\u0301': "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýCĆcćLĹlĺNŃnńRŔrŕSŚsśZŹzź", // combining acute accent
+	'\u0302': "AÂEÊIÎOÔUÛaâeêiîoôuûCĈcĉGĜgĝHĤhĥJĴjĵSŜsŝWŴwŵYŶyŷ", // combining circumflex accent
+	'\u0303': "AÃNÑOÕaãnñoõIĨiĩUŨuũ",                             // combining tilde
+	'\u0304': "AĀaāEĒeēIĪiīOŌoōUŪuū",                             // combining macron
+	'\u0306': "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭ",                         // combining breve
+	'\u0307': "CĊcċEĖeėGĠgġIİZŻzż",                               // combining dot above
+	'\u0308': "AÄEËIÏOÖUÜaäeëiïoöuüyÿYŸ",                         // combining diaeresis
+	'\u030A': "AÅaåUŮuů",                                         // combining ring above
+	'\u030B': "OŐoőUŰuű",                                         // combining double acute accent
+	'\u030C': "CČcčDĎdďEĚeěLĽlľNŇnňRŘrřSŠsšTŤtťZŽzž",             // combining caron
+	'\u0327': "CÇcçGĢgģKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţ",                 // combining cedilla
+	'\u0328': "AĄaąEĘeęIĮiįUŲuų",                                 // combining ogonek
+}
+
+// decompositions maps precomposed letters to their base letter and mark.
+var decompositions = func() map[rune][2]rune {
+	d := map[rune][2]rune{}
+	for mark, pairs := range compositions {
+		rs := []rune(pairs)
+		for i := 0; i+1 < len(rs); i += 2 {
+			d[rs[i+1]] = [2]rune{rs[i], mark}
+		}
+	}
+	return d
+}()
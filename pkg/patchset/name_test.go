@@ -0,0 +1,51 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patchset
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSameName(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"foo", "foo", true},
+		{"Foo", "fOO", true},
+		{"caf\u00e9", "cafe\u0301", true},
+		{"CAF\u00c9", "cafe\u0301", true},
+		{"caf\u00e9", "cafe", false},
+		{"foo", "foobar", false},
+	}
+	for _, tt := range tests {
+		if got := SameName(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameName(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSortNames(t *testing.T) {
+	names := []string{"beta", "Alpha", "\u00e9clair", "alpha", "Zeta", "e\u0301clair", "delta"}
+	SortNames(names)
+	want := []string{"Alpha", "alpha", "beta", "delta", "e\u0301clair", "\u00e9clair", "Zeta"}
+	if diff := cmp.Diff(names, want); diff != "" {
+		t.Errorf("SortNames() returned diff (-got +want):\n%s", diff)
+	}
+}
@@ -29,10 +29,18 @@ type Patchset struct {
 	name              string
 	uuid              uuid.UUID
 	version           Version
+	description       string
+	fields            []Field
 	metadata          string
 	patches, floating []string
 }
 
+// Field is a free-form metadata field of a patchset, such as its owner or a
+// bug link.
+type Field struct {
+	Key, Value string
+}
+
 // Version wraps a patchset version number
 type Version struct {
 	v int
@@ -115,6 +123,52 @@ func (p Patchset) Name() string {
 	return p.name
 }
 
+// Description returns the description of the patchset.
+func (p Patchset) Description() string {
+	return p.description
+}
+
+// SetDescription sets the description of the patchset.
+func (p *Patchset) SetDescription(description string) {
+	p.description = description
+}
+
+// Fields returns the metadata fields of the patchset, in the order they were
+// added.
+func (p Patchset) Fields() []Field {
+	return p.fields
+}
+
+// Field returns the value of the metadata field with the given key, or an
+// empty string if it isn't set.
+func (p Patchset) Field(key string) string {
+	for _, f := range p.fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// SetField sets the metadata field with the given key, replacing any existing
+// value. An empty value removes the field.
+func (p *Patchset) SetField(key, value string) {
+	for i, f := range p.fields {
+		if f.Key != key {
+			continue
+		}
+		if value == "" {
+			p.fields = append(p.fields[:i:i], p.fields[i+1:]...)
+		} else {
+			p.fields[i].Value = value
+		}
+		return
+	}
+	if value != "" {
+		p.fields = append(p.fields, Field{Key: key, Value: value})
+	}
+}
+
 // SameAs compares two patchsets and checks if they are the same, regardless of
 // version or name changes.
 func (p Patchset) SameAs(p2 *Patchset) bool {
@@ -43,3 +43,21 @@ func TestNewNil(t *testing.T) {
 		t.Errorf(`New("") returned non-nil patchset`)
 	}
 }
+
+func TestSetField(t *testing.T) {
+	ps := New("patchset")
+	ps.SetField("Owner", "a")
+	ps.SetField("Bug", "b/1")
+	ps.SetField("Owner", "c")
+	ps.SetField("Status", "")
+	if diff := cmp.Diff(ps.Fields(), []Field{{"Owner", "c"}, {"Bug", "b/1"}}); diff != "" {
+		t.Errorf("SetField() returned diff (-got +want):\n%s", diff)
+	}
+	if got := ps.Field("Bug"); got != "b/1" {
+		t.Errorf("Field(%q) = %q, want %q", "Bug", got, "b/1")
+	}
+	ps.SetField("Owner", "")
+	if diff := cmp.Diff(ps.Fields(), []Field{{"Bug", "b/1"}}); diff != "" {
+		t.Errorf("SetField() to remove returned diff (-got +want):\n%s", diff)
+	}
+}
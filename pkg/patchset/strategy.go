@@ -0,0 +1,155 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patchset
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// StrategyField is the metadata field declaring how the patches of a patchset
+// are applied, for example "3way ours=*.pb.go theirs=vendor/**".
+const StrategyField = "Apply-Strategy"
+
+// Method is the way a patch is applied to the head.
+type Method string
+
+const (
+	// CherryPick cherry-picks the patch, which is the default.
+	CherryPick Method = "cherry-pick"
+	// ThreeWay applies the patch with git apply --3way, which copes with
+	// contexts that have moved or changed around the patched lines.
+	ThreeWay Method = "3way"
+)
+
+// Side is the side of a conflict that a strategy rule resolves it to.
+type Side string
+
+const (
+	// Ours keeps the version of the head the patch is applied to.
+	Ours Side = "ours"
+	// Theirs takes the version of the patch being applied.
+	Theirs Side = "theirs"
+)
+
+// Rule resolves conflicts in paths matching Pattern to Side.
+//
+// A pattern without a slash matches the base name of a path, a pattern ending
+// in "/**" matches everything below a directory, and any other pattern matches
+// the whole path, all using path.Match syntax.
+type Rule struct {
+	Side    Side
+	Pattern string
+}
+
+// Strategy is the application strategy of a patchset.
+type Strategy struct {
+	Method Method
+	Rules  []Rule
+}
+
+// ParseStrategy parses the value of an Apply-Strategy field, which is a space
+// separated list of an optional method, and ours=<patterns> or
+// theirs=<patterns> rules with comma separated patterns. An empty value is the
+// default strategy.
+func ParseStrategy(value string) (Strategy, error) {
+	s := Strategy{Method: CherryPick}
+	method := false
+	for _, w := range strings.Fields(value) {
+		side, patterns := w, ""
+		if i := strings.Index(w, "="); i >= 0 {
+			side, patterns = w[:i], w[i+1:]
+		}
+		switch {
+		case side == string(Ours) || side == string(Theirs):
+			if patterns == "" {
+				return Strategy{}, fmt.Errorf("no patterns given for %s", side)
+			}
+			for _, p := range strings.Split(patterns, ",") {
+				if _, err := path.Match(strings.TrimSuffix(p, "/**"), ""); p == "" || err != nil {
+					return Strategy{}, fmt.Errorf("invalid pattern %q", p)
+				}
+				s.Rules = append(s.Rules, Rule{Side: Side(side), Pattern: p})
+			}
+		case w == string(CherryPick) || w == string(ThreeWay):
+			if method {
+				return Strategy{}, fmt.Errorf("more than one method in %q", value)
+			}
+			s.Method, method = Method(w), true
+		default:
+			return Strategy{}, fmt.Errorf("unknown strategy %q", w)
+		}
+	}
+	return s, nil
+}
+
+// String returns the strategy in the form parsed by ParseStrategy.
+func (s Strategy) String() string {
+	words := []string{string(s.Method)}
+	for i := 0; i < len(s.Rules); {
+		side, patterns := s.Rules[i].Side, []string{}
+		for ; i < len(s.Rules) && s.Rules[i].Side == side; i++ {
+			patterns = append(patterns, s.Rules[i].Pattern)
+		}
+		words = append(words, fmt.Sprintf("%s=%s", side, strings.Join(patterns, ",")))
+	}
+	return strings.Join(words, " ")
+}
+
+// Default reports whether the strategy is plain cherry-picking.
+func (s Strategy) Default() bool {
+	return (s.Method == "" || s.Method == CherryPick) && len(s.Rules) == 0
+}
+
+// Resolve returns the side that conflicts in the path are resolved to, which
+// is that of the first matching rule, or an empty side if no rule matches.
+func (s Strategy) Resolve(p string) Side {
+	for _, r := range s.Rules {
+		if matchPath(r.Pattern, p) {
+			return r.Side
+		}
+	}
+	return ""
+}
+
+func matchPath(pattern, p string) bool {
+	if dir := strings.TrimSuffix(pattern, "/**"); dir != pattern {
+		parts := strings.Split(p, "/")
+		for i := 1; i < len(parts); i++ {
+			if ok, _ := path.Match(dir, strings.Join(parts[:i], "/")); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		p = path.Base(p)
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// Strategy returns the application strategy declared in the metadata of the
+// patchset.
+func (p Patchset) Strategy() (Strategy, error) {
+	s, err := ParseStrategy(p.Field(StrategyField))
+	if err != nil {
+		return Strategy{}, fmt.Errorf("invalid %s of patchset %q: %w", StrategyField, p.Name(), err)
+	}
+	return s, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patchset
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Strategy
+	}{
+		{"", Strategy{Method: CherryPick}},
+		{"3way", Strategy{Method: ThreeWay}},
+		{"ours=*.pb.go,gen/** theirs=vendor/**", Strategy{Method: CherryPick, Rules: []Rule{
+			{Ours, "*.pb.go"}, {Ours, "gen/**"}, {Theirs, "vendor/**"},
+		}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseStrategy(tt.value)
+		if err != nil {
+			t.Errorf("ParseStrategy(%q): %v", tt.value, err)
+			continue
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("ParseStrategy(%q) returned diff (-got +want):\n%s", tt.value, diff)
+		}
+		if again, err := ParseStrategy(got.String()); err != nil || !cmp.Equal(again, got) {
+			t.Errorf("ParseStrategy(%q) = %v, %v, want %v", got.String(), again, err, got)
+		}
+	}
+	for _, value := range []string{"rebase", "3way cherry-pick", "ours", "ours=", "theirs=[", "ours=a,,b"} {
+		if _, err := ParseStrategy(value); err == nil {
+			t.Errorf("ParseStrategy(%q): expected error", value)
+		}
+	}
+}
+
+func TestStrategyResolve(t *testing.T) {
+	s := Strategy{Rules: []Rule{{Ours, "*.pb.go"}, {Theirs, "third_party/*/**"}, {Ours, "api/*.json"}}}
+	tests := []struct {
+		path string
+		want Side
+	}{
+		{"foo.pb.go", Ours},
+		{"pkg/foo.pb.go", Ours},
+		{"third_party/lib/a/b.c", Theirs},
+		{"third_party/README", ""},
+		{"api/v1.json", Ours},
+		{"pkg/api/v1.json", ""},
+		{"main.go", ""},
+	}
+	for _, tt := range tests {
+		if got := s.Resolve(tt.path); got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patchset
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TagsField is the metadata field labeling a patchset with tags, such as
+// "performance, vendor", by which groups of patchsets can be selected.
+const TagsField = "Tags"
+
+var tagRegexp = regexp.MustCompile(`^[[:alnum:]][-_.[:alnum:]]*$`)
+
+// ParseTags parses the value of a Tags field, which is a list of tags
+// separated by commas or spaces. Tags consist of letters, digits, '-', '_'
+// and '.', and start with a letter or digit.
+func ParseTags(value string) ([]string, error) {
+	var tags []string
+	for _, t := range splitTags(value) {
+		if !tagRegexp.MatchString(t) {
+			return nil, fmt.Errorf("invalid tag %q", t)
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+func splitTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// Tags returns the tags of the patchset. Invalid tags are left out.
+func (p Patchset) Tags() []string {
+	var tags []string
+	for _, t := range splitTags(p.Field(TagsField)) {
+		if tagRegexp.MatchString(t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// HasTag reports whether the patchset is tagged with the tag, ignoring case.
+func (p Patchset) HasTag(tag string) bool {
+	for _, t := range p.Tags() {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
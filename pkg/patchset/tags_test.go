@@ -0,0 +1,64 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patchset
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "vendor", want: []string{"vendor"}},
+		{value: "performance, vendor backport", want: []string{"performance", "vendor", "backport"}},
+		{value: "v1.2,arm_64", want: []string{"v1.2", "arm_64"}},
+		{value: "-vendor", wantErr: true},
+		{value: "vendor;perf", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTags(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTags(%q) returned error %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("ParseTags(%q) returned diff (-got +want):\n%s", tt.value, diff)
+		}
+	}
+}
+
+func TestHasTag(t *testing.T) {
+	p := New("foo")
+	if p.HasTag("vendor") {
+		t.Errorf("HasTag(%q) = true for untagged patchset", "vendor")
+	}
+	p.SetField(TagsField, "Performance, vendor !bad")
+	if diff := cmp.Diff(p.Tags(), []string{"Performance", "vendor"}); diff != "" {
+		t.Errorf("Tags() returned diff (-got +want):\n%s", diff)
+	}
+	for tag, want := range map[string]bool{"performance": true, "vendor": true, "backport": false, "!bad": false} {
+		if got := p.HasTag(tag); got != want {
+			t.Errorf("HasTag(%q) = %t, want %t", tag, got, want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package push
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// ownersFileConfig names the path ownership file to read from the tip of each
+// patchset, rather than the first of defaultOwnersFiles found.
+const ownersFileConfig = "kilt.ownersFile"
+
+// ownerField is the patchset metadata field naming its owners.
+const ownerField = "Owner"
+
+var defaultOwnersFiles = []string{"CODEOWNERS", ".github/CODEOWNERS", "docs/CODEOWNERS"}
+
+// ownerRule assigns owners to the paths matching a pattern, as a line of a
+// CODEOWNERS file does.
+type ownerRule struct {
+	pattern string
+	owners  []string
+}
+
+// parseOwners parses a CODEOWNERS style file, where each line is a path
+// pattern followed by its owners, and # starts a comment.
+func parseOwners(b []byte) []ownerRule {
+	var rules []ownerRule
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		rules = append(rules, ownerRule{pattern: f[0], owners: f[1:]})
+	}
+	return rules
+}
+
+// ownersOf returns the owners of the file, given by the last rule matching
+// it, as in CODEOWNERS files.
+func ownersOf(rules []ownerRule, file string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if matchOwnerPattern(rules[i].pattern, file) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+// matchOwnerPattern reports whether the file matches the CODEOWNERS pattern.
+// Patterns containing a slash are relative to the root of the tree, others
+// match a file or directory of that name anywhere. Patterns matching a
+// directory match everything under it, and those ending in a slash only match
+// directories.
+func matchOwnerPattern(pattern, file string) bool {
+	dir := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	parts := strings.Split(file, "/")
+	for i := range parts {
+		if dir && i == len(parts)-1 {
+			break
+		}
+		candidate := parts[i]
+		if anchored {
+			candidate = strings.Join(parts[:i+1], "/")
+		}
+		if ok, _ := path.Match(pattern, candidate); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadOwners returns the path ownership rules in the tree of the revision.
+func loadOwners(r *repo.Repo, rev string) ([]ownerRule, error) {
+	files := defaultOwnersFiles
+	configured, err := r.ConfigString(ownersFileConfig, "")
+	if err != nil {
+		return nil, err
+	}
+	if configured != "" {
+		files = []string{configured}
+	}
+	for _, f := range files {
+		b, err := r.ReadFile(rev, f)
+		if err == repo.ErrNoFile {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return parseOwners(b), nil
+	}
+	return nil, nil
+}
+
+var addressRegexp = regexp.MustCompile(`<([^<>]+)>`)
+
+// reviewerAddress returns the address of an owner, given as an address or a
+// "Name <address>" pair.
+func reviewerAddress(owner string) string {
+	if m := addressRegexp.FindStringSubmatch(owner); m != nil {
+		return m[1]
+	}
+	return strings.TrimSpace(owner)
+}
+
+// reviewers returns the reviewers of the patchset: the owners in its Owner
+// fields, followed by the owners of the paths its patches change.
+func reviewers(r *repo.Repo, ps *patchset.Patchset) ([]string, error) {
+	var result []string
+	seen := map[string]bool{}
+	add := func(owner string) {
+		if a := reviewerAddress(owner); a != "" && !seen[a] {
+			seen[a] = true
+			result = append(result, a)
+		}
+	}
+	for _, f := range ps.Fields() {
+		if strings.EqualFold(f.Key, ownerField) {
+			for _, owner := range strings.Split(f.Value, ",") {
+				add(owner)
+			}
+		}
+	}
+	rules, err := loadOwners(r, tip(ps))
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return result, nil
+	}
+	for _, patch := range ps.Patches() {
+		files, err := r.ChangedFiles(patch)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			for _, owner := range ownersOf(rules, file) {
+				add(owner)
+			}
+		}
+	}
+	return result, nil
+}
+
+// withReviewers adds the reviewers to a Gerrit refs/for/ target as push
+// options. Other targets are returned unchanged.
+func withReviewers(target string, reviewers []string) string {
+	if !strings.HasPrefix(target, "refs/for/") || len(reviewers) == 0 {
+		return target
+	}
+	var options []string
+	for _, r := range reviewers {
+		options = append(options, "r="+r)
+	}
+	sep := "%"
+	if strings.Contains(target, "%") {
+		sep = ","
+	}
+	return target + sep + strings.Join(options, ",")
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package push implements pushing patchsets to a remote, such as for review.
+package push
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// DefaultTarget is the ref that patchsets are pushed to if no target is given.
+const DefaultTarget = "refs/heads/{branch}/{patchset}"
+
+const stateFile = "push.json"
+
+// state records the patchsets already pushed by an interrupted push, so that
+// pushing them again can be skipped when it is resumed.
+type state struct {
+	Remote string            `json:"remote"`
+	Target string            `json:"target"`
+	Pushed map[string]string `json:"pushed"`
+}
+
+// Patchsets pushes the tip of each named patchset, or of all patchsets if no
+// names are given, to the remote. The target is the ref pushed to, where
+// {branch} and {patchset} are replaced by the kilt branch and patchset names,
+// for example refs/for/main%topic={patchset} for Gerrit. Each patchset is
+// pushed separately, and progress is saved so that rerunning an interrupted
+// push only pushes the patchsets that are left.
+//
+// If reviewers is set, the owners of each patchset, from its Owner fields and
+// the path ownership file in its tree, are added as reviewers of Gerrit
+// refs/for/ targets, or printed for other targets.
+func Patchsets(names []string, remote, target string, force, reviewers bool) error {
+	if err := repo.Writable("push"); err != nil {
+		return err
+	}
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	patchsets, err := selectPatchsets(r, names)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(r.BranchDirectory(), stateFile)
+	s, err := loadState(file, remote, target)
+	if err != nil {
+		return err
+	}
+	for _, ps := range patchsets {
+		tip := tip(ps)
+		if s.Pushed[ps.Name()] == tip {
+			fmt.Printf("Skipping %s, already pushed\n", ps.Name())
+			continue
+		}
+		dest := expandTarget(target, r.KiltBranch(), ps.Name())
+		if reviewers {
+			if dest, err = addReviewers(r, ps, dest); err != nil {
+				return err
+			}
+		}
+		refspec := tip + ":" + dest
+		if force {
+			refspec = "+" + refspec
+		}
+		rejected, err := r.Push(remote, []string{refspec})
+		if err != nil {
+			return fmt.Errorf("failed to push patchset %s: %w", ps.Name(), err)
+		}
+		if reason, ok := rejected[dest]; ok {
+			return fmt.Errorf("remote rejected patchset %s: %s", ps.Name(), reason)
+		}
+		fmt.Printf("Pushed %s to %s\n", ps.Name(), dest)
+		s.Pushed[ps.Name()] = tip
+		if err := saveState(file, s); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// addReviewers adds the reviewers of the patchset to the target if it is a
+// Gerrit target, or otherwise prints them for review tooling to pick up.
+func addReviewers(r *repo.Repo, ps *patchset.Patchset, dest string) (string, error) {
+	list, err := reviewers(r, ps)
+	if err != nil {
+		return "", fmt.Errorf("failed to find reviewers of patchset %s: %w", ps.Name(), err)
+	}
+	if len(list) == 0 {
+		return dest, nil
+	}
+	if d := withReviewers(dest, list); d != dest {
+		return d, nil
+	}
+	fmt.Printf("Reviewers for %s: %s\n", ps.Name(), strings.Join(list, ", "))
+	return dest, nil
+}
+
+func selectPatchsets(r *repo.Repo, names []string) ([]*patchset.Patchset, error) {
+	cache, err := r.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	var selected []*patchset.Patchset
+	if len(names) == 0 {
+		for _, ps := range cache.Slice {
+			if ps.MetadataCommit() != "" {
+				selected = append(selected, ps)
+			}
+		}
+	} else {
+		for _, name := range names {
+			ps, ok := cache.Map[name]
+			if !ok || ps.MetadataCommit() == "" {
+				return nil, fmt.Errorf("patchset %s not found", name)
+			}
+			selected = append(selected, ps)
+		}
+		sort.Slice(selected, func(i, j int) bool {
+			return cache.Index[selected[i].Name()] < cache.Index[selected[j].Name()]
+		})
+	}
+	for _, ps := range selected {
+		if len(ps.FloatingPatches()) > 0 {
+			return nil, fmt.Errorf("patchset %s has floating patches, rework it before pushing", ps.Name())
+		}
+	}
+	return selected, nil
+}
+
+// tip returns the last commit of the patchset.
+func tip(ps *patchset.Patchset) string {
+	if patches := ps.Patches(); len(patches) > 0 {
+		return patches[len(patches)-1]
+	}
+	return ps.MetadataCommit()
+}
+
+func expandTarget(target, branch, patchset string) string {
+	return strings.NewReplacer("{branch}", branch, "{patchset}", patchset).Replace(target)
+}
+
+// loadState returns the saved progress of a push to the same remote and
+// target, or an empty state if there is none.
+func loadState(file, remote, target string) (*state, error) {
+	s := &state{}
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, s); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+	}
+	if s.Remote != remote || s.Target != target || s.Pushed == nil {
+		s = &state{Remote: remote, Target: target, Pushed: map[string]string{}}
+	}
+	return s, nil
+}
+
+func saveState(file string, s *state) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0666)
+}
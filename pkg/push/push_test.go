@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package push
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/internal/testfiles"
+)
+
+func TestExpandTarget(t *testing.T) {
+	tests := []struct {
+		target, want string
+	}{
+		{DefaultTarget, "refs/heads/main/a"},
+		{"refs/for/main%topic={patchset}", "refs/for/main%topic=a"},
+		{"refs/heads/fixed", "refs/heads/fixed"},
+	}
+	for _, tt := range tests {
+		if got := expandTarget(tt.target, "main", "a"); got != tt.want {
+			t.Errorf("expandTarget(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	dir, err := testfiles.TempDir("State")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "branch", stateFile)
+	s, err := loadState(file, "origin", DefaultTarget)
+	if err != nil {
+		t.Fatalf("loadState(): %v", err)
+	}
+	s.Pushed["a"] = "1234"
+	if err := saveState(file, s); err != nil {
+		t.Fatalf("saveState(): %v", err)
+	}
+	got, err := loadState(file, "origin", DefaultTarget)
+	if err != nil {
+		t.Fatalf("loadState(): %v", err)
+	}
+	if diff := cmp.Diff(got, s); diff != "" {
+		t.Errorf("loadState() returned diff (-got +want):\n%s", diff)
+	}
+	if got, err = loadState(file, "other", DefaultTarget); err != nil || len(got.Pushed) != 0 {
+		t.Errorf("loadState() for another remote = %v, %v, want empty state", got, err)
+	}
+}
+
+func TestOwnersOf(t *testing.T) {
+	rules := parseOwners([]byte(`# Default owners.
+*          default@example.com
+*.md       docs@example.com
+/net/      net@example.com # Networking.
+drivers/   drivers@example.com
+build      build@example.com other@example.com
+`))
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"main.go", []string{"default@example.com"}},
+		{"README.md", []string{"docs@example.com"}},
+		{"net/tcp/tcp.go", []string{"net@example.com"}},
+		{"net", []string{"default@example.com"}},
+		{"drivers/gpu/gpu.c", []string{"drivers@example.com"}},
+		{"arch/drivers/x.c", []string{"drivers@example.com"}},
+		{"lib/net/x.c", []string{"default@example.com"}},
+		{"tools/build/Makefile", []string{"build@example.com", "other@example.com"}},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(ownersOf(rules, tt.file), tt.want); diff != "" {
+			t.Errorf("ownersOf(%q) returned diff (-got +want):\n%s", tt.file, diff)
+		}
+	}
+}
+
+func TestReviewerAddress(t *testing.T) {
+	tests := []struct {
+		owner, want string
+	}{
+		{"someone@example.com", "someone@example.com"},
+		{" Some One <someone@example.com> ", "someone@example.com"},
+		{"@someone", "@someone"},
+	}
+	for _, tt := range tests {
+		if got := reviewerAddress(tt.owner); got != tt.want {
+			t.Errorf("reviewerAddress(%q) = %q, want %q", tt.owner, got, tt.want)
+		}
+	}
+}
+
+func TestWithReviewers(t *testing.T) {
+	reviewers := []string{"a@example.com", "b@example.com"}
+	tests := []struct {
+		target, want string
+	}{
+		{"refs/for/main", "refs/for/main%r=a@example.com,r=b@example.com"},
+		{"refs/for/main%topic=a", "refs/for/main%topic=a,r=a@example.com,r=b@example.com"},
+		{"refs/heads/main/a", "refs/heads/main/a"},
+	}
+	for _, tt := range tests {
+		if got := withReviewers(tt.target, reviewers); got != tt.want {
+			t.Errorf("withReviewers(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ArgType is the type of an argument of an operation.
+type ArgType int
+
+const (
+	// String arguments may hold any text.
+	String ArgType = iota
+	// PatchsetName arguments name a patchset, and may not be empty or contain
+	// whitespace.
+	PatchsetName
+	// CommitID arguments hold a full or abbreviated commit ID.
+	CommitID
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case PatchsetName:
+		return "patchset name"
+	case CommitID:
+		return "commit ID"
+	}
+	return "string"
+}
+
+// Check checks that the argument is valid for the type.
+func (t ArgType) Check(arg string) error {
+	if t == String {
+		return nil
+	}
+	if arg == "" {
+		return fmt.Errorf("empty %s", t)
+	}
+	if strings.ContainsAny(arg, " \t\r\n") {
+		return fmt.Errorf("invalid %s %q: contains whitespace", t, arg)
+	}
+	if t == CommitID {
+		if len(arg) < 4 || len(arg) > 64 {
+			return fmt.Errorf("invalid %s %q: wrong length", t, arg)
+		}
+		for _, r := range arg {
+			if !strings.ContainsRune("0123456789abcdef", r) {
+				return fmt.Errorf("invalid %s %q: not hexadecimal", t, arg)
+			}
+		}
+	}
+	return nil
+}
+
+// Param describes an argument of an operation.
+type Param struct {
+	Name string
+	Type ArgType
+	// Optional params may be omitted, as long as the params following them
+	// are also omitted.
+	Optional bool
+	// Variadic is set on the last param of an operation taking any number of
+	// arguments of its type, including none.
+	Variadic bool
+}
+
+// CheckArgs checks the arguments against the params of the operation. The
+// arguments of operations with nil params are not checked, while operations
+// with empty params take no arguments.
+func (op Operation) CheckArgs(args []string) error {
+	if op.Params == nil {
+		return nil
+	}
+	i := 0
+	for _, p := range op.Params {
+		if p.Variadic {
+			for ; i < len(args); i++ {
+				if err := p.Type.Check(args[i]); err != nil {
+					return fmt.Errorf("%s: %s: %w", op.Name, p.Name, err)
+				}
+			}
+			return nil
+		}
+		if i >= len(args) {
+			if p.Optional {
+				return nil
+			}
+			return fmt.Errorf("%s: missing %s", op.Name, p.Name)
+		}
+		if err := p.Type.Check(args[i]); err != nil {
+			return fmt.Errorf("%s: %s: %w", op.Name, p.Name, err)
+		}
+		i++
+	}
+	if i < len(args) {
+		return fmt.Errorf("%s: %d arguments, want at most %d", op.Name, len(args), i)
+	}
+	return nil
+}
+
+// Check checks that the operation of the item is registered, and that its
+// arguments are valid.
+func (e *Executor) Check(item Item) error {
+	op, ok := e.registered[item.Operation]
+	if !ok {
+		return fmt.Errorf("invalid operation %q", item.Operation)
+	}
+	return op.CheckArgs(item.Args)
+}
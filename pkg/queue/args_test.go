@@ -0,0 +1,74 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "testing"
+
+func TestCheckArgs(t *testing.T) {
+	op := Operation{
+		Name: "Apply",
+		Params: []Param{
+			{Name: "patchset", Type: PatchsetName},
+			{Name: "base", Type: CommitID, Optional: true},
+			{Name: "options", Type: String, Variadic: true},
+		},
+	}
+	tests := []struct {
+		desc    string
+		op      Operation
+		args    []string
+		wantErr bool
+	}{
+		{desc: "Required only", op: op, args: []string{"a"}},
+		{desc: "Optional given", op: op, args: []string{"a", "abc123"}},
+		{desc: "Variadic given", op: op, args: []string{"a", "abc123", "squash", "up-to"}},
+		{desc: "Missing required", op: op, wantErr: true},
+		{desc: "Invalid patchset name", op: op, args: []string{"a b"}, wantErr: true},
+		{desc: "Empty patchset name", op: op, args: []string{""}, wantErr: true},
+		{desc: "Invalid commit ID", op: op, args: []string{"a", "HEAD"}, wantErr: true},
+		{desc: "Short commit ID", op: op, args: []string{"a", "abc"}, wantErr: true},
+		{desc: "No params", op: Operation{Name: "Any"}, args: []string{"", "a b"}},
+		{desc: "Empty params", op: Operation{Name: "None", Params: []Param{}}, args: []string{"a"}, wantErr: true},
+		{desc: "Too many", op: Operation{Name: "One", Params: []Param{{Name: "rev", Type: String}}}, args: []string{"a", "b"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.op.CheckArgs(tt.args); (err != nil) != tt.wantErr {
+			t.Errorf("%s: CheckArgs(%q) = %v, want error %t", tt.desc, tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEnqueueChecksArgs(t *testing.T) {
+	e := NewExecutor()
+	e.Register(Operation{
+		Name:    "Checkout",
+		Execute: func([]string) error { return nil },
+		Params:  []Param{{Name: "patchset", Type: PatchsetName}},
+	})
+	if err := e.Enqueue("Checkout"); err == nil {
+		t.Error("Enqueue() without a patchset returned nil error")
+	}
+	if err := e.Enqueue("Checkout", "a"); err != nil {
+		t.Errorf("Enqueue(): %v", err)
+	}
+	if err := e.ReplaceQueue(Queue{Items: []Item{{Operation: "Checkout", Args: []string{"a", "b"}}}}); err == nil {
+		t.Error("ReplaceQueue() with too many arguments returned nil error")
+	}
+	if got := len(e.Queue().Items); got != 1 {
+		t.Errorf("Queue() has %d items after failed ReplaceQueue(), want 1", got)
+	}
+}
@@ -20,20 +20,45 @@ package queue
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
 // Operation defines a queueable piece of work.
 type Operation struct {
-	Name      string
-	Execute   func(args []string) error
+	Name    string
+	Execute func(args []string) error
+	// Rollback, if set, undoes the effects of a successful Execute with the
+	// same arguments.
+	Rollback  func(args []string) error
 	Resumable bool
+	// Params, if set, describe the arguments of the operation, which are
+	// checked as items are queued.
+	Params []Param
+}
+
+// Hooks are called around each operation the executor executes, letting
+// callers add logging, timing or state persistence to every operation.
+type Hooks struct {
+	// Before is called with the item about to be executed. If it returns an
+	// error, the item is left queued and not executed.
+	Before func(item Item) error
+	// After is called with the item once it was executed successfully. The
+	// item has already been removed from the queue.
+	After func(item Item) error
+	// OnError is called with the item and the error it failed with, and
+	// returns the error to return from Execute, which may be nil if the
+	// failure was dealt with.
+	OnError func(item Item, err error) error
 }
 
 // Executor executes a queue of functions corresponding to registered operations.
 type Executor struct {
 	registered map[string]Operation
 	queue      Queue
+	hooks      []Hooks
+	// history holds the executed items, most recent last.
+	history []Item
 }
 
 // NewExecutor returns a new, empty Executor.
@@ -48,16 +73,56 @@ func (e *Executor) LoadQueue(queue Queue) {
 	e.queue.Items = append(e.queue.Items, queue.Items...)
 }
 
+// ReplaceQueue replaces the queued items of the executor with those of queue,
+// leaving the executor unchanged if any of the operations are not registered
+// or have invalid arguments.
+func (e *Executor) ReplaceQueue(queue Queue) error {
+	for _, item := range queue.Items {
+		if err := e.Check(item); err != nil {
+			return fmt.Errorf("replace: %w", err)
+		}
+	}
+	e.queue.Items = append([]Item{}, queue.Items...)
+	return nil
+}
+
 // MarshalQueue marshalls the executors operation queue.
 func (e *Executor) MarshalQueue() ([]byte, error) {
 	return e.queue.MarshalText()
 }
 
+// AddHooks adds hooks called around each executed operation. Hooks are called
+// in the order they were added; unset hooks are skipped.
+func (e *Executor) AddHooks(h Hooks) {
+	e.hooks = append(e.hooks, h)
+}
+
 // Register registers a new operation with the Executor.
 func (e *Executor) Register(op Operation) {
 	e.registered[op.Name] = op
 }
 
+// Operations returns the sorted names of all registered operations.
+func (e *Executor) Operations() []string {
+	var names []string
+	for name := range e.registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registered checks whether the named operation is registered.
+func (e *Executor) Registered(opName string) bool {
+	_, ok := e.registered[opName]
+	return ok
+}
+
+// Rollbackable checks whether the named operation can be rolled back.
+func (e *Executor) Rollbackable(opName string) bool {
+	return e.registered[opName].Rollback != nil
+}
+
 // Resumable checks whether the named operation is resumable.
 func (e *Executor) Resumable(opName string) bool {
 	return e.registered[opName].Resumable
@@ -71,13 +136,40 @@ func (e *Executor) apply(opName string, args []string) error {
 	return op.Execute(args)
 }
 
-// Execute will execute a single operation from the queue.
+// Execute will execute a single operation from the queue, calling the hooks
+// around it.
 func (e *Executor) Execute() error {
-	item, err := e.queue.Pop()
-	if err != nil {
+	if len(e.queue.Items) == 0 {
+		return ErrEmpty
+	}
+	item := e.queue.Items[0]
+	for _, h := range e.hooks {
+		if h.Before == nil {
+			continue
+		}
+		if err := h.Before(item); err != nil {
+			return err
+		}
+	}
+	e.queue.Pop()
+	if err := e.apply(item.Operation, item.Args); err != nil {
+		for _, h := range e.hooks {
+			if h.OnError != nil && err != nil {
+				err = h.OnError(item, err)
+			}
+		}
 		return err
 	}
-	return e.apply(item.Operation, item.Args)
+	e.history = append(e.history, item)
+	for _, h := range e.hooks {
+		if h.After == nil {
+			continue
+		}
+		if err := h.After(item); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // ExecuteAll executes all operations in the queue, stopping on error.
@@ -91,10 +183,45 @@ func (e *Executor) ExecuteAll() error {
 	return nil
 }
 
-// Enqueue queues a new operation with the provided arguments.
+// History returns the executed items, most recent last.
+func (e *Executor) History() []Item {
+	return append([]Item{}, e.history...)
+}
+
+// LoadHistory replaces the executed items of the executor, such as with those
+// executed by an earlier process.
+func (e *Executor) LoadHistory(items []Item) {
+	e.history = append([]Item{}, items...)
+}
+
+// RollbackLast rolls back the last n executed operations, most recent first,
+// and queues them again ahead of the queued items, so that they run next. It
+// stops at the first operation that can't be rolled back or whose rollback
+// fails, returning the number of operations rolled back.
+func (e *Executor) RollbackLast(n int) (int, error) {
+	for i := 0; i < n; i++ {
+		if len(e.history) == 0 {
+			return i, errors.New("no executed operations to roll back")
+		}
+		item := e.history[len(e.history)-1]
+		op := e.registered[item.Operation]
+		if op.Rollback == nil {
+			return i, fmt.Errorf("operation %q can't be rolled back", item.Operation)
+		}
+		if err := op.Rollback(item.Args); err != nil {
+			return i, fmt.Errorf("failed to roll back %s: %w", item.Operation, err)
+		}
+		e.history = e.history[:len(e.history)-1]
+		e.queue.Items = append([]Item{item}, e.queue.Items...)
+	}
+	return n, nil
+}
+
+// Enqueue queues a new operation with the provided arguments, which are
+// checked against the params of the operation.
 func (e *Executor) Enqueue(name string, args ...string) error {
-	if _, ok := e.registered[name]; !ok {
-		return fmt.Errorf("enqueue: invalid operation %q", name)
+	if err := e.Check(Item{Operation: name, Args: args}); err != nil {
+		return fmt.Errorf("enqueue: %w", err)
 	}
 	e.queue.Enqueue(name, args...)
 	return nil
@@ -159,9 +286,13 @@ func (q Queue) MarshalText() ([]byte, error) {
 }
 
 // UnmarshalText will load the queue with the items from the text, appending them to the existing items.
+// Lines starting with "#" are treated as comments and ignored.
 func (q *Queue) UnmarshalText(text []byte) error {
 	ss := strings.Split(string(text), "\n")
 	for _, s := range ss {
+		if strings.HasPrefix(strings.TrimSpace(s), "#") {
+			continue
+		}
 		i := Item{}
 		err := i.UnmarshalText([]byte(s))
 		if err != nil {
@@ -0,0 +1,257 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQueueUnmarshalText(t *testing.T) {
+	text := `# A comment line
+Checkout a
+
+Rework b
+  # An indented comment
+Apply c d
+`
+	want := Queue{Items: []Item{
+		{Operation: "Checkout", Args: []string{"a"}},
+		{Operation: "Rework", Args: []string{"b"}},
+		{Operation: "Apply", Args: []string{"c", "d"}},
+	}}
+	var q Queue
+	if err := q.UnmarshalText([]byte(text)); err != nil {
+		t.Fatalf("UnmarshalText(): %v", err)
+	}
+	if diff := cmp.Diff(want, q); diff != "" {
+		t.Errorf("UnmarshalText() returned diff (-want +got):\n%s", diff)
+	}
+	b, err := q.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText(): %v", err)
+	}
+	if got, want := string(b), "Checkout a\nRework b\nApply c d\n"; got != want {
+		t.Errorf("MarshalText() = %q, want %q", got, want)
+	}
+}
+
+func TestExecutorEnqueue(t *testing.T) {
+	e := NewExecutor()
+	var got []string
+	e.Register(Operation{
+		Name: "Record",
+		Execute: func(args []string) error {
+			got = append(got, args...)
+			return nil
+		},
+	})
+	e.Register(Operation{Name: "Noop", Execute: func([]string) error { return nil }})
+	if diff := cmp.Diff([]string{"Noop", "Record"}, e.Operations()); diff != "" {
+		t.Errorf("Operations() returned diff (-want +got):\n%s", diff)
+	}
+	if err := e.Enqueue("Missing"); err == nil {
+		t.Errorf("Enqueue(%q): expected error for unregistered operation", "Missing")
+	}
+	for _, arg := range []string{"a", "b"} {
+		if err := e.Enqueue("Record", arg); err != nil {
+			t.Fatalf("Enqueue(): %v", err)
+		}
+	}
+	if err := e.ExecuteAll(); err != nil {
+		t.Fatalf("ExecuteAll(): %v", err)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, got); diff != "" {
+		t.Errorf("ExecuteAll() returned diff (-want +got):\n%s", diff)
+	}
+	if err := e.Execute(); err != ErrEmpty {
+		t.Errorf("Execute() = %v, want %v", err, ErrEmpty)
+	}
+}
+
+func TestExecutorReplaceQueue(t *testing.T) {
+	e := NewExecutor()
+	e.Register(Operation{Name: "Noop", Execute: func([]string) error { return nil }})
+	if err := e.Enqueue("Noop", "a"); err != nil {
+		t.Fatalf("Enqueue(): %v", err)
+	}
+	bad := Queue{Items: []Item{{Operation: "Noop"}, {Operation: "Missing"}}}
+	if err := e.ReplaceQueue(bad); err == nil {
+		t.Errorf("ReplaceQueue(): expected error for unregistered operation")
+	}
+	if diff := cmp.Diff(Queue{Items: []Item{{Operation: "Noop", Args: []string{"a"}}}}, e.Queue()); diff != "" {
+		t.Errorf("ReplaceQueue() with invalid queue modified queue (-want +got):\n%s", diff)
+	}
+	good := Queue{Items: []Item{{Operation: "Noop", Args: []string{"b"}}, {Operation: "Noop", Args: []string{"c"}}}}
+	if err := e.ReplaceQueue(good); err != nil {
+		t.Fatalf("ReplaceQueue(): %v", err)
+	}
+	if diff := cmp.Diff(good, e.Queue()); diff != "" {
+		t.Errorf("ReplaceQueue() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	q := Queue{Items: []Item{
+		{Operation: "Trailer", Args: []string{"a", "set", "Reviewed-by", "A Reviewer <a@example.com>"}},
+		{Operation: "UpdateHead"},
+	}}
+	b, err := Marshal(q, Header{KiltVersion: "v1.0.0", Branch: "main"})
+	if err != nil {
+		t.Fatalf("Marshal(): %v", err)
+	}
+	got, h, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal(): %v", err)
+	}
+	if diff := cmp.Diff(q, got); diff != "" {
+		t.Errorf("Unmarshal() returned diff (-want +got):\n%s", diff)
+	}
+	if h.Checksum == "" {
+		t.Errorf("Unmarshal() header has no checksum")
+	}
+	h.Checksum = ""
+	if diff := cmp.Diff(Header{Format: FormatVersion, KiltVersion: "v1.0.0", Branch: "main"}, h); diff != "" {
+		t.Errorf("Unmarshal() header returned diff (-want +got):\n%s", diff)
+	}
+
+	got, h, err = Unmarshal([]byte("Checkout a\nApply c d\n"))
+	if err != nil {
+		t.Fatalf("Unmarshal() of text: %v", err)
+	}
+	want := Queue{Items: []Item{
+		{Operation: "Checkout", Args: []string{"a"}},
+		{Operation: "Apply", Args: []string{"c", "d"}},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unmarshal() of text returned diff (-want +got):\n%s", diff)
+	}
+	if h != (Header{}) {
+		t.Errorf("Unmarshal() of text header = %+v, want empty", h)
+	}
+
+	if _, _, err := Unmarshal([]byte(`{"format": 99, "items": []}`)); err == nil {
+		t.Errorf("Unmarshal() of newer format: expected error")
+	}
+	if _, _, err := Unmarshal(b[:len(b)/2]); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Unmarshal() of truncated queue = %v, want %v", err, ErrCorrupted)
+	}
+	tampered := bytes.Replace(b, []byte("UpdateHead"), []byte("Finish"), 1)
+	if _, _, err := Unmarshal(tampered); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Unmarshal() of queue with bad checksum = %v, want %v", err, ErrCorrupted)
+	}
+}
+
+func TestExecutorHooks(t *testing.T) {
+	e := NewExecutor()
+	failure := errors.New("failed")
+	e.Register(Operation{Name: "Noop", Execute: func([]string) error { return nil }})
+	e.Register(Operation{Name: "Fail", Execute: func([]string) error { return failure }})
+	var got []string
+	e.AddHooks(Hooks{
+		Before: func(item Item) error {
+			got = append(got, "before "+item.Operation)
+			if item.Operation == "Noop" && len(item.Args) > 0 {
+				return errors.New("refused")
+			}
+			return nil
+		},
+		After: func(item Item) error {
+			got = append(got, "after "+item.Operation)
+			return nil
+		},
+		OnError: func(item Item, err error) error {
+			got = append(got, "error "+item.Operation)
+			return err
+		},
+	})
+	e.AddHooks(Hooks{
+		After: func(item Item) error {
+			got = append(got, fmt.Sprintf("queued %d", len(e.Queue().Items)))
+			return nil
+		},
+	})
+	e.Enqueue("Noop")
+	e.Enqueue("Fail")
+	e.Enqueue("Noop", "refused")
+	if err := e.Execute(); err != nil {
+		t.Fatalf("Execute(): %v", err)
+	}
+	if err := e.Execute(); err != failure {
+		t.Errorf("Execute() = %v, want %v", err, failure)
+	}
+	if err := e.Execute(); err == nil {
+		t.Errorf("Execute(): expected error from Before hook")
+	}
+	want := []string{"before Noop", "after Noop", "queued 2", "before Fail", "error Fail", "before Noop"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Execute() hooks returned diff (-want +got):\n%s", diff)
+	}
+	if n := len(e.Queue().Items); n != 1 {
+		t.Errorf("Execute() left %d items queued, want the refused item", n)
+	}
+}
+
+func TestExecutorRollbackLast(t *testing.T) {
+	e := NewExecutor()
+	var state []string
+	e.Register(Operation{
+		Name: "Push",
+		Execute: func(args []string) error {
+			state = append(state, args...)
+			return nil
+		},
+		Rollback: func(args []string) error {
+			state = state[:len(state)-len(args)]
+			return nil
+		},
+	})
+	e.Register(Operation{Name: "Noop", Execute: func([]string) error { return nil }})
+	e.Enqueue("Noop")
+	e.Enqueue("Push", "a")
+	e.Enqueue("Push", "b")
+	e.Enqueue("Push", "c")
+	for i := 0; i < 3; i++ {
+		if err := e.Execute(); err != nil {
+			t.Fatalf("Execute(): %v", err)
+		}
+	}
+	if n, err := e.RollbackLast(1); n != 1 || err != nil {
+		t.Errorf("RollbackLast(1) = %d, %v, want 1, nil", n, err)
+	}
+	if diff := cmp.Diff([]string{"a"}, state); diff != "" {
+		t.Errorf("RollbackLast(1) state returned diff (-want +got):\n%s", diff)
+	}
+	if n, err := e.RollbackLast(3); n != 1 || err == nil {
+		t.Errorf("RollbackLast(3) = %d, %v, want 1 and an error for Noop", n, err)
+	}
+	want := Queue{Items: []Item{
+		{Operation: "Push", Args: []string{"a"}},
+		{Operation: "Push", Args: []string{"b"}},
+		{Operation: "Push", Args: []string{"c"}},
+	}}
+	if diff := cmp.Diff(want, e.Queue()); diff != "" {
+		t.Errorf("RollbackLast() queue returned diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]Item{{Operation: "Noop"}}, e.History()); diff != "" {
+		t.Errorf("RollbackLast() history returned diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// FormatVersion is the version of the JSON format queues are saved in. Queues
+// saved in a later format are refused rather than misread.
+const FormatVersion = 1
+
+// ErrCorrupted is returned when a saved queue can't be parsed, or its items
+// don't match their checksum, as happens if writing it was interrupted.
+var ErrCorrupted = errors.New("queue corrupted")
+
+// Header describes the kilt and the kilt branch that saved a queue.
+type Header struct {
+	Format      int    `json:"format"`
+	KiltVersion string `json:"kiltVersion,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	// Schema is the version of the names and arguments of the operations
+	// of the queue, as defined by the caller.
+	Schema int `json:"schema,omitempty"`
+	// Checksum is the SHA-256 of the items, set by Marshal.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+type state struct {
+	Header
+	Items []jsonItem `json:"items"`
+}
+
+// jsonItem is the JSON representation of an Item, which would otherwise be
+// marshalled with its MarshalText method.
+type jsonItem struct {
+	Operation string   `json:"operation"`
+	Args      []string `json:"args,omitempty"`
+}
+
+// Marshal returns the versioned JSON representation of the queue under the
+// header, whose format is set to FormatVersion and checksum to that of the
+// items. Unlike the text format, the JSON format can represent arguments
+// containing spaces.
+func Marshal(q Queue, h Header) ([]byte, error) {
+	s := state{Header: h, Items: []jsonItem{}}
+	for _, i := range q.Items {
+		s.Items = append(s.Items, jsonItem{Operation: i.Operation, Args: i.Args})
+	}
+	sum, err := checksum(s.Items)
+	if err != nil {
+		return nil, err
+	}
+	s.Format, s.Checksum = FormatVersion, sum
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Unmarshal reads a queue saved by Marshal, returning it along with its
+// header. A queue saved in the text format of earlier versions of kilt is read
+// with UnmarshalText, and returned with an empty header. ErrCorrupted is
+// returned if the queue is truncated or its checksum doesn't match.
+func Unmarshal(b []byte) (Queue, Header, error) {
+	var q Queue
+	if trimmed := bytes.TrimSpace(b); len(trimmed) == 0 || trimmed[0] != '{' {
+		err := q.UnmarshalText(b)
+		return q, Header{}, err
+	}
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		return q, Header{}, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	if s.Format > FormatVersion {
+		return q, s.Header, fmt.Errorf("queue saved in format %d by kilt %s, but only format %d is supported", s.Format, s.KiltVersion, FormatVersion)
+	}
+	if s.Checksum != "" {
+		sum, err := checksum(s.Items)
+		if err != nil {
+			return q, s.Header, err
+		}
+		if sum != s.Checksum {
+			return q, s.Header, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+		}
+	}
+	for _, i := range s.Items {
+		q.Items = append(q.Items, Item{Operation: i.Operation, Args: i.Args})
+	}
+	return q, s.Header, nil
+}
+
+func checksum(items []jsonItem) (string, error) {
+	b, err := json.Marshal(items)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
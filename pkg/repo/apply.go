@@ -0,0 +1,323 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/golang/glog"
+
+	"github.com/libgit2/git2go/v30"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+// applyFallbackConfig is the git config option controlling whether patches
+// that fail to cherry-pick are applied with git apply --3way instead.
+const applyFallbackConfig = "kilt.applyFallback"
+
+// cherryPickIndex cherry-picks the commit to the index and work tree, returning
+// the resulting index, which may contain conflicts. If there are conflicts,
+// message is saved for committing the resolution.
+func (r *Repo) cherryPickIndex(commit *git.Commit, message string) (*git.Index, error) {
+	id := commit.Id().String()
+	if sparse, err := r.sparseCheckout(); err != nil {
+		return nil, err
+	} else if sparse {
+		return r.gitCherryPick(id, message)
+	}
+	opts, err := git.DefaultCherrypickOptions()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.git.Cherrypick(commit, opts); err != nil {
+		return r.cherryPickFallback(id, message, err)
+	}
+	return r.git.Index()
+}
+
+// strategyIndex applies the commit to the index and work tree using the
+// method of the strategy, returning the resulting index.
+func (r *Repo) strategyIndex(commit *git.Commit, message string, s patchset.Strategy) (*git.Index, error) {
+	if s.Method == patchset.ThreeWay {
+		return r.threeWayIndex(commit.Id().String(), message)
+	}
+	return r.cherryPickIndex(commit, message)
+}
+
+// cherryPickFallback applies the commit with the given id after a failed
+// cherry-pick, returning the resulting index.
+func (r *Repo) cherryPickFallback(id, message string, cherryPickErr error) (*git.Index, error) {
+	if enabled, err := r.ConfigBool(applyFallbackConfig, true); err != nil {
+		return nil, err
+	} else if !enabled {
+		return nil, cherryPickErr
+	}
+	log.Warningf("Cherry-pick of %s failed, falling back to git apply: %v", id, cherryPickErr)
+	ix, err := r.threeWayIndex(id, message)
+	if err != nil {
+		return nil, fmt.Errorf("cherry-pick failed: %v; fallback failed: %w", cherryPickErr, err)
+	}
+	return ix, nil
+}
+
+// threeWayIndex applies the commit with the given id with git apply --3way,
+// returning the resulting index.
+func (r *Repo) threeWayIndex(id, message string) (*git.Index, error) {
+	patch, err := r.CommitPatch(id)
+	if err != nil {
+		return nil, err
+	}
+	// git apply --3way copes with line ending conversions, filters and other
+	// work tree oddities that cherry-picks through libgit2 can fail on.
+	ix, err := r.runGitToIndex(patch, "apply", "--3way", "--index", "--whitespace=nowarn")
+	if err != nil {
+		return nil, err
+	}
+	return ix, r.saveMergeMessage(ix, message)
+}
+
+// resolveConflicts resolves the conflicts in the index whose paths match a
+// rule of the strategy to the side of that rule, returning the updated index.
+func (r *Repo) resolveConflicts(id string, ix *git.Index, s patchset.Strategy) (*git.Index, error) {
+	it, err := ix.ConflictIterator()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Free()
+	// Paths to take from each side, and paths that the chosen side deletes.
+	take := map[patchset.Side][]string{}
+	var remove []string
+	for {
+		c, err := it.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		path, entry := conflictPath(c), c.Our
+		side := s.Resolve(path)
+		if side == "" {
+			continue
+		} else if side == patchset.Theirs {
+			entry = c.Their
+		}
+		if entry == nil {
+			remove = append(remove, path)
+		} else {
+			take[side] = append(take[side], path)
+		}
+	}
+	if len(take) == 0 && len(remove) == 0 {
+		return ix, nil
+	}
+	for _, side := range []patchset.Side{patchset.Ours, patchset.Theirs} {
+		if paths := take[side]; len(paths) > 0 {
+			if err := r.runGit("", append([]string{"checkout", "--" + string(side), "--"}, paths...)...); err != nil {
+				return nil, err
+			}
+			if err := r.runGit("", append([]string{"add", "--"}, paths...)...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(remove) > 0 {
+		if err := r.runGit("", append([]string{"rm", "-q", "-f", "--"}, remove...)...); err != nil {
+			return nil, err
+		}
+	}
+	log.Warningf("Resolved conflicts of %s in %d paths using the patchset's apply strategy", id, len(take[patchset.Ours])+len(take[patchset.Theirs])+len(remove))
+	ix, err = git.OpenIndex(filepath.Join(r.git.Path(), "index"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	return ix, nil
+}
+
+// conflictPaths returns the paths with conflicts in the index.
+func conflictPaths(ix *git.Index) ([]string, error) {
+	it, err := ix.ConflictIterator()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Free()
+	var paths []string
+	for {
+		c, err := it.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			return paths, nil
+		} else if err != nil {
+			return nil, err
+		}
+		paths = append(paths, conflictPath(c))
+	}
+}
+
+func conflictPath(c git.IndexConflict) string {
+	for _, e := range []*git.IndexEntry{c.Our, c.Their, c.Ancestor} {
+		if e != nil {
+			return e.Path
+		}
+	}
+	return ""
+}
+
+// gitCherryPick cherry-picks the commit with the given id using git, returning
+// the resulting index.
+func (r *Repo) gitCherryPick(id, message string) (*git.Index, error) {
+	ix, err := r.runGitToIndex("", "cherry-pick", "--no-commit", id)
+	if err != nil {
+		return nil, err
+	}
+	return ix, r.saveMergeMessage(ix, message)
+}
+
+// saveMergeMessage saves message for git commit if the index has conflicts.
+func (r *Repo) saveMergeMessage(ix *git.Index, message string) error {
+	if err := Writable("save merge message"); err != nil {
+		return err
+	}
+	if !ix.HasConflicts() {
+		return nil
+	}
+	return ioutil.WriteFile(filepath.Join(r.git.Path(), "MERGE_MSG"), []byte(message), 0666)
+}
+
+// runGitToIndex runs a git command which updates the index, returning the index
+// freshly read from disk. Failures that leave conflicts in the index are not
+// considered errors.
+func (r *Repo) runGitToIndex(stdin string, args ...string) (*git.Index, error) {
+	runErr := r.runGit(stdin, args...)
+	ix, err := git.OpenIndex(filepath.Join(r.git.Path(), "index"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	if runErr != nil && !ix.HasConflicts() {
+		return nil, runErr
+	}
+	return ix, nil
+}
+
+// runGit runs git with the given arguments and input in the work tree.
+func (r *Repo) runGit(stdin string, args ...string) error {
+	if err := Writable("run git " + args[0]); err != nil {
+		return err
+	}
+	_, err := r.gitOutput(stdin, args...)
+	return err
+}
+
+// gitOutput runs git with the given arguments and input in the work tree,
+// returning its output.
+func (r *Repo) gitOutput(stdin string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.git.Workdir()
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// ApplyPatches applies the patches in turn on top of the tree of base, in
+// memory, without touching HEAD, the index or the work tree. It returns the
+// first patch that doesn't apply cleanly, or an empty string if they all do.
+func (r *Repo) ApplyPatches(base string, patches []string) (string, error) {
+	_, conflict, err := r.TreeWithPatches(base, patches)
+	return conflict, err
+}
+
+// TreeWithPatches applies the patches in turn on top of base, a commit or a
+// tree, in memory like ApplyPatches. It returns the id of the resulting tree,
+// or the first patch that doesn't apply cleanly.
+func (r *Repo) TreeWithPatches(base string, patches []string) (tree, conflict string, err error) {
+	obj, err := r.git.RevparseSingle(base)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse %q: %w", base, err)
+	}
+	treeObj, err := obj.Peel(git.ObjectTree)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to peel %q to a tree: %w", base, err)
+	}
+	t, err := treeObj.AsTree()
+	if err != nil {
+		return "", "", err
+	}
+	for _, patch := range patches {
+		c, err := r.lookupCommit(patch)
+		if err != nil {
+			return "", "", err
+		}
+		if c.ParentCount() == 0 {
+			return "", "", fmt.Errorf("patch %s has no parent", patch)
+		}
+		parent, err := c.Parent(0).Tree()
+		if err != nil {
+			return "", "", err
+		}
+		theirs, err := c.Tree()
+		if err != nil {
+			return "", "", err
+		}
+		ix, err := r.git.MergeTrees(parent, t, theirs, nil)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to apply %s: %w", patch, err)
+		}
+		if ix.HasConflicts() {
+			ix.Free()
+			return "", patch, nil
+		}
+		id, err := ix.WriteTreeTo(r.git)
+		ix.Free()
+		if err != nil {
+			return "", "", err
+		}
+		if t, err = r.git.LookupTree(id); err != nil {
+			return "", "", err
+		}
+	}
+	return t.Id().String(), "", nil
+}
+
+// CheckoutTreeTo writes the files of the tree with the given id to dir, which
+// is created if needed, leaving the index and work tree of the repo untouched.
+func (r *Repo) CheckoutTreeTo(tree, dir string) error {
+	id, err := git.NewOid(tree)
+	if err != nil {
+		return err
+	}
+	t, err := r.git.LookupTree(id)
+	if err != nil {
+		return fmt.Errorf("failed to look up tree %s: %w", tree, err)
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return r.git.CheckoutTree(t, &git.CheckoutOpts{
+		Strategy:        git.CheckoutForce | git.CheckoutRecreateMissing | git.CheckoutDontUpdateIndex,
+		TargetDirectory: dir,
+	})
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// maxBackups is the number of finished reworks that are kept for undoing.
+const maxBackups = 10
+
+// Backup is the state of the kilt branch before a finished rework, kept in
+// refs/kilt/<branch>/backup/<n>.
+type Backup struct {
+	// N numbers the backups of the branch, increasing with each rework.
+	N int
+	// Branch is the tip of the branch before the rework.
+	Branch string
+	// Base is the kilt base before the rework.
+	Base string
+	// Result is the tip of the branch the rework finished with.
+	Result string
+}
+
+func (r *Repo) backupRef(n int, name string) string {
+	return path.Join(refPath, r.branch, "backup", strconv.Itoa(n), name)
+}
+
+// Backups returns the backups of the kilt branch, most recent first.
+func (r *Repo) Backups() ([]Backup, error) {
+	prefix := path.Join(refPath, r.branch, "backup") + "/"
+	it, err := r.git.NewReferenceIteratorGlob(prefix + "*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	defer it.Free()
+	backups := map[int]*Backup{}
+	for {
+		ref, err := it.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to list backups: %w", err)
+		}
+		parts := strings.Split(strings.TrimPrefix(ref.Name(), prefix), "/")
+		if len(parts) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		b, ok := backups[n]
+		if !ok {
+			b = &Backup{N: n}
+			backups[n] = b
+		}
+		switch parts[1] {
+		case "branch":
+			b.Branch = ref.Target().String()
+		case "base":
+			b.Base = ref.Target().String()
+		case "result":
+			b.Result = ref.Target().String()
+		}
+	}
+	var list []Backup
+	for _, b := range backups {
+		if b.Branch != "" && b.Base != "" && b.Result != "" {
+			list = append(list, *b)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].N > list[j].N })
+	return list, nil
+}
+
+// SaveBackup records the state of the kilt branch before the rework in
+// progress, which is about to be finished with the head as its result. Only
+// the most recent backups are kept.
+func (r *Repo) SaveBackup() error {
+	if err := Writable("save backup"); err != nil {
+		return err
+	}
+	tip, err := r.ResolveCommit(path.Join(refPath, r.ReworkRef("branch")))
+	if err != nil {
+		return err
+	}
+	base := r.base
+	if saved, err := r.LookupKiltRef(r.ReworkRef("base")); err != nil {
+		return err
+	} else if saved != "" {
+		if base, err = r.ResolveCommit(saved); err != nil {
+			return err
+		}
+	}
+	result, err := r.ResolveCommit("HEAD")
+	if err != nil {
+		return err
+	}
+	backups, err := r.Backups()
+	if err != nil {
+		return err
+	}
+	n := 1
+	if len(backups) > 0 {
+		last := backups[0]
+		if last.Base == base && last.Result == result && (last.Branch == tip || tip == result) {
+			// Already saved by an interrupted or failed finish, which
+			// may have moved the branch to the result.
+			return nil
+		}
+		n = last.N + 1
+	}
+	for name, id := range map[string]string{"branch": tip, "base": base, "result": result} {
+		oid, err := git.NewOid(id)
+		if err != nil {
+			return err
+		}
+		if _, err := r.git.References.Create(r.backupRef(n, name), oid, true, "Saving kilt backup"); err != nil {
+			return fmt.Errorf("failed to save backup: %w", err)
+		}
+	}
+	for i, b := range backups {
+		if i+1 >= maxBackups {
+			if err := r.deleteBackup(b.N); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Repo) deleteBackup(n int) error {
+	if err := Writable("delete backup"); err != nil {
+		return err
+	}
+	for _, name := range []string{"branch", "base", "result"} {
+		ref, err := r.git.References.Lookup(r.backupRef(n, name))
+		if git.IsErrorCode(err, git.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to lookup backup: %w", err)
+		}
+		if err := ref.Delete(); err != nil {
+			return fmt.Errorf("failed to delete backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup moves the kilt branch and base back to the state saved in the
+// backup, checks out the branch and deletes the backup. Unless force is set,
+// the branch must still be at the result of the rework, so that later work
+// isn't lost.
+func (r *Repo) RestoreBackup(b Backup, force bool) error {
+	if err := Writable("restore backup"); err != nil {
+		return err
+	}
+	branch, err := r.git.LookupBranch(r.branch, git.BranchLocal)
+	if err != nil {
+		return fmt.Errorf("failed to lookup branch: %w", err)
+	}
+	if tip := branch.Target().String(); tip != b.Result && !force {
+		return fmt.Errorf("branch %s has changed since the rework was finished, at %s rather than %s", r.branch, tip, b.Result)
+	}
+	tip, err := git.NewOid(b.Branch)
+	if err != nil {
+		return err
+	}
+	base, err := git.NewOid(b.Base)
+	if err != nil {
+		return err
+	}
+	if _, err := branch.SetTarget(tip, "Undoing kilt rework"); err != nil {
+		return fmt.Errorf("failed to restore branch: %w", err)
+	}
+	if _, err := r.git.References.Create(baseRef(r.branch), base, true, "Undoing kilt rework"); err != nil {
+		return fmt.Errorf("failed to restore base: %w", err)
+	}
+	r.base = b.Base
+	r.patchsets = PatchsetCache{}
+	if err := r.CheckoutBranch(r.branch); err != nil {
+		return err
+	}
+	return r.deleteBackup(b.N)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import "fmt"
+
+// inMemoryApplyConfig is the git config option controlling whether runs of
+// patches are cherry-picked in memory.
+const inMemoryApplyConfig = "kilt.inMemoryApply"
+
+// InMemoryApply reports whether runs of patches should be cherry-picked in
+// memory with CherryPickBatchToHead, as set by kilt.inMemoryApply, which
+// defaults to true.
+func (r *Repo) InMemoryApply() (bool, error) {
+	return r.ConfigBool(inMemoryApplyConfig, true)
+}
+
+// CherryPickBatchToHead cherry-picks the commits with the given ids in turn on
+// top of head, merging their trees and creating the new commits in memory,
+// without touching the index or work tree for each of them. Only the final
+// tree is checked out, after which head is moved to the last new commit.
+//
+// Picking stops at the first commit that doesn't apply cleanly, which is left
+// to be cherry-picked on its own so that its conflicts can be resolved. It
+// returns the number of commits picked.
+func (r *Repo) CherryPickBatchToHead(ids []string) (int, error) {
+	if err := Writable("cherry-pick"); err != nil {
+		return 0, err
+	}
+	head, err := r.lookupCommit("HEAD")
+	if err != nil {
+		return 0, err
+	}
+	start, err := head.Tree()
+	if err != nil {
+		return 0, err
+	}
+	parent, tree := head, start
+	picked := 0
+	for _, id := range ids {
+		commit, err := r.lookupCommit(id)
+		if err != nil {
+			return 0, err
+		}
+		if commit.ParentCount() != 1 {
+			break
+		}
+		base, err := commit.Parent(0).Tree()
+		if err != nil {
+			return 0, err
+		}
+		theirs, err := commit.Tree()
+		if err != nil {
+			return 0, err
+		}
+		ix, err := r.git.MergeTrees(base, tree, theirs, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to apply %s: %w", id, err)
+		}
+		if ix.HasConflicts() {
+			ix.Free()
+			break
+		}
+		oid, err := ix.WriteTreeTo(r.git)
+		ix.Free()
+		if err != nil {
+			return 0, err
+		}
+		if tree, err = r.git.LookupTree(oid); err != nil {
+			return 0, err
+		}
+		committer, err := r.rewriteCommitter(commit.Author(), commit.Committer())
+		if err != nil {
+			return 0, err
+		}
+		created, err := r.createCommit("", commit.Author(), committer, commit.Message(), tree, parent)
+		if err != nil {
+			return 0, err
+		}
+		if parent, err = r.git.LookupCommit(created); err != nil {
+			return 0, err
+		}
+		picked++
+	}
+	if picked == 0 {
+		return 0, nil
+	}
+	if err := r.checkoutTree(tree); err != nil {
+		return 0, fmt.Errorf("failed to check out applied patches: %w", err)
+	}
+	if err := r.moveHead(parent.Id()); err != nil {
+		return 0, err
+	}
+	if err := r.git.StateCleanup(); err != nil {
+		return picked, err
+	}
+	return picked, r.syncSubmodules(start, tree)
+}
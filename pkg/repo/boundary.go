@@ -0,0 +1,84 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/libgit2/git2go/v30"
+)
+
+// BoundaryRef returns the name of the ref pointing at the last patch of the
+// named patchset, so that external tools can build the base and the patchsets
+// up to it without knowing about kilt.
+func (r *Repo) BoundaryRef(name string) string {
+	return path.Join(refPath, r.branch, "boundaries", name)
+}
+
+// WriteBoundaryRefs points the boundary ref of each of the patchsets at its
+// last patch, or at its metadata commit if it has no patches, and deletes the
+// boundary refs of other patchsets.
+func (r *Repo) WriteBoundaryRefs(patchsets []*patchset.Patchset) error {
+	if err := Writable("write boundary refs"); err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, ps := range patchsets {
+		id := ps.MetadataCommit()
+		if patches := ps.Patches(); len(patches) > 0 {
+			id = patches[len(patches)-1]
+		}
+		if id == "" {
+			continue
+		}
+		oid, err := git.NewOid(id)
+		if err != nil {
+			return fmt.Errorf("failed to parse commit id %q: %w", id, err)
+		}
+		refName := r.BoundaryRef(ps.Name())
+		keep[refName] = true
+		if _, err := r.git.References.Create(refName, oid, true, fmt.Sprintf("Updating kilt boundary of patchset %s", ps.Name())); err != nil {
+			return fmt.Errorf("failed to create ref %q: %w", refName, err)
+		}
+	}
+	prefix := path.Join(refPath, r.branch, "boundaries") + "/"
+	it, err := r.git.NewReferenceIteratorGlob(prefix + "*")
+	if err != nil {
+		return fmt.Errorf("failed to list boundary refs: %w", err)
+	}
+	defer it.Free()
+	var stale []*git.Reference
+	for {
+		ref, err := it.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to list boundary refs: %w", err)
+		}
+		if !keep[ref.Name()] {
+			stale = append(stale, ref)
+		}
+	}
+	for _, ref := range stale {
+		if err := ref.Delete(); err != nil {
+			return fmt.Errorf("failed to delete ref %q: %w", ref.Name(), err)
+		}
+	}
+	return nil
+}
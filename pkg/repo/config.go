@@ -0,0 +1,68 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// ConfigBool looks up a boolean git config value, returning def if it is not set.
+func (r *Repo) ConfigBool(key string, def bool) (bool, error) {
+	config, err := r.git.Config()
+	if err != nil {
+		return false, fmt.Errorf("failed to open config: %w", err)
+	}
+	v, err := config.LookupBool(key)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return def, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to lookup %q: %w", key, err)
+	}
+	return v, nil
+}
+
+// ConfigString looks up a string git config value, returning def if it is not set.
+func (r *Repo) ConfigString(key, def string) (string, error) {
+	config, err := r.git.Config()
+	if err != nil {
+		return "", fmt.Errorf("failed to open config: %w", err)
+	}
+	v, err := config.LookupString(key)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return def, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to lookup %q: %w", key, err)
+	}
+	return v, nil
+}
+
+// ConfigInt looks up an integer git config value, returning def if it is not set.
+func (r *Repo) ConfigInt(key string, def int) (int, error) {
+	config, err := r.git.Config()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open config: %w", err)
+	}
+	v, err := config.LookupInt64(key)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return def, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to lookup %q: %w", key, err)
+	}
+	return int(v), nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/libgit2/git2go/v30"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+// StackEntry describes a commit of the kilt branch as read from its message,
+// without the fixups applied by the patchset walk.
+type StackEntry struct {
+	ID       string
+	Metadata bool
+	// Patchset is the Patchset-Name of the commit, or empty if it has none.
+	Patchset string
+	// Version is the Patchset-Version of a metadata commit.
+	Version string
+}
+
+// StackEntries returns the commits of the kilt branch from the base to the
+// head.
+func (r *Repo) StackEntries() ([]StackEntry, error) {
+	commits, err := r.branchCommits()
+	if err != nil {
+		return nil, err
+	}
+	var entries []StackEntry
+	for _, c := range commits {
+		fields := parseFields(c.Message())
+		entries = append(entries, StackEntry{
+			ID:       c.Id().String(),
+			Metadata: isMetadataCommit(c),
+			Patchset: fields[patchsetNameField],
+			Version:  fields[patchsetVersionField],
+		})
+	}
+	return entries, nil
+}
+
+// RecordedVersions returns the highest version of each patchset recorded in
+// the patchset version refs of the branch, keyed by patchset name.
+func (r *Repo) RecordedVersions() (map[string]patchset.Version, error) {
+	prefix := path.Join(refPath, r.branch, "patchsets") + "/"
+	refs, err := r.refsWithPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	versions := map[string]patchset.Version{}
+	for _, ref := range refs {
+		name, v := path.Split(strings.TrimPrefix(ref, prefix))
+		name = strings.TrimSuffix(name, "/")
+		version, err := patchset.ParseVersion(strings.TrimPrefix(v, "v"))
+		if err != nil || name == "" {
+			continue
+		}
+		if highest, ok := versions[name]; !ok || version.Cmp(highest) > 0 {
+			versions[name] = version
+		}
+	}
+	return versions, nil
+}
+
+// OrphanedRef is a kilt ref that no kilt branch or rework uses.
+type OrphanedRef struct {
+	Ref    string
+	Reason string
+}
+
+// OrphanedRefs returns the refs under refs/kilt that belong to no kilt
+// branch, to a kilt branch whose git branch was deleted, or to a rework that
+// is no longer in progress.
+func (r *Repo) OrphanedRefs() ([]OrphanedRef, error) {
+	refs, err := r.refsWithPrefix(refPath + "/")
+	if err != nil {
+		return nil, err
+	}
+	// Kilt branches are identified by their base refs, and may contain
+	// slashes, so the longest matching branch owns a ref.
+	var branches []string
+	for _, ref := range refs {
+		if strings.HasSuffix(ref, "/base") && !strings.Contains(ref, "/rework/") && !strings.Contains(ref, "/backup/") && !strings.Contains(ref, "/boundaries/") {
+			branches = append(branches, strings.TrimSuffix(strings.TrimPrefix(ref, refPath+"/"), "/base"))
+		}
+	}
+	sort.Slice(branches, func(i, j int) bool { return len(branches[i]) > len(branches[j]) })
+	var orphans []OrphanedRef
+	for _, ref := range refs {
+		rel := strings.TrimPrefix(ref, refPath+"/")
+		owner := ""
+		for _, b := range branches {
+			if strings.HasPrefix(rel, b+"/") {
+				owner = b
+				break
+			}
+		}
+		switch {
+		case owner == "":
+			orphans = append(orphans, OrphanedRef{ref, "no kilt branch has this ref"})
+		case !r.localBranchExists(owner):
+			orphans = append(orphans, OrphanedRef{ref, fmt.Sprintf("branch %s no longer exists", owner)})
+		case strings.HasPrefix(rel, reworkRef(owner, "")+"/") && rel != reworkRef(owner, "branch"):
+			if ok, err := checkRework(r.git, owner); err != nil {
+				return nil, err
+			} else if !ok {
+				orphans = append(orphans, OrphanedRef{ref, fmt.Sprintf("no rework of %s is in progress", owner)})
+			}
+		}
+	}
+	return orphans, nil
+}
+
+func (r *Repo) localBranchExists(name string) bool {
+	_, err := r.git.LookupBranch(name, git.BranchLocal)
+	return err == nil
+}
+
+// refsWithPrefix returns the names of the refs starting with prefix, sorted.
+func (r *Repo) refsWithPrefix(prefix string) ([]string, error) {
+	it, err := r.git.NewReferenceIteratorGlob(prefix + "*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	defer it.Free()
+	var refs []string
+	for {
+		ref, err := it.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to list refs: %w", err)
+		}
+		refs = append(refs, ref.Name())
+	}
+	sort.Strings(refs)
+	return refs, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// ErrNoData is returned when no data has been stored under a name.
+var ErrNoData = errors.New("no data stored")
+
+// ErrNoFile is returned when a file isn't found in the tree of a revision.
+var ErrNoFile = errors.New("file not found")
+
+// DataRef returns the ref that data stored under name is kept in for the
+// current kilt branch.
+func (r *Repo) DataRef(name string) string {
+	return path.Join(refPath, r.branch, name)
+}
+
+// ReadData returns the contents of file from the data stored under name. If
+// nothing has been stored, ErrNoData is returned.
+func (r *Repo) ReadData(name, file string) ([]byte, error) {
+	refName := r.DataRef(name)
+	ref, err := r.git.References.Lookup(refName)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return nil, ErrNoData
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up %q: %w", refName, err)
+	}
+	commit, err := r.git.LookupCommit(ref.Target())
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up commit for %q: %w", refName, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	entry := tree.EntryByName(file)
+	if entry == nil {
+		return nil, ErrNoData
+	}
+	blob, err := r.git.LookupBlob(entry.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %q in %q: %w", file, refName, err)
+	}
+	return blob.Contents(), nil
+}
+
+// WriteData stores contents as file in the data stored under name. Each write
+// is recorded as a commit on the data ref, so the history of the data is kept.
+func (r *Repo) WriteData(name, file string, contents []byte, message string) error {
+	if err := Writable("write kilt data"); err != nil {
+		return err
+	}
+	refName := r.DataRef(name)
+	blob, err := r.git.CreateBlobFromBuffer(contents)
+	if err != nil {
+		return fmt.Errorf("failed to write %q: %w", file, err)
+	}
+	var parents []*git.Commit
+	builder, err := r.git.TreeBuilder()
+	if err != nil {
+		return err
+	}
+	ref, err := r.git.References.Lookup(refName)
+	if err == nil {
+		parent, err := r.git.LookupCommit(ref.Target())
+		if err != nil {
+			return fmt.Errorf("failed to look up commit for %q: %w", refName, err)
+		}
+		tree, err := parent.Tree()
+		if err != nil {
+			return err
+		}
+		if entry := tree.EntryByName(file); entry != nil && entry.Id.Equal(blob) {
+			return nil
+		}
+		if builder, err = r.git.TreeBuilderFromTree(tree); err != nil {
+			return err
+		}
+		parents = append(parents, parent)
+	} else if !git.IsErrorCode(err, git.ErrNotFound) {
+		return fmt.Errorf("failed to look up %q: %w", refName, err)
+	}
+	if err = builder.Insert(file, blob, git.FilemodeBlob); err != nil {
+		return err
+	}
+	treeID, err := builder.Write()
+	if err != nil {
+		return err
+	}
+	tree, err := r.git.LookupTree(treeID)
+	if err != nil {
+		return err
+	}
+	sig, err := r.git.DefaultSignature()
+	if err != nil {
+		return err
+	}
+	// Data commits only live under kilt refs, outside of the branch, so they
+	// are left unsigned rather than prompting for a passphrase.
+	id, err := r.git.CreateCommit("", sig, sig, message, tree, parents...)
+	if err != nil {
+		return fmt.Errorf("failed to commit %q: %w", file, err)
+	}
+	if _, err = r.git.References.Create(refName, id, true, message); err != nil {
+		return fmt.Errorf("failed to update %q: %w", refName, err)
+	}
+	return nil
+}
+
+// ReadFile returns the contents of the file at path in the tree of the
+// revision. If there is no such file, ErrNoFile is returned.
+func (r *Repo) ReadFile(rev, file string) ([]byte, error) {
+	commit, err := r.lookupCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := tree.EntryByPath(file)
+	if git.IsErrorCode(err, git.ErrNotFound) || err == nil && entry.Type != git.ObjectBlob {
+		return nil, ErrNoFile
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up %q in %q: %w", file, rev, err)
+	}
+	blob, err := r.git.LookupBlob(entry.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %q in %q: %w", file, rev, err)
+	}
+	return blob.Contents(), nil
+}
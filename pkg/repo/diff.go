@@ -0,0 +1,365 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// CommitInfo describes a single commit.
+type CommitInfo struct {
+	ID          string
+	Summary     string
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	AuthorDate  time.Time
+}
+
+// DiffStat summarizes the size of a diff.
+type DiffStat struct {
+	FilesChanged int
+	Insertions   int
+	Deletions    int
+}
+
+// Trailer is a "Key: value" trailer from a commit message.
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+func (r *Repo) lookupCommit(rev string) (*git.Commit, error) {
+	obj, err := r.git.RevparseSingle(rev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", rev, err)
+	}
+	commitObj, err := obj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to peel %q to a commit: %w", rev, err)
+	}
+	return commitObj.AsCommit()
+}
+
+func (r *Repo) diffTrees(from, to *git.Tree) (*git.Diff, error) {
+	opts, err := git.DefaultDiffOptions()
+	if err != nil {
+		return nil, err
+	}
+	return r.git.DiffTreeToTree(from, to, &opts)
+}
+
+func diffFiles(diff *git.Diff) ([]string, error) {
+	n, err := diff.NumDeltas()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for i := 0; i < n; i++ {
+		delta, err := diff.GetDelta(i)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, delta.NewFile.Path)
+		if delta.OldFile.Path != delta.NewFile.Path {
+			files = append(files, delta.OldFile.Path)
+		}
+	}
+	return files, nil
+}
+
+// ResolveCommit returns the full id of the commit that rev refers to.
+func (r *Repo) ResolveCommit(rev string) (string, error) {
+	commit, err := r.lookupCommit(rev)
+	if err != nil {
+		return "", err
+	}
+	return commit.Id().String(), nil
+}
+
+// CommitInfo returns a description of the commit with the given id.
+func (r *Repo) CommitInfo(id string) (CommitInfo, error) {
+	commit, err := r.lookupCommit(id)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	author := commit.Author()
+	return CommitInfo{
+		ID:          commit.Id().String(),
+		Summary:     commit.Summary(),
+		Message:     commit.Message(),
+		AuthorName:  author.Name,
+		AuthorEmail: author.Email,
+		AuthorDate:  author.When,
+	}, nil
+}
+
+// commitDiff returns the diff of the commit against its first parent.
+func (r *Repo) commitDiff(id string) (*git.Diff, error) {
+	commit, err := r.lookupCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *git.Tree
+	if commit.ParentCount() > 0 {
+		if parentTree, err = commit.Parent(0).Tree(); err != nil {
+			return nil, err
+		}
+	}
+	return r.diffTrees(parentTree, tree)
+}
+
+// CommitPatch returns the patch text of the commit with the given id.
+func (r *Repo) CommitPatch(id string) (string, error) {
+	diff, err := r.commitDiff(id)
+	if err != nil {
+		return "", err
+	}
+	defer diff.Free()
+	if err := r.checkDiffSize(diff); err != nil {
+		return "", err
+	}
+	return diffPatch(diff)
+}
+
+func diffPatch(diff *git.Diff) (string, error) {
+	b, err := diff.ToBuf(git.DiffFormatPatch)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// PatchID returns an id for the changes made by the commit with the given id.
+// Like git patch-id, it ignores line numbers and whitespace, so the same change
+// applied to different bases has the same id.
+func (r *Repo) PatchID(id string) (string, error) {
+	patch, err := r.CommitPatch(id)
+	if err != nil {
+		return "", err
+	}
+	return patchID(patch), nil
+}
+
+func patchID(patch string) string {
+	h := sha1.New()
+	for _, l := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(l, "index ") || strings.HasPrefix(l, "@@") {
+			continue
+		}
+		io.WriteString(h, strings.Join(strings.Fields(l), ""))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CommitDiffStat returns a git-style diffstat of the commit with the given id.
+func (r *Repo) CommitDiffStat(id string) (string, error) {
+	diff, err := r.commitDiff(id)
+	if err != nil {
+		return "", err
+	}
+	defer diff.Free()
+	return diffStat(diff)
+}
+
+func diffStat(diff *git.Diff) (string, error) {
+	stats, err := diff.Stats()
+	if err != nil {
+		return "", err
+	}
+	defer stats.Free()
+	return stats.String(git.DiffStatsFull|git.DiffStatsIncludeSummary, 72)
+}
+
+// CommitStat returns the number of files changed, insertions and deletions of the commit with the given id.
+func (r *Repo) CommitStat(id string) (DiffStat, error) {
+	diff, err := r.commitDiff(id)
+	if err != nil {
+		return DiffStat{}, err
+	}
+	defer diff.Free()
+	stats, err := diff.Stats()
+	if err != nil {
+		return DiffStat{}, err
+	}
+	defer stats.Free()
+	return DiffStat{
+		FilesChanged: stats.FilesChanged(),
+		Insertions:   stats.Insertions(),
+		Deletions:    stats.Deletions(),
+	}, nil
+}
+
+// CommitTrailers returns the trailers of the message of the commit with the given id.
+func (r *Repo) CommitTrailers(id string) ([]Trailer, error) {
+	commit, err := r.lookupCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	trailers, err := git.MessageTrailers(commit.Message())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trailers: %w", err)
+	}
+	var result []Trailer
+	for _, t := range trailers {
+		result = append(result, Trailer{Key: t.Key, Value: t.Value})
+	}
+	return result, nil
+}
+
+// ChangedFiles returns the paths modified by the commit with the given id, relative to its first parent.
+func (r *Repo) ChangedFiles(id string) ([]string, error) {
+	diff, err := r.commitDiff(id)
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Free()
+	return diffFiles(diff)
+}
+
+func (r *Repo) diffBetween(from, to string) (*git.Diff, error) {
+	fromCommit, err := r.lookupCommit(from)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := r.lookupCommit(to)
+	if err != nil {
+		return nil, err
+	}
+	fromTree, err := fromCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := toCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return r.diffTrees(fromTree, toTree)
+}
+
+// ChangedFilesBetween returns the paths that differ between the trees of the two revisions.
+func (r *Repo) ChangedFilesBetween(from, to string) ([]string, error) {
+	diff, err := r.diffBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Free()
+	return diffFiles(diff)
+}
+
+// PatchBetween returns the patch text of the changes between the trees of the two revisions.
+func (r *Repo) PatchBetween(from, to string) (string, error) {
+	diff, err := r.diffBetween(from, to)
+	if err != nil {
+		return "", err
+	}
+	defer diff.Free()
+	if err := r.checkDiffSize(diff); err != nil {
+		return "", err
+	}
+	return diffPatch(diff)
+}
+
+// DiffStatBetween returns a git-style diffstat of the changes between the trees of the two revisions.
+func (r *Repo) DiffStatBetween(from, to string) (string, error) {
+	diff, err := r.diffBetween(from, to)
+	if err != nil {
+		return "", err
+	}
+	defer diff.Free()
+	return diffStat(diff)
+}
+
+// AheadBehind returns the number of commits that rev has which upstream does
+// not, and the number of commits upstream has which rev does not.
+func (r *Repo) AheadBehind(rev, upstream string) (int, int, error) {
+	revCommit, err := r.lookupCommit(rev)
+	if err != nil {
+		return 0, 0, err
+	}
+	upstreamCommit, err := r.lookupCommit(upstream)
+	if err != nil {
+		return 0, 0, err
+	}
+	return r.git.AheadBehind(revCommit.Id(), upstreamCommit.Id())
+}
+
+// IsAncestor returns whether the revision ancestor is an ancestor of rev, or
+// the same commit.
+func (r *Repo) IsAncestor(ancestor, rev string) (bool, error) {
+	a, err := r.lookupCommit(ancestor)
+	if err != nil {
+		return false, err
+	}
+	c, err := r.lookupCommit(rev)
+	if err != nil {
+		return false, err
+	}
+	if a.Id().Equal(c.Id()) {
+		return true, nil
+	}
+	return r.git.DescendantOf(c.Id(), a.Id())
+}
+
+// MergeBase returns the id of the best common ancestor of the two revisions.
+func (r *Repo) MergeBase(one, two string) (string, error) {
+	oneCommit, err := r.lookupCommit(one)
+	if err != nil {
+		return "", err
+	}
+	twoCommit, err := r.lookupCommit(two)
+	if err != nil {
+		return "", err
+	}
+	oid, err := r.git.MergeBase(oneCommit.Id(), twoCommit.Id())
+	if err != nil {
+		return "", fmt.Errorf("failed to find merge base: %w", err)
+	}
+	return oid.String(), nil
+}
+
+// FetchUpstream fetches the remote that the given remote-tracking ref belongs to.
+func (r *Repo) FetchUpstream(upstream string) error {
+	ref, err := r.git.References.Dwim(upstream)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %w", upstream, err)
+	}
+	if !ref.IsRemote() {
+		return fmt.Errorf("%q is not a remote-tracking ref", upstream)
+	}
+	name, err := r.git.RemoteName(ref.Name())
+	if err != nil {
+		return fmt.Errorf("failed to find remote for %q: %w", upstream, err)
+	}
+	remote, err := r.git.Remotes.Lookup(name)
+	if err != nil {
+		return fmt.Errorf("failed to lookup remote %q: %w", name, err)
+	}
+	defer remote.Free()
+	return r.fetch(remote)
+}
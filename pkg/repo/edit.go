@@ -0,0 +1,273 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/kilt/pkg/patchset"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+const editHelp = `# Editing the metadata of patchset %s.
+# The first line is the name of the patchset, followed by a blank line and the
+# description. Metadata fields, such as "Owner: name", go in a final paragraph
+# of their own. Lines starting with # are ignored.
+`
+
+// EditableMetadata returns the name, description and fields of the patchset
+// as text for the user to edit.
+func EditableMetadata(ps *patchset.Patchset) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, editHelp, ps.Name())
+	fmt.Fprintf(&b, "%s\n", ps.Name())
+	if d := ps.Description(); d != "" {
+		fmt.Fprintf(&b, "\n%s\n", d)
+	}
+	if len(ps.Fields()) > 0 {
+		b.WriteString("\n")
+		for _, f := range ps.Fields() {
+			fmt.Fprintf(&b, "%s: %s\n", f.Key, f.Value)
+		}
+	}
+	return []byte(b.String())
+}
+
+// ParseEditedMetadata returns a copy of the patchset with the name,
+// description and fields from text edited by the user.
+func ParseEditedMetadata(ps *patchset.Patchset, text []byte) (*patchset.Patchset, error) {
+	var lines []string
+	for _, l := range strings.Split(string(text), "\n") {
+		if !strings.HasPrefix(l, "#") {
+			lines = append(lines, strings.TrimRight(l, " \t"))
+		}
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no patchset name given")
+	}
+	name := strings.TrimSpace(lines[0])
+	if !ValidPatchsetName(name) {
+		return nil, fmt.Errorf("invalid patchset name %q", name)
+	}
+	body := lines[1:]
+	start := len(body)
+	for start > 0 && body[start-1] != "" {
+		start--
+	}
+	var fields []patchset.Field
+	for _, l := range body[start:] {
+		f := fieldsRegexp.FindStringSubmatch(l)
+		if len(f) != 3 {
+			fields = nil
+			start = len(body)
+			break
+		}
+		fields = append(fields, patchset.Field{Key: f[1], Value: f[2]})
+	}
+	edited := patchset.Load(name, ps.UUID().String(), ps.Version())
+	edited.SetDescription(strings.TrimSpace(strings.Join(body[:start], "\n")))
+	for _, f := range fields {
+		if err := CheckField(f.Key, f.Value); err != nil {
+			return nil, err
+		}
+		edited.SetField(f.Key, f.Value)
+	}
+	return edited, nil
+}
+
+// SameMetadata returns whether the two patchsets have the same name,
+// description and fields.
+func SameMetadata(a, b *patchset.Patchset) bool {
+	return metadataMessage(a) == metadataMessage(b)
+}
+
+// CreateMetadataTemplate creates a metadata commit for the patchset that isn't
+// on any branch, with the same parent as the patchset's metadata commit. It
+// serves as the template of an updated metadata commit in a rework.
+func (r *Repo) CreateMetadataTemplate(ps *patchset.Patchset, metadata string) (string, error) {
+	if err := Writable("create metadata commit"); err != nil {
+		return "", err
+	}
+	commit, err := r.lookupCommit(metadata)
+	if err != nil {
+		return "", err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit tree: %w", err)
+	}
+	var parents []*git.Commit
+	for i := uint(0); i < commit.ParentCount(); i++ {
+		parents = append(parents, commit.Parent(i))
+	}
+	oid, err := r.createCommit("", commit.Author(), commit.Committer(), metadataMessage(ps), tree, parents...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create metadata commit: %w", err)
+	}
+	return oid.String(), nil
+}
+
+// PatchsetFromCommit returns the patchset described by the metadata commit.
+func (r *Repo) PatchsetFromCommit(id string) (*patchset.Patchset, error) {
+	commit, err := r.lookupCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	if !isMetadataCommit(commit) {
+		return nil, fmt.Errorf("commit %s is not a metadata commit", id)
+	}
+	return patchsetFromMetadata(commit.Message())
+}
+
+// SquashToHead melds the changes of the commit into the head commit. The
+// message of the head commit is kept, with the body of the commit's message
+// appended if squash is set, as git rebase --autosquash does for squash!
+// commits.
+func (r *Repo) SquashToHead(id string, squash bool) error {
+	if err := Writable("squash commit"); err != nil {
+		return err
+	}
+	commit, err := r.lookupCommit(id)
+	if err != nil {
+		return err
+	}
+	head, err := r.lookupCommit("HEAD")
+	if err != nil {
+		return err
+	}
+	ix, err := r.cherryPickIndex(commit, commit.Message())
+	if err != nil {
+		return err
+	}
+	if ix.HasConflicts() {
+		return ErrUserActionRequired
+	}
+	oid, err := ix.WriteTreeTo(r.git)
+	if err != nil {
+		return err
+	}
+	tree, err := r.git.LookupTree(oid)
+	if err != nil {
+		return err
+	}
+	var parents []*git.Commit
+	for i := uint(0); i < head.ParentCount(); i++ {
+		parents = append(parents, head.Parent(i))
+	}
+	committer, err := r.rewriteCommitter(head.Author(), head.Committer())
+	if err != nil {
+		return err
+	}
+	message := squashMessage(head.Message(), commit.Message(), squash)
+	squashed, err := r.createCommit("", head.Author(), committer, message, tree, parents...)
+	if err != nil {
+		return fmt.Errorf("failed to create squashed commit: %w", err)
+	}
+	if err := r.moveHead(squashed); err != nil {
+		return err
+	}
+	return r.git.StateCleanup()
+}
+
+// moveHead points the head, or the branch it refers to, at the commit.
+func (r *Repo) moveHead(id *git.Oid) error {
+	if err := Writable("move head"); err != nil {
+		return err
+	}
+	if detached, err := r.git.IsHeadDetached(); err != nil {
+		return err
+	} else if detached {
+		return r.git.SetHeadDetached(id)
+	}
+	ref, err := r.git.Head()
+	if err != nil {
+		return err
+	}
+	_, err = ref.SetTarget(id, "kilt: squash")
+	return err
+}
+
+// squashMessage returns the message of a commit with a fixup melded into it.
+// For a squash, the fixup's body is appended without its squash! subject and
+// kilt footers.
+func squashMessage(target, fixup string, squash bool) string {
+	target = strings.TrimRight(target, "\n") + "\n"
+	if !squash {
+		return target
+	}
+	lines := strings.Split(strings.TrimRight(fixup, "\n"), "\n")
+	if strings.HasPrefix(lines[0], "squash! ") || strings.HasPrefix(lines[0], "fixup! ") {
+		lines = lines[1:]
+	}
+	var body []string
+	for _, l := range lines {
+		if f := fieldsRegexp.FindStringSubmatch(l); len(f) == 3 && (f[1] == patchsetNameField || f[1] == FixesPatchField) {
+			continue
+		}
+		body = append(body, l)
+	}
+	text := strings.TrimSpace(strings.Join(body, "\n"))
+	if text == "" {
+		return target
+	}
+	return target + "\n" + text + "\n"
+}
+
+// FixesPatchField is the footer of a patch that should be melded into the
+// patch it names, by commit id or subject.
+const FixesPatchField = "Fixes-Patch"
+
+// CollapseToHead replaces the commits between base and the head with a single
+// commit of the head's tree, with the given message.
+func (r *Repo) CollapseToHead(base, message string) error {
+	if err := Writable("collapse commits"); err != nil {
+		return err
+	}
+	parent, err := r.lookupCommit(base)
+	if err != nil {
+		return err
+	}
+	head, err := r.lookupCommit("HEAD")
+	if err != nil {
+		return err
+	}
+	tree, err := head.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to get commit tree: %w", err)
+	}
+	author, err := r.authorSignature()
+	if err != nil {
+		return err
+	}
+	committer, err := r.committerSignature()
+	if err != nil {
+		return err
+	}
+	collapsed, err := r.createCommit("", author, committer, message, tree, parent)
+	if err != nil {
+		return fmt.Errorf("failed to create collapsed commit: %w", err)
+	}
+	return r.moveHead(collapsed)
+}
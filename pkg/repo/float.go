@@ -0,0 +1,110 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+
+	git "github.com/libgit2/git2go/v30"
+)
+
+// FloatPatch sets the Patchset-Name footer of the commit rev to name, making it
+// a floating patch of that patchset until the next rework places it. The
+// commit must be a patch on the top of the branch, after the metadata commit of
+// the last patchset, so that only the commit and the patches above it are
+// rewritten, keeping their trees, and there must be no merge above it. It
+// returns the id of the rewritten commit.
+func (r *Repo) FloatPatch(rev, name string) (string, error) {
+	if err := Writable("float patch"); err != nil {
+		return "", err
+	}
+	branch, err := r.git.LookupBranch(r.head, git.BranchLocal)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up branch %q: %w", r.head, err)
+	}
+	target, err := r.lookupCommit(rev)
+	if err != nil {
+		return "", err
+	}
+	commits, err := r.branchCommits()
+	if err != nil {
+		return "", err
+	}
+	top := -1
+	for i, c := range commits {
+		if isMetadataCommit(c) {
+			top = -1
+		} else if c.Id().Equal(target.Id()) {
+			top = i
+		}
+	}
+	if top < 0 {
+		return "", fmt.Errorf("commit %s is not a patch on the top of the branch", target.Id())
+	}
+	// The walk of the branch leaves out merges, so the commits to rewrite are
+	// found by following the first parents from the tip of the branch.
+	tip, err := r.git.LookupCommit(branch.Target())
+	if err != nil {
+		return "", err
+	}
+	var rewrite []*git.Commit
+	for c := tip; ; c = c.Parent(0) {
+		if c == nil {
+			return "", fmt.Errorf("commit %s is not a patch on the top of the branch", target.Id())
+		}
+		if c.ParentCount() != 1 {
+			return "", fmt.Errorf("merge %s can't be rewritten", c.Id())
+		}
+		rewrite = append([]*git.Commit{c}, rewrite...)
+		if c.Id().Equal(target.Id()) {
+			break
+		}
+	}
+	if parseFields(target.Message())[patchsetNameField] == name {
+		return "", fmt.Errorf("commit %s already belongs to patchset %q", target.Id(), name)
+	}
+	parent := target.Parent(0)
+	var floated string
+	for i, c := range rewrite {
+		message := c.Message()
+		if i == 0 {
+			message = WithPatchsetName(message, name)
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			return "", err
+		}
+		committer, err := r.rewriteCommitter(c.Author(), c.Committer())
+		if err != nil {
+			return "", err
+		}
+		id, err := r.createCommit("", c.Author(), committer, message, tree, parent)
+		if err != nil {
+			return "", fmt.Errorf("failed to rewrite %s: %w", c.Id(), err)
+		}
+		if parent, err = r.git.LookupCommit(id); err != nil {
+			return "", err
+		}
+		if i == 0 {
+			floated = id.String()
+		}
+	}
+	if _, err := branch.Reference.SetTarget(parent.Id(), "kilt: float "+target.Id().String()); err != nil {
+		return "", err
+	}
+	return floated, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// defaultPatchsetConfig is the git config option naming the patchset that
+	// the commit-msg hook assigns new commits to.
+	defaultPatchsetConfig = "kilt.defaultPatchset"
+
+	// commitMsgHook is the commit-msg hook installed by InstallCommitMsgHook.
+	commitMsgHook = `#!/bin/sh
+# Installed by kilt hooks install.
+exec kilt lint-msg --fix "$1"
+`
+)
+
+// ErrHookExists is returned when installing a hook would replace a different
+// existing hook.
+var ErrHookExists = errors.New("hook already exists")
+
+// hooksDirectory returns the directory git runs hooks from, as set by
+// core.hooksPath, which defaults to the hooks directory of the repo.
+func (r *Repo) hooksDirectory() (string, error) {
+	dir, err := r.ConfigString("core.hooksPath", "")
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		return filepath.Join(r.git.Path(), "hooks"), nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(r.Workdir(), dir)
+	}
+	return dir, nil
+}
+
+// InstallCommitMsgHook installs a commit-msg hook that runs kilt lint-msg --fix
+// on each new commit message, returning its path. An existing commit-msg hook
+// is only replaced if force is set.
+func (r *Repo) InstallCommitMsgHook(force bool) (string, error) {
+	if err := Writable("install hook"); err != nil {
+		return "", err
+	}
+	dir, err := r.hooksDirectory()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "commit-msg")
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if bytes.Equal(b, []byte(commitMsgHook)) {
+			return path, nil
+		}
+		if !force {
+			return "", fmt.Errorf("%s: %w", path, ErrHookExists)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(commitMsgHook), 0777); err != nil {
+		return "", err
+	}
+	// WriteFile keeps the mode of an existing file.
+	return path, os.Chmod(path, 0777)
+}
+
+// DefaultPatchset returns the name of the patchset new commits are assigned
+// to: the one set by kilt.defaultPatchset, or else the patchset of the head
+// commit, whether a patch or a metadata commit. It returns an empty string if
+// there is neither.
+func (r *Repo) DefaultPatchset() (string, error) {
+	name, err := r.ConfigString(defaultPatchsetConfig, "")
+	if err != nil || name != "" {
+		return name, err
+	}
+	head, err := r.lookupCommit("HEAD")
+	if err != nil {
+		return "", err
+	}
+	if isMetadataCommit(head) {
+		ps, err := patchsetFromMetadata(head.Message())
+		if err != nil {
+			return "", nil
+		}
+		return ps.Name(), nil
+	}
+	return parseFields(head.Message())[patchsetNameField], nil
+}
+
+// HasPatchsetName reports whether the message has a Patchset-Name footer.
+func HasPatchsetName(message string) bool {
+	_, ok := parseFields(message)[patchsetNameField]
+	return ok
+}
+
+// LintMessage returns the problems with the Patchset-Name footer of a commit
+// message: it must be given once, in the trailing paragraph of the message,
+// and name one of the patchsets that isn't archived, unless patchsets is
+// empty. Metadata commits and fixup! and squash! commits, which are melded into
+// other patches, are not checked.
+func LintMessage(message string, patchsets PatchsetCache) []string {
+	if strings.HasPrefix(message, metadataPrefix) || strings.HasPrefix(message, "fixup! ") || strings.HasPrefix(message, "squash! ") {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	start := len(lines)
+	for start > 1 && lines[start-1] != "" {
+		start--
+	}
+	var problems, names []string
+	for i, l := range lines[1:] {
+		f := fieldsRegexp.FindStringSubmatch(l)
+		if len(f) != 3 || f[1] != patchsetNameField {
+			continue
+		}
+		if i+1 < start {
+			problems = append(problems, fmt.Sprintf("%s footer is not in the trailing paragraph", patchsetNameField))
+		}
+		names = append(names, strings.TrimSpace(f[2]))
+	}
+	switch {
+	case len(names) == 0:
+		return append(problems, fmt.Sprintf("missing %s footer", patchsetNameField))
+	case len(names) > 1:
+		return append(problems, fmt.Sprintf("%s footer given %d times", patchsetNameField, len(names)))
+	}
+	name := names[0]
+	if !ValidPatchsetName(name) {
+		return append(problems, fmt.Sprintf("invalid patchset name %q", name))
+	}
+	if len(patchsets.Slice) == 0 {
+		return problems
+	}
+	if ps, ok := patchsets.Lookup(name); !ok {
+		problems = append(problems, fmt.Sprintf("unknown patchset %q", name))
+	} else if ps.Name() != name {
+		problems = append(problems, fmt.Sprintf("patchset %q is named %q", name, ps.Name()))
+	} else if ps.Archived() {
+		problems = append(problems, fmt.Sprintf("patchset %q is archived", name))
+	}
+	return problems
+}
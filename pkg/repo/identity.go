@@ -0,0 +1,173 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// CommitterMode controls the committer of commits that kilt rewrites, such as
+// cherry-picked patches.
+type CommitterMode string
+
+const (
+	// PreserveCommitter keeps the committer and commit date of the original
+	// commit. This is the default.
+	PreserveCommitter CommitterMode = "preserve"
+	// ResetCommitter makes the current user the committer, with the current
+	// date, as git rebase does.
+	ResetCommitter CommitterMode = "reset"
+	// CommitterDateIsAuthorDate makes the current user the committer, with the
+	// author date of the original commit as the commit date, as git rebase
+	// --committer-date-is-author-date does.
+	CommitterDateIsAuthorDate CommitterMode = "author-date"
+)
+
+// committerConfig is the git config option setting the default committer
+// mode.
+const committerConfig = "kilt.committer"
+
+var committerMode CommitterMode
+
+// ParseCommitterMode parses the name of a committer mode.
+func ParseCommitterMode(s string) (CommitterMode, error) {
+	switch m := CommitterMode(s); m {
+	case PreserveCommitter, ResetCommitter, CommitterDateIsAuthorDate:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown committer mode %q, want %s, %s or %s", s, PreserveCommitter, ResetCommitter, CommitterDateIsAuthorDate)
+}
+
+// SetCommitterMode sets the committer mode for rewritten commits, overriding
+// the kilt.committer git config option.
+func SetCommitterMode(m CommitterMode) {
+	committerMode = m
+}
+
+// committerMode returns the committer mode set with SetCommitterMode, or else
+// the one configured with kilt.committer.
+func (r *Repo) committerMode() (CommitterMode, error) {
+	if committerMode != "" {
+		return committerMode, nil
+	}
+	v, err := r.ConfigString(committerConfig, string(PreserveCommitter))
+	if err != nil {
+		return "", err
+	}
+	return ParseCommitterMode(v)
+}
+
+// rewriteCommitter returns the committer for a rewrite of a commit with the
+// given author and committer, according to the committer mode.
+func (r *Repo) rewriteCommitter(author, committer *git.Signature) (*git.Signature, error) {
+	mode, err := r.committerMode()
+	if err != nil || mode == PreserveCommitter {
+		return committer, err
+	}
+	sig, err := r.committerSignature()
+	if err != nil {
+		return nil, err
+	}
+	if mode == CommitterDateIsAuthorDate {
+		sig.When = author.When
+	}
+	return sig, nil
+}
+
+// authorSignature returns the signature of the current user as the author of
+// a new commit, honoring GIT_AUTHOR_NAME, GIT_AUTHOR_EMAIL and GIT_AUTHOR_DATE.
+func (r *Repo) authorSignature() (*git.Signature, error) {
+	return r.envSignature("AUTHOR")
+}
+
+// committerSignature returns the signature of the current user as the
+// committer of a new commit, honoring GIT_COMMITTER_NAME, GIT_COMMITTER_EMAIL
+// and GIT_COMMITTER_DATE.
+func (r *Repo) committerSignature() (*git.Signature, error) {
+	return r.envSignature("COMMITTER")
+}
+
+// envSignature returns the default signature of the repo, with its name,
+// email and date overridden by the GIT_<role>_* environment variables as git
+// does.
+func (r *Repo) envSignature(role string) (*git.Signature, error) {
+	name, email := os.Getenv("GIT_"+role+"_NAME"), os.Getenv("GIT_"+role+"_EMAIL")
+	sig := &git.Signature{Name: name, Email: email, When: time.Now()}
+	if name == "" || email == "" {
+		def, err := r.git.DefaultSignature()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get default signature: %w", err)
+		}
+		if sig.Name == "" {
+			sig.Name = def.Name
+		}
+		if sig.Email == "" {
+			sig.Email = def.Email
+		}
+	}
+	if date := os.Getenv("GIT_" + role + "_DATE"); date != "" {
+		when, err := parseGitDate(date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GIT_%s_DATE: %w", role, err)
+		}
+		sig.When = when
+	}
+	return sig, nil
+}
+
+// gitDateLayouts are the date formats accepted in GIT_AUTHOR_DATE and
+// GIT_COMMITTER_DATE besides git's internal format.
+var gitDateLayouts = []string{
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+}
+
+// parseGitDate parses a date in git's internal format, "<unix seconds>
+// <offset>" optionally prefixed with @, in RFC 2822 or in ISO 8601 format.
+// Dates without a time zone are taken to be local.
+func parseGitDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if f := strings.Fields(strings.TrimPrefix(s, "@")); len(f) == 1 || len(f) == 2 {
+		if secs, err := strconv.ParseInt(f[0], 10, 64); err == nil {
+			t := time.Unix(secs, 0)
+			if len(f) == 1 {
+				return t, nil
+			}
+			zone, err := time.Parse("-0700", f[1])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid time zone in %q", s)
+			}
+			return t.In(zone.Location()), nil
+		}
+	}
+	for _, layout := range gitDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+const (
+	// maxCommitsConfig is the git config option limiting the number of commits
+	// walked between the base and the head. Zero disables the limit.
+	maxCommitsConfig  = "kilt.maxCommits"
+	defaultMaxCommits = 10000
+
+	// maxPatchSizeConfig is the git config option limiting the total size in
+	// bytes of the files changed by a patch that kilt renders as text. Zero
+	// disables the limit.
+	maxPatchSizeConfig  = "kilt.maxPatchSize"
+	defaultMaxPatchSize = 64 << 20
+)
+
+// ErrLimitExceeded is returned when a walk or a patch exceeds the limits
+// configured with kilt.maxCommits or kilt.maxPatchSize, which usually means the
+// kilt base is misconfigured.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// MaxCommits returns the maximum number of commits walked between the base and
+// the head, or zero if there is no limit.
+func (r *Repo) MaxCommits() (int, error) {
+	return r.ConfigInt(maxCommitsConfig, defaultMaxCommits)
+}
+
+// commitLimitError returns the error for a walk from head to base that passed
+// max commits.
+func commitLimitError(head, base *git.Oid, max int) error {
+	return fmt.Errorf("%w: more than %d commits between base %s and head %s; the kilt base is probably wrong, check it with kilt base verify, or raise %s",
+		ErrLimitExceeded, max, shortID(base), shortID(head), maxCommitsConfig)
+}
+
+// checkDiffSize returns an error if the blobs on either side of the diff add
+// up to more than kilt.maxPatchSize.
+func (r *Repo) checkDiffSize(diff *git.Diff) error {
+	max, err := r.ConfigInt(maxPatchSizeConfig, defaultMaxPatchSize)
+	if err != nil || max <= 0 {
+		return err
+	}
+	n, err := diff.NumDeltas()
+	if err != nil {
+		return err
+	}
+	odb, err := r.git.Odb()
+	if err != nil {
+		return err
+	}
+	defer odb.Free()
+	var size uint64
+	for i := 0; i < n; i++ {
+		delta, err := diff.Delta(i)
+		if err != nil {
+			return err
+		}
+		for _, f := range []git.DiffFile{delta.OldFile, delta.NewFile} {
+			if f.Oid == nil || f.Oid.IsZero() || !odb.Exists(f.Oid) {
+				continue
+			}
+			s, _, err := odb.ReadHeader(f.Oid)
+			if err != nil {
+				return err
+			}
+			size += s
+		}
+		if size > uint64(max) {
+			return fmt.Errorf("%w: changes to %d files are larger than %d bytes; if the kilt base is wrong, check it with kilt base verify, or raise %s",
+				ErrLimitExceeded, n, max, maxPatchSizeConfig)
+		}
+	}
+	return nil
+}
+
+func shortID(id *git.Oid) string {
+	return id.String()[:12]
+}
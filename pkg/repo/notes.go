@@ -0,0 +1,134 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// NotesRef is the git notes ref that kilt keeps review notes on patches in.
+// The notes can also be read with git log --notes=kilt.
+const NotesRef = "refs/notes/kilt"
+
+// PatchNote returns the kilt note attached to the patch with the given id, or
+// an empty string if it has none.
+func (r *Repo) PatchNote(id string) (string, error) {
+	oid, err := git.NewOid(id)
+	if err != nil {
+		return "", fmt.Errorf("invalid patch %q: %w", id, err)
+	}
+	note, err := r.git.Notes.Read(NotesRef, oid)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read note of %s: %w", id, err)
+	}
+	defer note.Free()
+	return note.Message(), nil
+}
+
+// SetPatchNote attaches note to the patch with the given id, replacing any
+// note it already has.
+func (r *Repo) SetPatchNote(id, note string) error {
+	if err := Writable("write note"); err != nil {
+		return err
+	}
+	oid, err := git.NewOid(id)
+	if err != nil {
+		return fmt.Errorf("invalid patch %q: %w", id, err)
+	}
+	author, err := r.authorSignature()
+	if err != nil {
+		return err
+	}
+	committer, err := r.committerSignature()
+	if err != nil {
+		return err
+	}
+	if _, err := r.git.Notes.Create(NotesRef, author, committer, oid, note, true); err != nil {
+		return fmt.Errorf("failed to write note of %s: %w", id, err)
+	}
+	return nil
+}
+
+// RemovePatchNote removes the kilt note attached to the patch with the given
+// id, if it has one.
+func (r *Repo) RemovePatchNote(id string) error {
+	if err := Writable("remove note"); err != nil {
+		return err
+	}
+	oid, err := git.NewOid(id)
+	if err != nil {
+		return fmt.Errorf("invalid patch %q: %w", id, err)
+	}
+	author, err := r.authorSignature()
+	if err != nil {
+		return err
+	}
+	committer, err := r.committerSignature()
+	if err != nil {
+		return err
+	}
+	if err := r.git.Notes.Remove(NotesRef, author, committer, oid); err != nil && !git.IsErrorCode(err, git.ErrNotFound) {
+		return fmt.Errorf("failed to remove note of %s: %w", id, err)
+	}
+	return nil
+}
+
+// CarryPatchNotes attaches the kilt notes of the patches in from to the
+// patches in to that make the same change, matched by patch ID, so that notes
+// survive patches being rewritten. Patches in to that already have a note keep
+// it.
+func (r *Repo) CarryPatchNotes(from, to []string) error {
+	notes := map[string]string{}
+	for _, patch := range from {
+		note, err := r.PatchNote(patch)
+		if err != nil {
+			return err
+		}
+		if note == "" {
+			continue
+		}
+		id, err := r.PatchID(patch)
+		if err != nil {
+			return err
+		}
+		notes[id] = note
+	}
+	if len(notes) == 0 {
+		return nil
+	}
+	for _, patch := range to {
+		if note, err := r.PatchNote(patch); err != nil {
+			return err
+		} else if note != "" {
+			continue
+		}
+		id, err := r.PatchID(patch)
+		if err != nil {
+			return err
+		}
+		if note, ok := notes[id]; ok {
+			if err := r.SetPatchNote(patch, note); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	log "github.com/golang/glog"
+)
+
+// ParseMode controls how malformed kilt metadata is handled when reading the
+// patchsets of a branch.
+type ParseMode int
+
+const (
+	// Lenient skips or works around malformed metadata with a warning, so
+	// that interactive commands keep working on messy branches.
+	Lenient ParseMode = iota
+	// Strict fails on the first malformed metadata found, in branch order,
+	// so that checks such as CI reject it deterministically.
+	Strict
+)
+
+// StrictEnvVar is the environment variable that, set to a true value such as
+// 1, enables strict parsing.
+const StrictEnvVar = "KILT_STRICT"
+
+var parseMode = func() ParseMode {
+	if strict, _ := strconv.ParseBool(os.Getenv(StrictEnvVar)); strict {
+		return Strict
+	}
+	return Lenient
+}()
+
+// SetParseMode sets how malformed metadata is handled by patchset reads that
+// haven't happened yet.
+func SetParseMode(m ParseMode) {
+	parseMode = m
+}
+
+// CurrentParseMode returns how malformed metadata is handled.
+func CurrentParseMode() ParseMode {
+	return parseMode
+}
+
+// MetadataError describes malformed kilt metadata found in a commit of the
+// branch.
+type MetadataError struct {
+	Commit  string
+	Problem string
+}
+
+func (e *MetadataError) Error() string {
+	return fmt.Sprintf("malformed kilt metadata in commit %s: %s", e.Commit, e.Problem)
+}
+
+// reportMalformed handles malformed metadata according to the parse mode,
+// returning it as an error in strict mode and logging a warning otherwise.
+func reportMalformed(e *MetadataError) error {
+	if parseMode == Strict {
+		return e
+	}
+	log.Warning(e)
+	return nil
+}
+
+// MalformedMetadata returns every malformed metadata found in the branch, in
+// branch order, regardless of the parse mode.
+func (r *Repo) MalformedMetadata() ([]*MetadataError, error) {
+	var found []*MetadataError
+	_, err := r.readPatchsets(func(e *MetadataError) error {
+		found = append(found, e)
+		return nil
+	})
+	return found, err
+}
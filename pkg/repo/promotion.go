@@ -0,0 +1,72 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	promotionData = "promotions"
+	promotionFile = "promotions.json"
+)
+
+// Promotion records a patchset version promoted from one kilt branch to
+// another. The same record is kept in the promotion journal of both branches.
+type Promotion struct {
+	Patchset string    `json:"patchset"`
+	UUID     string    `json:"uuid"`
+	Version  string    `json:"version"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Time     time.Time `json:"time"`
+}
+
+// Promotions returns the promotions recorded for the kilt branch, oldest
+// first.
+func (r *Repo) Promotions() ([]Promotion, error) {
+	b, err := r.ReadData(promotionData, promotionFile)
+	if errors.Is(err, ErrNoData) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var promotions []Promotion
+	if err := json.Unmarshal(b, &promotions); err != nil {
+		return nil, fmt.Errorf("failed to parse promotions: %w", err)
+	}
+	return promotions, nil
+}
+
+// RecordPromotion appends the promotion to the promotion journal of the kilt
+// branch.
+func (r *Repo) RecordPromotion(p Promotion) error {
+	promotions, err := r.Promotions()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(append(promotions, p), "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, "\n"...)
+	msg := fmt.Sprintf("kilt: record promotion of %s version %s from %s to %s", p.Patchset, p.Version, p.From, p.To)
+	return r.WriteData(promotionData, promotionFile, b, msg)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// QuarantinePatchset is the name of the patchset that patches without a
+	// Patchset-Name footer are quarantined in until they are assigned.
+	QuarantinePatchset = "quarantine"
+
+	quarantineData = "quarantine"
+	quarantineFile = "quarantine.json"
+
+	quarantineMaxAgeConfig  = "kilt.quarantineMaxAge"
+	defaultQuarantineMaxAge = 14
+)
+
+// QuarantineMaxAge returns how long patches may stay quarantined, which is
+// configured in days with kilt.quarantineMaxAge. Zero means no limit.
+func (r *Repo) QuarantineMaxAge() (time.Duration, error) {
+	days, err := r.ConfigInt(quarantineMaxAgeConfig, defaultQuarantineMaxAge)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// QuarantinedSince returns the times at which the given patches were first
+// quarantined, keyed by commit ID. The times are tracked by patch ID, so they
+// survive the patches being rebuilt. Untracked patches are left out.
+func (r *Repo) QuarantinedSince(patches []string) (map[string]time.Time, error) {
+	dates, err := r.readQuarantineDates()
+	if err != nil {
+		return nil, err
+	}
+	since := map[string]time.Time{}
+	for _, patch := range patches {
+		id, err := r.PatchID(patch)
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := dates[id]; ok {
+			since[patch] = t
+		}
+	}
+	return since, nil
+}
+
+// TrackQuarantine records now as the quarantine time of the given patches
+// that aren't tracked yet, and stops tracking patches that are no longer
+// quarantined.
+func (r *Repo) TrackQuarantine(patches []string, now time.Time) error {
+	dates, err := r.readQuarantineDates()
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, patch := range patches {
+		id, err := r.PatchID(patch)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	b, err := json.MarshalIndent(trackDates(dates, ids, now), "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.WriteData(quarantineData, quarantineFile, b, "Track quarantined patches")
+}
+
+func (r *Repo) readQuarantineDates() (map[string]time.Time, error) {
+	dates := map[string]time.Time{}
+	b, err := r.ReadData(quarantineData, quarantineFile)
+	if errors.Is(err, ErrNoData) {
+		return dates, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &dates); err != nil {
+		return nil, fmt.Errorf("failed to parse quarantine dates: %w", err)
+	}
+	return dates, nil
+}
+
+// trackDates returns the dates of ids, keeping the dates of ids already in
+// dates and using now for the others.
+func trackDates(dates map[string]time.Time, ids []string, now time.Time) map[string]time.Time {
+	tracked := map[string]time.Time{}
+	for _, id := range ids {
+		if t, ok := dates[id]; ok {
+			tracked[id] = t
+		} else {
+			tracked[id] = now
+		}
+	}
+	return tracked
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ReadOnlyEnvVar is the environment variable that, set to a true value such
+// as 1, enables read-only mode.
+const ReadOnlyEnvVar = "KILT_READ_ONLY"
+
+// ErrReadOnly is returned by operations that would modify the repo or kilt
+// state while read-only mode is enabled.
+var ErrReadOnly = errors.New("kilt is in read-only mode")
+
+var readOnly, _ = strconv.ParseBool(os.Getenv(ReadOnlyEnvVar))
+
+// SetReadOnly enables or disables read-only mode. In read-only mode, every
+// attempt to create or update refs, commits, the work tree or kilt state files
+// fails with ErrReadOnly.
+func SetReadOnly(enabled bool) {
+	readOnly = enabled
+}
+
+// ReadOnly returns whether read-only mode is enabled.
+func ReadOnly() bool {
+	return readOnly
+}
+
+// Writable returns an error wrapping ErrReadOnly if read-only mode is enabled.
+// The operation describes what was attempted.
+func Writable(operation string) error {
+	if readOnly {
+		return fmt.Errorf("can't %s: %w", operation, ErrReadOnly)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/kilt/pkg/network"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+const (
+	networkAttemptsConfig = "kilt.networkAttempts"
+	networkBackoffConfig  = "kilt.networkBackoff"
+	networkIntervalConfig = "kilt.networkInterval"
+)
+
+// NetworkPolicy returns the retry and rate limiting policy for network
+// operations, as configured with the kilt.networkAttempts, kilt.networkBackoff
+// and kilt.networkInterval options. Durations are given like "500ms" or "2s".
+func (r *Repo) NetworkPolicy() (*network.Policy, error) {
+	if r.network != nil {
+		return r.network, nil
+	}
+	p := network.DefaultPolicy()
+	var err error
+	if p.Attempts, err = r.ConfigInt(networkAttemptsConfig, p.Attempts); err != nil {
+		return nil, err
+	}
+	for _, d := range []struct {
+		key string
+		v   *time.Duration
+	}{
+		{networkBackoffConfig, &p.Backoff},
+		{networkIntervalConfig, &p.Interval},
+	} {
+		s, err := r.ConfigString(d.key, "")
+		if err != nil {
+			return nil, err
+		}
+		if s == "" {
+			continue
+		}
+		if *d.v, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+	}
+	r.network = p
+	return p, nil
+}
+
+// remoteSession runs operations on a remote with retries and credentials from
+// the ssh agent or the git credential helpers.
+type remoteSession struct {
+	policy *network.Policy
+	filled []network.Credential
+	tried  map[string]bool
+}
+
+func (r *Repo) newRemoteSession() (*remoteSession, error) {
+	policy, err := r.NetworkPolicy()
+	if err != nil {
+		return nil, err
+	}
+	return &remoteSession{policy: policy, tried: map[string]bool{}}, nil
+}
+
+func (s *remoteSession) callbacks() git.RemoteCallbacks {
+	return git.RemoteCallbacks{CredentialsCallback: s.credentials}
+}
+
+// credentials provides each kind of credential at most once per attempt, so
+// a rejected credential fails the attempt instead of being offered forever.
+func (s *remoteSession) credentials(url, username string, allowed git.CredType) (*git.Cred, error) {
+	if allowed&git.CredTypeSshKey != 0 && !s.tried["ssh"] {
+		s.tried["ssh"] = true
+		return git.NewCredSshKeyFromAgent(username)
+	}
+	if allowed&git.CredTypeUserpassPlaintext != 0 && !s.tried["userpass"] {
+		s.tried["userpass"] = true
+		c, err := network.Fill(url, username)
+		if err != nil {
+			return nil, err
+		}
+		s.filled = append(s.filled, c)
+		return git.NewCredUserpassPlaintext(c.Username, c.Password)
+	}
+	if allowed&git.CredTypeDefault != 0 && !s.tried["default"] {
+		s.tried["default"] = true
+		return git.NewCredDefault()
+	}
+	return nil, fmt.Errorf("no credentials available for %s", url)
+}
+
+// do runs op with retries. Authentication failures aren't retried, and the
+// credential helpers are told whether the credentials they provided worked.
+func (s *remoteSession) do(description string, op func() error) error {
+	return s.policy.Do(description, func() error {
+		s.tried = map[string]bool{}
+		s.filled = nil
+		err := op()
+		for _, c := range s.filled {
+			if err == nil {
+				c.Approve()
+			} else if git.IsErrorCode(err, git.ErrAuth) {
+				c.Reject()
+			}
+		}
+		if git.IsErrorCode(err, git.ErrAuth) || git.IsErrorCode(err, git.ErrCertificate) {
+			return network.Permanent(err)
+		}
+		return err
+	})
+}
+
+// Push pushes the refspecs to the named remote, retrying failures as
+// described by the network policy. The refs that the remote rejected are
+// returned with the reason for each.
+func (r *Repo) Push(name string, refspecs []string) (map[string]string, error) {
+	if err := Writable("push"); err != nil {
+		return nil, err
+	}
+	s, err := r.newRemoteSession()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := r.git.Remotes.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup remote %q: %w", name, err)
+	}
+	defer remote.Free()
+	rejected := map[string]string{}
+	callbacks := s.callbacks()
+	callbacks.PushUpdateReferenceCallback = func(ref, status string) git.ErrorCode {
+		if status != "" {
+			rejected[ref] = status
+		}
+		return git.ErrOk
+	}
+	err = s.do(fmt.Sprintf("push to %s", name), func() error {
+		return remote.Push(refspecs, &git.PushOptions{RemoteCallbacks: callbacks})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rejected, nil
+}
+
+// fetch fetches the default refspecs of the remote, retrying failures as
+// described by the network policy.
+func (r *Repo) fetch(remote *git.Remote) error {
+	if err := Writable("fetch"); err != nil {
+		return err
+	}
+	s, err := r.newRemoteSession()
+	if err != nil {
+		return err
+	}
+	return s.do(fmt.Sprintf("fetch %s", remote.Name()), func() error {
+		return remote.Fetch(nil, &git.FetchOptions{RemoteCallbacks: s.callbacks()}, "")
+	})
+}
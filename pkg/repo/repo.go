@@ -20,15 +20,17 @@ package repo
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
 
-	log "github.com/golang/glog"
-
 	"github.com/libgit2/git2go/v30"
+	"github.com/google/kilt/pkg/network"
 	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/reporter"
 )
 
 // Repo wraps git repo state for repository manipulations
@@ -38,6 +40,12 @@ type Repo struct {
 	branch    string
 	head      string
 	patchsets PatchsetCache
+	network   *network.Policy
+	reporter  reporter.Reporter
+	// branchView is set for repos opened with OpenBranchView.
+	branchView bool
+	// dir is the directory the repo was opened from, for Reopen.
+	dir string
 }
 
 const (
@@ -45,7 +53,8 @@ const (
 	patchsetNameField    = "Patchset-Name"
 	patchsetUUIDField    = "Patchset-UUID"
 	patchsetVersionField = "Patchset-Version"
-	metadataMessage      = metadataPrefix + "%s\n\n" + patchsetNameField + ": %s\n" + patchsetUUIDField + ": %s\n" + patchsetVersionField + ": %s\n"
+	conflictsField       = "Conflicts"
+	resolutionField      = "Conflict-Resolution"
 	refPath              = "refs/kilt"
 )
 
@@ -60,6 +69,20 @@ type PatchsetCache struct {
 	Map   map[string]*patchset.Patchset
 }
 
+// Lookup returns the patchset with the name, or if there is none, the patchset
+// whose name collates equal to it, as defined by patchset.NameKey.
+func (c PatchsetCache) Lookup(name string) (*patchset.Patchset, bool) {
+	if p, ok := c.Map[name]; ok {
+		return p, true
+	}
+	for _, p := range c.Slice {
+		if patchset.SameName(p.Name(), name) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
 func newWithGitRepo(git *git.Repository, base, branch, head string) *Repo {
 	return &Repo{
 		git:    git,
@@ -69,50 +92,252 @@ func newWithGitRepo(git *git.Repository, base, branch, head string) *Repo {
 	}
 }
 
-// Open tries to open a repo in the current working directory
-func Open() (*Repo, error) {
-	g, err := git.OpenRepository(".")
+// openGitRepo opens the repo containing dir. Like git, parent directories are
+// searched and GIT_DIR and related environment variables are respected, so
+// kilt can be run from anywhere in the work tree.
+func openGitRepo(dir string) (*git.Repository, error) {
+	g, err := git.OpenRepositoryExtended(dir, git.RepositoryOpenFromEnv, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open repo: %w", err)
 	}
+	return g, nil
+}
+
+// Open tries to open the repo containing the current working directory
+func Open() (*Repo, error) {
+	return OpenDir(".")
+}
+
+// OpenDir tries to open the repo containing dir.
+func OpenDir(dir string) (*Repo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	g, err := openGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateLegacyRework(g); err != nil {
+		return nil, fmt.Errorf("failed to migrate rework: %w", err)
+	}
 	branch, err := findKiltBranch(g)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find kilt branch: %w", err)
 	}
 	head := branch
-	if inProgress, err := checkRework(g); err != nil {
+	if inProgress, err := checkRework(g, branch); err != nil {
 		return nil, err
 	} else if inProgress {
-		head = "refs/kilt/rework/head"
+		head = path.Join(refPath, reworkRef(branch, "head"))
 	}
 	baseRefPath := baseRef(branch)
 	base, err := g.References.Lookup(baseRefPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to lookup base: %w", err)
 	}
-	return newWithGitRepo(g, base.Target().String(), branch, head), nil
+	r := newWithGitRepo(g, base.Target().String(), branch, head)
+	r.dir = dir
+	return r, nil
+}
+
+// Reopen opens the repo again from the directory it was opened from, to see
+// the kilt branch as it is now, such as once a rework has finished.
+func (r *Repo) Reopen() (*Repo, error) {
+	dir := r.dir
+	if dir == "" {
+		dir = r.git.Workdir()
+	}
+	return OpenDir(dir)
 }
 
-// Init initializes kilt in the current branch.
-func Init(base string) (*Repo, error) {
-	g, err := git.OpenRepository(".")
+// ErrInitialized is returned when initializing a branch that already has a
+// kilt base.
+type ErrInitialized struct {
+	Branch string
+	Base   string
+}
+
+func (e *ErrInitialized) Error() string {
+	return fmt.Sprintf("branch %q is already initialized with base %s", e.Branch, e.Base)
+}
+
+// Init initializes kilt in the current branch. If the branch already has a
+// base, ErrInitialized is returned unless force is set, in which case the base
+// is moved. The base must be an ancestor of the branch.
+func Init(base string, force bool) (*Repo, error) {
+	if err := Writable("initialize kilt"); err != nil {
+		return nil, err
+	}
+	g, err := openGitRepo(".")
 	if err != nil {
-		return nil, fmt.Errorf("failed to open repo: %w", err)
+		return nil, err
 	}
 	obj, err := g.RevparseSingle(base)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse base %q: %w", base, err)
 	}
+	commit, err := obj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, fmt.Errorf("base %q is not a commit: %w", base, err)
+	}
+	if err := migrateLegacyRework(g); err != nil {
+		return nil, fmt.Errorf("failed to migrate rework: %w", err)
+	}
 	branch, err := findKiltBranch(g)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find kilt branch: %w", err)
 	}
 	head := branch
 	baseRefPath := baseRef(branch)
-	if _, err := g.References.Create(baseRefPath, obj.Id(), false, fmt.Sprintf("Creating kilt base reference %s", baseRefPath)); err != nil {
+	existing, err := g.References.Lookup(baseRefPath)
+	if err == nil {
+		if !force {
+			return nil, &ErrInitialized{Branch: branch, Base: existing.Target().String()}
+		}
+	} else if !git.IsErrorCode(err, git.ErrNotFound) {
+		return nil, fmt.Errorf("failed to lookup base: %w", err)
+	}
+	b, err := g.LookupBranch(branch, git.BranchLocal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup branch %q: %w", branch, err)
+	}
+	if tip := b.Target(); !tip.Equal(commit.Id()) {
+		if ok, err := g.DescendantOf(tip, commit.Id()); err != nil {
+			return nil, fmt.Errorf("failed to check ancestry of base: %w", err)
+		} else if !ok {
+			return nil, fmt.Errorf("base %q is not an ancestor of branch %q", base, branch)
+		}
+	}
+	msg := fmt.Sprintf("Creating kilt base reference %s", baseRefPath)
+	if existing != nil {
+		msg = fmt.Sprintf("Moving kilt base reference %s", baseRefPath)
+	}
+	if _, err := g.References.Create(baseRefPath, commit.Id(), force, msg); err != nil {
 		return nil, fmt.Errorf("failed to create ref: %w", err)
 	}
-	return newWithGitRepo(g, base, branch, head), nil
+	return newWithGitRepo(g, commit.Id().String(), branch, head), nil
+}
+
+// OpenBranch returns a repo for another kilt branch, sharing the underlying git repository.
+func (r *Repo) OpenBranch(branch string) (*Repo, error) {
+	base, err := r.git.References.Lookup(baseRef(branch))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup base of %q: %w", branch, err)
+	}
+	return newWithGitRepo(r.git, base.Target().String(), branch, branch), nil
+}
+
+// OpenBranchView returns a repo for the kilt branch as it is outside any rework
+// in progress: the head is the branch ref rather than the rework head, and the
+// base is the one the branch had before the rework moved it, if it did. This
+// lets read-only commands report the branch state while a rework is underway.
+func (r *Repo) OpenBranchView() (*Repo, error) {
+	base := r.base
+	saved := path.Join(refPath, r.ReworkRef("base"))
+	if ref, err := r.git.References.Lookup(saved); err == nil {
+		base = ref.Target().String()
+	} else if !git.IsErrorCode(err, git.ErrNotFound) {
+		return nil, fmt.Errorf("failed to lookup ref %q: %w", saved, err)
+	}
+	v := newWithGitRepo(r.git, base, r.branch, r.branch)
+	v.branchView = true
+	return v, nil
+}
+
+// IsBranchView reports whether the repo was opened with OpenBranchView.
+func (r *Repo) IsBranchView() bool {
+	return r.branchView
+}
+
+// OpenRevision returns a repo for the patch stack between base and the ref rev,
+// which need not be a kilt branch, sharing the underlying git repository.
+func (r *Repo) OpenRevision(rev, base string) (*Repo, error) {
+	ref, err := r.git.References.Dwim(rev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %w", rev, err)
+	}
+	obj, err := r.git.RevparseSingle(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base %q: %w", base, err)
+	}
+	return newWithGitRepo(r.git, obj.Id().String(), rev, ref.Name()), nil
+}
+
+// OpenAt returns a repo for the patch stack of the kilt branch as it was at
+// the commit rev, sharing the underlying git repository. Unless base is given,
+// the base of the stack at rev is taken from the backup of the branch whose
+// result is rev, else from the metadata commits reachable from rev, else the
+// current base is used if rev descends from it.
+func (r *Repo) OpenAt(rev, base string) (*Repo, error) {
+	obj, err := r.git.RevparseSingle(rev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", rev, err)
+	}
+	commit, err := obj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a commit: %w", rev, err)
+	}
+	id := commit.Id().String()
+	if base == "" {
+		if base, err = r.baseAt(commit.Id()); err != nil {
+			return nil, err
+		}
+	}
+	baseObj, err := r.git.RevparseSingle(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base %q: %w", base, err)
+	}
+	return newWithGitRepo(r.git, baseObj.Id().String(), r.branch, id), nil
+}
+
+// baseAt returns the base of the kilt patch stack at the commit id.
+func (r *Repo) baseAt(id *git.Oid) (string, error) {
+	backups, err := r.Backups()
+	if err != nil {
+		return "", err
+	}
+	for _, b := range backups {
+		if b.Result == id.String() {
+			return b.Base, nil
+		}
+	}
+	if base, err := r.stackBase(id); err != nil || base != "" {
+		return base, err
+	}
+	current, err := git.NewOid(r.base)
+	if err != nil {
+		return "", err
+	}
+	if id.Equal(current) {
+		return r.base, nil
+	}
+	if ok, err := r.git.DescendantOf(id, current); err != nil {
+		return "", err
+	} else if ok {
+		return r.base, nil
+	}
+	return "", fmt.Errorf("unable to find the kilt base at %s, use --base to give it", id)
+}
+
+// stackBase walks the first parents of the commit id through the patches and
+// metadata commits of a patch stack, returning the parent of the oldest
+// metadata commit, or an empty string if none is found.
+func (r *Repo) stackBase(id *git.Oid) (string, error) {
+	commit, err := r.git.LookupCommit(id)
+	if err != nil {
+		return "", err
+	}
+	var base string
+	for commit.ParentCount() == 1 {
+		if isMetadataCommit(commit) {
+			base = commit.ParentId(0).String()
+		} else if _, ok := parseFields(commit.Message())[patchsetNameField]; !ok {
+			break
+		}
+		commit = commit.Parent(0)
+	}
+	return base, nil
 }
 
 // LookupKiltRef will lookup the specified ref name under the kilt ref path.
@@ -134,11 +359,62 @@ func (r *Repo) LookupKiltRef(name string) (string, error) {
 
 // ReworkInProgress checks whether there is currently a rework operation in progress.
 func (r *Repo) ReworkInProgress() (bool, error) {
-	return checkRework(r.git)
+	return checkRework(r.git, r.branch)
+}
+
+// ReworkRef returns the name, relative to the kilt ref path, of the rework ref
+// with the given name for the current kilt branch. Rework refs are kept per
+// branch, so reworks of different branches don't interfere.
+func (r *Repo) ReworkRef(name string) string {
+	return reworkRef(r.branch, name)
+}
+
+func reworkRef(branch, name string) string {
+	return path.Join(branch, "rework", name)
+}
+
+// ReworkDirectory returns a full path to the directory the rework state of the
+// current kilt branch is kept in.
+func (r *Repo) ReworkDirectory() string {
+	return filepath.Join(r.BranchDirectory(), "rework")
+}
+
+// BranchDirectory returns the directory holding local state of the kilt branch.
+func (r *Repo) BranchDirectory() string {
+	return filepath.Join(r.KiltDirectory(), "branches", r.branch)
+}
+
+// SetActiveRework records the current kilt branch as the one whose rework the
+// detached head belongs to, which is used to find the kilt branch when reworks
+// of several branches are in progress.
+func (r *Repo) SetActiveRework() error {
+	if err := Writable("record the active rework"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.KiltDirectory(), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(activeReworkFile(r.git), []byte(r.branch+"\n"), 0666)
+}
+
+// ClearActiveRework removes the record of the active rework if it belongs to
+// the current kilt branch.
+func (r *Repo) ClearActiveRework() error {
+	if err := Writable("clear the active rework"); err != nil {
+		return err
+	}
+	if b, err := ioutil.ReadFile(activeReworkFile(r.git)); err != nil || strings.TrimSpace(string(b)) != r.branch {
+		return nil
+	}
+	return os.Remove(activeReworkFile(r.git))
 }
 
-func checkRework(g *git.Repository) (bool, error) {
-	p := path.Join(refPath, "rework/branch")
+func activeReworkFile(g *git.Repository) string {
+	return filepath.Join(g.Path(), "kilt", "rework-branch")
+}
+
+func checkRework(g *git.Repository, branch string) (bool, error) {
+	p := path.Join(refPath, reworkRef(branch, "branch"))
 	ref, err := g.References.Lookup(p)
 	if git.IsErrorCode(err, git.ErrNotFound) {
 		return false, nil
@@ -154,25 +430,123 @@ func checkRework(g *git.Repository) (bool, error) {
 	return false, nil
 }
 
+// reworkBranches returns the kilt branches with a rework in progress.
+func reworkBranches(g *git.Repository) ([]string, error) {
+	it, err := g.NewReferenceIterator()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	defer it.Free()
+	suffix := "/" + reworkRef("", "branch")
+	var branches []string
+	for {
+		ref, err := it.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to list refs: %w", err)
+		}
+		name := ref.Name()
+		if strings.HasPrefix(name, refPath+"/") && strings.HasSuffix(name, suffix) {
+			branches = append(branches, strings.TrimSuffix(strings.TrimPrefix(name, refPath+"/"), suffix))
+		}
+	}
+	return branches, nil
+}
+
+// legacyReworkPath is where versions of kilt running a single rework per repo
+// kept the rework refs, before they were kept per kilt branch.
+const legacyReworkPath = refPath + "/rework"
+
+// migrateLegacyRework moves the refs and state of a rework begun by a version
+// of kilt that kept them for the whole repo to those of the kilt branch being
+// reworked, so the rework can be continued or aborted. A migration that was
+// interrupted is completed.
+func migrateLegacyRework(g *git.Repository) error {
+	legacy, err := g.References.Lookup(path.Join(legacyReworkPath, "branch"))
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to lookup legacy rework branch: %w", err)
+	}
+	target := legacy.SymbolicTarget()
+	branch := strings.TrimPrefix(target, "refs/heads/")
+	if branch == "" || branch == target {
+		return fmt.Errorf("legacy rework ref %s doesn't point to a branch", legacy.Name())
+	}
+	if ReadOnly() {
+		return fmt.Errorf("the rework of %s was begun by an earlier version of kilt, and can't be migrated in read-only mode; run kilt without read-only mode, or finish the rework with that version: %w", branch, ErrReadOnly)
+	}
+	legacyDir := filepath.Join(g.Path(), "kilt", "rework")
+	dir := filepath.Join(g.Path(), "kilt", "branches", branch, "rework")
+	if _, err := os.Stat(legacyDir); err == nil {
+		if err := os.MkdirAll(filepath.Dir(dir), 0777); err != nil {
+			return err
+		}
+		if err := os.Rename(legacyDir, dir); err != nil {
+			return fmt.Errorf("failed to move rework state: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	msg := "Migrating kilt rework reference"
+	head, err := g.References.Lookup(path.Join(legacyReworkPath, "head"))
+	if err == nil {
+		if _, err := g.References.Create(path.Join(refPath, reworkRef(branch, "head")), head.Target(), true, msg); err != nil {
+			return fmt.Errorf("failed to create rework head: %w", err)
+		}
+	} else if !git.IsErrorCode(err, git.ErrNotFound) {
+		return fmt.Errorf("failed to lookup legacy rework head: %w", err)
+	}
+	if _, err := g.References.CreateSymbolic(path.Join(refPath, reworkRef(branch, "branch")), target, true, msg); err != nil {
+		return fmt.Errorf("failed to create rework branch: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(g.Path(), "kilt"), 0777); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(activeReworkFile(g), []byte(branch+"\n"), 0666); err != nil {
+		return err
+	}
+	if head != nil {
+		if err := head.Delete(); err != nil {
+			return fmt.Errorf("failed to delete legacy rework head: %w", err)
+		}
+	}
+	if err := legacy.Delete(); err != nil {
+		return fmt.Errorf("failed to delete legacy rework branch: %w", err)
+	}
+	return nil
+}
+
+// findKiltBranch returns the current branch. On a detached head, the branch
+// with a rework in progress is returned, preferring the active rework if
+// several branches are being reworked.
 func findKiltBranch(g *git.Repository) (string, error) {
 	var branchName string
 	if detached, err := g.IsHeadDetached(); err != nil {
 		return "", fmt.Errorf("failed while checking detached head: %w", err)
 	} else if detached {
-		ref, err := g.References.Lookup(path.Join(refPath, "rework/branch"))
-		if git.IsErrorCode(err, git.ErrNotFound) {
-			return "", errors.New("must not be on a detached head")
-		}
+		branches, err := reworkBranches(g)
 		if err != nil {
 			return "", fmt.Errorf("failed while checking rework branch: %w", err)
 		}
-		branchRef, err := ref.Resolve()
-		if err != nil {
-			return "", fmt.Errorf("failed to resolve reference: %w", err)
+		switch len(branches) {
+		case 0:
+			return "", errors.New("must not be on a detached head")
+		case 1:
+			return branches[0], nil
 		}
-		if branchName, err = branchRef.Branch().Name(); err != nil {
-			return "", fmt.Errorf("failed to get branch name: %w", err)
+		b, err := ioutil.ReadFile(activeReworkFile(g))
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to read active rework: %w", err)
 		}
+		active := strings.TrimSpace(string(b))
+		for _, branch := range branches {
+			if branch == active {
+				return branch, nil
+			}
+		}
+		return "", fmt.Errorf("reworks of branches %s are in progress; check out one of the branches", strings.Join(branches, ", "))
 	} else {
 		head, err := g.Head()
 		if err != nil {
@@ -199,8 +573,65 @@ func (r *Repo) KiltBase() string {
 	return r.base
 }
 
+// MoveBase moves the kilt base of the branch to the given commit. The previous
+// base is kept in the rework base ref, so RestoreBase can move it back if the
+// rework is aborted.
+func (r *Repo) MoveBase(id string) error {
+	if err := Writable("move the kilt base"); err != nil {
+		return err
+	}
+	oid, err := git.NewOid(id)
+	if err != nil {
+		return fmt.Errorf("invalid base %q: %w", id, err)
+	}
+	old, err := git.NewOid(r.base)
+	if err != nil {
+		return fmt.Errorf("invalid base %q: %w", r.base, err)
+	}
+	saved := path.Join(refPath, r.ReworkRef("base"))
+	if _, err := r.git.References.Lookup(saved); git.IsErrorCode(err, git.ErrNotFound) {
+		if _, err := r.git.References.Create(saved, old, false, "Saving kilt base reference"); err != nil {
+			return fmt.Errorf("failed to create ref %q: %w", saved, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to lookup ref %q: %w", saved, err)
+	}
+	msg := fmt.Sprintf("Moving kilt base reference %s", baseRef(r.branch))
+	if _, err := r.git.References.Create(baseRef(r.branch), oid, true, msg); err != nil {
+		return fmt.Errorf("failed to update base: %w", err)
+	}
+	r.base = id
+	r.patchsets = PatchsetCache{}
+	return nil
+}
+
+// RestoreBase moves the kilt base back to where it was before MoveBase, if it
+// was moved during the rework in progress.
+func (r *Repo) RestoreBase() error {
+	if err := Writable("restore the kilt base"); err != nil {
+		return err
+	}
+	saved := path.Join(refPath, r.ReworkRef("base"))
+	ref, err := r.git.References.Lookup(saved)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to lookup ref %q: %w", saved, err)
+	}
+	msg := fmt.Sprintf("Restoring kilt base reference %s", baseRef(r.branch))
+	if _, err := r.git.References.Create(baseRef(r.branch), ref.Target(), true, msg); err != nil {
+		return fmt.Errorf("failed to restore base: %w", err)
+	}
+	r.base = ref.Target().String()
+	r.patchsets = PatchsetCache{}
+	return ref.Delete()
+}
+
 // WriteRefHead will write the current head to the specified kilt ref.
 func (r *Repo) WriteRefHead(name string) error {
+	if err := Writable("write ref"); err != nil {
+		return err
+	}
 	ref, err := r.git.Head()
 	if err != nil {
 		return fmt.Errorf("failed to lookup head: %w", err)
@@ -218,10 +649,8 @@ func (r *Repo) WriteRefHead(name string) error {
 
 // WriteSymbolicRefBranch will write the given symbolic branch to the specified kilt ref.
 func (r *Repo) WriteSymbolicRefBranch(name, branchName string) error {
-	if detached, err := r.git.IsHeadDetached(); err != nil {
-		return fmt.Errorf("failed while checking detached head: %w", err)
-	} else if detached {
-		return errors.New("must not be on a detached head")
+	if err := Writable("write ref"); err != nil {
+		return err
 	}
 	branch, err := r.git.LookupBranch(branchName, git.BranchLocal)
 	if err != nil {
@@ -237,6 +666,9 @@ func (r *Repo) WriteSymbolicRefBranch(name, branchName string) error {
 
 // WriteSymbolicRefHead will write the current symbolic head to the specified kilt ref.
 func (r *Repo) WriteSymbolicRefHead(name string) error {
+	if err := Writable("write ref"); err != nil {
+		return err
+	}
 	if detached, err := r.git.IsHeadDetached(); err != nil {
 		return fmt.Errorf("failed while checking detached head: %w", err)
 	} else if detached {
@@ -255,6 +687,9 @@ func (r *Repo) WriteSymbolicRefHead(name string) error {
 
 // DeleteKiltRef will delete the specified kilt ref.
 func (r *Repo) DeleteKiltRef(name string) error {
+	if err := Writable("delete ref"); err != nil {
+		return err
+	}
 	p := path.Join(refPath, name)
 	ref, err := r.git.References.Lookup(p)
 	if err != nil {
@@ -263,13 +698,48 @@ func (r *Repo) DeleteKiltRef(name string) error {
 	return ref.Delete()
 }
 
+// PatchsetVersionRef returns the name of the ref recording the given version of a patchset.
+func (r *Repo) PatchsetVersionRef(name string, version patchset.Version) string {
+	return path.Join(refPath, r.branch, "patchsets", name, "v"+version.String())
+}
+
+// WritePatchsetVersionRef will record the current version of the patchset as a
+// ref pointing at its last patch. Existing version refs are left untouched.
+func (r *Repo) WritePatchsetVersionRef(ps *patchset.Patchset) error {
+	if err := Writable("write version ref"); err != nil {
+		return err
+	}
+	id := ps.MetadataCommit()
+	if patches := ps.Patches(); len(patches) > 0 {
+		id = patches[len(patches)-1]
+	}
+	oid, err := git.NewOid(id)
+	if err != nil {
+		return fmt.Errorf("failed to parse commit id %q: %w", id, err)
+	}
+	refName := r.PatchsetVersionRef(ps.Name(), ps.Version())
+	_, err = r.git.References.Create(refName, oid, false, fmt.Sprintf("Recording kilt patchset %s version %s", ps.Name(), ps.Version()))
+	if git.IsErrorCode(err, git.ErrExists) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to create ref %q: %w", refName, err)
+	}
+	return nil
+}
+
 // SetHead will set the current head to the given kilt ref.
 func (r *Repo) SetHead(name string) error {
+	if err := Writable("set head"); err != nil {
+		return err
+	}
 	return r.git.SetHead(path.Join(refPath, name))
 }
 
 // SetIndirectBranchToHead will resolve the ref and set head to point to the resolved target.
 func (r *Repo) SetIndirectBranchToHead(name string) error {
+	if err := Writable("update branch"); err != nil {
+		return err
+	}
 	p := path.Join(refPath, name)
 	ref, err := r.git.References.Lookup(p)
 	if err != nil {
@@ -289,6 +759,9 @@ func (r *Repo) SetIndirectBranchToHead(name string) error {
 
 // SetBranchToHead will set the given branch to point to HEAD.
 func (r *Repo) SetBranchToHead(name string) error {
+	if err := Writable("update branch"); err != nil {
+		return err
+	}
 	head, err := r.git.Head()
 	if err != nil {
 		return err
@@ -306,8 +779,21 @@ func (r *Repo) KiltDirectory() string {
 	return filepath.Join(r.git.Path(), "kilt")
 }
 
+// SetReporter sets the reporter that checkouts and conflicts are reported to.
+func (r *Repo) SetReporter(rep reporter.Reporter) {
+	r.reporter = rep
+}
+
+// Workdir returns a full path to the work tree of the repo.
+func (r *Repo) Workdir() string {
+	return r.git.Workdir()
+}
+
 // CheckoutRev will checkout the given rev.
 func (r *Repo) CheckoutRev(rev string) error {
+	if err := Writable("check out"); err != nil {
+		return err
+	}
 	obj, err := r.git.RevparseSingle(rev)
 	if err != nil {
 		return err
@@ -320,15 +806,75 @@ func (r *Repo) CheckoutRev(rev string) error {
 	if err != nil {
 		return err
 	}
-	if err := r.git.CheckoutTree(tree, &git.CheckoutOpts{Strategy: git.CheckoutSafe}); err != nil {
+	if err := r.checkoutTree(tree); err != nil {
 		return err
 	}
 	if err := r.git.SetHeadDetached(obj.Id()); err != nil {
 		return err
 	}
+	reporter.Checkout(r.reporter, rev)
 	return r.git.StateCleanup()
 }
 
+// ResetToHead discards all changes to the index and work tree, along with any
+// cherry-pick in progress.
+func (r *Repo) ResetToHead() error {
+	if err := Writable("reset to head"); err != nil {
+		return err
+	}
+	if sparse, err := r.sparseCheckout(); err != nil {
+		return err
+	} else if sparse {
+		if err := r.runGit("", "reset", "--hard", "--quiet", "HEAD"); err != nil {
+			return err
+		}
+		return r.git.StateCleanup()
+	}
+	head, err := r.git.Head()
+	if err != nil {
+		return err
+	}
+	obj, err := head.Peel(git.ObjectCommit)
+	if err != nil {
+		return err
+	}
+	commit, err := obj.AsCommit()
+	if err != nil {
+		return err
+	}
+	if err := r.git.ResetToCommit(commit, git.ResetHard, &git.CheckoutOpts{Strategy: git.CheckoutForce}); err != nil {
+		return err
+	}
+	return r.git.StateCleanup()
+}
+
+// WorkTreeClean reports whether the index and work tree match head, ignoring
+// untracked files.
+func (r *Repo) WorkTreeClean() (bool, error) {
+	if sparse, err := r.sparseCheckout(); err != nil {
+		return false, err
+	} else if sparse {
+		out, err := r.gitOutput("", "status", "--porcelain", "--untracked-files=no")
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(out) == "", nil
+	}
+	list, err := r.git.StatusList(&git.StatusOptions{
+		Show:  git.StatusShowIndexAndWorkdir,
+		Flags: git.StatusOptExcludeSubmodules,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer list.Free()
+	n, err := list.EntryCount()
+	if err != nil {
+		return false, err
+	}
+	return n == 0, nil
+}
+
 // CheckoutBase will checkout the kilt base rev.
 func (r *Repo) CheckoutBase() error {
 	return r.CheckoutRev(r.base)
@@ -359,6 +905,37 @@ var ErrUserActionRequired = errors.New("conflicts during cherry pick")
 
 // CherryPickToHead will cherrypick a commit with the given id to the current head.
 func (r *Repo) CherryPickToHead(id string) error {
+	return r.CherryPickToHeadWithStrategy(id, patchset.Strategy{})
+}
+
+// CherryPickToHeadWithStrategy will apply a commit with the given id to the
+// current head using the method of the strategy, resolving conflicts in paths
+// matching its rules.
+func (r *Repo) CherryPickToHeadWithStrategy(id string, s patchset.Strategy) error {
+	return r.cherryPickToHead(id, s, func(message string) string { return message })
+}
+
+// ReassignToHead will cherrypick a commit with the given id to the current
+// head, setting the Patchset-Name footer of the new commit to patchset.
+func (r *Repo) ReassignToHead(id, name string) error {
+	return r.cherryPickToHead(id, patchset.Strategy{}, func(message string) string {
+		return WithPatchsetName(message, name)
+	})
+}
+
+// SetTrailerToHead will cherrypick a commit with the given id to the current
+// head, setting the key trailer of the new commit to value. If add is set, the
+// trailer is added even if the commit already has a key trailer.
+func (r *Repo) SetTrailerToHead(id, key, value string, add bool) error {
+	return r.cherryPickToHead(id, patchset.Strategy{}, func(message string) string {
+		return WithTrailer(message, key, value, add)
+	})
+}
+
+func (r *Repo) cherryPickToHead(id string, s patchset.Strategy, rewrite func(message string) string) error {
+	if err := Writable("cherry-pick"); err != nil {
+		return err
+	}
 	obj, err := r.git.RevparseSingle(id)
 	if err != nil {
 		return err
@@ -367,21 +944,30 @@ func (r *Repo) CherryPickToHead(id string) error {
 	if err != nil {
 		return err
 	}
-	opts, err := git.DefaultCherrypickOptions()
+	message := rewrite(commit.Message())
+	ix, err := r.strategyIndex(commit, message, s)
 	if err != nil {
 		return err
 	}
-	if err = r.git.Cherrypick(commit, opts); err != nil {
-		return err
+	if ix.HasConflicts() {
+		if ix, err = r.replayResolutions(id, ix); err != nil {
+			return err
+		}
 	}
-	ix, err := r.git.Index()
-	if err != nil {
-		return err
+	if ix.HasConflicts() && len(s.Rules) > 0 {
+		if ix, err = r.resolveConflicts(id, ix, s); err != nil {
+			return err
+		}
 	}
 	if ix.HasConflicts() {
+		paths, err := conflictPaths(ix)
+		if err != nil {
+			return err
+		}
+		reporter.Conflict(r.reporter, id, paths)
 		return ErrUserActionRequired
 	}
-	oid, err := ix.WriteTree()
+	oid, err := ix.WriteTreeTo(r.git)
 	if err != nil {
 		return err
 	}
@@ -401,10 +987,69 @@ func (r *Repo) CherryPickToHead(id string) error {
 	if err != nil {
 		return err
 	}
-	if _, err := r.git.CreateCommit("HEAD", commit.Author(), commit.Committer(), commit.Message(), tree, parent); err != nil {
+	committer, err := r.rewriteCommitter(commit.Author(), commit.Committer())
+	if err != nil {
 		return err
 	}
-	return r.git.StateCleanup()
+	if _, err := r.createCommit("HEAD", commit.Author(), committer, message, tree, parent); err != nil {
+		return err
+	}
+	if err := r.git.StateCleanup(); err != nil {
+		return err
+	}
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return err
+	}
+	return r.syncSubmodules(parentTree, tree)
+}
+
+// ApplyPatchToHead applies the patch text to the index and work tree, and
+// commits the result to the current head using the message and author in info.
+func (r *Repo) ApplyPatchToHead(patch string, info CommitInfo) error {
+	if err := Writable("apply patch"); err != nil {
+		return err
+	}
+	diff, err := git.DiffFromBuffer([]byte(patch), r.git)
+	if err != nil {
+		return fmt.Errorf("failed to parse patch: %w", err)
+	}
+	defer diff.Free()
+	if err := r.git.ApplyDiff(diff, git.ApplyLocationBoth, nil); err != nil {
+		return fmt.Errorf("failed to apply patch: %w", err)
+	}
+	ix, err := r.git.Index()
+	if err != nil {
+		return err
+	}
+	oid, err := ix.WriteTree()
+	if err != nil {
+		return err
+	}
+	tree, err := r.git.LookupTree(oid)
+	if err != nil {
+		return err
+	}
+	parent, err := r.lookupCommit("HEAD")
+	if err != nil {
+		return err
+	}
+	committer, err := r.committerSignature()
+	if err != nil {
+		return err
+	}
+	author := &git.Signature{Name: info.AuthorName, Email: info.AuthorEmail, When: info.AuthorDate}
+	if author.When.IsZero() {
+		author.When = committer.When
+	}
+	if _, err := r.createCommit("HEAD", author, committer, info.Message, tree, parent); err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return err
+	}
+	return r.syncSubmodules(parentTree, tree)
 }
 
 // AddPatchset will add the given patchset to the head of the repo
@@ -415,6 +1060,9 @@ func (r *Repo) AddPatchset(ps *patchset.Patchset) error {
 
 // DetachHead will detach the head from the current branch but stay on the same commit.
 func (r *Repo) DetachHead() error {
+	if err := Writable("detach head"); err != nil {
+		return err
+	}
 	ref, err := r.git.Head()
 	if err != nil {
 		return err
@@ -432,6 +1080,9 @@ func (r *Repo) DetachHead() error {
 
 // CheckoutBranch will checkout the given branch.
 func (r *Repo) CheckoutBranch(name string) error {
+	if err := Writable("check out"); err != nil {
+		return err
+	}
 	branch, err := r.git.LookupBranch(name, git.BranchLocal)
 	if err != nil {
 		return fmt.Errorf("failed to lookup branch: %w", err)
@@ -445,17 +1096,21 @@ func (r *Repo) CheckoutBranch(name string) error {
 	if err != nil {
 		return err
 	}
-	if err := r.git.CheckoutTree(tree, &git.CheckoutOpts{Strategy: git.CheckoutSafe}); err != nil {
+	if err := r.checkoutTree(tree); err != nil {
 		return err
 	}
 	if err := r.git.SetHead(ref.Name()); err != nil {
 		return err
 	}
+	reporter.Checkout(r.reporter, ref.Shorthand())
 	return r.git.StateCleanup()
 }
 
 // CheckoutIndirectBranch will resolve the ref and checkout the branch that the resolved target points to.
 func (r *Repo) CheckoutIndirectBranch(name string) error {
+	if err := Writable("check out"); err != nil {
+		return err
+	}
 	p := path.Join(refPath, name)
 	ref, err := r.git.References.Lookup(p)
 	if err != nil {
@@ -473,12 +1128,13 @@ func (r *Repo) CheckoutIndirectBranch(name string) error {
 	if err != nil {
 		return err
 	}
-	if err := r.git.CheckoutTree(tree, &git.CheckoutOpts{Strategy: git.CheckoutSafe}); err != nil {
+	if err := r.checkoutTree(tree); err != nil {
 		return err
 	}
 	if err := r.git.SetHead(ref.Name()); err != nil {
 		return err
 	}
+	reporter.Checkout(r.reporter, ref.Shorthand())
 	return r.git.StateCleanup()
 }
 
@@ -512,6 +1168,9 @@ func (r *Repo) CompareTreeToHead(kiltRef string) (bool, error) {
 }
 
 func (r *Repo) createMetadataCommit(ps *patchset.Patchset) error {
+	if err := Writable("create metadata commit"); err != nil {
+		return err
+	}
 	head, err := r.git.Head()
 	if err != nil {
 		return fmt.Errorf("failed to get repo head: %w", err)
@@ -524,16 +1183,20 @@ func (r *Repo) createMetadataCommit(ps *patchset.Patchset) error {
 	if err != nil {
 		return fmt.Errorf("failed to get head commit: %w", err)
 	}
-	sig, err := r.git.DefaultSignature()
+	author, err := r.authorSignature()
 	if err != nil {
-		return fmt.Errorf("failed to get default signature: %w", err)
+		return err
+	}
+	committer, err := r.committerSignature()
+	if err != nil {
+		return err
 	}
 	tree, err := commit.Tree()
 	if err != nil {
 		return fmt.Errorf("failed to get commit tree: %w", err)
 	}
-	message := fmt.Sprintf(metadataMessage, ps.Name(), ps.Name(), ps.UUID(), ps.Version())
-	_, err = r.git.CreateCommit(head.Branch().Reference.Name(), sig, sig, message, tree, commit)
+	message := metadataMessage(ps)
+	_, err = r.createCommit(head.Branch().Reference.Name(), author, committer, message, tree, commit)
 	if err != nil {
 		return fmt.Errorf("failed to create new commit: %w", err)
 	}
@@ -542,6 +1205,23 @@ func (r *Repo) createMetadataCommit(ps *patchset.Patchset) error {
 
 // UpdateMetadataForCommit will increment the version number of the given metadata commit.
 func (r *Repo) UpdateMetadataForCommit(id string) error {
+	return r.updateMetadataForCommit(id, "", "", true)
+}
+
+// CopyMetadataForCommit will recreate the given metadata commit on the head,
+// keeping its version number.
+func (r *Repo) CopyMetadataForCommit(id string) error {
+	return r.updateMetadataForCommit(id, "", "", false)
+}
+
+// AdoptMetadataForCommit will replace the name and, unless uuid is empty, the
+// UUID of the patchset of the given metadata commit, incrementing its version
+// number.
+func (r *Repo) AdoptMetadataForCommit(id, name, uuid string) error {
+	return r.updateMetadataForCommit(id, name, uuid, true)
+}
+
+func (r *Repo) updateMetadataForCommit(id, name, uuid string, bump bool) error {
 	obj, err := r.git.RevparseSingle(id)
 	if err != nil {
 		return err
@@ -554,8 +1234,21 @@ func (r *Repo) UpdateMetadataForCommit(id string) error {
 	if err != nil {
 		return err
 	}
-	version := ps.Version().Successor()
-	newPatchset := patchset.Load(ps.Name(), ps.UUID().String(), version)
+	if name == "" {
+		name = ps.Name()
+	}
+	if uuid == "" {
+		uuid = ps.UUID().String()
+	}
+	version := ps.Version()
+	if bump {
+		version = version.Successor()
+	}
+	newPatchset := patchset.Load(name, uuid, version)
+	newPatchset.SetDescription(ps.Description())
+	for _, f := range ps.Fields() {
+		newPatchset.SetField(f.Key, f.Value)
+	}
 	return r.createMetadataCommit(newPatchset)
 }
 
@@ -600,109 +1293,84 @@ func (r *Repo) PatchsetCache() (PatchsetCache, error) {
 }
 
 func (r *Repo) walkPatchsets() error {
-	branch, err := r.git.LookupBranch(r.head, git.BranchLocal)
-	var head *git.Reference
-	if git.IsErrorCode(err, git.ErrNotFound) {
-		head, err = r.git.References.Lookup(r.head)
-		if err != nil {
-			return err
-		}
-	} else if err != nil {
-		return err
-	} else {
-		head = branch.Reference
-	}
-	headCommit, err := head.Peel(git.ObjectCommit)
-	if err != nil {
-		return err
-	}
-	revWalk, err := r.git.Walk()
+	patchsets, err := r.readPatchsets(reportMalformed)
 	if err != nil {
 		return err
 	}
-	defer revWalk.Free()
-
-	revWalk.Sorting(git.SortTopological | git.SortTime | git.SortReverse)
-
-	if err := revWalk.Push(headCommit.Id()); err != nil {
-		return err
-	}
+	r.patchsets = patchsets
+	return nil
+}
 
-	baseObj, err := r.git.RevparseSingle(r.base)
+// readPatchsets reads the patchsets of the branch, passing malformed metadata
+// to report, and stopping with the error it returns, if any.
+func (r *Repo) readPatchsets(report func(*MetadataError) error) (PatchsetCache, error) {
+	commits, err := r.walkEntries()
 	if err != nil {
-		return err
+		return PatchsetCache{}, err
 	}
 
-	if err := revWalk.Hide(baseObj.Id()); err != nil {
-		return err
-	}
-
-	var oid git.Oid
 	var patchsets []*patchset.Patchset
 	patchsetMap := map[string]*patchset.Patchset{}
 	patchsetIndex := map[string]int{}
 	var currentPatchset *patchset.Patchset
-	for {
-		if err := revWalk.Next(&oid); err != nil {
-			break
-		}
-
-		c, err := r.git.LookupCommit(&oid)
-		if err != nil {
-			return err
-		}
-
-		if c.ParentCount() != 1 {
-			continue
-		}
-
-		if isMetadataCommit(c) {
-			patchset, err := patchsetFromMetadata(c.Message())
+	for _, c := range commits {
+		id := c.ID
+		if c.Metadata != "" {
+			patchset, err := patchsetFromMetadata(c.Metadata)
 			if err != nil {
-				log.Warningf("Error parsing metadata for commit %q: %v", c.Id(), err)
+				if err := report(&MetadataError{Commit: id, Problem: err.Error()}); err != nil {
+					return PatchsetCache{}, err
+				}
 				continue
 			}
 			if patchset == nil {
-				log.Warningf("Got nil patchset for commit %q", c.Id())
+				if err := report(&MetadataError{Commit: id, Problem: "invalid patchset"}); err != nil {
+					return PatchsetCache{}, err
+				}
 				continue
 			}
 			if _, ok := patchsetMap[patchset.Name()]; ok {
-				log.Warningf("Patchset %q seen twice", patchset.Name())
+				if err := report(&MetadataError{Commit: id, Problem: fmt.Sprintf("patchset %q seen twice", patchset.Name())}); err != nil {
+					return PatchsetCache{}, err
+				}
 				continue
 			}
-			patchset.AddMetadataCommit(c.Id().String())
+			patchset.AddMetadataCommit(id)
 			patchsets = append(patchsets, patchset)
 			patchsetMap[patchset.Name()] = patchset
 			patchsetIndex[patchset.Name()] = len(patchsets) - 1
 			currentPatchset = patchset
 		} else {
-			fields := parseFields(c.Message())
-			name, ok := fields[patchsetNameField]
-			if !ok {
+			name := c.Patchset
+			if !ValidPatchsetName(name) {
+				if err := report(&MetadataError{Commit: id, Problem: fmt.Sprintf("invalid patchset name %q", name)}); err != nil {
+					return PatchsetCache{}, err
+				}
 				name = "unknown"
 			}
 			if currentPatchset != nil && (name == currentPatchset.Name() || name == "unknown") {
-				currentPatchset.AddPatch(c.Id().String())
+				currentPatchset.AddPatch(id)
 			} else {
 				currentPatchset = nil
 				if p, ok := patchsetMap[name]; ok {
-					p.AddFloatingPatch(c.Id().String())
+					p.AddFloatingPatch(id)
 				} else {
-					log.Warningf("Patch %q belongs to patchset %q which hasn't been seen yet", c.Id().String(), name)
+					if err := report(&MetadataError{Commit: id, Problem: fmt.Sprintf("patch belongs to patchset %q which hasn't been seen yet", name)}); err != nil {
+						return PatchsetCache{}, err
+					}
 					p := patchset.New(name)
-					p.AddFloatingPatch(c.Id().String())
+					p.AddFloatingPatch(id)
 					patchsets = append(patchsets, p)
 					patchsetMap[p.Name()] = p
 				}
 			}
 		}
 	}
-	r.patchsets = PatchsetCache{
+	return PatchsetCache{
 		Slice: patchsets,
 		Map:   patchsetMap,
 		Index: patchsetIndex,
-	}
-	return nil
+	}, nil
 }
 
 // DescribeCommit returns a short ID and description for the commit.
@@ -722,12 +1390,52 @@ func (r *Repo) DescribeCommit(id string) (string, error) {
 	return fmt.Sprintf("%s %s", shortID, commit.Summary()), nil
 }
 
+// CommitPatchset returns the name of the patchset the commit with the given id
+// belongs to, taken from its Patchset-Name trailer, or an empty string if it
+// has none.
+func (r *Repo) CommitPatchset(id string) (string, error) {
+	commit, err := r.lookupCommit(id)
+	if err != nil {
+		return "", err
+	}
+	return parseFields(commit.Message())[patchsetNameField], nil
+}
+
+// ConflictHints returns the conflict hints annotated on the commit with the
+// given id, taken from its "Conflicts:" and "Conflict-Resolution:" trailers.
+func (r *Repo) ConflictHints(id string) ([]string, error) {
+	obj, err := r.git.RevparseSingle(id)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := obj.AsCommit()
+	if err != nil {
+		return nil, err
+	}
+	var hints []string
+	for _, l := range strings.Split(commit.Message(), "\n")[1:] {
+		f := fieldsRegexp.FindStringSubmatch(l)
+		if len(f) != 3 {
+			continue
+		}
+		switch f[1] {
+		case conflictsField, resolutionField:
+			hints = append(hints, fmt.Sprintf("%s: %s", f[1], f[2]))
+		}
+	}
+	return hints, nil
+}
+
 func patchsetFromMetadata(metadata string) (*patchset.Patchset, error) {
-	fields := parseFields(metadata)
+	description, trailer := splitMetadata(metadata)
+	fields := parseFields("\n" + strings.Join(trailer, "\n"))
 	name, ok := fields[patchsetNameField]
 	if !ok {
 		return nil, fmt.Errorf("no %s field found", patchsetNameField)
 	}
+	if !ValidPatchsetName(name) {
+		return nil, fmt.Errorf("invalid patchset name %q", name)
+	}
 	uuid, ok := fields[patchsetUUIDField]
 	if !ok {
 		return nil, fmt.Errorf("no %s field found", patchsetUUIDField)
@@ -740,19 +1448,149 @@ func patchsetFromMetadata(metadata string) (*patchset.Patchset, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse version %q: %w", v, err)
 	}
-	return patchset.Load(name, uuid, version), nil
+	ps := patchset.Load(name, uuid, version)
+	if ps == nil {
+		return nil, nil
+	}
+	ps.SetDescription(description)
+	for _, l := range trailer {
+		if f := fieldsRegexp.FindStringSubmatch(l); len(f) == 3 && !reservedField(f[1]) {
+			ps.SetField(f[1], f[2])
+		}
+	}
+	return ps, nil
+}
+
+// splitMetadata splits a metadata commit message into the description, which
+// is everything between the summary line and the final paragraph, and the
+// lines of the final paragraph, which holds the fields.
+func splitMetadata(message string) (string, []string) {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	start := len(lines)
+	for start > 1 && lines[start-1] != "" {
+		start--
+	}
+	var description string
+	if start > 1 {
+		description = strings.TrimSpace(strings.Join(lines[1:start], "\n"))
+	}
+	return description, lines[start:]
+}
+
+// metadataMessage returns the message of the metadata commit for ps.
+func metadataMessage(ps *patchset.Patchset) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s\n\n", metadataPrefix, ps.Name())
+	if d := strings.TrimSpace(ps.Description()); d != "" {
+		fmt.Fprintf(&b, "%s\n\n", d)
+	}
+	fmt.Fprintf(&b, "%s: %s\n", patchsetNameField, ps.Name())
+	fmt.Fprintf(&b, "%s: %s\n", patchsetUUIDField, ps.UUID())
+	fmt.Fprintf(&b, "%s: %s\n", patchsetVersionField, ps.Version())
+	for _, f := range ps.Fields() {
+		fmt.Fprintf(&b, "%s: %s\n", f.Key, f.Value)
+	}
+	return b.String()
+}
+
+func reservedField(key string) bool {
+	switch key {
+	case patchsetNameField, patchsetUUIDField, patchsetVersionField:
+		return true
+	}
+	return false
+}
+
+// CheckField returns an error if key and value can't be stored as a metadata
+// field of a patchset.
+func CheckField(key, value string) error {
+	if f := fieldsRegexp.FindStringSubmatch(key + ": "); len(f) != 3 || f[1] != key {
+		return fmt.Errorf("invalid field name %q", key)
+	}
+	if reservedField(key) {
+		return fmt.Errorf("field %s is reserved", key)
+	}
+	if strings.ContainsAny(value, "\n") {
+		return fmt.Errorf("value of field %s must be a single line", key)
+	}
+	switch key {
+	case patchset.StrategyField:
+		if _, err := patchset.ParseStrategy(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case patchset.TagsField:
+		if _, err := patchset.ParseTags(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case patchset.ArchivedField:
+		if _, err := patchset.ParseArchived(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	return nil
 }
 
 func isMetadataCommit(commit *git.Commit) bool {
 	return strings.HasPrefix(commit.Message(), metadataPrefix)
 }
 
+// ValidPatchsetName reports whether name can name a patchset, which requires
+// it to be non-empty and free of whitespace.
+func ValidPatchsetName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\r\n")
+}
+
+// parseFields returns the footers of the message, with surrounding whitespace
+// removed from their values.
 func parseFields(message string) map[string]string {
 	fields := map[string]string{}
 	for _, l := range strings.Split(message, "\n")[1:] {
 		if f := fieldsRegexp.FindStringSubmatch(l); len(f) == 3 {
-			fields[f[1]] = f[2]
+			fields[f[1]] = strings.TrimSpace(f[2])
 		}
 	}
 	return fields
 }
+
+// WithPatchsetName returns the message with its Patchset-Name footer set to name.
+func WithPatchsetName(message, name string) string {
+	return setField(message, patchsetNameField, name)
+}
+
+// WithTrailer returns the message with the key trailer set to value. Any
+// existing value is replaced, unless add is set, in which case another key
+// trailer is added.
+func WithTrailer(message, key, value string, add bool) string {
+	return writeField(message, key, value, !add)
+}
+
+// setField sets the key footer in the trailing paragraph of message to value,
+// replacing any existing value, or starting a new trailer paragraph if the
+// message doesn't end in one.
+func setField(message, key, value string) string {
+	return writeField(message, key, value, true)
+}
+
+func writeField(message, key, value string, replace bool) string {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	field := key + ": " + value
+	start := len(lines)
+	for start > 0 && lines[start-1] != "" {
+		start--
+	}
+	if start == 0 {
+		return strings.Join(append(lines, "", field), "\n") + "\n"
+	}
+	for i := start; i < len(lines) && replace; i++ {
+		if f := fieldsRegexp.FindStringSubmatch(lines[i]); len(f) == 3 && f[1] == key {
+			lines[i] = field
+			return strings.Join(lines, "\n") + "\n"
+		}
+	}
+	for _, l := range lines[start:] {
+		if !fieldsRegexp.MatchString(l) {
+			return strings.Join(append(lines, "", field), "\n") + "\n"
+		}
+	}
+	return strings.Join(append(lines, field), "\n") + "\n"
+}
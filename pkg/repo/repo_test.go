@@ -17,9 +17,16 @@ limitations under the License.
 package repo
 
 import (
+	"errors"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/kilt/pkg/internal/testfiles"
 	"github.com/google/kilt/pkg/patchset"
 
@@ -112,6 +119,54 @@ func TestCreateMetadataCommit(t *testing.T) {
 	}
 }
 
+func TestPatchsetNameFooters(t *testing.T) {
+	r := setupRepo(t, "PatchsetNameFooters")
+	defer cleanupRepo(t, r)
+	base, err := newWithGitRepo(r, "", "test", "test").ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatalf("ResolveCommit(): %v", err)
+	}
+	g := newWithGitRepo(r, base, "test", "test")
+	if err := g.createMetadataCommit(patchset.New("a")); err != nil {
+		t.Fatalf("createMetadataCommit(): %v", err)
+	}
+	sig, err := r.DefaultSignature()
+	if err != nil {
+		t.Fatalf("DefaultSignature(): %v", err)
+	}
+	var patches []string
+	for _, message := range []string{"trailing space\n\nPatchset-Name: a \n", "space in name\n\nPatchset-Name: my feature\n"} {
+		head, err := g.lookupCommit("HEAD")
+		if err != nil {
+			t.Fatalf("lookupCommit(): %v", err)
+		}
+		tree, err := head.Tree()
+		if err != nil {
+			t.Fatalf("Tree(): %v", err)
+		}
+		id, err := r.CreateCommit("HEAD", sig, sig, message, tree, head)
+		if err != nil {
+			t.Fatalf("CreateCommit(): %v", err)
+		}
+		patches = append(patches, id.String())
+	}
+
+	ps, err := g.PatchsetMap()
+	if err != nil {
+		t.Fatalf("PatchsetMap(): %v", err)
+	}
+	if a, ok := ps["a"]; !ok || !cmp.Equal(a.Patches(), patches) {
+		t.Errorf("PatchsetMap()[\"a\"] = %v, want patches %v", ps["a"], patches)
+	}
+	malformed, err := g.MalformedMetadata()
+	if err != nil {
+		t.Fatalf("MalformedMetadata(): %v", err)
+	}
+	if len(malformed) != 1 || malformed[0].Commit != patches[1] {
+		t.Errorf("MalformedMetadata() = %v, want commit %s", malformed, patches[1])
+	}
+}
+
 func TestPatchsetMap(t *testing.T) {
 	r := setupRepo(t, "CreateMetadataCommit")
 
@@ -161,3 +216,1114 @@ func TestPatchsetMap(t *testing.T) {
 		}
 	}
 }
+
+func TestSetField(t *testing.T) {
+	tests := []struct {
+		desc, in, out string
+	}{
+		{
+			desc: "Subject only",
+			in:   "ext4: fix inode\n",
+			out:  "ext4: fix inode\n\nPatchset-Name: a\n",
+		},
+		{
+			desc: "Body without trailers",
+			in:   "Subject\n\nSome body text.\n",
+			out:  "Subject\n\nSome body text.\n\nPatchset-Name: a\n",
+		},
+		{
+			desc: "Append to trailers",
+			in:   "Subject\n\nBody.\n\nSigned-off-by: Test <nobody@google.com>\n",
+			out:  "Subject\n\nBody.\n\nSigned-off-by: Test <nobody@google.com>\nPatchset-Name: a\n",
+		},
+		{
+			desc: "Replace existing field",
+			in:   "Subject\n\nPatchset-Name: b\nSigned-off-by: Test <nobody@google.com>\n\n",
+			out:  "Subject\n\nPatchset-Name: a\nSigned-off-by: Test <nobody@google.com>\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := setField(tt.in, patchsetNameField, "a"); got != tt.out {
+			t.Errorf("%s: setField(%q) = %q, want %q", tt.desc, tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestLintMessage(t *testing.T) {
+	a := patchset.New("a")
+	patchsets := PatchsetCache{Slice: []*patchset.Patchset{a}, Map: map[string]*patchset.Patchset{"a": a}}
+	tests := []struct {
+		desc, in string
+		want     []string
+	}{
+		{
+			desc: "Valid footer",
+			in:   "Subject\n\nBody.\n\nPatchset-Name: a\nSigned-off-by: Test <nobody@google.com>\n",
+		},
+		{
+			desc: "Missing footer",
+			in:   "Subject\n\nBody.\n",
+			want: []string{"missing Patchset-Name footer"},
+		},
+		{
+			desc: "Footer outside trailers",
+			in:   "Subject\n\nPatchset-Name: a\n\nBody.\n",
+			want: []string{"Patchset-Name footer is not in the trailing paragraph"},
+		},
+		{
+			desc: "Repeated footer",
+			in:   "Subject\n\nPatchset-Name: a\nPatchset-Name: a\n",
+			want: []string{"Patchset-Name footer given 2 times"},
+		},
+		{
+			desc: "Unknown patchset",
+			in:   "Subject\n\nPatchset-Name: b\n",
+			want: []string{`unknown patchset "b"`},
+		},
+		{
+			desc: "Fixup commit",
+			in:   "fixup! Subject\n",
+		},
+	}
+	for _, tt := range tests {
+		got := LintMessage(tt.in, patchsets)
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("%s: LintMessage() returned diff (-got +want):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestPatchID(t *testing.T) {
+	patch := "diff --git a/a b/a\nindex 1234567..89abcde 100644\n--- a/a\n+++ b/a\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n"
+	tests := []struct {
+		desc, in string
+		same     bool
+	}{
+		{
+			desc: "Different line numbers and index",
+			in:   "diff --git a/a b/a\nindex 7654321..edcba98 100644\n--- a/a\n+++ b/a\n@@ -10,2 +10,2 @@ func\n a\n-b\n+c\n",
+			same: true,
+		},
+		{
+			desc: "Whitespace changes",
+			in:   "diff --git a/a b/a\n--- a/a\n+++ b/a\n@@ -1,2 +1,2 @@\n  a\n-b \n+c\n",
+			same: true,
+		},
+		{
+			desc: "Different change",
+			in:   "diff --git a/a b/a\n--- a/a\n+++ b/a\n@@ -1,2 +1,2 @@\n a\n-b\n+d\n",
+			same: false,
+		},
+	}
+	for _, tt := range tests {
+		if got := patchID(tt.in) == patchID(patch); got != tt.same {
+			t.Errorf("%s: patchID(%q) == patchID(%q) is %v, want %v", tt.desc, tt.in, patch, got, tt.same)
+		}
+	}
+}
+
+func TestWithTrailer(t *testing.T) {
+	in := "Subject\n\nReviewed-by: A <a@google.com>\n"
+	tests := []struct {
+		desc string
+		add  bool
+		out  string
+	}{
+		{
+			desc: "Replace",
+			out:  "Subject\n\nReviewed-by: B <b@google.com>\n",
+		},
+		{
+			desc: "Add",
+			add:  true,
+			out:  "Subject\n\nReviewed-by: A <a@google.com>\nReviewed-by: B <b@google.com>\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := WithTrailer(in, "Reviewed-by", "B <b@google.com>", tt.add); got != tt.out {
+			t.Errorf("%s: WithTrailer(%q) = %q, want %q", tt.desc, in, got, tt.out)
+		}
+	}
+}
+
+func TestWithSubjectPrefix(t *testing.T) {
+	tests := []struct {
+		desc, in, prefix, out string
+	}{
+		{
+			desc:   "Prepended",
+			in:     "Fix a bug\n\nBody\n",
+			prefix: "CHROMIUM:",
+			out:    "CHROMIUM: Fix a bug\n\nBody\n",
+		},
+		{
+			desc:   "Case and spacing normalized",
+			in:     "chromium:Fix a bug\n",
+			prefix: "CHROMIUM:",
+			out:    "CHROMIUM: Fix a bug\n",
+		},
+		{
+			desc:   "Already prefixed",
+			in:     "BACKPORT: Fix a bug\n",
+			prefix: "BACKPORT:",
+			out:    "BACKPORT: Fix a bug\n",
+		},
+		{
+			desc:   "Longer word kept",
+			in:     "FIXUPS are gone",
+			prefix: "FIX",
+			out:    "FIX FIXUPS are gone",
+		},
+	}
+	for _, tt := range tests {
+		got := WithSubjectPrefix(tt.in, tt.prefix)
+		if got != tt.out {
+			t.Errorf("%s: WithSubjectPrefix(%q, %q) = %q, want %q", tt.desc, tt.in, tt.prefix, got, tt.out)
+		}
+		if subject := strings.SplitN(got, "\n", 2)[0]; !HasSubjectPrefix(subject, tt.prefix) {
+			t.Errorf("%s: HasSubjectPrefix(%q, %q) = false, want true", tt.desc, subject, tt.prefix)
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	r := setupRepo(t, "Data")
+	defer cleanupRepo(t, r)
+	g := newWithGitRepo(r, "", "test", "test")
+	if _, err := g.ReadData("deps", "deps.json"); err != ErrNoData {
+		t.Fatalf("ReadData(): got %v, want ErrNoData", err)
+	}
+	for _, contents := range []string{"a\n", "b\n", "b\n"} {
+		if err := g.WriteData("deps", "deps.json", []byte(contents), "Update"); err != nil {
+			t.Fatalf("WriteData(%q): %v", contents, err)
+		}
+		got, err := g.ReadData("deps", "deps.json")
+		if err != nil {
+			t.Fatalf("ReadData(): %v", err)
+		}
+		if string(got) != contents {
+			t.Errorf("ReadData() = %q, want %q", got, contents)
+		}
+	}
+	ref, err := r.References.Lookup("refs/kilt/test/deps")
+	if err != nil {
+		t.Fatalf("Lookup(): %v", err)
+	}
+	commit, err := r.LookupCommit(ref.Target())
+	if err != nil {
+		t.Fatalf("LookupCommit(): %v", err)
+	}
+	if commit.ParentCount() != 1 {
+		t.Errorf("got %d parents, want 1 for unchanged write to be skipped", commit.ParentCount())
+	}
+}
+
+func TestTrackDates(t *testing.T) {
+	old := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	got := trackDates(map[string]time.Time{"a": old, "b": old}, []string{"a", "c"}, now)
+	want := map[string]time.Time{"a": old, "c": now}
+	if len(got) != len(want) {
+		t.Fatalf("trackDates(): got %v, want %v", got, want)
+	}
+	for id, w := range want {
+		if !got[id].Equal(w) {
+			t.Errorf("trackDates(): got %v for %q, want %v", got[id], id, w)
+		}
+	}
+}
+
+func TestInitExistingBase(t *testing.T) {
+	r := setupRepo(t, "InitExistingBase")
+	defer cleanupRepo(t, r)
+	if _, err := Init("HEAD", false); err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	var initialized *ErrInitialized
+	if _, err := Init("HEAD", false); !errors.As(err, &initialized) {
+		t.Errorf("Init() again: got %v, want ErrInitialized", err)
+	}
+	if _, err := Init("HEAD", true); err != nil {
+		t.Errorf("Init() with force: %v", err)
+	}
+}
+
+func TestMetadataMessage(t *testing.T) {
+	ps := patchset.Load("a", "00000000-0000-0000-0000-000000000001", patchset.InitialVersion())
+	ps.SetDescription("Does things.\n\nOwner: not a field.")
+	ps.SetField("Owner", "someone@example.com")
+	ps.SetField("Bug", "b/1")
+	message := metadataMessage(ps)
+	want := metadataPrefix + `a
+
+Does things.
+
+Owner: not a field.
+
+Patchset-Name: a
+Patchset-UUID: 00000000-0000-0000-0000-000000000001
+Patchset-Version: 1
+Owner: someone@example.com
+Bug: b/1
+`
+	if diff := cmp.Diff(message, want); diff != "" {
+		t.Errorf("metadataMessage() returned diff (-got +want):\n%s", diff)
+	}
+	got, err := patchsetFromMetadata(message)
+	if err != nil {
+		t.Fatalf("patchsetFromMetadata(): %v", err)
+	}
+	if !got.Equal(ps) || got.Description() != ps.Description() {
+		t.Errorf("patchsetFromMetadata() = %v, want %v", got, ps)
+	}
+	if diff := cmp.Diff(got.Fields(), ps.Fields()); diff != "" {
+		t.Errorf("patchsetFromMetadata() fields returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestParseEditedMetadata(t *testing.T) {
+	ps := patchset.Load("a", "00000000-0000-0000-0000-000000000001", patchset.InitialVersion())
+	ps.SetDescription("Does things.\n\nOwner: not a field.")
+	ps.SetField("Owner", "someone@example.com")
+	got, err := ParseEditedMetadata(ps, EditableMetadata(ps))
+	if err != nil {
+		t.Fatalf("ParseEditedMetadata(): %v", err)
+	}
+	if !SameMetadata(got, ps) {
+		t.Errorf("ParseEditedMetadata() of unedited text = %q, want %q", metadataMessage(got), metadataMessage(ps))
+	}
+	tests := []struct {
+		text, description string
+		fields            []patchset.Field
+	}{
+		{"# comment\nb\n\nNew description.\n", "New description.", nil},
+		{"b\n\nOwner: me\nBug: 1\n\n", "", []patchset.Field{{"Owner", "me"}, {"Bug", "1"}}},
+		{"b\n\nFirst.\n\nSecond: not a field\nas this isn't\n", "First.\n\nSecond: not a field\nas this isn't", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseEditedMetadata(ps, []byte(tt.text))
+		if err != nil {
+			t.Fatalf("ParseEditedMetadata(%q): %v", tt.text, err)
+		}
+		if got.Name() != "b" || !got.SameVersion(ps) {
+			t.Errorf("ParseEditedMetadata(%q) = %s version %s, want b version %s", tt.text, got.Name(), got.Version(), ps.Version())
+		}
+		if got.Description() != tt.description {
+			t.Errorf("ParseEditedMetadata(%q) description = %q, want %q", tt.text, got.Description(), tt.description)
+		}
+		if diff := cmp.Diff(got.Fields(), tt.fields); diff != "" {
+			t.Errorf("ParseEditedMetadata(%q) fields returned diff (-got +want):\n%s", tt.text, diff)
+		}
+	}
+	for _, bad := range []string{"", "# only\n", "a b\n", "a\n\nPatchset-Name: b\n"} {
+		if _, err := ParseEditedMetadata(ps, []byte(bad)); err == nil {
+			t.Errorf("ParseEditedMetadata(%q): expected error", bad)
+		}
+	}
+}
+
+func TestCheckField(t *testing.T) {
+	tests := []struct {
+		key, value string
+		ok         bool
+	}{
+		{"Owner", "someone", true},
+		{"Upstream-Status", "pending", true},
+		{"Patchset-Name", "a", false},
+		{"a: b", "c", false},
+		{"", "c", false},
+		{"Owner", "a\nb", false},
+	}
+	for _, tt := range tests {
+		if err := CheckField(tt.key, tt.value); (err == nil) != tt.ok {
+			t.Errorf("CheckField(%q, %q) = %v, want ok %t", tt.key, tt.value, err, tt.ok)
+		}
+	}
+}
+
+func TestMoveBase(t *testing.T) {
+	r := setupRepo(t, "MoveBase")
+	defer cleanupRepo(t, r)
+	g, err := Init("HEAD", false)
+	if err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	old := g.KiltBase()
+	if err = g.createMetadataCommit(patchset.New("a")); err != nil {
+		t.Fatalf("createMetadataCommit(): %v", err)
+	}
+	head, err := g.ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatalf("ResolveCommit(): %v", err)
+	}
+	if err = g.MoveBase(head); err != nil {
+		t.Fatalf("MoveBase(): %v", err)
+	}
+	base := func() string {
+		ref, err := r.References.Lookup(baseRef("test"))
+		if err != nil {
+			t.Fatalf("Lookup(): %v", err)
+		}
+		return ref.Target().String()
+	}
+	if got := base(); got != head {
+		t.Errorf("MoveBase(): base = %s, want %s", got, head)
+	}
+	if err = g.RestoreBase(); err != nil {
+		t.Fatalf("RestoreBase(): %v", err)
+	}
+	if got := base(); got != old {
+		t.Errorf("RestoreBase(): base = %s, want %s", got, old)
+	}
+	if saved, err := g.LookupKiltRef(g.ReworkRef("base")); err != nil || saved != "" {
+		t.Errorf("RestoreBase(): saved base ref = %q, %v, want deleted", saved, err)
+	}
+}
+
+func TestFindKiltBranchDetached(t *testing.T) {
+	r := setupRepo(t, "FindKiltBranchDetached")
+	defer cleanupRepo(t, r)
+	head, err := r.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	if _, err := r.References.CreateSymbolic("refs/kilt/test/rework/branch", "refs/heads/test", false, "test"); err != nil {
+		t.Fatalf("CreateSymbolic(): %v", err)
+	}
+	if err := r.SetHeadDetached(head.Target()); err != nil {
+		t.Fatalf("SetHeadDetached(): %v", err)
+	}
+	got, err := findKiltBranch(r)
+	if err != nil {
+		t.Fatalf("findKiltBranch(): %v", err)
+	}
+	if got != "test" {
+		t.Errorf("findKiltBranch() = %q, want %q", got, "test")
+	}
+}
+
+func TestMigrateLegacyRework(t *testing.T) {
+	r := setupRepo(t, "MigrateLegacyRework")
+	defer cleanupRepo(t, r)
+	if _, err := Init("HEAD", false); err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	// Set up a rework as begun by a kilt keeping a single rework per repo.
+	if _, err := r.References.Create("refs/kilt/rework/head", head.Target(), false, "test"); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	if _, err := r.References.CreateSymbolic("refs/kilt/rework/branch", "refs/heads/test", false, "test"); err != nil {
+		t.Fatalf("CreateSymbolic(): %v", err)
+	}
+	legacyDir := filepath.Join(r.Path(), "kilt", "rework")
+	if err := os.MkdirAll(legacyDir, 0777); err != nil {
+		t.Fatalf("MkdirAll(): %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(legacyDir, "queue"), []byte("Finish\n"), 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	if err := r.SetHeadDetached(head.Target()); err != nil {
+		t.Fatalf("SetHeadDetached(): %v", err)
+	}
+
+	g, err := Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	if g.KiltBranch() != "test" {
+		t.Errorf("KiltBranch() = %q, want %q", g.KiltBranch(), "test")
+	}
+	if inProgress, err := g.ReworkInProgress(); err != nil || !inProgress {
+		t.Errorf("ReworkInProgress() = %t, %v, want true", inProgress, err)
+	}
+	if got, err := g.LookupKiltRef(g.ReworkRef("head")); err != nil || got != "refs/kilt/test/rework/head" {
+		t.Errorf("LookupKiltRef(head) = %q, %v, want the migrated rework head", got, err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(g.ReworkDirectory(), "queue")); err != nil || string(got) != "Finish\n" {
+		t.Errorf("migrated queue = %q, %v, want %q", got, err, "Finish\n")
+	}
+	for _, name := range []string{"rework/head", "rework/branch"} {
+		if got, err := g.LookupKiltRef(name); err != nil || got != "" {
+			t.Errorf("LookupKiltRef(%q) = %q, %v, want legacy ref removed", name, got, err)
+		}
+	}
+}
+
+func TestOpenGitRepoFromSubdirectory(t *testing.T) {
+	r := setupRepo(t, "OpenGitRepoFromSubdirectory")
+	defer cleanupRepo(t, r)
+	dir := filepath.Join(r.Workdir(), "a", "b")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatalf("MkdirAll(): %v", err)
+	}
+	os.Chdir(dir)
+	g, err := openGitRepo(".")
+	if err != nil {
+		t.Fatalf("openGitRepo(): %v", err)
+	}
+	if g.Workdir() != r.Workdir() {
+		t.Errorf("openGitRepo(): got work tree %q, want %q", g.Workdir(), r.Workdir())
+	}
+}
+
+func TestSquashMessage(t *testing.T) {
+	target := "Add widget\n\nThe widget widgets.\n\nPatchset-Name: foo\n"
+	tests := []struct {
+		name, fixup string
+		squash      bool
+		want        string
+	}{
+		{"fixup", "fixup! Add widget\n", false, target},
+		{"squash", "squash! Add widget\n\nAlso gadgets.\n\nPatchset-Name: foo\nFixes-Patch: Add widget\n", true, target + "\nAlso gadgets.\n"},
+		{"empty squash", "squash! Add widget\n", true, target},
+	}
+	for _, tt := range tests {
+		if got := squashMessage(target, tt.fixup, tt.squash); got != tt.want {
+			t.Errorf("%s: squashMessage() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	r := setupRepo(t, "Backup")
+	defer cleanupRepo(t, r)
+	g, err := Init("HEAD", false)
+	if err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	before, err := g.ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatalf("ResolveCommit(): %v", err)
+	}
+	if err = g.WriteSymbolicRefHead(g.ReworkRef("branch")); err != nil {
+		t.Fatalf("WriteSymbolicRefHead(): %v", err)
+	}
+	// Simulate a finished rework by moving the branch to a new commit.
+	if err = g.createMetadataCommit(patchset.New("a")); err != nil {
+		t.Fatalf("createMetadataCommit(): %v", err)
+	}
+	after, err := g.ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatalf("ResolveCommit(): %v", err)
+	}
+	if err = r.SetHeadDetached(mustOid(t, after)); err != nil {
+		t.Fatalf("SetHeadDetached(): %v", err)
+	}
+	branch, err := r.LookupBranch("test", git.BranchLocal)
+	if err != nil {
+		t.Fatalf("LookupBranch(): %v", err)
+	}
+	if _, err = branch.SetTarget(mustOid(t, before), ""); err != nil {
+		t.Fatalf("SetTarget(): %v", err)
+	}
+	// Saving again, as a finish interrupted after saving would, is a no-op.
+	for i := 0; i < 2; i++ {
+		if err = g.SaveBackup(); err != nil {
+			t.Fatalf("SaveBackup(): %v", err)
+		}
+	}
+	backups, err := g.Backups()
+	if err != nil {
+		t.Fatalf("Backups(): %v", err)
+	}
+	want := []Backup{{N: 1, Branch: before, Base: g.KiltBase(), Result: after}}
+	if diff := cmp.Diff(backups, want); diff != "" {
+		t.Errorf("Backups() returned diff (-got +want):\n%s", diff)
+	}
+	if err = g.RestoreBackup(backups[0], false); err == nil {
+		t.Errorf("RestoreBackup(): expected error for changed branch")
+	}
+	if err = g.RestoreBackup(backups[0], true); err != nil {
+		t.Fatalf("RestoreBackup(): %v", err)
+	}
+	if got, err := g.ResolveCommit("test"); err != nil || got != before {
+		t.Errorf("RestoreBackup(): branch = %s, %v, want %s", got, err, before)
+	}
+	if backups, err := g.Backups(); err != nil || len(backups) != 0 {
+		t.Errorf("RestoreBackup(): backups = %v, %v, want none", backups, err)
+	}
+}
+
+func mustOid(t *testing.T, id string) *git.Oid {
+	oid, err := git.NewOid(id)
+	if err != nil {
+		t.Fatalf("NewOid(%q): %v", id, err)
+	}
+	return oid
+}
+
+func TestReadOnly(t *testing.T) {
+	r := setupRepo(t, "ReadOnly")
+	defer cleanupRepo(t, r)
+	g := newWithGitRepo(r, "", "test", "test")
+	SetReadOnly(true)
+	defer SetReadOnly(false)
+	before, err := g.ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatalf("ResolveCommit(): %v", err)
+	}
+	if err := g.createMetadataCommit(patchset.New("a")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("createMetadataCommit(): got %v, want ErrReadOnly", err)
+	}
+	if err := g.WriteRefHead("test/rework/head"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("WriteRefHead(): got %v, want ErrReadOnly", err)
+	}
+	if after, err := g.ResolveCommit("HEAD"); err != nil || after != before {
+		t.Errorf("ResolveCommit(): head = %s, %v, want unchanged %s", after, err, before)
+	}
+}
+
+func TestSignedMetadataCommit(t *testing.T) {
+	r := setupRepo(t, "SignedMetadataCommit")
+	defer cleanupRepo(t, r)
+	g := newWithGitRepo(r, "", "test", "test")
+	const signature = "-----BEGIN PGP SIGNATURE-----\n\ntest\n-----END PGP SIGNATURE-----\n"
+	program := filepath.Join(r.Path(), "fake-gpg")
+	if err := ioutil.WriteFile(program, []byte("#!/bin/sh\ncat >/dev/null\nprintf '"+strings.ReplaceAll(signature, "\n", `\n`)+"'\n"), 0755); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	config, err := r.Config()
+	if err != nil {
+		t.Fatalf("Config(): %v", err)
+	}
+	if err = config.SetBool("commit.gpgsign", true); err != nil {
+		t.Fatalf("SetBool(): %v", err)
+	}
+	if err = config.SetString("gpg.program", program); err != nil {
+		t.Fatalf("SetString(): %v", err)
+	}
+	if err := g.createMetadataCommit(patchset.New("signed")); err != nil {
+		t.Fatalf("createMetadataCommit(): %v", err)
+	}
+	head, err := g.lookupCommit("HEAD")
+	if err != nil {
+		t.Fatalf("lookupCommit(): %v", err)
+	}
+	got, _, err := head.ExtractSignature()
+	if err != nil {
+		t.Fatalf("ExtractSignature(): %v", err)
+	}
+	if got != signature {
+		t.Errorf("ExtractSignature() = %q, want %q", got, signature)
+	}
+	if branch, err := g.ResolveCommit("test"); err != nil || branch != head.Id().String() {
+		t.Errorf("ResolveCommit(test) = %s, %v, want signed commit %s", branch, err, head.Id())
+	}
+}
+
+func TestParseGitDate(t *testing.T) {
+	want := time.Date(2020, 6, 2, 10, 0, 0, 0, time.FixedZone("", -7*60*60))
+	for _, date := range []string{
+		"1591117200 -0700",
+		"@1591117200 -0700",
+		"Tue, 2 Jun 2020 10:00:00 -0700",
+		"2020-06-02T10:00:00-07:00",
+		"2020-06-02 10:00:00 -0700",
+	} {
+		got, err := parseGitDate(date)
+		if err != nil {
+			t.Errorf("parseGitDate(%q): %v", date, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseGitDate(%q) = %v, want %v", date, got, want)
+		}
+		if _, offset := got.Zone(); offset != -7*60*60 {
+			t.Errorf("parseGitDate(%q) has offset %d, want %d", date, offset, -7*60*60)
+		}
+	}
+	for _, date := range []string{"", "yesterday", "1591117200 PDT"} {
+		if _, err := parseGitDate(date); err == nil {
+			t.Errorf("parseGitDate(%q): expected error", date)
+		}
+	}
+}
+
+func TestOpenAt(t *testing.T) {
+	r := setupRepo(t, "OpenAt")
+	defer cleanupRepo(t, r)
+	g := newWithGitRepo(r, "", "test", "test")
+	base, err := g.ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatalf("ResolveCommit(): %v", err)
+	}
+	g.base = base
+	var at string
+	for _, name := range []string{"a", "b", "c"} {
+		if err := g.createMetadataCommit(patchset.New(name)); err != nil {
+			t.Fatalf("createMetadataCommit(%q): %v", name, err)
+		}
+		if name == "b" {
+			if at, err = g.ResolveCommit("HEAD"); err != nil {
+				t.Fatalf("ResolveCommit(): %v", err)
+			}
+		}
+	}
+	old, err := g.OpenAt(at, "")
+	if err != nil {
+		t.Fatalf("OpenAt(): %v", err)
+	}
+	if old.KiltBase() != base {
+		t.Errorf("OpenAt(): base = %s, want %s", old.KiltBase(), base)
+	}
+	patchsets, err := old.Patchsets()
+	if err != nil {
+		t.Fatalf("Patchsets(): %v", err)
+	}
+	var names []string
+	for _, p := range patchsets {
+		names = append(names, p.Name())
+	}
+	if diff := cmp.Diff(names, []string{"a", "b"}); diff != "" {
+		t.Errorf("Patchsets() returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestPatchNotes(t *testing.T) {
+	r := setupRepo(t, "PatchNotes")
+	defer cleanupRepo(t, r)
+	g := newWithGitRepo(r, "", "test", "test")
+	var ids []string
+	for _, name := range []string{"a", "b", "c"} {
+		if err := g.createMetadataCommit(patchset.New(name)); err != nil {
+			t.Fatalf("createMetadataCommit(%q): %v", name, err)
+		}
+		id, err := g.ResolveCommit("HEAD")
+		if err != nil {
+			t.Fatalf("ResolveCommit(): %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := g.SetPatchNote(ids[0], "Looks good\n"); err != nil {
+		t.Fatalf("SetPatchNote(): %v", err)
+	}
+	if err := g.SetPatchNote(ids[2], "Needs work\n"); err != nil {
+		t.Fatalf("SetPatchNote(): %v", err)
+	}
+	// The metadata commits make no changes, so they share a patch ID.
+	if err := g.CarryPatchNotes(ids[:1], ids[1:]); err != nil {
+		t.Fatalf("CarryPatchNotes(): %v", err)
+	}
+	for i, want := range []string{"Looks good\n", "Looks good\n", "Needs work\n"} {
+		got, err := g.PatchNote(ids[i])
+		if err != nil {
+			t.Fatalf("PatchNote(): %v", err)
+		}
+		if got != want {
+			t.Errorf("PatchNote(%s) = %q, want %q", ids[i], got, want)
+		}
+	}
+	if err := g.RemovePatchNote(ids[0]); err != nil {
+		t.Fatalf("RemovePatchNote(): %v", err)
+	}
+	if got, err := g.PatchNote(ids[0]); err != nil || got != "" {
+		t.Errorf("PatchNote() = %q, %v after removal, want no note", got, err)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	r := setupRepo(t, "ParseMode")
+	defer cleanupRepo(t, r)
+	base, err := newWithGitRepo(r, "", "test", "test").ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatalf("ResolveCommit(): %v", err)
+	}
+	g := newWithGitRepo(r, base, "test", "test")
+	if err := g.createMetadataCommit(patchset.New("a")); err != nil {
+		t.Fatalf("createMetadataCommit(): %v", err)
+	}
+	head, err := g.lookupCommit("HEAD")
+	if err != nil {
+		t.Fatalf("lookupCommit(): %v", err)
+	}
+	tree, err := head.Tree()
+	if err != nil {
+		t.Fatalf("Tree(): %v", err)
+	}
+	sig, err := r.DefaultSignature()
+	if err != nil {
+		t.Fatalf("DefaultSignature(): %v", err)
+	}
+	bad, err := r.CreateCommit("HEAD", sig, sig, metadataPrefix+"b\n\nPatchset-Name: b\n", tree, head)
+	if err != nil {
+		t.Fatalf("CreateCommit(): %v", err)
+	}
+
+	ps, err := g.PatchsetMap()
+	if err != nil {
+		t.Fatalf("PatchsetMap() in lenient mode: %v", err)
+	}
+	if _, ok := ps["a"]; !ok || len(ps) != 1 {
+		t.Errorf("PatchsetMap() in lenient mode = %v, want only patchset a", ps)
+	}
+	malformed, err := g.MalformedMetadata()
+	if err != nil {
+		t.Fatalf("MalformedMetadata(): %v", err)
+	}
+	if len(malformed) != 1 || malformed[0].Commit != bad.String() {
+		t.Errorf("MalformedMetadata() = %v, want commit %s", malformed, bad)
+	}
+
+	SetParseMode(Strict)
+	defer SetParseMode(Lenient)
+	var metadataErr *MetadataError
+	if _, err := newWithGitRepo(r, base, "test", "test").PatchsetMap(); !errors.As(err, &metadataErr) || metadataErr.Commit != bad.String() {
+		t.Errorf("PatchsetMap() in strict mode: got %v, want malformed metadata in %s", err, bad)
+	}
+}
+
+func TestLinearCommitsWithGraph(t *testing.T) {
+	r := setupRepo(t, "LinearCommitsWithGraph")
+	defer cleanupRepo(t, r)
+	head, err := r.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	base, err := r.LookupCommit(head.Target())
+	if err != nil {
+		t.Fatalf("LookupCommit(): %v", err)
+	}
+	tree, err := base.Tree()
+	if err != nil {
+		t.Fatalf("Tree(): %v", err)
+	}
+	// Each commit is a minute apart, so both walks see the same date order.
+	when := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(message string, parents ...*git.Commit) *git.Commit {
+		when = when.Add(time.Minute)
+		sig := &git.Signature{Name: "Test Data", Email: "nobody@google.com", When: when}
+		oid, err := r.CreateCommit("", sig, sig, message, tree, parents...)
+		if err != nil {
+			t.Fatalf("CreateCommit(%q): %v", message, err)
+		}
+		c, err := r.LookupCommit(oid)
+		if err != nil {
+			t.Fatalf("LookupCommit(): %v", err)
+		}
+		return c
+	}
+	a1 := commit("a1", base)
+	s1 := commit("s1", base)
+	a2 := commit("a2", a1)
+	merge := commit("Merge s1", a2, s1)
+	a3 := commit("a3", merge)
+	if _, err := r.References.Create("refs/heads/test", a3.Id(), true, "test"); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	config, err := r.Config()
+	if err != nil {
+		t.Fatalf("Config(): %v", err)
+	}
+	g := newWithGitRepo(r, base.Id().String(), "test", "test")
+	walk := func(graph bool) []string {
+		if err := config.SetBool(commitGraphConfig, graph); err != nil {
+			t.Fatalf("SetBool(): %v", err)
+		}
+		if got, err := g.useCommitGraph(); err != nil || got != graph {
+			t.Fatalf("useCommitGraph() = %t, %v, want %t", got, err, graph)
+		}
+		commits, err := g.linearCommits(a3.Id(), base.Id())
+		if err != nil {
+			t.Fatalf("linearCommits(): %v", err)
+		}
+		var ids []string
+		for _, c := range commits {
+			ids = append(ids, c.Summary())
+		}
+		return ids
+	}
+	cmd := exec.Command("git", "commit-graph", "write", "--reachable")
+	cmd.Dir = r.Workdir()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git commit-graph write: %v: %s", err, out)
+	}
+	want := []string{"a1", "s1", "a2", "a3"}
+	if diff := cmp.Diff(walk(false), want); diff != "" {
+		t.Errorf("linearCommits() without commit-graph returned diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(walk(true), want); diff != "" {
+		t.Errorf("linearCommits() with commit-graph returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestWalkCache(t *testing.T) {
+	r := setupRepo(t, "WalkCache")
+	defer cleanupRepo(t, r)
+	g, err := Init("HEAD", false)
+	if err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	config, err := g.git.Config()
+	if err != nil {
+		t.Fatalf("Config(): %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err = g.createMetadataCommit(patchset.New(name)); err != nil {
+			t.Fatalf("createMetadataCommit(%q): %v", name, err)
+		}
+	}
+	ids := func() []string {
+		entries, err := g.walkEntries()
+		if err != nil {
+			t.Fatalf("walkEntries(): %v", err)
+		}
+		var ids []string
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+	walks := func() int {
+		cache, ok := g.readWalkCache()
+		if !ok {
+			t.Fatalf("readWalkCache(): no cache")
+		}
+		return len(cache.Walks)
+	}
+	want := ids()
+	if len(want) != 2 {
+		t.Fatalf("walkEntries() = %q, want 2 commits", want)
+	}
+	if got := walks(); got != 1 {
+		t.Errorf("walks cached = %d, want 1", got)
+	}
+
+	// A cached walk is used as is, as long as it is within kilt.maxCommits.
+	cache, _ := g.readWalkCache()
+	cache.Walks[0].Entries = cache.Walks[0].Entries[:1]
+	if err = g.writeWalkCache(cache); err != nil {
+		t.Fatalf("writeWalkCache(): %v", err)
+	}
+	if diff := cmp.Diff(ids(), want[:1]); diff != "" {
+		t.Errorf("walkEntries() with cached walk returned diff (-got +want):\n%s", diff)
+	}
+	if err = config.SetInt32(maxCommitsConfig, 1); err != nil {
+		t.Fatalf("SetInt32(): %v", err)
+	}
+	if _, err := g.walkEntries(); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("walkEntries() with lower kilt.maxCommits: got %v, want ErrLimitExceeded", err)
+	}
+	if err = config.Delete(maxCommitsConfig); err != nil {
+		t.Fatalf("Delete(): %v", err)
+	}
+
+	// Moving the head walks again, keeping the earlier walk cached.
+	if err = g.createMetadataCommit(patchset.New("c")); err != nil {
+		t.Fatalf("createMetadataCommit(): %v", err)
+	}
+	head, err := g.ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatalf("ResolveCommit(): %v", err)
+	}
+	if diff := cmp.Diff(ids(), append(append([]string{}, want...), head)); diff != "" {
+		t.Errorf("walkEntries() after moving head returned diff (-got +want):\n%s", diff)
+	}
+	if got := walks(); got != 2 {
+		t.Errorf("walks cached after moving head = %d, want 2", got)
+	}
+
+	// So does moving the base.
+	if err = g.MoveBase(want[0]); err != nil {
+		t.Fatalf("MoveBase(): %v", err)
+	}
+	if diff := cmp.Diff(ids(), []string{want[1], head}); diff != "" {
+		t.Errorf("walkEntries() after moving base returned diff (-got +want):\n%s", diff)
+	}
+	if got := walks(); got != 3 {
+		t.Errorf("walks cached after moving base = %d, want 3", got)
+	}
+
+	// In read-only mode, walks are still made but not cached.
+	if err = os.Remove(g.walkCachePath()); err != nil {
+		t.Fatalf("Remove(): %v", err)
+	}
+	SetReadOnly(true)
+	defer SetReadOnly(false)
+	if diff := cmp.Diff(ids(), []string{want[1], head}); diff != "" {
+		t.Errorf("walkEntries() in read-only mode returned diff (-got +want):\n%s", diff)
+	}
+	if _, err := os.Stat(g.walkCachePath()); !os.IsNotExist(err) {
+		t.Errorf("Stat(walk cache) in read-only mode: got %v, want not exist", err)
+	}
+}
+
+func TestFloatPatch(t *testing.T) {
+	r := setupRepo(t, "FloatPatch")
+	defer cleanupRepo(t, r)
+	g, err := Init("HEAD", false)
+	if err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	if err = g.createMetadataCommit(patchset.New("a")); err != nil {
+		t.Fatalf("createMetadataCommit(): %v", err)
+	}
+	metadata, err := g.lookupCommit("HEAD")
+	if err != nil {
+		t.Fatalf("lookupCommit(): %v", err)
+	}
+	tree, err := metadata.Tree()
+	if err != nil {
+		t.Fatalf("Tree(): %v", err)
+	}
+	sig := &git.Signature{Name: "Test Data", Email: "nobody@google.com", When: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}
+	commit := func(message string, parents ...*git.Commit) *git.Commit {
+		oid, err := g.git.CreateCommit("", sig, sig, message+"\n\nPatchset-Name: a\n", tree, parents...)
+		if err != nil {
+			t.Fatalf("CreateCommit(%q): %v", message, err)
+		}
+		c, err := g.git.LookupCommit(oid)
+		if err != nil {
+			t.Fatalf("LookupCommit(): %v", err)
+		}
+		return c
+	}
+	setBranch := func(c *git.Commit) {
+		if _, err := g.git.References.Create("refs/heads/test", c.Id(), true, "test"); err != nil {
+			t.Fatalf("Create(): %v", err)
+		}
+	}
+	branchHead := func() string {
+		head, err := g.ResolveCommit("test")
+		if err != nil {
+			t.Fatalf("ResolveCommit(): %v", err)
+		}
+		return head
+	}
+	p1 := commit("p1", metadata)
+	p2 := commit("p2", p1)
+
+	// The merged commit is left out of the walk of the branch, so the merge
+	// has to be found from the tip of the branch.
+	side := commit("side", p1)
+	merge := commit("Merge side", p2, side)
+	setBranch(commit("p3", merge))
+	before := branchHead()
+	if _, err := g.FloatPatch(p1.Id().String(), "b"); err == nil || !strings.Contains(err.Error(), "merge") {
+		t.Errorf("FloatPatch() below a merge: got %v, want merge error", err)
+	}
+	if got := branchHead(); got != before {
+		t.Errorf("FloatPatch() below a merge moved branch to %s, want %s", got, before)
+	}
+
+	setBranch(p2)
+	floated, err := g.FloatPatch(p1.Id().String(), "b")
+	if err != nil {
+		t.Fatalf("FloatPatch(): %v", err)
+	}
+	tip, err := g.lookupCommit("test")
+	if err != nil {
+		t.Fatalf("lookupCommit(): %v", err)
+	}
+	if got := tip.Parent(0).Id().String(); got != floated {
+		t.Errorf("FloatPatch(): parent of branch tip = %s, want floated %s", got, floated)
+	}
+	if got := parseFields(tip.Parent(0).Message())[patchsetNameField]; got != "b" {
+		t.Errorf("FloatPatch(): floated patchset = %q, want %q", got, "b")
+	}
+	if got := parseFields(tip.Message())[patchsetNameField]; got != "a" {
+		t.Errorf("FloatPatch(): patchset of following patch = %q, want %q", got, "a")
+	}
+	if !tip.Parent(0).Parent(0).Id().Equal(metadata.Id()) {
+		t.Errorf("FloatPatch(): floated patch parent = %s, want %s", tip.Parent(0).Parent(0).Id(), metadata.Id())
+	}
+	if !tip.TreeId().Equal(p2.TreeId()) {
+		t.Errorf("FloatPatch(): tree = %s, want %s", tip.TreeId(), p2.TreeId())
+	}
+}
+
+func TestReplayResolutions(t *testing.T) {
+	r := setupRepo(t, "ReplayResolutions")
+	defer cleanupRepo(t, r)
+	g, err := Init("HEAD", false)
+	if err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	config, err := r.Config()
+	if err != nil {
+		t.Fatalf("Config(): %v", err)
+	}
+	if err = config.SetBool(rerereConfig, true); err != nil {
+		t.Fatalf("SetBool(): %v", err)
+	}
+	initial, err := g.lookupCommit("HEAD")
+	if err != nil {
+		t.Fatalf("lookupCommit(): %v", err)
+	}
+	sig := &git.Signature{Name: "Test Data", Email: "nobody@google.com", When: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}
+	commit := func(content, message string, parent *git.Commit) *git.Commit {
+		blob, err := r.CreateBlobFromBuffer([]byte(content))
+		if err != nil {
+			t.Fatalf("CreateBlobFromBuffer(): %v", err)
+		}
+		builder, err := r.TreeBuilder()
+		if err != nil {
+			t.Fatalf("TreeBuilder(): %v", err)
+		}
+		if err = builder.Insert("f", blob, git.FilemodeBlob); err != nil {
+			t.Fatalf("Insert(): %v", err)
+		}
+		id, err := builder.Write()
+		if err != nil {
+			t.Fatalf("Write(): %v", err)
+		}
+		tree, err := r.LookupTree(id)
+		if err != nil {
+			t.Fatalf("LookupTree(): %v", err)
+		}
+		oid, err := r.CreateCommit("", sig, sig, message, tree, parent)
+		if err != nil {
+			t.Fatalf("CreateCommit(%q): %v", message, err)
+		}
+		c, err := r.LookupCommit(oid)
+		if err != nil {
+			t.Fatalf("LookupCommit(): %v", err)
+		}
+		return c
+	}
+	base := commit("base\n", "Add f", initial)
+	ours := commit("ours\n", "Change f here", base)
+	theirs := commit("theirs\n", "Change f there", base)
+	// resetBranch moves the branch to ours, discarding the cherry-pick in
+	// progress, as aborting the rework would.
+	resetBranch := func() {
+		if _, err := r.References.Create("refs/heads/test", ours.Id(), true, "test"); err != nil {
+			t.Fatalf("Create(): %v", err)
+		}
+		if err := g.ResetToHead(); err != nil {
+			t.Fatalf("ResetToHead(): %v", err)
+		}
+	}
+	path := filepath.Join(r.Workdir(), "f")
+
+	resetBranch()
+	if err := g.CherryPickToHead(theirs.Id().String()); !errors.Is(err, ErrUserActionRequired) {
+		t.Fatalf("CherryPickToHead(): got %v, want conflict", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("resolved\n"), 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	if err := g.RecordResolutions(); err != nil {
+		t.Fatalf("RecordResolutions(): %v", err)
+	}
+
+	// Reworking again replays the recorded resolution, so the same conflict
+	// needs no user action.
+	resetBranch()
+	if err := g.CherryPickToHead(theirs.Id().String()); err != nil {
+		t.Fatalf("CherryPickToHead() again: %v", err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "resolved\n" {
+		t.Errorf("f after replay = %q, %v, want %q", b, err, "resolved\n")
+	}
+	head, err := g.lookupCommit("HEAD")
+	if err != nil {
+		t.Fatalf("lookupCommit(): %v", err)
+	}
+	if got := head.Message(); got != theirs.Message() {
+		t.Errorf("HEAD after replay has message %q, want %q", got, theirs.Message())
+	}
+}
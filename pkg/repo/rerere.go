@@ -0,0 +1,65 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"path/filepath"
+
+	log "github.com/golang/glog"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// rerereConfig is the git config option enabling git rerere, which kilt uses
+// to replay recorded conflict resolutions.
+const rerereConfig = "rerere.enabled"
+
+// rerereEnabled reports whether conflict resolutions are recorded and replayed.
+func (r *Repo) rerereEnabled() (bool, error) {
+	return r.ConfigBool(rerereConfig, false)
+}
+
+// replayResolutions records the conflicts in the index with git rerere, and
+// replays any previously recorded resolutions of them, returning the updated
+// index. The returned index has no conflicts if all of them were resolved.
+func (r *Repo) replayResolutions(id string, ix *git.Index) (*git.Index, error) {
+	if enabled, err := r.rerereEnabled(); err != nil || !enabled {
+		return ix, err
+	}
+	if err := r.runGit("", "-c", "rerere.autoUpdate=true", "rerere"); err != nil {
+		return nil, err
+	}
+	ix, err := git.OpenIndex(filepath.Join(r.git.Path(), "index"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	if !ix.HasConflicts() {
+		log.Warningf("Resolved conflicts of %s using recorded resolutions", id)
+	}
+	return ix, nil
+}
+
+// RecordResolutions records the resolutions of conflicts in the work tree with
+// git rerere, so that they can be replayed when the same conflicts recur. It
+// does nothing unless rerere is enabled.
+func (r *Repo) RecordResolutions() error {
+	if enabled, err := r.rerereEnabled(); err != nil || !enabled {
+		return err
+	}
+	return r.runGit("", "rerere")
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// ErrUnresolved is returned when committing a resolution while conflicts
+// remain in the index.
+var ErrUnresolved = errors.New("conflicts remain unresolved")
+
+// ConflictedPaths returns the paths with conflicts in the index on disk.
+func (r *Repo) ConflictedPaths() ([]string, error) {
+	ix, err := git.OpenIndex(filepath.Join(r.git.Path(), "index"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	if !ix.HasConflicts() {
+		return nil, nil
+	}
+	return conflictPaths(ix)
+}
+
+// CommitResolution commits the resolved conflicts of applying the commit with
+// the given id to the head, keeping the author of the commit and the message
+// saved when the conflicts occurred. The resolution is recorded first, if
+// rerere is enabled.
+func (r *Repo) CommitResolution(id string) error {
+	if err := Writable("commit resolution"); err != nil {
+		return err
+	}
+	ix, err := git.OpenIndex(filepath.Join(r.git.Path(), "index"))
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if ix.HasConflicts() {
+		return ErrUnresolved
+	}
+	if err := r.RecordResolutions(); err != nil {
+		return err
+	}
+	commit, err := r.lookupCommit(id)
+	if err != nil {
+		return err
+	}
+	message := commit.Message()
+	msgPath := filepath.Join(r.git.Path(), "MERGE_MSG")
+	if b, err := ioutil.ReadFile(msgPath); err == nil {
+		message = string(b)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	oid, err := ix.WriteTreeTo(r.git)
+	if err != nil {
+		return err
+	}
+	tree, err := r.git.LookupTree(oid)
+	if err != nil {
+		return err
+	}
+	parent, err := r.lookupCommit("HEAD")
+	if err != nil {
+		return err
+	}
+	committer, err := r.rewriteCommitter(commit.Author(), commit.Committer())
+	if err != nil {
+		return err
+	}
+	if _, err := r.createCommit("HEAD", commit.Author(), committer, message, tree, parent); err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+	return r.git.StateCleanup()
+}
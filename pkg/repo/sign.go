@@ -0,0 +1,168 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// signingConfig is the git configuration for signing commits.
+type signingConfig struct {
+	// Format is the value of gpg.format: openpgp, x509 or ssh.
+	Format string
+	// Program is the signing program, from gpg.<format>.program or
+	// gpg.program.
+	Program string
+	// Key is user.signingkey, or the committer identity if it isn't set.
+	Key string
+}
+
+// signingConfig returns the signing configuration of the repo, or nil if
+// commit.gpgsign isn't set.
+func (r *Repo) signingConfig() (*signingConfig, error) {
+	if sign, err := r.ConfigBool("commit.gpgsign", false); err != nil || !sign {
+		return nil, err
+	}
+	format, err := r.ConfigString("gpg.format", "openpgp")
+	if err != nil {
+		return nil, err
+	}
+	def := map[string]string{"openpgp": "gpg", "x509": "gpgsm", "ssh": "ssh-keygen"}[format]
+	if def == "" {
+		return nil, fmt.Errorf("unsupported gpg.format %q", format)
+	}
+	if format == "openpgp" {
+		if def, err = r.ConfigString("gpg.program", def); err != nil {
+			return nil, err
+		}
+	}
+	program, err := r.ConfigString("gpg."+format+".program", def)
+	if err != nil {
+		return nil, err
+	}
+	key, err := r.ConfigString("user.signingkey", "")
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		if format == "ssh" {
+			return nil, fmt.Errorf("user.signingkey is required to sign commits with ssh")
+		}
+		sig, err := r.git.DefaultSignature()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get default signature: %w", err)
+		}
+		key = fmt.Sprintf("%s <%s>", sig.Name, sig.Email)
+	}
+	return &signingConfig{Format: format, Program: program, Key: key}, nil
+}
+
+// signArgs returns the arguments to the signing program that make it write a
+// detached, armored signature of its input, given the path of the key file for
+// ssh signing.
+func (c *signingConfig) signArgs(keyFile string) []string {
+	if c.Format == "ssh" {
+		return []string{"-Y", "sign", "-n", "git", "-f", keyFile}
+	}
+	return []string{"--status-fd=2", "-bsau", c.Key}
+}
+
+// sshKeyFile returns the path of the ssh key file and a function to clean it
+// up. A literal public key, as git allows in user.signingkey, is written to a
+// temporary file.
+func (c *signingConfig) sshKeyFile() (string, func(), error) {
+	key := strings.TrimPrefix(c.Key, "key::")
+	if key == c.Key && !strings.HasPrefix(key, "ssh-") {
+		return key, func() {}, nil
+	}
+	f, err := ioutil.TempFile("", "kilt-signing-key")
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
+
+// sign returns a detached signature of content.
+func (c *signingConfig) sign(content string) (string, string, error) {
+	var keyFile string
+	if c.Format == "ssh" {
+		file, cleanup, err := c.sshKeyFile()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to write signing key: %w", err)
+		}
+		defer cleanup()
+		keyFile = file
+	}
+	cmd := exec.Command(c.Program, c.signArgs(keyFile)...)
+	cmd.Stdin = strings.NewReader(content)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("%s failed to sign commit: %v: %s", c.Program, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return "", "", fmt.Errorf("%s returned an empty signature", c.Program)
+	}
+	return stdout.String(), "", nil
+}
+
+// createCommit creates a commit like git.Repository.CreateCommit, signing it
+// if the repo is configured to sign commits with commit.gpgsign. If ref is
+// set, it is updated to point at the new commit, as is the branch it refers
+// to if it is symbolic.
+func (r *Repo) createCommit(ref string, author, committer *git.Signature, message string, tree *git.Tree, parents ...*git.Commit) (*git.Oid, error) {
+	config, err := r.signingConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return r.git.CreateCommit(ref, author, committer, message, tree, parents...)
+	}
+	unsigned, err := r.git.CreateCommit("", author, committer, message, tree, parents...)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.git.LookupCommit(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := commit.WithSignatureUsing(config.sign)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign commit: %w", err)
+	}
+	switch ref {
+	case "":
+	case "HEAD":
+		err = r.moveHead(oid)
+	default:
+		_, err = r.git.References.Create(ref, oid, true, "commit: "+commit.Summary())
+	}
+	return oid, err
+}
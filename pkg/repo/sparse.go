@@ -0,0 +1,55 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// sparseCheckout reports whether the work tree uses sparse-checkout. libgit2
+// doesn't support sparse-checkout, and would materialize the full tree, so
+// checkouts and cherry-picks are left to git to keep them within the sparse
+// patterns.
+func (r *Repo) sparseCheckout() (bool, error) {
+	if enabled, err := r.ConfigBool("core.sparseCheckout", false); err != nil || !enabled {
+		return false, err
+	}
+	_, err := os.Stat(filepath.Join(r.git.Path(), "info", "sparse-checkout"))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkoutTree updates the index and work tree to the tree, without touching
+// files with local changes.
+func (r *Repo) checkoutTree(tree *git.Tree) error {
+	if err := Writable("check out"); err != nil {
+		return err
+	}
+	if sparse, err := r.sparseCheckout(); err != nil {
+		return err
+	} else if sparse {
+		return r.runGit("", "read-tree", "-m", "-u", "HEAD", tree.Id().String())
+	}
+	return r.git.CheckoutTree(tree, &git.CheckoutOpts{Strategy: git.CheckoutSafe})
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+const (
+	// SubjectPrefixField is the metadata field setting the prefix the
+	// subjects of the patches of a patchset must start with.
+	SubjectPrefixField = "Subject-Prefix"
+
+	subjectPrefixConfig = "kilt.subjectPrefix"
+)
+
+// SubjectPrefix returns the prefix the subjects of the patches of the patchset
+// must start with, such as "CHROMIUM:", or an empty string if there is none.
+// It is set by the Subject-Prefix field of the patchset, else by
+// kilt-patchset.<name>.subjectPrefix, else by kilt-tag.<tag>.subjectPrefix for
+// the first of its tags that has one, else by kilt.subjectPrefix.
+func (r *Repo) SubjectPrefix(ps *patchset.Patchset) (string, error) {
+	if prefix := ps.Field(SubjectPrefixField); prefix != "" {
+		return prefix, nil
+	}
+	prefix, err := r.ConfigString(fmt.Sprintf("kilt-patchset.%s.subjectPrefix", ps.Name()), "")
+	if err != nil || prefix != "" {
+		return prefix, err
+	}
+	for _, tag := range ps.Tags() {
+		prefix, err := r.ConfigString(fmt.Sprintf("kilt-tag.%s.subjectPrefix", tag), "")
+		if err != nil || prefix != "" {
+			return prefix, err
+		}
+	}
+	return r.ConfigString(subjectPrefixConfig, "")
+}
+
+// HasSubjectPrefix reports whether the subject starts with the prefix,
+// followed by a space.
+func HasSubjectPrefix(subject, prefix string) bool {
+	return strings.HasPrefix(subject, prefix+" ")
+}
+
+// WithSubjectPrefix returns the message with its subject starting with the
+// prefix. A prefix differing only in case or in the spacing following it is
+// normalized, otherwise the prefix is prepended.
+func WithSubjectPrefix(message, prefix string) string {
+	subject, rest := message, ""
+	if i := strings.Index(message, "\n"); i >= 0 {
+		subject, rest = message[:i], message[i:]
+	}
+	if len(subject) >= len(prefix) && strings.EqualFold(subject[:len(prefix)], prefix) {
+		if after := subject[len(prefix):]; after == "" || after[0] == ' ' || after[0] == '\t' || strings.HasSuffix(prefix, ":") {
+			subject = after
+		}
+	}
+	return prefix + " " + strings.TrimLeft(subject, " \t") + rest
+}
+
+// SetSubjectPrefixToHead cherry-picks the commit to head, making its subject
+// start with the prefix.
+func (r *Repo) SetSubjectPrefixToHead(id, prefix string) error {
+	return r.cherryPickToHead(id, patchset.Strategy{}, func(message string) string {
+		return WithSubjectPrefix(message, prefix)
+	})
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"path"
+	"sort"
+
+	log "github.com/golang/glog"
+	"github.com/libgit2/git2go/v30"
+)
+
+const (
+	// submoduleUpdateConfig controls whether checkouts of initialized
+	// submodules are updated when an applied patch changes their gitlink.
+	submoduleUpdateConfig = "kilt.submoduleUpdate"
+	gitmodulesPath        = ".gitmodules"
+)
+
+// SubmoduleState records a submodule of the head commit.
+type SubmoduleState struct {
+	Path string `json:"path"`
+	// Commit is the commit the gitlink of the head commit records.
+	Commit string `json:"commit"`
+	// Checkout is the commit checked out in the submodule, and empty if the
+	// submodule isn't initialized.
+	Checkout string `json:"checkout,omitempty"`
+}
+
+// Submodules returns the state of the submodules of the head commit, sorted
+// by path.
+func (r *Repo) Submodules() ([]SubmoduleState, error) {
+	head, err := r.lookupCommit("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	tree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var states []SubmoduleState
+	err = tree.Walk(func(dir string, entry *git.TreeEntry) int {
+		if entry.Filemode == git.FilemodeCommit {
+			states = append(states, SubmoduleState{Path: path.Join(dir, entry.Name), Commit: entry.Id.String()})
+		}
+		return 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range states {
+		states[i].Checkout = r.submoduleCheckout(states[i].Path)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Path < states[j].Path })
+	return states, nil
+}
+
+// submoduleCheckout returns the commit checked out in the submodule at path,
+// or an empty string if it isn't initialized.
+func (r *Repo) submoduleCheckout(path string) string {
+	sub, err := r.git.Submodules.Lookup(path)
+	if err != nil {
+		return ""
+	}
+	defer sub.Free()
+	if id := sub.WdId(); id != nil {
+		return id.String()
+	}
+	return ""
+}
+
+// gitlinkChanges returns the paths of the gitlinks the diff adds or changes,
+// and whether it changes .gitmodules.
+func gitlinkChanges(diff *git.Diff) ([]string, bool, error) {
+	n, err := diff.NumDeltas()
+	if err != nil {
+		return nil, false, err
+	}
+	var paths []string
+	var gitmodules bool
+	for i := 0; i < n; i++ {
+		delta, err := diff.GetDelta(i)
+		if err != nil {
+			return nil, false, err
+		}
+		if delta.NewFile.Path == gitmodulesPath || delta.OldFile.Path == gitmodulesPath {
+			gitmodules = true
+		}
+		if git.Filemode(delta.NewFile.Mode) == git.FilemodeCommit && delta.Status != git.DeltaDeleted {
+			paths = append(paths, delta.NewFile.Path)
+		}
+	}
+	return paths, gitmodules, nil
+}
+
+// syncSubmodules brings the checkouts of initialized submodules whose gitlink
+// changed between the trees in line with the new tree. Unless
+// kilt.submoduleUpdate is disabled, git submodule sync and update are run;
+// otherwise, or if they fail, a warning names the affected submodules, so the
+// work tree isn't silently left inconsistent.
+func (r *Repo) syncSubmodules(from, to *git.Tree) error {
+	diff, err := r.diffTrees(from, to)
+	if err != nil {
+		return err
+	}
+	defer diff.Free()
+	paths, gitmodules, err := gitlinkChanges(diff)
+	if err != nil {
+		return err
+	}
+	var initialized []string
+	for _, p := range paths {
+		if r.submoduleCheckout(p) != "" {
+			initialized = append(initialized, p)
+		}
+	}
+	if len(initialized) == 0 && !gitmodules {
+		return nil
+	}
+	update, err := r.ConfigBool(submoduleUpdateConfig, true)
+	if err != nil {
+		return err
+	}
+	if gitmodules {
+		if !update {
+			log.Warningf("Patch changes %s; run git submodule sync to update submodule configuration", gitmodulesPath)
+		} else if err := r.runGit("", "submodule", "sync", "--quiet"); err != nil {
+			log.Warningf("Failed to sync submodules after %s changed: %v", gitmodulesPath, err)
+		}
+	}
+	for _, p := range initialized {
+		if !update {
+			log.Warningf("Submodule %s is not checked out at the commit the patch records; run git submodule update -- %s", p, p)
+			continue
+		}
+		if err := r.runGit("", "submodule", "update", "--quiet", "--", p); err != nil {
+			log.Warningf("Failed to update submodule %s: %v", p, err)
+		}
+	}
+	return nil
+}
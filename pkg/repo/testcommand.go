@@ -0,0 +1,84 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+const testCommandConfig = "kilt.testCommand"
+
+// TestCommand returns the shell command testing the patchset once it has been
+// reworked or applied, or an empty string if there is none. It is set by
+// kilt-patchset.<name>.testCommand, else by kilt-tag.<tag>.testCommand for the
+// first of its tags that has one, else by kilt.testCommand.
+func (r *Repo) TestCommand(ps *patchset.Patchset) (string, error) {
+	command, err := r.ConfigString(fmt.Sprintf("kilt-patchset.%s.testCommand", ps.Name()), "")
+	if err != nil || command != "" {
+		return command, err
+	}
+	for _, tag := range ps.Tags() {
+		command, err := r.ConfigString(fmt.Sprintf("kilt-tag.%s.testCommand", tag), "")
+		if err != nil || command != "" {
+			return command, err
+		}
+	}
+	return r.ConfigString(testCommandConfig, "")
+}
+
+// ErrTestFailed is returned when the test command of a patchset fails.
+type ErrTestFailed struct {
+	Patchset, Command string
+	Err               error
+}
+
+func (e *ErrTestFailed) Error() string {
+	return fmt.Sprintf("test of patchset %s failed: %q: %v", e.Patchset, e.Command, e.Err)
+}
+
+func (e *ErrTestFailed) Unwrap() error {
+	return e.Err
+}
+
+// RunTestCommand runs the shell command in the work tree to test the
+// patchset. The name of the patchset and the commit under test are passed in
+// the KILT_PATCHSET and KILT_HEAD environment variables. The output of the
+// command is sent to standard error.
+func (r *Repo) RunTestCommand(command string, ps *patchset.Patchset) error {
+	if err := Writable("run test command"); err != nil {
+		return err
+	}
+	head, err := r.ResolveCommit("HEAD")
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = r.git.Workdir()
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"KILT_PATCHSET="+ps.Name(),
+		"KILT_HEAD="+head)
+	if err := cmd.Run(); err != nil {
+		return &ErrTestFailed{Patchset: ps.Name(), Command: command, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// commitGraphConfig is the git config option controlling whether walks use
+// git's commit-graph when the repo has one.
+const commitGraphConfig = "kilt.commitGraph"
+
+// LogEntry describes a commit of the kilt branch and the patchset it belongs to.
+type LogEntry struct {
+	ID       string
+	ShortID  string
+	Summary  string
+	Patchset string
+	Metadata bool
+	Floating bool
+}
+
+// Log returns the commits of the kilt branch from the base to the head, in the
+// order they were walked to find the patchsets.
+func (r *Repo) Log() ([]LogEntry, error) {
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]LogEntry{}
+	for _, ps := range patchsets {
+		if id := ps.MetadataCommit(); id != "" {
+			owners[id] = LogEntry{Patchset: ps.Name(), Metadata: true}
+		}
+		for _, id := range ps.Patches() {
+			owners[id] = LogEntry{Patchset: ps.Name()}
+		}
+		for _, id := range ps.FloatingPatches() {
+			owners[id] = LogEntry{Patchset: ps.Name(), Floating: true}
+		}
+	}
+	commits, err := r.branchCommits()
+	if err != nil {
+		return nil, err
+	}
+	var entries []LogEntry
+	for _, c := range commits {
+		e, ok := owners[c.Id().String()]
+		if !ok {
+			continue
+		}
+		e.ID = c.Id().String()
+		if e.ShortID, err = c.ShortId(); err != nil {
+			return nil, err
+		}
+		e.Summary = c.Summary()
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// CommitsBetween returns the ids of the commits reachable from to but not from
+// from, oldest first. Merge commits are left out.
+func (r *Repo) CommitsBetween(from, to string) ([]string, error) {
+	fromObj, err := r.git.RevparseSingle(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", from, err)
+	}
+	toObj, err := r.git.RevparseSingle(to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", to, err)
+	}
+	fromCommit, err := fromObj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := toObj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := r.linearCommits(toCommit.Id(), fromCommit.Id())
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, c := range commits {
+		ids = append(ids, c.Id().String())
+	}
+	return ids, nil
+}
+
+// branchCommits returns the linear commits between the base and the head.
+func (r *Repo) branchCommits() ([]*git.Commit, error) {
+	head, base, err := r.branchRange()
+	if err != nil {
+		return nil, err
+	}
+	return r.linearCommits(head, base)
+}
+
+// branchRange returns the ids of the head and the base of the branch.
+func (r *Repo) branchRange() (head, base *git.Oid, err error) {
+	branch, err := r.git.LookupBranch(r.head, git.BranchLocal)
+	var headCommit *git.Object
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		// The head is a ref outside of refs/heads, or a commit id for a
+		// repo opened at a past commit.
+		obj, err := r.git.RevparseSingle(r.head)
+		if err != nil {
+			return nil, nil, err
+		}
+		if headCommit, err = obj.Peel(git.ObjectCommit); err != nil {
+			return nil, nil, err
+		}
+	} else if err != nil {
+		return nil, nil, err
+	} else if headCommit, err = branch.Reference.Peel(git.ObjectCommit); err != nil {
+		return nil, nil, err
+	}
+	baseObj, err := r.git.RevparseSingle(r.base)
+	if err != nil {
+		return nil, nil, err
+	}
+	return headCommit.Id(), baseObj.Id(), nil
+}
+
+// linearCommits returns the commits with a single parent that are reachable
+// from head but not from base, in reverse topological order.
+// The walk stops with an error wrapping ErrLimitExceeded after kilt.maxCommits
+// commits.
+func (r *Repo) linearCommits(head, base *git.Oid) ([]*git.Commit, error) {
+	commits, _, err := r.walkLinearCommits(head, base)
+	return commits, err
+}
+
+// walkLinearCommits implements linearCommits, also returning the number of
+// commits walked, merges included, which is what kilt.maxCommits limits.
+func (r *Repo) walkLinearCommits(head, base *git.Oid) ([]*git.Commit, int, error) {
+	max, err := r.MaxCommits()
+	if err != nil {
+		return nil, 0, err
+	}
+	if graph, err := r.useCommitGraph(); err != nil {
+		return nil, 0, err
+	} else if graph {
+		return r.linearCommitsWithGraph(head, base, max)
+	}
+	revWalk, err := r.git.Walk()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer revWalk.Free()
+
+	revWalk.Sorting(git.SortTopological | git.SortTime | git.SortReverse)
+
+	if err := revWalk.Push(head); err != nil {
+		return nil, 0, err
+	}
+	if err := revWalk.Hide(base); err != nil {
+		return nil, 0, err
+	}
+
+	var oid git.Oid
+	var commits []*git.Commit
+	walked := 0
+	for ; ; walked++ {
+		if err := revWalk.Next(&oid); err != nil {
+			break
+		}
+		if max > 0 && walked >= max {
+			return nil, 0, commitLimitError(head, base, max)
+		}
+		c, err := r.git.LookupCommit(&oid)
+		if err != nil {
+			return nil, 0, err
+		}
+		if c.ParentCount() == 1 {
+			commits = append(commits, c)
+		}
+	}
+	return commits, walked, nil
+}
+
+// linearCommitsWithGraph implements walkLinearCommits with git rev-list, which
+// uses the commit-graph to find the boundary with base and the parents of each
+// commit without parsing the commits. Only the commits that are returned are
+// loaded.
+func (r *Repo) linearCommitsWithGraph(head, base *git.Oid, max int) ([]*git.Commit, int, error) {
+	args := []string{"rev-list", "--date-order", "--reverse", "--parents", head.String(), "^" + base.String()}
+	if max > 0 {
+		args = append(args, fmt.Sprintf("--max-count=%d", max+1))
+	}
+	out, err := r.gitOutput("", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	var lines []string
+	if out = strings.TrimSpace(out); out != "" {
+		lines = strings.Split(out, "\n")
+	}
+	if max > 0 && len(lines) > max {
+		return nil, 0, commitLimitError(head, base, max)
+	}
+	var commits []*git.Commit
+	for _, line := range lines {
+		ids := strings.Fields(line)
+		if len(ids) != 2 {
+			continue
+		}
+		oid, err := git.NewOid(ids[0])
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse commit id %q: %w", ids[0], err)
+		}
+		c, err := r.git.LookupCommit(oid)
+		if err != nil {
+			return nil, 0, err
+		}
+		commits = append(commits, c)
+	}
+	return commits, len(lines), nil
+}
+
+// useCommitGraph reports whether walks should use git's commit-graph, which
+// libgit2 doesn't support. A commit-graph can be written with
+// "git commit-graph write --reachable", or by setting fetch.writeCommitGraph.
+func (r *Repo) useCommitGraph() (bool, error) {
+	if enabled, err := r.ConfigBool(commitGraphConfig, true); err != nil || !enabled {
+		return false, err
+	}
+	info := filepath.Join(r.git.Path(), "objects", "info")
+	for _, f := range []string{"commit-graph", filepath.Join("commit-graphs", "commit-graph-chain")} {
+		if _, err := os.Stat(filepath.Join(info, f)); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
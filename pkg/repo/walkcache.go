@@ -0,0 +1,171 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/golang/glog"
+
+	"github.com/google/kilt/pkg/internal/atomicfile"
+)
+
+const (
+	// walkCacheConfig is the git config option controlling whether the walk
+	// of the branch is cached between invocations.
+	walkCacheConfig = "kilt.walkCache"
+	// walkCacheFormat is the version of the format of the walk cache, which
+	// is discarded if it differs.
+	walkCacheFormat = 2
+	// walkCacheSize is the number of walks kept in the walk cache, so that
+	// walks at other heads, such as those of kilt log --at or of branch
+	// views, don't evict the walk of the branch.
+	walkCacheSize = 8
+)
+
+// walkEntry is a commit of the branch, with what finding the patchsets needs
+// to know about it.
+type walkEntry struct {
+	ID string `json:"id"`
+	// Metadata holds the message of a metadata commit, and is empty for
+	// patches.
+	Metadata string `json:"metadata,omitempty"`
+	// Patchset is the patchset a patch names in its Patchset-Name trailer,
+	// or "unknown" if it has none.
+	Patchset string `json:"patchset,omitempty"`
+}
+
+// cachedWalk is the walk of the branch between the base and the head with the
+// given ids.
+type cachedWalk struct {
+	Head string `json:"head"`
+	Base string `json:"base"`
+	// Walked is the number of commits walked, merges included, which
+	// kilt.maxCommits is checked against.
+	Walked  int         `json:"walked"`
+	Entries []walkEntry `json:"entries"`
+}
+
+// walkCache holds the most recent walks, most recent first.
+type walkCache struct {
+	Format int          `json:"format"`
+	Walks  []cachedWalk `json:"walks"`
+}
+
+// lookup returns the cached walk between the base and the head.
+func (c *walkCache) lookup(head, base string) (cachedWalk, bool) {
+	for _, w := range c.Walks {
+		if w.Head == head && w.Base == base {
+			return w, true
+		}
+	}
+	return cachedWalk{}, false
+}
+
+// add adds the walk as the most recent, dropping the least recent walks beyond
+// walkCacheSize.
+func (c *walkCache) add(walk cachedWalk) {
+	c.Walks = append([]cachedWalk{walk}, c.Walks...)
+	if len(c.Walks) > walkCacheSize {
+		c.Walks = c.Walks[:walkCacheSize]
+	}
+}
+
+func (r *Repo) walkCachePath() string {
+	return filepath.Join(r.KiltDirectory(), "walk-cache")
+}
+
+// walkEntries returns the linear commits between the base and the head. The
+// walks are cached under the kilt directory, keyed by the ids of the head and
+// the base, so that they are only repeated once either moves. Cached walks are
+// still checked against kilt.maxCommits. Failing to use the cache is never
+// fatal.
+func (r *Repo) walkEntries() ([]walkEntry, error) {
+	head, base, err := r.branchRange()
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := r.ConfigBool(walkCacheConfig, true)
+	if err != nil {
+		return nil, err
+	}
+	var cache walkCache
+	if enabled {
+		cache, _ = r.readWalkCache()
+		if walk, ok := cache.lookup(head.String(), base.String()); ok {
+			max, err := r.MaxCommits()
+			if err != nil {
+				return nil, err
+			}
+			if max > 0 && walk.Walked > max {
+				return nil, commitLimitError(head, base, max)
+			}
+			return walk.Entries, nil
+		}
+	}
+	commits, walked, err := r.walkLinearCommits(head, base)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]walkEntry, len(commits))
+	for i, c := range commits {
+		entries[i].ID = c.Id().String()
+		if isMetadataCommit(c) {
+			entries[i].Metadata = c.Message()
+			continue
+		}
+		name, ok := parseFields(c.Message())[patchsetNameField]
+		if !ok {
+			name = "unknown"
+		}
+		entries[i].Patchset = name
+	}
+	if enabled && !ReadOnly() {
+		cache.add(cachedWalk{Head: head.String(), Base: base.String(), Walked: walked, Entries: entries})
+		if err := r.writeWalkCache(cache); err != nil {
+			log.V(1).Infof("Failed to write walk cache: %v", err)
+		}
+	}
+	return entries, nil
+}
+
+func (r *Repo) readWalkCache() (walkCache, bool) {
+	var cache walkCache
+	b, err := ioutil.ReadFile(r.walkCachePath())
+	if err != nil {
+		return walkCache{}, false
+	}
+	if err := json.Unmarshal(b, &cache); err != nil || cache.Format != walkCacheFormat {
+		return walkCache{}, false
+	}
+	return cache, true
+}
+
+func (r *Repo) writeWalkCache(cache walkCache) error {
+	cache.Format = walkCacheFormat
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.KiltDirectory(), 0777); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(r.walkCachePath(), b, 0666)
+}
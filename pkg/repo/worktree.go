@@ -0,0 +1,53 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// AddWorktree creates a linked work tree at path with its head detached at
+// rev, leaving the current work tree and its head untouched.
+func (r *Repo) AddWorktree(path, rev string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	return r.runGit("", "worktree", "add", "--detach", abs, rev)
+}
+
+// OpenWorktree returns a repo for the kilt branch in the linked work tree at
+// path. The work tree shares refs and objects with r, but has its own head,
+// index and kilt state.
+func (r *Repo) OpenWorktree(path string) (*Repo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	g, err := git.OpenRepository(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open work tree %q: %w", path, err)
+	}
+	w := newWithGitRepo(g, r.base, r.branch, r.head)
+	w.network = r.network
+	w.reporter = r.reporter
+	w.dir = abs
+	return w, nil
+}
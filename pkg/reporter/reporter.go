@@ -0,0 +1,317 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reporter implements reporting the progress of kilt operations.
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Reporter receives the messages of kilt operations.
+type Reporter interface {
+	// Operation reports that the named operation is being performed.
+	Operation(name, message string)
+	// Note reports information not tied to an operation, such as hints for
+	// resolving conflicts.
+	Note(message string)
+}
+
+// ProgressReporter is implemented by reporters that track how many of the
+// queued operations are complete.
+type ProgressReporter interface {
+	// Progress reports the position in the queue, before the next operation
+	// is performed.
+	Progress(p Progress)
+}
+
+// StateReporter is implemented by reporters that track how operations end and
+// what they do to the work tree.
+type StateReporter interface {
+	// Finished reports that the named operation finished, with err set if it
+	// failed.
+	Finished(name string, err error)
+	// Conflict reports that applying the commit stopped at conflicts in the
+	// paths.
+	Conflict(commit string, paths []string)
+	// Checkout reports that the target was checked out.
+	Checkout(target string)
+}
+
+// Finished reports the end of the named operation to rep, if it tracks them.
+func Finished(rep Reporter, name string, err error) {
+	if s, ok := rep.(StateReporter); ok {
+		s.Finished(name, err)
+	}
+}
+
+// Conflict reports conflicts applying the commit to rep, if it tracks them.
+func Conflict(rep Reporter, commit string, paths []string) {
+	if s, ok := rep.(StateReporter); ok {
+		s.Conflict(commit, paths)
+	}
+}
+
+// Checkout reports the checkout of the target to rep, if it tracks them.
+func Checkout(rep Reporter, target string) {
+	if s, ok := rep.(StateReporter); ok {
+		s.Checkout(target)
+	}
+}
+
+// Progress counts the complete operations of a queue.
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Percent returns the percentage of complete operations.
+func (p Progress) Percent() int {
+	if p.Total == 0 {
+		return 0
+	}
+	return p.Done * 100 / p.Total
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("%d/%d operations complete", p.Done, p.Total)
+}
+
+// Event types.
+const (
+	TypeOperation = "operation"
+	TypeNote      = "note"
+	TypeProgress  = "progress"
+	TypeFinished  = "finished"
+	TypeConflict  = "conflict"
+	TypeCheckout  = "checkout"
+)
+
+// Event is a single reported message.
+type Event struct {
+	Type      string    `json:"type"`
+	Operation string    `json:"operation,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Progress  *Progress `json:"progress,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	Paths     []string  `json:"paths,omitempty"`
+	Target    string    `json:"target,omitempty"`
+}
+
+func finishedEvent(name string, err error) Event {
+	e := Event{Type: TypeFinished, Operation: name}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// Verbosity controls how much a console reporter writes.
+type Verbosity int
+
+// Verbosity levels.
+const (
+	// Normal writes operation messages prefixed with their queue position.
+	Normal Verbosity = iota
+	// Terse only writes notes, leaving out operation messages.
+	Terse
+	// Verbose also writes the operation name and percentage complete.
+	Verbose
+)
+
+// Console reports messages as lines of text.
+type Console struct {
+	w         io.Writer
+	verbosity Verbosity
+	progress  Progress
+}
+
+// NewConsole returns a reporter writing messages as lines of text to w.
+func NewConsole(w io.Writer) *Console {
+	return &Console{w: w}
+}
+
+// SetVerbosity sets how much the console writes.
+func (c *Console) SetVerbosity(v Verbosity) {
+	c.verbosity = v
+}
+
+// Operation writes the message, prefixed with the position of the operation
+// in the queue once progress has been reported.
+func (c *Console) Operation(name, message string) {
+	p := c.progress
+	switch {
+	case c.verbosity == Terse:
+	case p.Total == 0:
+		fmt.Fprintln(c.w, message)
+	case c.verbosity == Verbose:
+		fmt.Fprintf(c.w, "[%d/%d %3d%%] %s: %s\n", p.Done+1, p.Total, p.Percent(), name, message)
+	default:
+		fmt.Fprintf(c.w, "[%d/%d] %s\n", p.Done+1, p.Total, message)
+	}
+}
+
+// Progress records the position used to prefix operation messages.
+func (c *Console) Progress(p Progress) {
+	c.progress = p
+}
+
+// Note writes the message.
+func (c *Console) Note(message string) {
+	fmt.Fprintln(c.w, message)
+}
+
+// Finished writes the result of the operation when verbose.
+func (c *Console) Finished(name string, err error) {
+	switch {
+	case c.verbosity != Verbose:
+	case err != nil:
+		fmt.Fprintf(c.w, "%s: failed: %v\n", name, err)
+	default:
+		fmt.Fprintf(c.w, "%s: done\n", name)
+	}
+}
+
+// Conflict writes the conflicting paths.
+func (c *Console) Conflict(commit string, paths []string) {
+	fmt.Fprintf(c.w, "Conflicts applying %s in:\n", commit)
+	for _, p := range paths {
+		fmt.Fprintf(c.w, "\t%s\n", p)
+	}
+}
+
+// Checkout writes the checked out target when verbose.
+func (c *Console) Checkout(target string) {
+	if c.verbosity == Verbose {
+		fmt.Fprintf(c.w, "Checked out %s\n", target)
+	}
+}
+
+// Quiet discards all messages.
+type Quiet struct{}
+
+// Operation discards the message.
+func (Quiet) Operation(name, message string) {}
+
+// Note discards the message.
+func (Quiet) Note(message string) {}
+
+// JSON reports messages as a stream of JSON encoded events, one per line.
+type JSON struct {
+	enc *json.Encoder
+}
+
+// NewJSON returns a reporter writing JSON encoded events to w.
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{enc: json.NewEncoder(w)}
+}
+
+// Operation writes an operation event.
+func (j *JSON) Operation(name, message string) {
+	j.enc.Encode(Event{Type: TypeOperation, Operation: name, Message: message})
+}
+
+// Note writes a note event.
+func (j *JSON) Note(message string) {
+	j.enc.Encode(Event{Type: TypeNote, Message: message})
+}
+
+// Progress writes a progress event.
+func (j *JSON) Progress(p Progress) {
+	j.enc.Encode(Event{Type: TypeProgress, Progress: &p})
+}
+
+// Finished writes a finished event.
+func (j *JSON) Finished(name string, err error) {
+	j.enc.Encode(finishedEvent(name, err))
+}
+
+// Conflict writes a conflict event.
+func (j *JSON) Conflict(commit string, paths []string) {
+	j.enc.Encode(Event{Type: TypeConflict, Commit: commit, Paths: paths})
+}
+
+// Checkout writes a checkout event.
+func (j *JSON) Checkout(target string) {
+	j.enc.Encode(Event{Type: TypeCheckout, Target: target})
+}
+
+// Recorder records reported messages, for use in tests.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Operation records an operation event.
+func (r *Recorder) Operation(name, message string) {
+	r.record(Event{Type: TypeOperation, Operation: name, Message: message})
+}
+
+// Note records a note event.
+func (r *Recorder) Note(message string) {
+	r.record(Event{Type: TypeNote, Message: message})
+}
+
+// Progress records a progress event.
+func (r *Recorder) Progress(p Progress) {
+	r.record(Event{Type: TypeProgress, Progress: &p})
+}
+
+// Finished records a finished event.
+func (r *Recorder) Finished(name string, err error) {
+	r.record(finishedEvent(name, err))
+}
+
+// Conflict records a conflict event.
+func (r *Recorder) Conflict(commit string, paths []string) {
+	r.record(Event{Type: TypeConflict, Commit: commit, Paths: append([]string{}, paths...)})
+}
+
+// Checkout records a checkout event.
+func (r *Recorder) Checkout(target string) {
+	r.record(Event{Type: TypeCheckout, Target: target})
+}
+
+func (r *Recorder) record(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// Events returns the recorded events.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+// New returns the reporter for the named format, which is one of "text",
+// "json" or "quiet". Messages are written to w.
+func New(format string, w io.Writer) (Reporter, error) {
+	switch format {
+	case "text":
+		return NewConsole(w), nil
+	case "json":
+		return NewJSON(w), nil
+	case "quiet":
+		return Quiet{}, nil
+	}
+	return nil, fmt.Errorf("unknown report format %q", format)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reporter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReporters(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "text",
+			want:   "Applying a\nhint\n",
+		},
+		{
+			format: "json",
+			want:   `{"type":"operation","operation":"Apply","message":"Applying a"}` + "\n" + `{"type":"note","message":"hint"}` + "\n",
+		},
+		{
+			format: "quiet",
+		},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		r, err := New(tt.format, &b)
+		if err != nil {
+			t.Fatalf("New(%q): %v", tt.format, err)
+		}
+		r.Operation("Apply", "Applying a")
+		r.Note("hint")
+		if diff := cmp.Diff(b.String(), tt.want); diff != "" {
+			t.Errorf("%s: reported diff (-got +want)\n%s", tt.format, diff)
+		}
+	}
+	if _, err := New("xml", nil); err == nil {
+		t.Errorf("New(%q): expected error", "xml")
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := &Recorder{}
+	r.Operation("Apply", "Applying a")
+	r.Note("hint")
+	want := []Event{
+		{Type: TypeOperation, Operation: "Apply", Message: "Applying a"},
+		{Type: TypeNote, Message: "hint"},
+	}
+	if diff := cmp.Diff(r.Events(), want); diff != "" {
+		t.Errorf("Events() returned diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestConsoleProgress(t *testing.T) {
+	tests := []struct {
+		verbosity Verbosity
+		want      string
+	}{
+		{Normal, "Checking out base\n[3/8] Applying a\nhint\n"},
+		{Terse, "hint\n"},
+		{Verbose, "Checking out base\n[3/8  25%] Apply: Applying a\nhint\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		c := NewConsole(&b)
+		c.SetVerbosity(tt.verbosity)
+		c.Operation("Checkout", "Checking out base")
+		c.Progress(Progress{Done: 2, Total: 8})
+		c.Operation("Apply", "Applying a")
+		c.Note("hint")
+		if diff := cmp.Diff(b.String(), tt.want); diff != "" {
+			t.Errorf("verbosity %d: reported diff (-got +want)\n%s", tt.verbosity, diff)
+		}
+	}
+}
+
+func TestJSONProgress(t *testing.T) {
+	var b strings.Builder
+	NewJSON(&b).Progress(Progress{Done: 34, Total: 120})
+	if want := `{"type":"progress","progress":{"done":34,"total":120}}` + "\n"; b.String() != want {
+		t.Errorf("Progress() wrote %q, want %q", b.String(), want)
+	}
+	if got, want := (Progress{Done: 34, Total: 120}).String(), "34/120 operations complete"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestStateReporter(t *testing.T) {
+	report := func(rep Reporter) {
+		Checkout(rep, "base")
+		Conflict(rep, "abc123", []string{"a.go", "b/c.go"})
+		Finished(rep, "Apply", errors.New("conflicts"))
+		Finished(rep, "Checkout", nil)
+	}
+	tests := []struct {
+		name string
+		rep  func(*strings.Builder) Reporter
+		want string
+	}{
+		{
+			name: "text",
+			rep:  func(b *strings.Builder) Reporter { return NewConsole(b) },
+			want: "Conflicts applying abc123 in:\n\ta.go\n\tb/c.go\n",
+		},
+		{
+			name: "verbose",
+			rep: func(b *strings.Builder) Reporter {
+				c := NewConsole(b)
+				c.SetVerbosity(Verbose)
+				return c
+			},
+			want: "Checked out base\nConflicts applying abc123 in:\n\ta.go\n\tb/c.go\nApply: failed: conflicts\nCheckout: done\n",
+		},
+		{
+			name: "json",
+			rep:  func(b *strings.Builder) Reporter { return NewJSON(b) },
+			want: `{"type":"checkout","target":"base"}` + "\n" +
+				`{"type":"conflict","commit":"abc123","paths":["a.go","b/c.go"]}` + "\n" +
+				`{"type":"finished","operation":"Apply","error":"conflicts"}` + "\n" +
+				`{"type":"finished","operation":"Checkout"}` + "\n",
+		},
+		{
+			name: "quiet",
+			rep:  func(*strings.Builder) Reporter { return Quiet{} },
+		},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		report(tt.rep(&b))
+		if diff := cmp.Diff(b.String(), tt.want); diff != "" {
+			t.Errorf("%s: reported diff (-got +want)\n%s", tt.name, diff)
+		}
+	}
+	r := &Recorder{}
+	report(r)
+	want := []Event{
+		{Type: TypeCheckout, Target: "base"},
+		{Type: TypeConflict, Commit: "abc123", Paths: []string{"a.go", "b/c.go"}},
+		{Type: TypeFinished, Operation: "Apply", Error: "conflicts"},
+		{Type: TypeFinished, Operation: "Checkout"},
+	}
+	if diff := cmp.Diff(r.Events(), want); diff != "" {
+		t.Errorf("Events() returned diff (-got +want)\n%s", diff)
+	}
+}
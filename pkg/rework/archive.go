@@ -0,0 +1,142 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"fmt"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// NewArchiveCommand returns a command that archives or unarchives the
+// patchset, through an edit of its metadata setting or removing its Archived
+// field. If other patchsets depend on the patchset being archived, cascade
+// must be CascadeEdges, which removes their dependencies on it once the rework
+// finishes, or CascadeDependents, which archives them as well, otherwise an
+// *ErrHasDependents is returned.
+func NewArchiveCommand(name string, archived bool, cascade string) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := patchsets.Map[name]
+	if !ok || p.MetadataCommit() == "" {
+		return nil, fmt.Errorf("patchset %q not found", name)
+	}
+	edited, err := archivedCopy(p, archived)
+	if err != nil {
+		return nil, err
+	}
+	if repo.SameMetadata(p, edited) {
+		return nil, ErrNoChanges
+	}
+	var dependents []*patchset.Patchset
+	if archived {
+		deps, err := dependency.Load(c.repo)
+		if err != nil {
+			return nil, err
+		}
+		dependents = transitiveDependents(patchsets, deps, p)
+	}
+	archive := []*patchset.Patchset{p}
+	if len(dependents) > 0 {
+		switch cascade {
+		case "":
+			e := &ErrHasDependents{Patchset: name}
+			for _, d := range dependents {
+				e.Dependents = append(e.Dependents, d.Name())
+			}
+			return nil, e
+		case CascadeEdges:
+		case CascadeDependents:
+			for _, d := range dependents {
+				if !d.Archived() {
+					archive = append(archive, d)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown cascade mode %q", cascade)
+		}
+	}
+	ops := map[int]queue.Item{}
+	for _, ps := range archive {
+		edited, err := archivedCopy(ps, archived)
+		if err != nil {
+			return nil, err
+		}
+		template, err := c.repo.CreateMetadataTemplate(edited, ps.MetadataCommit())
+		if err != nil {
+			return nil, err
+		}
+		ops[patchsets.Index[ps.Name()]] = queue.Item{Operation: "Edit", Args: []string{ps.Name(), template}}
+	}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if len(dependents) > 0 && cascade == CascadeEdges {
+		if err = c.executor.Enqueue("RemoveDependents", name); err != nil {
+			return nil, err
+		}
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// archivedCopy returns a copy of the patchset, archived or unarchived.
+func archivedCopy(p *patchset.Patchset, archived bool) (*patchset.Patchset, error) {
+	edited, err := repo.ParseEditedMetadata(p, repo.EditableMetadata(p))
+	if err != nil {
+		return nil, err
+	}
+	edited.SetArchived(archived)
+	return edited, nil
+}
+
+// removeDependents removes the dependencies of other patchsets on the named
+// patchset in the reworked branch. Removing them again has no effect.
+func removeDependents(r *repo.Repo, name string) error {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets.Map[name]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		return err
+	}
+	for _, d := range deps.Dependents(p) {
+		if err := deps.Remove(d, p); err != nil {
+			return err
+		}
+	}
+	return dependency.Save(r, deps)
+}
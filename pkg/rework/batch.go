@@ -0,0 +1,134 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// applyInMemory replaces each run of Apply operations in the queue with a
+// single ApplyBatch operation cherry-picking the run in memory, if
+// kilt.inMemoryApply allows. Patches applied with a strategy other than the
+// default are left to be applied one at a time as usual. The progress of the
+// rework still counts the patches one by one.
+func (c *Command) applyInMemory() error {
+	r := c.repo
+	if enabled, err := r.InMemoryApply(); err != nil || !enabled {
+		return err
+	}
+	cache, err := r.PatchsetCache()
+	if err != nil {
+		return err
+	}
+	var items []queue.Item
+	var run []string
+	flush := func() {
+		switch len(run) {
+		case 0:
+		case 1:
+			items = append(items, queue.Item{Operation: "Apply", Args: run})
+		default:
+			items = append(items, queue.Item{Operation: "ApplyBatch", Args: run})
+		}
+		run = nil
+	}
+	for _, item := range c.executor.Queue().Items {
+		batched, err := c.batchable(cache, item)
+		if err != nil {
+			return err
+		}
+		if !batched {
+			flush()
+			items = append(items, item)
+			continue
+		}
+		run = append(run, item.Args[0])
+	}
+	flush()
+	return c.executor.ReplaceQueue(queue.Queue{Items: items})
+}
+
+// batchable reports whether the item applies a patch that can be cherry-picked
+// in memory as part of a batch.
+func (c *Command) batchable(cache repo.PatchsetCache, item queue.Item) (bool, error) {
+	if item.Operation != "Apply" || len(item.Args) != 1 {
+		return false, nil
+	}
+	name, err := c.repo.CommitPatchset(item.Args[0])
+	if err != nil {
+		return false, err
+	}
+	if p, ok := cache.Lookup(name); ok && name != "" {
+		if s, err := p.Strategy(); err != nil || !s.Default() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// applyBatch cherry-picks the patches in memory, checking out only the final
+// tree. The patch that conflicts, along with those following it, is queued
+// again to be applied one at a time. The batch counts as the progress of the
+// patches it applied. If the batch fails, the patches it didn't apply are
+// requeued one at a time as well, with the first of them recorded as the
+// failed operation, so that skipping it doesn't skip the rest of the run.
+func (c *Command) applyBatch(patches []string) error {
+	picked, err := c.repo.CherryPickBatchToHead(patches)
+	if err != nil {
+		if requeueErr := c.requeueApply(patches[picked:], true); requeueErr != nil {
+			return requeueErr
+		}
+		return err
+	}
+	if picked > 0 {
+		c.report("Apply", "Applied %d patches in memory", picked)
+	}
+	if err := c.requeueApply(patches[picked:], false); err != nil {
+		return err
+	}
+	if c.progress != nil {
+		c.progress.Done += picked - 1
+	}
+	return nil
+}
+
+// requeueApply queues Apply operations for the patches ahead of the rest of
+// the queue. If failed is set, the first of them replaces the running batch
+// as the failed operation, and the state is saved right away.
+func (c *Command) requeueApply(patches []string, failed bool) error {
+	var items []queue.Item
+	for _, patch := range patches {
+		items = append(items, queue.Item{Operation: "Apply", Args: []string{patch}})
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	if failed {
+		if err := c.writer.WriteCurrentState(items[0]); err != nil {
+			return err
+		}
+		items = items[1:]
+	}
+	if err := c.executor.ReplaceQueue(queue.Queue{Items: append(items, c.executor.Queue().Items...)}); err != nil {
+		return err
+	}
+	if failed {
+		return c.writer.WriteQueueState(c.executor.Queue())
+	}
+	return nil
+}
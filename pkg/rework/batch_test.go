@@ -0,0 +1,153 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/reporter"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// resetBranch moves the test branch back to the commit with the given id,
+// checking it out.
+func resetBranch(t *testing.T, g *git.Repository, id *git.Oid) {
+	b, err := g.LookupBranch("test", git.BranchLocal)
+	if err != nil {
+		t.Fatalf("LookupBranch(): %v", err)
+	}
+	if _, err = b.SetTarget(id, "reset"); err != nil {
+		t.Fatalf("SetTarget(): %v", err)
+	}
+	if err = g.CheckoutHead(&git.CheckoutOpts{Strategy: git.CheckoutForce}); err != nil {
+		t.Fatalf("CheckoutHead(): %v", err)
+	}
+}
+
+// branchCommits returns the tree and message of each commit of the test
+// branch, following first parents from its tip.
+func branchCommits(t *testing.T, g *git.Repository) []string {
+	b, err := g.LookupBranch("test", git.BranchLocal)
+	if err != nil {
+		t.Fatalf("LookupBranch(): %v", err)
+	}
+	commit, err := g.LookupCommit(b.Target())
+	if err != nil {
+		t.Fatalf("LookupCommit(): %v", err)
+	}
+	var commits []string
+	for commit != nil {
+		commits = append(commits, commit.TreeId().String()+" "+commit.Message())
+		commit = commit.Parent(0)
+	}
+	return commits
+}
+
+// TestApplyBatch checks that reworking a run of patches in memory yields the
+// same commits and tree as applying them one at a time, and that the batch
+// runs as an operation of the patchset queue.
+func TestApplyBatch(t *testing.T) {
+	defer SetDefaultReporter(defaultReporter)
+	g := crashRepo(t, "ApplyBatch")
+	defer os.RemoveAll(g.Workdir())
+	// Rework the floating patch into patchset a first, so that reworking a
+	// applies a run of two patches.
+	runUntilCrash(t, func() (*Command, error) { return NewBeginCommand(FloatingTargets{}) })
+	runUntilCrash(t, func() (*Command, error) { return NewFinishCommand(false) })
+	b, err := g.LookupBranch("test", git.BranchLocal)
+	if err != nil {
+		t.Fatalf("LookupBranch(): %v", err)
+	}
+	start := b.Target()
+	config, err := g.Config()
+	if err != nil {
+		t.Fatalf("Config(): %v", err)
+	}
+	var want []string
+	var wantTree string
+	for _, inMemory := range []bool{false, true} {
+		if err = config.SetBool("kilt.inMemoryApply", inMemory); err != nil {
+			t.Fatalf("SetBool(): %v", err)
+		}
+		resetBranch(t, g, start)
+		rec := &reporter.Recorder{}
+		SetDefaultReporter(rec)
+		runUntilCrash(t, func() (*Command, error) { return NewBeginCommand(AllTargets{}) })
+		runUntilCrash(t, func() (*Command, error) { return NewFinishCommand(false) })
+		batched := false
+		for _, e := range rec.Events() {
+			if e.Type == reporter.TypeFinished && e.Operation == "ApplyBatch" {
+				batched = true
+			}
+		}
+		if batched != inMemory {
+			t.Errorf("inMemoryApply %t: ran ApplyBatch = %t, want %t", inMemory, batched, inMemory)
+		}
+		got, gotTree := branchCommits(t, g), branchTree(t, g)
+		if !inMemory {
+			want, wantTree = got, gotTree
+			continue
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("in-memory commits returned diff (-got +want):\n%s", diff)
+		}
+		if gotTree != wantTree {
+			t.Errorf("in-memory tree = %s, want %s", gotTree, wantTree)
+		}
+	}
+}
+
+// TestFailedApplyBatch checks that a failed batch leaves its patches queued to
+// be applied one at a time, with the first of them as the failed operation, so
+// that skipping it doesn't skip the rest of the run.
+func TestFailedApplyBatch(t *testing.T) {
+	g := crashRepo(t, "FailedApplyBatch")
+	defer os.RemoveAll(g.Workdir())
+	head, err := g.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	missing, patch := "0123456789abcdef0123456789abcdef01234567", head.Target().String()
+	c, err := NewCommand()
+	if err != nil {
+		t.Fatalf("NewCommand(): %v", err)
+	}
+	s := newStateFile(c.repo, patchsetQueueName)
+	c.setWriter(s)
+	c.setReader(s)
+	registerReworkOperations(c)
+	if err := c.executor.Enqueue("ApplyBatch", missing, patch); err != nil {
+		t.Fatalf("Enqueue(): %v", err)
+	}
+	if err := c.Execute(); err == nil {
+		t.Fatal("Execute() succeeded, want failed batch")
+	}
+	current, q, err := readRecoveredState(s)
+	if err != nil {
+		t.Fatalf("readRecoveredState(): %v", err)
+	}
+	if diff := cmp.Diff(current.Items, []queue.Item{{Operation: "Apply", Args: []string{missing}}}); diff != "" {
+		t.Errorf("failed operation returned diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(q.Items, []queue.Item{{Operation: "Apply", Args: []string{patch}}}); diff != "" {
+		t.Errorf("queue returned diff (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"fmt"
+
+	"github.com/google/kilt/pkg/queue"
+)
+
+// NewBumpCommand returns a command that bumps the versions of the patchsets by
+// reworking them, along with the patchsets that depend on them, and finishes
+// the rework.
+func NewBumpCommand(patchsets []string) (*Command, error) {
+	var targets []TargetSelector
+	for _, p := range patchsets {
+		targets = append(targets, PatchsetTarget{Name: p})
+	}
+	c, err := NewBeginCommand(targets...)
+	if err != nil {
+		return nil, err
+	}
+	bump := map[string]bool{}
+	for _, p := range patchsets {
+		bump[p] = true
+	}
+	var q queue.Queue
+	for _, item := range c.executor.Queue().Items {
+		if item.Operation == "Rework" && len(item.Args) > 0 && bump[item.Args[0]] {
+			delete(bump, item.Args[0])
+			item = queue.Item{Operation: item.Operation, Args: append(append([]string{}, item.Args...), bumpArg)}
+		}
+		q.Items = append(q.Items, item)
+	}
+	for _, p := range patchsets {
+		if bump[p] {
+			return nil, fmt.Errorf("patchset %q not found", p)
+		}
+	}
+	if err = c.executor.ReplaceQueue(q); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
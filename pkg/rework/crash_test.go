@@ -0,0 +1,242 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/internal/failpoint"
+	"github.com/google/kilt/pkg/internal/testfiles"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+func TestRecoverQueue(t *testing.T) {
+	a := queue.Item{Operation: "Apply", Args: []string{"a"}}
+	b := queue.Item{Operation: "Apply", Args: []string{"b"}}
+	r := queue.Item{Operation: "Rework", Args: []string{"a"}}
+	q := func(items ...queue.Item) queue.Queue { return queue.Queue{Items: items} }
+	tests := []struct {
+		name                   string
+		current, done, in      queue.Queue
+		wantCurrent, wantQueue queue.Queue
+	}{
+		{"clean", q(), q(), q(a, b), q(), q(a, b)},
+		{"failed", q(r), q(), q(b), q(r), q(b)},
+		{"interrupted", q(r), q(), q(r, b), q(), q(r, b)},
+		{"completed", q(), q(a), q(a, b), q(), q(b)},
+		{"completed resumable", q(r), q(r), q(r, b), q(), q(b)},
+		{"saved", q(), q(a), q(b), q(), q(b)},
+	}
+	for _, tt := range tests {
+		current, got := recoverQueue(tt.current, tt.done, tt.in)
+		if diff := cmp.Diff(current, tt.wantCurrent); diff != "" {
+			t.Errorf("%s: recoverQueue() current returned diff (-got +want):\n%s", tt.name, diff)
+		}
+		if diff := cmp.Diff(got, tt.wantQueue); diff != "" {
+			t.Errorf("%s: recoverQueue() queue returned diff (-got +want):\n%s", tt.name, diff)
+		}
+	}
+}
+
+// crashRepo sets up a kilt branch with patchsets a and b, and a patch of a
+// floating after b.
+func crashRepo(t *testing.T, name string) *git.Repository {
+	path, err := testfiles.TempDir(name)
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	os.Chdir(path)
+	g, err := git.InitRepository(path, false)
+	if err != nil {
+		t.Fatalf("InitRepository(): %v", err)
+	}
+	config, err := g.Config()
+	if err != nil {
+		t.Fatalf("Config(): %v", err)
+	}
+	config.SetString("user.name", "Test Data")
+	config.SetString("user.email", "nobody@google.com")
+	commitFile(t, g, "base", "Initial commit.")
+	head, err := g.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	commit, err := g.LookupCommit(head.Target())
+	if err != nil {
+		t.Fatalf("LookupCommit(): %v", err)
+	}
+	branch, err := g.CreateBranch("test", commit, false)
+	if err != nil {
+		t.Fatalf("CreateBranch(): %v", err)
+	}
+	if err = g.SetHead(branch.Reference.Name()); err != nil {
+		t.Fatalf("SetHead(): %v", err)
+	}
+	r, err := repo.Init("HEAD", false)
+	if err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	for _, ps := range []string{"a", "b"} {
+		if err = r.AddPatchset(patchset.New(ps)); err != nil {
+			t.Fatalf("AddPatchset(%q): %v", ps, err)
+		}
+		commitFile(t, g, ps, ps)
+	}
+	commitFile(t, g, "a2", "a")
+	return g
+}
+
+func commitFile(t *testing.T, g *git.Repository, file, patchset string) {
+	if err := ioutil.WriteFile(filepath.Join(g.Workdir(), file), []byte(file+"\n"), 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	index, err := g.Index()
+	if err != nil {
+		t.Fatalf("Index(): %v", err)
+	}
+	if err = index.AddByPath(file); err != nil {
+		t.Fatalf("AddByPath(): %v", err)
+	}
+	if err = index.Write(); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	oid, err := index.WriteTree()
+	if err != nil {
+		t.Fatalf("WriteTree(): %v", err)
+	}
+	tree, err := g.LookupTree(oid)
+	if err != nil {
+		t.Fatalf("LookupTree(): %v", err)
+	}
+	sig, err := g.DefaultSignature()
+	if err != nil {
+		t.Fatalf("DefaultSignature(): %v", err)
+	}
+	var parents []*git.Commit
+	if head, err := g.Head(); err == nil {
+		parent, err := g.LookupCommit(head.Target())
+		if err != nil {
+			t.Fatalf("LookupCommit(): %v", err)
+		}
+		parents = append(parents, parent)
+	}
+	message := fmt.Sprintf("Add %s\n\nPatchset-Name: %s\n", file, patchset)
+	if _, err = g.CreateCommit("HEAD", sig, sig, message, tree, parents...); err != nil {
+		t.Fatalf("CreateCommit(): %v", err)
+	}
+}
+
+func branchTree(t *testing.T, g *git.Repository) string {
+	b, err := g.LookupBranch("test", git.BranchLocal)
+	if err != nil {
+		t.Fatalf("LookupBranch(): %v", err)
+	}
+	commit, err := g.LookupCommit(b.Target())
+	if err != nil {
+		t.Fatalf("LookupCommit(): %v", err)
+	}
+	return commit.TreeId().String()
+}
+
+// runUntilCrash runs the command, returning whether a simulated crash
+// interrupted it.
+func runUntilCrash(t *testing.T, newCommand func() (*Command, error)) (crashed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(simulatedCrash); !ok {
+				panic(r)
+			}
+			crashed = true
+		}
+	}()
+	c, err := newCommand()
+	if err != nil {
+		t.Fatalf("new command: %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		t.Fatalf("ExecuteAll(): %v", err)
+	}
+	if err = c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	return false
+}
+
+type simulatedCrash string
+
+// TestCrashRecovery crashes a rework of floating patches at every failpoint
+// in turn, and checks that continuing it yields the same result as an
+// uninterrupted rework.
+func TestCrashRecovery(t *testing.T) {
+	crash := failpoint.Crash
+	defer func() { failpoint.Crash = crash }()
+	failpoint.Crash = func(name string) { panic(simulatedCrash(name)) }
+	begin := func() (*Command, error) { return NewBeginCommand(FloatingTargets{}) }
+	finish := func() (*Command, error) { return NewFinishCommand(false) }
+
+	g := crashRepo(t, "CrashRecoveryReference")
+	runUntilCrash(t, begin)
+	runUntilCrash(t, finish)
+	want := branchTree(t, g)
+	os.RemoveAll(g.Workdir())
+
+	for _, point := range []string{"rework-before-operation", "rework-before-save", "rework-after-save"} {
+		for hit := 1; ; hit++ {
+			name := fmt.Sprintf("%s@%d", point, hit)
+			g := crashRepo(t, "CrashRecovery")
+			failpoint.Disable()
+			failpoint.Enable(name)
+			crashed := runUntilCrash(t, begin)
+			failpoint.Disable()
+			if crashed {
+				r, err := repo.Open()
+				if err != nil {
+					t.Fatalf("%s: Open(): %v", name, err)
+				}
+				if inProgress, err := r.ReworkInProgress(); err != nil {
+					t.Fatalf("%s: ReworkInProgress(): %v", name, err)
+				} else if inProgress {
+					runUntilCrash(t, NewContinueCommand)
+				} else {
+					runUntilCrash(t, begin)
+				}
+			}
+			runUntilCrash(t, finish)
+			if got := branchTree(t, g); got != want {
+				t.Errorf("%s: got tree %s, want %s", name, got, want)
+			}
+			if problems, err := VerifyState(); err != nil {
+				t.Errorf("%s: VerifyState(): %v", name, err)
+			} else if len(problems) > 0 {
+				t.Errorf("%s: VerifyState() = %q, want no problems", name, problems)
+			}
+			os.RemoveAll(g.Workdir())
+			if !crashed {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// dependencyUpdate is a change a rework makes to the dependency graph.
+type dependencyUpdate struct {
+	params []queue.Param
+	// apply applies the update to the graph of the finished branch. Applying
+	// it again has no effect.
+	apply func(c *Command, r *repo.Repo, args []string) error
+}
+
+// dependencyUpdates are the changes to the dependency graph a rework can make,
+// by the name of the operation recording them. The graph has to match the
+// original branch until the rework finishes, so the operations only record
+// the updates, which finishRework applies once the branch has moved.
+var dependencyUpdates = map[string]dependencyUpdate{
+	"RenameDependencies": {
+		params: []queue.Param{{Name: "renames", Type: queue.PatchsetName, Variadic: true}},
+		apply: func(c *Command, r *repo.Repo, args []string) error {
+			c.report("RenameDependencies", "Renaming %d patchsets in the dependency graph", len(args)/2)
+			return renameDependencies(r, args)
+		},
+	},
+	"AddDependencies": {
+		params: []queue.Param{patchsetParam, {Name: "dependencies", Type: queue.PatchsetName, Variadic: true}},
+		apply: func(c *Command, r *repo.Repo, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no patchset specified")
+			}
+			c.report("AddDependencies", "Adding dependencies of patchset %s", args[0])
+			return addDependencies(r, args[0], args[1:])
+		},
+	},
+	"RemoveDependents": {
+		params: []queue.Param{patchsetParam},
+		apply: func(c *Command, r *repo.Repo, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no patchset specified")
+			}
+			c.report("RemoveDependents", "Removing dependencies on patchset %s", args[0])
+			return removeDependents(r, args[0])
+		},
+	},
+	"DeleteDependencies": {
+		params: []queue.Param{{Name: "patchsets", Type: queue.PatchsetName, Variadic: true}},
+		apply: func(c *Command, r *repo.Repo, args []string) error {
+			c.report("DeleteDependencies", "Removing %d patchsets from the dependency graph", len(args))
+			return dependency.Delete(r, args)
+		},
+	},
+	"SplitDependencies": {
+		params: []queue.Param{patchsetParam, {Name: "new name", Type: queue.PatchsetName}, {Name: "dependents", Type: queue.String}},
+		apply: func(c *Command, r *repo.Repo, args []string) error {
+			if len(args) < 3 {
+				return errors.New("patchset, new name and dependents mode required")
+			}
+			c.report("SplitDependencies", "Adding dependencies of patchset %s", args[1])
+			return splitDependencies(r, args[0], args[1], args[2])
+		},
+	},
+}
+
+// registerDependencyOperations registers the operations recording the
+// dependency updates of the rework.
+func registerDependencyOperations(c *Command) {
+	for name, u := range dependencyUpdates {
+		name := name
+		c.executor.Register(queue.Operation{
+			Name:   name,
+			Params: u.params,
+			Execute: func(args []string) error {
+				return recordDependencyUpdate(c.repo, queue.Item{Operation: name, Args: args})
+			},
+		})
+	}
+}
+
+// recordDependencyUpdate adds the item to the dependency updates applied when
+// the rework finishes.
+func recordDependencyUpdate(r *repo.Repo, item queue.Item) error {
+	s := newStateFile(r, dependencyQueueName)
+	q, err := s.ReadState()
+	if err != nil {
+		return err
+	}
+	q.Items = append(q.Items, item)
+	return s.WriteQueueState(q)
+}
+
+// applyDependencyUpdates applies the dependency updates recorded during the
+// rework to the graph of the reworked branch. Each update is dropped from the
+// record once applied, and the rework state is kept until all of them are, so
+// that a failed update can be fixed and the rework continued.
+func (c *Command) applyDependencyUpdates() error {
+	s := newStateFile(c.repo, dependencyQueueName)
+	q, err := s.ReadState()
+	if err != nil || len(q.Items) == 0 {
+		return err
+	}
+	// Reopen the repo to walk the reworked branch.
+	r, err := c.repo.Reopen()
+	if err != nil {
+		return err
+	}
+	for len(q.Items) > 0 {
+		item := q.Items[0]
+		u, ok := dependencyUpdates[item.Operation]
+		if !ok {
+			return fmt.Errorf("unknown dependency update %q", item.Operation)
+		}
+		if err := u.apply(c, r, item.Args); err != nil {
+			return fmt.Errorf("failed to update dependencies: %w", err)
+		}
+		q.Items = q.Items[1:]
+		if err := s.WriteQueueState(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
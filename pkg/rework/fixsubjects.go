@@ -0,0 +1,148 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// NewFixSubjectsCommand returns a command that adds the subject prefix of
+// each of the named patchsets, or of all patchsets if none are named, to the
+// subjects of its patches that lack it, through a message-only rework.
+// Patchsets without a subject prefix, or whose patches all have it, are left
+// unchanged.
+func NewFixSubjectsCommand(names []string) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	selected := map[string]bool{}
+	for _, name := range names {
+		if _, ok := patchsets.Map[name]; !ok {
+			return nil, fmt.Errorf("patchset %q not found", name)
+		}
+		selected[name] = true
+	}
+	ops := map[int]queue.Item{}
+	for i, ps := range patchsets.Slice {
+		if (len(names) > 0 && !selected[ps.Name()]) || ps.Name() == repo.QuarantinePatchset {
+			continue
+		}
+		prefix, err := c.repo.SubjectPrefix(ps)
+		if err != nil {
+			return nil, err
+		}
+		if prefix == "" {
+			continue
+		}
+		fix, err := c.unprefixedPatches(ps.Patches(), prefix)
+		if err != nil {
+			return nil, err
+		}
+		floating, err := c.unprefixedPatches(ps.FloatingPatches(), prefix)
+		if err != nil {
+			return nil, err
+		}
+		if len(floating) > 0 {
+			return nil, fmt.Errorf("patchset %q has floating patches and must be reworked first", ps.Name())
+		}
+		if len(fix) > 0 {
+			ops[i] = queue.Item{Operation: "FixSubjects", Args: []string{ps.Name(), url.QueryEscape(prefix)}}
+		}
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("all subjects have their prefix")
+	}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// unprefixedPatches returns the patches whose subjects lack the prefix.
+func (c *Command) unprefixedPatches(patches []string, prefix string) ([]string, error) {
+	var unprefixed []string
+	for _, patch := range patches {
+		info, err := c.repo.CommitInfo(patch)
+		if err != nil {
+			return nil, err
+		}
+		if !repo.HasSubjectPrefix(info.Summary, prefix) {
+			unprefixed = append(unprefixed, patch)
+		}
+	}
+	return unprefixed, nil
+}
+
+// fixSubjectsPatchset applies the patchset, adding the query escaped prefix
+// to the subjects of its patches that lack it.
+func (c *Command) fixSubjectsPatchset(name, escapedPrefix string) error {
+	prefix, err := url.QueryUnescape(escapedPrefix)
+	if err != nil {
+		return fmt.Errorf("invalid subject prefix %q: %w", escapedPrefix, err)
+	}
+	patchsets, err := c.repo.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets[name]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	fix, err := c.unprefixedPatches(p.Patches(), prefix)
+	if err != nil {
+		return err
+	}
+	unprefixed := map[string]bool{}
+	for _, patch := range fix {
+		unprefixed[patch] = true
+	}
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		if p.MetadataCommit() != "" {
+			if err := e.Enqueue("Apply", p.MetadataCommit()); err != nil {
+				return err
+			}
+		}
+		for _, patch := range p.Patches() {
+			if unprefixed[patch] {
+				if err := e.Enqueue("SetSubjectPrefix", patch, escapedPrefix); err != nil {
+					return err
+				}
+			} else {
+				if err := e.Enqueue("Apply", patch); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/kilt/pkg/internal/atomicfile"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// headOperations are the outer rework operations that only move the rework
+// head, and can be rolled back by moving it back to where it was before they
+// ran.
+var headOperations = map[string]bool{
+	"Rework":       true,
+	"Apply":        true,
+	"Delete":       true,
+	"Release":      true,
+	"Gather":       true,
+	"Trailer":      true,
+	"FixSubjects":  true,
+	"Rename":       true,
+	"Edit":         true,
+	"Adopt":        true,
+	"Quarantine":   true,
+	"Checkout":     true,
+	"CheckoutBase": true,
+	"CheckoutRev":  true,
+	"Promote":      true,
+	"Test":         true,
+}
+
+// historyEntry is an executed operation of the rework, along with the rework
+// head before it ran.
+type historyEntry struct {
+	Operation string   `json:"operation"`
+	Args      []string `json:"args,omitempty"`
+	Head      string   `json:"head,omitempty"`
+}
+
+// ReadHistory reads the executed operations of the queue.
+func (s *stateFile) ReadHistory() ([]historyEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.path, s.name+"-history"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var history []historyEntry
+	if err := json.Unmarshal(b, &history); err != nil {
+		return nil, s.readError(s.name+"-history", fmt.Errorf("%w: %v", queue.ErrCorrupted, err))
+	}
+	return history, nil
+}
+
+// WriteHistory writes the executed operations of the queue.
+func (s *stateFile) WriteHistory(history []historyEntry) error {
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return os.RemoveAll(filepath.Join(s.path, s.name+"-history"))
+	}
+	b, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(s.path, 0777)
+	return atomicfile.WriteFile(filepath.Join(s.path, s.name+"-history"), append(b, '\n'), 0666)
+}
+
+// ReadHead reads the rework head from before the running operation, or an
+// empty string if it wasn't recorded.
+func (s *stateFile) ReadHead() (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.path, s.name+"-head"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(b)), err
+}
+
+// WriteHead records the rework head from before the running operation.
+func (s *stateFile) WriteHead(head string) error {
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
+	os.MkdirAll(s.path, 0777)
+	return atomicfile.WriteFile(filepath.Join(s.path, s.name+"-head"), []byte(head+"\n"), 0666)
+}
+
+// clearHistory removes the executed operations and the recorded head.
+func (s *stateFile) clearHistory() error {
+	if err := os.RemoveAll(filepath.Join(s.path, s.name+"-history")); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.path, s.name+"-head"))
+}
+
+// historyState returns the state file the history of the command is kept in.
+// Only the outer queue of a rework keeps a history.
+func (c *Command) historyState() (*stateFile, bool) {
+	s, ok := c.writer.(*stateFile)
+	if !ok || s == nil || s.name != reworkQueueName {
+		return nil, false
+	}
+	return s, true
+}
+
+// loadHistory loads the executed operations of the rework into the command.
+func (c *Command) loadHistory() error {
+	s, ok := c.historyState()
+	if !ok {
+		return nil
+	}
+	history, err := s.ReadHistory()
+	if err != nil {
+		return err
+	}
+	var items []queue.Item
+	c.heads = nil
+	for _, h := range history {
+		items = append(items, queue.Item{Operation: h.Operation, Args: h.Args})
+		c.heads = append(c.heads, h.Head)
+	}
+	c.executor.LoadHistory(items)
+	return nil
+}
+
+// recordHead records the rework head before the operation runs, unless the
+// operation is being resumed, in which case the head it started from was
+// recorded already.
+func (c *Command) recordHead(op queue.Item) error {
+	s, ok := c.historyState()
+	if !ok || !c.executor.Rollbackable(op.Operation) {
+		return nil
+	}
+	if c.resuming {
+		c.resuming = false
+		head, err := s.ReadHead()
+		if err != nil || head != "" {
+			c.pendingHead = head
+			return err
+		}
+	}
+	head, err := c.repo.ResolveCommit("HEAD")
+	if err != nil {
+		return err
+	}
+	c.pendingHead = head
+	return s.WriteHead(head)
+}
+
+// saveHistory adds the executed operation to the saved history, along with the
+// rework head before it ran. The history is removed along with the queue once
+// the queue is empty.
+func (c *Command) saveHistory(op queue.Item) error {
+	s, ok := c.historyState()
+	if !ok {
+		return nil
+	}
+	if c.executor.Peek() == nil {
+		return s.clearHistory()
+	}
+	head := ""
+	if c.executor.Rollbackable(op.Operation) {
+		head = c.pendingHead
+	}
+	c.heads = append(c.heads, head)
+	return c.writeHistory(s)
+}
+
+func (c *Command) writeHistory(s *stateFile) error {
+	var history []historyEntry
+	for i, item := range c.executor.History() {
+		if i >= len(c.heads) {
+			break
+		}
+		history = append(history, historyEntry{Operation: item.Operation, Args: item.Args, Head: c.heads[i]})
+	}
+	return s.WriteHistory(history)
+}
+
+// rollbackHead moves the rework head back to where it was before the last
+// executed operation ran, discarding any changes left in the work tree.
+func (c *Command) rollbackHead(_ []string) error {
+	if len(c.heads) == 0 || c.heads[len(c.heads)-1] == "" {
+		return errors.New("rework head before the operation unknown")
+	}
+	head := c.heads[len(c.heads)-1]
+	if err := c.repo.ResetToHead(); err != nil {
+		return err
+	}
+	if err := c.repo.CheckoutRev(head); err != nil {
+		return err
+	}
+	c.heads = c.heads[:len(c.heads)-1]
+	return nil
+}
+
+// NewUndoLastCommand returns a command that rolls back the last n operations
+// of the rework in progress, moving the rework head back to where it was
+// before them and queueing them to run again, so that a mistaken continue can
+// be undone. A failed operation, or one interrupted partway through its
+// patchset, is rolled back first, without being counted. The rework is
+// resumed with NewContinueCommand.
+func NewUndoLastCommand(n int) (*Command, error) {
+	c, err := NewCommand()
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("no rework in progress")
+	}
+	c.useOuterQueue()
+	s, ok := c.historyState()
+	if !ok {
+		return nil, errors.New("only reworks can be rolled back")
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of operations %d", n)
+	}
+	current, q, err := readRecoveredState(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.loadHistory(); err != nil {
+		return nil, err
+	}
+	if len(current.Items) > 0 {
+		head, err := s.ReadHead()
+		if err != nil {
+			return nil, err
+		}
+		if head == "" {
+			return nil, fmt.Errorf("rework head before %s unknown", describeItem(current.Items[0]))
+		}
+		if err := c.repo.ResetToHead(); err != nil {
+			return nil, fmt.Errorf("failed to reset work tree: %w", err)
+		}
+		if err := c.repo.CheckoutRev(head); err != nil {
+			return nil, err
+		}
+		c.report("Rollback", "Rolled back %s", describeItem(current.Items[0]))
+	}
+	nested := newStateFile(c.repo, patchsetQueueName)
+	for _, clear := range []func() error{nested.ClearCurrentState, nested.ClearDoneState, nested.ClearQueueState, s.ClearCurrentState, s.ClearDoneState} {
+		if err := clear(); err != nil {
+			return nil, err
+		}
+	}
+	c.executor.LoadQueue(current)
+	c.executor.LoadQueue(q)
+	history := c.executor.History()
+	rolled, err := c.executor.RollbackLast(n)
+	for i := len(history) - 1; i >= len(history)-rolled; i-- {
+		c.report("Rollback", "Rolled back %s", describeItem(history[i]))
+	}
+	if werr := c.writeHistory(s); werr != nil {
+		return nil, werr
+	}
+	if err != nil && rolled == 0 && len(current.Items) == 0 {
+		return nil, err
+	} else if err != nil {
+		c.reporter.Note(fmt.Sprintf("Rolled back %d of %d operations: %v", rolled, n, err))
+	}
+	return c, nil
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// Plan is the queue of operations a rework or build will run, computed from
+// the patchsets and their dependencies without touching the repo, so that it
+// can be inspected, saved or tested before it is executed with
+// NewPlanCommand.
+type Plan struct {
+	// Queue is the name of the queue the operations run in, which determines
+	// the operations available to them.
+	Queue string
+	// Worktree, if set, is the path of a new linked work tree the plan runs
+	// in, checked out at Base.
+	Worktree string `json:",omitempty"`
+	Base     string `json:",omitempty"`
+	Items    []queue.Item
+}
+
+// PlanInput is the state of the repo that plans are computed from.
+type PlanInput struct {
+	Patchsets    repo.PatchsetCache
+	Dependencies *dependency.StructGraph
+	// PatchIndex returns the position of the patch of the patchset with the
+	// given subject, to resolve patch dependencies.
+	PatchIndex func(ps *patchset.Patchset, subject string) (int, error)
+	// TestCommands holds the test command of each patchset that has one, by
+	// name. A Test operation follows each such patchset once it is reworked
+	// or applied.
+	TestCommands map[string]string
+}
+
+// LoadPlanInput reads the patchsets and dependencies of the repo.
+func LoadPlanInput(r *repo.Repo) (PlanInput, error) {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return PlanInput{}, err
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		return PlanInput{}, err
+	}
+	tests, err := testCommands(r, patchsets.Slice)
+	if err != nil {
+		return PlanInput{}, err
+	}
+	return PlanInput{
+		Patchsets:    patchsets,
+		Dependencies: deps,
+		PatchIndex: func(ps *patchset.Patchset, subject string) (int, error) {
+			return patchIndex(r, ps, subject)
+		},
+		TestCommands: tests,
+	}, nil
+}
+
+// testCommands returns the test command of each of the patchsets that has
+// one, by name.
+func testCommands(r *repo.Repo, patchsets []*patchset.Patchset) (map[string]string, error) {
+	tests := map[string]string{}
+	for _, ps := range patchsets {
+		command, err := r.TestCommand(ps)
+		if err != nil {
+			return nil, err
+		}
+		if command != "" {
+			tests[ps.Name()] = command
+		}
+	}
+	return tests, nil
+}
+
+func (p *Plan) add(op string, args ...string) {
+	p.Items = append(p.Items, queue.Item{Operation: op, Args: args})
+}
+
+// addTest adds the test of the patchset, if it has a test command.
+func (p *Plan) addTest(in PlanInput, ps *patchset.Patchset) {
+	if _, ok := in.TestCommands[ps.Name()]; ok {
+		p.add("Test", ps.Name())
+	}
+}
+
+// PlanRework returns the plan of a rework of the selected patchsets and those
+// depending on them. The patchsets preceding the first of them are kept as
+// they are, and those following it are reapplied. If begin is set, the plan
+// begins a new rework, rather than adding to one that is in progress.
+func PlanRework(in PlanInput, begin bool, selectors ...TargetSelector) Plan {
+	p := Plan{Queue: reworkQueueName}
+	if begin {
+		p.add("Begin")
+	}
+	revDeps := revDepPatchsets(in, selectors)
+	first := true
+	var previous *patchset.Patchset
+	i := 0
+	for _, ps := range in.Patchsets.Slice {
+		if i < len(revDeps) && revDeps[i].SameAs(ps) {
+			if first {
+				if previous != nil {
+					p.add("Checkout", previous.Name())
+				} else {
+					p.add("CheckoutBase")
+				}
+				first = false
+			}
+			p.add("Rework", ps.Name())
+			p.addTest(in, ps)
+			i++
+		} else {
+			if !first {
+				p.add("Apply", ps.Name())
+				p.addTest(in, ps)
+			} else {
+				previous = ps
+			}
+		}
+	}
+	p.add("UpdateHead")
+	return p
+}
+
+// PlanBuild returns the plan of a build of the selected patchsets and those
+// they depend on, configured by opts. opts.UpTo must be a full commit id.
+func PlanBuild(in PlanInput, opts BuildOptions, selectors ...TargetSelector) (Plan, error) {
+	switch opts.Order {
+	case "":
+		opts.Order = OrderTopological
+	case OrderTopological, OrderBranch:
+	default:
+		return Plan{}, fmt.Errorf("unknown build order %q", opts.Order)
+	}
+	p := Plan{Queue: buildQueueName, Worktree: opts.Worktree}
+	begin, finish := "Begin", "Finish"
+	if opts.Worktree != "" {
+		p.Base = opts.Base
+		begin, finish = "BeginWorktree", "FinishWorktree"
+	}
+	p.add(begin)
+	selected, prefixes, err := dependentPatchsets(in, selectors)
+	if err != nil {
+		return Plan{}, err
+	}
+	if opts.Order == OrderTopological {
+		if selected, err = topologicalOrder(in.Dependencies, selected); err != nil {
+			return Plan{}, err
+		}
+	}
+	if opts.UpTo != "" {
+		if err := checkUpTo(selected, opts.UpTo); err != nil {
+			return Plan{}, err
+		}
+	}
+	p.add("Checkout", opts.Base)
+	for i, ps := range selected {
+		args := []string{ps.Name()}
+		if opts.Squash {
+			args = append(args, squashKind)
+		}
+		if opts.UpTo != "" && i == len(selected)-1 {
+			args = append(args, upToPrefix+opts.UpTo)
+		} else if prefix, ok := prefixes[ps.Name()]; ok {
+			args = append(args, upToPrefix+prefix)
+		}
+		p.add("Apply", args...)
+		p.addTest(in, ps)
+	}
+	p.add("UpdateHead")
+	p.add(finish, opts.Base)
+	return p, nil
+}
+
+// NewPlanCommand returns a command that executes the plan.
+func NewPlanCommand(p Plan) (*Command, error) {
+	c, err := NewCommand()
+	if err != nil {
+		return nil, err
+	}
+	return c.usePlan(p)
+}
+
+// usePlan switches the command to the queue of the plan, and enqueues its
+// operations.
+func (c *Command) usePlan(p Plan) (*Command, error) {
+	switch p.Queue {
+	case reworkQueueName, buildQueueName:
+	default:
+		return nil, fmt.Errorf("unknown plan queue %q", p.Queue)
+	}
+	if p.Worktree != "" {
+		if err := c.useWorktree(p.Worktree, p.Base); err != nil {
+			return nil, err
+		}
+	}
+	s := newStateFile(c.repo, p.Queue)
+	c.setWriter(s)
+	c.setReader(s)
+	if p.Queue == buildQueueName {
+		registerBuildOperations(c)
+	} else {
+		registerOperations(c)
+	}
+	for _, item := range p.Items {
+		if err := c.executor.Enqueue(item.Operation, item.Args...); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// testOperation returns the Test operation, which runs the test command of a
+// patchset once it has been reworked or applied. The command is looked up
+// again when the operation runs, so that a fixed command is used when the
+// rework is continued.
+func testOperation(c *Command) queue.Operation {
+	return queue.Operation{
+		Name:   "Test",
+		Params: []queue.Param{patchsetParam},
+		Execute: func(args []string) error {
+			if len(args) == 0 {
+				return errors.New("no patchset specified")
+			}
+			patchsets, err := c.repo.PatchsetMap()
+			if err != nil {
+				return err
+			}
+			ps, ok := patchsets[args[0]]
+			if !ok {
+				return fmt.Errorf("patchset %q not found", args[0])
+			}
+			command, err := c.repo.TestCommand(ps)
+			if err != nil || command == "" {
+				return err
+			}
+			c.report("Test", "Testing patchset %s", args[0])
+			return c.repo.RunTestCommand(command, ps)
+		},
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// planInput returns the input of plans for patchsets a, b, c and d, where c
+// depends on a, and each patchset has two patches named after it.
+func planInput(t *testing.T) PlanInput {
+	t.Helper()
+	cache := repo.PatchsetCache{Map: map[string]*patchset.Patchset{}, Index: map[string]int{}}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		ps := patchset.New(name)
+		ps.AddPatch(name + "1")
+		ps.AddPatch(name + "2")
+		cache.Slice = append(cache.Slice, ps)
+		cache.Map[name] = ps
+		cache.Index[name] = i
+	}
+	deps := dependency.NewStruct(cache)
+	if err := deps.Add(cache.Map["c"], cache.Map["a"]); err != nil {
+		t.Fatalf("Add(): %v", err)
+	}
+	return PlanInput{
+		Patchsets:    cache,
+		Dependencies: deps,
+		PatchIndex: func(ps *patchset.Patchset, subject string) (int, error) {
+			return 0, nil
+		},
+	}
+}
+
+func TestPlanRework(t *testing.T) {
+	item := func(op string, args ...string) queue.Item { return queue.Item{Operation: op, Args: args} }
+	in := planInput(t)
+	tests := []struct {
+		desc      string
+		begin     bool
+		selectors []TargetSelector
+		tests     map[string]string
+		want      []queue.Item
+	}{
+		{
+			desc:      "Dependents reworked",
+			begin:     true,
+			selectors: []TargetSelector{PatchsetTarget{Name: "a"}},
+			want: []queue.Item{
+				item("Begin"),
+				item("CheckoutBase"),
+				item("Rework", "a"),
+				item("Apply", "b"),
+				item("Rework", "c"),
+				item("Apply", "d"),
+				item("UpdateHead"),
+			},
+		},
+		{
+			desc:      "Preceding patchsets kept",
+			selectors: []TargetSelector{PatchsetTarget{Name: "d"}},
+			want: []queue.Item{
+				item("Checkout", "c"),
+				item("Rework", "d"),
+				item("UpdateHead"),
+			},
+		},
+		{
+			desc:      "Patchsets with test commands tested",
+			selectors: []TargetSelector{PatchsetTarget{Name: "b"}},
+			tests:     map[string]string{"b": "make", "d": "make check"},
+			want: []queue.Item{
+				item("Checkout", "a"),
+				item("Rework", "b"),
+				item("Test", "b"),
+				item("Apply", "c"),
+				item("Apply", "d"),
+				item("Test", "d"),
+				item("UpdateHead"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		in.TestCommands = tt.tests
+		p := PlanRework(in, tt.begin, tt.selectors...)
+		if p.Queue != reworkQueueName {
+			t.Errorf("%s: PlanRework() queue = %q, want %q", tt.desc, p.Queue, reworkQueueName)
+		}
+		if diff := cmp.Diff(p.Items, tt.want); diff != "" {
+			t.Errorf("%s: PlanRework() returned diff (-got +want):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestPlanBuild(t *testing.T) {
+	item := func(op string, args ...string) queue.Item { return queue.Item{Operation: op, Args: args} }
+	in := planInput(t)
+	p, err := PlanBuild(in, BuildOptions{Base: "out", UpTo: "c1"}, PatchsetTarget{Name: "c"})
+	if err != nil {
+		t.Fatalf("PlanBuild(): %v", err)
+	}
+	want := Plan{
+		Queue: buildQueueName,
+		Items: []queue.Item{
+			item("Begin"),
+			item("Checkout", "out"),
+			item("Apply", "a"),
+			item("Apply", "c", upToPrefix+"c1"),
+			item("UpdateHead"),
+			item("Finish", "out"),
+		},
+	}
+	if diff := cmp.Diff(p, want); diff != "" {
+		t.Errorf("PlanBuild() returned diff (-got +want):\n%s", diff)
+	}
+	if _, err := PlanBuild(in, BuildOptions{UpTo: "a1"}, PatchsetTarget{Name: "c"}); err == nil {
+		t.Error("PlanBuild() with a patch outside the last patchset returned nil error")
+	}
+	if _, err := PlanBuild(in, BuildOptions{Order: "random"}); err == nil {
+		t.Error("PlanBuild() with an unknown order returned nil error")
+	}
+}
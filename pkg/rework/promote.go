@@ -0,0 +1,296 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// Promotion describes a version of a patchset of another kilt branch being
+// promoted onto the kilt branch.
+type Promotion struct {
+	Patchset string
+	UUID     string
+	Version  patchset.Version
+	// From is the kilt branch the patchset is promoted from.
+	From string
+	// Metadata is the metadata commit of the version on From, and Patches
+	// its patches, in order.
+	Metadata string
+	Patches  []string
+	// Dependencies are the names of the patchsets it depends on.
+	Dependencies []string
+}
+
+// LoadPromotion reads the promotion of the named patchset from the kilt branch
+// from onto the kilt branch of r. Unless version is empty, that version of the
+// patchset is promoted, as recorded by its version ref, otherwise its current
+// version. The dependencies promoted are those the patchset currently has on
+// from.
+func LoadPromotion(r *repo.Repo, from, name, version string) (Promotion, error) {
+	o, err := r.OpenBranch(from)
+	if err != nil {
+		return Promotion{}, err
+	}
+	patchsets, err := o.PatchsetCache()
+	if err != nil {
+		return Promotion{}, err
+	}
+	current, ok := patchsets.Lookup(name)
+	if !ok || current.MetadataCommit() == "" {
+		return Promotion{}, fmt.Errorf("patchset %q not found on %s", name, from)
+	}
+	ps := current
+	if version != "" {
+		v, err := patchset.ParseVersion(version)
+		if err != nil {
+			return Promotion{}, err
+		}
+		at, err := o.OpenAt(o.PatchsetVersionRef(current.Name(), v), "")
+		if err != nil {
+			return Promotion{}, fmt.Errorf("version %s of patchset %q not found on %s: %w", v, current.Name(), from, err)
+		}
+		old, err := at.PatchsetCache()
+		if err != nil {
+			return Promotion{}, err
+		}
+		ps = nil
+		for _, p := range old.Slice {
+			if p.SameAs(current) && p.Version().Cmp(v) == 0 {
+				ps = p
+			}
+		}
+		if ps == nil || ps.MetadataCommit() == "" {
+			return Promotion{}, fmt.Errorf("version %s of patchset %q not found on %s", v, current.Name(), from)
+		}
+	}
+	graph, err := dependency.Load(o)
+	if err != nil {
+		return Promotion{}, err
+	}
+	p := Promotion{
+		Patchset: ps.Name(),
+		UUID:     ps.UUID().String(),
+		Version:  ps.Version(),
+		From:     from,
+		Metadata: ps.MetadataCommit(),
+		Patches:  append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...),
+	}
+	for _, dep := range graph.Dependencies(current) {
+		p.Dependencies = append(p.Dependencies, dep.Name())
+	}
+	return p, nil
+}
+
+// NewPromoteCommand returns a command that promotes the patchset version onto
+// the kilt branch through a rework. A patchset of the same UUID already on the
+// branch is replaced in place, otherwise the patchset is added after the last
+// patchset. Once the rework finishes, its dependencies are added to the graph
+// of the branch, and the promotion is recorded in the promotion journal of
+// both branches.
+func NewPromoteCommand(p Promotion) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	slot, err := promotionSlot(patchsets, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPromotedDependencies(patchsets, slot, p); err != nil {
+		return nil, err
+	}
+	ops := map[int]queue.Item{
+		slot: {Operation: "Promote", Args: append([]string{p.Metadata}, p.Patches...)},
+	}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if len(p.Dependencies) > 0 {
+		if err = c.executor.Enqueue("AddDependencies", append([]string{p.Patchset}, p.Dependencies...)...); err != nil {
+			return nil, err
+		}
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("RecordPromotion", p.Patchset, p.UUID, p.Version.String(), p.From); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// promotionSlot returns the index the promoted patchset takes in patchsets:
+// that of the patchset it replaces, or one past the last patchset.
+func promotionSlot(patchsets repo.PatchsetCache, p Promotion) (int, error) {
+	existing, ok := patchsets.Lookup(p.Patchset)
+	if !ok {
+		for _, ps := range patchsets.Slice {
+			if ps.UUID().String() == p.UUID {
+				return 0, fmt.Errorf("patchset %q is named %q on the kilt branch", p.Patchset, ps.Name())
+			}
+		}
+		return len(patchsets.Slice), nil
+	}
+	if existing.UUID().String() != p.UUID {
+		return 0, fmt.Errorf("patchset %q on the kilt branch is a different patchset than on %s", p.Patchset, p.From)
+	}
+	if existing.Version().Cmp(p.Version) >= 0 {
+		return 0, fmt.Errorf("patchset %q is already at version %s", p.Patchset, existing.Version())
+	}
+	return patchsets.Index[existing.Name()], nil
+}
+
+// checkPromotedDependencies checks that each dependency of the promoted
+// patchset is on the kilt branch, ahead of the slot the patchset takes.
+func checkPromotedDependencies(patchsets repo.PatchsetCache, slot int, p Promotion) error {
+	for _, name := range p.Dependencies {
+		dep, ok := patchsets.Lookup(name)
+		if !ok {
+			return fmt.Errorf("patchset %q depends on %q, which is not on the kilt branch", p.Patchset, name)
+		}
+		if patchsets.Index[dep.Name()] >= slot {
+			return fmt.Errorf("patchset %q depends on %q, which comes after it on the kilt branch", p.Patchset, name)
+		}
+	}
+	return nil
+}
+
+// registerPromoteOperations registers the operations promoting a patchset
+// version from another kilt branch.
+func registerPromoteOperations(c *Command) {
+	var operations = []queue.Operation{
+		{
+			Name:   "Promote",
+			Params: []queue.Param{{Name: "metadata", Type: queue.CommitID}, {Name: "patches", Type: queue.CommitID, Variadic: true}},
+			Execute: func(args []string) error {
+				if len(args) == 0 {
+					return errors.New("no metadata commit specified")
+				}
+				desc, err := c.repo.DescribeCommit(args[0])
+				if err != nil {
+					return err
+				}
+				c.report("Promote", "Promoting %s", desc)
+				return c.executeReworkQueue(func(e *queue.Executor) error {
+					if err := e.Enqueue("UpdateMetadata", args[0], keepVersionArg); err != nil {
+						return err
+					}
+					for _, patch := range args[1:] {
+						if err := e.Enqueue("Apply", patch); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "RecordPromotion",
+			Params: []queue.Param{patchsetParam, {Name: "UUID", Type: queue.String}, {Name: "version", Type: queue.String}, {Name: "branch", Type: queue.String}},
+			Execute: func(args []string) error {
+				if len(args) < 4 {
+					return errors.New("patchset, UUID, version and branch required")
+				}
+				return recordPromotion(c.repo, args[0], args[1], args[2], args[3])
+			},
+		},
+	}
+	for _, op := range operations {
+		if headOperations[op.Name] {
+			op.Rollback = c.rollbackHead
+		}
+		c.executor.Register(op)
+	}
+}
+
+// addDependencies adds the named dependencies of the patchset to the graph of
+// the kilt branch, skipping those it already has.
+func addDependencies(r *repo.Repo, name string, deps []string) error {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return err
+	}
+	ps, ok := patchsets.Lookup(name)
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	graph, err := dependency.Load(r)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, dep := range graph.Dependencies(ps) {
+		existing[dep.UUID().String()] = true
+	}
+	for _, name := range deps {
+		dep, ok := patchsets.Lookup(name)
+		if !ok {
+			return fmt.Errorf("patchset %q not found", name)
+		}
+		if existing[dep.UUID().String()] {
+			continue
+		}
+		if err := graph.Add(ps, dep); err != nil {
+			return err
+		}
+	}
+	if err := graph.Validate(); err != nil {
+		return err
+	}
+	return dependency.Save(r, graph)
+}
+
+// recordPromotion records the promotion of the patchset version from the
+// branch in the promotion journal of both kilt branches.
+func recordPromotion(r *repo.Repo, name, uuid, version, from string) error {
+	r, err := r.Reopen()
+	if err != nil {
+		return err
+	}
+	o, err := r.OpenBranch(from)
+	if err != nil {
+		return err
+	}
+	p := repo.Promotion{
+		Patchset: name,
+		UUID:     uuid,
+		Version:  version,
+		From:     from,
+		To:       r.KiltBranch(),
+		Time:     time.Now(),
+	}
+	if err := r.RecordPromotion(p); err != nil {
+		return err
+	}
+	return o.RecordPromotion(p)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"testing"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+func TestPromotionSlot(t *testing.T) {
+	cache := planInput(t).Patchsets
+	v2 := patchset.InitialVersion().Successor()
+	tests := []struct {
+		desc      string
+		promotion Promotion
+		want      int
+		wantErr   bool
+	}{
+		{
+			desc:      "New patchset appended",
+			promotion: Promotion{Patchset: "e", UUID: patchset.New("e").UUID().String(), Version: v2, Dependencies: []string{"a", "d"}},
+			want:      4,
+		},
+		{
+			desc:      "Existing patchset replaced in place",
+			promotion: Promotion{Patchset: "c", UUID: cache.Map["c"].UUID().String(), Version: v2, Dependencies: []string{"a"}},
+			want:      2,
+		},
+		{
+			desc:      "Version not newer",
+			promotion: Promotion{Patchset: "c", UUID: cache.Map["c"].UUID().String(), Version: patchset.InitialVersion()},
+			wantErr:   true,
+		},
+		{
+			desc:      "Different patchset of the same name",
+			promotion: Promotion{Patchset: "c", UUID: patchset.New("c").UUID().String(), Version: v2},
+			wantErr:   true,
+		},
+		{
+			desc:      "Patchset renamed on the branch",
+			promotion: Promotion{Patchset: "e", UUID: cache.Map["b"].UUID().String(), Version: v2},
+			wantErr:   true,
+		},
+		{
+			desc:      "Missing dependency",
+			promotion: Promotion{Patchset: "e", UUID: patchset.New("e").UUID().String(), Version: v2, Dependencies: []string{"x"}},
+			wantErr:   true,
+		},
+		{
+			desc:      "Dependency after the patchset",
+			promotion: Promotion{Patchset: "b", UUID: cache.Map["b"].UUID().String(), Version: v2, Dependencies: []string{"d"}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := promotionSlot(cache, tt.promotion)
+		if err == nil {
+			err = checkPromotedDependencies(cache, got, tt.promotion)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: promotionSlot() error = %v, wantErr %t", tt.desc, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%s: promotionSlot() = %d, want %d", tt.desc, got, tt.want)
+		}
+	}
+}
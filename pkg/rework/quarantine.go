@@ -0,0 +1,117 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// NewQuarantineCommand returns a command that collects the patches without a
+// Patchset-Name footer into the quarantine patchset through a rework, creating
+// the patchset at the position of the first unassigned patch if it doesn't
+// exist yet. The time each patch entered quarantine is tracked, so patches
+// that stay unassigned for too long can be reported.
+func NewQuarantineCommand() (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	unknown, ok := patchsets.Map["unknown"]
+	if !ok || unknown.MetadataCommit() != "" {
+		return nil, errors.New("no unassigned patches to quarantine")
+	}
+	position := -1
+	for i, ps := range patchsets.Slice {
+		if ps == unknown {
+			position = i
+		}
+	}
+	ops := map[int]queue.Item{}
+	if q, ok := patchsets.Map[repo.QuarantinePatchset]; ok && q.MetadataCommit() != "" {
+		ops[patchsets.Index[repo.QuarantinePatchset]] = queue.Item{
+			Operation: "Gather",
+			Args:      append([]string{repo.QuarantinePatchset}, unknown.FloatingPatches()...),
+		}
+		ops[position] = queue.Item{Operation: "Quarantine"}
+	} else {
+		ops[position] = queue.Item{Operation: "Quarantine", Args: unknown.FloatingPatches()}
+	}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("TrackQuarantine"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// quarantinePatches reassigns the patches to the quarantine patchset, which is
+// created at head if it isn't in the reworked branch yet.
+func (c *Command) quarantinePatches(patches []string) error {
+	if len(patches) == 0 {
+		return nil
+	}
+	patchsets, err := c.repo.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		if q, ok := patchsets[repo.QuarantinePatchset]; !ok || q.MetadataCommit() == "" {
+			if err := e.Enqueue("CreateMetadata", repo.QuarantinePatchset); err != nil {
+				return err
+			}
+		}
+		for _, patch := range patches {
+			if err := e.Enqueue("Reassign", patch, repo.QuarantinePatchset); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// trackQuarantine records the quarantine times of the patches in the
+// quarantine patchset of the reworked branch.
+func trackQuarantine(r *repo.Repo) error {
+	r, err := r.Reopen()
+	if err != nil {
+		return err
+	}
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	var patches []string
+	if q, ok := patchsets[repo.QuarantinePatchset]; ok {
+		patches = append(append(patches, q.Patches()...), q.FloatingPatches()...)
+	}
+	return r.TrackQuarantine(patches, time.Now())
+}
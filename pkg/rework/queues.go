@@ -0,0 +1,149 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/reporter"
+)
+
+// Names of the queues kept in the rework directory. Builds keep their outer
+// queue under a name of their own so that continuing one runs the build
+// operations, which differ from those of a rework. The operation of the outer
+// queue working on a patchset runs the patchset queue, whose initial contents
+// are kept as its plan. Changes to the dependency graph are queued until the
+// rework finishes.
+const (
+	reworkQueueName     = "queue"
+	buildQueueName      = "buildQueue"
+	patchsetQueueName   = "reworkQueue"
+	patchsetPlanName    = "reworkQueue-plan"
+	skippedQueueName    = "skipped"
+	dependencyQueueName = "dependencies"
+)
+
+// QueueInfo describes a named queue of the rework state.
+type QueueInfo struct {
+	Name        string
+	Description string
+}
+
+// queues is the registry of the named queues of the rework state.
+var queues = []QueueInfo{
+	{reworkQueueName, "operations of the rework in progress"},
+	{buildQueueName, "operations of the build in progress"},
+	{patchsetQueueName, "steps of the patchset being reworked or applied"},
+	{patchsetPlanName, "all steps of the patchset being reworked or applied"},
+	{skippedQueueName, "operations skipped by the rework in progress"},
+	{dependencyQueueName, "dependency updates applied when the rework finishes"},
+}
+
+// Queues returns the named queues of the rework state.
+func Queues() []QueueInfo {
+	return append([]QueueInfo{}, queues...)
+}
+
+// QueueState is the saved state of a named queue.
+type QueueState struct {
+	QueueInfo
+	// Header is the header the queue was saved with, if any.
+	Header queue.Header
+	// Current is the failed operation, or the one that was running when the
+	// process was interrupted.
+	Current queue.Queue
+	// Done is the operation that completed before the queue was saved again.
+	Done queue.Queue
+	// Queue holds the queued operations.
+	Queue queue.Queue
+	// Progress counts the complete operations, if the queue tracks them.
+	Progress reporter.Progress
+}
+
+// Empty reports whether the queue has no saved state.
+func (s QueueState) Empty() bool {
+	return len(s.Current.Items) == 0 && len(s.Done.Items) == 0 && len(s.Queue.Items) == 0
+}
+
+func lookupQueue(name string) (QueueInfo, error) {
+	for _, q := range queues {
+		if q.Name == name {
+			return q, nil
+		}
+	}
+	return QueueInfo{}, fmt.Errorf("unknown queue %q", name)
+}
+
+// ReadQueue reads the saved state of the named queue, as it is on disk,
+// without recovering from an interrupted process.
+func ReadQueue(r *repo.Repo, name string) (QueueState, error) {
+	info, err := lookupQueue(name)
+	if err != nil {
+		return QueueState{}, err
+	}
+	s := newStateFile(r, name)
+	state := QueueState{QueueInfo: info}
+	if state.Current, err = s.ReadCurrentState(); err != nil {
+		return state, err
+	}
+	if state.Done, err = s.ReadDoneState(); err != nil {
+		return state, err
+	}
+	if state.Queue, err = s.ReadState(); err != nil {
+		return state, err
+	}
+	state.Header = s.saved
+	if state.Progress, err = s.ReadProgress(); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+// ClearQueue removes the saved state of the named queue. Clearing a queue of
+// a rework in progress leaves the rework inconsistent, so it requires force.
+func ClearQueue(r *repo.Repo, name string, force bool) error {
+	if _, err := lookupQueue(name); err != nil {
+		return err
+	}
+	if inProgress, err := r.ReworkInProgress(); err != nil {
+		return err
+	} else if inProgress && !force {
+		return fmt.Errorf("rework in progress, use kilt rework --abort instead, or force clearing queue %q", name)
+	}
+	s := newStateFile(r, name)
+	for _, clear := range []func() error{s.ClearQueueState, s.ClearCurrentState, s.ClearDoneState, s.ClearProgress, s.clearHistory} {
+		if err := clear(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueExists reports whether any state of the named queue is saved.
+func queueExists(r *repo.Repo, name string) bool {
+	s := newStateFile(r, name)
+	for _, suffix := range []string{"", "-current", "-done"} {
+		if _, err := os.Stat(filepath.Join(s.path, s.name+suffix)); err == nil {
+			return true
+		}
+	}
+	return false
+}
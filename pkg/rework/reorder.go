@@ -0,0 +1,154 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+const reorderHelp = `# Reordering the patchsets of the kilt branch.
+# List every patchset, one per line, in the order to apply them in. Each
+# patchset must come after the patchsets it depends on. Lines starting with #
+# are ignored.
+`
+
+// dependencyLister gives the patchsets a patchset directly depends on.
+type dependencyLister interface {
+	Dependencies(ps *patchset.Patchset) []*patchset.Patchset
+}
+
+// NewReorderCommand returns a command that rebuilds the branch with its
+// patchsets in the given order, through a rework which checks out the last
+// patchset whose position is unchanged, or the base, and applies the others in
+// their new order. Every patchset must be listed once, after the patchsets it
+// depends on.
+func NewReorderCommand(order []string) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	deps, err := dependency.Load(c.repo)
+	if err != nil {
+		return nil, err
+	}
+	ordered, err := reorderPatchsets(patchsets, order, deps)
+	if err != nil {
+		return nil, err
+	}
+	ops := map[int]queue.Item{}
+	for i, ps := range ordered {
+		if len(ops) == 0 && ps == patchsets.Slice[i] {
+			continue
+		}
+		if len(ps.FloatingPatches()) > 0 || ps.MetadataCommit() == "" {
+			ops[i] = queue.Item{Operation: "Rework", Args: []string{ps.Name()}}
+		} else {
+			ops[i] = queue.Item{Operation: "Apply", Args: []string{ps.Name()}}
+		}
+	}
+	if len(ops) == 0 {
+		return nil, ErrNoChanges
+	}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewEditReorderCommand returns a command that reorders the patchsets as
+// returned by edit, which is passed the current order as text.
+func NewEditReorderCommand(edit func(text []byte) ([]byte, error)) (*Command, error) {
+	r, err := repo.Open()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString(reorderHelp)
+	for _, ps := range patchsets {
+		fmt.Fprintf(&b, "%s\n", ps.Name())
+	}
+	text, err := edit([]byte(b.String()))
+	if err != nil {
+		return nil, err
+	}
+	return NewReorderCommand(parseOrder(text))
+}
+
+// parseOrder returns the patchset names listed in text, one per line, skipping
+// blank lines and comments.
+func parseOrder(text []byte) []string {
+	var names []string
+	for _, l := range strings.Split(string(text), "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" && !strings.HasPrefix(l, "#") {
+			names = append(names, l)
+		}
+	}
+	return names
+}
+
+// reorderPatchsets returns the patchsets in the order of the names, failing if
+// a patchset is missing, unknown or listed twice, or if a patchset would come
+// before one it depends on.
+func reorderPatchsets(patchsets repo.PatchsetCache, names []string, deps dependencyLister) ([]*patchset.Patchset, error) {
+	position := map[string]int{}
+	var ordered []*patchset.Patchset
+	for _, name := range names {
+		ps, ok := patchsets.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("patchset %q not found", name)
+		}
+		if _, ok := position[ps.Name()]; ok {
+			return nil, fmt.Errorf("patchset %q listed more than once", ps.Name())
+		}
+		position[ps.Name()] = len(ordered)
+		ordered = append(ordered, ps)
+	}
+	for _, ps := range patchsets.Slice {
+		if _, ok := position[ps.Name()]; !ok {
+			return nil, fmt.Errorf("patchset %q missing from the new order", ps.Name())
+		}
+	}
+	for i, ps := range ordered {
+		for _, dep := range deps.Dependencies(ps) {
+			if j, ok := position[dep.Name()]; ok && j > i {
+				return nil, fmt.Errorf("patchset %q must come after %q, which it depends on", ps.Name(), dep.Name())
+			}
+		}
+	}
+	return ordered, nil
+}
@@ -18,20 +18,31 @@ limitations under the License.
 package rework
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
 	log "github.com/golang/glog"
 	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/internal/atomicfile"
+	"github.com/google/kilt/pkg/internal/failpoint"
+	"github.com/google/kilt/pkg/internal/lock"
+	"github.com/google/kilt/pkg/internal/version"
+	"github.com/google/kilt/pkg/notify"
 	"github.com/google/kilt/pkg/patchset"
 	"github.com/google/kilt/pkg/queue"
 	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/reporter"
+	"github.com/google/kilt/pkg/selector"
 )
 
 // Command defines a rework command.
@@ -40,22 +51,187 @@ type Command struct {
 	executor queue.Executor
 	writer   stateWriter
 	reader   stateReader
+	reporter reporter.Reporter
+
+	// saved is set once the queue has been written before executing.
+	saved bool
+	// progress counts the complete operations of the rework. It is shared
+	// with the commands executing per-patchset queues.
+	progress *progressCounter
+	// ctx, if set, stops the execution of operations once it is done.
+	ctx context.Context
+	// heads holds the rework head before each executed operation of the
+	// history of the executor, or an empty string if the operation can't be
+	// rolled back. pendingHead is the head before the running operation.
+	heads       []string
+	pendingHead string
+	// resuming is set while the operation that failed is run again, whose
+	// head was recorded when it first ran.
+	resuming bool
 }
 
-// NewCommand opens the repo and returns a new rework command.
+// progressCounter counts the complete operations of a rework, including those
+// of the per-patchset queues, and saves the count alongside the rework queue.
+type progressCounter struct {
+	reporter.Progress
+	state *stateFile
+}
+
+// defaultReporter is the reporter of newly created commands.
+var defaultReporter reporter.Reporter = reporter.NewConsole(os.Stdout)
+
+// SetDefaultReporter sets the reporter of commands created afterwards.
+func SetDefaultReporter(rep reporter.Reporter) {
+	defaultReporter = rep
+}
+
+// Options configure the commands returned by the constructors taking them.
+type Options struct {
+	// Dir is a directory of the repo to rework. The repo containing the
+	// working directory is reworked if empty.
+	Dir string
+	// Reporter receives the messages of the command, including those sent
+	// while it is created. The default reporter is used if nil.
+	Reporter reporter.Reporter
+}
+
+// NewCommand opens the repo and returns a new rework command. Unless in
+// read-only mode, the repo is locked against other kilt processes until
+// ReleaseLocks is called or the process exits.
 func NewCommand() (*Command, error) {
-	r, err := repo.Open()
+	return NewCommandWithOptions(Options{})
+}
+
+// NewCommandWithOptions is like NewCommand, but opens the repo and reports to
+// the reporter selected by opts.
+func NewCommandWithOptions(opts Options) (*Command, error) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = "."
+	}
+	r, err := repo.OpenDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize rework: %w", err)
 	}
+	if err := lockRepo(r); err != nil {
+		return nil, err
+	}
+	rep := opts.Reporter
+	if rep == nil {
+		rep = defaultReporter
+	}
+	r.SetReporter(rep)
 	e := queue.NewExecutor()
 	var state *stateFile
-	return &Command{
+	c := &Command{
 		repo:     r,
 		executor: e,
 		writer:   state,
 		reader:   state,
-	}, nil
+		reporter: rep,
+	}
+	c.addStateHooks()
+	return c, nil
+}
+
+// newReworkCommand returns a command for a new rework of the kilt branch, whose
+// state is saved in the rework queue. An error is returned if a rework of the
+// branch is already in progress.
+func newReworkCommand() (*Command, error) {
+	c, err := NewCommand()
+	if err != nil {
+		return nil, err
+	}
+	s := newStateFile(c.repo, reworkQueueName)
+	c.setWriter(s)
+	c.setReader(s)
+	registerOperations(c)
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("rework already in progress")
+	}
+	return c, nil
+}
+
+// locks holds the repo locks of this process, by lock file path. locksMu
+// guards it, as commands of different repos may be created concurrently.
+var (
+	locks   = map[string]*lock.Lock{}
+	locksMu sync.Mutex
+)
+
+func lockPath(r *repo.Repo) string {
+	return filepath.Join(r.KiltDirectory(), "lock")
+}
+
+// lockRepo locks the repo for this process, unless it already holds the lock.
+func lockRepo(r *repo.Repo) error {
+	path := lockPath(r)
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if _, ok := locks[path]; ok || repo.ReadOnly() {
+		return nil
+	}
+	l, err := lock.Acquire(path, strings.Join(os.Args, " "))
+	if err != nil {
+		return fmt.Errorf("failed to lock repo: %w", err)
+	}
+	locks[path] = l
+	return nil
+}
+
+// ReleaseLocks releases the repo locks held by this process.
+func ReleaseLocks() error {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	for path, l := range locks {
+		if err := l.Release(); err != nil {
+			return fmt.Errorf("failed to release lock: %w", err)
+		}
+		delete(locks, path)
+	}
+	return nil
+}
+
+// ReleaseLock releases the lock of the repo, if held by this process.
+func ReleaseLock(r *repo.Repo) error {
+	path := lockPath(r)
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	l, ok := locks[path]
+	if !ok {
+		return nil
+	}
+	if err := l.Release(); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	delete(locks, path)
+	return nil
+}
+
+// BreakLock removes the lock of the current repo, regardless of which process
+// holds it.
+func BreakLock() error {
+	if err := repo.Writable("break lock"); err != nil {
+		return err
+	}
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	return lock.Break(lockPath(r))
+}
+
+// SetReporter sets the reporter that the messages of operations are sent to.
+func (c *Command) SetReporter(rep reporter.Reporter) {
+	c.reporter = rep
+	c.repo.SetReporter(rep)
+}
+
+// report sends the message of the named operation to the reporter.
+func (c *Command) report(op, format string, args ...interface{}) {
+	c.reporter.Operation(op, fmt.Sprintf(format, args...))
 }
 
 func (c *Command) setWriter(w stateWriter) {
@@ -71,25 +247,127 @@ func (c *Command) Save() error {
 	return c.writer.WriteQueueState(c.executor.Queue())
 }
 
-// Execute will execute the command, running an queued operations.
+// Execute will execute the command, running the next queued operation.
+//
+// The queue is saved before an operation runs and after it completes, by the
+// hooks the command adds to its executor, so an interrupted process can be
+// recovered from: the queue always starts with the operation that was running.
+// A resumable operation is recorded as current while it runs, and operations
+// are recorded as done once they complete until the queue is saved again.
 func (c *Command) Execute() error {
-	item := c.executor.Peek()
-	if item != nil && c.executor.Resumable(item.Operation) {
-		if err := c.writer.WriteCurrentState(*item); err != nil {
+	return c.executor.Execute()
+}
+
+// addStateHooks adds the hooks persisting the state of the command around
+// each operation, and reporting its progress and result.
+func (c *Command) addStateHooks() {
+	c.executor.AddHooks(queue.Hooks{
+		Before:  c.beforeOperation,
+		After:   c.afterOperation,
+		OnError: c.operationFailed,
+	})
+}
+
+// beforeOperation saves the queue the first time an operation runs, and
+// records the resumable operation about to run as current.
+func (c *Command) beforeOperation(op queue.Item) error {
+	if !c.saved {
+		if err := c.writer.WriteQueueState(c.executor.Queue()); err != nil {
+			return err
+		}
+		if err := c.writer.ClearDoneState(); err != nil {
+			return err
+		}
+		if err := c.startProgress(); err != nil {
 			return err
 		}
+		c.saved = true
 	}
-	err := c.executor.Execute()
-	if err == nil {
-		return c.writer.ClearCurrentState()
+	if p, ok := c.reporter.(reporter.ProgressReporter); ok && c.progress != nil {
+		p.Progress(c.progress.Progress)
+	}
+	if err := c.recordHead(op); err != nil {
+		return err
+	}
+	if c.executor.Resumable(op.Operation) {
+		if err := c.writer.WriteCurrentState(op); err != nil {
+			return err
+		}
+	}
+	failpoint.Inject("rework-before-operation")
+	return nil
+}
+
+// afterOperation records the operation as done, adds it to the history, and
+// saves the remaining queue.
+func (c *Command) afterOperation(op queue.Item) error {
+	reporter.Finished(c.reporter, op.Operation, nil)
+	if err := c.writer.WriteDoneState(op); err != nil {
+		return err
+	}
+	if err := c.saveHistory(op); err != nil {
+		return err
+	}
+	failpoint.Inject("rework-before-save")
+	if err := c.writer.ClearCurrentState(); err != nil {
+		return err
 	}
+	if err := c.writer.WriteQueueState(c.executor.Queue()); err != nil {
+		return err
+	}
+	failpoint.Inject("rework-after-save")
+	if err := c.writer.ClearDoneState(); err != nil {
+		return err
+	}
+	return c.advanceProgress()
+}
+
+// operationFailed reports the failure of the operation.
+func (c *Command) operationFailed(op queue.Item, err error) error {
+	reporter.Finished(c.reporter, op.Operation, err)
 	return err
 }
 
+// startProgress loads the progress of the rework, counting the queued
+// operations that are not yet accounted for. Commands without saved state
+// don't track progress, and the commands of per-patchset queues share the
+// progress of their parent.
+func (c *Command) startProgress() error {
+	s, ok := c.writer.(*stateFile)
+	if c.progress != nil || !ok || s == nil {
+		return nil
+	}
+	p, err := s.ReadProgress()
+	if err != nil {
+		return err
+	}
+	if remaining := len(c.executor.Queue().Items); p.Total < p.Done+remaining {
+		p.Total = p.Done + remaining
+	}
+	c.progress = &progressCounter{Progress: p, state: s}
+	return c.progress.state.WriteProgress(p)
+}
+
+// advanceProgress counts a completed operation. The count is removed once
+// the rework queue is empty.
+func (c *Command) advanceProgress() error {
+	if c.progress == nil {
+		return nil
+	}
+	c.progress.Done++
+	if c.writer == stateWriter(c.progress.state) && c.executor.Peek() == nil {
+		return c.progress.state.ClearProgress()
+	}
+	return c.progress.state.WriteProgress(c.progress.Progress)
+}
+
 // ExecuteAll will execute all queued operations, stopping if an error occurs.
 func (c *Command) ExecuteAll() error {
 	var err error
-	for err = c.Execute(); err == nil; err = c.Execute() {
+	for err = c.contextErr(); err == nil; err = c.contextErr() {
+		if err = c.Execute(); err != nil {
+			break
+		}
 	}
 	if err == queue.ErrEmpty {
 		return nil
@@ -97,22 +375,70 @@ func (c *Command) ExecuteAll() error {
 	return err
 }
 
+// ExecuteAllContext is like ExecuteAll, but stops before the next operation,
+// including those of per-patchset queues, once ctx is done, returning the
+// error of ctx. The remaining queue can be saved and continued later.
+func (c *Command) ExecuteAllContext(ctx context.Context) error {
+	c.ctx = ctx
+	return c.ExecuteAll()
+}
+
+// contextErr returns the error of the context of the command, if it is done.
+func (c *Command) contextErr() error {
+	if c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
 // stateWriter manages the writing and removal of operation states.
 type stateWriter interface {
 	WriteQueueState(queue queue.Queue) error
 	WriteCurrentState(item queue.Item) error
 	ClearQueueState() error
 	ClearCurrentState() error
+	WriteDoneState(item queue.Item) error
+	ClearDoneState() error
 }
 
 // stateReader manages the reading of operation states.
 type stateReader interface {
 	ReadState() (queue.Queue, error)
 	ReadCurrentState() (queue.Queue, error)
+	ReadDoneState() (queue.Queue, error)
 }
 
 type stateFile struct {
 	path, name string
+	// branch is the kilt branch recorded in the header of the state files.
+	branch string
+	// saved is the header of the state file read last.
+	saved queue.Header
+}
+
+// readError describes the error reading the state file with the name,
+// pointing at aborting the rework if the file is corrupted.
+func (s *stateFile) readError(name string, err error) error {
+	if errors.Is(err, queue.ErrCorrupted) {
+		return fmt.Errorf("rework state %s corrupted, run kilt rework --abort: %w", filepath.Join(s.path, name), err)
+	}
+	return err
+}
+
+// header returns the header the state files are saved with.
+func (s *stateFile) header() queue.Header {
+	return queue.Header{KiltVersion: version.String(), Branch: s.branch, Schema: operationSchema}
+}
+
+// decode parses the contents of the state file with the name, migrating them
+// to the current operation schema.
+func (s *stateFile) decode(name string, b []byte) (queue.Queue, error) {
+	q, h, err := queue.Unmarshal(b)
+	if err != nil {
+		return q, s.readError(name, err)
+	}
+	s.saved = h
+	return migrateQueue(h, q)
 }
 
 // ReadState will read the operation queue, returning a new Queue.
@@ -128,37 +454,40 @@ func (s *stateFile) ReadState() (queue.Queue, error) {
 	} else if err != nil {
 		return q, err
 	}
-	err = q.UnmarshalText(file)
-	if err != nil {
-		return q, err
-	}
-	return q, nil
+	return s.decode(s.name, file)
 }
 
 // ReadState will read the current operation, returning a new Queue.
 func (s *stateFile) ReadCurrentState() (queue.Queue, error) {
-	var item queue.Item
-	var queue queue.Queue
+	return s.readItem("-current")
+}
+
+// ReadDoneState will read the operation that completed before the queue could
+// be saved, returning a new Queue.
+func (s *stateFile) ReadDoneState() (queue.Queue, error) {
+	return s.readItem("-done")
+}
+
+func (s *stateFile) readItem(suffix string) (queue.Queue, error) {
+	var q queue.Queue
 	if s == nil {
-		return queue, nil
+		return q, nil
 	}
-	file, err := ioutil.ReadFile(filepath.Join(s.path, s.name+"-current"))
+	file, err := ioutil.ReadFile(filepath.Join(s.path, s.name+suffix))
 	var e *os.PathError
 	if err != nil && !errors.As(err, &e) {
-		return queue, err
+		return q, err
 	}
 	if len(file) == 0 {
-		return queue, nil
+		return q, nil
 	}
-	err = item.UnmarshalText(file)
-	if err != nil {
-		return queue, err
+	if q, err = s.decode(s.name+suffix, file); err != nil {
+		return q, err
 	}
-	if item.Operation == "" {
-		return queue, nil
+	if len(q.Items) > 1 {
+		q.Items = q.Items[:1]
 	}
-	queue.Items = append(queue.Items, item)
-	return queue, nil
+	return q, nil
 }
 
 // WriteCurrentState will write the current item to a state file.
@@ -166,33 +495,53 @@ func (s *stateFile) WriteCurrentState(item queue.Item) error {
 	if s == nil {
 		return nil
 	}
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
 	if item.Operation == "" {
 		return s.ClearCurrentState()
 	}
+	return s.writeItem("-current", item)
+}
+
+// WriteDoneState will write the item that completed to a state file.
+func (s *stateFile) WriteDoneState(item queue.Item) error {
+	if s == nil {
+		return nil
+	}
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
+	return s.writeItem("-done", item)
+}
+
+func (s *stateFile) writeItem(suffix string, item queue.Item) error {
 	os.MkdirAll(s.path, 0777)
-	currentFile := filepath.Join(s.path, s.name+"-current")
-	text, err := item.MarshalText()
+	b, err := queue.Marshal(queue.Queue{Items: []queue.Item{item}}, s.header())
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(currentFile, text, 0666)
+	return atomicfile.WriteFile(filepath.Join(s.path, s.name+suffix), b, 0666)
 }
 
 // WriteQueueState will marshal and write the queue to a state file.
-func (s *stateFile) WriteQueueState(queue queue.Queue) error {
+func (s *stateFile) WriteQueueState(q queue.Queue) error {
 	if s == nil {
 		return nil
 	}
-	if len(queue.Items) == 0 {
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
+	if len(q.Items) == 0 {
 		return s.ClearQueueState()
 	}
 	os.MkdirAll(s.path, 0777)
-	q, err := queue.MarshalText()
+	b, err := queue.Marshal(q, s.header())
 	if err != nil {
 		return fmt.Errorf("failed to marshal queue: %v", err)
 	}
 	queueFile := filepath.Join(s.path, s.name)
-	return ioutil.WriteFile(queueFile, q, 0666)
+	return atomicfile.WriteFile(queueFile, b, 0666)
 }
 
 // ClearCurrentState will remove the current operation state file.
@@ -200,15 +549,112 @@ func (s *stateFile) ClearCurrentState() error {
 	if s == nil {
 		return nil
 	}
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
 	queueFile := filepath.Join(s.path, s.name)
 	return os.RemoveAll(queueFile + "-current")
 }
 
+// ClearDoneState will remove the done operation state file.
+func (s *stateFile) ClearDoneState() error {
+	if s == nil {
+		return nil
+	}
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
+	queueFile := filepath.Join(s.path, s.name)
+	return os.RemoveAll(queueFile + "-done")
+}
+
+// readRecoveredState reads the failed operation and the queue following it,
+// recovering from an interrupted process with recoverQueue.
+func readRecoveredState(s stateReader) (queue.Queue, queue.Queue, error) {
+	current, err := s.ReadCurrentState()
+	if err != nil {
+		return current, queue.Queue{}, err
+	}
+	done, err := s.ReadDoneState()
+	if err != nil {
+		return current, queue.Queue{}, err
+	}
+	q, err := s.ReadState()
+	if err != nil {
+		return current, q, err
+	}
+	current, q = recoverQueue(current, done, q)
+	return current, q, nil
+}
+
+// recoverQueue returns the failed operation and the queue following it. If the
+// process was interrupted, the saved queue may still start with an operation
+// that completed, which is dropped, or with the operation that was running,
+// which is left in the queue to run again instead of being treated as failed.
+func recoverQueue(current, done, q queue.Queue) (queue.Queue, queue.Queue) {
+	items := q.Items
+	same := func(a queue.Queue, b []queue.Item) bool {
+		return len(a.Items) > 0 && len(b) > 0 && describeItem(a.Items[0]) == describeItem(b[0])
+	}
+	if same(done, items) {
+		items = items[1:]
+	}
+	if same(done, current.Items) || same(current, items) {
+		current = queue.Queue{}
+	}
+	return current, queue.Queue{Items: items}
+}
+
+// ReadProgress reads the count of complete operations.
+func (s *stateFile) ReadProgress() (reporter.Progress, error) {
+	var p reporter.Progress
+	if s == nil {
+		return p, nil
+	}
+	file, err := ioutil.ReadFile(filepath.Join(s.path, s.name+"-progress"))
+	var e *os.PathError
+	if errors.As(err, &e) {
+		return p, nil
+	} else if err != nil {
+		return p, err
+	}
+	if _, err := fmt.Sscan(string(file), &p.Done, &p.Total); err != nil {
+		return p, fmt.Errorf("invalid progress %q: %w", file, err)
+	}
+	return p, nil
+}
+
+// WriteProgress writes the count of complete operations to a state file.
+func (s *stateFile) WriteProgress(p reporter.Progress) error {
+	if s == nil {
+		return nil
+	}
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
+	os.MkdirAll(s.path, 0777)
+	return atomicfile.WriteFile(filepath.Join(s.path, s.name+"-progress"), []byte(fmt.Sprintf("%d %d\n", p.Done, p.Total)), 0666)
+}
+
+// ClearProgress removes the progress state file.
+func (s *stateFile) ClearProgress() error {
+	if s == nil {
+		return nil
+	}
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.path, s.name+"-progress"))
+}
+
 // ClearCurrentState will remove the queue state file.
 func (s *stateFile) ClearQueueState() error {
 	if s == nil {
 		return nil
 	}
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
 	queueFile := filepath.Join(s.path, s.name)
 	return os.RemoveAll(queueFile)
 }
@@ -226,12 +672,12 @@ func (FloatingTargets) Select(patchset *patchset.Patchset) bool {
 	return len(patchset.FloatingPatches()) > 0
 }
 
-// AllTargets selects every patchset.
+// AllTargets selects every patchset that isn't archived.
 type AllTargets struct{}
 
-// Select will always return true.
-func (AllTargets) Select(_ *patchset.Patchset) bool {
-	return true
+// Select will return true unless the patchset is archived.
+func (AllTargets) Select(patchset *patchset.Patchset) bool {
+	return !patchset.Archived()
 }
 
 // NoTargets selects nothing.
@@ -247,24 +693,79 @@ type PatchsetTarget struct {
 	Name string
 }
 
-// Select returns true if the patchset name matches.
-func (t PatchsetTarget) Select(patchset *patchset.Patchset) bool {
-	return t.Name == patchset.Name()
+// Select returns true if the patchset name collates equal to the target name.
+func (t PatchsetTarget) Select(p *patchset.Patchset) bool {
+	return patchset.SameName(t.Name, p.Name())
+}
+
+// TagTarget selects the patchsets tagged with a tag.
+type TagTarget struct {
+	Tag string
+}
+
+// Select returns true if the patchset is tagged with the target tag.
+func (t TagTarget) Select(p *patchset.Patchset) bool {
+	return p.HasTag(t.Tag)
+}
+
+// ExprTarget selects the patchsets matching a selector expression.
+type ExprTarget struct {
+	Expr  selector.Expr
+	graph selector.Graph
+}
+
+// ParseTarget parses a selector expression into a target. A plain patchset
+// name selects that patchset.
+func ParseTarget(expr string) (ExprTarget, error) {
+	e, err := selector.Parse(expr)
+	if err != nil {
+		return ExprTarget{}, err
+	}
+	return ExprTarget{Expr: e}, nil
+}
+
+// Select returns true if the expression matches the patchset.
+func (t ExprTarget) Select(p *patchset.Patchset) bool {
+	return t.Expr.Match(p, t.graph)
+}
+
+// withGraph returns the selectors with the dependency graph bound to any
+// expression targets, so that their depends terms can be evaluated.
+func withGraph(selectors []TargetSelector, g selector.Graph) []TargetSelector {
+	bound := make([]TargetSelector, len(selectors))
+	for i, s := range selectors {
+		if t, ok := s.(ExprTarget); ok {
+			t.graph = g
+			s = t
+		}
+		bound[i] = s
+	}
+	return bound
 }
 
-func registerBuildOperations(e *queue.Executor, r *repo.Repo) {
+// Params shared by the operations of reworks and builds.
+var (
+	noParams      = []queue.Param{}
+	patchsetParam = queue.Param{Name: "patchset", Type: queue.PatchsetName}
+	patchParam    = queue.Param{Name: "patch", Type: queue.CommitID}
+)
+
+func registerBuildOperations(c *Command) {
+	r := c.repo
 	var operations = []queue.Operation{
 		{
-			Name: "UpdateHead",
+			Name:   "UpdateHead",
+			Params: noParams,
 			Execute: func(_ []string) error {
-				if err := r.WriteRefHead("rework/head"); err != nil {
+				if err := r.WriteRefHead(r.ReworkRef("head")); err != nil {
 					return err
 				}
-				return r.SetHead("rework/head")
+				return r.SetHead(r.ReworkRef("head"))
 			},
 		},
 		{
-			Name: "Finish",
+			Name:   "Finish",
+			Params: []queue.Param{{Name: "branch", Type: queue.String}},
 			Execute: func(branch []string) error {
 				if len(branch) == 0 {
 					return errors.New("no branch specified")
@@ -272,65 +773,109 @@ func registerBuildOperations(e *queue.Executor, r *repo.Repo) {
 				return finishBuild(r, branch[0])
 			},
 		},
+
 		{
-			Name: "Abort",
+			Name:   "Abort",
+			Params: noParams,
 			Execute: func(_ []string) error {
 				return abortRework(r)
 			},
 		},
 		{
-			Name: "Begin",
-			Execute: func(_ []string) error {
-				return startNewRework(r)
+			Name:   "Begin",
+			Params: []queue.Param{{Name: "name", Type: queue.String, Optional: true}},
+			Execute: func(name []string) error {
+				if err := startNewRework(r); err != nil {
+					return err
+				}
+				if len(name) > 0 {
+					return writeSessionName(r, name[0])
+				}
+				return nil
 			},
 		},
 		{
-			Name: "Checkout",
+			Name:   "Checkout",
+			Params: []queue.Param{{Name: "rev", Type: queue.String}},
 			Execute: func(revspec []string) error {
 				if len(revspec) == 0 {
 					return errors.New("no rev specified")
 				}
-				fmt.Printf("Checking out %s\n", revspec[0])
+				c.report("Checkout", "Checking out %s", revspec[0])
 				return r.CheckoutRev(revspec[0])
 			},
 			Resumable: true,
 		},
 		{
-			Name: "Apply",
+			Name:   "Apply",
+			Params: []queue.Param{patchsetParam, {Name: "options", Type: queue.String, Variadic: true}},
 			Execute: func(patchset []string) error {
 				if len(patchset) == 0 {
 					return errors.New("no patchset specified")
 				}
-				fmt.Printf("Applying patchset %s\n", patchset[0])
-				return applyPatchset(r, patchset[0])
+				var squash bool
+				var upTo string
+				for _, arg := range patchset[1:] {
+					switch {
+					case arg == squashKind:
+						squash = true
+					case strings.HasPrefix(arg, upToPrefix):
+						upTo = strings.TrimPrefix(arg, upToPrefix)
+					}
+				}
+				if upTo != "" {
+					c.report("Apply", "Applying patchset %s up to %s", patchset[0], upTo)
+				} else {
+					c.report("Apply", "Applying patchset %s", patchset[0])
+				}
+				if squash {
+					return c.applySquashedPatchset(patchset[0], upTo)
+				}
+				return c.applyPatchset(patchset[0], upTo)
 			},
 			Resumable: true,
 		},
+		{
+			Name:   "Collapse",
+			Params: []queue.Param{patchsetParam, {Name: "base", Type: queue.String}},
+			Execute: func(args []string) error {
+				if len(args) < 2 {
+					return errors.New("patchset and base required")
+				}
+				return c.collapsePatchset(args[0], args[1])
+			},
+			Resumable: true,
+		},
+		testOperation(c),
 	}
 	for _, op := range operations {
-		e.Register(op)
+		c.executor.Register(op)
 	}
+	registerWorktreeOperations(c)
 }
 
-func registerOperations(e *queue.Executor, r *repo.Repo) {
+func registerOperations(c *Command) {
+	r := c.repo
 	var operations = []queue.Operation{
 		{
-			Name: "UpdateHead",
+			Name:   "UpdateHead",
+			Params: noParams,
 			Execute: func(_ []string) error {
-				if err := r.WriteRefHead("rework/head"); err != nil {
+				if err := r.WriteRefHead(r.ReworkRef("head")); err != nil {
 					return err
 				}
-				return r.SetHead("rework/head")
+				return r.SetHead(r.ReworkRef("head"))
 			},
 		},
 		{
-			Name: "Validate",
+			Name:   "Validate",
+			Params: noParams,
 			Execute: func(_ []string) error {
 				if valid, err := validateRework(r); err != nil {
 					return err
 				} else if !valid {
 					return &ErrInvalidRework{
-						original: "refs/kilt/rework/branch",
+						original: "refs/kilt/" + r.ReworkRef("branch"),
 						reworked: "HEAD",
 					}
 				}
@@ -338,76 +883,249 @@ func registerOperations(e *queue.Executor, r *repo.Repo) {
 			},
 		},
 		{
-			Name: "Finish",
+			Name:   "Finish",
+			Params: noParams,
 			Execute: func(_ []string) error {
-				return finishRework(r)
+				return c.finishRework()
 			},
 		},
 		{
-			Name: "Abort",
+			Name:   "Abort",
+			Params: noParams,
 			Execute: func(_ []string) error {
 				return abortRework(r)
 			},
 		},
 		{
-			Name: "Begin",
-			Execute: func(_ []string) error {
-				return startNewRework(r)
+			Name:   "Begin",
+			Params: []queue.Param{{Name: "name", Type: queue.String, Optional: true}},
+			Execute: func(name []string) error {
+				if err := startNewRework(r); err != nil {
+					return err
+				}
+				if len(name) > 0 {
+					return writeSessionName(r, name[0])
+				}
+				return nil
 			},
 		},
 		{
-			Name: "Rework",
+			Name:   "Rework",
+			Params: []queue.Param{patchsetParam, {Name: "options", Type: queue.String, Variadic: true}},
 			Execute: func(patchset []string) error {
 				if len(patchset) == 0 {
 					return errors.New("no patchset specified")
 				}
-				fmt.Printf("Reworking patchset %s\n", patchset[0])
-				return reworkPatchset(r, patchset[0])
+				c.report("Rework", "Reworking patchset %s", patchset[0])
+				var autosquash, bump bool
+				for _, arg := range patchset[1:] {
+					switch arg {
+					case autosquashArg:
+						autosquash = true
+					case bumpArg:
+						bump = true
+					}
+				}
+				if autosquash {
+					return c.autosquashPatchset(patchset[0], bump)
+				}
+				return c.reworkPatchset(patchset[0], bump)
 			},
 			Resumable: true,
 		},
 		{
-			Name: "Skip",
-			Execute: func([]string) error {
-				fmt.Println("Clearing queue")
-				return skipReworkQueue(r)
+			Name:   "Delete",
+			Params: []queue.Param{patchsetParam},
+			Execute: func(args []string) error {
+				if len(args) == 0 {
+					return errors.New("no patchset specified")
+				}
+				// The patchset is left out of the rebuilt branch, and
+				// DeleteDependencies removes it from the graph.
+				c.report("Delete", "Deleting patchset %s", args[0])
+				return nil
 			},
 			Resumable: true,
 		},
 		{
-			Name: "Checkout",
-			Execute: func(patchset []string) error {
-				if len(patchset) == 0 {
+			Name:   "Release",
+			Params: []queue.Param{patchsetParam, {Name: "patches", Type: queue.CommitID, Variadic: true}},
+			Execute: func(args []string) error {
+				if len(args) == 0 {
 					return errors.New("no patchset specified")
 				}
-				fmt.Printf("Checking out patchset %s\n", patchset[0])
-				return r.CheckoutPatchset(patchset[0])
+				c.report("Release", "Releasing %d patches from patchset %s", len(args)-1, args[0])
+				return c.movePatches(args[0], args[1:], nil, false)
 			},
 			Resumable: true,
 		},
 		{
-			Name: "CheckoutBase",
-			Execute: func(patchset []string) error {
-				fmt.Println("Checking out kilt base")
-				return r.CheckoutBase()
+			Name:   "Gather",
+			Params: []queue.Param{patchsetParam, {Name: "patches", Type: queue.CommitID, Variadic: true}},
+			Execute: func(args []string) error {
+				if len(args) == 0 {
+					return errors.New("no patchset specified")
+				}
+				c.report("Gather", "Gathering %d patches into patchset %s", len(args)-1, args[0])
+				return c.movePatches(args[0], nil, args[1:], false)
 			},
 			Resumable: true,
 		},
 		{
-			Name: "Apply",
-			Execute: func(patchset []string) error {
+			Name:   "Split",
+			Params: []queue.Param{patchsetParam, {Name: "new name", Type: queue.PatchsetName}, {Name: "at", Type: queue.CommitID}},
+			Execute: func(args []string) error {
+				if len(args) < 3 {
+					return errors.New("patchset, new name and commit required")
+				}
+				c.report("Split", "Splitting patchset %s into %s at %s", args[0], args[1], args[2])
+				return c.splitPatchset(args[0], args[1], args[2])
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "Trailer",
+			Params: []queue.Param{patchsetParam, {Name: "mode", Type: queue.String}, {Name: "key", Type: queue.String}, {Name: "value", Type: queue.String}},
+			Execute: func(args []string) error {
+				if len(args) < 4 {
+					return errors.New("patchset, mode, key and value required")
+				}
+				c.report("Trailer", "Rewriting %s trailers of patchset %s", args[2], args[0])
+				return c.trailerPatchset(args[0], args[1], args[2], args[3])
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "FixSubjects",
+			Params: []queue.Param{patchsetParam, {Name: "prefix", Type: queue.String}},
+			Execute: func(args []string) error {
+				if len(args) < 2 {
+					return errors.New("patchset and prefix required")
+				}
+				c.report("FixSubjects", "Fixing subjects of patchset %s", args[0])
+				return c.fixSubjectsPatchset(args[0], args[1])
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "Rename",
+			Params: []queue.Param{patchsetParam, {Name: "new name", Type: queue.PatchsetName}},
+			Execute: func(args []string) error {
+				if len(args) < 2 {
+					return errors.New("patchset and new name required")
+				}
+				c.report("Rename", "Renaming patchset %s to %s", args[0], args[1])
+				return c.adoptPatchset(args[0], args[1], "")
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "Edit",
+			Params: []queue.Param{patchsetParam, {Name: "template", Type: queue.CommitID}},
+			Execute: func(args []string) error {
+				if len(args) < 2 {
+					return errors.New("patchset and metadata template required")
+				}
+				c.report("Edit", "Editing metadata of patchset %s", args[0])
+				return c.editPatchset(args[0], args[1])
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "Adopt",
+			Params: []queue.Param{patchsetParam, {Name: "name", Type: queue.PatchsetName}, {Name: "UUID", Type: queue.String}},
+			Execute: func(args []string) error {
+				if len(args) < 3 {
+					return errors.New("patchset, name and UUID required")
+				}
+				c.report("Adopt", "Adopting patchset %s as %s", args[0], args[1])
+				return c.adoptPatchset(args[0], args[1], args[2])
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "Quarantine",
+			Params: []queue.Param{{Name: "patches", Type: queue.CommitID, Variadic: true}},
+			Execute: func(patches []string) error {
+				c.report("Quarantine", "Quarantining %d patches", len(patches))
+				return c.quarantinePatches(patches)
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "TrackQuarantine",
+			Params: noParams,
+			Execute: func(_ []string) error {
+				return trackQuarantine(r)
+			},
+		},
+		{
+			Name:   "Checkout",
+			Params: []queue.Param{patchsetParam},
+			Execute: func(patchset []string) error {
+				if len(patchset) == 0 {
+					return errors.New("no patchset specified")
+				}
+				c.report("Checkout", "Checking out patchset %s", patchset[0])
+				return r.CheckoutPatchset(patchset[0])
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "CheckoutBase",
+			Params: noParams,
+			Execute: func(patchset []string) error {
+				c.report("CheckoutBase", "Checking out kilt base")
+				return r.CheckoutBase()
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "CheckoutRev",
+			Params: []queue.Param{{Name: "rev", Type: queue.String}},
+			Execute: func(revspec []string) error {
+				if len(revspec) == 0 {
+					return errors.New("no rev specified")
+				}
+				c.report("CheckoutRev", "Checking out %s", revspec[0])
+				return r.CheckoutRev(revspec[0])
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "MoveBase",
+			Params: []queue.Param{{Name: "base", Type: queue.String}},
+			Execute: func(base []string) error {
+				if len(base) == 0 {
+					return errors.New("no base specified")
+				}
+				c.report("MoveBase", "Moving kilt base to %s", base[0])
+				return r.MoveBase(base[0])
+			},
+		},
+		{
+			Name:   "Apply",
+			Params: []queue.Param{patchsetParam},
+			Execute: func(patchset []string) error {
 				if len(patchset) == 0 {
 					return errors.New("no patchset specified")
 				}
-				fmt.Printf("Applying patchset %s\n", patchset[0])
-				return applyPatchset(r, patchset[0])
+				c.report("Apply", "Applying patchset %s", patchset[0])
+				return c.applyPatchset(patchset[0], "")
 			},
 			Resumable: true,
 		},
+		testOperation(c),
 	}
 	for _, op := range operations {
-		e.Register(op)
+		if headOperations[op.Name] {
+			op.Rollback = c.rollbackHead
+		}
+		c.executor.Register(op)
 	}
+	registerWorktreeOperations(c)
+	registerPromoteOperations(c)
+	registerDependencyOperations(c)
 }
 
 func selectPatchset(selectors []TargetSelector, patchset *patchset.Patchset) bool {
@@ -421,80 +1139,38 @@ func selectPatchset(selectors []TargetSelector, patchset *patchset.Patchset) boo
 
 // NewBeginCommand returns a command that begins a new rework.
 func NewBeginCommand(selectors ...TargetSelector) (*Command, error) {
-	c, err := NewCommand()
+	return NewBeginCommandWithOptions(Options{}, selectors...)
+}
+
+// NewBeginCommandWithOptions is like NewBeginCommand, with the repo and
+// reporter selected by opts.
+func NewBeginCommandWithOptions(opts Options, selectors ...TargetSelector) (*Command, error) {
+	c, err := NewCommandWithOptions(opts)
 	if err != nil {
 		return nil, err
 	}
-
-	s := newStateFile(c.repo, "queue")
-
-	c.setWriter(s)
-	c.setReader(s)
-
-	registerOperations(&c.executor, c.repo)
-
+	begin := true
 	if exists, err := c.repo.ReworkInProgress(); err != nil {
 		return nil, err
 	} else if exists {
-		if q, err := c.reader.ReadState(); err == nil && len(q.Items) > 0 {
+		s := newStateFile(c.repo, reworkQueueName)
+		if q, err := s.ReadState(); err == nil && len(q.Items) > 0 {
 			return nil, fmt.Errorf("rework already in progress")
 		}
-	} else {
-		if err = c.executor.Enqueue("Begin"); err != nil {
-			return nil, err
-		}
+		begin = false
 	}
-	patchsets, err := c.repo.Patchsets()
+	in, err := LoadPlanInput(c.repo)
 	if err != nil {
 		return nil, err
 	}
-	revDeps, err := selectRevDepPatchsets(c.repo, selectors)
-	if err != nil {
-		return nil, err
-	}
-	first := true
-	var previous *patchset.Patchset
-	i := 0
-	for _, p := range patchsets {
-		if i < len(revDeps) && revDeps[i].SameAs(p) {
-			if first {
-				if previous != nil {
-					c.executor.Enqueue("Checkout", previous.Name())
-				} else {
-					c.executor.Enqueue("CheckoutBase")
-				}
-				first = false
-			}
-			c.executor.Enqueue("Rework", p.Name())
-			i++
-		} else {
-			if !first {
-				c.executor.Enqueue("Apply", p.Name())
-			} else {
-				previous = p
-			}
-		}
-	}
-	if err = c.executor.Enqueue("UpdateHead"); err != nil {
-		return nil, err
-	}
-	return c, nil
+	return c.usePlan(PlanRework(in, begin, selectors...))
 }
 
-func selectRevDepPatchsets(r *repo.Repo, selectors []TargetSelector) ([]*patchset.Patchset, error) {
-	patchsets, err := r.PatchsetCache()
-	if err != nil {
-		return nil, err
-	}
-	deps := dependency.NewStruct(patchsets)
-	b, err := ioutil.ReadFile("dependencies.json")
-	if err != nil {
-		log.Exitf(`Failed to read "dependencies.json": %v`, err)
-	}
-	err = json.Unmarshal(b, deps)
-	if err != nil {
-		log.Exitf(`Failed to load "dependencies.json": %v`, err)
-	}
+// revDepPatchsets returns the selected patchsets and those depending on them,
+// in branch order.
+func revDepPatchsets(in PlanInput, selectors []TargetSelector) []*patchset.Patchset {
+	patchsets, deps := in.Patchsets, in.Dependencies
+	selectors = withGraph(selectors, deps)
 	seen := map[string]struct{}{}
 	var selected []*patchset.Patchset
 	for _, p := range patchsets.Slice {
@@ -513,334 +1189,2034 @@ func selectRevDepPatchsets(r *repo.Repo, selectors []TargetSelector) ([]*patchse
 	sort.Slice(selected, func(i, j int) bool {
 		return patchsets.Index[selected[i].Name()] < patchsets.Index[selected[j].Name()]
 	})
-	return selected, err
+	return selected
 }
 
-// NewBeginBuildCommand returns a command that begins a new rework.
-func NewBeginBuildCommand(base string, selectors ...TargetSelector) (*Command, error) {
-	c, err := NewCommand()
+// NewRebaseCommand returns a command that rebuilds the branch on top of a new
+// base. Every patchset is replayed in order onto the new base, and the kilt
+// base is moved once the branch has been rebuilt, so that an aborted rebase
+// leaves the branch as it was.
+func NewRebaseCommand(onto string) (*Command, error) {
+	c, err := newReworkCommand()
 	if err != nil {
 		return nil, err
 	}
-
-	s := newStateFile(c.repo, "queue")
-
-	c.setWriter(s)
-	c.setReader(s)
-
-	registerBuildOperations(&c.executor, c.repo)
-
-	if err = c.executor.Enqueue("Begin"); err != nil {
-		return nil, err
-	}
-	selected, err := selectDependentPatchsets(c.repo, selectors)
+	base, err := c.repo.ResolveCommit(onto)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to resolve %q: %w", onto, err)
 	}
-	if err = c.executor.Enqueue("Checkout", base); err != nil {
+	if base == c.repo.KiltBase() {
+		return nil, fmt.Errorf("branch is already based on %s", onto)
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
 		return nil, err
 	}
-	for _, p := range selected {
-		if err = c.executor.Enqueue("Apply", p.Name()); err != nil {
+	items := []queue.Item{{Operation: "Begin"}, {Operation: "CheckoutRev", Args: []string{base}}}
+	for _, ps := range patchsets.Slice {
+		op := "Apply"
+		if len(ps.FloatingPatches()) > 0 || ps.MetadataCommit() == "" {
+			op = "Rework"
+		}
+		items = append(items, queue.Item{Operation: op, Args: []string{ps.Name()}})
+	}
+	items = append(items,
+		queue.Item{Operation: "UpdateHead"},
+		queue.Item{Operation: "MoveBase", Args: []string{base}},
+		queue.Item{Operation: "Finish"})
+	for _, item := range items {
+		if err = c.executor.Enqueue(item.Operation, item.Args...); err != nil {
 			return nil, err
 		}
 	}
-	if err = c.executor.Enqueue("UpdateHead"); err != nil {
-		return nil, err
-	}
-	if err = c.executor.Enqueue("Finish", base); err != nil {
-		return nil, err
-	}
 	return c, nil
 }
 
-func selectDependentPatchsets(r *repo.Repo, selectors []TargetSelector) ([]*patchset.Patchset, error) {
-	patchsets, err := r.PatchsetCache()
+// NewBeginBuildCommand returns a command that begins a new rework.
+func NewBeginBuildCommand(base string, selectors ...TargetSelector) (*Command, error) {
+	return NewBeginBuildCommandWithOptions(BuildOptions{Base: base}, selectors...)
+}
+
+// NewBeginSquashedBuildCommand is like NewBeginBuildCommand, but applies each
+// patchset as a single commit, with a message composed from the patchset's
+// metadata and the subjects of its patches.
+func NewBeginSquashedBuildCommand(base string, selectors ...TargetSelector) (*Command, error) {
+	return NewBeginBuildCommandWithOptions(BuildOptions{Base: base, Squash: true}, selectors...)
+}
+
+// NewBeginPartialBuildCommand is like NewBeginBuildCommand, but only applies
+// the patches of the last patchset of the build up to and including the patch
+// upTo, for bisecting within a patchset. If squash is set, each patchset is
+// applied as a single commit, as with NewBeginSquashedBuildCommand.
+func NewBeginPartialBuildCommand(base, upTo string, squash bool, selectors ...TargetSelector) (*Command, error) {
+	return NewBeginBuildCommandWithOptions(BuildOptions{Base: base, Squash: squash, UpTo: upTo}, selectors...)
+}
+
+// NewBeginWorktreeBuildCommand is like NewBeginPartialBuildCommand, but builds
+// in a new linked work tree at path instead of detaching HEAD in the current
+// one, which is left untouched. The build is continued or aborted from the new
+// work tree, which is kept once the build is finished. If upTo is empty, every
+// patch is applied.
+func NewBeginWorktreeBuildCommand(path, base, upTo string, squash bool, selectors ...TargetSelector) (*Command, error) {
+	return NewBeginBuildCommandWithOptions(BuildOptions{Base: base, Squash: squash, UpTo: upTo, Worktree: path}, selectors...)
+}
+
+// Orders of the patchsets applied by a build.
+const (
+	// OrderTopological applies the patchsets in dependency order, failing
+	// with ErrOrderMismatch if their branch order disagrees with it.
+	OrderTopological = "topo"
+	// OrderBranch applies the patchsets in branch order.
+	OrderBranch = "branch"
+)
+
+// BuildOptions configures a build.
+type BuildOptions struct {
+	// Options select the repo to build in and the reporter of the build.
+	Options
+	// Base is the revision the build starts from, and the branch set to its
+	// result.
+	Base string
+	// Squash applies each patchset as a single commit.
+	Squash bool
+	// UpTo, if set, is the patch up to and including which the last
+	// patchset is applied.
+	UpTo string
+	// Worktree, if set, is the path of a new linked work tree to build in.
+	Worktree string
+	// Order is the order the patchsets are applied in, OrderTopological if
+	// empty.
+	Order string
+}
+
+// ErrOrderMismatch indicates that a patchset to build precedes a patchset it
+// depends on in the branch, so the branch order is stale.
+type ErrOrderMismatch struct {
+	Patchset   string
+	Dependency string
+}
+
+func (e *ErrOrderMismatch) Error() string {
+	return fmt.Sprintf("patchset %q precedes its dependency %q in the branch; reorder the branch, or build in branch order with --order=branch", e.Patchset, e.Dependency)
+}
+
+// NewBeginBuildCommandWithOptions returns a command that begins a build of the
+// selected patchsets and those they depend on, configured by opts.
+func NewBeginBuildCommandWithOptions(opts BuildOptions, selectors ...TargetSelector) (*Command, error) {
+	c, err := NewCommandWithOptions(opts.Options)
 	if err != nil {
 		return nil, err
 	}
-	deps := dependency.NewStruct(patchsets)
-	b, err := ioutil.ReadFile("dependencies.json")
+	if opts.UpTo != "" {
+		if opts.UpTo, err = c.repo.ResolveCommit(opts.UpTo); err != nil {
+			return nil, fmt.Errorf("failed to resolve %q: %w", opts.UpTo, err)
+		}
+	}
+	in, err := LoadPlanInput(c.repo)
 	if err != nil {
-		log.Exitf(`Failed to read "dependencies.json": %v`, err)
+		return nil, err
 	}
-	err = json.Unmarshal(b, deps)
+	p, err := PlanBuild(in, opts, selectors...)
 	if err != nil {
-		log.Exitf(`Failed to load "dependencies.json": %v`, err)
+		return nil, err
 	}
-	seen := map[string]struct{}{}
-	var selected []*patchset.Patchset
-	for _, p := range patchsets.Slice {
-		for _, s := range selectors {
-			if _, ok := seen[p.Name()]; !ok && s.Select(p) {
-				seen[p.Name()] = struct{}{}
-				selected = append(selected, p)
-				ps := deps.TransitiveDependencies(p)
-				for _, patchset := range ps {
-					seen[patchset.Name()] = struct{}{}
+	return c.usePlan(p)
+}
+
+// registerWorktreeOperations registers the operations of builds in a linked
+// work tree, which are distinct from those of other builds so that they are
+// resumed correctly by the commands continuing any rework.
+func registerWorktreeOperations(c *Command) {
+	r := c.repo
+	var operations = []queue.Operation{
+		{
+			Name:   "BeginWorktree",
+			Params: noParams,
+			Execute: func(_ []string) error {
+				if err := startNewBuild(r, r.KiltBranch()); err != nil {
+					return err
 				}
-				selected = append(selected, ps...)
-			}
-		}
+				return writeWorktreeMarker(r)
+			},
+		},
+		{
+			Name:   "FinishWorktree",
+			Params: []queue.Param{{Name: "branch", Type: queue.String}},
+			Execute: func(branch []string) error {
+				if len(branch) == 0 {
+					return errors.New("no branch specified")
+				}
+				return finishBuild(r, branch[0])
+			},
+		},
+	}
+	for _, op := range operations {
+		c.executor.Register(op)
 	}
-	sort.Slice(selected, func(i, j int) bool {
-		return patchsets.Index[selected[i].Name()] < patchsets.Index[selected[j].Name()]
-	})
-	return selected, err
 }
 
-func startNewBuild(r *repo.Repo, branch string) error {
-	if exists, err := r.ReworkInProgress(); err != nil {
+// useWorktree creates a linked work tree at path, checked out at rev, and
+// switches the command to it.
+func (c *Command) useWorktree(path, rev string) error {
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
 		return err
 	} else if exists {
-		return fmt.Errorf("rework already in progress")
+		return errors.New("rework already in progress")
+	}
+	if err := c.repo.AddWorktree(path, rev); err != nil {
+		return fmt.Errorf("failed to create work tree: %w", err)
 	}
-	if err := r.WriteRefHead("rework/head"); err != nil {
+	w, err := c.repo.OpenWorktree(path)
+	if err != nil {
 		return err
 	}
-	if err := r.WriteSymbolicRefBranch("rework/branch", branch); err != nil {
+	if err := lockRepo(w); err != nil {
 		return err
 	}
-	return r.SetHead("rework/head")
+	c.repo = w
+	return nil
 }
 
-func startNewRework(r *repo.Repo) error {
-	if err := r.WriteRefHead("rework/head"); err != nil {
-		return err
+// topologicalOrder returns the patchsets, given in branch order, in dependency
+// order. If a patchset precedes one it depends on, ErrOrderMismatch is
+// returned.
+func topologicalOrder(deps *dependency.StructGraph, patchsets []*patchset.Patchset) ([]*patchset.Patchset, error) {
+	for i, p := range patchsets {
+		for _, later := range patchsets[i+1:] {
+			if dependsOn(deps, p, later, map[string]bool{}) {
+				return nil, &ErrOrderMismatch{Patchset: p.Name(), Dependency: later.Name()}
+			}
+		}
 	}
-	if err := r.WriteSymbolicRefHead("rework/branch"); err != nil {
-		return err
+	return deps.Order(patchsets)
+}
+
+// dependsOn reports whether ps depends on dep, directly or not.
+func dependsOn(deps *dependency.StructGraph, ps, dep *patchset.Patchset, seen map[string]bool) bool {
+	for _, d := range deps.Dependencies(ps) {
+		if d.SameAs(dep) {
+			return true
+		}
+		if id := d.UUID().String(); !seen[id] {
+			seen[id] = true
+			if dependsOn(deps, d, dep, seen) {
+				return true
+			}
+		}
 	}
-	return r.SetHead("rework/head")
+	return false
 }
 
-// NewFinishCommand returns a command that finishes a rework.
-func NewFinishCommand(force bool) (*Command, error) {
-	c, err := NewCommand()
-	if err != nil {
-		return nil, err
+// checkUpTo checks that the patch id belongs to the last of the patchsets.
+func checkUpTo(patchsets []*patchset.Patchset, id string) error {
+	if len(patchsets) == 0 {
+		return errors.New("no patchsets to build")
 	}
-	if exists, err := c.repo.ReworkInProgress(); err != nil {
-		return nil, err
-	} else if !exists {
-		return nil, fmt.Errorf("no rework in progress")
+	last := patchsets[len(patchsets)-1]
+	if !containsPatch(last.Patches(), id) {
+		return fmt.Errorf("patch %s is not in patchset %s, the last patchset of the build", id, last.Name())
 	}
-	registerOperations(&c.executor, c.repo)
-	if !force {
-		if err = c.executor.Enqueue("Validate"); err != nil {
-			return nil, err
+	return nil
+}
+
+// patchesUpTo returns the patches up to and including upTo, or all of them if
+// upTo isn't one of them.
+func patchesUpTo(patches []string, upTo string) []string {
+	for i, p := range patches {
+		if p == upTo {
+			return patches[:i+1]
 		}
 	}
-	if err = c.executor.Enqueue("Finish"); err != nil {
-		return nil, err
+	return patches
+}
+
+func containsPatch(patches []string, id string) bool {
+	for _, p := range patches {
+		if p == id {
+			return true
+		}
 	}
-	return c, nil
+	return false
 }
 
-func finishBuild(r *repo.Repo, branch string) error {
+// dependentPatchsets returns the selected patchsets and those they depend on,
+// in branch order. Patchsets that are only needed up to a patch through patch
+// dependencies are mapped to the last patch needed.
+func dependentPatchsets(in PlanInput, selectors []TargetSelector) ([]*patchset.Patchset, map[string]string, error) {
+	patchsets, deps := in.Patchsets, in.Dependencies
+	selectors = withGraph(selectors, deps)
+	var targets []*patchset.Patchset
+	for _, p := range patchsets.Slice {
+		for _, s := range selectors {
+			if s.Select(p) {
+				targets = append(targets, p)
+				break
+			}
+		}
+	}
+	need, err := deps.Prefixes(targets, in.PatchIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+	var selected []*patchset.Patchset
+	upTo := map[string]string{}
+	for _, p := range patchsets.Slice {
+		n, ok := need[p.Name()]
+		if !ok {
+			continue
+		}
+		selected = append(selected, p)
+		if n != dependency.All && n < len(p.Patches()) {
+			upTo[p.Name()] = p.Patches()[n-1]
+		}
+	}
+	return selected, upTo, nil
+}
+
+// patchIndex returns the position of the last patch of the patchset with the
+// given subject.
+func patchIndex(r *repo.Repo, ps *patchset.Patchset, subject string) (int, error) {
+	patches := ps.Patches()
+	for i := len(patches) - 1; i >= 0; i-- {
+		info, err := r.CommitInfo(patches[i])
+		if err != nil {
+			return 0, err
+		}
+		if info.Summary == subject {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no patch %q in patchset %s", subject, ps.Name())
+}
+
+func startNewBuild(r *repo.Repo, branch string) error {
 	if exists, err := r.ReworkInProgress(); err != nil {
 		return err
-	} else if !exists {
-		return fmt.Errorf("no rework in progress")
+	} else if exists {
+		return fmt.Errorf("rework already in progress")
 	}
-	if err := r.SetBranchToHead(branch); err != nil {
+	if err := r.WriteRefHead(r.ReworkRef("head")); err != nil {
 		return err
 	}
-	if err := r.CheckoutBranch(branch); err != nil {
+	if err := r.WriteSymbolicRefBranch(r.ReworkRef("branch"), branch); err != nil {
 		return err
 	}
-	cleanupReworkState(r)
-	return nil
-}
-
-func finishRework(r *repo.Repo) error {
-	if err := r.SetIndirectBranchToHead("rework/branch"); err != nil {
+	if err := r.SetActiveRework(); err != nil {
 		return err
 	}
-	if err := r.CheckoutIndirectBranch("rework/branch"); err != nil {
+	return r.SetHead(r.ReworkRef("head"))
+}
+
+// sessionFile is the rework state file holding the name of the rework.
+const sessionFile = "name"
+
+// worktreeFile is the rework state file marking a build in a linked work tree,
+// whose head is detached rather than returned to the kilt branch on abort.
+const worktreeFile = "worktree"
+
+func writeWorktreeMarker(r *repo.Repo) error {
+	if err := os.MkdirAll(r.ReworkDirectory(), 0777); err != nil {
 		return err
 	}
-	cleanupReworkState(r)
-	return nil
+	return ioutil.WriteFile(filepath.Join(r.ReworkDirectory(), worktreeFile), nil, 0666)
+}
+
+func worktreeBuild(r *repo.Repo) bool {
+	_, err := os.Stat(filepath.Join(r.ReworkDirectory(), worktreeFile))
+	return err == nil
+}
+
+// SetName names the rework begun by the command, describing what it is for.
+// The name is kept until the rework is finished or aborted.
+func (c *Command) SetName(name string) error {
+	if strings.ContainsAny(name, "\n") {
+		return errors.New("rework name must be a single line")
+	}
+	var q queue.Queue
+	found := false
+	for _, item := range c.executor.Queue().Items {
+		if item.Operation == "Begin" && !found {
+			item = queue.Item{Operation: item.Operation, Args: []string{name}}
+			found = true
+		}
+		q.Items = append(q.Items, item)
+	}
+	if !found {
+		return errors.New("only a new rework can be named")
+	}
+	return c.executor.ReplaceQueue(q)
+}
+
+// SessionName returns the name of the rework in progress, or an empty string
+// if it wasn't named.
+func SessionName(r *repo.Repo) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(r.ReworkDirectory(), sessionFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeSessionName(r *repo.Repo, name string) error {
+	if err := repo.Writable("write rework state"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.ReworkDirectory(), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.ReworkDirectory(), sessionFile), []byte(name+"\n"), 0666)
+}
+
+func startNewRework(r *repo.Repo) error {
+	if err := r.WriteRefHead(r.ReworkRef("head")); err != nil {
+		return err
+	}
+	if err := r.WriteSymbolicRefHead(r.ReworkRef("branch")); err != nil {
+		return err
+	}
+	if err := r.SetActiveRework(); err != nil {
+		return err
+	}
+	return r.SetHead(r.ReworkRef("head"))
+}
+
+// Cascade modes for deleting patchsets that other patchsets depend on.
+const (
+	// CascadeEdges removes the dependencies on the deleted patchset.
+	CascadeEdges = "edges"
+	// CascadeDependents deletes the dependent patchsets as well.
+	CascadeDependents = "dependents"
+)
+
+// ErrHasDependents indicates that a patchset can't be removed because other
+// patchsets depend on it.
+type ErrHasDependents struct {
+	Patchset   string
+	Dependents []string
+}
+
+func (e *ErrHasDependents) Error() string {
+	return fmt.Sprintf("patchset %q is depended on by %s; use --cascade to remove the dependencies or the dependents", e.Patchset, strings.Join(e.Dependents, ", "))
+}
+
+// Dependents returns the names of the patchsets that depend on the named
+// patchset, directly or transitively, in branch order.
+func Dependents(name string) ([]string, error) {
+	r, err := repo.Open()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := patchsets.Map[name]
+	if !ok {
+		return nil, fmt.Errorf("patchset %q not found", name)
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, d := range transitiveDependents(patchsets, deps, p) {
+		names = append(names, d.Name())
+	}
+	return names, nil
+}
+
+// transitiveDependents returns the patchsets that depend on p, directly or
+// transitively, in branch order.
+func transitiveDependents(patchsets repo.PatchsetCache, deps *dependency.StructGraph, p *patchset.Patchset) []*patchset.Patchset {
+	seen := map[string]bool{p.Name(): true}
+	var dependents []*patchset.Patchset
+	for todo := []*patchset.Patchset{p}; len(todo) > 0; todo = todo[1:] {
+		for _, d := range deps.Dependents(todo[0]) {
+			if !seen[d.Name()] {
+				seen[d.Name()] = true
+				dependents = append(dependents, d)
+				todo = append(todo, d)
+			}
+		}
+	}
+	sort.Slice(dependents, func(i, j int) bool {
+		return patchsets.Index[dependents[i].Name()] < patchsets.Index[dependents[j].Name()]
+	})
+	return dependents
+}
+
+// NewDeleteCommand returns a command that deletes the named patchset, rewriting
+// the branch without its metadata commit. If rehome is set, the patches of the
+// deleted patchset are reassigned to the rehome patchset, which must precede
+// it in the branch, otherwise the patches are dropped. If other patchsets
+// depend on the patchset, cascade must be CascadeEdges or CascadeDependents,
+// otherwise an *ErrHasDependents is returned. The deleted patchsets are removed
+// from the dependency graph once the rework finishes.
+func NewDeleteCommand(name, rehome, cascade string) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := patchsets.Map[name]
+	if !ok || p.MetadataCommit() == "" {
+		return nil, fmt.Errorf("patchset %q not found", name)
+	}
+	deps, err := dependency.Load(c.repo)
+	if err != nil {
+		return nil, err
+	}
+	dependents := transitiveDependents(patchsets, deps, p)
+	if len(dependents) > 0 {
+		switch cascade {
+		case "":
+			e := &ErrHasDependents{Patchset: name}
+			for _, d := range dependents {
+				e.Dependents = append(e.Dependents, d.Name())
+			}
+			return nil, e
+		case CascadeEdges:
+			// Deleting a patchset removes the dependencies on it.
+			dependents = nil
+		case CascadeDependents:
+			for _, d := range dependents {
+				if d.Name() == rehome {
+					return nil, fmt.Errorf("can't rehome patches to %q, which depends on %q", rehome, name)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown cascade mode %q", cascade)
+		}
+	}
+	index := patchsets.Index[name]
+	ops := map[int]queue.Item{
+		index: {Operation: "Delete", Args: []string{name}},
+	}
+	if rehome != "" {
+		if _, ok := patchsets.Map[rehome]; !ok {
+			return nil, fmt.Errorf("patchset %q not found", rehome)
+		}
+		if patchsets.Index[rehome] >= index {
+			return nil, fmt.Errorf("patchset %q must precede %q to receive its patches", rehome, name)
+		}
+		// As with kilt move, the patches follow those of the rehome
+		// patchset.
+		patches := append(append([]string{}, p.Patches()...), p.FloatingPatches()...)
+		ops[patchsets.Index[rehome]] = queue.Item{Operation: "Gather", Args: append([]string{rehome}, patches...)}
+	}
+	deleted := []string{name}
+	for _, d := range dependents {
+		ops[patchsets.Index[d.Name()]] = queue.Item{Operation: "Delete", Args: []string{d.Name()}}
+		deleted = append(deleted, d.Name())
+	}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if rehome != "" && len(dependents) == 0 {
+		if err = c.executor.Enqueue("Validate"); err != nil {
+			return nil, err
+		}
+	}
+	if err = c.executor.Enqueue("DeleteDependencies", deleted...); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// enqueueRebuild enqueues the operations to rebuild the branch from the first
+// patchset in ops, where the patchsets at the positions in ops are handled by
+// the given operations instead of being applied. An operation at the position
+// past the last patchset runs after the last patchset. Rebuilding starts
+// earlier if a preceding patchset has floating patches, as those need to be
+// reworked to keep the branch consistent.
+func (c *Command) enqueueRebuild(patchsets repo.PatchsetCache, ops map[int]queue.Item) error {
+	start := len(patchsets.Slice)
+	for i := range ops {
+		if i < start {
+			start = i
+		}
+	}
+	for i, ps := range patchsets.Slice[:start] {
+		if len(ps.FloatingPatches()) > 0 {
+			start = i
+			break
+		}
+	}
+	if err := c.executor.Enqueue("Begin"); err != nil {
+		return err
+	}
+	checkout := queue.Item{Operation: "CheckoutBase"}
+	if start > 0 {
+		checkout = queue.Item{Operation: "Checkout", Args: []string{patchsets.Slice[start-1].Name()}}
+	}
+	if err := c.executor.Enqueue(checkout.Operation, checkout.Args...); err != nil {
+		return err
+	}
+	for i, ps := range patchsets.Slice[start:] {
+		op, ok := ops[start+i]
+		switch {
+		case ok:
+		case len(ps.FloatingPatches()) > 0 || ps.MetadataCommit() == "":
+			op = queue.Item{Operation: "Rework", Args: []string{ps.Name()}}
+		default:
+			op = queue.Item{Operation: "Apply", Args: []string{ps.Name()}}
+		}
+		if err := c.executor.Enqueue(op.Operation, op.Args...); err != nil {
+			return err
+		}
+	}
+	if op, ok := ops[len(patchsets.Slice)]; ok {
+		if err := c.executor.Enqueue(op.Operation, op.Args...); err != nil {
+			return err
+		}
+	}
+	return c.executor.Enqueue("UpdateHead")
+}
+
+// NewRenameCommand returns a command that renames a patchset through a rework,
+// updating its metadata, the footers of its patches and its dependencies.
+func NewRenameCommand(name, newName string) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	if !repo.ValidPatchsetName(newName) {
+		return nil, fmt.Errorf("invalid patchset name %q", newName)
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := patchsets.Map[name]
+	if !ok || p.MetadataCommit() == "" {
+		return nil, fmt.Errorf("patchset %q not found", name)
+	}
+	if other, ok := patchsets.Lookup(newName); ok && other != p {
+		return nil, fmt.Errorf("patchset %q already exists", other.Name())
+	}
+	if err = c.enqueueRebuild(patchsets, map[int]queue.Item{
+		patchsets.Index[name]: {Operation: "Rename", Args: []string{name, newName}},
+	}); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("RenameDependencies", name, newName); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ErrNoChanges is returned when editing patchset metadata without changing it.
+var ErrNoChanges = errors.New("no changes made")
+
+// NewEditCommand returns a command that replaces the metadata of the patchset
+// with the name, description and fields returned by edit, through a rework
+// which creates a metadata commit with a new version.
+func NewEditCommand(name string, edit func(text []byte) ([]byte, error)) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := patchsets.Map[name]
+	if !ok || p.MetadataCommit() == "" {
+		return nil, fmt.Errorf("patchset %q not found", name)
+	}
+	text, err := edit(repo.EditableMetadata(p))
+	if err != nil {
+		return nil, err
+	}
+	edited, err := repo.ParseEditedMetadata(p, text)
+	if err != nil {
+		return nil, err
+	}
+	if repo.SameMetadata(p, edited) {
+		return nil, ErrNoChanges
+	}
+	if other, ok := patchsets.Lookup(edited.Name()); ok && other != p {
+		return nil, fmt.Errorf("patchset %q already exists", other.Name())
+	}
+	template, err := c.repo.CreateMetadataTemplate(edited, p.MetadataCommit())
+	if err != nil {
+		return nil, err
+	}
+	if err = c.enqueueRebuild(patchsets, map[int]queue.Item{
+		patchsets.Index[name]: {Operation: "Edit", Args: []string{name, template}},
+	}); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if edited.Name() != name {
+		if err = c.executor.Enqueue("RenameDependencies", name, edited.Name()); err != nil {
+			return nil, err
+		}
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// editPatchset reworks the patchset with a new metadata commit based on the
+// template, reassigning its patches if the template renames it. As with
+// adoptPatchset, the dependency graph is renamed once the rework finishes.
+func (c *Command) editPatchset(name, template string) error {
+	r := c.repo
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets[name]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	edited, err := r.PatchsetFromCommit(template)
+	if err != nil {
+		return err
+	}
+	newName := edited.Name()
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		if err := e.Enqueue("UpdateMetadata", template); err != nil {
+			return err
+		}
+		for _, patch := range p.Patches() {
+			if newName != name {
+				if err := e.Enqueue("Reassign", patch, newName); err != nil {
+					return err
+				}
+			} else {
+				if err := e.Enqueue("Apply", patch); err != nil {
+					return err
+				}
+			}
+		}
+		for _, patch := range p.FloatingPatches() {
+			if newName != name {
+				if err := e.Enqueue("Reassign", patch, newName); err != nil {
+					return err
+				}
+			} else {
+				if err := e.Enqueue("Cherrypick", patch); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Adoption describes a patchset adopting the name and UUID of another patchset.
+type Adoption struct {
+	Patchset string
+	Name     string
+	UUID     string
+}
+
+// NewAdoptCommand returns a command that gives the patchsets the names and
+// UUIDs of their adoptions through a rework.
+func NewAdoptCommand(adoptions []Adoption) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	if len(adoptions) == 0 {
+		return nil, errors.New("no patchsets to adopt")
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	ops := map[int]queue.Item{}
+	var renames []string
+	for _, a := range adoptions {
+		p, ok := patchsets.Map[a.Patchset]
+		if !ok || p.MetadataCommit() == "" {
+			return nil, fmt.Errorf("patchset %q not found", a.Patchset)
+		}
+		if other, ok := patchsets.Lookup(a.Name); ok && other != p {
+			return nil, fmt.Errorf("patchset %q already exists", other.Name())
+		}
+		ops[patchsets.Index[a.Patchset]] = queue.Item{Operation: "Adopt", Args: []string{a.Patchset, a.Name, a.UUID}}
+		if a.Name != a.Patchset {
+			renames = append(renames, a.Patchset, a.Name)
+		}
+	}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if len(renames) > 0 {
+		if err = c.executor.Enqueue("RenameDependencies", renames...); err != nil {
+			return nil, err
+		}
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// renameDependencies renames patchsets in the dependency graph of the finished
+// branch, given as pairs of old and new names.
+func renameDependencies(r *repo.Repo, args []string) error {
+	if len(args)%2 != 0 {
+		return errors.New("renames must be pairs of old and new names")
+	}
+	renames := map[string]string{}
+	for i := 0; i < len(args); i += 2 {
+		renames[args[i]] = args[i+1]
+	}
+	return dependency.Rename(r, renames)
+}
+
+// adoptPatchset recreates the metadata and patches of the patchset under the
+// new name and, unless empty, the new UUID. The dependency graph is renamed by
+// RenameDependencies once the rework finishes, as the graph has to match the
+// original branch until then.
+func (c *Command) adoptPatchset(name, newName, uuid string) error {
+	patchsets, err := c.repo.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets[name]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		args := []string{p.MetadataCommit(), newName}
+		if uuid != "" {
+			args = append(args, uuid)
+		}
+		if err := e.Enqueue("RenameMetadata", args...); err != nil {
+			return err
+		}
+		for _, patch := range p.Patches() {
+			if newName != name {
+				if err := e.Enqueue("Reassign", patch, newName); err != nil {
+					return err
+				}
+			} else {
+				if err := e.Enqueue("Apply", patch); err != nil {
+					return err
+				}
+			}
+		}
+		for _, patch := range p.FloatingPatches() {
+			if newName != name {
+				if err := e.Enqueue("Reassign", patch, newName); err != nil {
+					return err
+				}
+			} else {
+				if err := e.Enqueue("Cherrypick", patch); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// NewMoveCommand returns a command that moves the given commits to the target
+// patchset through a rework, reassigning them and placing them after the
+// patches of the target patchset.
+func NewMoveCommand(commits []string, target string) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := patchsets.Map[target]
+	if !ok || t.MetadataCommit() == "" {
+		return nil, fmt.Errorf("patchset %q not found", target)
+	}
+	if t.Archived() {
+		return nil, fmt.Errorf("patchset %q is archived", target)
+	}
+	moving := map[string]bool{}
+	for _, commit := range commits {
+		id, err := c.repo.ResolveCommit(commit)
+		if err != nil {
+			return nil, err
+		}
+		moving[id] = true
+	}
+	ops := map[int]queue.Item{}
+	var moved []string
+	for i, ps := range patchsets.Slice {
+		patches := append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...)
+		for _, patch := range patches {
+			if !moving[patch] {
+				continue
+			}
+			if ps.Name() == target {
+				return nil, fmt.Errorf("commit %s already belongs to patchset %q", patch, target)
+			}
+			op, ok := ops[i]
+			if !ok {
+				op = queue.Item{Operation: "Release", Args: []string{ps.Name()}}
+			}
+			op.Args = append(op.Args, patch)
+			ops[i] = op
+			moved = append(moved, patch)
+			delete(moving, patch)
+		}
+	}
+	for commit := range moving {
+		return nil, fmt.Errorf("commit %s is not a patch in the kilt branch", commit)
+	}
+	ops[patchsets.Index[target]] = queue.Item{Operation: "Gather", Args: append([]string{target}, moved...)}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewFinishCommand returns a command that finishes a rework.
+func NewFinishCommand(force bool) (*Command, error) {
+	return NewFinishCommandWithOptions(Options{}, force)
+}
+
+// NewFinishCommandWithOptions is like NewFinishCommand, with the repo and
+// reporter selected by opts.
+func NewFinishCommandWithOptions(opts Options, force bool) (*Command, error) {
+	c, err := NewCommandWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("no rework in progress")
+	}
+	registerOperations(c)
+	if !force {
+		if err = c.executor.Enqueue("Validate"); err != nil {
+			return nil, err
+		}
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewUndoCommand returns a command that restores the kilt branch and base to
+// their state before the most recently finished rework. Unless force is set,
+// the branch must not have changed since the rework was finished.
+func NewUndoCommand(force bool) (*Command, error) {
+	c, err := NewCommand()
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("rework in progress, abort it before undoing a finished rework")
+	}
+	c.executor.Register(queue.Operation{
+		Name:   "Undo",
+		Params: noParams,
+		Execute: func(_ []string) error {
+			return c.undoRework(force)
+		},
+	})
+	if err = c.executor.Enqueue("Undo"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Command) undoRework(force bool) error {
+	r := c.repo
+	backups, err := r.Backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("no finished rework of %s to undo", r.KiltBranch())
+	}
+	b := backups[0]
+	c.report("Undo", "Restoring %s to %.7s, before the rework finished at %.7s", r.KiltBranch(), b.Branch, b.Result)
+	return r.RestoreBackup(b, force)
+}
+
+func finishBuild(r *repo.Repo, branch string) error {
+	if exists, err := r.ReworkInProgress(); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("no rework in progress")
+	}
+	if err := r.SetBranchToHead(branch); err != nil {
+		return err
+	}
+	if err := r.CheckoutBranch(branch); err != nil {
+		return err
+	}
+	cleanupReworkState(r)
+	return nil
+}
+
+// finishRework moves the branch to the reworked head and applies the
+// dependency updates recorded during the rework, before removing the rework
+// state. If the updates fail, the rework can be continued to finish again.
+func (c *Command) finishRework() error {
+	r := c.repo
+	if err := r.SaveBackup(); err != nil {
+		return fmt.Errorf("failed to back up branch: %w", err)
+	}
+	if err := carryNotes(r); err != nil {
+		log.Warningf("Failed to carry patch notes forward: %v", err)
+	}
+	if err := r.SetIndirectBranchToHead(r.ReworkRef("branch")); err != nil {
+		return err
+	}
+	if err := r.CheckoutIndirectBranch(r.ReworkRef("branch")); err != nil {
+		return err
+	}
+	if err := c.applyDependencyUpdates(); err != nil {
+		return err
+	}
+	cleanupReworkState(r)
+	return writeVersionRefs(r)
+}
+
+// carryNotes re-attaches the kilt notes of the patches of the branch to the
+// reworked patches making the same changes.
+func carryNotes(r *repo.Repo) error {
+	branch, err := r.OpenBranchView()
+	if err != nil {
+		return err
+	}
+	from, err := allPatches(branch)
+	if err != nil {
+		return err
+	}
+	to, err := allPatches(r)
+	if err != nil {
+		return err
+	}
+	return r.CarryPatchNotes(from, to)
+}
+
+func allPatches(r *repo.Repo) ([]string, error) {
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return nil, err
+	}
+	var patches []string
+	for _, ps := range patchsets {
+		patches = append(append(patches, ps.Patches()...), ps.FloatingPatches()...)
+	}
+	return patches, nil
+}
+
+// writeVersionRefs records the version of each patchset on the reworked branch
+// as a ref, if enabled with the kilt.versionRefs config option, and updates
+// the boundary refs of the patchsets unless disabled with kilt.boundaryRefs.
+func writeVersionRefs(r *repo.Repo) error {
+	// The rework head is gone, so reopen the repo to walk the finished branch.
+	r, err := r.Reopen()
+	if err != nil {
+		return err
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return err
+	}
+	if enabled, err := r.ConfigBool(boundaryRefsConfig, true); err != nil {
+		return err
+	} else if enabled {
+		if err := r.WriteBoundaryRefs(patchsets); err != nil {
+			return err
+		}
+	}
+	if enabled, err := r.ConfigBool(versionRefsConfig, false); err != nil || !enabled {
+		return err
+	}
+	for _, ps := range patchsets {
+		if ps.MetadataCommit() == "" {
+			continue
+		}
+		if err := r.WritePatchsetVersionRef(ps); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // NewAbortCommand returns a command that aborts an in-progress rework.
 func NewAbortCommand() (*Command, error) {
+	return NewAbortCommandWithOptions(Options{})
+}
+
+// NewAbortCommandWithOptions is like NewAbortCommand, with the repo and
+// reporter selected by opts.
+func NewAbortCommandWithOptions(opts Options) (*Command, error) {
+	c, err := NewCommandWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	s := newStateFile(c.repo, reworkQueueName)
+
+	c.setWriter(s)
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("no rework in progress")
+	}
+	registerOperations(c)
+	if err = c.executor.Enqueue("Abort"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// abortRework restores the branch the rework started from. Changes left in the
+// index and work tree by a failed operation are discarded first, and the rework
+// state is only removed once the work tree is verified to match the branch, so
+// a failed abort can be retried. The dependency graph is only updated when a
+// rework finishes, so it is left as it is.
+func abortRework(r *repo.Repo) error {
+	if err := r.ResetToHead(); err != nil {
+		return fmt.Errorf("failed to reset work tree: %w", err)
+	}
+	if worktreeBuild(r) {
+		// The kilt branch is checked out in the main work tree, so the
+		// linked one is left detached where the build stopped.
+		if err := r.DetachHead(); err != nil {
+			return err
+		}
+	} else if err := r.CheckoutIndirectBranch(r.ReworkRef("branch")); err != nil {
+		return err
+	}
+	if clean, err := r.WorkTreeClean(); err != nil {
+		return err
+	} else if !clean {
+		return errors.New("work tree doesn't match the branch after checkout")
+	}
+	if err := clearReworkQueues(r); err != nil {
+		return err
+	}
+	if err := r.RestoreBase(); err != nil {
+		return err
+	}
+	cleanupReworkState(r)
+	return nil
+}
+
+// clearReworkQueues removes the saved queues of the rework.
+func clearReworkQueues(r *repo.Repo) error {
+	for _, name := range []string{reworkQueueName, buildQueueName, patchsetQueueName, patchsetPlanName, dependencyQueueName} {
+		s := newStateFile(r, name)
+		if err := s.ClearQueueState(); err != nil {
+			return err
+		}
+		if err := s.ClearCurrentState(); err != nil {
+			return err
+		}
+		if err := s.ClearDoneState(); err != nil {
+			return err
+		}
+		if err := s.ClearProgress(); err != nil {
+			return err
+		}
+		if err := s.clearHistory(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrInvalidRework indicates that the rework is invalid and the trees don't match.
+type ErrInvalidRework struct {
+	original, reworked string
+}
+
+func (e *ErrInvalidRework) Error() string {
+	return fmt.Sprintf("rework tree doesn't match: git diff-tree -p %s %s", e.original, e.reworked)
+}
+
+// NewValidateCommand returns a command that checks the validity of the rework.
+func NewValidateCommand() (*Command, error) {
+	c, err := NewCommand()
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("no rework in progress")
+	}
+	registerOperations(c)
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func validateRework(r *repo.Repo) (bool, error) {
+	return r.CompareTreeToHead(r.ReworkRef("branch"))
+}
+
+func newStateFile(r *repo.Repo, name string) *stateFile {
+	return &stateFile{
+		path:   r.ReworkDirectory(),
+		name:   name,
+		branch: r.KiltBranch(),
+	}
+}
+
+// outerQueue returns the state file of the outer queue of the rework in
+// progress, which is the build queue while a build is in progress.
+func outerQueue(r *repo.Repo) *stateFile {
+	if queueExists(r, buildQueueName) {
+		return newStateFile(r, buildQueueName)
+	}
+	return newStateFile(r, reworkQueueName)
+}
+
+// useOuterQueue sets the command up to read and write the outer queue of the
+// rework in progress, and registers the operations it may hold.
+func (c *Command) useOuterQueue() {
+	state := outerQueue(c.repo)
+	c.setWriter(state)
+	c.setReader(state)
+	if state.name == buildQueueName {
+		registerBuildOperations(c)
+	} else {
+		registerOperations(c)
+	}
+}
+
+// RemainingWork returns the operations queued for the rework in progress.
+func RemainingWork(r *repo.Repo) (queue.Queue, error) {
+	_, q, err := readRecoveredState(outerQueue(r))
+	return q, err
+}
+
+// OperationProgress returns the count of complete operations of the rework in
+// progress, or nil if it isn't known.
+func OperationProgress(r *repo.Repo) (*reporter.Progress, error) {
+	p, err := outerQueue(r).ReadProgress()
+	if err != nil || p.Total == 0 {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Status writes the status of the rework to w.
+func Status(w io.Writer, r *repo.Repo) error {
+	q, err := RemainingWork(r)
+	if err != nil {
+		return err
+	}
+	skipped, err := SkippedWork(r)
+	if err != nil {
+		return err
+	}
+	if name, err := SessionName(r); err != nil {
+		return err
+	} else if name != "" {
+		fmt.Fprintf(w, "Rework: %s\n", name)
+	}
+	if len(skipped.Items) > 0 {
+		fmt.Fprintln(w, "Skipped work:")
+		for _, item := range skipped.Items {
+			fmt.Fprintf(w, "\t%s\n", describeItem(item))
+		}
+	}
+	progress, err := NestedProgress(r)
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		state := "next"
+		if progress.Failed {
+			state = "failed"
+		}
+		fmt.Fprintln(w, "In progress:")
+		fmt.Fprintf(w, "\t%s, %s\n", progress, state)
+	}
+	if operations, err := OperationProgress(r); err != nil {
+		return err
+	} else if operations != nil {
+		fmt.Fprintln(w, operations)
+	}
+	if len(q.Items) > 0 {
+		fmt.Fprintln(w, "Remaining work:")
+		for _, item := range q.Items {
+			fmt.Fprintf(w, "\t%s %s\n", item.Operation, strings.Join(item.Args, " "))
+		}
+		fmt.Fprintln(w, `Use kilt rework --continue to perform the next operation, or use
+kilt rework --skip to skip it, discarding its changes. To perform an operation
+manually, commit the result and then skip it.`)
+	} else {
+		fmt.Fprintln(w, "All work complete. Use kilt rework --finish to validate and finish the rework.")
+	}
+	return nil
+}
+
+// VerifyState checks that the saved rework state of the current kilt branch is
+// consistent, returning a description of each problem found. State left by an
+// interrupted process, which continuing the rework recovers from, is not a
+// problem.
+func VerifyState() ([]string, error) {
 	c, err := NewCommand()
 	if err != nil {
 		return nil, err
 	}
-	s := newStateFile(c.repo, "queue")
+	r := c.repo
+	outer := outerQueue(r).name
+	if outer == buildQueueName {
+		registerBuildOperations(c)
+	} else {
+		registerOperations(c)
+	}
+	n, err := NewCommand()
+	if err != nil {
+		return nil, err
+	}
+	registerReworkOperations(n)
+
+	var problems []string
+	inProgress, err := r.ReworkInProgress()
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.LookupKiltRef(r.ReworkRef("head"))
+	if err != nil {
+		return nil, err
+	}
+	if inProgress && head == "" {
+		problems = append(problems, "rework in progress without a rework head ref")
+	} else if !inProgress && head != "" {
+		problems = append(problems, "rework head ref without a rework in progress")
+	}
+	failed := map[string]queue.Queue{}
+	for _, state := range []struct {
+		name string
+		c    *Command
+	}{
+		{outer, c},
+		{patchsetQueueName, n},
+	} {
+		s := newStateFile(r, state.name)
+		current, err := s.ReadCurrentState()
+		if err != nil {
+			return nil, err
+		}
+		done, err := s.ReadDoneState()
+		if err != nil {
+			return nil, err
+		}
+		q, err := s.ReadState()
+		if err != nil {
+			return nil, err
+		}
+		if !inProgress {
+			// A process interrupted before beginning leaves a queue
+			// starting with Begin, which is replaced by the next rework.
+			interrupted := len(q.Items) > 0 && q.Items[0].Operation == "Begin"
+			if len(current.Items)+len(done.Items) > 0 || len(q.Items) > 0 && !interrupted {
+				problems = append(problems, fmt.Sprintf("%s state saved without a rework in progress", state.name))
+			}
+			continue
+		}
+		registered := map[string]bool{}
+		for _, op := range state.c.executor.Operations() {
+			registered[op] = true
+		}
+		for _, item := range append(append(current.Items, done.Items...), q.Items...) {
+			if !registered[item.Operation] {
+				problems = append(problems, fmt.Sprintf("%s has unknown operation %q", state.name, item.Operation))
+			}
+		}
+		for _, item := range current.Items {
+			if registered[item.Operation] && !state.c.executor.Resumable(item.Operation) {
+				problems = append(problems, fmt.Sprintf("%s has non-resumable current operation %q", state.name, item.Operation))
+			}
+		}
+		failed[state.name], _ = recoverQueue(current, done, q)
+	}
+	if nested := failed[patchsetQueueName].Items; len(nested) > 0 && len(failed[outer].Items) == 0 {
+		problems = append(problems, fmt.Sprintf("patchset operation %s failed without a failed rework operation to resume it", describeItem(nested[0])))
+	}
+	return problems, nil
+}
+
+// NewContinueCommand returns a command that continues with saved rework steps.
+func NewContinueCommand() (*Command, error) {
+	return NewContinueCommandWithOptions(Options{})
+}
+
+// NewContinueCommandWithOptions is like NewContinueCommand, with the repo and
+// reporter selected by opts.
+func NewContinueCommandWithOptions(opts Options) (*Command, error) {
+	c, err := NewCommandWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 
-	c.setWriter(s)
 	if exists, err := c.repo.ReworkInProgress(); err != nil {
 		return nil, err
 	} else if !exists {
 		return nil, fmt.Errorf("no rework in progress")
 	}
-	registerOperations(&c.executor, c.repo)
-	if err = c.executor.Enqueue("Abort"); err != nil {
+
+	c.useOuterQueue()
+
+	if err = c.repo.RecordResolutions(); err != nil {
 		return nil, err
 	}
+	if err = continueRework(c); err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
 
-func abortRework(r *repo.Repo) error {
-	if err := r.CheckoutIndirectBranch("rework/branch"); err != nil {
-		return err
-	}
-	cleanupReworkState(r)
-	return nil
+// NewSkipCommand returns a command that skips the failed rework operation, or
+// the next queued operation if none failed, and continues the rework. Changes
+// left in the index and work tree by the skipped operation are discarded, and
+// the operation is recorded so it can be reported by status. If no operation
+// failed, changes in the work tree are not the rework's to discard, so the
+// work tree must be clean.
+func NewSkipCommand() (*Command, error) {
+	return NewSkipCommandWithOptions(Options{})
 }
 
-// ErrInvalidRework indicates that the rework is invalid and the trees don't match.
-type ErrInvalidRework struct {
-	original, reworked string
+// NewSkipCommandWithOptions is like NewSkipCommand, with the repo and reporter
+// selected by opts, which receives the message of the skipped operation.
+func NewSkipCommandWithOptions(opts Options) (*Command, error) {
+	c, err := NewCommandWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("no rework in progress")
+	}
+
+	c.useOuterQueue()
+
+	skipped, q, err := popFailed(c)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.repo.ResetToHead(); err != nil {
+		return nil, fmt.Errorf("failed to reset work tree: %w", err)
+	}
+	if err = recordSkipped(c.repo, skipped); err != nil {
+		return nil, err
+	}
+	c.report("Skip", "Skipped %s", describeItem(skipped))
+	c.executor.LoadQueue(q)
+
+	return c, nil
 }
 
-func (e *ErrInvalidRework) Error() string {
-	return fmt.Sprintf("rework tree doesn't match: git diff-tree -p %s %s", e.original, e.reworked)
+// popFailed removes the failed rework operation, or the next queued operation
+// if none failed, from the saved state, returning it and the queue following
+// it. The command is loaded with the rest of the failed operation's patchset,
+// if any.
+func popFailed(c *Command) (queue.Item, queue.Queue, error) {
+	current, q, err := readRecoveredState(c.reader)
+	if err != nil {
+		return queue.Item{}, queue.Queue{}, err
+	}
+	nested := newStateFile(c.repo, patchsetQueueName)
+	nestedCurrent, nestedQueue, err := readRecoveredState(nested)
+	if err != nil {
+		return queue.Item{}, queue.Queue{}, err
+	}
+	var item queue.Item
+	switch {
+	case len(nestedCurrent.Items) > 0:
+		// Remove the failed operation of the patchset, resuming the rest of
+		// the patchset unless nothing of it is left.
+		item = nestedCurrent.Items[0]
+		if err = nested.ClearCurrentState(); err != nil {
+			return queue.Item{}, queue.Queue{}, err
+		}
+		if len(nestedQueue.Items) > 0 {
+			c.executor.LoadQueue(current)
+		} else if err = c.writer.ClearCurrentState(); err != nil {
+			return queue.Item{}, queue.Queue{}, err
+		}
+	case len(current.Items) > 0 && len(nestedQueue.Items) > 0:
+		// Remove the next operation of the patchset being worked on.
+		item, _ = nestedQueue.Pop()
+		if err = nested.WriteQueueState(nestedQueue); err != nil {
+			return queue.Item{}, queue.Queue{}, err
+		}
+		if len(nestedQueue.Items) > 0 {
+			c.executor.LoadQueue(current)
+		} else if err = c.writer.ClearCurrentState(); err != nil {
+			return queue.Item{}, queue.Queue{}, err
+		}
+	case len(current.Items) > 0:
+		item = current.Items[0]
+		if err = c.writer.ClearCurrentState(); err != nil {
+			return queue.Item{}, queue.Queue{}, err
+		}
+	default:
+		if clean, err := c.repo.WorkTreeClean(); err != nil {
+			return queue.Item{}, queue.Queue{}, err
+		} else if !clean {
+			return queue.Item{}, queue.Queue{}, errors.New("no operation failed, and the work tree has changes that skipping would discard")
+		}
+		if item, err = q.Pop(); err == queue.ErrEmpty {
+			return queue.Item{}, queue.Queue{}, errors.New("no operation to skip")
+		}
+	}
+	return item, q, nil
 }
 
-// NewValidateCommand returns a command that checks the validity of the rework.
-func NewValidateCommand() (*Command, error) {
+// NewResolveCommand returns a command that commits the resolution of the
+// conflicts left by the failed rework operation, which must apply a patch, and
+// continues the rework. The resolution is committed with the author and
+// message of the patch.
+func NewResolveCommand() (*Command, error) {
 	c, err := NewCommand()
 	if err != nil {
 		return nil, err
 	}
+
 	if exists, err := c.repo.ReworkInProgress(); err != nil {
 		return nil, err
 	} else if !exists {
 		return nil, fmt.Errorf("no rework in progress")
 	}
-	registerOperations(&c.executor, c.repo)
-	if err = c.executor.Enqueue("Validate"); err != nil {
+
+	c.useOuterQueue()
+
+	item, failed, err := FailedOperation(c.repo)
+	if err != nil {
+		return nil, err
+	} else if !failed {
+		return nil, errors.New("no failed operation to resolve")
+	}
+	patch, ok := AppliedPatch(item)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't apply a patch", describeItem(item))
+	}
+	if err = c.repo.CommitResolution(patch); err != nil {
+		return nil, fmt.Errorf("failed to commit resolution: %w", err)
+	}
+	_, q, err := popFailed(c)
+	if err != nil {
 		return nil, err
 	}
+	c.report("Resolve", "Resolved %s", describeItem(item))
+	c.executor.LoadQueue(q)
+
 	return c, nil
 }
 
-func validateRework(r *repo.Repo) (bool, error) {
-	return r.CompareTreeToHead("rework/branch")
+// FailedOperation returns the failed operation of the rework in progress, and
+// whether there is one. The failed step of a patchset's queue is returned
+// rather than the operation on the patchset.
+func FailedOperation(r *repo.Repo) (queue.Item, bool, error) {
+	current, _, err := readRecoveredState(outerQueue(r))
+	if err != nil {
+		return queue.Item{}, false, err
+	}
+	nestedCurrent, nestedQueue, err := readRecoveredState(newStateFile(r, patchsetQueueName))
+	if err != nil {
+		return queue.Item{}, false, err
+	}
+	switch {
+	case len(nestedCurrent.Items) > 0:
+		return nestedCurrent.Items[0], true, nil
+	case len(current.Items) > 0 && len(nestedQueue.Items) == 0:
+		return current.Items[0], true, nil
+	}
+	return queue.Item{}, false, nil
 }
 
-func newStateFile(r *repo.Repo, name string) *stateFile {
-	return &stateFile{
-		path: filepath.Join(r.KiltDirectory(), "rework"),
-		name: name,
+// AppliedPatch returns the patch that the operation applies, if it applies
+// one.
+func AppliedPatch(item queue.Item) (string, bool) {
+	switch item.Operation {
+	case "Apply", "Cherrypick":
+		if len(item.Args) > 0 {
+			return item.Args[0], true
+		}
 	}
+	return "", false
 }
 
-// Status prints the status of the rework.
-func Status(r *repo.Repo) error {
-	state := newStateFile(r, "queue")
-	q, err := state.ReadState()
+// recordSkipped adds the item to the operations skipped during the rework.
+func recordSkipped(r *repo.Repo, item queue.Item) error {
+	s := newStateFile(r, skippedQueueName)
+	q, err := s.ReadState()
 	if err != nil {
 		return err
 	}
-	if len(q.Items) > 0 {
-		fmt.Println("Remaining work:")
-		for _, item := range q.Items {
-			fmt.Printf("\t%s %s\n", item.Operation, strings.Join(item.Args, " "))
-		}
-		fmt.Println(`Use kilt rework --continue to perform the next operation, or manually perform
-the operation and use kilt rework --skip to skip execution.`)
-	} else {
-		fmt.Println("All work complete. Use kilt rework --finish to validate and finish the rework.")
+	q.Items = append(q.Items, item)
+	return s.WriteQueueState(q)
+}
+
+// SkippedWork returns the operations skipped during the rework in progress.
+func SkippedWork(r *repo.Repo) (queue.Queue, error) {
+	return newStateFile(r, skippedQueueName).ReadState()
+}
+
+func describeItem(item queue.Item) string {
+	return strings.TrimSpace(strings.Join(append([]string{item.Operation}, item.Args...), " "))
+}
+
+func continueRework(c *Command) error {
+	skipped, err := SkippedWork(c.repo)
+	if err != nil {
+		return err
+	}
+	for _, item := range skipped.Items {
+		c.reporter.Note(fmt.Sprintf("Previously skipped %s", describeItem(item)))
+	}
+	current, q, err := readRecoveredState(c.reader)
+	if err != nil {
+		return err
+	}
+	progress, err := NestedProgress(c.repo)
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		c.reporter.Note(fmt.Sprintf("Resuming %s", progress))
+	}
+	var saved queue.Header
+	if s, ok := c.reader.(*stateFile); ok && s != nil {
+		saved = s.saved
+	}
+	if err := checkOperations(&c.executor, saved, append(append([]queue.Item{}, current.Items...), q.Items...)); err != nil {
+		return err
+	}
+	if err := c.loadHistory(); err != nil {
+		return err
 	}
+	c.resuming = len(current.Items) > 0
+	c.executor.LoadQueue(current)
+	c.executor.LoadQueue(q)
 	return nil
 }
 
-// NewContinueCommand returns a command that continues with saved rework steps.
-func NewContinueCommand() (*Command, error) {
-	c, err := NewCommand()
+// Progress describes how far the per-patchset queue of an outer rework
+// operation, such as Rework or Apply, has got.
+type Progress struct {
+	// Parent is the outer operation the patchset queue belongs to.
+	Parent queue.Item
+	// Operation is the failed operation of the patchset queue, or the next
+	// one to run.
+	Operation queue.Item
+	// Failed is set if Operation failed.
+	Failed bool
+	// Step is the position of Operation in the patchset queue, out of Total.
+	// Total is zero if the size of the queue isn't known.
+	Step, Total int
+}
+
+func (p *Progress) String() string {
+	op := shortItem(p.Operation)
+	if p.Total == 0 {
+		return fmt.Sprintf("%s: %s", describeItem(p.Parent), op)
+	}
+	return fmt.Sprintf("%s: step %d/%d (%s)", describeItem(p.Parent), p.Step, p.Total, op)
+}
+
+var commitIDRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// shortItem describes the item with commit ids abbreviated.
+func shortItem(item queue.Item) string {
+	args := make([]string, len(item.Args))
+	for i, a := range item.Args {
+		if commitIDRegexp.MatchString(a) {
+			a = a[:7]
+		}
+		args[i] = a
+	}
+	return describeItem(queue.Item{Operation: item.Operation, Args: args})
+}
+
+// NestedProgress returns the progress of the per-patchset queue of the rework
+// in progress, or nil if no patchset queue is in progress.
+func NestedProgress(r *repo.Repo) (*Progress, error) {
+	outerCurrent, outer, err := readRecoveredState(outerQueue(r))
+	if err != nil {
+		return nil, err
+	}
+	current, q, err := readRecoveredState(newStateFile(r, patchsetQueueName))
+	if err != nil {
+		return nil, err
+	}
+	plan, err := newStateFile(r, patchsetPlanName).ReadState()
 	if err != nil {
 		return nil, err
 	}
+	return nestedProgress(outerCurrent, outer, current, q, plan), nil
+}
 
-	state := newStateFile(c.repo, "queue")
-	c.setWriter(state)
-	c.setReader(state)
+func nestedProgress(outerCurrent, outer, current, q, plan queue.Queue) *Progress {
+	if len(current.Items)+len(q.Items) == 0 {
+		return nil
+	}
+	p := &Progress{}
+	switch {
+	case len(outerCurrent.Items) > 0:
+		p.Parent = outerCurrent.Items[0]
+	case len(outer.Items) > 0:
+		p.Parent = outer.Items[0]
+	}
+	if len(current.Items) > 0 {
+		p.Operation, p.Failed = current.Items[0], true
+	} else {
+		p.Operation = q.Items[0]
+	}
+	if remaining := len(current.Items) + len(q.Items); len(plan.Items) >= remaining {
+		p.Total = len(plan.Items)
+		p.Step = p.Total - remaining + 1
+	}
+	return p
+}
 
-	if exists, err := c.repo.ReworkInProgress(); err != nil {
-		return nil, err
-	} else if !exists {
-		return nil, fmt.Errorf("no rework in progress")
+func (c *Command) reworkPatchset(patchset string, bump bool) error {
+	return c.movePatches(patchset, nil, nil, bump)
+}
+
+// movePatches reworks the patchset, leaving out the patches in release and
+// reassigning the patches in gather to it. Unless bump is set, the version
+// policy decides whether the version of the patchset is bumped.
+func (c *Command) movePatches(patchset string, release, gather []string, bump bool) error {
+	r := c.repo
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets[patchset]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", patchset)
+	}
+	released := map[string]bool{}
+	for _, patch := range release {
+		released[patch] = true
 	}
+	changed := len(release) > 0 || len(gather) > 0 || len(p.FloatingPatches()) > 0
+	metadata, err := c.updateMetadataArgs(p, changed, bump)
+	if err != nil {
+		return err
+	}
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		if p.MetadataCommit() == "" {
+			if err := e.Enqueue("CreateMetadata", p.Name()); err != nil {
+				return err
+			}
+		} else {
+			if err := e.Enqueue("UpdateMetadata", metadata...); err != nil {
+				return err
+			}
+		}
 
-	registerOperations(&c.executor, c.repo)
+		for _, patch := range p.Patches() {
+			if !released[patch] {
+				if err := e.Enqueue("Apply", patch); err != nil {
+					return err
+				}
+			}
+		}
+		for _, patch := range p.FloatingPatches() {
+			if !released[patch] {
+				if err := e.Enqueue("Cherrypick", patch); err != nil {
+					return err
+				}
+			}
+		}
+		for _, patch := range gather {
+			if err := e.Enqueue("Reassign", patch, p.Name()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
-	if err = continueRework(c); err != nil {
-		return nil, err
+// autosquashPatchset reworks the patchset like reworkPatchset, melding the
+// floating fixup!, squash! and Fixes-Patch: patches into the patches they
+// refer to.
+func (c *Command) autosquashPatchset(patchset string, bump bool) error {
+	r := c.repo
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets[patchset]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", patchset)
+	}
+	describe := func(ids []string) ([]squashPatch, error) {
+		var patches []squashPatch
+		for _, id := range ids {
+			info, err := r.CommitInfo(id)
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, squashPatch{id: id, subject: info.Summary, message: info.Message})
+		}
+		return patches, nil
+	}
+	patches, err := describe(p.Patches())
+	if err != nil {
+		return err
+	}
+	floating, err := describe(p.FloatingPatches())
+	if err != nil {
+		return err
+	}
+	items := autosquashItems(patches, floating)
+	metadata, err := c.updateMetadataArgs(p, len(floating) > 0, bump)
+	if err != nil {
+		return err
 	}
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		if p.MetadataCommit() == "" {
+			if err := e.Enqueue("CreateMetadata", p.Name()); err != nil {
+				return err
+			}
+		} else {
+			if err := e.Enqueue("UpdateMetadata", metadata...); err != nil {
+				return err
+			}
+		}
+		for _, item := range items {
+			if err := e.Enqueue(item.Operation, item.Args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const (
+	autosquashArg = "autosquash"
+	fixupKind     = "fixup"
+	squashKind    = "squash"
+	// upToPrefix prefixes the last patch to apply of a partially built
+	// patchset.
+	upToPrefix = "up-to="
+)
 
-	return c, nil
+// squashPatch is a patch considered for autosquashing.
+type squashPatch struct {
+	id, subject, message string
 }
 
-// NewSkipCommand returns a command that skips the next saved rework step.
-func NewSkipCommand() (*Command, error) {
-	c, err := NewCommand()
-	if err != nil {
-		return nil, err
+var fixesPatchRegexp = regexp.MustCompile("(?m)^" + repo.FixesPatchField + ":[[:space:]]*(.+?)[[:space:]]*$")
+
+// squashTarget returns the kind of squash the patch requests and the subject or
+// commit id of the patch it should be melded into, or empty strings if the
+// patch isn't a fixup.
+func squashTarget(p squashPatch) (kind, target string) {
+	subject := p.subject
+	for {
+		switch {
+		case strings.HasPrefix(subject, "fixup! "):
+			subject = strings.TrimPrefix(subject, "fixup! ")
+			if kind == "" {
+				kind = fixupKind
+			}
+			continue
+		case strings.HasPrefix(subject, "squash! "):
+			subject = strings.TrimPrefix(subject, "squash! ")
+			if kind == "" {
+				kind = squashKind
+			}
+			continue
+		}
+		break
 	}
+	if kind != "" {
+		return kind, subject
+	}
+	if m := fixesPatchRegexp.FindStringSubmatch(p.message); m != nil {
+		return fixupKind, m[1]
+	}
+	return "", ""
+}
 
-	state := newStateFile(c.repo, "queue")
-	c.setWriter(state)
-	c.setReader(state)
+// squashMatches returns whether target, a subject or commit id prefix, refers
+// to the patch.
+func squashMatches(p squashPatch, target string) bool {
+	if p.subject == target {
+		return true
+	}
+	if len(target) >= 7 && strings.HasPrefix(p.id, target) {
+		return true
+	}
+	return strings.HasPrefix(p.subject, target)
+}
 
-	if exists, err := c.repo.ReworkInProgress(); err != nil {
-		return nil, err
-	} else if !exists {
-		return nil, fmt.Errorf("no rework in progress")
+// autosquashItems returns the nested rework operations applying the patches
+// and floating patches of a patchset, with each fixup squashed right after the
+// patch it refers to. Fixups whose target isn't found are cherry-picked as
+// regular floating patches.
+func autosquashItems(patches, floating []squashPatch) []queue.Item {
+	all := append(append([]squashPatch{}, patches...), floating...)
+	squashes := map[string][]queue.Item{}
+	fixup := map[string]bool{}
+	for i, f := range floating {
+		kind, target := squashTarget(f)
+		if kind == "" {
+			continue
+		}
+		// Prefer the most recent matching patch preceding the fixup.
+		for j := len(patches) + i - 1; j >= 0; j-- {
+			if !fixup[all[j].id] && squashMatches(all[j], target) {
+				fixup[f.id] = true
+				squashes[all[j].id] = append(squashes[all[j].id], queue.Item{Operation: "Squash", Args: []string{f.id, kind}})
+				break
+			}
+		}
+	}
+	var items []queue.Item
+	add := func(op string, p squashPatch) {
+		if fixup[p.id] {
+			return
+		}
+		items = append(items, queue.Item{Operation: op, Args: []string{p.id}})
+		items = append(items, squashes[p.id]...)
+	}
+	for _, p := range patches {
+		add("Apply", p)
 	}
+	for _, p := range floating {
+		add("Cherrypick", p)
+	}
+	return items
+}
 
-	registerOperations(&c.executor, c.repo)
+// VersionPolicy controls when reworks bump the versions of patchsets. It is
+// set with the kilt.versionPolicy config option.
+type VersionPolicy string
+
+// Version policies.
+const (
+	// BumpAlways bumps the version of every reworked patchset.
+	BumpAlways VersionPolicy = "always"
+	// BumpOnChange bumps the version of reworked patchsets whose patches
+	// change, by gaining floating patches or losing patches, but not of
+	// patchsets that are only rebased.
+	BumpOnChange VersionPolicy = "on-change"
+	// BumpManual leaves versions to kilt bump.
+	BumpManual VersionPolicy = "manual"
+)
 
-	c.executor.Enqueue("Skip")
+const (
+	versionPolicyConfig = "kilt.versionPolicy"
+	// keepVersionArg makes UpdateMetadata keep the version of the patchset.
+	keepVersionArg = "keep-version"
+	// bumpArg makes Rework bump the version of the patchset regardless of
+	// the version policy.
+	bumpArg = "bump"
+)
 
-	q, err := c.reader.ReadState()
+// versionPolicy returns the configured version policy.
+func (c *Command) versionPolicy() (VersionPolicy, error) {
+	v, err := c.repo.ConfigString(versionPolicyConfig, string(BumpAlways))
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	switch p := VersionPolicy(v); p {
+	case BumpAlways, BumpOnChange, BumpManual:
+		return p, nil
 	}
-	current, err := c.reader.ReadCurrentState()
+	return "", fmt.Errorf("invalid %s %q, want always, on-change or manual", versionPolicyConfig, v)
+}
+
+// bumps reports whether the policy bumps the version of a reworked patchset,
+// given whether its patches change and whether a bump was requested.
+func (p VersionPolicy) bumps(changed, requested bool) bool {
+	return requested || p == BumpAlways || p == BumpOnChange && changed
+}
+
+// updateMetadataArgs returns the arguments of the UpdateMetadata operation of
+// the reworked patchset, following the version policy.
+func (c *Command) updateMetadataArgs(p *patchset.Patchset, changed, bump bool) ([]string, error) {
+	policy, err := c.versionPolicy()
 	if err != nil {
 		return nil, err
 	}
-	if len(current.Items) == 0 {
-		if _, err = q.Pop(); err != nil {
-			return nil, err
-		}
-	} else {
-		c.writer.ClearCurrentState()
+	args := []string{p.MetadataCommit()}
+	if !policy.bumps(changed, bump) {
+		args = append(args, keepVersionArg)
 	}
-	c.executor.LoadQueue(q)
+	return args, nil
+}
 
-	return c, nil
+// autosquashConfig disables folding fixup patches automatically when set to
+// false, like git's rebase.autoSquash.
+const autosquashConfig = "kilt.autosquash"
+
+// AutosquashByDefault returns whether reworks meld floating fixup patches into
+// the patches they refer to unless told otherwise, as set by the
+// kilt.autosquash config option.
+func (c *Command) AutosquashByDefault() (bool, error) {
+	return c.repo.ConfigBool(autosquashConfig, true)
 }
 
-func continueRework(c *Command) error {
-	current, err := c.reader.ReadCurrentState()
-	if err != nil {
-		return err
-	}
-	c.executor.LoadQueue(current)
-	q, err := c.reader.ReadState()
-	if err != nil {
-		return err
+// Autosquash makes the queued Rework operations meld floating fixup patches
+// into the patches they refer to, rather than appending them to the patchset.
+func (c *Command) Autosquash() error {
+	var q queue.Queue
+	for _, item := range c.executor.Queue().Items {
+		if item.Operation == "Rework" && len(item.Args) > 0 && !hasArg(item.Args[1:], autosquashArg) {
+			item = queue.Item{Operation: item.Operation, Args: append(append([]string{}, item.Args...), autosquashArg)}
+		}
+		q.Items = append(q.Items, item)
 	}
-	c.executor.LoadQueue(q)
-	return nil
+	return c.executor.ReplaceQueue(q)
 }
 
-func skipReworkQueue(r *repo.Repo) error {
-	state := newStateFile(r, "reworkQueue")
-	if err := state.ClearQueueState(); err != nil {
-		return err
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
 	}
-	return state.ClearCurrentState()
+	return false
 }
 
-func reworkPatchset(r *repo.Repo, patchset string) error {
+func (c *Command) applyPatchset(patchset, upTo string) error {
+	r := c.repo
 	patchsets, err := r.PatchsetMap()
 	if err != nil {
 		return err
@@ -849,50 +3225,52 @@ func reworkPatchset(r *repo.Repo, patchset string) error {
 	if !ok {
 		return fmt.Errorf("patchset %q not found", patchset)
 	}
-	c, err := NewCommand()
-	if err != nil {
-		return err
-	}
-	state := newStateFile(r, "reworkQueue")
-	c.setWriter(state)
-	c.setReader(state)
-
-	registerReworkOperations(&c.executor, c.repo)
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		if err := e.Enqueue("Apply", p.MetadataCommit()); err != nil {
+			return err
+		}
+		for _, patch := range patchesUpTo(p.Patches(), upTo) {
+			if err := e.Enqueue("Apply", patch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
-	current, err := c.reader.ReadCurrentState()
+// applySquashedPatchset applies the patches of the patchset, up to upTo if
+// set, then collapses them into a single commit.
+func (c *Command) applySquashedPatchset(patchset, upTo string) error {
+	r := c.repo
+	patchsets, err := r.PatchsetMap()
 	if err != nil {
 		return err
 	}
-	q, err := c.reader.ReadState()
+	p, ok := patchsets[patchset]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", patchset)
+	}
+	base, err := r.ResolveCommit("HEAD")
 	if err != nil {
 		return err
 	}
-	c.executor.LoadQueue(q)
-
-	if len(q.Items) == 0 && len(current.Items) == 0 {
-		if p.MetadataCommit() == "" {
-			c.executor.Enqueue("CreateMetadata", p.Name())
-		} else {
-			c.executor.Enqueue("UpdateMetadata", p.MetadataCommit())
-		}
-
-		for _, patch := range p.Patches() {
-			c.executor.Enqueue("Apply", patch)
-		}
-		for _, patch := range p.FloatingPatches() {
-			c.executor.Enqueue("Cherrypick", patch)
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		for _, patch := range patchesUpTo(p.Patches(), upTo) {
+			if err := e.Enqueue("Apply", patch); err != nil {
+				return err
+			}
 		}
-	}
-	if err = c.ExecuteAll(); err != nil {
-		if saveErr := c.Save(); saveErr != nil {
-			return fmt.Errorf("failed to save queue: %v; during error: %v", saveErr, err)
+		if err := e.Enqueue("Collapse", p.Name(), base); err != nil {
+			return err
 		}
-		return err
-	}
-	return nil
+		return nil
+	})
 }
 
-func applyPatchset(r *repo.Repo, patchset string) error {
+// collapsePatchset replaces the commits applied since base with a single
+// commit for the patchset.
+func (c *Command) collapsePatchset(patchset, base string) error {
+	r := c.repo
 	patchsets, err := r.PatchsetMap()
 	if err != nil {
 		return err
@@ -901,83 +3279,254 @@ func applyPatchset(r *repo.Repo, patchset string) error {
 	if !ok {
 		return fmt.Errorf("patchset %q not found", patchset)
 	}
-	c, err := NewCommand()
+	var subjects []string
+	for _, patch := range p.Patches() {
+		info, err := r.CommitInfo(patch)
+		if err != nil {
+			return err
+		}
+		subjects = append(subjects, info.Summary)
+	}
+	c.report("Collapse", "Squashing patchset %s into a single commit", patchset)
+	return r.CollapseToHead(base, squashedMessage(p, subjects))
+}
+
+// squashedMessage returns the message of the single commit a patchset is
+// squashed into. The subject is the first line of the patchset's description,
+// or its name, followed by the rest of the description and the subjects of the
+// squashed patches.
+func squashedMessage(p *patchset.Patchset, subjects []string) string {
+	var b strings.Builder
+	description := strings.TrimSpace(p.Description())
+	subject, rest := description, ""
+	if i := strings.Index(description, "\n"); i >= 0 {
+		subject, rest = description[:i], strings.TrimSpace(description[i+1:])
+	}
+	if subject == "" {
+		subject = p.Name()
+	}
+	b.WriteString(subject + "\n")
+	if rest != "" {
+		b.WriteString("\n" + rest + "\n")
+	}
+	if len(subjects) > 0 {
+		b.WriteString("\nSquashed patches:\n")
+		for _, s := range subjects {
+			b.WriteString("  * " + s + "\n")
+		}
+	}
+	fmt.Fprintf(&b, "\nPatchset: %s v%s\n", p.Name(), p.Version())
+	return b.String()
+}
+
+// executeReworkQueue executes the nested per-patchset rework queue, sending
+// its messages to the reporter of c. A previously saved queue is resumed,
+// otherwise enqueue is called to fill a new queue. On failure the remaining
+// queue is saved for a later continue.
+func (c *Command) executeReworkQueue(enqueue func(e *queue.Executor) error) error {
+	n, err := NewCommand()
 	if err != nil {
 		return err
 	}
-	state := newStateFile(r, "reworkQueue")
-	c.setWriter(state)
-	c.setReader(state)
+	n.SetReporter(c.reporter)
+	n.progress = c.progress
+	n.ctx = c.ctx
+	state := newStateFile(n.repo, patchsetQueueName)
+	n.setWriter(state)
+	n.setReader(state)
 
-	registerReworkOperations(&c.executor, c.repo)
+	registerReworkOperations(n)
 
-	current, err := c.reader.ReadCurrentState()
+	current, q, err := readRecoveredState(n.reader)
 	if err != nil {
 		return err
 	}
-	q, err := c.reader.ReadState()
+	done, err := n.reader.ReadDoneState()
 	if err != nil {
 		return err
 	}
-	c.executor.LoadQueue(q)
+	n.executor.LoadQueue(q)
 
-	if len(q.Items) == 0 && len(current.Items) == 0 {
-		c.executor.Enqueue("Apply", p.MetadataCommit())
-		for _, patch := range p.Patches() {
-			c.executor.Enqueue("Apply", patch)
+	// A done operation without a queue means the patchset was completed by
+	// an interrupted process.
+	plan := newStateFile(n.repo, patchsetPlanName)
+	if len(q.Items) == 0 && len(current.Items) == 0 && len(done.Items) == 0 {
+		if err = enqueue(&n.executor); err != nil {
+			return err
+		}
+		if n.progress != nil {
+			n.progress.Total += len(n.executor.Queue().Items)
+		}
+		if err = n.applyInMemory(); err != nil {
+			return err
+		}
+		if err = plan.WriteQueueState(n.executor.Queue()); err != nil {
+			return err
 		}
 	}
-	if err = c.ExecuteAll(); err != nil {
-		if saveErr := c.Save(); saveErr != nil {
+	if err = n.ExecuteAll(); err != nil {
+		if saveErr := n.Save(); saveErr != nil {
 			return fmt.Errorf("failed to save queue: %v; during error: %v", saveErr, err)
 		}
 		return err
 	}
-	return nil
+	if err = state.ClearCurrentState(); err != nil {
+		return err
+	}
+	if err = state.ClearDoneState(); err != nil {
+		return err
+	}
+	if err = plan.ClearQueueState(); err != nil {
+		return err
+	}
+	return state.ClearQueueState()
 }
 
-func registerReworkOperations(e *queue.Executor, r *repo.Repo) {
+func registerReworkOperations(c *Command) {
+	r := c.repo
 	var operations = []queue.Operation{
 		{
-			Name: "Apply",
+			Name:   "Apply",
+			Params: []queue.Param{patchParam},
 			Execute: func(patch []string) error {
 				desc, err := r.DescribeCommit(patch[0])
 				if err != nil {
 					return err
 				}
-				fmt.Printf("Applying %s\n", desc)
-				return r.CherryPickToHead(patch[0])
+				c.report("Apply", "Applying %s", desc)
+				return c.cherryPickWithHints(patch[0])
 			},
 			Resumable: true,
 		},
 		{
-			Name: "Cherrypick",
+			Name:      "ApplyBatch",
+			Params:    []queue.Param{{Name: "patches", Type: queue.CommitID, Variadic: true}},
+			Execute:   c.applyBatch,
+			Resumable: true,
+		},
+		{
+			Name:   "Cherrypick",
+			Params: []queue.Param{patchParam},
 			Execute: func(patch []string) error {
 				desc, err := r.DescribeCommit(patch[0])
 				if err != nil {
 					return err
 				}
-				fmt.Printf("Cherrypick %s\n", desc)
-				return r.CherryPickToHead(patch[0])
+				c.report("Cherrypick", "Cherrypick %s", desc)
+				return c.cherryPickWithHints(patch[0])
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "Reassign",
+			Params: []queue.Param{patchParam, patchsetParam},
+			Execute: func(args []string) error {
+				if len(args) < 2 {
+					return errors.New("patch and patchset required")
+				}
+				desc, err := r.DescribeCommit(args[0])
+				if err != nil {
+					return err
+				}
+				c.report("Reassign", "Reassigning %s to %s", desc, args[1])
+				return r.ReassignToHead(args[0], args[1])
 			},
 			Resumable: true,
 		},
 		{
-			Name: "UpdateMetadata",
+			Name:   "UpdateMetadata",
+			Params: []queue.Param{{Name: "metadata", Type: queue.CommitID}, {Name: "option", Type: queue.String, Optional: true}},
 			Execute: func(patch []string) error {
 				desc, err := r.DescribeCommit(patch[0])
 				if err != nil {
 					return err
 				}
-				fmt.Printf("Updating metadata %s\n", desc)
+				if len(patch) > 1 && patch[1] == keepVersionArg {
+					c.report("UpdateMetadata", "Copying metadata %s", desc)
+					return r.CopyMetadataForCommit(patch[0])
+				}
+				c.report("UpdateMetadata", "Updating metadata %s", desc)
 				return r.UpdateMetadataForCommit(patch[0])
 			},
 			Resumable: true,
 		},
 		{
-			Name: "CreateMetadata",
+			Name:   "Squash",
+			Params: []queue.Param{patchParam, {Name: "kind", Type: queue.String}},
+			Execute: func(args []string) error {
+				if len(args) < 2 {
+					return errors.New("patch and squash kind required")
+				}
+				desc, err := r.DescribeCommit(args[0])
+				if err != nil {
+					return err
+				}
+				c.report("Squash", "Squashing %s", desc)
+				return r.SquashToHead(args[0], args[1] == squashKind)
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "SetTrailer",
+			Params: []queue.Param{patchParam, {Name: "key", Type: queue.String}, {Name: "value", Type: queue.String}},
+			Execute: func(args []string) error {
+				return c.setTrailer(args, false)
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "AddTrailer",
+			Params: []queue.Param{patchParam, {Name: "key", Type: queue.String}, {Name: "value", Type: queue.String}},
+			Execute: func(args []string) error {
+				return c.setTrailer(args, true)
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "SetSubjectPrefix",
+			Params: []queue.Param{patchParam, {Name: "prefix", Type: queue.String}},
+			Execute: func(args []string) error {
+				if len(args) < 2 {
+					return errors.New("patch and prefix required")
+				}
+				prefix, err := url.QueryUnescape(args[1])
+				if err != nil {
+					return fmt.Errorf("invalid subject prefix %q: %w", args[1], err)
+				}
+				desc, err := r.DescribeCommit(args[0])
+				if err != nil {
+					return err
+				}
+				c.report("SetSubjectPrefix", "Prefixing subject of %s with %s", desc, prefix)
+				return r.SetSubjectPrefixToHead(args[0], prefix)
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "RenameMetadata",
+			Params: []queue.Param{{Name: "metadata", Type: queue.CommitID}, {Name: "name", Type: queue.PatchsetName}, {Name: "UUID", Type: queue.String, Optional: true}},
+			Execute: func(args []string) error {
+				if len(args) < 2 {
+					return errors.New("metadata commit and name required")
+				}
+				desc, err := r.DescribeCommit(args[0])
+				if err != nil {
+					return err
+				}
+				uuid := ""
+				if len(args) > 2 {
+					uuid = args[2]
+				}
+				c.report("RenameMetadata", "Renaming metadata %s to %s", desc, args[1])
+				return r.AdoptMetadataForCommit(args[0], args[1], uuid)
+			},
+			Resumable: true,
+		},
+		{
+			Name:   "CreateMetadata",
+			Params: []queue.Param{patchsetParam},
 			Execute: func(ps []string) error {
-				fmt.Printf("Creating metadata for %s\n", ps[0])
+				c.report("CreateMetadata", "Creating metadata for %s", ps[0])
 				p := patchset.New(ps[0])
 				return r.AddPatchset(p)
 			},
@@ -985,17 +3534,82 @@ func registerReworkOperations(e *queue.Executor, r *repo.Repo) {
 		},
 	}
 	for _, op := range operations {
-		e.Register(op)
+		c.executor.Register(op)
+	}
+}
+
+// cherryPickWithHints cherry-picks the patch to head, printing any conflict
+// hints annotated on the patch if user action is required.
+func (c *Command) cherryPickWithHints(patch string) error {
+	r := c.repo
+	s, err := c.applyStrategy(patch)
+	if err != nil {
+		return err
+	}
+	err = r.CherryPickToHeadWithStrategy(patch, s)
+	if !errors.Is(err, repo.ErrUserActionRequired) {
+		return err
 	}
+	hints, hintErr := r.ConflictHints(patch)
+	if hintErr != nil {
+		log.Warningf("Failed to read conflict hints for %q: %v", patch, hintErr)
+	} else if len(hints) > 0 {
+		c.reporter.Note("Conflict hints for this patch:")
+		for _, h := range hints {
+			c.reporter.Note("\t" + h)
+		}
+	}
+	return err
+}
+
+// applyStrategy returns the application strategy declared by the patchset the
+// patch belongs to, as of the metadata on the branch being reworked.
+func (c *Command) applyStrategy(patch string) (patchset.Strategy, error) {
+	name, err := c.repo.CommitPatchset(patch)
+	if err != nil || name == "" {
+		return patchset.Strategy{}, err
+	}
+	cache, err := c.repo.PatchsetCache()
+	if err != nil {
+		return patchset.Strategy{}, err
+	}
+	p, ok := cache.Lookup(name)
+	if !ok {
+		return patchset.Strategy{}, nil
+	}
+	s, err := p.Strategy()
+	if err == nil && !s.Default() {
+		log.Infof("Applying %s with strategy %q of patchset %q", patch, s, p.Name())
+	}
+	return s, err
 }
 
 func cleanupReworkState(r *repo.Repo) {
-	if err := r.DeleteKiltRef("rework/branch"); err != nil {
+	if err := r.DeleteKiltRef(r.ReworkRef("branch")); err != nil {
 		log.Errorf("Error deleting kilt rework branch ref: %v", err)
 	}
-	if err := r.DeleteKiltRef("rework/head"); err != nil {
+	if err := r.DeleteKiltRef(r.ReworkRef("head")); err != nil {
 		log.Errorf("Error deleting kilt rework head ref: %v", err)
 	}
+	if base, err := r.LookupKiltRef(r.ReworkRef("base")); err != nil {
+		log.Errorf("Error looking up kilt rework base ref: %v", err)
+	} else if base != "" {
+		if err := r.DeleteKiltRef(r.ReworkRef("base")); err != nil {
+			log.Errorf("Error deleting kilt rework base ref: %v", err)
+		}
+	}
+	if err := newStateFile(r, skippedQueueName).ClearQueueState(); err != nil {
+		log.Errorf("Error deleting skipped rework operations: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(r.ReworkDirectory(), sessionFile)); err != nil {
+		log.Errorf("Error deleting rework name: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(r.ReworkDirectory(), worktreeFile)); err != nil {
+		log.Errorf("Error deleting work tree build marker: %v", err)
+	}
+	if err := r.ClearActiveRework(); err != nil {
+		log.Errorf("Error clearing active rework: %v", err)
+	}
 }
 
 type reworkState struct {
@@ -1004,15 +3618,200 @@ type reworkState struct {
 
 func loadExistingRework(r *repo.Repo) (reworkState, error) {
 	var head, branch string
-	if branch, err := r.LookupKiltRef("rework/branch"); err != nil {
+	if branch, err := r.LookupKiltRef(r.ReworkRef("branch")); err != nil {
 		return reworkState{}, err
 	} else if branch == "" {
 		return reworkState{}, errors.New("failed to lookup rework branch")
 	}
-	if head, err := r.LookupKiltRef("rework/head"); err != nil {
+	if head, err := r.LookupKiltRef(r.ReworkRef("head")); err != nil {
 		return reworkState{}, err
 	} else if head == "" {
 		return reworkState{}, errors.New("failed to lookup rework head")
 	}
 	return reworkState{branch: branch, head: head}, nil
 }
+
+// Git config options providing the defaults of Notify.
+const (
+	notifyCommandConfig = "kilt.notifyCommand"
+	notifyURLConfig     = "kilt.notifyURL"
+)
+
+// Notify sends a notification that the named command stopped with err, which
+// is nil if all operations completed. The notification is run as the shell
+// command and posted to the webhook URL, which default to the
+// kilt.notifyCommand and kilt.notifyURL config options; nothing is sent if
+// neither is set.
+func (c *Command) Notify(command, shell, url string, err error) error {
+	r := c.repo
+	var cfgErr error
+	if shell == "" {
+		shell, cfgErr = r.ConfigString(notifyCommandConfig, "")
+	}
+	if url == "" && cfgErr == nil {
+		url, cfgErr = r.ConfigString(notifyURLConfig, "")
+	}
+	if cfgErr != nil {
+		return cfgErr
+	}
+	var notifiers []notify.Notifier
+	if shell != "" {
+		notifiers = append(notifiers, notify.Command{Shell: shell})
+	}
+	if url != "" {
+		policy, err := r.NetworkPolicy()
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, notify.Webhook{URL: url, Policy: policy})
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	e, sumErr := c.notifyEvent(command, err)
+	if sumErr != nil {
+		return sumErr
+	}
+	var msgs []string
+	for _, err := range notify.All(notifiers, e) {
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// notifyEvent describes the outcome of the command, summarizing the progress,
+// skipped operations and remaining work of the rework.
+func (c *Command) notifyEvent(command string, err error) (notify.Event, error) {
+	r := c.repo
+	e := notify.Event{Command: command, Branch: r.KiltBranch(), Outcome: notify.Complete}
+	switch {
+	case errors.Is(err, repo.ErrUserActionRequired):
+		e.Outcome, e.Error = notify.Conflict, err.Error()
+	case err != nil:
+		e.Outcome, e.Error = notify.Failed, err.Error()
+	}
+	if c.progress != nil {
+		e.Summary = append(e.Summary, c.progress.Progress.String())
+	}
+	skipped, sErr := SkippedWork(r)
+	if sErr != nil {
+		return e, sErr
+	}
+	for _, item := range skipped.Items {
+		e.Summary = append(e.Summary, "Skipped "+describeItem(item))
+	}
+	if err == nil {
+		return e, nil
+	}
+	progress, pErr := NestedProgress(r)
+	if pErr != nil {
+		return e, pErr
+	}
+	if progress != nil {
+		e.Summary = append(e.Summary, "Stopped at "+progress.String())
+	}
+	if q := c.executor.Queue(); len(q.Items) > 0 {
+		e.Summary = append(e.Summary, fmt.Sprintf("%d operations remaining, next %s", len(q.Items), describeItem(q.Items[0])))
+	}
+	return e, nil
+}
+
+// versionRefsConfig is the git config option enabling version refs on finish.
+const versionRefsConfig = "kilt.versionRefs"
+
+// boundaryRefsConfig is the git config option controlling whether boundary
+// refs are updated on finish.
+const boundaryRefsConfig = "kilt.boundaryRefs"
+
+const editQueueHelp = `# Edit the rework operations, one per line, in the form:
+#   <operation> [args...]
+#
+# Lines can be reordered, removed to drop an operation, or added to insert a new
+# operation. Lines starting with "#" are ignored.
+#
+# Available operations: %s
+`
+
+// patchsetOperations lists operations whose first argument names a patchset.
+var patchsetOperations = map[string]bool{
+	"Rework":      true,
+	"Checkout":    true,
+	"Apply":       true,
+	"Delete":      true,
+	"Rename":      true,
+	"Release":     true,
+	"Gather":      true,
+	"Trailer":     true,
+	"Adopt":       true,
+	"FixSubjects": true,
+}
+
+// EditQueue passes the remaining operations of an in-progress rework to edit,
+// validates the edited operations and saves them as the new rework queue.
+func EditQueue(edit func(text []byte) ([]byte, error)) error {
+	c, err := NewCommand()
+	if err != nil {
+		return err
+	}
+	if exists, err := c.repo.ReworkInProgress(); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("no rework in progress")
+	}
+	state := newStateFile(c.repo, reworkQueueName)
+	c.setWriter(state)
+	registerOperations(c)
+	q, err := state.ReadState()
+	if err != nil {
+		return err
+	}
+	c.executor.LoadQueue(q)
+	if err := c.Edit(edit); err != nil {
+		return err
+	}
+	return c.Save()
+}
+
+// Edit passes the queued operations of the command to edit, and replaces them
+// with the validated, edited operations. A leading Begin operation is kept, as
+// the rework can't be started without it.
+func (c *Command) Edit(edit func(text []byte) ([]byte, error)) error {
+	q := c.executor.Queue()
+	var begin []queue.Item
+	if len(q.Items) > 0 && q.Items[0].Operation == "Begin" {
+		begin, q.Items = q.Items[:1], q.Items[1:]
+	}
+	text, err := q.MarshalText()
+	if err != nil {
+		return err
+	}
+	help := fmt.Sprintf(editQueueHelp, strings.Join(c.executor.Operations(), ", "))
+	edited, err := edit(append([]byte(help), text...))
+	if err != nil {
+		return err
+	}
+	var newQueue queue.Queue
+	if err := newQueue.UnmarshalText(edited); err != nil {
+		return err
+	}
+	patchsets, err := c.repo.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	for _, item := range newQueue.Items {
+		if !patchsetOperations[item.Operation] {
+			continue
+		}
+		if len(item.Args) == 0 {
+			return fmt.Errorf("operation %s requires a patchset", item.Operation)
+		}
+		if _, ok := patchsets[item.Args[0]]; !ok {
+			return fmt.Errorf("operation %s: patchset %q not found", item.Operation, item.Args[0])
+		}
+	}
+	newQueue.Items = append(append([]queue.Item{}, begin...), newQueue.Items...)
+	return c.executor.ReplaceQueue(newQueue)
+}
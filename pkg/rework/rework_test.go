@@ -0,0 +1,708 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/reporter"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+func TestNestedProgress(t *testing.T) {
+	q := func(items ...queue.Item) queue.Queue { return queue.Queue{Items: items} }
+	item := func(op string, args ...string) queue.Item { return queue.Item{Operation: op, Args: args} }
+	id := "0123456789abcdef0123456789abcdef01234567"
+	rework := item("Rework", "foo")
+	plan := q(item("UpdateMetadata", id), item("Apply", "a"), item("Apply", id), item("Apply", "c"))
+	tests := []struct {
+		name                          string
+		outerCurrent, current, nested queue.Queue
+		plan                          queue.Queue
+		want                          string
+	}{
+		{"idle", q(), q(), q(), q(), ""},
+		{"failed", q(rework), q(item("Apply", id)), q(item("Apply", "c")), plan, "Rework foo: step 3/4 (Apply 0123456), failed"},
+		{"next", q(rework), q(), q(item("Apply", "c")), plan, "Rework foo: step 4/4 (Apply c), next"},
+		{"no plan", q(rework), q(item("Apply", "a")), q(), q(), "Rework foo: Apply a, failed"},
+	}
+	for _, tt := range tests {
+		var got string
+		if p := nestedProgress(tt.outerCurrent, q(), tt.current, tt.nested, tt.plan); p != nil {
+			state := "next"
+			if p.Failed {
+				state = "failed"
+			}
+			got = p.String() + ", " + state
+		}
+		if got != tt.want {
+			t.Errorf("%s: nestedProgress() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAutosquashItems(t *testing.T) {
+	item := func(op string, args ...string) queue.Item { return queue.Item{Operation: op, Args: args} }
+	patches := []squashPatch{
+		{id: "aaaaaaaaaa", subject: "Add widget"},
+		{id: "bbbbbbbbbb", subject: "Fix frobnicator"},
+	}
+	floating := []squashPatch{
+		{id: "cccccccccc", subject: "fixup! Add widget"},
+		{id: "dddddddddd", subject: "Tweak frobnicator", message: "Tweak frobnicator\n\nFixes-Patch: bbbbbbb\n"},
+		{id: "eeeeeeeeee", subject: "Add gadget"},
+		{id: "ffffffffff", subject: "squash! fixup! Add gad"},
+		{id: "0000000000", subject: "fixup! Unknown patch"},
+	}
+	want := []queue.Item{
+		item("Apply", "aaaaaaaaaa"),
+		item("Squash", "cccccccccc", "fixup"),
+		item("Apply", "bbbbbbbbbb"),
+		item("Squash", "dddddddddd", "fixup"),
+		item("Cherrypick", "eeeeeeeeee"),
+		item("Squash", "ffffffffff", "squash"),
+		item("Cherrypick", "0000000000"),
+	}
+	if diff := cmp.Diff(autosquashItems(patches, floating), want); diff != "" {
+		t.Errorf("autosquashItems() returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSquashedMessage(t *testing.T) {
+	described := patchset.New("foo")
+	described.SetDescription("Add widgets\n\nWidgets are useful.\n")
+	tests := []struct {
+		name     string
+		p        *patchset.Patchset
+		subjects []string
+		want     string
+	}{
+		{"name only", patchset.New("foo"), nil, "foo\n\nPatchset: foo v1\n"},
+		{"description", described, []string{"Add a widget", "Fix the widget"}, "Add widgets\n\nWidgets are useful.\n\nSquashed patches:\n  * Add a widget\n  * Fix the widget\n\nPatchset: foo v1\n"},
+	}
+	for _, tt := range tests {
+		if got := squashedMessage(tt.p, tt.subjects); got != tt.want {
+			t.Errorf("%s: squashedMessage() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAppliedPatch(t *testing.T) {
+	tests := []struct {
+		item  queue.Item
+		patch string
+		ok    bool
+	}{
+		{queue.Item{Operation: "Apply", Args: []string{"abc"}}, "abc", true},
+		{queue.Item{Operation: "Cherrypick", Args: []string{"def"}}, "def", true},
+		{queue.Item{Operation: "Apply"}, "", false},
+		{queue.Item{Operation: "Rework", Args: []string{"foo"}}, "", false},
+	}
+	for _, tt := range tests {
+		patch, ok := AppliedPatch(tt.item)
+		if patch != tt.patch || ok != tt.ok {
+			t.Errorf("AppliedPatch(%v) = %q, %t, want %q, %t", tt.item, patch, ok, tt.patch, tt.ok)
+		}
+	}
+}
+
+func TestPatchesUpTo(t *testing.T) {
+	patches := []string{"a", "b", "c"}
+	tests := []struct {
+		upTo string
+		want []string
+	}{
+		{"a", []string{"a"}},
+		{"b", []string{"a", "b"}},
+		{"c", []string{"a", "b", "c"}},
+		{"", []string{"a", "b", "c"}},
+		{"d", []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(patchesUpTo(patches, tt.upTo), tt.want); diff != "" {
+			t.Errorf("patchesUpTo(%q) returned diff (-got +want):\n%s", tt.upTo, diff)
+		}
+	}
+}
+
+type fakeDependencies map[string][]*patchset.Patchset
+
+func (d fakeDependencies) Dependencies(ps *patchset.Patchset) []*patchset.Patchset {
+	return d[ps.Name()]
+}
+
+func TestReorderPatchsets(t *testing.T) {
+	a, b, c := patchset.New("a"), patchset.New("b"), patchset.New("c")
+	patchsets := repo.PatchsetCache{
+		Slice: []*patchset.Patchset{a, b, c},
+		Index: map[string]int{"a": 0, "b": 1, "c": 2},
+		Map:   map[string]*patchset.Patchset{"a": a, "b": b, "c": c},
+	}
+	deps := fakeDependencies{"c": {a}}
+	tests := []struct {
+		order   string
+		want    []string
+		wantErr bool
+	}{
+		{order: "# comment\nb\n\na\nc\n", want: []string{"b", "a", "c"}},
+		{order: "a\nc\nb\n", want: []string{"a", "c", "b"}},
+		{order: "c\na\nb\n", wantErr: true},
+		{order: "a\nb\n", wantErr: true},
+		{order: "a\nb\nc\nb\n", wantErr: true},
+		{order: "a\nb\nc\nd\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		ordered, err := reorderPatchsets(patchsets, parseOrder([]byte(tt.order)), deps)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("reorderPatchsets(%q) returned error %v, want error %t", tt.order, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, ps := range ordered {
+			got = append(got, ps.Name())
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("reorderPatchsets(%q) returned diff (-got +want):\n%s", tt.order, diff)
+		}
+	}
+}
+
+func TestVersionPolicyBumps(t *testing.T) {
+	tests := []struct {
+		policy             VersionPolicy
+		changed, requested bool
+		want               bool
+	}{
+		{BumpAlways, false, false, true},
+		{BumpOnChange, false, false, false},
+		{BumpOnChange, true, false, true},
+		{BumpManual, true, false, false},
+		{BumpManual, false, true, true},
+		{BumpOnChange, false, true, true},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.bumps(tt.changed, tt.requested); got != tt.want {
+			t.Errorf("%s.bumps(%t, %t) = %t, want %t", tt.policy, tt.changed, tt.requested, got, tt.want)
+		}
+	}
+}
+
+// addDependency makes the named patchset depend on dep in the kilt branch.
+func addDependency(t *testing.T, name, dep string) {
+	r, err := repo.Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		t.Fatalf("PatchsetMap(): %v", err)
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	if err = deps.Add(patchsets[name], patchsets[dep]); err != nil {
+		t.Fatalf("Add(%q, %q): %v", name, dep, err)
+	}
+	if err = dependency.Save(r, deps); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+}
+
+// dependencyNames returns the names of the patchsets the named patchset
+// depends on in the kilt branch, or in the rework in progress.
+func dependencyNames(t *testing.T, name string) []string {
+	r, err := repo.Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		t.Fatalf("PatchsetMap(): %v", err)
+	}
+	ps, ok := patchsets[name]
+	if !ok {
+		t.Fatalf("patchset %q not found", name)
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	var names []string
+	for _, d := range deps.Dependencies(ps) {
+		names = append(names, d.Name())
+	}
+	return names
+}
+
+// runUntil executes the operations of the command up to the next one named op.
+func runUntil(t *testing.T, c *Command, op string) {
+	for next := c.executor.Peek(); next != nil && next.Operation != op; next = c.executor.Peek() {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("Execute(%s): %v", next.Operation, err)
+		}
+	}
+}
+
+// runAll executes the remaining operations of the command and saves it.
+func runAll(t *testing.T, c *Command) {
+	if err := c.ExecuteAll(); err != nil {
+		t.Fatalf("ExecuteAll(): %v", err)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+}
+
+// TestCommandOptions checks that commands created with options work on the
+// repo of their directory from elsewhere, and report to their reporter from
+// the start, including the messages of their constructors.
+func TestCommandOptions(t *testing.T) {
+	g := crashRepo(t, "CommandOptions")
+	dir := g.Workdir()
+	defer os.RemoveAll(dir)
+	os.Chdir(os.TempDir())
+	defer os.Chdir(dir)
+	rec := &reporter.Recorder{}
+	opts := Options{Dir: dir, Reporter: rec}
+	c, err := NewBeginCommandWithOptions(opts, AllTargets{})
+	if err != nil {
+		t.Fatalf("NewBeginCommandWithOptions(): %v", err)
+	}
+	runUntil(t, c, "UpdateHead")
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	if _, err := NewSkipCommandWithOptions(opts); err != nil {
+		t.Fatalf("NewSkipCommandWithOptions(): %v", err)
+	}
+	skipped := false
+	for _, e := range rec.Events() {
+		if e.Type == reporter.TypeOperation && e.Operation == "Skip" {
+			skipped = true
+		}
+	}
+	if !skipped {
+		t.Errorf("events %v: skip not reported", rec.Events())
+	}
+	c, err = NewAbortCommandWithOptions(opts)
+	if err != nil {
+		t.Fatalf("NewAbortCommandWithOptions(): %v", err)
+	}
+	runAll(t, c)
+	if err := ReleaseLock(c.repo); err != nil {
+		t.Errorf("ReleaseLock(): %v", err)
+	}
+}
+
+// writeFile writes the file in the work tree, without committing it.
+func writeFile(t *testing.T, g *git.Repository, file, content string) {
+	if err := ioutil.WriteFile(filepath.Join(g.Workdir(), file), []byte(content), 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+}
+
+// skippedItems returns the operations skipped during the rework in progress.
+func skippedItems(t *testing.T) []queue.Item {
+	r, err := repo.Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	q, err := SkippedWork(r)
+	if err != nil {
+		t.Fatalf("SkippedWork(): %v", err)
+	}
+	return q.Items
+}
+
+// TestSkipPatchsetOperation checks that skipping a failed operation of a
+// patchset queue skips only that operation, and the rest of the rework goes
+// on.
+func TestSkipPatchsetOperation(t *testing.T) {
+	g := crashRepo(t, "SkipPatchsetOperation")
+	defer os.RemoveAll(g.Workdir())
+	head, err := g.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	floating := head.Target().String()
+	c, err := NewBeginCommand(FloatingTargets{})
+	if err != nil {
+		t.Fatalf("NewBeginCommand(): %v", err)
+	}
+	runUntil(t, c, "Rework")
+	// An untracked file in the way fails the cherry-pick of the floating
+	// patch of a.
+	writeFile(t, g, "a2", "untracked\n")
+	if err := c.Execute(); err == nil {
+		t.Fatal("Execute(Rework) succeeded, want failed cherry-pick")
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	c, err = NewSkipCommand()
+	if err != nil {
+		t.Fatalf("NewSkipCommand(): %v", err)
+	}
+	if err := os.Remove(filepath.Join(g.Workdir(), "a2")); err != nil {
+		t.Fatalf("Remove(): %v", err)
+	}
+	runAll(t, c)
+	want := []queue.Item{{Operation: "Cherrypick", Args: []string{floating}}}
+	if diff := cmp.Diff(skippedItems(t), want); diff != "" {
+		t.Errorf("skipped operations returned diff (-got +want):\n%s", diff)
+	}
+	if current, q, err := readRecoveredState(newStateFile(c.repo, reworkQueueName)); err != nil {
+		t.Fatalf("readRecoveredState(): %v", err)
+	} else if len(current.Items)+len(q.Items) > 0 {
+		t.Errorf("rework state after skip = %v, %v, want every operation done", current.Items, q.Items)
+	}
+	runUntilCrash(t, NewAbortCommand)
+}
+
+// TestSkipReworkOperation checks that skipping a failed operation of the rework
+// queue discards the changes it left, and continues with the next operation.
+func TestSkipReworkOperation(t *testing.T) {
+	g := crashRepo(t, "SkipReworkOperation")
+	defer os.RemoveAll(g.Workdir())
+	c, err := NewBeginCommand(FloatingTargets{})
+	if err != nil {
+		t.Fatalf("NewBeginCommand(): %v", err)
+	}
+	runUntil(t, c, "CheckoutBase")
+	// A change to a file the base doesn't have fails the checkout.
+	writeFile(t, g, "a", "changed\n")
+	if err := c.Execute(); err == nil {
+		t.Fatal("Execute(CheckoutBase) succeeded, want failed checkout")
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	c, err = NewSkipCommand()
+	if err != nil {
+		t.Fatalf("NewSkipCommand(): %v", err)
+	}
+	if diff := cmp.Diff(skippedItems(t), []queue.Item{{Operation: "CheckoutBase"}}); diff != "" {
+		t.Errorf("skipped operations returned diff (-got +want):\n%s", diff)
+	}
+	if next := c.executor.Peek(); next == nil || next.Operation != "Rework" {
+		t.Errorf("next operation after skip = %v, want Rework", next)
+	}
+	if clean, err := c.repo.WorkTreeClean(); err != nil || !clean {
+		t.Errorf("WorkTreeClean() after skip = %t, %v, want changes discarded", clean, err)
+	}
+	runUntilCrash(t, NewAbortCommand)
+}
+
+// TestSkipDirtyWorkTree checks that skipping when no operation failed leaves
+// the changes in the work tree alone, refusing to skip.
+func TestSkipDirtyWorkTree(t *testing.T) {
+	g := crashRepo(t, "SkipDirtyWorkTree")
+	defer os.RemoveAll(g.Workdir())
+	c, err := NewBeginCommand(FloatingTargets{})
+	if err != nil {
+		t.Fatalf("NewBeginCommand(): %v", err)
+	}
+	runUntil(t, c, "CheckoutBase")
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	writeFile(t, g, "a", "changed\n")
+	if _, err := NewSkipCommand(); err == nil {
+		t.Error("NewSkipCommand() succeeded with changes in the work tree, want error")
+	}
+	if len(skippedItems(t)) > 0 {
+		t.Errorf("skipped operations = %v, want none", skippedItems(t))
+	}
+	if clean, err := c.repo.WorkTreeClean(); err != nil || clean {
+		t.Errorf("WorkTreeClean() = %t, %v, want changes kept", clean, err)
+	}
+	if err := c.repo.ResetToHead(); err != nil {
+		t.Fatalf("ResetToHead(): %v", err)
+	}
+	runUntilCrash(t, NewAbortCommand)
+}
+
+func TestRenameDependencies(t *testing.T) {
+	tests := []struct {
+		desc       string
+		newCommand func() (*Command, error)
+	}{
+		{"rename", func() (*Command, error) { return NewRenameCommand("a", "c") }},
+		{"edit", func() (*Command, error) {
+			return NewEditCommand("a", func(_ []byte) ([]byte, error) { return []byte("c\n"), nil })
+		}},
+	}
+	for _, tt := range tests {
+		g := crashRepo(t, "RenameDependencies")
+		addDependency(t, "b", "a")
+		c, err := tt.newCommand()
+		if err != nil {
+			t.Fatalf("%s: new command: %v", tt.desc, err)
+		}
+		runUntil(t, c, "UpdateHead")
+		// Until the rework finishes, the graph has to match the original
+		// branch, so that resumed operations can load it.
+		if diff := cmp.Diff(dependencyNames(t, "b"), []string{"a"}); diff != "" {
+			t.Errorf("%s: dependencies during rework returned diff (-got +want):\n%s", tt.desc, diff)
+		}
+		runAll(t, c)
+		if diff := cmp.Diff(dependencyNames(t, "b"), []string{"c"}); diff != "" {
+			t.Errorf("%s: dependencies after rework returned diff (-got +want):\n%s", tt.desc, diff)
+		}
+		// Renaming again, as a rework interrupted while finishing would,
+		// has no effect.
+		r, err := repo.Open()
+		if err != nil {
+			t.Fatalf("%s: Open(): %v", tt.desc, err)
+		}
+		if err := renameDependencies(r, []string{"a", "c"}); err != nil {
+			t.Fatalf("%s: renameDependencies() again: %v", tt.desc, err)
+		}
+		if diff := cmp.Diff(dependencyNames(t, "b"), []string{"c"}); diff != "" {
+			t.Errorf("%s: dependencies after renaming again returned diff (-got +want):\n%s", tt.desc, diff)
+		}
+		os.RemoveAll(g.Workdir())
+	}
+}
+
+func TestFailedDependencyUpdate(t *testing.T) {
+	g := crashRepo(t, "FailedDependencyUpdate")
+	defer os.RemoveAll(g.Workdir())
+	addDependency(t, "b", "a")
+	c, err := NewRenameCommand("a", "c")
+	if err != nil {
+		t.Fatalf("NewRenameCommand(): %v", err)
+	}
+	runUntil(t, c, "Finish")
+	if err := recordDependencyUpdate(c.repo, queue.Item{Operation: "AddDependencies", Args: []string{"b", "missing"}}); err != nil {
+		t.Fatalf("recordDependencyUpdate(): %v", err)
+	}
+	if err := c.ExecuteAll(); err == nil {
+		t.Fatal("ExecuteAll() succeeded, want failed dependency update")
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	if inProgress, err := c.repo.ReworkInProgress(); err != nil || !inProgress {
+		t.Fatalf("ReworkInProgress() = %t, %v, want rework left to continue", inProgress, err)
+	}
+	// The rename was applied before the failed update, and isn't applied
+	// twice when continuing.
+	if diff := cmp.Diff(dependencyNames(t, "b"), []string{"c"}); diff != "" {
+		t.Errorf("dependencies after failed finish returned diff (-got +want):\n%s", diff)
+	}
+	// Drop the failed update, as a fixed dependency graph would let it
+	// apply, and continue.
+	if err := newStateFile(c.repo, dependencyQueueName).ClearQueueState(); err != nil {
+		t.Fatalf("ClearQueueState(): %v", err)
+	}
+	runUntilCrash(t, NewContinueCommand)
+	if inProgress, err := c.repo.ReworkInProgress(); err != nil || inProgress {
+		t.Errorf("ReworkInProgress() after continue = %t, %v, want finished rework", inProgress, err)
+	}
+	if diff := cmp.Diff(dependencyNames(t, "b"), []string{"c"}); diff != "" {
+		t.Errorf("dependencies after continue returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestAbortKeepsDependencies(t *testing.T) {
+	g := crashRepo(t, "AbortKeepsDependencies")
+	defer os.RemoveAll(g.Workdir())
+	addDependency(t, "b", "a")
+	c, err := NewDeleteCommand("b", "", "")
+	if err != nil {
+		t.Fatalf("NewDeleteCommand(): %v", err)
+	}
+	runUntil(t, c, "Finish")
+	if diff := cmp.Diff(dependencyNames(t, "b"), []string{"a"}); diff != "" {
+		t.Errorf("dependencies during delete returned diff (-got +want):\n%s", diff)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	runUntilCrash(t, NewAbortCommand)
+	if diff := cmp.Diff(dependencyNames(t, "b"), []string{"a"}); diff != "" {
+		t.Errorf("dependencies after abort returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestDeleteRehome(t *testing.T) {
+	g := crashRepo(t, "DeleteRehome")
+	defer os.RemoveAll(g.Workdir())
+	addDependency(t, "b", "a")
+	tree := branchTree(t, g)
+	c, err := NewDeleteCommand("b", "a", "")
+	if err != nil {
+		t.Fatalf("NewDeleteCommand(): %v", err)
+	}
+	runAll(t, c)
+	if got := branchTree(t, g); got != tree {
+		t.Errorf("tree after delete = %s, want unchanged %s", got, tree)
+	}
+	r, err := repo.Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		t.Fatalf("Patchsets(): %v", err)
+	}
+	var names, subjects []string
+	for _, ps := range patchsets {
+		names = append(names, ps.Name())
+		for _, patch := range ps.Patches() {
+			id, err := git.NewOid(patch)
+			if err != nil {
+				t.Fatalf("NewOid(): %v", err)
+			}
+			commit, err := g.LookupCommit(id)
+			if err != nil {
+				t.Fatalf("LookupCommit(): %v", err)
+			}
+			subjects = append(subjects, commit.Summary())
+		}
+	}
+	if diff := cmp.Diff(names, []string{"a"}); diff != "" {
+		t.Errorf("patchsets returned diff (-got +want):\n%s", diff)
+	}
+	// The patches of b follow those of a, as with kilt move.
+	if diff := cmp.Diff(subjects, []string{"Add a", "Add a2", "Add b"}); diff != "" {
+		t.Errorf("patches returned diff (-got +want):\n%s", diff)
+	}
+	if dangling, err := dependency.Dangling(r); err != nil || len(dangling) != 0 {
+		t.Errorf("Dangling() = %v, %v, want b removed from the graph", dangling, err)
+	}
+}
+
+// archivedNames returns the names of the archived patchsets of the kilt
+// branch.
+func archivedNames(t *testing.T) []string {
+	r, err := repo.Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		t.Fatalf("Patchsets(): %v", err)
+	}
+	var names []string
+	for _, ps := range patchsets {
+		if ps.Archived() {
+			names = append(names, ps.Name())
+		}
+	}
+	return names
+}
+
+func TestArchiveDependents(t *testing.T) {
+	tests := []struct {
+		cascade      string
+		wantArchived []string
+		wantDeps     []string
+	}{
+		{CascadeEdges, []string{"a"}, nil},
+		{CascadeDependents, []string{"a", "b"}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		g := crashRepo(t, "ArchiveDependents")
+		addDependency(t, "b", "a")
+		var e *ErrHasDependents
+		if _, err := NewArchiveCommand("a", true, ""); !errors.As(err, &e) {
+			t.Errorf("%s: NewArchiveCommand() without cascade: got %v, want ErrHasDependents", tt.cascade, err)
+		}
+		runUntilCrash(t, func() (*Command, error) { return NewArchiveCommand("a", true, tt.cascade) })
+		if diff := cmp.Diff(archivedNames(t), tt.wantArchived); diff != "" {
+			t.Errorf("%s: archived patchsets returned diff (-got +want):\n%s", tt.cascade, diff)
+		}
+		if diff := cmp.Diff(dependencyNames(t, "b"), tt.wantDeps); diff != "" {
+			t.Errorf("%s: dependencies returned diff (-got +want):\n%s", tt.cascade, diff)
+		}
+		os.RemoveAll(g.Workdir())
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		dependents string
+		wantDeps   []string
+	}{
+		{CopyDependents, []string{"a", "c"}},
+		{MoveDependents, []string{"c"}},
+	}
+	for _, tt := range tests {
+		g := crashRepo(t, "Split")
+		// Rework the floating patch into patchset a, which can then be split
+		// at it.
+		runUntilCrash(t, func() (*Command, error) { return NewBeginCommand(FloatingTargets{}) })
+		runUntilCrash(t, func() (*Command, error) { return NewFinishCommand(false) })
+		addDependency(t, "b", "a")
+		r, err := repo.Open()
+		if err != nil {
+			t.Fatalf("Open(): %v", err)
+		}
+		patchsets, err := r.PatchsetMap()
+		if err != nil {
+			t.Fatalf("PatchsetMap(): %v", err)
+		}
+		at := patchsets["a"].Patches()[1]
+		runUntilCrash(t, func() (*Command, error) { return NewSplitCommand("a", at, "c", tt.dependents) })
+		if inProgress, err := r.ReworkInProgress(); err != nil || inProgress {
+			t.Errorf("%s: ReworkInProgress() = %t, %v, want finished rework", tt.dependents, inProgress, err)
+		}
+		if r, err = repo.Open(); err != nil {
+			t.Fatalf("Open(): %v", err)
+		}
+		split, err := r.Patchsets()
+		if err != nil {
+			t.Fatalf("Patchsets(): %v", err)
+		}
+		var names []string
+		for _, ps := range split {
+			names = append(names, ps.Name())
+		}
+		if diff := cmp.Diff(names, []string{"a", "c", "b"}); diff != "" {
+			t.Errorf("%s: patchsets returned diff (-got +want):\n%s", tt.dependents, diff)
+		}
+		if diff := cmp.Diff(dependencyNames(t, "c"), []string{"a"}); diff != "" {
+			t.Errorf("%s: dependencies of c returned diff (-got +want):\n%s", tt.dependents, diff)
+		}
+		if diff := cmp.Diff(dependencyNames(t, "b"), tt.wantDeps); diff != "" {
+			t.Errorf("%s: dependencies of b returned diff (-got +want):\n%s", tt.dependents, diff)
+		}
+		// Recording the dependencies again, as a split interrupted while
+		// finishing would, has no effect.
+		if err := splitDependencies(r, "a", "c", tt.dependents); err != nil {
+			t.Fatalf("%s: splitDependencies() again: %v", tt.dependents, err)
+		}
+		if diff := cmp.Diff(dependencyNames(t, "b"), tt.wantDeps); diff != "" {
+			t.Errorf("%s: dependencies of b after recording again returned diff (-got +want):\n%s", tt.dependents, diff)
+		}
+		os.RemoveAll(g.Workdir())
+	}
+}
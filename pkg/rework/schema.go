@@ -0,0 +1,111 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"fmt"
+
+	log "github.com/golang/glog"
+
+	"github.com/google/kilt/pkg/internal/version"
+	"github.com/google/kilt/pkg/queue"
+)
+
+// operationSchema is the version of the names and arguments of the operations
+// saved in rework queues. It must be increased whenever an operation is
+// renamed or removed, or its arguments change, along with a migration from
+// the previous schema, so that reworks started by an older kilt can be
+// continued.
+const operationSchema = 1
+
+// migrations upgrade the items of a saved queue from the schema they are keyed
+// by to the next one.
+var migrations = map[int]func(items []queue.Item) ([]queue.Item, error){
+	// Queues saved in the text format, before the schema was recorded, hold
+	// the operations of the first schema, except for Skip. It cleared the
+	// queue of the rework being skipped, and is dropped, as skipping is no
+	// longer an operation of the rework.
+	0: func(items []queue.Item) ([]queue.Item, error) {
+		var migrated []queue.Item
+		for _, item := range items {
+			if item.Operation != "Skip" {
+				migrated = append(migrated, item)
+			}
+		}
+		return migrated, nil
+	},
+}
+
+// ErrIncompatibleState is returned when the saved state of a rework was
+// written by a kilt whose operations this kilt can't run.
+type ErrIncompatibleState struct {
+	KiltVersion string
+	Schema      int
+	// Operation is the unknown operation found in the state, if any.
+	Operation string
+}
+
+func (e *ErrIncompatibleState) Error() string {
+	saved := "another kilt"
+	if e.KiltVersion != "" {
+		saved = "kilt " + e.KiltVersion
+	}
+	problem := fmt.Sprintf("uses operation schema %d, which kilt %s can't migrate", e.Schema, version.String())
+	if e.Operation != "" {
+		problem = fmt.Sprintf("holds operation %q, unknown to kilt %s", e.Operation, version.String())
+	}
+	return fmt.Sprintf("rework state saved by %s %s; finish the rework with that version, or run kilt rework --abort", saved, problem)
+}
+
+// migrateQueue upgrades the queue saved under the header to the current
+// operation schema.
+func migrateQueue(h queue.Header, q queue.Queue) (queue.Queue, error) {
+	if h.Schema == operationSchema {
+		return q, nil
+	}
+	incompatible := &ErrIncompatibleState{KiltVersion: h.KiltVersion, Schema: h.Schema}
+	if h.Schema > operationSchema {
+		return q, incompatible
+	}
+	log.V(1).Infof("Migrating rework state saved by kilt %q from operation schema %d to %d", h.KiltVersion, h.Schema, operationSchema)
+	items := q.Items
+	for s := h.Schema; s < operationSchema; s++ {
+		migrate, ok := migrations[s]
+		if !ok {
+			return q, incompatible
+		}
+		var err error
+		if items, err = migrate(items); err != nil {
+			return q, fmt.Errorf("failed to migrate rework state from operation schema %d: %w", s, err)
+		}
+	}
+	return queue.Queue{Items: items}, nil
+}
+
+// checkOperations checks that the executor can run the saved items, which
+// were written under the header.
+func checkOperations(e *queue.Executor, h queue.Header, items []queue.Item) error {
+	for _, item := range items {
+		if !e.Registered(item.Operation) {
+			return &ErrIncompatibleState{KiltVersion: h.KiltVersion, Schema: h.Schema, Operation: item.Operation}
+		}
+		if err := e.Check(item); err != nil {
+			return fmt.Errorf("invalid saved rework operation: %w", err)
+		}
+	}
+	return nil
+}
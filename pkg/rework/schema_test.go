@@ -0,0 +1,81 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/kilt/pkg/queue"
+)
+
+func TestMigrateQueue(t *testing.T) {
+	q := queue.Queue{Items: []queue.Item{{Operation: "Apply", Args: []string{"a"}}}}
+	tests := []struct {
+		desc    string
+		header  queue.Header
+		wantErr bool
+	}{
+		{desc: "Current schema", header: queue.Header{Schema: operationSchema}},
+		{desc: "Text format", header: queue.Header{}},
+		{desc: "Newer schema", header: queue.Header{KiltVersion: "v9.0.0", Schema: operationSchema + 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := migrateQueue(tt.header, q)
+		var incompatible *ErrIncompatibleState
+		if tt.wantErr {
+			if !errors.As(err, &incompatible) {
+				t.Errorf("%s: migrateQueue() error = %v, want ErrIncompatibleState", tt.desc, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: migrateQueue(): %v", tt.desc, err)
+			continue
+		}
+		if diff := cmp.Diff(got, q); diff != "" {
+			t.Errorf("%s: migrateQueue() returned diff (-got +want):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestMigrateSkip(t *testing.T) {
+	q := queue.Queue{Items: []queue.Item{{Operation: "Skip"}, {Operation: "Apply", Args: []string{"a"}}}}
+	got, err := migrateQueue(queue.Header{}, q)
+	if err != nil {
+		t.Fatalf("migrateQueue(): %v", err)
+	}
+	want := queue.Queue{Items: []queue.Item{{Operation: "Apply", Args: []string{"a"}}}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("migrateQueue() returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestCheckOperations(t *testing.T) {
+	e := queue.NewExecutor()
+	e.Register(queue.Operation{Name: "Apply", Execute: func([]string) error { return nil }})
+	if err := checkOperations(&e, queue.Header{}, []queue.Item{{Operation: "Apply"}}); err != nil {
+		t.Errorf("checkOperations(): %v", err)
+	}
+	err := checkOperations(&e, queue.Header{KiltVersion: "v9.0.0"}, []queue.Item{{Operation: "Apply"}, {Operation: "Transmogrify"}})
+	var incompatible *ErrIncompatibleState
+	if !errors.As(err, &incompatible) || incompatible.Operation != "Transmogrify" {
+		t.Errorf("checkOperations() = %v, want ErrIncompatibleState for %q", err, "Transmogrify")
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+)
+
+const (
+	// CopyDependents makes the patchsets depending on a split patchset depend
+	// on both of its halves.
+	CopyDependents = "copy"
+	// MoveDependents makes the patchsets depending on a split patchset depend
+	// on its second half instead, which depends on the first.
+	MoveDependents = "move"
+)
+
+// NewSplitCommand returns a command that splits the patchset in two through a
+// rework. The patches from the commit at onwards are reassigned to a new
+// patchset named newName, created right after the original. The new patchset
+// depends on the original and on the patchsets the original depends on; the
+// patchsets depending on the original are updated as set by dependents,
+// CopyDependents or MoveDependents. The dependencies are recorded once the
+// rework finishes.
+func NewSplitCommand(name, at, newName, dependents string) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	if dependents != CopyDependents && dependents != MoveDependents {
+		return nil, fmt.Errorf("invalid dependents mode %q", dependents)
+	}
+	if !repo.ValidPatchsetName(newName) {
+		return nil, fmt.Errorf("invalid patchset name %q", newName)
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := patchsets.Map[name]
+	if !ok || p.MetadataCommit() == "" {
+		return nil, fmt.Errorf("patchset %q not found", name)
+	}
+	if other, ok := patchsets.Lookup(newName); ok {
+		return nil, fmt.Errorf("patchset %q already exists", other.Name())
+	}
+	if len(p.FloatingPatches()) > 0 {
+		return nil, fmt.Errorf("patchset %q has floating patches and must be reworked first", name)
+	}
+	id, err := c.repo.ResolveCommit(at)
+	if err != nil {
+		return nil, err
+	}
+	if splitIndex(p.Patches(), id) < 0 {
+		return nil, fmt.Errorf("commit %s is not a patch of patchset %q", id, name)
+	}
+	if splitIndex(p.Patches(), id) == 0 {
+		return nil, errors.New("can't split at the first patch of a patchset, use kilt rename instead")
+	}
+	if err = c.enqueueRebuild(patchsets, map[int]queue.Item{
+		patchsets.Index[name]: {Operation: "Split", Args: []string{name, newName, id}},
+	}); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	// The new patchset only exists on the branch once the rework finishes,
+	// which is when its dependencies are recorded.
+	if err = c.executor.Enqueue("SplitDependencies", name, newName, dependents); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func splitIndex(patches []string, id string) int {
+	for i, patch := range patches {
+		if patch == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitPatchset applies the patches of the patchset before the commit at, then
+// creates the new patchset and reassigns the remaining patches to it.
+func (c *Command) splitPatchset(name, newName, at string) error {
+	patchsets, err := c.repo.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets[name]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	i := splitIndex(p.Patches(), at)
+	if i < 0 {
+		return fmt.Errorf("commit %s is not a patch of patchset %q", at, name)
+	}
+	metadata, err := c.updateMetadataArgs(p, true, false)
+	if err != nil {
+		return err
+	}
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		if err := e.Enqueue("UpdateMetadata", metadata...); err != nil {
+			return err
+		}
+		for _, patch := range p.Patches()[:i] {
+			if err := e.Enqueue("Apply", patch); err != nil {
+				return err
+			}
+		}
+		if err := e.Enqueue("CreateMetadata", newName); err != nil {
+			return err
+		}
+		for _, patch := range p.Patches()[i:] {
+			if err := e.Enqueue("Reassign", patch, newName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// splitDependencies records the dependencies of the new patchset split from
+// the original in the reworked branch, and updates the dependents of the
+// original as set by dependents. Recording them again has no effect.
+func splitDependencies(r *repo.Repo, name, newName, dependents string) error {
+	patchsets, err := r.PatchsetCache()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets.Map[name]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	split, ok := patchsets.Map[newName]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", newName)
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		return err
+	}
+	for _, dep := range append(deps.Dependencies(p), p) {
+		if err := ensureDependency(deps, split, dep); err != nil {
+			return err
+		}
+	}
+	for _, d := range deps.Dependents(p) {
+		if d.SameAs(split) {
+			continue
+		}
+		if dependents == MoveDependents {
+			if err := deps.Remove(d, p); err != nil {
+				return err
+			}
+		}
+		if err := ensureDependency(deps, d, split); err != nil {
+			return err
+		}
+	}
+	return dependency.Save(r, deps)
+}
+
+// ensureDependency makes ps depend on dep, unless it already does.
+func ensureDependency(deps *dependency.StructGraph, ps, dep *patchset.Patchset) error {
+	for _, d := range deps.Dependencies(ps) {
+		if d.SameAs(dep) {
+			return nil
+		}
+	}
+	return deps.Add(ps, dep)
+}
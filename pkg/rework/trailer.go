@@ -0,0 +1,138 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rework
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/google/kilt/pkg/queue"
+)
+
+var trailerKeyRegexp = regexp.MustCompile("^[-[:alnum:]]+$")
+
+// NewTrailerCommand returns a command that sets the key trailer to value in the
+// patches of the named patchsets, or of all patchsets if none are named,
+// through a message-only rework. If add is set, the trailer is added to the
+// patches even if they already have a key trailer.
+func NewTrailerCommand(names []string, key, value string, add bool) (*Command, error) {
+	c, err := newReworkCommand()
+	if err != nil {
+		return nil, err
+	}
+	if !trailerKeyRegexp.MatchString(key) {
+		return nil, fmt.Errorf("invalid trailer key %q", key)
+	}
+	if value == "" || strings.Contains(value, "\n") {
+		return nil, fmt.Errorf("invalid trailer value %q", value)
+	}
+	patchsets, err := c.repo.PatchsetCache()
+	if err != nil {
+		return nil, err
+	}
+	selected := map[string]bool{}
+	for _, name := range names {
+		if _, ok := patchsets.Map[name]; !ok {
+			return nil, fmt.Errorf("patchset %q not found", name)
+		}
+		selected[name] = true
+	}
+	mode := "set"
+	if add {
+		mode = "add"
+	}
+	ops := map[int]queue.Item{}
+	for i, ps := range patchsets.Slice {
+		if len(names) > 0 && !selected[ps.Name()] {
+			continue
+		}
+		if len(ps.FloatingPatches()) > 0 {
+			return nil, fmt.Errorf("patchset %q has floating patches and must be reworked first", ps.Name())
+		}
+		ops[i] = queue.Item{Operation: "Trailer", Args: []string{ps.Name(), mode, key, url.QueryEscape(value)}}
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("no patchsets selected")
+	}
+	if err = c.enqueueRebuild(patchsets, ops); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Validate"); err != nil {
+		return nil, err
+	}
+	if err = c.executor.Enqueue("Finish"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// trailerPatchset applies the patchset, rewriting the trailers of its patches.
+// The trailer value is query escaped, as queue arguments can't contain spaces.
+func (c *Command) trailerPatchset(name, mode, key, value string) error {
+	r := c.repo
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	p, ok := patchsets[name]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	op := "SetTrailer"
+	if mode == "add" {
+		op = "AddTrailer"
+	}
+	return c.executeReworkQueue(func(e *queue.Executor) error {
+		if p.MetadataCommit() != "" {
+			if err := e.Enqueue("Apply", p.MetadataCommit()); err != nil {
+				return err
+			}
+		}
+		for _, patch := range p.Patches() {
+			if err := e.Enqueue(op, patch, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// setTrailer cherry-picks the patch in args to head, setting the trailer
+// given by the key and query escaped value in args.
+func (c *Command) setTrailer(args []string, add bool) error {
+	r := c.repo
+	if len(args) < 3 {
+		return errors.New("patch, key and value required")
+	}
+	value, err := url.QueryUnescape(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid trailer value %q: %w", args[2], err)
+	}
+	desc, err := r.DescribeCommit(args[0])
+	if err != nil {
+		return err
+	}
+	op := "SetTrailer"
+	if add {
+		op = "AddTrailer"
+	}
+	c.report(op, "Setting %s: %s on %s", args[1], value, desc)
+	return r.SetTrailerToHead(args[0], args[1], value, add)
+}
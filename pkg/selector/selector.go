@@ -0,0 +1,325 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package selector parses selector expressions, which select patchsets by
+// name, tag, dependencies and whether they have floating patches.
+//
+// An expression combines terms with && (and), || (or), ! (not) and
+// parentheses. The terms are:
+//
+//	all           every patchset
+//	floating      patchsets with floating patches
+//	archived      archived patchsets
+//	<name>        the patchset with the name
+//	name=<name>   the patchset with the name, even if it is a keyword
+//	name~<regexp> patchsets whose name matches the regular expression
+//	tag:<tag>     patchsets tagged with the tag
+//	depends(<n>)  patchsets depending on patchset n, directly or not
+//
+// Values can be quoted with ' or " to include spaces or operator characters,
+// as in name~'^(net|fs)-'.
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+// Graph gives the dependencies of patchsets, for the depends term.
+type Graph interface {
+	TransitiveDependencies(ps *patchset.Patchset) []*patchset.Patchset
+}
+
+// Expr is a parsed selector expression.
+type Expr interface {
+	// Match reports whether the expression selects the patchset. If g is nil,
+	// depends terms select nothing.
+	Match(p *patchset.Patchset, g Graph) bool
+	String() string
+}
+
+type and [2]Expr
+
+func (e and) Match(p *patchset.Patchset, g Graph) bool {
+	return e[0].Match(p, g) && e[1].Match(p, g)
+}
+
+func (e and) String() string { return fmt.Sprintf("(%s && %s)", e[0], e[1]) }
+
+type or [2]Expr
+
+func (e or) Match(p *patchset.Patchset, g Graph) bool {
+	return e[0].Match(p, g) || e[1].Match(p, g)
+}
+
+func (e or) String() string { return fmt.Sprintf("(%s || %s)", e[0], e[1]) }
+
+type not struct{ Expr }
+
+func (e not) Match(p *patchset.Patchset, g Graph) bool { return !e.Expr.Match(p, g) }
+
+func (e not) String() string { return "!" + e.Expr.String() }
+
+type all struct{}
+
+func (all) Match(*patchset.Patchset, Graph) bool { return true }
+
+func (all) String() string { return "all" }
+
+type floating struct{}
+
+func (floating) Match(p *patchset.Patchset, _ Graph) bool { return len(p.FloatingPatches()) > 0 }
+
+func (floating) String() string { return "floating" }
+
+type archived struct{}
+
+func (archived) Match(p *patchset.Patchset, _ Graph) bool { return p.Archived() }
+
+func (archived) String() string { return "archived" }
+
+type name string
+
+func (e name) Match(p *patchset.Patchset, _ Graph) bool {
+	return patchset.SameName(string(e), p.Name())
+}
+
+func (e name) String() string { return fmt.Sprintf("name=%q", string(e)) }
+
+type nameRegexp struct{ *regexp.Regexp }
+
+func (e nameRegexp) Match(p *patchset.Patchset, _ Graph) bool { return e.MatchString(p.Name()) }
+
+func (e nameRegexp) String() string { return fmt.Sprintf("name~%q", e.Regexp.String()) }
+
+type tag string
+
+func (e tag) Match(p *patchset.Patchset, _ Graph) bool { return p.HasTag(string(e)) }
+
+func (e tag) String() string { return fmt.Sprintf("tag:%q", string(e)) }
+
+type depends string
+
+func (e depends) Match(p *patchset.Patchset, g Graph) bool {
+	if g == nil {
+		return false
+	}
+	for _, d := range g.TransitiveDependencies(p) {
+		if patchset.SameName(string(e), d.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e depends) String() string { return fmt.Sprintf("depends(%q)", string(e)) }
+
+// token is a lexical token of an expression: an operator, a parenthesis or a
+// word. quoted is set for words with quoted parts, which are never keywords.
+type token struct {
+	text   string
+	word   bool
+	quoted bool
+}
+
+const operatorChars = "()!&|"
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, token{text: s[i : i+2]})
+			i += 2
+		case c == '&' || c == '|':
+			return nil, fmt.Errorf("unexpected %q at offset %d, want %c%c", c, i, c, c)
+		case strings.IndexByte(operatorChars, c) >= 0:
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		default:
+			var b strings.Builder
+			t := token{word: true}
+			for i < len(s) && !unicode.IsSpace(rune(s[i])) && strings.IndexByte(operatorChars, s[i]) < 0 {
+				if q := s[i]; q == '\'' || q == '"' {
+					end := strings.IndexByte(s[i+1:], q)
+					if end < 0 {
+						return nil, fmt.Errorf("unterminated quote at offset %d", i)
+					}
+					b.WriteString(s[i+1 : i+1+end])
+					i += end + 2
+					t.quoted = true
+					continue
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			t.text = b.String()
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+}
+
+func (p *parser) peek() (token, bool) {
+	if len(p.tokens) == 0 {
+		return token{}, false
+	}
+	return p.tokens[0], true
+}
+
+func (p *parser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.tokens = p.tokens[1:]
+	}
+	return t, ok
+}
+
+func (p *parser) operator(op string) bool {
+	if t, ok := p.peek(); ok && !t.word && t.text == op {
+		p.tokens = p.tokens[1:]
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (Expr, error) {
+	e, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.operator("||") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		e = or{e, r}
+	}
+	return e, nil
+}
+
+func (p *parser) and() (Expr, error) {
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.operator("&&") {
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		e = and{e, r}
+	}
+	return e, nil
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.operator("!") {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{e}, nil
+	}
+	if p.operator("(") {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.operator(")") {
+			return nil, errors.New("missing )")
+		}
+		return e, nil
+	}
+	t, ok := p.next()
+	if !ok {
+		return nil, errors.New("unexpected end of expression")
+	}
+	if !t.word {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return p.term(t)
+}
+
+func (p *parser) term(t token) (Expr, error) {
+	switch {
+	case !t.quoted && t.text == "all":
+		return all{}, nil
+	case !t.quoted && t.text == "floating":
+		return floating{}, nil
+	case !t.quoted && t.text == "archived":
+		return archived{}, nil
+	case !t.quoted && t.text == "depends":
+		if !p.operator("(") {
+			return nil, errors.New("missing ( after depends")
+		}
+		arg, ok := p.next()
+		if !ok || !arg.word || arg.text == "" {
+			return nil, errors.New("depends takes a patchset name")
+		}
+		if !p.operator(")") {
+			return nil, errors.New("missing ) after depends argument")
+		}
+		return depends(arg.text), nil
+	case strings.HasPrefix(t.text, "name="):
+		return name(strings.TrimPrefix(t.text, "name=")), nil
+	case strings.HasPrefix(t.text, "name~"):
+		re, err := regexp.Compile(strings.TrimPrefix(t.text, "name~"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid name pattern: %w", err)
+		}
+		return nameRegexp{re}, nil
+	case strings.HasPrefix(t.text, "tag:"):
+		v := strings.TrimPrefix(t.text, "tag:")
+		if v == "" {
+			return nil, errors.New("empty tag")
+		}
+		return tag(v), nil
+	case t.text == "":
+		return nil, errors.New("empty name")
+	}
+	return name(t.text), nil
+}
+
+// Parse parses a selector expression. A plain patchset name is an expression
+// selecting just that patchset.
+func Parse(s string) (Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.or()
+	if err == nil && len(p.tokens) > 0 {
+		err = fmt.Errorf("unexpected %q", p.tokens[0].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+	}
+	return e, nil
+}
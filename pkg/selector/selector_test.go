@@ -0,0 +1,130 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/patchset"
+)
+
+type fakeGraph map[string][]*patchset.Patchset
+
+func (g fakeGraph) TransitiveDependencies(ps *patchset.Patchset) []*patchset.Patchset {
+	return g[ps.Name()]
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		{expr: "foo", want: `name="foo"`},
+		{expr: "all", want: "all"},
+		{expr: "'all'", want: `name="all"`},
+		{expr: "name=floating", want: `name="floating"`},
+		{expr: "archived || floating", want: "(archived || floating)"},
+		{expr: "'my patchset'", want: `name="my patchset"`},
+		{expr: "name~^net-", want: `name~"^net-"`},
+		{expr: "name~'^(net|fs)-'", want: `name~"^(net|fs)-"`},
+		{expr: "depends( foo )", want: `depends("foo")`},
+		{expr: "floating && !tag:frozen", want: `(floating && !tag:"frozen")`},
+		{expr: "a || b && c", want: `(name="a" || (name="b" && name="c"))`},
+		{expr: "(a || b) && c", want: `((name="a" || name="b") && name="c")`},
+		{expr: "", wantErr: true},
+		{expr: "a &&", wantErr: true},
+		{expr: "a & b", wantErr: true},
+		{expr: "(a", wantErr: true},
+		{expr: "a)", wantErr: true},
+		{expr: "a b", wantErr: true},
+		{expr: "depends foo", wantErr: true},
+		{expr: "depends()", wantErr: true},
+		{expr: "name~(", wantErr: true},
+		{expr: "tag:", wantErr: true},
+		{expr: "'foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) returned error %v, want error %t", tt.expr, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := e.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	base := patchset.New("base")
+	netCore := patchset.New("net-core")
+	netCore.AddFloatingPatch("abc")
+	netFrozen := patchset.New("net-frozen")
+	netFrozen.AddFloatingPatch("def")
+	netFrozen.SetField(patchset.TagsField, "frozen")
+	fs := patchset.New("fs")
+	fs.SetArchived(true)
+	patchsets := []*patchset.Patchset{base, netCore, netFrozen, fs}
+	g := fakeGraph{
+		"net-core":   {base},
+		"net-frozen": {netCore, base},
+	}
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{expr: "all", want: []string{"base", "net-core", "net-frozen", "fs"}},
+		{expr: "FS", want: []string{"fs"}},
+		{expr: "name~^net-", want: []string{"net-core", "net-frozen"}},
+		{expr: "floating && !tag:frozen", want: []string{"net-core"}},
+		{expr: "depends(base)", want: []string{"net-core", "net-frozen"}},
+		{expr: "depends(net-core) || fs", want: []string{"net-frozen", "fs"}},
+		{expr: "!(floating || base)", want: []string{"fs"}},
+		{expr: "archived", want: []string{"fs"}},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", tt.expr, err)
+			continue
+		}
+		var got []string
+		for _, p := range patchsets {
+			if e.Match(p, g) {
+				got = append(got, p.Name())
+			}
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("Match(%q) returned diff (-got +want):\n%s", tt.expr, diff)
+		}
+	}
+}
+
+func TestMatchNoGraph(t *testing.T) {
+	e, err := Parse("depends(base)")
+	if err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if e.Match(patchset.New("foo"), nil) {
+		t.Error("Match() = true without a dependency graph")
+	}
+}
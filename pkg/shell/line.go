@@ -0,0 +1,105 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+// lineReader reads command lines. On a terminal, the terminal is switched to
+// non-canonical mode with stty so that tab completion can be offered.
+type lineReader struct {
+	file     *os.File
+	in       *bufio.Reader
+	complete func(line string) (string, []string)
+	restore  string
+}
+
+func newLineReader(in *os.File, complete func(line string) (string, []string)) *lineReader {
+	l := &lineReader{file: in, in: bufio.NewReader(in), complete: complete}
+	if state, err := stty(in, "-g"); err == nil {
+		if _, err := stty(in, "-icanon", "-echo", "min", "1"); err == nil {
+			l.restore = strings.TrimSpace(state)
+		}
+	}
+	return l
+}
+
+func stty(in *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = in
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// Close restores the terminal state.
+func (l *lineReader) Close() {
+	if l.restore != "" {
+		stty(l.file, l.restore)
+	}
+}
+
+// ReadLine prints the prompt and reads a line, returning io.EOF at the end of
+// the input.
+func (l *lineReader) ReadLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if l.restore == "" {
+		line, err := l.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	var line []byte
+	for {
+		b, err := l.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case b == '\r' || b == '\n':
+			fmt.Println()
+			return string(line), nil
+		case b == 4 && len(line) == 0:
+			fmt.Println()
+			return "", io.EOF
+		case b == 127 || b == '\b':
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+				fmt.Print("\b \b")
+			}
+		case b == '\t':
+			completed, candidates := l.complete(string(line))
+			if len(candidates) > 1 {
+				fmt.Printf("\n%s\n%s%s", strings.Join(candidates, "  "), prompt, completed)
+			} else {
+				fmt.Print(completed[len(line):])
+			}
+			line = []byte(completed)
+		case b >= ' ':
+			line = append(line, b)
+			os.Stdout.Write([]byte{b})
+		}
+	}
+}
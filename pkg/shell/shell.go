@@ -0,0 +1,288 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shell implements an interactive kilt session, which keeps the repo
+// and its patchsets loaded across commands.
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/kilt/pkg/queue"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+	"github.com/google/kilt/pkg/show"
+	"github.com/google/kilt/pkg/status"
+)
+
+// errQuit is returned by the quit command to end the session.
+var errQuit = errors.New("quit")
+
+// Shell is an interactive kilt session.
+type Shell struct {
+	repo     *repo.Repo
+	commands map[string]command
+}
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var reworkCommands = []string{"abort", "begin", "continue", "finish", "queue", "skip", "step"}
+
+// New opens the repo and returns a new session.
+func New() (*Shell, error) {
+	s := &Shell{}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	s.commands = map[string]command{
+		"help":   {"help", s.help},
+		"list":   {"list", s.list},
+		"show":   {"show <patchset>...", s.show},
+		"status": {"status", s.status},
+		"rework": {"rework begin [<patchset>...] | step | continue | skip | abort | finish | queue", s.rework},
+		"reload": {"reload", s.reloadCommand},
+		"quit":   {"quit", s.quit},
+	}
+	return s, nil
+}
+
+// Run reads and executes commands from in until it is exhausted or the quit
+// command is given. When in is a terminal, patchset names and commands can be
+// completed with tab.
+func (s *Shell) Run(in *os.File) error {
+	l := newLineReader(in, s.complete)
+	defer l.Close()
+	for {
+		line, err := l.ReadLine(s.prompt())
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := s.Exec(line); err == errQuit {
+			return nil
+		} else if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+}
+
+// Exec executes a single command line.
+func (s *Shell) Exec(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	c, ok := s.commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, try help", args[0])
+	}
+	return c.run(args[1:])
+}
+
+func (s *Shell) prompt() string {
+	if ok, err := s.repo.ReworkInProgress(); err == nil && ok {
+		return fmt.Sprintf("kilt %s (rework)> ", s.repo.KiltBranch())
+	}
+	return fmt.Sprintf("kilt %s> ", s.repo.KiltBranch())
+}
+
+// reload reopens the repo, dropping the cached patchsets.
+func (s *Shell) reload() error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	s.repo = r
+	return nil
+}
+
+func (s *Shell) complete(line string) (string, []string) {
+	var commands []string
+	for name := range s.commands {
+		commands = append(commands, name)
+	}
+	sort.Strings(commands)
+	var names []string
+	if patchsets, err := s.repo.Patchsets(); err == nil {
+		for _, ps := range patchsets {
+			names = append(names, ps.Name())
+		}
+	}
+	return complete(line, commands, names)
+}
+
+func (s *Shell) help(_ []string) error {
+	var names []string
+	for name := range s.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("\t%s\n", s.commands[name].usage)
+	}
+	return nil
+}
+
+func (s *Shell) list(_ []string) error {
+	patchsets, err := s.repo.Patchsets()
+	if err != nil {
+		return err
+	}
+	for _, ps := range patchsets {
+		if ps.MetadataCommit() == "" {
+			fmt.Printf("%s (no metadata, %d floating patches)\n", ps.Name(), len(ps.FloatingPatches()))
+			continue
+		}
+		fmt.Printf("%s v%s, %d patches", ps.Name(), ps.Version(), len(ps.Patches()))
+		if floating := len(ps.FloatingPatches()); floating > 0 {
+			fmt.Printf(", %d floating", floating)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func (s *Shell) show(args []string) error {
+	if len(args) == 0 {
+		return errors.New("patchset name required")
+	}
+	for _, name := range args {
+		if err := show.PatchsetFromRepo(s.repo, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Shell) status(_ []string) error {
+	return status.Print()
+}
+
+func (s *Shell) reloadCommand(_ []string) error {
+	return s.reload()
+}
+
+func (s *Shell) quit(_ []string) error {
+	return errQuit
+}
+
+// rework runs a single step of a rework, or all remaining steps with continue
+// and finish, and reloads the repo afterwards as the branch may have changed.
+func (s *Shell) rework(args []string) error {
+	if len(args) == 0 {
+		return errors.New("rework subcommand required")
+	}
+	if args[0] == "queue" {
+		q, err := rework.RemainingWork(s.repo)
+		if err != nil {
+			return err
+		}
+		for _, item := range q.Items {
+			fmt.Printf("\t%s\n", strings.Join(append([]string{item.Operation}, item.Args...), " "))
+		}
+		return nil
+	}
+	var c *rework.Command
+	var err error
+	all := false
+	switch args[0] {
+	case "begin":
+		targets := []rework.TargetSelector{rework.FloatingTargets{}}
+		for _, p := range args[1:] {
+			targets = append(targets, rework.PatchsetTarget{Name: p})
+		}
+		c, err = rework.NewBeginCommand(targets...)
+	case "step":
+		c, err = rework.NewContinueCommand()
+	case "continue":
+		c, err = rework.NewContinueCommand()
+		all = true
+	case "skip":
+		c, err = rework.NewSkipCommand()
+	case "abort":
+		c, err = rework.NewAbortCommand()
+	case "finish":
+		c, err = rework.NewFinishCommand(false)
+		all = true
+	default:
+		return fmt.Errorf("unknown rework subcommand %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	if all {
+		err = c.ExecuteAll()
+	} else {
+		err = c.Execute()
+	}
+	if saveErr := c.Save(); saveErr != nil {
+		return fmt.Errorf("failed to save rework state: %w", saveErr)
+	}
+	if reloadErr := s.reload(); reloadErr != nil {
+		return reloadErr
+	}
+	if err == queue.ErrEmpty {
+		return nil
+	}
+	return err
+}
+
+// complete completes the last word of line, returning the completed line and
+// the candidates for the word. The first word is completed from commands, the
+// word after rework from its subcommands, and any other word from patchsets.
+func complete(line string, commands, patchsets []string) (string, []string) {
+	fields := strings.Fields(line)
+	word := ""
+	if len(fields) > 0 && !strings.HasSuffix(line, " ") {
+		word = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+	words := patchsets
+	switch {
+	case len(fields) == 0:
+		words = commands
+	case len(fields) == 1 && fields[0] == "rework":
+		words = reworkCommands
+	}
+	var candidates []string
+	for _, w := range words {
+		if strings.HasPrefix(w, word) {
+			candidates = append(candidates, w)
+		}
+	}
+	if len(candidates) == 0 {
+		return line, nil
+	}
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+	return line[:len(line)-len(word)] + prefix, candidates
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestComplete(t *testing.T) {
+	commands := []string{"help", "list", "rework", "reload", "show"}
+	patchsets := []string{"core", "core-tests", "docs"}
+	tests := []struct {
+		line       string
+		want       string
+		candidates []string
+	}{
+		{line: "", want: "", candidates: commands},
+		{line: "sh", want: "show ", candidates: []string{"show"}},
+		{line: "re", want: "re", candidates: []string{"rework", "reload"}},
+		{line: "rework b", want: "rework begin ", candidates: []string{"begin"}},
+		{line: "rework begin c", want: "rework begin core", candidates: []string{"core", "core-tests"}},
+		{line: "show d", want: "show docs ", candidates: []string{"docs"}},
+		{line: "show x", want: "show x"},
+	}
+	for _, tt := range tests {
+		got, candidates := complete(tt.line, commands, patchsets)
+		if got != tt.want {
+			t.Errorf("complete(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+		if diff := cmp.Diff(candidates, tt.candidates); diff != "" {
+			t.Errorf("complete(%q) returned diff (-got +want):\n%s", tt.line, diff)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package show
+
+import (
+	"fmt"
+
+	"github.com/google/kilt/pkg/repo"
+)
+
+const (
+	colorHeader   = "\x1b[1;33m"
+	colorMetadata = "\x1b[36m"
+	colorFloating = "\x1b[31m"
+	colorReset    = "\x1b[0m"
+)
+
+// Log will print the commits of the kilt branch from the base to the head,
+// grouped under a header for each run of commits of the same patchset. If
+// patchset isn't empty, only its commits are printed.
+func Log(patchset string, color bool) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	return LogFromRepo(r, patchset, color)
+}
+
+// LogFromRepo prints the log of the kilt branch of the repo, like Log.
+func LogFromRepo(r *repo.Repo, patchset string, color bool) error {
+	entries, err := r.Log()
+	if err != nil {
+		return err
+	}
+	for _, l := range formatLog(entries, patchset, color) {
+		fmt.Println(l)
+	}
+	return nil
+}
+
+func formatLog(entries []repo.LogEntry, patchset string, color bool) []string {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+	var lines []string
+	current := ""
+	for _, e := range entries {
+		if patchset != "" && e.Patchset != patchset {
+			current = ""
+			continue
+		}
+		if e.Patchset != current {
+			current = e.Patchset
+			lines = append(lines, paint(colorHeader, fmt.Sprintf("Patchset %s", current)))
+		}
+		line := fmt.Sprintf("\t%s %s", e.ShortID, e.Summary)
+		switch {
+		case e.Metadata:
+			line = paint(colorMetadata, line+" (metadata)")
+		case e.Floating:
+			line = paint(colorFloating, line+" (floating)")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package show
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/repo"
+)
+
+func TestFormatLog(t *testing.T) {
+	entries := []repo.LogEntry{
+		{ShortID: "1111111", Summary: "kilt metadata: patchset a", Patchset: "a", Metadata: true},
+		{ShortID: "2222222", Summary: "Add a", Patchset: "a"},
+		{ShortID: "3333333", Summary: "kilt metadata: patchset b", Patchset: "b", Metadata: true},
+		{ShortID: "4444444", Summary: "Fix a", Patchset: "a", Floating: true},
+	}
+	tests := []struct {
+		desc     string
+		patchset string
+		want     []string
+	}{
+		{
+			desc: "All patchsets",
+			want: []string{
+				"Patchset a",
+				"\t1111111 kilt metadata: patchset a (metadata)",
+				"\t2222222 Add a",
+				"Patchset b",
+				"\t3333333 kilt metadata: patchset b (metadata)",
+				"Patchset a",
+				"\t4444444 Fix a (floating)",
+			},
+		},
+		{
+			desc:     "Filtered",
+			patchset: "b",
+			want: []string{
+				"Patchset b",
+				"\t3333333 kilt metadata: patchset b (metadata)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := formatLog(entries, tt.patchset, false)
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("%s: formatLog() returned diff (-got +want):\n%s", tt.desc, diff)
+		}
+	}
+}
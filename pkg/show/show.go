@@ -18,17 +18,83 @@ limitations under the License.
 package show
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/google/kilt/pkg/repo"
 )
 
+// Open opens the repo, at the commit at if it is set, with the patch stack
+// based on base, or on the base found by repo.OpenAt if base is empty. If
+// branchView is set, the kilt branch is opened rather than the head of any
+// rework in progress.
+func Open(at, base string, branchView bool) (*repo.Repo, error) {
+	r, err := repo.Open()
+	if err != nil {
+		return nil, err
+	}
+	if branchView {
+		return r.OpenBranchView()
+	}
+	if at == "" {
+		return r, nil
+	}
+	return r.OpenAt(at, base)
+}
+
 // Patchset will print metadata and list patches for the given patchset.
 func Patchset(name string) error {
 	r, err := repo.Open()
 	if err != nil {
 		return err
 	}
+	return PatchsetFromRepo(r, name)
+}
+
+// List will print the patchsets of the repo in order, with their versions and
+// numbers of patches. Archived patchsets are only printed if archived is set.
+func List(r *repo.Repo, archived bool) error {
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return err
+	}
+	hidden := 0
+	for _, ps := range patchsets {
+		if ps.Archived() && !archived {
+			hidden++
+			continue
+		}
+		line := fmt.Sprintf("%s v%s: %d patches", ps.Name(), ps.Version(), len(ps.Patches()))
+		if floating := len(ps.FloatingPatches()); floating > 0 {
+			line += fmt.Sprintf(", %d floating", floating)
+		}
+		if ps.Archived() {
+			line += ", archived"
+		}
+		fmt.Println(line)
+	}
+	if hidden > 0 {
+		fmt.Printf("%d archived patchsets not shown, use --archived to list them\n", hidden)
+	}
+	return nil
+}
+
+// PatchsetFromRepo will print metadata and list patches for the given patchset
+// of an already opened repo, using its cached patchsets.
+func PatchsetFromRepo(r *repo.Repo, name string) error {
+	return printPatchset(r, name, false)
+}
+
+// PatchsetWithNotesFromRepo is like PatchsetFromRepo, but also prints the kilt
+// review notes attached to each patch.
+func PatchsetWithNotesFromRepo(r *repo.Repo, name string) error {
+	return printPatchset(r, name, true)
+}
+
+func printPatchset(r *repo.Repo, name string, notes bool) error {
 	patchsets, err := r.PatchsetMap()
 	if err != nil {
 		return err
@@ -39,27 +105,209 @@ func Patchset(name string) error {
 	}
 	fmt.Printf("Patchset %s, Version %s, UUID %s\n", patchset.Name(), patchset.Version(), patchset.UUID())
 	fmt.Printf("Metadata commit id %s\n", patchset.MetadataCommit())
+	for _, f := range patchset.Fields() {
+		fmt.Printf("%s: %s\n", f.Key, f.Value)
+	}
+	if d := patchset.Description(); d != "" {
+		fmt.Println()
+		for _, l := range strings.Split(d, "\n") {
+			fmt.Printf("    %s\n", l)
+		}
+		fmt.Println()
+	}
 	patches := patchset.Patches()
 	floating := patchset.FloatingPatches()
 	if len(patches) > 0 {
 		fmt.Println("Patches in patchset:")
-		for _, patch := range patches {
-			desc, err := r.DescribeCommit(patch)
-			if err != nil {
-				return err
-			}
-			fmt.Printf("\t%s\n", desc)
+		if err := printPatches(r, patches, notes); err != nil {
+			return err
 		}
 	}
 	if len(floating) > 0 {
 		fmt.Println("Floating patches:")
-		for _, patch := range floating {
-			desc, err := r.DescribeCommit(patch)
-			if err != nil {
-				return err
-			}
-			fmt.Printf("\t%s\n", desc)
+		if err := printPatches(r, floating, notes); err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+func printPatches(r *repo.Repo, patches []string, notes bool) error {
+	for _, patch := range patches {
+		desc, err := r.DescribeCommit(patch)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\t%s\n", desc)
+		if !notes {
+			continue
+		}
+		note, err := r.PatchNote(patch)
+		if err != nil {
+			return err
+		}
+		if note == "" {
+			continue
+		}
+		fmt.Println("\t    Notes:")
+		for _, l := range strings.Split(strings.TrimRight(note, "\n"), "\n") {
+			fmt.Printf("\t        %s\n", l)
+		}
+	}
+	return nil
+}
+
+// Diff will print the combined changes of the patches in the given patchset,
+// from the tree of its metadata commit to the tree of its last patch. If stat
+// is set, a diffstat is printed first, and if patch is set, the diff is printed.
+func Diff(r *repo.Repo, name string, stat, patch bool) error {
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	ps, ok := patchsets[name]
+	if !ok {
+		return fmt.Errorf("patchset %s not found", name)
+	}
+	if ps.MetadataCommit() == "" {
+		return fmt.Errorf("patchset %s has no metadata commit", name)
+	}
+	patches := ps.Patches()
+	if len(patches) == 0 {
+		return fmt.Errorf("patchset %s has no patches", name)
+	}
+	if floating := ps.FloatingPatches(); len(floating) > 0 {
+		fmt.Fprintf(os.Stderr, "Patchset %s has %d floating patches, which are not included\n", name, len(floating))
+	}
+	from, to := ps.MetadataCommit(), patches[len(patches)-1]
+	if stat {
+		s, err := r.DiffStatBetween(from, to)
+		if err != nil {
+			return err
+		}
+		fmt.Println(s)
+	}
+	if patch {
+		p, err := r.PatchBetween(from, to)
+		if err != nil {
+			return err
+		}
+		fmt.Print(p)
+	}
+	return nil
+}
+
+// PatchsetJSON is the JSON representation of a patchset.
+type PatchsetJSON struct {
+	Name            string        `json:"name"`
+	Version         string        `json:"version"`
+	UUID            string        `json:"uuid"`
+	Description     string        `json:"description"`
+	Fields          []TrailerJSON `json:"fields"`
+	MetadataCommit  string        `json:"metadata_commit"`
+	Patches         []PatchJSON   `json:"patches"`
+	FloatingPatches []PatchJSON   `json:"floating_patches"`
+}
+
+// PatchJSON is the JSON representation of a single patch in a patchset.
+type PatchJSON struct {
+	ID           string        `json:"id"`
+	Subject      string        `json:"subject"`
+	AuthorName   string        `json:"author_name"`
+	AuthorEmail  string        `json:"author_email"`
+	Date         time.Time     `json:"date"`
+	Files        []string      `json:"files"`
+	FilesChanged int           `json:"files_changed"`
+	Insertions   int           `json:"insertions"`
+	Deletions    int           `json:"deletions"`
+	Trailers     []TrailerJSON `json:"trailers"`
+	Note         string        `json:"note,omitempty"`
+}
+
+// TrailerJSON is the JSON representation of a commit message trailer.
+type TrailerJSON struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// PatchsetsJSON will print a JSON array describing the named patchsets and
+// each of their patches.
+func PatchsetsJSON(r *repo.Repo, names []string) error {
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	result := []PatchsetJSON{}
+	for _, name := range names {
+		patchset, ok := patchsets[name]
+		if !ok {
+			return fmt.Errorf("patchset %s not found", name)
+		}
+		ps := PatchsetJSON{
+			Name:           patchset.Name(),
+			Version:        patchset.Version().String(),
+			UUID:           patchset.UUID().String(),
+			Description:    patchset.Description(),
+			Fields:         []TrailerJSON{},
+			MetadataCommit: patchset.MetadataCommit(),
+		}
+		for _, f := range patchset.Fields() {
+			ps.Fields = append(ps.Fields, TrailerJSON{Key: f.Key, Value: f.Value})
+		}
+		if ps.Patches, err = patchesJSON(r, patchset.Patches()); err != nil {
+			return err
+		}
+		if ps.FloatingPatches, err = patchesJSON(r, patchset.FloatingPatches()); err != nil {
+			return err
+		}
+		result = append(result, ps)
+	}
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func patchesJSON(r *repo.Repo, patches []string) ([]PatchJSON, error) {
+	result := []PatchJSON{}
+	for _, patch := range patches {
+		info, err := r.CommitInfo(patch)
+		if err != nil {
+			return nil, err
+		}
+		files, err := r.ChangedFiles(patch)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := r.CommitStat(patch)
+		if err != nil {
+			return nil, err
+		}
+		trailers, err := r.CommitTrailers(patch)
+		if err != nil {
+			return nil, err
+		}
+		p := PatchJSON{
+			ID:           info.ID,
+			Subject:      info.Summary,
+			AuthorName:   info.AuthorName,
+			AuthorEmail:  info.AuthorEmail,
+			Date:         info.AuthorDate,
+			Files:        files,
+			FilesChanged: stat.FilesChanged,
+			Insertions:   stat.Insertions,
+			Deletions:    stat.Deletions,
+			Trailers:     []TrailerJSON{},
+		}
+		for _, t := range trailers {
+			p.Trailers = append(p.Trailers, TrailerJSON{Key: t.Key, Value: t.Value})
+		}
+		if p.Note, err = r.PatchNote(patch); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
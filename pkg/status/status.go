@@ -18,33 +18,56 @@ limitations under the License.
 package status
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/google/kilt/pkg/patchset"
 	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/reporter"
 	"github.com/google/kilt/pkg/rework"
 )
 
+// Open opens the repo. If branchView is set, the kilt branch is opened rather
+// than the head of any rework in progress.
+func Open(branchView bool) (*repo.Repo, error) {
+	r, err := repo.Open()
+	if err != nil || !branchView {
+		return r, err
+	}
+	return r.OpenBranchView()
+}
+
 // Print will print the current kilt branch and rework status.
 func Print() error {
 	r, err := repo.Open()
 	if err != nil {
 		return err
 	}
+	return PrintFromRepo(r)
+}
+
+// PrintFromRepo will print the kilt branch and rework status of the repo. For
+// a branch view, the branch is reported as if no rework were in progress.
+func PrintFromRepo(r *repo.Repo) error {
 	fmt.Printf("On kilt branch %s with base commit %s\n", r.KiltBranch(), r.KiltBase())
 	if ok, err := r.ReworkInProgress(); err != nil {
 		return err
+	} else if ok && r.IsBranchView() {
+		fmt.Println("Rework in progress; showing the branch as it was before the rework.")
 	} else if ok {
 		fmt.Println("Rework in progress.")
-		rework.Status(r)
-		return nil
+		return rework.Status(os.Stdout, r)
 	}
 	patchsets, err := r.Patchsets()
 	if err != nil {
 		return err
 	}
-	found := false
+	found, archived := false, false
 	for _, patchset := range patchsets {
-		if patchset.Name() == "unknown" {
+		if patchset.Name() == "unknown" || patchset.Name() == repo.QuarantinePatchset {
 			continue
 		}
 		if patchset.MetadataCommit() == "" {
@@ -57,7 +80,17 @@ func Print() error {
 				fmt.Printf("First commit: %s\n", desc)
 			}
 		}
-		if floating := patchset.FloatingPatches(); len(floating) > 0 {
+		if floating := patchset.FloatingPatches(); len(floating) > 0 && patchset.Archived() {
+			archived = true
+			fmt.Printf("Patchset %q is archived, but floating patches were found:\n", patchset.Name())
+			for i := range floating {
+				desc, err := r.DescribeCommit(floating[len(floating)-i-1])
+				if err != nil {
+					return err
+				}
+				fmt.Printf("\t%s\n", desc)
+			}
+		} else if len(floating) > 0 {
 			found = true
 			fmt.Printf("Patchset %q needs rework; floating patches found:\n", patchset.Name())
 			for i := range floating {
@@ -72,6 +105,10 @@ func Print() error {
 	if found {
 		fmt.Println(`Rework patchsets individually using kilt rework -p <patchset>, or rework all
 patches using kilt rework`)
+	}
+	if archived {
+		fmt.Println(`Archived patchsets can't receive new patches. Move the patches to another
+patchset using kilt move, or unarchive the patchset using kilt archive --undo.`)
 	}
 	ps, err := r.PatchsetMap()
 	if err != nil {
@@ -87,7 +124,284 @@ patches using kilt rework`)
 			}
 			fmt.Printf("\t%s\n", desc)
 		}
-		fmt.Println(`Please assign these patches to a patchset by adding a "Patchset-Name:" footer.`)
+		fmt.Println(`Please assign these patches to a patchset by adding a "Patchset-Name:" footer,
+or collect them in the quarantine patchset using kilt quarantine.`)
+	}
+	if patchset, ok := ps[repo.QuarantinePatchset]; ok {
+		if err := printQuarantine(r, patchset); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// printQuarantine prints the patches in the quarantine patchset with how long
+// they have been quarantined, flagging those beyond the maximum age.
+func printQuarantine(r *repo.Repo, ps *patchset.Patchset) error {
+	patches := append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...)
+	if len(patches) == 0 {
+		return nil
+	}
+	since, err := r.QuarantinedSince(patches)
+	if err != nil {
+		return err
+	}
+	maxAge, err := r.QuarantineMaxAge()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Quarantined patches:")
+	expired := false
+	for _, patch := range patches {
+		desc, err := r.DescribeCommit(patch)
+		if err != nil {
+			return err
+		}
+		t, ok := since[patch]
+		if !ok {
+			fmt.Printf("\t%s (untracked)\n", desc)
+			continue
+		}
+		age := time.Since(t)
+		days := int(age / (24 * time.Hour))
+		if maxAge > 0 && age > maxAge {
+			expired = true
+			fmt.Printf("\t%s (%d days, overdue)\n", desc, days)
+		} else {
+			fmt.Printf("\t%s (%d days)\n", desc, days)
+		}
+	}
+	if expired {
+		fmt.Println("Assign overdue patches to a patchset using kilt move <commit>... --to <patchset>")
+	}
+	return nil
+}
+
+// CheckBase will print a report of how far the kilt base has drifted from the
+// given upstream ref, and which patchsets touch files that changed upstream.
+func CheckBase(upstream string, fetch bool) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	if fetch {
+		fmt.Printf("Fetching %s\n", upstream)
+		if err := r.FetchUpstream(upstream); err != nil {
+			return err
+		}
+	}
+	_, behind, err := r.AheadBehind(r.KiltBase(), upstream)
+	if err != nil {
+		return err
+	}
+	if behind == 0 {
+		fmt.Printf("Base commit %s is up to date with %s\n", r.KiltBase(), upstream)
+		return nil
+	}
+	fmt.Printf("Base commit %s is %d commits behind %s\n", r.KiltBase(), behind, upstream)
+	mergeBase, err := r.MergeBase(r.KiltBase(), upstream)
+	if err != nil {
+		return err
+	}
+	files, err := r.ChangedFilesBetween(mergeBase, upstream)
+	if err != nil {
+		return err
+	}
+	changed := map[string]bool{}
+	for _, f := range files {
+		changed[f] = true
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, patchset := range patchsets {
+		var touched []string
+		seen := map[string]bool{}
+		patches := append(append([]string{}, patchset.Patches()...), patchset.FloatingPatches()...)
+		for _, patch := range patches {
+			files, err := r.ChangedFiles(patch)
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if changed[f] && !seen[f] {
+					seen[f] = true
+					touched = append(touched, f)
+				}
+			}
+		}
+		if len(touched) == 0 {
+			continue
+		}
+		found = true
+		fmt.Printf("Patchset %q touches files changed upstream:\n", patchset.Name())
+		for _, f := range touched {
+			fmt.Printf("\t%s\n", f)
+		}
+	}
+	if !found {
+		fmt.Println("No patchsets touch files changed upstream.")
+	}
+	return nil
+}
+
+// State is the machine-readable status of the kilt branch.
+type State struct {
+	Branch           string             `json:"branch"`
+	Base             string             `json:"base"`
+	ReworkInProgress bool               `json:"rework_in_progress"`
+	ReworkName       string             `json:"rework_name,omitempty"`
+	Progress         string             `json:"progress,omitempty"`
+	Operations       *reporter.Progress `json:"operations,omitempty"`
+	Queue            []string           `json:"queue"`
+	Skipped          []string           `json:"skipped"`
+	Patchsets        []PatchsetState    `json:"patchsets"`
+}
+
+// PatchsetState is the machine-readable status of a patchset.
+type PatchsetState struct {
+	Name            string   `json:"name"`
+	Version         string   `json:"version"`
+	UUID            string   `json:"uuid"`
+	MetadataCommit  string   `json:"metadata_commit"`
+	Archived        bool     `json:"archived,omitempty"`
+	FloatingPatches []string `json:"floating_patches"`
+}
+
+// LoadState reads the status of the kilt branch.
+func LoadState() (*State, error) {
+	r, err := repo.Open()
+	if err != nil {
+		return nil, err
+	}
+	return LoadStateFromRepo(r)
+}
+
+// LoadStateFromRepo reads the status of the kilt branch of the repo. For a
+// branch view, the patchsets are those of the branch rather than of the rework
+// head, while the rework queue is still reported.
+func LoadStateFromRepo(r *repo.Repo) (*State, error) {
+	var err error
+	s := &State{
+		Branch:    r.KiltBranch(),
+		Base:      r.KiltBase(),
+		Queue:     []string{},
+		Skipped:   []string{},
+		Patchsets: []PatchsetState{},
+	}
+	if s.ReworkInProgress, err = r.ReworkInProgress(); err != nil {
+		return nil, err
+	}
+	if s.ReworkInProgress {
+		if s.ReworkName, err = rework.SessionName(r); err != nil {
+			return nil, err
+		}
+		q, err := rework.RemainingWork(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range q.Items {
+			s.Queue = append(s.Queue, strings.Join(append([]string{item.Operation}, item.Args...), " "))
+		}
+		progress, err := rework.NestedProgress(r)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			s.Progress = progress.String()
+		}
+		if s.Operations, err = rework.OperationProgress(r); err != nil {
+			return nil, err
+		}
+		skipped, err := rework.SkippedWork(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range skipped.Items {
+			s.Skipped = append(s.Skipped, strings.Join(append([]string{item.Operation}, item.Args...), " "))
+		}
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range patchsets {
+		ps := PatchsetState{
+			Name:            p.Name(),
+			MetadataCommit:  p.MetadataCommit(),
+			Archived:        p.Archived(),
+			FloatingPatches: append([]string{}, p.FloatingPatches()...),
+		}
+		if p.MetadataCommit() != "" {
+			ps.Version = p.Version().String()
+			ps.UUID = p.UUID().String()
+		}
+		s.Patchsets = append(s.Patchsets, ps)
+	}
+	return s, nil
+}
+
+// PrintJSON will print the status of the kilt branch of the repo as JSON.
+func PrintJSON(r *repo.Repo) error {
+	s, err := LoadStateFromRepo(r)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// PrintPorcelain will print the status of the kilt branch of the repo in a
+// stable, line oriented format. Each line starts with a keyword followed by its
+// fields:
+//
+//	branch <name>
+//	base <commit>
+//	rework <true|false>
+//	progress <description of the patchset queue in progress>
+//	operations <complete> <total>
+//	queue <operation> [args...]
+//	skipped <operation> [args...]
+//	patchset <name> <version|-> <uuid|-> <metadata commit|->
+//	floating <patchset> <commit>
+func PrintPorcelain(r *repo.Repo) error {
+	s, err := LoadStateFromRepo(r)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("branch %s\n", s.Branch)
+	fmt.Printf("base %s\n", s.Base)
+	fmt.Printf("rework %t\n", s.ReworkInProgress)
+	if s.Progress != "" {
+		fmt.Printf("progress %s\n", s.Progress)
+	}
+	if s.Operations != nil {
+		fmt.Printf("operations %d %d\n", s.Operations.Done, s.Operations.Total)
+	}
+	for _, item := range s.Queue {
+		fmt.Printf("queue %s\n", item)
+	}
+	for _, item := range s.Skipped {
+		fmt.Printf("skipped %s\n", item)
+	}
+	for _, ps := range s.Patchsets {
+		fmt.Printf("patchset %s %s %s %s\n", ps.Name, orDash(ps.Version), orDash(ps.UUID), orDash(ps.MetadataCommit))
+		for _, patch := range ps.FloatingPatches {
+			fmt.Printf("floating %s %s\n", ps.Name, patch)
+		}
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
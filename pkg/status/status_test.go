@@ -0,0 +1,151 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/internal/testfiles"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// setupRepo creates a kilt branch with a patch for each of the patchsets.
+func setupRepo(t *testing.T, name string, patchsets ...string) *git.Repository {
+	path, err := testfiles.TempDir(name)
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	os.Chdir(path)
+	g, err := git.InitRepository(path, false)
+	if err != nil {
+		t.Fatalf("InitRepository(): %v", err)
+	}
+	config, err := g.Config()
+	if err != nil {
+		t.Fatalf("Config(): %v", err)
+	}
+	config.SetString("user.name", "Test Data")
+	config.SetString("user.email", "nobody@google.com")
+	commitFile(t, g, "base", "")
+	r, err := repo.Init("HEAD", false)
+	if err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	for _, ps := range patchsets {
+		if err = r.AddPatchset(patchset.New(ps)); err != nil {
+			t.Fatalf("AddPatchset(%q): %v", ps, err)
+		}
+		commitFile(t, g, ps, ps)
+	}
+	return g
+}
+
+// commitFile commits a new file, as a patch of the patchset if set.
+func commitFile(t *testing.T, g *git.Repository, file, patchset string) {
+	if err := ioutil.WriteFile(filepath.Join(g.Workdir(), file), []byte(file+"\n"), 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	index, err := g.Index()
+	if err != nil {
+		t.Fatalf("Index(): %v", err)
+	}
+	if err = index.AddByPath(file); err != nil {
+		t.Fatalf("AddByPath(): %v", err)
+	}
+	if err = index.Write(); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	oid, err := index.WriteTree()
+	if err != nil {
+		t.Fatalf("WriteTree(): %v", err)
+	}
+	tree, err := g.LookupTree(oid)
+	if err != nil {
+		t.Fatalf("LookupTree(): %v", err)
+	}
+	sig, err := g.DefaultSignature()
+	if err != nil {
+		t.Fatalf("DefaultSignature(): %v", err)
+	}
+	var parents []*git.Commit
+	if head, err := g.Head(); err == nil {
+		parent, err := g.LookupCommit(head.Target())
+		if err != nil {
+			t.Fatalf("LookupCommit(): %v", err)
+		}
+		parents = append(parents, parent)
+	}
+	message := fmt.Sprintf("Add %s\n", file)
+	if patchset != "" {
+		message += fmt.Sprintf("\nPatchset-Name: %s\n", patchset)
+	}
+	if _, err = g.CreateCommit("HEAD", sig, sig, message, tree, parents...); err != nil {
+		t.Fatalf("CreateCommit(): %v", err)
+	}
+}
+
+func TestSkipped(t *testing.T) {
+	g := setupRepo(t, "Skipped", "a")
+	defer os.RemoveAll(g.Workdir())
+	c, err := rework.NewBeginCommand(rework.AllTargets{})
+	if err != nil {
+		t.Fatalf("NewBeginCommand(): %v", err)
+	}
+	// Begin the rework, leaving the rest of it queued.
+	if err = c.Execute(); err != nil {
+		t.Fatalf("Execute(): %v", err)
+	}
+	if err = c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	before, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState(): %v", err)
+	}
+	if len(before.Queue) == 0 {
+		t.Fatal("LoadState(): empty queue, want the rest of the rework")
+	}
+	if len(before.Skipped) > 0 {
+		t.Errorf("LoadState(): skipped %v before skipping", before.Skipped)
+	}
+	c, err = rework.NewSkipCommand()
+	if err != nil {
+		t.Fatalf("NewSkipCommand(): %v", err)
+	}
+	if err = c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	after, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState(): %v", err)
+	}
+	if diff := cmp.Diff(after.Skipped, before.Queue[:1]); diff != "" {
+		t.Errorf("skipped returned diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(after.Queue, before.Queue[1:]); diff != "" {
+		t.Errorf("queue after skip returned diff (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+)
+
+// SuggestionKind is the kind of fix a suggestion makes.
+type SuggestionKind string
+
+// Kinds of suggestions.
+const (
+	SuggestContinue SuggestionKind = "continue"
+	SuggestFinish   SuggestionKind = "finish"
+	SuggestRework   SuggestionKind = "rework"
+	SuggestAssign   SuggestionKind = "assign"
+	SuggestPrune    SuggestionKind = "prune-dependencies"
+)
+
+// Suggestion is a command fixing a problem found with the kilt branch.
+type Suggestion struct {
+	Kind SuggestionKind
+	// Problem describes the problem the command fixes.
+	Problem string
+	// Command is the kilt command fixing the problem. Placeholders in angle
+	// brackets must be filled in before running it.
+	Command string
+	// Safe is set if the command only reworks the branch as it is, so it can
+	// be applied without asking.
+	Safe bool
+	// Patchsets are the patchsets the command applies to.
+	Patchsets []string
+}
+
+// Suggestions returns the commands fixing the problems found with the kilt
+// branch of the repo, or carrying on with the rework in progress.
+func Suggestions(r *repo.Repo) ([]Suggestion, error) {
+	if ok, err := r.ReworkInProgress(); err != nil {
+		return nil, err
+	} else if ok {
+		q, err := rework.RemainingWork(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(q.Items) > 0 {
+			return []Suggestion{{
+				Kind:    SuggestContinue,
+				Problem: fmt.Sprintf("Rework in progress with %d remaining operations", len(q.Items)),
+				Command: "kilt rework --continue",
+			}}, nil
+		}
+		return []Suggestion{{
+			Kind:    SuggestFinish,
+			Problem: "Rework in progress with all work complete",
+			Command: "kilt rework --finish",
+		}}, nil
+	}
+	var suggestions []Suggestion
+	dangling, err := dependency.Dangling(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(dangling) > 0 {
+		suggestions = append(suggestions, Suggestion{
+			Kind:    SuggestPrune,
+			Problem: fmt.Sprintf("%d dependency entries name patchsets missing from the branch", len(dangling)),
+			Command: "kilt deps --prune",
+			Safe:    true,
+		})
+	}
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, ps := range patchsets {
+		if ps.Name() == "unknown" || ps.Name() == repo.QuarantinePatchset {
+			continue
+		}
+		if len(ps.FloatingPatches()) > 0 || ps.MetadataCommit() == "" {
+			stale = append(stale, ps.Name())
+		}
+	}
+	if len(stale) > 0 {
+		args := make([]string, len(stale))
+		for i, name := range stale {
+			args[i] = "-p " + name
+		}
+		suggestions = append(suggestions, Suggestion{
+			Kind:      SuggestRework,
+			Problem:   fmt.Sprintf("Patchsets %s have floating patches or lack metadata", strings.Join(stale, ", ")),
+			Command:   "kilt rework " + strings.Join(args, " "),
+			Safe:      true,
+			Patchsets: stale,
+		})
+	}
+	for _, ps := range patchsets {
+		switch ps.Name() {
+		case "unknown":
+			if patches := ps.FloatingPatches(); len(patches) > 0 {
+				suggestions = append(suggestions, assignSuggestion(
+					fmt.Sprintf("%d patches belong to no patchset; or collect them with kilt quarantine", len(patches)), patches))
+			}
+		case repo.QuarantinePatchset:
+			overdue, err := overduePatches(r, append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...))
+			if err != nil {
+				return nil, err
+			}
+			if len(overdue) > 0 {
+				suggestions = append(suggestions, assignSuggestion(
+					fmt.Sprintf("%d quarantined patches are overdue", len(overdue)), overdue))
+			}
+		}
+	}
+	return suggestions, nil
+}
+
+// assignSuggestion returns the suggestion to move the patches to a patchset.
+func assignSuggestion(problem string, patches []string) Suggestion {
+	ids := make([]string, len(patches))
+	for i, patch := range patches {
+		ids[i] = fmt.Sprintf("%.12s", patch)
+	}
+	return Suggestion{
+		Kind:    SuggestAssign,
+		Problem: problem,
+		Command: fmt.Sprintf("kilt move %s --to <patchset>", strings.Join(ids, " ")),
+	}
+}
+
+// overduePatches returns the quarantined patches beyond the maximum age.
+func overduePatches(r *repo.Repo, patches []string) ([]string, error) {
+	if len(patches) == 0 {
+		return nil, nil
+	}
+	maxAge, err := r.QuarantineMaxAge()
+	if err != nil || maxAge <= 0 {
+		return nil, err
+	}
+	since, err := r.QuarantinedSince(patches)
+	if err != nil {
+		return nil, err
+	}
+	var overdue []string
+	for _, patch := range patches {
+		if t, ok := since[patch]; ok && time.Since(t) > maxAge {
+			overdue = append(overdue, patch)
+		}
+	}
+	return overdue, nil
+}
+
+// WriteSuggestions writes the suggested commands to w as a block of shell
+// commands, each preceded by a comment describing the problem it fixes.
+func WriteSuggestions(w io.Writer, suggestions []Suggestion) {
+	if len(suggestions) == 0 {
+		fmt.Fprintln(w, "No problems found.")
+		return
+	}
+	fmt.Fprintln(w, "Suggested commands:")
+	fmt.Fprintln(w)
+	for _, s := range suggestions {
+		fmt.Fprintf(w, "# %s\n%s\n", s.Problem, s.Command)
+	}
+}
+
+// ApplySuggestions applies the safe suggestions: dependencies naming missing
+// patchsets are pruned, then a rework of the stale patchsets is begun and run
+// until it completes or stops. It returns the rework command, if one was
+// begun, so that its state can be saved, along with the error it stopped with.
+func ApplySuggestions(r *repo.Repo, suggestions []Suggestion) (*rework.Command, error) {
+	var targets []rework.TargetSelector
+	for _, s := range suggestions {
+		if !s.Safe {
+			continue
+		}
+		switch s.Kind {
+		case SuggestPrune:
+			pruned, err := dependency.Prune(r)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf("Pruned %d dependency entries.\n", pruned)
+		case SuggestRework:
+			for _, name := range s.Patchsets {
+				targets = append(targets, rework.PatchsetTarget{Name: name})
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	c, err := rework.NewBeginCommand(append([]rework.TargetSelector{rework.FloatingTargets{}}, targets...)...)
+	if err != nil {
+		return nil, err
+	}
+	return c, c.ExecuteAll()
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+
+	"github.com/libgit2/git2go/v30"
+)
+
+// setupStaleRepo creates a kilt branch with patchsets a and b, a floating
+// patch of a, and a dependency file naming a missing patchset.
+func setupStaleRepo(t *testing.T, name string) *git.Repository {
+	g := setupRepo(t, name, "a", "b")
+	commitFile(t, g, "a2", "a")
+	deps := []byte(`{"a": [], "b": ["a", "gone"]}` + "\n")
+	if err := ioutil.WriteFile(filepath.Join(g.Workdir(), dependency.File), deps, 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	return g
+}
+
+func TestSuggestions(t *testing.T) {
+	g := setupStaleRepo(t, "Suggestions")
+	defer os.RemoveAll(g.Workdir())
+	commitFile(t, g, "loose", "")
+	head, err := g.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	r, err := repo.Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	got, err := Suggestions(r)
+	if err != nil {
+		t.Fatalf("Suggestions(): %v", err)
+	}
+	want := []Suggestion{
+		{
+			Kind:    SuggestPrune,
+			Problem: "1 dependency entries name patchsets missing from the branch",
+			Command: "kilt deps --prune",
+			Safe:    true,
+		},
+		{
+			Kind:      SuggestRework,
+			Problem:   "Patchsets a have floating patches or lack metadata",
+			Command:   "kilt rework -p a",
+			Safe:      true,
+			Patchsets: []string{"a"},
+		},
+		{
+			Kind:    SuggestAssign,
+			Problem: "1 patches belong to no patchset; or collect them with kilt quarantine",
+			Command: fmt.Sprintf("kilt move %.12s --to <patchset>", head.Target().String()),
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Suggestions() returned diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSuggestionsRework(t *testing.T) {
+	g := setupRepo(t, "SuggestionsRework", "a")
+	defer os.RemoveAll(g.Workdir())
+	c, err := rework.NewBeginCommand(rework.AllTargets{})
+	if err != nil {
+		t.Fatalf("NewBeginCommand(): %v", err)
+	}
+	if err = c.Execute(); err != nil {
+		t.Fatalf("Execute(): %v", err)
+	}
+	if err = c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	r, err := repo.Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	got, err := Suggestions(r)
+	if err != nil {
+		t.Fatalf("Suggestions(): %v", err)
+	}
+	if len(got) != 1 || got[0].Kind != SuggestContinue {
+		t.Errorf("Suggestions() = %+v, want a single %s suggestion", got, SuggestContinue)
+	}
+}
+
+func TestApplySuggestions(t *testing.T) {
+	g := setupStaleRepo(t, "ApplySuggestions")
+	defer os.RemoveAll(g.Workdir())
+	r, err := repo.Open()
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	suggestions, err := Suggestions(r)
+	if err != nil {
+		t.Fatalf("Suggestions(): %v", err)
+	}
+	c, err := ApplySuggestions(r, suggestions)
+	if err != nil {
+		t.Fatalf("ApplySuggestions(): %v", err)
+	}
+	if c == nil {
+		t.Fatal("ApplySuggestions() begun no rework, want rework of a")
+	}
+	if err = c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	if r, err = repo.Open(); err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	if dangling, err := dependency.Dangling(r); err != nil || len(dangling) > 0 {
+		t.Errorf("Dangling() after ApplySuggestions() = %v, %v, want none", dangling, err)
+	}
+	if got, err := Suggestions(r); err != nil || len(got) != 1 || got[0].Kind != SuggestFinish {
+		t.Errorf("Suggestions() after ApplySuggestions() = %+v, %v, want a single %s suggestion", got, err, SuggestFinish)
+	}
+	c, err = rework.NewFinishCommand(false)
+	if err != nil {
+		t.Fatalf("NewFinishCommand(): %v", err)
+	}
+	if err = c.ExecuteAll(); err != nil {
+		t.Fatalf("ExecuteAll(): %v", err)
+	}
+	if err = c.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	if r, err = repo.Open(); err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	if got, err := Suggestions(r); err != nil || len(got) > 0 {
+		t.Errorf("Suggestions() after finishing = %+v, %v, want none", got, err)
+	}
+}
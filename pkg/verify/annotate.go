@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// AnnotateBuild reconstructs the patchsets and versions contained in branch, a
+// branch produced by kilt build, from the patchset metadata carried in it, and
+// returns a description of how a rebuild from the current kilt branch would
+// change each of them, along with the number of patchsets that would change.
+// If base is empty, the merge base of branch and the kilt base is used.
+func AnnotateBuild(branch, base string) ([]string, int, error) {
+	r, err := repo.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	if base == "" {
+		if base, err = r.MergeBase(branch, r.KiltBase()); err != nil {
+			return nil, 0, err
+		}
+	}
+	b, err := r.OpenRevision(branch, base)
+	if err != nil {
+		return nil, 0, err
+	}
+	built, builtNames, err := loadContent(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(builtNames) == 0 {
+		return nil, 0, fmt.Errorf("no kilt patchsets found on %s", branch)
+	}
+	current, _, err := loadContent(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	deps, err := dependency.Load(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	requires := map[string][]string{}
+	for _, c := range current {
+		for _, dep := range deps.TransitiveDependencies(c.patchset) {
+			requires[c.patchset.Name()] = append(requires[c.patchset.Name()], dep.Name())
+		}
+	}
+	lines, changes := annotate(built, builtNames, current, requires, r.KiltBranch())
+	return lines, changes, nil
+}
+
+// annotate describes how rebuilding the built patchsets from the current
+// patchsets would change each of them, returning the descriptions and the
+// number of patchsets that would change. Built patchsets are matched to the
+// current ones by UUID, falling back to the name. requires maps the names of
+// current patchsets to the names of their transitive dependencies, which a
+// rebuild would add.
+func annotate(built map[string]*patchsetContent, builtNames []string, current map[string]*patchsetContent, requires map[string][]string, branch string) ([]string, int) {
+	byUUID := map[string]*patchsetContent{}
+	for _, c := range current {
+		byUUID[c.patchset.UUID().String()] = c
+	}
+	var lines []string
+	changes := 0
+	included := map[string]bool{}
+	var matched []string
+	for _, name := range builtNames {
+		c := built[name]
+		desc := fmt.Sprintf("Patchset %q v%s", name, c.patchset.Version())
+		t, ok := byUUID[c.patchset.UUID().String()]
+		if !ok {
+			t, ok = current[name]
+		}
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%s: no longer on %s, a rebuild would drop it", desc, branch))
+			changes++
+			continue
+		}
+		included[t.patchset.Name()] = true
+		matched = append(matched, t.patchset.Name())
+		var parts []string
+		if t.patchset.Name() != name {
+			parts = append(parts, fmt.Sprintf("renamed to %q", t.patchset.Name()))
+		}
+		if t.patchset.Version().Cmp(c.patchset.Version()) != 0 {
+			parts = append(parts, fmt.Sprintf("updated to v%s", t.patchset.Version()))
+		} else if !equal(t.patchIDs, c.patchIDs) {
+			parts = append(parts, "content changed without a version update")
+		}
+		if len(parts) == 0 {
+			lines = append(lines, desc+": up to date")
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", desc, strings.Join(parts, ", ")))
+		changes++
+	}
+	for _, name := range matched {
+		for _, dep := range requires[name] {
+			if included[dep] {
+				continue
+			}
+			included[dep] = true
+			lines = append(lines, fmt.Sprintf("Patchset %q: not built, a rebuild would add it as a dependency of %q", dep, name))
+			changes++
+		}
+	}
+	return lines, changes
+}
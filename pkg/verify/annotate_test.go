@@ -0,0 +1,71 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pborman/uuid"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+func TestAnnotate(t *testing.T) {
+	uuids := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		uuids[name] = uuid.New()
+	}
+	content := func(name, uuidName string, version int, ids ...string) *patchsetContent {
+		v, err := patchset.ParseVersion(strconv.Itoa(version))
+		if err != nil {
+			t.Fatalf("ParseVersion(): %v", err)
+		}
+		return &patchsetContent{
+			patchset: patchset.Load(name, uuids[uuidName], v),
+			patchIDs: ids,
+		}
+	}
+	built := map[string]*patchsetContent{
+		"a": content("a", "a", 1, "1"),
+		"b": content("b", "b", 1, "2"),
+		"c": content("c", "c", 2, "3"),
+		"d": content("d", "d", 1, "4"),
+	}
+	current := map[string]*patchsetContent{
+		"a": content("a", "a", 1, "1"),
+		"b": content("b", "b", 2, "2", "5"),
+		"e": content("e", "c", 2, "3"),
+		"f": content("f", "e", 1, "6"),
+	}
+	requires := map[string][]string{"b": {"a", "f"}}
+	lines, changes := annotate(built, []string{"a", "b", "c", "d"}, current, requires, "main")
+	want := []string{
+		`Patchset "a" v1: up to date`,
+		`Patchset "b" v1: updated to v2`,
+		`Patchset "c" v2: renamed to "e"`,
+		`Patchset "d" v1: no longer on main, a rebuild would drop it`,
+		`Patchset "f": not built, a rebuild would add it as a dependency of "b"`,
+	}
+	if diff := cmp.Diff(lines, want); diff != "" {
+		t.Errorf("annotate() returned diff (-got +want)\n%s", diff)
+	}
+	if changes != 4 {
+		t.Errorf("annotate() = %d changes, want 4", changes)
+	}
+}
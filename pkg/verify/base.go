@@ -0,0 +1,112 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/repo"
+)
+
+// ErrBadBase indicates that the kilt base of the branch looks misconfigured.
+var ErrBadBase = errors.New("kilt base looks misconfigured")
+
+// baseFacts are the facts about the kilt base that Base checks.
+type baseFacts struct {
+	// Ancestor is set if the base is an ancestor of the branch, and otherwise
+	// ForkPoint is the merge base of the two.
+	Ancestor  bool
+	ForkPoint string
+	// Distance is the number of commits between the base and the branch.
+	Distance, MaxCommits int
+	// First is the first commit after the base, and FirstMetadata is set if
+	// it is a metadata commit.
+	First         string
+	FirstMetadata bool
+	// BasePatchset is the Patchset-Name of the base commit itself, if any.
+	BasePatchset string
+}
+
+// Base sanity-checks the kilt base of the current branch: that it is an
+// ancestor of the branch, that the branch isn't implausibly far from it, and
+// that the patch stack starts right after it. A summary and any problems are
+// printed, and ErrBadBase is returned if there are problems.
+func Base() error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	base, branch := r.KiltBase(), r.KiltBranch()
+	fmt.Printf("Kilt branch %s with base %s\n", branch, base)
+	var f baseFacts
+	if f.MaxCommits, err = r.MaxCommits(); err != nil {
+		return err
+	}
+	if f.Ancestor, err = r.IsAncestor(base, branch); err != nil {
+		return err
+	}
+	if !f.Ancestor {
+		if f.ForkPoint, err = r.MergeBase(base, branch); err != nil {
+			return err
+		}
+	} else {
+		if f.Distance, _, err = r.AheadBehind(branch, base); err != nil {
+			return err
+		}
+		fmt.Printf("%d commits between the base and the branch\n", f.Distance)
+		if f.Distance > 0 && (f.MaxCommits <= 0 || f.Distance <= f.MaxCommits) {
+			entries, err := r.StackEntries()
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				f.First, f.FirstMetadata = entries[0].ID, entries[0].Metadata
+			}
+		}
+	}
+	if f.BasePatchset, err = r.CommitPatchset(base); err != nil {
+		return err
+	}
+	problems := baseProblems(f)
+	for _, p := range problems {
+		fmt.Printf("Problem: %s\n", p)
+	}
+	if len(problems) > 0 {
+		return ErrBadBase
+	}
+	fmt.Println("The base looks correct")
+	return nil
+}
+
+// baseProblems returns descriptions of the problems shown by the facts.
+func baseProblems(f baseFacts) []string {
+	var problems []string
+	if !f.Ancestor {
+		problems = append(problems, fmt.Sprintf("the base is not an ancestor of the branch, which forked from it at %s; move the base with kilt rebase", f.ForkPoint))
+	}
+	if f.MaxCommits > 0 && f.Distance > f.MaxCommits {
+		problems = append(problems, fmt.Sprintf("%d commits between the base and the branch is more than kilt.maxCommits (%d); the base is probably too old", f.Distance, f.MaxCommits))
+	}
+	if f.First != "" && !f.FirstMetadata {
+		problems = append(problems, fmt.Sprintf("the first commit after the base, %s, is not a metadata commit; the base may be too old", f.First))
+	}
+	if f.BasePatchset != "" {
+		problems = append(problems, fmt.Sprintf("the base is itself a commit of patchset %q; the base may be too recent", f.BasePatchset))
+	}
+	return problems
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"testing"
+)
+
+func TestBaseProblems(t *testing.T) {
+	tests := []struct {
+		desc  string
+		facts baseFacts
+		want  int
+	}{
+		{"Correct", baseFacts{Ancestor: true, Distance: 12, MaxCommits: 100, First: "a", FirstMetadata: true}, 0},
+		{"Empty", baseFacts{Ancestor: true, MaxCommits: 100}, 0},
+		{"Unlimited", baseFacts{Ancestor: true, Distance: 1000, First: "a", FirstMetadata: true}, 0},
+		{"NotAncestor", baseFacts{ForkPoint: "f"}, 1},
+		{"TooFar", baseFacts{Ancestor: true, Distance: 101, MaxCommits: 100}, 1},
+		{"TooOld", baseFacts{Ancestor: true, Distance: 12, MaxCommits: 100, First: "a"}, 1},
+		{"TooRecent", baseFacts{Ancestor: true, Distance: 10, MaxCommits: 100, First: "a", BasePatchset: "p"}, 2},
+	}
+	for _, tt := range tests {
+		if got := baseProblems(tt.facts); len(got) != tt.want {
+			t.Errorf("%s: baseProblems(%+v) = %q, want %d problems", tt.desc, tt.facts, got, tt.want)
+		}
+	}
+}
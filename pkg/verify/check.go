@@ -0,0 +1,190 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/dependency"
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// ErrInconsistent indicates that the consistency check found problems.
+var ErrInconsistent = errors.New("kilt branch has consistency problems")
+
+// Kinds of problems found by the consistency check.
+const (
+	DuplicateMetadata = "duplicate-metadata"
+	UnknownPatchset   = "unknown-patchset"
+	VersionRegression = "version-regression"
+	MissingDependency = "missing-dependency"
+	OrphanedRef       = "orphaned-ref"
+	MalformedMetadata = "malformed-metadata"
+)
+
+// Problem is a structural problem of a kilt branch.
+type Problem struct {
+	Kind string `json:"kind"`
+	// Object is the commit, patchset or ref the problem was found in.
+	Object  string `json:"object"`
+	Message string `json:"message"`
+}
+
+// Report is the result of a consistency check.
+type Report struct {
+	Branch   string    `json:"branch"`
+	Problems []Problem `json:"problems"`
+}
+
+// Check scans the current kilt branch for structural problems, and prints a
+// report of them, as JSON if asJSON is set. ErrInconsistent is returned if any
+// were found.
+func Check(asJSON bool) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	report := Report{Branch: r.KiltBranch(), Problems: []Problem{}}
+	entries, err := r.StackEntries()
+	if err != nil {
+		return err
+	}
+	recorded, err := r.RecordedVersions()
+	if err != nil {
+		return err
+	}
+	report.Problems = append(report.Problems, stackProblems(entries, recorded)...)
+	malformed, err := r.MalformedMetadata()
+	if err != nil {
+		return err
+	}
+	report.Problems = append(report.Problems, malformedProblems(malformed, report.Problems)...)
+	dangling, err := dependency.Dangling(r)
+	if err != nil {
+		return err
+	}
+	for _, d := range dangling {
+		p := Problem{Kind: MissingDependency, Object: d.Patchset}
+		if d.Dependency == "" {
+			p.Message = fmt.Sprintf("dependencies are recorded for patchset %q, which is not on the branch", d.Patchset)
+		} else {
+			p.Message = fmt.Sprintf("patchset %q depends on %q, which is not on the branch", d.Patchset, d.Dependency)
+		}
+		report.Problems = append(report.Problems, p)
+	}
+	orphans, err := r.OrphanedRefs()
+	if err != nil {
+		return err
+	}
+	for _, o := range orphans {
+		report.Problems = append(report.Problems, Problem{Kind: OrphanedRef, Object: o.Ref, Message: o.Reason})
+	}
+	if asJSON {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else if len(report.Problems) == 0 {
+		fmt.Printf("Kilt branch %s is consistent\n", report.Branch)
+	} else {
+		for _, p := range report.Problems {
+			fmt.Printf("%s: %s: %s\n", p.Kind, p.Object, p.Message)
+		}
+	}
+	if len(report.Problems) > 0 {
+		return ErrInconsistent
+	}
+	return nil
+}
+
+// malformedProblems returns the problems for the malformed metadata that
+// aren't already reported for the same commit.
+func malformedProblems(malformed []*repo.MetadataError, reported []Problem) []Problem {
+	seen := map[string]bool{}
+	for _, p := range reported {
+		seen[p.Object] = true
+	}
+	var problems []Problem
+	for _, m := range malformed {
+		if !seen[m.Commit] {
+			problems = append(problems, Problem{Kind: MalformedMetadata, Object: m.Commit, Message: m.Problem})
+		}
+	}
+	return problems
+}
+
+// stackProblems returns the problems found in the commits of the branch,
+// given the highest version of each patchset recorded in its version refs.
+func stackProblems(entries []repo.StackEntry, recorded map[string]patchset.Version) []Problem {
+	var problems []Problem
+	var names []string
+	metadata := map[string]repo.StackEntry{}
+	for _, e := range entries {
+		if !e.Metadata {
+			continue
+		}
+		key := patchset.NameKey(e.Patchset)
+		if first, ok := metadata[key]; ok {
+			problems = append(problems, Problem{
+				Kind:    DuplicateMetadata,
+				Object:  e.ID,
+				Message: fmt.Sprintf("second metadata commit for patchset %q, the first is %s", e.Patchset, first.ID),
+			})
+			continue
+		}
+		metadata[key] = e
+		names = append(names, e.Patchset)
+	}
+	for _, e := range entries {
+		if e.Metadata {
+			continue
+		}
+		if e.Patchset == "" {
+			problems = append(problems, Problem{Kind: UnknownPatchset, Object: e.ID, Message: "patch has no Patchset-Name"})
+		} else if _, ok := metadata[patchset.NameKey(e.Patchset)]; !ok {
+			problems = append(problems, Problem{
+				Kind:    UnknownPatchset,
+				Object:  e.ID,
+				Message: fmt.Sprintf("patch belongs to patchset %q, which has no metadata commit", e.Patchset),
+			})
+		}
+	}
+	for _, name := range names {
+		e := metadata[patchset.NameKey(name)]
+		version, err := patchset.ParseVersion(e.Version)
+		if err != nil {
+			problems = append(problems, Problem{
+				Kind:    VersionRegression,
+				Object:  name,
+				Message: fmt.Sprintf("metadata commit %s has invalid version %q", e.ID, e.Version),
+			})
+			continue
+		}
+		if highest, ok := recorded[name]; ok && version.Cmp(highest) < 0 {
+			problems = append(problems, Problem{
+				Kind:    VersionRegression,
+				Object:  name,
+				Message: fmt.Sprintf("metadata commit %s has version %s, but version %s was recorded", e.ID, version, highest),
+			})
+		}
+	}
+	return problems
+}
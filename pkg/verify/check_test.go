@@ -0,0 +1,70 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+func TestStackProblems(t *testing.T) {
+	version := func(v string) patchset.Version {
+		version, err := patchset.ParseVersion(v)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q): %v", v, err)
+		}
+		return version
+	}
+	entries := []repo.StackEntry{
+		{ID: "m1", Metadata: true, Patchset: "a", Version: "2"},
+		{ID: "p1", Patchset: "a"},
+		{ID: "m2", Metadata: true, Patchset: "b", Version: "1"},
+		{ID: "p2", Patchset: "B"},
+		{ID: "m3", Metadata: true, Patchset: "A", Version: "3"},
+		{ID: "p3"},
+		{ID: "p4", Patchset: "c"},
+	}
+	recorded := map[string]patchset.Version{"a": version("2"), "b": version("4")}
+	want := []Problem{
+		{DuplicateMetadata, "m3", `second metadata commit for patchset "A", the first is m1`},
+		{UnknownPatchset, "p3", "patch has no Patchset-Name"},
+		{UnknownPatchset, "p4", `patch belongs to patchset "c", which has no metadata commit`},
+		{VersionRegression, "b", "metadata commit m2 has version 1, but version 4 was recorded"},
+	}
+	if diff := cmp.Diff(stackProblems(entries, recorded), want); diff != "" {
+		t.Errorf("stackProblems() returned diff (-got +want):\n%s", diff)
+	}
+	if got := stackProblems(entries[:2], recorded); len(got) != 0 {
+		t.Errorf("stackProblems() = %v, want no problems", got)
+	}
+}
+
+func TestMalformedProblems(t *testing.T) {
+	malformed := []*repo.MetadataError{
+		{Commit: "1111", Problem: "no Patchset-UUID field found"},
+		{Commit: "2222", Problem: `patchset "a" seen twice`},
+	}
+	reported := []Problem{{Kind: DuplicateMetadata, Object: "2222"}}
+	want := []Problem{{Kind: MalformedMetadata, Object: "1111", Message: "no Patchset-UUID field found"}}
+	if diff := cmp.Diff(malformedProblems(malformed, reported), want); diff != "" {
+		t.Errorf("malformedProblems() returned diff (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package verify implements consistency checks of kilt branches.
+package verify
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// ErrMismatch indicates that the compared kilt branches differ.
+var ErrMismatch = errors.New("kilt branches differ")
+
+type patchsetContent struct {
+	patchset *patchset.Patchset
+	patchIDs []string
+	patches  map[string]string
+}
+
+// Compare will compare the patchsets of the current kilt branch with those of
+// another kilt branch, and print the patchsets missing from either branch,
+// version mismatches and patchsets whose content has drifted.
+func Compare(other string) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	o, err := r.OpenBranch(other)
+	if err != nil {
+		return err
+	}
+	ours, ourNames, err := loadContent(r)
+	if err != nil {
+		return err
+	}
+	theirs, theirNames, err := loadContent(o)
+	if err != nil {
+		return err
+	}
+	branch := r.KiltBranch()
+	differ := false
+	for _, name := range ourNames {
+		c := ours[name]
+		t, ok := theirs[name]
+		if !ok {
+			fmt.Printf("Patchset %q only on %s\n", name, branch)
+			differ = true
+			continue
+		}
+		if c.patchset.Version().Cmp(t.patchset.Version()) != 0 {
+			fmt.Printf("Patchset %q version mismatch: %s on %s, %s on %s\n", name, c.patchset.Version(), branch, t.patchset.Version(), other)
+			differ = true
+		}
+		ourOnly, theirOnly := difference(c.patchIDs, t.patchIDs), difference(t.patchIDs, c.patchIDs)
+		if len(ourOnly) == 0 && len(theirOnly) == 0 {
+			if !equal(c.patchIDs, t.patchIDs) {
+				fmt.Printf("Patchset %q has its patches in a different order\n", name)
+				differ = true
+			}
+			continue
+		}
+		differ = true
+		fmt.Printf("Patchset %q content differs:\n", name)
+		if err := printPatches(r, branch, ourOnly, c.patches); err != nil {
+			return err
+		}
+		if err := printPatches(o, other, theirOnly, t.patches); err != nil {
+			return err
+		}
+	}
+	for _, name := range theirNames {
+		if _, ok := ours[name]; !ok {
+			fmt.Printf("Patchset %q only on %s\n", name, other)
+			differ = true
+		}
+	}
+	if differ {
+		return ErrMismatch
+	}
+	fmt.Printf("Kilt branches %s and %s match\n", branch, other)
+	return nil
+}
+
+// loadContent computes the patch ids of the patchsets in the repo, returning
+// them by name along with the names in branch order.
+func loadContent(r *repo.Repo) (map[string]*patchsetContent, []string, error) {
+	patchsets, err := r.Patchsets()
+	if err != nil {
+		return nil, nil, err
+	}
+	content := map[string]*patchsetContent{}
+	var names []string
+	for _, ps := range patchsets {
+		if ps.MetadataCommit() == "" {
+			continue
+		}
+		c := &patchsetContent{patchset: ps, patches: map[string]string{}}
+		patches := append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...)
+		for _, patch := range patches {
+			id, err := r.PatchID(patch)
+			if err != nil {
+				return nil, nil, err
+			}
+			c.patchIDs = append(c.patchIDs, id)
+			c.patches[id] = patch
+		}
+		content[ps.Name()] = c
+		names = append(names, ps.Name())
+	}
+	return content, names, nil
+}
+
+func printPatches(r *repo.Repo, branch string, ids []string, patches map[string]string) error {
+	for _, id := range ids {
+		desc, err := r.DescribeCommit(patches[id])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\tonly on %s: %s\n", branch, desc)
+	}
+	return nil
+}
+
+// difference returns the ids in a that are not in b, counting duplicates.
+func difference(a, b []string) []string {
+	count := map[string]int{}
+	for _, id := range b {
+		count[id]++
+	}
+	var diff []string
+	for _, id := range a {
+		if count[id] > 0 {
+			count[id]--
+			continue
+		}
+		diff = append(diff, id)
+	}
+	return diff
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
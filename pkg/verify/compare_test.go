@@ -0,0 +1,54 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDifference(t *testing.T) {
+	tests := []struct {
+		desc string
+		a, b []string
+		want []string
+	}{
+		{
+			desc: "Equal",
+			a:    []string{"1", "2"},
+			b:    []string{"2", "1"},
+		},
+		{
+			desc: "Missing",
+			a:    []string{"1", "2", "3"},
+			b:    []string{"2"},
+			want: []string{"1", "3"},
+		},
+		{
+			desc: "Duplicates",
+			a:    []string{"1", "1"},
+			b:    []string{"1"},
+			want: []string{"1"},
+		},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(difference(tt.a, tt.b), tt.want); diff != "" {
+			t.Errorf("%s: difference(%v, %v) returned diff (-got +want)\n%s", tt.desc, tt.a, tt.b, diff)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/kilt/pkg/patchset"
+	"github.com/google/kilt/pkg/repo"
+)
+
+// ErrSkew indicates that the patchsets of the compared kilt branches have
+// diverged.
+var ErrSkew = errors.New("patchset versions differ")
+
+// Kinds of skew between the patchsets of two kilt branches.
+const (
+	// Added patchsets are only on the current branch.
+	Added = "added"
+	// Removed patchsets are only on the other branch.
+	Removed = "removed"
+	// Ahead patchsets have a higher version on the current branch.
+	Ahead = "ahead"
+	// Behind patchsets have a higher version on the other branch.
+	Behind = "behind"
+	// Renamed patchsets have the same version but different names.
+	Renamed = "renamed"
+)
+
+// Skew is a difference of a patchset between two kilt branches.
+type Skew struct {
+	Kind string `json:"kind"`
+	UUID string `json:"uuid"`
+	// Name and Version are those on the current branch, and OtherName and
+	// OtherVersion those on the other branch, empty where the patchset is
+	// missing.
+	Name         string `json:"name,omitempty"`
+	Version      string `json:"version,omitempty"`
+	OtherName    string `json:"other_name,omitempty"`
+	OtherVersion string `json:"other_version,omitempty"`
+}
+
+// SkewReport is the result of comparing the patchset versions of two kilt
+// branches.
+type SkewReport struct {
+	Branch string `json:"branch"`
+	Other  string `json:"other"`
+	Skew   []Skew `json:"skew"`
+}
+
+// CompareVersions compares the patchsets of the current kilt branch with those
+// of another kilt branch, matching them by UUID so that renamed patchsets are
+// followed, and prints the patchsets added or removed and those whose versions
+// differ, as JSON if asJSON is set. Only patchsets with a metadata commit are
+// compared. ErrSkew is returned if any differ.
+func CompareVersions(other string, asJSON bool) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	o, err := r.OpenBranch(other)
+	if err != nil {
+		return err
+	}
+	ours, err := r.Patchsets()
+	if err != nil {
+		return err
+	}
+	theirs, err := o.Patchsets()
+	if err != nil {
+		return err
+	}
+	report := SkewReport{Branch: r.KiltBranch(), Other: other, Skew: versionSkew(ours, theirs)}
+	if asJSON {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else if len(report.Skew) == 0 {
+		fmt.Printf("Patchset versions of %s and %s match\n", report.Branch, other)
+	} else {
+		for _, s := range report.Skew {
+			fmt.Println(describeSkew(s, report.Branch, other))
+		}
+	}
+	if len(report.Skew) > 0 {
+		return ErrSkew
+	}
+	return nil
+}
+
+// versionSkew returns the differences between our patchsets and theirs,
+// matched by UUID, in the order of our branch followed by those only on
+// theirs.
+func versionSkew(ours, theirs []*patchset.Patchset) []Skew {
+	byUUID := map[string]*patchset.Patchset{}
+	for _, t := range theirs {
+		if t.MetadataCommit() != "" {
+			byUUID[t.UUID().String()] = t
+		}
+	}
+	var skew []Skew
+	matched := map[string]bool{}
+	for _, p := range ours {
+		if p.MetadataCommit() == "" {
+			continue
+		}
+		id := p.UUID().String()
+		s := Skew{UUID: id, Name: p.Name(), Version: p.Version().String()}
+		t, ok := byUUID[id]
+		if !ok {
+			s.Kind = Added
+			skew = append(skew, s)
+			continue
+		}
+		matched[id] = true
+		s.OtherName, s.OtherVersion = t.Name(), t.Version().String()
+		switch p.Version().Cmp(t.Version()) {
+		case 1:
+			s.Kind = Ahead
+		case -1:
+			s.Kind = Behind
+		default:
+			if p.Name() == t.Name() {
+				continue
+			}
+			s.Kind = Renamed
+		}
+		skew = append(skew, s)
+	}
+	for _, t := range theirs {
+		id := t.UUID().String()
+		if t.MetadataCommit() == "" || matched[id] {
+			continue
+		}
+		skew = append(skew, Skew{Kind: Removed, UUID: id, OtherName: t.Name(), OtherVersion: t.Version().String()})
+	}
+	return skew
+}
+
+// describeSkew describes the skew of a patchset between branch and other.
+func describeSkew(s Skew, branch, other string) string {
+	switch s.Kind {
+	case Added:
+		return fmt.Sprintf("Patchset %q v%s only on %s", s.Name, s.Version, branch)
+	case Removed:
+		return fmt.Sprintf("Patchset %q v%s only on %s", s.OtherName, s.OtherVersion, other)
+	case Renamed:
+		return fmt.Sprintf("Patchset %q v%s named %q on %s", s.Name, s.Version, s.OtherName, other)
+	}
+	desc := fmt.Sprintf("Patchset %q v%s on %s, v%s on %s", s.Name, s.Version, branch, s.OtherVersion, other)
+	if s.OtherName != s.Name {
+		desc += fmt.Sprintf(" as %q", s.OtherName)
+	}
+	return fmt.Sprintf("%s (%s)", desc, s.Kind)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/kilt/pkg/patchset"
+)
+
+func TestVersionSkew(t *testing.T) {
+	ps := func(name, uuid string, version int) *patchset.Patchset {
+		v := patchset.InitialVersion()
+		for i := 1; i < version; i++ {
+			v = v.Successor()
+		}
+		p := patchset.Load(name, uuid, v)
+		p.AddMetadataCommit("metadata-" + name)
+		return p
+	}
+	const (
+		uuidA = "6ba7b810-9dad-11d1-80b4-00c04fd430c1"
+		uuidB = "6ba7b810-9dad-11d1-80b4-00c04fd430c2"
+		uuidC = "6ba7b810-9dad-11d1-80b4-00c04fd430c3"
+		uuidD = "6ba7b810-9dad-11d1-80b4-00c04fd430c4"
+		uuidE = "6ba7b810-9dad-11d1-80b4-00c04fd430c5"
+	)
+	floating := patchset.New("unknown")
+	ours := []*patchset.Patchset{ps("a", uuidA, 2), ps("b", uuidB, 3), ps("c", uuidC, 1), ps("d", uuidD, 1), floating}
+	theirs := []*patchset.Patchset{ps("a", uuidA, 2), ps("b", uuidB, 4), ps("c2", uuidC, 1), ps("e", uuidE, 1)}
+	want := []Skew{
+		{Kind: Behind, UUID: uuidB, Name: "b", Version: "3", OtherName: "b", OtherVersion: "4"},
+		{Kind: Renamed, UUID: uuidC, Name: "c", Version: "1", OtherName: "c2", OtherVersion: "1"},
+		{Kind: Added, UUID: uuidD, Name: "d", Version: "1"},
+		{Kind: Removed, UUID: uuidE, OtherName: "e", OtherVersion: "1"},
+	}
+	if diff := cmp.Diff(versionSkew(ours, theirs), want); diff != "" {
+		t.Errorf("versionSkew() returned diff (-got +want):\n%s", diff)
+	}
+}
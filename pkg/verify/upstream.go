@@ -0,0 +1,136 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"fmt"
+
+	"github.com/google/kilt/pkg/repo"
+)
+
+// Upstream states of a patch.
+const (
+	upstreamMerged   = "merged"
+	upstreamModified = "modified"
+	upstreamPending  = "pending"
+)
+
+// upstreamCommit identifies a commit by its patch id and summary.
+type upstreamCommit struct {
+	id, patchID, summary string
+}
+
+// upstreamMatch is the upstream state of a patch, and the upstream commit it
+// matched, if any.
+type upstreamMatch struct {
+	patch    upstreamCommit
+	state    string
+	upstream upstreamCommit
+}
+
+// CompareUpstream will match the patches of the named patchset against the
+// commits on the upstream ref since the kilt base, and print which patches
+// have been merged unchanged, merged with modifications, or are still pending.
+// Patches are matched by patch id, or failing that by summary.
+func CompareUpstream(name, upstream string) error {
+	r, err := repo.Open()
+	if err != nil {
+		return err
+	}
+	patchsets, err := r.PatchsetMap()
+	if err != nil {
+		return err
+	}
+	ps, ok := patchsets[name]
+	if !ok {
+		return fmt.Errorf("patchset %q not found", name)
+	}
+	patches, err := loadCommits(r, append(append([]string{}, ps.Patches()...), ps.FloatingPatches()...))
+	if err != nil {
+		return err
+	}
+	ids, err := r.CommitsBetween(r.KiltBase(), upstream)
+	if err != nil {
+		return err
+	}
+	commits, err := loadCommits(r, ids)
+	if err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	for _, m := range matchUpstream(patches, commits) {
+		counts[m.state]++
+		desc := fmt.Sprintf("%.12s %s", m.patch.id, m.patch.summary)
+		switch m.state {
+		case upstreamMerged:
+			fmt.Printf("%s: merged as %.12s\n", desc, m.upstream.id)
+		case upstreamModified:
+			fmt.Printf("%s: modified upstream as %.12s\n", desc, m.upstream.id)
+		default:
+			fmt.Printf("%s: pending\n", desc)
+		}
+	}
+	fmt.Printf("Patchset %q on %s: %d merged, %d modified, %d pending\n", name, upstream, counts[upstreamMerged], counts[upstreamModified], counts[upstreamPending])
+	return nil
+}
+
+func loadCommits(r *repo.Repo, ids []string) ([]upstreamCommit, error) {
+	var commits []upstreamCommit
+	for _, id := range ids {
+		info, err := r.CommitInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		patchID, err := r.PatchID(id)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, upstreamCommit{id: id, patchID: patchID, summary: info.Summary})
+	}
+	return commits, nil
+}
+
+// matchUpstream matches each patch with an upstream commit, by patch id if
+// possible, otherwise by summary. Each upstream commit is matched at most once.
+func matchUpstream(patches, upstream []upstreamCommit) []upstreamMatch {
+	used := make([]bool, len(upstream))
+	find := func(same func(u upstreamCommit) bool) (upstreamCommit, bool) {
+		for i, u := range upstream {
+			if !used[i] && same(u) {
+				used[i] = true
+				return u, true
+			}
+		}
+		return upstreamCommit{}, false
+	}
+	matches := make([]upstreamMatch, len(patches))
+	for i, p := range patches {
+		matches[i] = upstreamMatch{patch: p, state: upstreamPending}
+		if u, ok := find(func(u upstreamCommit) bool { return u.patchID == p.patchID }); ok {
+			matches[i].state, matches[i].upstream = upstreamMerged, u
+		}
+	}
+	for i, p := range patches {
+		if matches[i].state != upstreamPending {
+			continue
+		}
+		if u, ok := find(func(u upstreamCommit) bool { return u.summary == p.summary }); ok {
+			matches[i].state, matches[i].upstream = upstreamModified, u
+		}
+	}
+	return matches
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatchUpstream(t *testing.T) {
+	patches := []upstreamCommit{
+		{id: "p1", patchID: "a", summary: "Add a"},
+		{id: "p2", patchID: "b", summary: "Add b"},
+		{id: "p3", patchID: "c", summary: "Add c"},
+	}
+	upstream := []upstreamCommit{
+		{id: "u1", patchID: "x", summary: "Add b"},
+		{id: "u2", patchID: "a", summary: "Add a, reworded"},
+	}
+	var got []string
+	for _, m := range matchUpstream(patches, upstream) {
+		got = append(got, m.patch.id+" "+m.state+" "+m.upstream.id)
+	}
+	want := []string{"p1 merged u2", "p2 modified u1", "p3 pending "}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("matchUpstream() returned diff (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package workspace runs kilt operations across the repos of a product
+// assembled from several kilt branches.
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/kilt/pkg/repo"
+	"github.com/google/kilt/pkg/rework"
+	"github.com/google/kilt/pkg/status"
+)
+
+// ConfigFile is the name of the workspace config file. It is looked up in the
+// current directory and its parents.
+const ConfigFile = "kilt-workspace.json"
+
+// Member is a repo of the workspace.
+type Member struct {
+	// Name identifies the repo in output and manifests.
+	Name string `json:"name"`
+	// Path is the work tree of the repo, relative to the config file.
+	Path string `json:"path"`
+	// Base is the revision that kilt ws build builds on.
+	Base string `json:"base,omitempty"`
+	// Patchsets are the patchsets that kilt ws build includes.
+	Patchsets []string `json:"patchsets,omitempty"`
+}
+
+// Workspace is a set of kilt managed repos.
+type Workspace struct {
+	Dir     string   `json:"-"`
+	Members []Member `json:"repos"`
+}
+
+// Result is the outcome of an operation on a member.
+type Result struct {
+	Member Member
+	Err    error
+}
+
+// Find loads the workspace config from the current directory or the nearest
+// parent directory containing one.
+func Find() (*Workspace, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		file := filepath.Join(dir, ConfigFile)
+		if _, err := os.Stat(file); err == nil {
+			return Load(file)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("no %s found", ConfigFile)
+		}
+		dir = parent
+	}
+}
+
+// Load reads the workspace config file.
+func Load(file string) (*Workspace, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	w, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	w.Dir = filepath.Dir(file)
+	return w, nil
+}
+
+func parse(b []byte) (*Workspace, error) {
+	w := &Workspace{}
+	if err := json.Unmarshal(b, w); err != nil {
+		return nil, err
+	}
+	if len(w.Members) == 0 {
+		return nil, errors.New("no repos listed")
+	}
+	seen := map[string]bool{}
+	for i, m := range w.Members {
+		if m.Path == "" {
+			return nil, fmt.Errorf("repo %d has no path", i+1)
+		}
+		if m.Name == "" {
+			w.Members[i].Name = filepath.Base(filepath.Clean(m.Path))
+		}
+		name := w.Members[i].Name
+		if seen[name] {
+			return nil, fmt.Errorf("repo %q listed twice", name)
+		}
+		seen[name] = true
+	}
+	return w, nil
+}
+
+// Path returns the absolute path of the work tree of the member.
+func (w *Workspace) Path(m Member) string {
+	if filepath.IsAbs(m.Path) {
+		return m.Path
+	}
+	return filepath.Join(w.Dir, m.Path)
+}
+
+// Each runs op in the work tree of every member in turn, carrying on after
+// failures. The current directory is restored afterwards.
+func (w *Workspace) Each(op func(m Member) error) ([]Result, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Chdir(wd)
+	var results []Result
+	for _, m := range w.Members {
+		err := os.Chdir(w.Path(m))
+		if err == nil {
+			err = op(m)
+		}
+		results = append(results, Result{Member: m, Err: err})
+	}
+	return results, nil
+}
+
+// Failed returns an error summarizing the failed results, or nil if all
+// succeeded.
+func Failed(results []Result) error {
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.Member.Name, r.Err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed in %d of %d repos: %s", len(failed), len(results), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Status prints a summary of the kilt branch of every member.
+func (w *Workspace) Status() ([]Result, error) {
+	return w.Each(func(m Member) error {
+		s, err := status.LoadState()
+		if err != nil {
+			fmt.Printf("%s: %v\n", m.Name, err)
+			return err
+		}
+		fmt.Println(summary(m, s))
+		return nil
+	})
+}
+
+func summary(m Member, s *status.State) string {
+	floating := 0
+	for _, ps := range s.Patchsets {
+		floating += len(ps.FloatingPatches)
+	}
+	text := fmt.Sprintf("%s: branch %s, %d patchsets", m.Name, s.Branch, len(s.Patchsets))
+	if floating > 0 {
+		text += fmt.Sprintf(", %d floating patches", floating)
+	}
+	if s.ReworkInProgress {
+		text += fmt.Sprintf(", rework in progress with %d operations remaining", len(s.Queue))
+	}
+	return text
+}
+
+// Build builds the configured patchsets on the configured base of every member.
+func (w *Workspace) Build() ([]Result, error) {
+	return w.Each(func(m Member) error {
+		if m.Base == "" || len(m.Patchsets) == 0 {
+			fmt.Printf("%s: no base or patchsets configured, skipping\n", m.Name)
+			return nil
+		}
+		fmt.Printf("%s: building %d patchsets on %s\n", m.Name, len(m.Patchsets), m.Base)
+		var targets []rework.TargetSelector
+		for _, p := range m.Patchsets {
+			targets = append(targets, rework.PatchsetTarget{Name: p})
+		}
+		c, err := rework.NewBeginBuildCommand(m.Base, targets...)
+		if err != nil {
+			fmt.Printf("%s: %v\n", m.Name, err)
+			return err
+		}
+		err = c.ExecuteAll()
+		if err != nil {
+			fmt.Printf("%s: %v\n", m.Name, err)
+		}
+		if serr := c.Save(); serr != nil {
+			return fmt.Errorf("failed to save rework state: %w", serr)
+		}
+		return err
+	})
+}
+
+// Manifest records the state of every member of a workspace.
+type Manifest struct {
+	Repos []RepoManifest `json:"repos"`
+}
+
+// RepoManifest records the state of a member.
+type RepoManifest struct {
+	Name      string                 `json:"name"`
+	Path      string                 `json:"path"`
+	Head      string                 `json:"head"`
+	Branch    string                 `json:"branch"`
+	Base      string                 `json:"base"`
+	Patchsets []status.PatchsetState `json:"patchsets"`
+	// Submodules records the submodules of the head, as the build may
+	// depend on their state.
+	Submodules []repo.SubmoduleState `json:"submodules,omitempty"`
+}
+
+// Manifest returns the combined manifest of all members.
+func (w *Workspace) Manifest() (*Manifest, []Result, error) {
+	manifest := &Manifest{Repos: []RepoManifest{}}
+	results, err := w.Each(func(m Member) error {
+		r, err := repo.Open()
+		if err != nil {
+			return err
+		}
+		head, err := r.ResolveCommit("HEAD")
+		if err != nil {
+			return err
+		}
+		s, err := status.LoadState()
+		if err != nil {
+			return err
+		}
+		submodules, err := r.Submodules()
+		if err != nil {
+			return err
+		}
+		manifest.Repos = append(manifest.Repos, RepoManifest{
+			Name:       m.Name,
+			Path:       m.Path,
+			Head:       head,
+			Branch:     s.Branch,
+			Base:       s.Base,
+			Patchsets:  s.Patchsets,
+			Submodules: submodules,
+		})
+		return nil
+	})
+	return manifest, results, err
+}
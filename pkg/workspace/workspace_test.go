@@ -0,0 +1,47 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workspace
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	w, err := parse([]byte(`{"repos": [{"path": "src/kernel/"}, {"name": "fw", "path": "firmware", "base": "v1", "patchsets": ["a"]}]}`))
+	if err != nil {
+		t.Fatalf("parse(): %v", err)
+	}
+	want := []Member{
+		{Name: "kernel", Path: "src/kernel/"},
+		{Name: "fw", Path: "firmware", Base: "v1", Patchsets: []string{"a"}},
+	}
+	if diff := cmp.Diff(w.Members, want); diff != "" {
+		t.Errorf("parse() returned diff (-got +want):\n%s", diff)
+	}
+	for _, bad := range []string{
+		`{"repos": []}`,
+		`{"repos": [{"name": "a"}]}`,
+		`{"repos": [{"path": "a"}, {"path": "b/a"}]}`,
+		`{"repos": `,
+	} {
+		if _, err := parse([]byte(bad)); err == nil {
+			t.Errorf("parse(%s): expected error", bad)
+		}
+	}
+}